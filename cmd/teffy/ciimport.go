@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/omaskery/teffy/pkg/io/ci"
+)
+
+func runCIImport(args []string) error {
+	fs := flag.NewFlagSet("ci-import", flag.ExitOnError)
+	output := fs.String("o", "-", "path to write the trace to")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "usage: teffy ci-import [flags] <timings file>")
+		_, _ = fmt.Fprintln(fs.Output(), "the timings are a JSON array of steps with a name, start, end and optionally a job and status,")
+		_, _ = fmt.Fprintln(fs.Output(), "or the jobs of a GitHub Actions workflow run as listed by its API")
+		fs.PrintDefaults()
+	}
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var r io.Reader = os.Stdin
+	if positional[0] != "-" {
+		f, err := os.Open(positional[0])
+		if err != nil {
+			return fmt.Errorf("failed to open timings file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	data, err := ci.Parse(r)
+	if err != nil {
+		return err
+	}
+	return writeTrace(*output, data, formatObject)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/ctf"
+	"github.com/omaskery/teffy/pkg/io/ftrace"
+	"github.com/omaskery/teffy/pkg/stats"
+)
+
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := fs.String("from", string(formatAuto), "format of the input trace: auto, array, object, lines (newline delimited JSON events), binary, chrome-proto, jaeger, fxt, perf (perf script output), ctf (babeltrace output), otlp (OpenTelemetry JSON), ftrace (Linux ftrace text), ninja (.ninja_log), gotest (go test -json output) or pprof (pprof profiles)")
+	to := fs.String("to", string(formatObject), "format of the output trace: array, object, lines (newline delimited JSON events), binary, chrome-proto, jaeger or parquet (for data tools such as DuckDB)")
+	compress := fs.Bool("gzip", false, "compress the output with gzip, implied by an output path ending in .gz")
+	strict := fs.Bool("strict", false, "adjust the trace to import cleanly into chrome://tracing, requires the object format")
+	legacyPhases := fs.Bool("legacy-phases", false, "write async and instant events using their deprecated phases, for older viewers")
+	systemTrace := fs.Bool("system-trace", false, "convert the Linux ftrace text in the trace's systemTraceEvents into events")
+	concurrency := fs.Duration("concurrency", 0, "plot how many threads and processes were busy over time as counters, averaged over buckets of this size, such as 100ms")
+	coalesce := fs.Duration("coalesce", 0, "merge runs of durations shorter than this on the same thread, such as 1ms, so that huge traces can be viewed")
+	indent := fs.Bool("indent", false, "write each event on lines of its own, indented by two spaces, so that traces diff cleanly")
+	sortKeys := fs.Bool("sort-keys", false, "write the keys of events and their args in sorted order")
+	output := fs.String("o", "-", "path to write the converted trace to")
+	var spans ctfSpans
+	fs.Var(&spans, "ctf-span", "pair ctf tracepoints into spans, given as name=begin-regex=end-regex (repeatable)")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "usage: teffy convert [flags] <trace file>")
+		_, _ = fmt.Fprintln(fs.Output(), "gzipped, binary, fxt and ninja log input is detected automatically")
+		fs.PrintDefaults()
+	}
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	fromFormat, err := parseTraceFormat(*from, true)
+	if err != nil {
+		return err
+	}
+	if fromFormat.writeOnly() {
+		return fmt.Errorf("the %s format can only be written", fromFormat)
+	}
+	toFormat, err := parseTraceFormat(*to, false)
+	if err != nil {
+		return err
+	}
+	if toFormat.readOnly() {
+		return fmt.Errorf("the %s format can only be read", toFormat)
+	}
+	if *strict && toFormat != formatObject {
+		return fmt.Errorf("strict output requires the object format, as stack traces are moved to its stack frames")
+	}
+
+	data, err := readTraceAs(positional[0], fromFormat, spans...)
+	if err != nil {
+		return err
+	}
+
+	if *systemTrace {
+		if err := ftrace.ConvertSystemTraceEvents(data); err != nil {
+			return err
+		}
+	}
+
+	if *concurrency > 0 {
+		bucket := concurrency.Microseconds()
+		stats.AppendConcurrency(data, stats.Compute(data).Concurrency(bucket), bucket)
+	}
+
+	if *coalesce > 0 {
+		data = tio.CoalesceDurations(data, coalesce.Microseconds())
+	}
+
+	if *strict {
+		data, err = tio.ChromeCompatible(*data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if toFormat == formatJaeger {
+		_, _ = fmt.Fprintln(os.Stderr, "warning: the jaeger format can only hold complete and instant events, other events and data in the trace are discarded")
+	} else if toFormat != formatObject && hasFileLevelData(data) {
+		_, _ = fmt.Fprintf(os.Stderr, "warning: the %s format can only hold events, other data in the trace is discarded\n", toFormat)
+	}
+
+	var writeOptions []tio.WriteOption
+	if *legacyPhases {
+		writeOptions = append(writeOptions, tio.WithLegacyPhases())
+	}
+	if *indent {
+		writeOptions = append(writeOptions, tio.WithIndent("  "))
+	}
+	if *sortKeys {
+		writeOptions = append(writeOptions, tio.WithSortedKeys())
+	}
+
+	return writeTraceAs(*output, data, toFormat, *compress || strings.HasSuffix(*output, ".gz"), writeOptions...)
+}
+
+// hasFileLevelData reports whether the trace holds anything beyond its events that only the object format can store
+func hasFileLevelData(data *tio.TefData) bool {
+	return len(data.StackFrames()) > 0 ||
+		len(data.Samples()) > 0 ||
+		len(data.OtherData()) > 0 ||
+		len(data.Metadata()) > 0 ||
+		data.SystemTraceEvents() != "" ||
+		data.PowerTraceAsString() != ""
+}
+
+// ctfSpans is a flag that may be given multiple times, each giving the name of a span and the regular expressions
+// matching the tracepoints that begin and end it, as name=begin-regex=end-regex
+type ctfSpans []ctf.Option
+
+func (s *ctfSpans) String() string {
+	return ""
+}
+
+func (s *ctfSpans) Set(value string) error {
+	parts := strings.SplitN(value, "=", 3)
+	if len(parts) != 3 {
+		return fmt.Errorf("expected name=begin-regex=end-regex")
+	}
+	begin, err := regexp.Compile(parts[1])
+	if err != nil {
+		return err
+	}
+	end, err := regexp.Compile(parts[2])
+	if err != nil {
+		return err
+	}
+	*s = append(*s, ctf.WithSpan(parts[0], begin, end))
+	return nil
+}
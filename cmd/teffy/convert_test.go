@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/pprof/profile"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+var _ = Describe("convert", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "teffy-convert")
+		Expect(err).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("reads pprof profiles", func() {
+		mainFn := &profile.Function{ID: 1, Name: "main", Filename: "main.go"}
+		workFn := &profile.Function{ID: 2, Name: "work", Filename: "work.go"}
+		mainLoc := &profile.Location{ID: 1, Line: []profile.Line{{Function: mainFn}}}
+		workLoc := &profile.Location{ID: 2, Line: []profile.Line{{Function: workFn}}}
+		p := &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+			Sample: []*profile.Sample{
+				{Location: []*profile.Location{workLoc, mainLoc}, Value: []int64{3000}},
+				{Location: []*profile.Location{mainLoc}, Value: []int64{1000}},
+			},
+			Location: []*profile.Location{mainLoc, workLoc},
+			Function: []*profile.Function{mainFn, workFn},
+		}
+		input := filepath.Join(dir, "cpu.pprof")
+		f, err := os.Create(input)
+		Expect(err).To(Succeed())
+		Expect(p.Write(f)).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+
+		output := filepath.Join(dir, "cpu.trace")
+		Expect(runConvert([]string{"--from", "pprof", "-o", output, input})).To(Succeed())
+
+		data, err := readTrace(output)
+		Expect(err).To(Succeed())
+		var names []string
+		for _, e := range data.Events() {
+			if c, ok := e.(*events.Complete); ok {
+				names = append(names, c.Name)
+			}
+		}
+		Expect(names).To(Equal([]string{"main", "work"}))
+	})
+
+	It("does not write pprof profiles", func() {
+		Expect(runConvert([]string{"--to", "pprof", "-o", filepath.Join(dir, "out"), "-"})).
+			To(MatchError(ContainSubstring("can only be read")))
+	})
+})
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/omaskery/teffy/pkg/stats"
+)
+
+// diffReport is the machine readable form of the output of the diff command
+type diffReport struct {
+	ByName     []diffEntry `json:"byName"`
+	ByCategory []diffEntry `json:"byCategory"`
+}
+
+type diffEntry struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	OldCount   int     `json:"oldCount"`
+	NewCount   int     `json:"newCount"`
+	CountDelta int     `json:"countDelta"`
+	OldTotal   int64   `json:"oldTotal"`
+	NewTotal   int64   `json:"newTotal"`
+	TotalDelta int64   `json:"totalDelta"`
+	OldMean    float64 `json:"oldMean"`
+	NewMean    float64 `json:"newMean"`
+	MeanDelta  float64 `json:"meanDelta"`
+}
+
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJson := fs.Bool("json", false, "write a machine readable JSON report instead of tables")
+	top := fs.Int("top", 10, "number of regressions and improvements to show, or -1 for all of them")
+	failOver := fs.Float64("fail-over", 0,
+		"fail if the total duration of any span name grows by more than this percentage, 0 to never fail")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "usage: teffy diff [flags] <old trace file> <new trace file>")
+		fs.PrintDefaults()
+	}
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	before, err := readTrace(positional[0])
+	if err != nil {
+		return fmt.Errorf("failed to read '%s': %w", positional[0], err)
+	}
+	after, err := readTrace(positional[1])
+	if err != nil {
+		return fmt.Errorf("failed to read '%s': %w", positional[1], err)
+	}
+
+	comparison := stats.Compare(stats.Compute(before), stats.Compute(after))
+
+	if *asJson {
+		report := diffReport{
+			ByName:     diffEntries(comparison.ByName),
+			ByCategory: diffEntries(comparison.ByCategory),
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	} else {
+		fmt.Printf("operations (us):\n")
+		printChanges(comparison.ByName, *top)
+		fmt.Printf("\ncategories (us):\n")
+		printChanges(comparison.ByCategory, *top)
+	}
+
+	if *failOver > 0 {
+		for _, c := range comparison.ByName {
+			if change := c.TotalChange() * 100; change > *failOver {
+				return fmt.Errorf("total duration of '%s' grew by %.1f%%, more than the allowed %.1f%%",
+					c.Name, change, *failOver)
+			}
+		}
+	}
+
+	return nil
+}
+
+func diffEntries(changes []stats.Change) []diffEntry {
+	entries := make([]diffEntry, 0, len(changes))
+	for _, c := range changes {
+		e := diffEntry{
+			Name:       c.Name,
+			Status:     changeStatus(c),
+			CountDelta: c.CountDelta,
+			TotalDelta: c.TotalDelta,
+			MeanDelta:  c.MeanDelta,
+		}
+		if c.Old != nil {
+			e.OldCount, e.OldTotal, e.OldMean = c.Old.Count, c.Old.Total, c.Old.Mean
+		}
+		if c.New != nil {
+			e.NewCount, e.NewTotal, e.NewMean = c.New.Count, c.New.Total, c.New.Mean
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func changeStatus(c stats.Change) string {
+	switch {
+	case c.Added():
+		return "new"
+	case c.Removed():
+		return "removed"
+	default:
+		return "changed"
+	}
+}
+
+// printChanges prints the greatest regressions followed by the greatest improvements, relying on the changes being
+// ordered by the greatest increase in total duration first
+func printChanges(changes []stats.Change, top int) {
+	var regressions, improvements []stats.Change
+	for _, c := range changes {
+		if c.TotalDelta > 0 && (top < 0 || len(regressions) < top) {
+			regressions = append(regressions, c)
+		}
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		if c := changes[i]; c.TotalDelta < 0 && (top < 0 || len(improvements) < top) {
+			improvements = append(improvements, c)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(w, "name\tstatus\tcount delta\told total\tnew total\ttotal delta\tchange\tmean delta\t")
+	for _, group := range [][]stats.Change{regressions, improvements} {
+		for _, c := range group {
+			e := diffEntries([]stats.Change{c})[0]
+			change := "-"
+			if !c.Added() && !c.Removed() {
+				change = fmt.Sprintf("%+.1f%%", c.TotalChange()*100)
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%+d\t%d\t%d\t%+d\t%s\t%+.1f\t\n",
+				e.Name, e.Status, e.CountDelta, e.OldTotal, e.NewTotal, e.TotalDelta, change, e.MeanDelta)
+		}
+	}
+	_ = w.Flush()
+}
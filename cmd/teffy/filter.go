@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/omaskery/teffy/pkg/filter"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/pipeline"
+)
+
+func runFilter(args []string) error {
+	fs := flag.NewFlagSet("filter", flag.ExitOnError)
+	var categories stringList
+	var pids, tids intList
+	fs.Var(&categories, "category", "keep events with this category (repeatable)")
+	fs.Var(&pids, "pid", "keep events from this process ID (repeatable)")
+	fs.Var(&tids, "tid", "keep events from this thread ID (repeatable)")
+	nameRegex := fs.String("name-regex", "", "keep events whose name matches this regular expression")
+	between := fs.String("between", "", "keep events between two times relative to the start of the trace, e.g. 10ms,20ms")
+	crop := fs.Bool("crop", false, "with --between, clip durations crossing the ends of the window to fit within it")
+	output := fs.String("o", "-", "path to write the filtered trace to")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "usage: teffy filter [flags] <trace file>")
+		_, _ = fmt.Fprintln(fs.Output(), "the filtered trace is written in the format of the input trace")
+		fs.PrintDefaults()
+	}
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	data, format, err := readTraceFormat(positional[0], formatAuto)
+	if err != nil {
+		return err
+	}
+
+	var predicates []filter.Predicate
+	if len(categories) > 0 {
+		predicates = append(predicates, filter.ByCategory(categories...))
+	}
+	if len(pids) > 0 {
+		predicates = append(predicates, filter.ByProcessID(pids...))
+	}
+	if len(tids) > 0 {
+		predicates = append(predicates, filter.ByThreadID(tids...))
+	}
+	if *nameRegex != "" {
+		re, err := regexp.Compile(*nameRegex)
+		if err != nil {
+			return fmt.Errorf("invalid name regex: %w", err)
+		}
+		predicates = append(predicates, filter.ByName(re))
+	}
+	if *between != "" {
+		start, end, err := parseWindow(*between)
+		if err != nil {
+			return fmt.Errorf("invalid time window: %w", err)
+		}
+		origin := filter.StartTime(data)
+		if *crop {
+			data = tio.Crop(data, origin+start, origin+end)
+		} else {
+			predicates = append(predicates, filter.Between(origin+start, origin+end))
+		}
+	}
+
+	return writeTrace(*output, pipeline.Apply(data, pipeline.Filter(filter.All(predicates...))), format)
+}
+
+// parseWindow parses a pair of comma separated durations into a start and end in microseconds
+func parseWindow(s string) (int64, int64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected a start and end separated by a comma: '%s'", s)
+	}
+	start, err := time.ParseDuration(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("end of window is before its start: '%s'", s)
+	}
+	return start.Microseconds(), end.Microseconds(), nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+)
+
+// parseFlags parses the flags in args, allowing them to be interleaved with positional arguments (which the flag
+// package does not), and returns the positional arguments
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) < 1 {
+			return positional, nil
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// stringList is a flag that may be given multiple times, or given a comma separated list of values
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, strings.Split(value, ",")...)
+	return nil
+}
+
+// intList is a flag that may be given multiple times, or given a comma separated list of integers
+type intList []int64
+
+func (l *intList) String() string {
+	parts := make([]string, 0, len(*l))
+	for _, i := range *l {
+		parts = append(parts, strconv.FormatInt(i, 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *intList) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		i, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return err
+		}
+		*l = append(*l, i)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/omaskery/teffy/pkg/flamegraph"
+)
+
+func runFlamegraph(args []string) (err error) {
+	fs := flag.NewFlagSet("flamegraph", flag.ExitOnError)
+	mergeThreads := fs.Bool("merge-threads", false, "fold the spans of all threads together")
+	output := fs.String("o", "-", "path to write the folded stacks to")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "usage: teffy flamegraph [flags] <trace file>")
+		fs.PrintDefaults()
+	}
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	data, err := readTrace(positional[0])
+	if err != nil {
+		return err
+	}
+
+	var options []flamegraph.Option
+	if *mergeThreads {
+		options = append(options, flamegraph.WithMergedThreads())
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer func() {
+			if closeErr := f.Close(); err == nil && closeErr != nil {
+				err = fmt.Errorf("failed to close output file: %w", closeErr)
+			}
+		}()
+		w = f
+	}
+
+	return flamegraph.Write(w, data, options...)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"unicode"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/ctf"
+	"github.com/omaskery/teffy/pkg/io/ftrace"
+	"github.com/omaskery/teffy/pkg/io/fxt"
+	"github.com/omaskery/teffy/pkg/io/gotest"
+	"github.com/omaskery/teffy/pkg/io/jaeger"
+	"github.com/omaskery/teffy/pkg/io/native"
+	"github.com/omaskery/teffy/pkg/io/ninja"
+	"github.com/omaskery/teffy/pkg/io/otlp"
+	"github.com/omaskery/teffy/pkg/io/perf"
+	"github.com/omaskery/teffy/pkg/io/perfetto"
+	"github.com/omaskery/teffy/pkg/io/pprof"
+)
+
+// traceFormat identifies one of the JSON formats of Trace Event Format files, newline delimited JSON events, teffy's
+// binary encoding of events, Chrome's legacy protobuf encoding of events, Jaeger's JSON format, Parquet, or one of the
+// formats of other tools that can be read
+type traceFormat string
+
+const (
+	formatAuto   traceFormat = "auto"
+	formatArray  traceFormat = "array"
+	formatObject traceFormat = "object"
+	formatLines  traceFormat = "lines"
+	formatBinary traceFormat = "binary"
+	formatChrome traceFormat = "chrome-proto"
+	// formatJaeger is the JSON format that Jaeger's UI downloads, which holds only complete and instant events
+	formatJaeger traceFormat = "jaeger"
+	// formatFxt is Fuchsia's trace format, which can only be read
+	formatFxt traceFormat = "fxt"
+	// formatPerf is the text output of `perf script`, which can only be read
+	formatPerf traceFormat = "perf"
+	// formatCtf is babeltrace's text form of Common Trace Format traces, which can only be read
+	formatCtf traceFormat = "ctf"
+	// formatOtlp is OpenTelemetry's OTLP JSON trace export, which can only be read
+	formatOtlp traceFormat = "otlp"
+	// formatFtrace is the text output of Linux's ftrace, which can only be read
+	formatFtrace traceFormat = "ftrace"
+	// formatNinja is ninja's build log, .ninja_log, which can only be read
+	formatNinja traceFormat = "ninja"
+	// formatGoTest is the output of `go test -json`, which can only be read
+	formatGoTest traceFormat = "gotest"
+	// formatPprof is a pprof profile, such as a CPU profile, laid out as a flame chart, which can only be read
+	formatPprof traceFormat = "pprof"
+	// formatParquet is a Parquet file of the events for querying with data tools, which can only be written
+	formatParquet traceFormat = "parquet"
+)
+
+// readOnly reports whether traces can only be read in the format, not written
+func (f traceFormat) readOnly() bool {
+	return f == formatFxt || f == formatPerf || f == formatCtf || f == formatOtlp || f == formatFtrace ||
+		f == formatNinja || f == formatGoTest || f == formatPprof
+}
+
+// writeOnly reports whether traces can only be written in the format, not read
+func (f traceFormat) writeOnly() bool {
+	return f == formatParquet
+}
+
+func parseTraceFormat(s string, allowAuto bool) (traceFormat, error) {
+	switch f := traceFormat(s); f {
+	case formatArray, formatObject, formatLines, formatBinary, formatChrome, formatJaeger, formatFxt, formatPerf, formatCtf, formatOtlp, formatFtrace, formatNinja, formatGoTest, formatPprof, formatParquet:
+		return f, nil
+	case formatAuto:
+		if allowAuto {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown trace format '%s'", s)
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// sniffLength is how much of the start of a file is enough to recognise the formats that can be detected
+const sniffLength = 16
+
+// readTrace parses the trace file at the given path, or stdin if the path is "-", detecting whether it is gzipped
+// and whether it is in the JSON Array Format, the JSON Object Format, the binary encoding, Fuchsia's trace format or
+// ninja's build log
+func readTrace(path string) (*tio.TefData, error) {
+	data, _, err := readTraceFormat(path, formatAuto)
+	return data, err
+}
+
+// readTraceAs parses the trace file at the given path, or stdin if the path is "-", in the given format, detecting
+// whether it is gzipped. The CTF options configure how tracepoints are converted when reading the ctf format.
+func readTraceAs(path string, format traceFormat, ctfOptions ...ctf.Option) (*tio.TefData, error) {
+	data, _, err := readTraceFormat(path, format, ctfOptions...)
+	return data, err
+}
+
+// readTraceFormat parses the trace file as readTraceAs does, also returning the format it was read in, which is the
+// detected format when the given format is auto
+func readTraceFormat(path string, format traceFormat, ctfOptions ...ctf.Option) (*tio.TefData, traceFormat, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to open trace file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(gzipMagic))
+	if err == nil && bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decompress trace file: %w", err)
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	if format == formatAuto {
+		start, _ := br.Peek(sniffLength)
+		if native.IsBinaryTrace(start) {
+			format = formatBinary
+		} else if fxt.IsFxtTrace(start) {
+			format = formatFxt
+		} else if ninja.IsNinjaLog(start) {
+			format = formatNinja
+		}
+	}
+	if format == formatAuto {
+		isArray, err := isJsonArray(br)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read trace file: %w", err)
+		}
+		format = formatObject
+		if isArray {
+			format = formatArray
+		}
+	}
+
+	var data *tio.TefData
+	switch format {
+	case formatArray:
+		data, err = tio.ParseJsonArray(br)
+	case formatLines:
+		data, err = tio.ParseJsonLines(br)
+	case formatBinary:
+		data, err = native.Parse(br)
+	case formatChrome:
+		data, err = perfetto.ParseChromeEvents(br)
+	case formatJaeger:
+		data, err = jaeger.Parse(br)
+	case formatFxt:
+		data, err = fxt.Parse(br)
+	case formatPerf:
+		data, err = perf.Parse(br)
+	case formatCtf:
+		data, err = ctf.Parse(br, ctfOptions...)
+	case formatOtlp:
+		data, err = otlp.Parse(br)
+	case formatFtrace:
+		data, err = ftrace.Parse(br)
+	case formatNinja:
+		data, err = ninja.Parse(br)
+	case formatGoTest:
+		data, err = gotest.Parse(br)
+	case formatPprof:
+		data, err = pprof.Parse(br)
+	default:
+		data, err = tio.ParseJsonObj(br, tio.WithLenientDisplayTimeUnit(func(err error) {
+			_, _ = fmt.Fprintf(os.Stderr, "warning: displaying the trace in milliseconds: %v\n", err)
+		}), tio.WithWarnings(func(err error) {
+			_, _ = fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}))
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse trace file: %w", err)
+	}
+	// stack frames streamed by a Tracer as metadata events belong with the trace's other stack frames
+	data.CollectStackFrames()
+	return data, format, nil
+}
+
+// isJsonArray peeks at the first non-whitespace character of the reader to see if it starts a JSON array
+func isJsonArray(br *bufio.Reader) (bool, error) {
+	for {
+		r, _, err := br.ReadRune()
+		if err != nil {
+			return false, err
+		}
+		if !unicode.IsSpace(r) {
+			return r == '[', br.UnreadRune()
+		}
+	}
+}
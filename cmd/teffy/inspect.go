@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/filter"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// inspectFlags are the flags shared by the commands that print some of the events of a trace
+type inspectFlags struct {
+	fs     *flag.FlagSet
+	count  *int
+	format *string
+}
+
+func newInspectFlags(name, usage string, defaultCount int) *inspectFlags {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	f := &inspectFlags{
+		fs:     fs,
+		count:  fs.Int("n", defaultCount, "number of events to print, or -1 for all of them"),
+		format: fs.String("format", "table", "how to print the events: table, or json (a JSON Array Format trace)"),
+	}
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "usage: teffy %s [flags] <trace file>\n", usage)
+		fs.PrintDefaults()
+	}
+	return f
+}
+
+// parse parses the flags and reads the trace named by the positional argument
+func (f *inspectFlags) parse(args []string) (*tio.TefData, error) {
+	positional, err := parseFlags(f.fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) != 1 {
+		f.fs.Usage()
+		os.Exit(2)
+	}
+	if *f.format != "table" && *f.format != "json" {
+		return nil, fmt.Errorf("unknown event format '%s'", *f.format)
+	}
+	return readTrace(positional[0])
+}
+
+// limit returns how many of the given number of events to print
+func (f *inspectFlags) limit(available int) int {
+	if *f.count < 0 || *f.count > available {
+		return available
+	}
+	return *f.count
+}
+
+func (f *inspectFlags) print(evs []events.Event) error {
+	if *f.format == "json" {
+		return writeEventsJson(os.Stdout, evs)
+	}
+	return writeEventsTable(os.Stdout, evs)
+}
+
+func runHead(args []string) error {
+	f := newInspectFlags("head", "head [-n count]", 10)
+	data, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	evs := data.Events()
+	return f.print(evs[:f.limit(len(evs))])
+}
+
+func runTail(args []string) error {
+	f := newInspectFlags("tail", "tail [-n count]", 10)
+	data, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	evs := data.Events()
+	return f.print(evs[len(evs)-f.limit(len(evs)):])
+}
+
+func runSample(args []string) error {
+	f := newInspectFlags("sample", "sample [-n count]", 10)
+	seed := f.fs.Int64("seed", 0, "seed for choosing the events, so that the same events are chosen again (random if 0)")
+	data, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	evs := data.Events()
+	chosen := rand.New(rand.NewSource(*seed)).Perm(len(evs))[:f.limit(len(evs))]
+	// the events chosen are printed in the order they appear in the trace
+	sort.Ints(chosen)
+	sampled := make([]events.Event, 0, len(chosen))
+	for _, i := range chosen {
+		sampled = append(sampled, evs[i])
+	}
+	return f.print(sampled)
+}
+
+func runGrep(args []string) error {
+	f := newInspectFlags("grep", "grep [--name-regex regex] [--category category]", -1)
+	nameRegex := f.fs.String("name-regex", "", "print events whose name matches this regular expression")
+	var categories stringList
+	f.fs.Var(&categories, "category", "print events with this category (repeatable)")
+	data, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+
+	// the filters keep all metadata events, to keep the names of processes and threads, but here they are only printed
+	// when they match in their own right
+	var predicates []filter.Predicate
+	if *nameRegex != "" {
+		re, err := regexp.Compile(*nameRegex)
+		if err != nil {
+			return fmt.Errorf("invalid name regex: %w", err)
+		}
+		predicates = append(predicates, func(e events.Event) bool { return re.MatchString(e.Core().Name) })
+	}
+	if len(categories) > 0 {
+		notMetadata := func(e events.Event) bool { return e.Phase() != events.PhaseMetadata }
+		predicates = append(predicates, notMetadata, filter.ByCategory(categories...))
+	}
+	matches := filter.All(predicates...)
+
+	var found []events.Event
+	for _, e := range data.Events() {
+		if *f.count >= 0 && len(found) >= *f.count {
+			break
+		}
+		if matches(e) {
+			found = append(found, e)
+		}
+	}
+	return f.print(found)
+}
+
+// writeEventsJson writes the events as an indented JSON Array Format trace, one event per line or more
+func writeEventsJson(w io.Writer, evs []events.Event) error {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i, e := range evs {
+		encoded, err := events.MarshalEvent(e)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n  ")
+		if err := json.Indent(&buf, encoded, "  ", "  "); err != nil {
+			return fmt.Errorf("failed to indent event: %w", err)
+		}
+	}
+	buf.WriteString("\n]\n")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// writeEventsTable writes the events as a table of their commonest fields, with their args as compact JSON
+func writeEventsTable(w io.Writer, evs []events.Event) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ph\tts\tdur\tpid\ttid\tname\tcat\targs")
+	for _, e := range evs {
+		encoded, err := events.MarshalEvent(e)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(encoded, &fields); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		field := func(key string) string {
+			raw := fields[key]
+			var s string
+			if string(raw) == "null" {
+				return ""
+			} else if err := json.Unmarshal(raw, &s); err == nil {
+				return s
+			}
+			return string(raw)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", field("ph"), field("ts"), field("dur"),
+			field("pid"), field("tid"), field("name"), field("cat"), field("args"))
+	}
+	return tw.Flush()
+}
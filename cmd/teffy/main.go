@@ -0,0 +1,108 @@
+// teffy is a command line tool for inspecting and manipulating Trace Event Format files
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"ci-import": {
+		description: "convert the timings of the jobs and steps of a CI pipeline into a trace",
+		run:         runCIImport,
+	},
+	"convert": {
+		description: "convert a trace between the array and object formats, optionally gzipped",
+		run:         runConvert,
+	},
+	"diff": {
+		description: "compare the spans of two traces, reporting duration regressions",
+		run:         runDiff,
+	},
+	"filter": {
+		description: "write a trace containing only the events matching some filters",
+		run:         runFilter,
+	},
+	"flamegraph": {
+		description: "fold the spans of a trace into stacks for flame graph tools",
+		run:         runFlamegraph,
+	},
+	"grep": {
+		description: "print the events of a trace whose names or categories match",
+		run:         runGrep,
+	},
+	"head": {
+		description: "print the first events of a trace",
+		run:         runHead,
+	},
+	"merge": {
+		description: "combine several traces into one",
+		run:         runMerge,
+	},
+	"sample": {
+		description: "print events chosen at random from a trace",
+		run:         runSample,
+	},
+	"scrub": {
+		description: "redact or hash sensitive names, args and file paths in a trace",
+		run:         runScrub,
+	},
+	"stats": {
+		description: "report statistics about the events in a trace",
+		run:         runStats,
+	},
+	"tail": {
+		description: "print the last events of a trace",
+		run:         runTail,
+	},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		_, _ = fmt.Fprintf(os.Stderr, "unknown command '%s'\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		abortWithErr(fmt.Sprintf("%s failed", os.Args[1]), err)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	_, _ = fmt.Fprintln(os.Stderr, "usage: teffy <command> [arguments]")
+	_, _ = fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		_, _ = fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].description)
+	}
+}
+
+func abortWithErr(reason string, err error) {
+	abort(fmt.Sprintf("%s: %v\n", reason, err))
+}
+
+func abort(reason string) {
+	_, err := os.Stderr.WriteString(reason)
+	if err != nil {
+		panic(fmt.Sprintf("failed while writing error to terminal: %v", err))
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/merge"
+)
+
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	var shifts stringList
+	fs.Var(&shifts, "shift-ts", "durations to shift the timestamps of each input by, in input order, e.g. 0,1.5ms")
+	remapPids := fs.Bool("remap-pids", false, "give processes new IDs when their ID is already used by an earlier input")
+	clockSync := fs.Bool("clock-sync", false, "align inputs using the clock sync events they share with one another")
+	framePolicy := fs.String("stack-frames", "rename",
+		"what becomes of stack frames whose IDs are used by an earlier input: rename, keep-first, keep-last or error")
+	output := fs.String("o", "-", "path to write the merged trace to")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "usage: teffy merge [flags] <trace file> <trace file>...")
+		_, _ = fmt.Fprintln(fs.Output(), "the merged trace is written in the format of the first input trace")
+		fs.PrintDefaults()
+	}
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	policy, err := tio.ParseStackFramePolicy(*framePolicy)
+	if err != nil {
+		return err
+	}
+	if len(shifts) > len(positional) {
+		return fmt.Errorf("more timestamp shifts given (%d) than inputs (%d)", len(shifts), len(positional))
+	}
+
+	// the merged trace is written in the format of the first input
+	var format traceFormat
+	sources := make([]merge.Source, len(positional))
+	for i, path := range positional {
+		data, inputFormat, err := readTraceFormat(path, formatAuto)
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %w", path, err)
+		}
+		if i == 0 {
+			format = inputFormat
+		}
+		sources[i].Data = data
+		if i < len(shifts) {
+			shift, err := time.ParseDuration(shifts[i])
+			if err != nil {
+				return fmt.Errorf("invalid timestamp shift for '%s': %w", path, err)
+			}
+			sources[i].TimestampShift = shift.Microseconds()
+		}
+	}
+
+	options := []merge.Option{merge.WithStackFramePolicy(policy)}
+	if *remapPids {
+		options = append(options, merge.WithProcessIDRemapping())
+	}
+	if *clockSync {
+		options = append(options, merge.WithClockSyncAlignment())
+	}
+
+	merged, err := merge.Merge(sources, options...)
+	if err != nil {
+		return err
+	}
+	return writeTrace(*output, merged, format)
+}
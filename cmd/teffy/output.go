@@ -0,0 +1,72 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/jaeger"
+	"github.com/omaskery/teffy/pkg/io/native"
+	"github.com/omaskery/teffy/pkg/io/parquet"
+	"github.com/omaskery/teffy/pkg/io/perfetto"
+)
+
+// writeTrace writes the trace in the given format to the file at the given path, or stdout if the path is "-". Traces
+// are written in the JSON Object Format instead when they cannot be written in the given format.
+func writeTrace(path string, data *tio.TefData, format traceFormat) error {
+	if format.readOnly() {
+		format = formatObject
+	}
+	return writeTraceAs(path, data, format, false)
+}
+
+// writeTraceAs writes the trace in the given format to the file at the given path, or stdout if the path is "-",
+// optionally compressing it with gzip
+func writeTraceAs(path string, data *tio.TefData, format traceFormat, compress bool, options ...tio.WriteOption) (err error) {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer func() {
+			if closeErr := f.Close(); err == nil && closeErr != nil {
+				err = fmt.Errorf("failed to close output file: %w", closeErr)
+			}
+		}()
+		w = f
+	}
+
+	if compress {
+		gz := gzip.NewWriter(w)
+		defer func() {
+			if closeErr := gz.Close(); err == nil && closeErr != nil {
+				err = fmt.Errorf("failed to compress trace: %w", closeErr)
+			}
+		}()
+		w = gz
+	}
+
+	switch format {
+	case formatArray:
+		err = tio.WriteJsonArray(w, data.Events(), options...)
+	case formatLines:
+		err = tio.WriteJsonLines(w, data.Events(), options...)
+	case formatBinary:
+		err = native.Write(w, data.Events())
+	case formatChrome:
+		err = perfetto.WriteChromeEvents(w, *data)
+	case formatJaeger:
+		err = jaeger.Write(w, *data)
+	case formatParquet:
+		err = parquet.Write(w, data.Events())
+	default:
+		err = tio.WriteJsonObject(w, *data, options...)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/omaskery/teffy/pkg/scrub"
+)
+
+func runScrub(args []string) error {
+	fs := flag.NewFlagSet("scrub", flag.ExitOnError)
+	var nameRegexes, argKeys stringList
+	fs.Var(&nameRegexes, "name-regex", "scrub event, process and thread names matching this regular expression (repeatable)")
+	fs.Var(&argKeys, "arg", "scrub the values of args with this key (repeatable)")
+	stackFiles := fs.Bool("stack-files", false, "scrub the file paths of stack frames")
+	salt := fs.String("hash-salt", "", "replace scrubbed values with a hash salted with this secret, rather than redacting them")
+	output := fs.String("o", "-", "path to write the scrubbed trace to")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "usage: teffy scrub [flags] <trace file>")
+		_, _ = fmt.Fprintln(fs.Output(), "the scrubbed trace is written in the format of the input trace")
+		fs.PrintDefaults()
+	}
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var options []scrub.Option
+	for _, pattern := range nameRegexes {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid name regex: %w", err)
+		}
+		options = append(options, scrub.WithNames(re))
+	}
+	if len(argKeys) > 0 {
+		options = append(options, scrub.WithArgKeys(argKeys...))
+	}
+	if *stackFiles {
+		options = append(options, scrub.WithStackFrameFiles())
+	}
+	if *salt != "" {
+		options = append(options, scrub.WithHashing(*salt))
+	}
+
+	data, format, err := readTraceFormat(positional[0], formatAuto)
+	if err != nil {
+		return err
+	}
+
+	scrub.New(options...).Trace(data)
+
+	return writeTrace(*output, data, format)
+}
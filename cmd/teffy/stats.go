@@ -0,0 +1,209 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/omaskery/teffy/pkg/stats"
+)
+
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	top := fs.Int("top", 10, "number of entries to show in each ranking, or -1 for all of them")
+	format := fs.String("format", "table", "how to print the statistics: table, json or csv (a block per ranking, separated by blank lines)")
+	utilization := fs.Bool("utilization", false, "report how busy each thread was and the longest gaps it sat idle for, instead of the usual statistics")
+	minGap := fs.Duration("min-gap", 0, "the shortest idle gap to report with --utilization, such as 100ms")
+	concurrency := fs.Duration("concurrency", 0, "report how many threads and processes were busy over time in buckets of this size, such as 100ms, instead of the usual statistics")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "usage: teffy stats [flags] <trace file>")
+		fs.PrintDefaults()
+	}
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *format != "table" && *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown stats format '%s'", *format)
+	}
+
+	data, err := readTrace(positional[0])
+	if err != nil {
+		return err
+	}
+
+	s := stats.Compute(data)
+	if *concurrency > 0 {
+		samples := s.Concurrency(concurrency.Microseconds())
+		switch *format {
+		case "json":
+			return writeConcurrencyJson(os.Stdout, samples)
+		case "csv":
+			return stats.WriteConcurrencyCsv(os.Stdout, samples)
+		}
+		printConcurrency(samples)
+		return nil
+	}
+	if *utilization {
+		report := utilizationReportOf(data, s, minGap.Microseconds(), *top)
+		switch *format {
+		case "json":
+			return writeUtilizationJson(os.Stdout, report)
+		case "csv":
+			return writeUtilizationCsv(os.Stdout, report)
+		}
+		printUtilization(report)
+		return nil
+	}
+
+	rankings := rankStats(s, *top)
+	switch *format {
+	case "json":
+		return writeStatsJson(os.Stdout, rankings)
+	case "csv":
+		return writeStatsCsv(os.Stdout, rankings)
+	}
+
+	fmt.Printf("display time unit: %s\n", data.DisplayTimeUnit())
+
+	if data.ControllerTraceDataKey() != "" {
+		fmt.Printf("controller trace data key: %s\n", data.ControllerTraceDataKey())
+	}
+
+	if data.SystemTraceEvents() != "" {
+		fmt.Println("system trace events are present")
+	}
+
+	if data.PowerTraceAsString() != "" {
+		fmt.Println("power trace information is present")
+	}
+
+	stackTraceCount := 0
+	for _, frame := range data.StackFrames() {
+		if frame.Parent == "" {
+			stackTraceCount += 1
+		}
+	}
+	fmt.Printf("contains %v stack frames (~%v stack traces)\n", len(data.StackFrames()), stackTraceCount)
+	if len(data.Samples()) > 0 {
+		fmt.Printf("contains %v samples\n", len(data.Samples()))
+	}
+
+	for key := range data.Metadata() {
+		fmt.Printf("contains metadata '%s'\n", key)
+	}
+
+	fmt.Printf("ingested %v trace events\n", s.EventCount)
+
+	fmt.Printf("\nslowest operations by total duration (us):\n")
+	printAggregates(rankings.Slowest)
+	fmt.Printf("\nslowest categories by total duration (us):\n")
+	printAggregates(rankings.SlowestCategories)
+	fmt.Printf("\nlongest spans (us):\n")
+	printSpans(rankings.Longest)
+	fmt.Printf("\nbusiest threads (us):\n")
+	printBusiestThreads(rankings.BusiestThreads)
+	if len(rankings.Counters) > 0 {
+		fmt.Printf("\ncounters:\n")
+		printCounters(rankings.Counters)
+	}
+
+	fmt.Printf("\nthread coverage:\n")
+	printThreads(s.Threads)
+
+	return nil
+}
+
+func printAggregates(aggregates []stats.NamedAggregate) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(w, "name\tcount\ttotal\tself\tmean\tp50\tp95\tp99\t")
+	for _, a := range aggregates {
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.1f\t%d\t%d\t%d\t\n",
+			a.Name, a.Count, a.Total, a.Self, a.Mean, a.P50, a.P95, a.P99)
+	}
+	_ = w.Flush()
+}
+
+func printThreads(threads map[stats.ThreadKey]*stats.ThreadCoverage) {
+	keys := make([]stats.ThreadKey, 0, len(threads))
+	for key := range threads {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ProcessID != keys[j].ProcessID {
+			return keys[i].ProcessID < keys[j].ProcessID
+		}
+		return keys[i].ThreadID < keys[j].ThreadID
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(w, "pid\ttid\tstart\tend\tbusy\tcoverage\t")
+	for _, key := range keys {
+		t := threads[key]
+		_, _ = fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%.1f%%\t\n",
+			key.ProcessID, key.ThreadID, t.Start, t.End, t.Busy, t.Coverage*100)
+	}
+	_ = w.Flush()
+}
+
+func printSpans(spans []stats.Span) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(w, "name\tpid\ttid\tstart\tduration\t")
+	for _, sp := range spans {
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t\n", sp.Name, sp.ProcessID, sp.ThreadID, sp.Start, sp.Duration)
+	}
+	_ = w.Flush()
+}
+
+func printBusiestThreads(threads []stats.NamedThread) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(w, "pid\ttid\tbusy\tcoverage\t")
+	for _, t := range threads {
+		_, _ = fmt.Fprintf(w, "%d\t%d\t%d\t%.1f%%\t\n", t.ProcessID, t.ThreadID, t.Busy, t.Coverage*100)
+	}
+	_ = w.Flush()
+}
+
+func printCounters(counters []stats.NamedCounter) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(w, "name\tseries\tcount\tmin\tmax\t")
+	for _, c := range counters {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%g\t%g\t\n", c.Name, c.Series, c.Count, c.Min, c.Max)
+	}
+	_ = w.Flush()
+}
+
+func printUtilization(r utilizationReport) {
+	fmt.Printf("thread utilization (us):\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(w, "pid\ttid\tname\tbusy\tidle\tutilization\tgaps\tlongest gap\t")
+	for _, t := range r.Threads {
+		_, _ = fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%d\t%.1f%%\t%d\t%d\t\n",
+			t.ProcessID, t.ThreadID, r.names[t.ThreadKey], t.Busy, t.Idle, t.Coverage*100, len(t.Gaps), longestGap(t))
+	}
+	_ = w.Flush()
+
+	fmt.Printf("\nlongest idle gaps (us):\n")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(w, "pid\ttid\tname\tstart\tduration\t")
+	for _, g := range r.LongestGaps {
+		_, _ = fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%d\t\n", g.ProcessID, g.ThreadID, r.names[g.ThreadKey], g.Start, g.Duration)
+	}
+	_ = w.Flush()
+}
+
+func printConcurrency(samples []stats.ConcurrencySample) {
+	fmt.Printf("busy threads and processes over time (us):\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(w, "start\tthreads\tprocesses\t")
+	for _, sample := range samples {
+		_, _ = fmt.Fprintf(w, "%d\t%.2f\t%.2f\t\n", sample.Start, sample.Threads, sample.Processes)
+	}
+	_ = w.Flush()
+}
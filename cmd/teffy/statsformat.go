@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/stats"
+)
+
+// statsRankings are the rankings that the stats command prints, all durations being in microseconds
+type statsRankings struct {
+	EventCount        int
+	Slowest           []stats.NamedAggregate
+	SlowestCategories []stats.NamedAggregate
+	Longest           []stats.Span
+	BusiestThreads    []stats.NamedThread
+	Counters          []stats.NamedCounter
+}
+
+func rankStats(s *stats.Stats, top int) statsRankings {
+	return statsRankings{
+		EventCount:        s.EventCount,
+		Slowest:           s.Slowest(top),
+		SlowestCategories: s.SlowestCategories(top),
+		Longest:           s.Longest(top),
+		BusiestThreads:    s.BusiestThreads(top),
+		Counters:          s.SortedCounters(),
+	}
+}
+
+type jsonAggregate struct {
+	Name  string  `json:"name"`
+	Count int     `json:"count"`
+	Total int64   `json:"total"`
+	Self  int64   `json:"self"`
+	Min   int64   `json:"min"`
+	Max   int64   `json:"max"`
+	Mean  float64 `json:"mean"`
+	P50   int64   `json:"p50"`
+	P95   int64   `json:"p95"`
+	P99   int64   `json:"p99"`
+}
+
+type jsonSpan struct {
+	Name       string   `json:"name"`
+	Categories []string `json:"categories,omitempty"`
+	ProcessID  int64    `json:"pid"`
+	ThreadID   int64    `json:"tid"`
+	Start      int64    `json:"start"`
+	Duration   int64    `json:"duration"`
+}
+
+type jsonThread struct {
+	ProcessID int64   `json:"pid"`
+	ThreadID  int64   `json:"tid"`
+	Start     int64   `json:"start"`
+	End       int64   `json:"end"`
+	Busy      int64   `json:"busy"`
+	Coverage  float64 `json:"coverage"`
+}
+
+type jsonCounter struct {
+	Name   string  `json:"name"`
+	Series string  `json:"series"`
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+func writeStatsJson(w io.Writer, r statsRankings) error {
+	aggregates := func(named []stats.NamedAggregate) []jsonAggregate {
+		result := make([]jsonAggregate, 0, len(named))
+		for _, a := range named {
+			result = append(result, jsonAggregate{
+				Name: a.Name, Count: a.Count, Total: a.Total, Self: a.Self, Min: a.Min, Max: a.Max,
+				Mean: a.Mean, P50: a.P50, P95: a.P95, P99: a.P99,
+			})
+		}
+		return result
+	}
+	doc := struct {
+		EventCount        int             `json:"eventCount"`
+		Slowest           []jsonAggregate `json:"slowest"`
+		SlowestCategories []jsonAggregate `json:"slowestCategories"`
+		Longest           []jsonSpan      `json:"longest"`
+		BusiestThreads    []jsonThread    `json:"busiestThreads"`
+		Counters          []jsonCounter   `json:"counters"`
+	}{
+		EventCount:        r.EventCount,
+		Slowest:           aggregates(r.Slowest),
+		SlowestCategories: aggregates(r.SlowestCategories),
+		Longest:           make([]jsonSpan, 0, len(r.Longest)),
+		BusiestThreads:    make([]jsonThread, 0, len(r.BusiestThreads)),
+		Counters:          make([]jsonCounter, 0, len(r.Counters)),
+	}
+	for _, sp := range r.Longest {
+		doc.Longest = append(doc.Longest, jsonSpan{
+			Name: sp.Name, Categories: sp.Categories, ProcessID: sp.ProcessID, ThreadID: sp.ThreadID,
+			Start: sp.Start, Duration: sp.Duration,
+		})
+	}
+	for _, t := range r.BusiestThreads {
+		doc.BusiestThreads = append(doc.BusiestThreads, jsonThread{
+			ProcessID: t.ProcessID, ThreadID: t.ThreadID, Start: t.Start, End: t.End, Busy: t.Busy, Coverage: t.Coverage,
+		})
+	}
+	for _, c := range r.Counters {
+		doc.Counters = append(doc.Counters, jsonCounter{Name: c.Name, Series: c.Series, Count: c.Count, Min: c.Min, Max: c.Max})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to write stats: %w", err)
+	}
+	return nil
+}
+
+// writeStatsCsv writes each ranking as a block of CSV with its own header row, the first column of every row naming
+// the ranking so that the rows can be told apart once the blocks, which are separated by blank lines, are combined
+func writeStatsCsv(w io.Writer, r statsRankings) error {
+	i := strconv.FormatInt
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+
+	var blocks [][][]string
+	aggregates := func(section string, named []stats.NamedAggregate) {
+		rows := [][]string{{"section", "name", "count", "total", "self", "min", "max", "mean", "p50", "p95", "p99"}}
+		for _, a := range named {
+			rows = append(rows, []string{section, a.Name, strconv.Itoa(a.Count), i(a.Total, 10), i(a.Self, 10),
+				i(a.Min, 10), i(a.Max, 10), f(a.Mean), i(a.P50, 10), i(a.P95, 10), i(a.P99, 10)})
+		}
+		blocks = append(blocks, rows)
+	}
+	aggregates("slowest", r.Slowest)
+	aggregates("slowest categories", r.SlowestCategories)
+
+	spans := [][]string{{"section", "name", "categories", "pid", "tid", "start", "duration"}}
+	for _, sp := range r.Longest {
+		spans = append(spans, []string{"longest", sp.Name, strings.Join(sp.Categories, ","), i(sp.ProcessID, 10),
+			i(sp.ThreadID, 10), i(sp.Start, 10), i(sp.Duration, 10)})
+	}
+	blocks = append(blocks, spans)
+
+	threads := [][]string{{"section", "pid", "tid", "start", "end", "busy", "coverage"}}
+	for _, t := range r.BusiestThreads {
+		threads = append(threads, []string{"busiest threads", i(t.ProcessID, 10), i(t.ThreadID, 10), i(t.Start, 10),
+			i(t.End, 10), i(t.Busy, 10), f(t.Coverage)})
+	}
+	blocks = append(blocks, threads)
+
+	counters := [][]string{{"section", "name", "series", "count", "min", "max"}}
+	for _, c := range r.Counters {
+		counters = append(counters, []string{"counters", c.Name, c.Series, strconv.Itoa(c.Count), f(c.Min), f(c.Max)})
+	}
+	blocks = append(blocks, counters)
+
+	for n, block := range blocks {
+		if n > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return fmt.Errorf("failed to write stats: %w", err)
+			}
+		}
+		cw := csv.NewWriter(w)
+		if err := cw.WriteAll(block); err != nil {
+			return fmt.Errorf("failed to write stats: %w", err)
+		}
+	}
+	return nil
+}
+
+// utilizationReport is the report that the stats command prints with --utilization, all durations being in
+// microseconds
+type utilizationReport struct {
+	Threads     []stats.ThreadUtilization
+	LongestGaps []stats.Gap
+	// names holds the names that metadata gives threads
+	names map[stats.ThreadKey]string
+}
+
+func utilizationReportOf(data *tio.TefData, s *stats.Stats, minGap int64, top int) utilizationReport {
+	r := utilizationReport{
+		Threads:     s.Utilization(minGap),
+		LongestGaps: s.LongestGaps(minGap, top),
+		names:       map[stats.ThreadKey]string{},
+	}
+	for _, t := range r.Threads {
+		if name, ok := data.ThreadName(t.ProcessID, t.ThreadID); ok {
+			r.names[t.ThreadKey] = name
+		}
+	}
+	return r
+}
+
+// longestGap returns the duration of the longest of the thread's gaps, or zero if it has none
+func longestGap(t stats.ThreadUtilization) int64 {
+	var longest int64
+	for _, g := range t.Gaps {
+		if g.Duration > longest {
+			longest = g.Duration
+		}
+	}
+	return longest
+}
+
+type jsonGap struct {
+	ProcessID int64  `json:"pid"`
+	ThreadID  int64  `json:"tid"`
+	Name      string `json:"name,omitempty"`
+	Start     int64  `json:"start"`
+	Duration  int64  `json:"duration"`
+}
+
+type jsonUtilization struct {
+	ProcessID   int64     `json:"pid"`
+	ThreadID    int64     `json:"tid"`
+	Name        string    `json:"name,omitempty"`
+	Start       int64     `json:"start"`
+	End         int64     `json:"end"`
+	Busy        int64     `json:"busy"`
+	Idle        int64     `json:"idle"`
+	Utilization float64   `json:"utilization"`
+	Gaps        []jsonGap `json:"gaps"`
+}
+
+func writeUtilizationJson(w io.Writer, r utilizationReport) error {
+	gaps := func(gs []stats.Gap) []jsonGap {
+		result := make([]jsonGap, 0, len(gs))
+		for _, g := range gs {
+			result = append(result, jsonGap{
+				ProcessID: g.ProcessID, ThreadID: g.ThreadID, Name: r.names[g.ThreadKey], Start: g.Start, Duration: g.Duration,
+			})
+		}
+		return result
+	}
+	doc := struct {
+		Threads     []jsonUtilization `json:"threads"`
+		LongestGaps []jsonGap         `json:"longestGaps"`
+	}{
+		Threads:     make([]jsonUtilization, 0, len(r.Threads)),
+		LongestGaps: gaps(r.LongestGaps),
+	}
+	for _, t := range r.Threads {
+		doc.Threads = append(doc.Threads, jsonUtilization{
+			ProcessID: t.ProcessID, ThreadID: t.ThreadID, Name: r.names[t.ThreadKey], Start: t.Start, End: t.End,
+			Busy: t.Busy, Idle: t.Idle, Utilization: t.Coverage, Gaps: gaps(t.Gaps),
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to write utilization: %w", err)
+	}
+	return nil
+}
+
+// writeUtilizationCsv writes the utilization of the threads and the longest gaps as two blocks of CSV, in the manner
+// of writeStatsCsv
+func writeUtilizationCsv(w io.Writer, r utilizationReport) error {
+	i := strconv.FormatInt
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+
+	threads := [][]string{{"section", "pid", "tid", "name", "start", "end", "busy", "idle", "utilization", "gaps",
+		"longest gap"}}
+	for _, t := range r.Threads {
+		threads = append(threads, []string{"threads", i(t.ProcessID, 10), i(t.ThreadID, 10), r.names[t.ThreadKey],
+			i(t.Start, 10), i(t.End, 10), i(t.Busy, 10), i(t.Idle, 10), f(t.Coverage), strconv.Itoa(len(t.Gaps)),
+			i(longestGap(t), 10)})
+	}
+	gaps := [][]string{{"section", "pid", "tid", "name", "start", "duration"}}
+	for _, g := range r.LongestGaps {
+		gaps = append(gaps, []string{"longest gaps", i(g.ProcessID, 10), i(g.ThreadID, 10), r.names[g.ThreadKey],
+			i(g.Start, 10), i(g.Duration, 10)})
+	}
+
+	for n, block := range [][][]string{threads, gaps} {
+		if n > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return fmt.Errorf("failed to write utilization: %w", err)
+			}
+		}
+		cw := csv.NewWriter(w)
+		if err := cw.WriteAll(block); err != nil {
+			return fmt.Errorf("failed to write utilization: %w", err)
+		}
+	}
+	return nil
+}
+
+type jsonConcurrency struct {
+	Start     int64   `json:"start"`
+	Threads   float64 `json:"threads"`
+	Processes float64 `json:"processes"`
+}
+
+func writeConcurrencyJson(w io.Writer, samples []stats.ConcurrencySample) error {
+	doc := make([]jsonConcurrency, 0, len(samples))
+	for _, sample := range samples {
+		doc = append(doc, jsonConcurrency{Start: sample.Start, Threads: sample.Threads, Processes: sample.Processes})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to write concurrency: %w", err)
+	}
+	return nil
+}
@@ -1,9 +1,22 @@
 module github.com/omaskery/teffy
 
-go 1.15
+go 1.24.0
 
 require (
 	github.com/go-logr/logr v0.2.0
+	github.com/google/pprof v0.0.0-20250403155104-27863c87afa6
 	github.com/onsi/ginkgo v1.14.0
 	github.com/onsi/gomega v1.10.1
+	golang.org/x/exp v0.0.0-20251023183803-a4bb9ffd2546
+)
+
+require (
+	github.com/fsnotify/fsnotify v1.4.9 // indirect
+	github.com/nxadm/tail v1.4.4 // indirect
+	golang.org/x/net v0.46.0 // indirect
+	golang.org/x/sys v0.37.0 // indirect
+	golang.org/x/text v0.30.0 // indirect
+	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
+	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
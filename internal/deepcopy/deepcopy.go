@@ -0,0 +1,64 @@
+// Package deepcopy copies values along with everything they point to, so that the copy shares nothing with the
+// original. It is intended for the plain data of events and traces, and does not handle cyclic values.
+package deepcopy
+
+import "reflect"
+
+// Of returns a deep copy of the value
+func Of(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return Value(reflect.ValueOf(v)).Interface()
+}
+
+// Value returns a deep copy of the value. Unexported fields of structs are copied shallowly, as reflection cannot set
+// them.
+func Value(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.New(v.Type().Elem())
+		copied.Elem().Set(Value(v.Elem()))
+		return copied
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(Value(v.Elem()))
+		return copied
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(Value(v.Index(i)))
+		}
+		return copied
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), Value(iter.Value()))
+		}
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if field := copied.Field(i); field.CanSet() {
+				field.Set(Value(v.Field(i)))
+			}
+		}
+		return copied
+	default:
+		return v
+	}
+}
@@ -0,0 +1,240 @@
+// Package jsonfields provides helpers for working with JSON objects one field at a time, so that an object can be
+// decoded in a single pass and any fields that are not understood can be preserved when it is written back out.
+package jsonfields
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrNotObject means that a JSON value was expected to be an object but was not
+var ErrNotObject = errors.New("expected a JSON object")
+
+// Split splits a raw JSON object into its fields, this is the only time the object as a whole is scanned. The
+// raw object must already be known to be valid JSON, as it is when it has been decoded as a json.RawMessage, so that
+// the fields can be found without validating them again.
+func Split(raw []byte) (map[string]json.RawMessage, error) {
+	i := skipSpace(raw, 0)
+	if i >= len(raw) || raw[i] != '{' {
+		return nil, ErrNotObject
+	}
+	i = skipSpace(raw, i+1)
+
+	fields := make(map[string]json.RawMessage, 8)
+	for i < len(raw) && raw[i] != '}' {
+		keyEnd := skipValue(raw, i)
+		key, ok := simpleString(raw[i:keyEnd])
+		if !ok {
+			if err := json.Unmarshal(raw[i:keyEnd], &key); err != nil {
+				return nil, err
+			}
+		}
+
+		// skip the colon between the key and the value
+		i = skipSpace(raw, keyEnd)
+		i = skipSpace(raw, i+1)
+
+		valueEnd := skipValue(raw, i)
+		fields[key] = raw[i:valueEnd:valueEnd]
+
+		// skip any comma before the next key
+		i = skipSpace(raw, valueEnd)
+		if i < len(raw) && raw[i] == ',' {
+			i = skipSpace(raw, i+1)
+		}
+	}
+
+	return fields, nil
+}
+
+func skipSpace(raw []byte, i int) int {
+	for i < len(raw) && (raw[i] == ' ' || raw[i] == '\t' || raw[i] == '\r' || raw[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// skipValue finds the end of the valid JSON value starting at i
+func skipValue(raw []byte, i int) int {
+	depth := 0
+	inString := false
+	for ; i < len(raw); i++ {
+		c := raw[i]
+		if inString {
+			switch c {
+			case '\\':
+				i++
+			case '"':
+				inString = false
+				if depth == 0 {
+					return i + 1
+				}
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth == 0 {
+				return i
+			}
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		case ',', ' ', '\t', '\r', '\n', ':':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return i
+}
+
+// Decode decodes the fields of an object into the provided JSON struct, decoding each field directly
+// into the struct field it belongs to, and capturing any fields that the struct does not know about in extra so that
+// they can be preserved when the object is written back out
+func Decode(fields map[string]json.RawMessage, j interface{}, extra *map[string]json.RawMessage) error {
+	v := reflect.ValueOf(j).Elem()
+	index := Index(v.Type())
+	for key, value := range fields {
+		path, ok := index[key]
+		if !ok {
+			if *extra == nil {
+				*extra = map[string]json.RawMessage{}
+			}
+			(*extra)[key] = value
+			continue
+		}
+		if err := decodeValue(value, v.FieldByIndex(path).Addr().Interface()); err != nil {
+			return fmt.Errorf("failed to decode field '%s': %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// decodeValue decodes a single JSON value into the target, taking shortcuts for the simple values that make up
+// most fields of most objects and falling back to encoding/json for everything else
+func decodeValue(raw json.RawMessage, target interface{}) error {
+	switch t := target.(type) {
+	case *string:
+		if s, ok := simpleString(raw); ok {
+			*t = s
+			return nil
+		}
+	case *int64:
+		if i, err := strconv.ParseInt(string(raw), 10, 64); err == nil {
+			*t = i
+			return nil
+		}
+	case **int64:
+		if i, err := strconv.ParseInt(string(raw), 10, 64); err == nil {
+			*t = &i
+			return nil
+		}
+	case json.Unmarshaler:
+		// the raw value was already checked to be valid JSON when it was split from its object
+		return t.UnmarshalJSON(raw)
+	}
+	return json.Unmarshal(raw, target)
+}
+
+// simpleString decodes a JSON string that contains no escape sequences, which most strings do not
+func simpleString(raw json.RawMessage) (string, bool) {
+	if len(raw) < 2 || raw[0] != '"' || raw[len(raw)-1] != '"' {
+		return "", false
+	}
+	content := raw[1 : len(raw)-1]
+	for _, c := range content {
+		if c == '\\' || c == '"' || c < 0x20 || c >= 0x80 {
+			return "", false
+		}
+	}
+	return string(content), true
+}
+
+var indexCache sync.Map
+
+// Index maps the JSON keys that the given JSON struct type will (un)marshal to the index of their field,
+// descending into embedded structs
+func Index(t reflect.Type) map[string][]int {
+	if cached, ok := indexCache.Load(t); ok {
+		return cached.(map[string][]int)
+	}
+
+	index := map[string][]int{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			for key, path := range Index(field.Type) {
+				index[key] = append([]int{i}, path...)
+			}
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		index[name] = []int{i}
+	}
+
+	indexCache.Store(t, index)
+	return index
+}
+
+// AppendExtra appends the given extra fields to an already serialised JSON object without decoding it again,
+// in order of their keys. Keys that the struct the object was serialised from may write take precedence over any
+// extra field with the same key, even if the struct omitted them.
+func AppendExtra(msg json.RawMessage, extra map[string]json.RawMessage, known map[string][]int) (json.RawMessage, error) {
+	keys := make([]string, 0, len(extra))
+	for key := range extra {
+		if _, ok := known[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) < 1 {
+		return msg, nil
+	}
+	sort.Strings(keys)
+
+	trimmed := bytes.TrimRight(msg, " \t\r\n")
+	if len(trimmed) < 2 || trimmed[len(trimmed)-1] != '}' {
+		return nil, fmt.Errorf("expected a serialised JSON object")
+	}
+	var buffer bytes.Buffer
+	buffer.Grow(len(msg) + 32*len(keys))
+	buffer.Write(trimmed[:len(trimmed)-1])
+	empty := len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) < 1
+	for _, key := range keys {
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		if !empty {
+			buffer.WriteByte(',')
+		}
+		empty = false
+		buffer.Write(encodedKey)
+		buffer.WriteByte(':')
+		buffer.Write(extra[key])
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,37 @@
+// Package lanes arranges intervals that may overlap without nesting, such as the spans of distributed traces, onto as
+// few lanes as possible, such that the intervals of each lane nest within each other as duration events on a thread
+// must.
+package lanes
+
+// Interval is a period of time in microseconds
+type Interval struct {
+	Start, End int64
+}
+
+// Assign gives the lane of each of the intervals, counting from zero. The intervals must be sorted by their start,
+// with longer intervals first amongst those starting together, so that enclosing intervals are placed first.
+func Assign(intervals []Interval) []int {
+	result := make([]int, len(intervals))
+	// each lane is the stack of intervals that are still open at the start of the latest interval placed on it
+	var stacks [][]Interval
+	for i, interval := range intervals {
+		placed := false
+		for lane, stack := range stacks {
+			for len(stack) > 0 && stack[len(stack)-1].End <= interval.Start {
+				stack = stack[:len(stack)-1]
+			}
+			stacks[lane] = stack
+			if len(stack) == 0 || stack[len(stack)-1].End >= interval.End {
+				stacks[lane] = append(stack, interval)
+				result[i] = lane
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			stacks = append(stacks, []Interval{interval})
+			result[i] = len(stacks) - 1
+		}
+	}
+	return result
+}
@@ -0,0 +1,71 @@
+// Package stackframes interns the frames of inline stack traces, giving each distinct frame, identified by its contents
+// and the frame that called it, a single ID in a trace's stack frames so that stack traces can refer to their most
+// recently called frame by ID rather than being held inline.
+package stackframes
+
+import (
+	"strconv"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+type key struct {
+	parent   string
+	category string
+	name     string
+}
+
+// Interner assigns IDs to the frames of stack traces, reusing the IDs of identical frames
+type Interner struct {
+	ids   map[key]string
+	taken map[string]struct{}
+	next  int
+}
+
+// New creates an Interner that reuses the existing stack frames, which may be nil, and never gives out their IDs to
+// other frames
+func New(existing map[string]*events.StackFrame) *Interner {
+	in := &Interner{
+		ids:   map[key]string{},
+		taken: make(map[string]struct{}, len(existing)),
+	}
+	for id, frame := range existing {
+		in.ids[key{parent: frame.Parent, category: frame.Category, name: frame.Name}] = id
+		in.taken[id] = struct{}{}
+	}
+	return in
+}
+
+// AddFunc is given each frame the first time it is interned, along with the ID it was given
+type AddFunc = func(id string, frame *events.StackFrame) error
+
+// Intern returns the ID of the most recently called frame of the stack trace, calling add with each frame not seen
+// before, from the outermost caller inwards. If add fails the frame is not interned and its error is returned.
+func (in *Interner) Intern(trace *events.StackTrace, add AddFunc) (string, error) {
+	parent := ""
+	for _, frame := range trace.Trace {
+		k := key{parent: parent, category: frame.Category, name: frame.Name}
+		id, ok := in.ids[k]
+		if !ok {
+			id = in.newId()
+			interned := &events.StackFrame{Category: frame.Category, Name: frame.Name, Parent: parent}
+			if err := add(id, interned); err != nil {
+				return "", err
+			}
+			in.ids[k] = id
+			in.taken[id] = struct{}{}
+		}
+		parent = id
+	}
+	return parent, nil
+}
+
+func (in *Interner) newId() string {
+	for {
+		in.next++
+		id := "s" + strconv.Itoa(in.next)
+		if _, taken := in.taken[id]; !taken {
+			return id
+		}
+	}
+}
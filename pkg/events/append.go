@@ -0,0 +1,135 @@
+package events
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// AppendEvent appends the encoding of the event, as MarshalEvent would encode it, to the buffer and returns the
+// extended buffer. Durations, complete events and instants without args, inline stack traces or extra fields, the
+// events emitted most often when tracing, are encoded without allocating so that a writer reusing its buffer can write
+// them for free, other events are marshalled as usual.
+func AppendEvent(buf []byte, e Event) ([]byte, error) {
+	if extended, ok := appendPlainEvent(buf, e); ok {
+		return extended, nil
+	}
+	msg, err := marshalJsonEvent(e)
+	if err != nil {
+		return buf, err
+	}
+	return append(buf, msg...), nil
+}
+
+// appendPlainEvent appends the event if it is of a kind encoded without allocating, reporting whether it was
+func appendPlainEvent(buf []byte, e Event) ([]byte, bool) {
+	if core := e.Core(); len(core.Extra) > 0 || core.ProcessRef != nil || core.ThreadRef != nil || hasTypedArgs(e) {
+		return buf, false
+	}
+
+	switch event := e.(type) {
+	case *BeginDuration:
+		if len(event.Args) > 0 || event.StackTrace != nil {
+			return buf, false
+		}
+		buf = appendEventCore(buf, e)
+		buf = appendStringField(buf, "sf", event.StackFrameID)
+	case *EndDuration:
+		if len(event.Args) > 0 || event.StackTrace != nil {
+			return buf, false
+		}
+		buf = appendEventCore(buf, e)
+		buf = appendStringField(buf, "sf", event.StackFrameID)
+	case *Complete:
+		if len(event.Args) > 0 || event.StackTrace != nil || event.EndStackTrace != nil {
+			return buf, false
+		}
+		buf = appendEventCore(buf, e)
+		buf = appendStringField(buf, "sf", event.StackFrameID)
+		if event.Duration != 0 || event.DurationFraction != 0 {
+			buf = append(buf, `,"dur":`...)
+			buf = appendMicros(buf, event.Duration, event.DurationFraction)
+		}
+		buf = appendIntField(buf, "tdur", event.ThreadDuration)
+		buf = appendStringField(buf, "esf", event.EndStackFrameID)
+	case *Instant:
+		// events with invalid scopes are left to MarshalEvent to report
+		if len(event.Args) > 0 || event.StackTrace != nil || !event.Scope.Valid() {
+			return buf, false
+		}
+		buf = appendEventCore(buf, e)
+		buf = appendStringField(buf, "sf", event.StackFrameID)
+		buf = appendStringField(buf, "s", string(event.Scope))
+	default:
+		return buf, false
+	}
+	return append(buf, '}'), true
+}
+
+// hasTypedArgs reports whether the event has typed args, which are encoded by MarshalEvent
+func hasTypedArgs(e Event) bool {
+	getter, ok := e.(TypedArgGetter)
+	return ok && getter.GetTypedArgs() != nil
+}
+
+// appendEventCore appends the opening brace and the fields every event has, in the order they are marshalled, leaving
+// the caller to append the fields of its event type and the closing brace
+func appendEventCore(buf []byte, e Event) []byte {
+	core := e.Core()
+	buf = append(buf, `{"ph":"`...)
+	buf = appendStringContents(buf, string(e.Phase()))
+	buf = append(buf, `","name":"`...)
+	buf = appendStringContents(buf, core.Name)
+	buf = append(buf, '"')
+	if len(core.Categories) > 0 {
+		buf = append(buf, `,"cat":"`...)
+		for i, category := range core.Categories {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendStringContents(buf, category)
+		}
+		buf = append(buf, '"')
+	}
+	buf = append(buf, `,"ts":`...)
+	buf = appendMicros(buf, core.Timestamp, core.TimestampFraction)
+	buf = appendIntField(buf, "tts", core.ThreadTimestamp)
+	buf = appendIntField(buf, "pid", core.ProcessID)
+	buf = appendIntField(buf, "tid", core.ThreadID)
+	return appendStringField(buf, "cname", core.Color)
+}
+
+// appendIntField appends the field if the value is present, as an omitempty pointer field is marshalled
+func appendIntField(buf []byte, name string, value *int64) []byte {
+	if value == nil {
+		return buf
+	}
+	buf = append(buf, ',', '"')
+	buf = append(buf, name...)
+	buf = append(buf, '"', ':')
+	return strconv.AppendInt(buf, *value, 10)
+}
+
+// appendStringField appends the field if the value is not empty, as an omitempty string field is marshalled
+func appendStringField(buf []byte, name string, value string) []byte {
+	if value == "" {
+		return buf
+	}
+	buf = append(buf, ',', '"')
+	buf = append(buf, name...)
+	buf = append(buf, `":"`...)
+	buf = appendStringContents(buf, value)
+	return append(buf, '"')
+}
+
+// appendStringContents appends the string as it appears between the quotes of a JSON string. Strings of printable
+// ASCII needing no escaping are copied as they are, anything else is escaped by encoding/json so that the encoding
+// always matches that of MarshalEvent.
+func appendStringContents(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= 0x7f || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			quoted, _ := json.Marshal(s)
+			return append(buf, quoted[1:len(quoted)-1]...)
+		}
+	}
+	return append(buf, s...)
+}
@@ -0,0 +1,54 @@
+package events_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+var _ = Describe("Appending events", func() {
+	pid := int64(1)
+	tid := int64(2)
+	tts := int64(3)
+	core := events.EventCore{Name: "work", Categories: []string{"a", "b"}, Timestamp: 10, ProcessID: &pid, ThreadID: &tid}
+
+	DescribeTable("encodes events exactly as MarshalEvent does",
+		func(e events.Event) {
+			expected, err := events.MarshalEvent(e)
+			Expect(err).ToNot(HaveOccurred())
+			appended, err := events.AppendEvent([]byte("prefix"), e)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(appended)).To(Equal("prefix" + string(expected)))
+		},
+		Entry("begin duration", &events.BeginDuration{EventWithArgs: events.EventWithArgs{EventCore: core}}),
+		Entry("end duration", &events.EndDuration{
+			EventWithArgs:   events.EventWithArgs{EventCore: events.EventCore{Name: "work", ThreadTimestamp: &tts}},
+			EventStackTrace: events.EventStackTrace{StackFrameID: "s1"},
+		}),
+		Entry("complete", &events.Complete{
+			EventWithArgs: events.EventWithArgs{EventCore: core},
+			Duration:      20, ThreadDuration: &tts,
+			EventEndStackTrace: events.EventEndStackTrace{EndStackFrameID: "s2"},
+		}),
+		Entry("instant", &events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: core},
+			Scope:         events.InstantScopeProcess,
+		}),
+		Entry("colored events", &events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "work", Color: "bad"}},
+		}),
+		Entry("names needing escaping", &events.Instant{EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{
+			Name: "<\"quoted\"\n & é >", Categories: []string{"\\", "\x01"},
+		}}}),
+		Entry("events with args", &events.BeginDuration{EventWithArgs: events.EventWithArgs{
+			EventCore: core, Args: map[string]interface{}{"n": 5.0},
+		}}),
+		Entry("events with stack traces", &events.Instant{
+			EventWithArgs:   events.EventWithArgs{EventCore: core},
+			EventStackTrace: events.EventStackTrace{StackTrace: &events.StackTrace{Trace: []*events.StackFrame{{Name: "main"}}}},
+		}),
+		Entry("other kinds of event", &events.Counter{EventCore: core, Values: map[string]float64{"n": 1}}),
+	)
+})
@@ -0,0 +1,116 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/omaskery/teffy/internal/jsonfields"
+)
+
+// ArgMarshaler is implemented by typed args that encode themselves, such as to encode their fields without reflection
+// or to put off work until the event is actually written. MarshalArgs must return a JSON object.
+type ArgMarshaler interface {
+	MarshalArgs() ([]byte, error)
+}
+
+// ArgsOf returns the typed args as the map of args they are encoded as, the same map that they would be read back from
+// a file as
+func ArgsOf(typedArgs interface{}) (map[string]interface{}, error) {
+	fields, err := encodeTypedArgs(typedArgs)
+	if err != nil {
+		return nil, err
+	}
+	args := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		var decoded interface{}
+		if err := json.Unmarshal(value, &decoded); err != nil {
+			return nil, fmt.Errorf("failed to decode arg %q: %w", key, err)
+		}
+		args[key] = decoded
+	}
+	return args, nil
+}
+
+// DecodeArgs decodes the args of the event, including any typed args, into the given pointer as encoding/json would,
+// such as into the struct the args were given as when the event was traced
+func (e *EventWithArgs) DecodeArgs(v interface{}) error {
+	args := interface{}(e.Args)
+	if e.TypedArgs != nil {
+		merged, err := mergeTypedArgs(e.TypedArgs, e.Args)
+		if err != nil {
+			return err
+		}
+		args = merged
+	}
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("failed to encode args: %w", err)
+	}
+	if err := json.Unmarshal(encoded, v); err != nil {
+		return fmt.Errorf("failed to decode args into %T: %w", v, err)
+	}
+	return nil
+}
+
+// withEncodedTypedArgs returns the event as it is written, a copy whose args hold the fields its typed args encode to
+// if it has any, or the event itself if it does not
+func withEncodedTypedArgs(e Event) (Event, error) {
+	getter, ok := e.(TypedArgGetter)
+	if !ok || getter.GetTypedArgs() == nil {
+		return e, nil
+	}
+	args, err := mergeTypedArgs(getter.GetTypedArgs(), e.(ArgGetter).GetArgs())
+	if err != nil {
+		return nil, err
+	}
+	// the event is copied rather than changed, as marshalling it must leave it as it was
+	copied := reflect.New(reflect.TypeOf(e).Elem())
+	copied.Elem().Set(reflect.ValueOf(e).Elem())
+	encoded := copied.Interface().(Event)
+	encoded.(ArgSetter).SetArgs(args)
+	return encoded, nil
+}
+
+// mergeTypedArgs encodes the typed args, returning their fields as raw JSON values along with the args, which take
+// precedence over fields of the same name
+func mergeTypedArgs(typedArgs interface{}, args map[string]interface{}) (map[string]interface{}, error) {
+	fields, err := encodeTypedArgs(typedArgs)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]interface{}, len(fields)+len(args))
+	for key, value := range fields {
+		merged[key] = value
+	}
+	for key, value := range args {
+		merged[key] = value
+	}
+	return merged, nil
+}
+
+// encodeTypedArgs encodes the typed args, splitting the object they encode to into its fields
+func encodeTypedArgs(typedArgs interface{}) (map[string]json.RawMessage, error) {
+	var encoded []byte
+	var err error
+	if marshaler, ok := typedArgs.(ArgMarshaler); ok {
+		encoded, err = marshaler.MarshalArgs()
+		if err == nil && !json.Valid(encoded) {
+			err = fmt.Errorf("MarshalArgs of %T returned invalid JSON", typedArgs)
+		}
+	} else {
+		encoded, err = json.Marshal(typedArgs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode args of type %T: %w", typedArgs, err)
+	}
+	fields, err := jsonfields.Split(encoded)
+	if errors.Is(err, jsonfields.ErrNotObject) {
+		return nil, fmt.Errorf("args of type %T must encode as a JSON object: %w", typedArgs, ErrInvalidDataType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode args of type %T: %w", typedArgs, err)
+	}
+	return fields, nil
+}
@@ -0,0 +1,74 @@
+package events_test
+
+import (
+	"errors"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+type requestArgs struct {
+	Method string `json:"method"`
+	Status int    `json:"status"`
+}
+
+// countArgs encodes itself without reflection
+type countArgs int
+
+func (c countArgs) MarshalArgs() ([]byte, error) {
+	return []byte(`{"count":` + strconv.Itoa(int(c)) + `}`), nil
+}
+
+var _ = Describe("Typed args", func() {
+	It("encodes structs as the args of events, along with any other args", func() {
+		event := &events.BeginDuration{EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{Name: "request"},
+			TypedArgs: requestArgs{Method: "GET", Status: 200},
+			Args:      map[string]interface{}{"status": 404, "retry": true},
+		}}
+		encoded, err := events.MarshalEvent(event)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(encoded)).To(MatchJSON(
+			`{"ph":"B","name":"request","ts":0,"args":{"method":"GET","status":404,"retry":true}}`))
+		appended, err := events.AppendEvent(nil, event)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(appended).To(Equal(encoded))
+
+		Expect(event.Args).To(HaveLen(2))
+		Expect(event.TypedArgs).To(Equal(requestArgs{Method: "GET", Status: 200}))
+	})
+
+	It("encodes ArgMarshalers as they encode themselves", func() {
+		event := &events.Complete{EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{Name: "batch"},
+			TypedArgs: countArgs(3),
+		}}
+		encoded, err := events.MarshalEvent(event)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(encoded)).To(MatchJSON(`{"ph":"X","name":"batch","ts":0,"args":{"count":3}}`))
+
+		Expect(events.ArgsOf(countArgs(3))).To(Equal(map[string]interface{}{"count": 3.0}))
+	})
+
+	It("decodes args into structs", func() {
+		event := &events.EndDuration{EventWithArgs: events.EventWithArgs{
+			Args: map[string]interface{}{"method": "PUT", "status": 201.0},
+		}}
+		var decoded requestArgs
+		Expect(event.DecodeArgs(&decoded)).To(Succeed())
+		Expect(decoded).To(Equal(requestArgs{Method: "PUT", Status: 201}))
+
+		event.TypedArgs = requestArgs{Method: "GET"}
+		Expect(event.DecodeArgs(&decoded)).To(Succeed())
+		Expect(decoded).To(Equal(requestArgs{Method: "PUT", Status: 201}))
+	})
+
+	It("fails to encode typed args that are not objects", func() {
+		event := &events.BeginDuration{EventWithArgs: events.EventWithArgs{TypedArgs: []int{1, 2}}}
+		_, err := events.MarshalEvent(event)
+		Expect(errors.Is(err, events.ErrInvalidDataType)).To(BeTrue())
+	})
+})
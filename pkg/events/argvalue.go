@@ -0,0 +1,210 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// ErrArgNotFound is returned by Arg when the event has no arg of the given key
+var ErrArgNotFound = errors.New("event has no such arg")
+
+// ErrArgType is returned by Arg when an arg cannot be converted to the type asked for
+var ErrArgType = errors.New("arg cannot be converted to the requested type")
+
+// ArgValue is the types that Arg can convert the value of an arg to
+type ArgValue interface {
+	~bool | ~string |
+		~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+		~float32 | ~float64
+}
+
+// Arg returns the arg of the given key, from the args of the event or failing that its typed args, converted to the
+// type asked for. Args read from files hold numbers as float64s, and some producers write numbers as strings, so
+// numbers and strings are converted between as long as no information is lost: a float64 of 12 is an int64 of 12 but
+// 12.5 is not, and the string "12" is an int64 of 12. Numbers are converted to strings as they are written in JSON.
+// ErrArgNotFound is returned if there is no such arg and ErrArgType if it cannot be converted.
+func Arg[T ArgValue](e Event, key string) (T, error) {
+	var result T
+	value, err := argOf(e, key)
+	if err != nil {
+		return result, err
+	}
+	if err := convertArg(value, reflect.ValueOf(&result).Elem()); err != nil {
+		return result, fmt.Errorf("arg %q: %w", key, err)
+	}
+	return result, nil
+}
+
+// ArgOr returns the arg of the given key converted to the type asked for as Arg does, or the fallback if the event has
+// no such arg or it cannot be converted
+func ArgOr[T ArgValue](e Event, key string, fallback T) T {
+	result, err := Arg[T](e, key)
+	if err != nil {
+		return fallback
+	}
+	return result
+}
+
+// argOf returns the arg of the given key, with the args of the event taking precedence over its typed args as they
+// do when the event is written
+func argOf(e Event, key string) (interface{}, error) {
+	getter, ok := e.(ArgGetter)
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", key, ErrArgNotFound)
+	}
+	if value, ok := getter.GetArgs()[key]; ok {
+		return value, nil
+	}
+	if typed, ok := e.(TypedArgGetter); ok && typed.GetTypedArgs() != nil {
+		args, err := ArgsOf(typed.GetTypedArgs())
+		if err != nil {
+			return nil, err
+		}
+		if value, ok := args[key]; ok {
+			return value, nil
+		}
+	}
+	return nil, fmt.Errorf("%q: %w", key, ErrArgNotFound)
+}
+
+// convertArg sets the target, which is of one of the kinds of ArgValue, to the value if it can be without losing
+// information
+func convertArg(value interface{}, target reflect.Value) error {
+	if raw, ok := value.(json.RawMessage); ok {
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return fmt.Errorf("failed to decode arg: %w", err)
+		}
+	}
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return fmt.Errorf("null: %w", ErrArgType)
+	}
+	fail := func() error {
+		return fmt.Errorf("%T %v as %s: %w", value, value, target.Type(), ErrArgType)
+	}
+
+	switch target.Kind() {
+	case reflect.String:
+		switch v.Kind() {
+		case reflect.String:
+			target.SetString(v.String())
+		case reflect.Bool:
+			target.SetString(strconv.FormatBool(v.Bool()))
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			target.SetString(strconv.FormatInt(v.Int(), 10))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			target.SetString(strconv.FormatUint(v.Uint(), 10))
+		case reflect.Float32, reflect.Float64:
+			target.SetString(strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()))
+		default:
+			return fail()
+		}
+	case reflect.Bool:
+		switch v.Kind() {
+		case reflect.Bool:
+			target.SetBool(v.Bool())
+		case reflect.String:
+			parsed, err := strconv.ParseBool(v.String())
+			if err != nil {
+				return fail()
+			}
+			target.SetBool(parsed)
+		default:
+			return fail()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := argInt(v)
+		if !ok || target.OverflowInt(n) {
+			return fail()
+		}
+		target.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := argUint(v)
+		if !ok || target.OverflowUint(n) {
+			return fail()
+		}
+		target.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, ok := argFloat(v)
+		if !ok || target.OverflowFloat(f) {
+			return fail()
+		}
+		target.SetFloat(f)
+	default:
+		return fail()
+	}
+	return nil
+}
+
+// argInt returns the value as an int64 if it holds a whole number that fits in one
+func argInt(v reflect.Value) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		// 2^63 is the first float64 beyond the range of int64, as MaxInt64 itself is not representable
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= 1<<63 {
+			return 0, false
+		}
+		return int64(f), true
+	case reflect.String:
+		if n, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+			return n, true
+		}
+		if f, err := strconv.ParseFloat(v.String(), 64); err == nil {
+			return argInt(reflect.ValueOf(f))
+		}
+	}
+	return 0, false
+}
+
+// argUint returns the value as a uint64 if it holds a non-negative whole number that fits in one
+func argUint(v reflect.Value) (uint64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int()), v.Int() >= 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f != math.Trunc(f) || f < 0 || f >= 1<<64 {
+			return 0, false
+		}
+		return uint64(f), true
+	case reflect.String:
+		if n, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return n, true
+		}
+		if f, err := strconv.ParseFloat(v.String(), 64); err == nil {
+			return argUint(reflect.ValueOf(f))
+		}
+	}
+	return 0, false
+}
+
+// argFloat returns the value as a float64 if it holds a number
+func argFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		f, err := strconv.ParseFloat(v.String(), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,71 @@
+package events_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+var _ = Describe("Arg", func() {
+	var event *events.Complete
+
+	BeforeEach(func() {
+		parsed, err := events.UnmarshalEvent([]byte(`{"ph":"X","name":"compile","ts":0,"dur":1,"args":{` +
+			`"bytes":1024,"ratio":0.5,"count":"12","mnemonic":"CppCompile","cached":"true",` +
+			`"negative":-3,"empty":null}}`))
+		Expect(err).To(Succeed())
+		event = parsed.(*events.Complete)
+	})
+
+	It("converts numbers read from files to integers when they are whole", func() {
+		Expect(events.Arg[int64](event, "bytes")).To(Equal(int64(1024)))
+		Expect(events.Arg[int](event, "bytes")).To(Equal(1024))
+		Expect(events.Arg[uint16](event, "bytes")).To(Equal(uint16(1024)))
+		Expect(events.Arg[float64](event, "ratio")).To(Equal(0.5))
+
+		_, err := events.Arg[int64](event, "ratio")
+		Expect(err).To(MatchError(events.ErrArgType))
+		_, err = events.Arg[uint8](event, "bytes")
+		Expect(err).To(MatchError(events.ErrArgType))
+		_, err = events.Arg[uint64](event, "negative")
+		Expect(err).To(MatchError(events.ErrArgType))
+		Expect(events.Arg[int8](event, "negative")).To(Equal(int8(-3)))
+	})
+
+	It("converts between numbers and strings", func() {
+		Expect(events.Arg[int64](event, "count")).To(Equal(int64(12)))
+		Expect(events.Arg[float32](event, "count")).To(Equal(float32(12)))
+		Expect(events.Arg[string](event, "bytes")).To(Equal("1024"))
+		Expect(events.Arg[string](event, "ratio")).To(Equal("0.5"))
+		Expect(events.Arg[bool](event, "cached")).To(BeTrue())
+
+		_, err := events.Arg[int64](event, "mnemonic")
+		Expect(err).To(MatchError(events.ErrArgType))
+		_, err = events.Arg[string](event, "empty")
+		Expect(err).To(MatchError(events.ErrArgType))
+	})
+
+	It("reports args that are missing", func() {
+		_, err := events.Arg[string](event, "missing")
+		Expect(err).To(MatchError(events.ErrArgNotFound))
+		_, err = events.Arg[string](&events.Counter{}, "missing")
+		Expect(err).To(MatchError(events.ErrArgNotFound))
+	})
+
+	It("falls back to a default when the arg is missing or of the wrong type", func() {
+		Expect(events.ArgOr(event, "mnemonic", "unknown")).To(Equal("CppCompile"))
+		Expect(events.ArgOr(event, "missing", "unknown")).To(Equal("unknown"))
+		Expect(events.ArgOr(event, "mnemonic", int64(-1))).To(Equal(int64(-1)))
+	})
+
+	It("reads typed args and args given in memory", func() {
+		e := &events.BeginDuration{EventWithArgs: events.EventWithArgs{
+			TypedArgs: requestArgs{Method: "GET", Status: 200},
+			Args:      map[string]interface{}{"method": "POST", "size": int32(7)},
+		}}
+		Expect(events.Arg[string](e, "method")).To(Equal("POST"))
+		Expect(events.Arg[int](e, "status")).To(Equal(200))
+		Expect(events.Arg[float64](e, "size")).To(Equal(float64(7)))
+	})
+})
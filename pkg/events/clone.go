@@ -0,0 +1,20 @@
+package events
+
+import "github.com/omaskery/teffy/internal/deepcopy"
+
+// Clone returns a deep copy of the event, sharing nothing with the original, so that either can be changed without
+// affecting the other. Args are copied along with any maps and slices within them.
+func Clone(e Event) Event {
+	if e == nil {
+		return nil
+	}
+	return deepcopy.Of(e).(Event)
+}
+
+// Clone returns a deep copy of the sample
+func (s *ProfileSample) Clone() *ProfileSample {
+	if s == nil {
+		return nil
+	}
+	return deepcopy.Of(s).(*ProfileSample)
+}
@@ -1,6 +1,11 @@
 // events provides logical representations for trace events
 package events
 
+import (
+	"encoding/json"
+	"sort"
+)
+
 // Phase is the discriminator for identifying the type of an event in a Trace Event Format file
 type Phase string
 
@@ -11,9 +16,14 @@ const (
 	PhaseInstant           Phase = "I"
 	PhaseInstantLegacy     Phase = "i"
 	PhaseCounter           Phase = "C"
+	PhaseSample            Phase = "P"
 	PhaseAsyncBegin        Phase = "b"
 	PhaseAsyncEnd          Phase = "e"
 	PhaseAsyncInstant      Phase = "n"
+	PhaseAsyncBeginLegacy  Phase = "S"
+	PhaseAsyncStepInto     Phase = "T"
+	PhaseAsyncStepPast     Phase = "p"
+	PhaseAsyncEndLegacy    Phase = "F"
 	PhaseFlowStart         Phase = "s"
 	PhaseFlowInstant       Phase = "t"
 	PhaseFlowFinish        Phase = "f"
@@ -63,12 +73,28 @@ type EventCore struct {
 	Categories []string
 	// Timestamp is the event time in microseconds
 	Timestamp int64
-	// ThreadTimestamp is an optional timestamp to order events within a single thread
+	// TimestampFraction is the fraction of a microsecond, from 0 up to but not including 1, that the event time is
+	// beyond Timestamp, for traces with sub-microsecond precision such as Chrome's. It is usually zero.
+	TimestampFraction float64
+	// ThreadTimestamp is an optional timestamp to order events within a single thread, any fraction of a microsecond
+	// it is read with is discarded
 	ThreadTimestamp *int64
 	// ProcessID is an optional identifier for the ID of the process that output this event
 	ProcessID *int64
 	// ThreadID is an optional identifier for the ID of the thread that output this event
 	ThreadID *int64
+	// ProcessRef is the process ID as the trace gave it, when it was not an int64 such as for a pid of "Browser", in
+	// which case ProcessID holds the int64 standing in for it. It is written in place of ProcessID while it still
+	// stands for it.
+	ProcessRef *IDRef
+	// ThreadRef is the thread ID as the trace gave it, when it was not an int64, as ProcessRef is for the process ID
+	ThreadRef *IDRef
+	// Color optionally names one of the trace viewer's reserved colors (e.g. "good" or "thread_state_running") to draw
+	// the event with, rather than a color chosen from its name, such as to highlight durations or the series of counters
+	Color string
+	// Extra holds any fields encountered when parsing the event that are not otherwise understood, these are written
+	// back out verbatim so that traces from other tools survive being read and rewritten
+	Extra map[string]json.RawMessage
 }
 
 // ArgSetter allows setting the arguments of events that allow it
@@ -77,6 +103,24 @@ type ArgSetter interface {
 	SetArgs(args map[string]interface{})
 }
 
+// ArgGetter allows reading the arguments of events that have them
+type ArgGetter interface {
+	// GetArgs gets the event arguments
+	GetArgs() map[string]interface{}
+}
+
+// TypedArgSetter allows setting the typed args of events that have args
+type TypedArgSetter interface {
+	// SetTypedArgs sets the Go value encoded as the event's args
+	SetTypedArgs(args interface{})
+}
+
+// TypedArgGetter allows reading the typed args of events that have args
+type TypedArgGetter interface {
+	// GetTypedArgs gets the Go value encoded as the event's args
+	GetTypedArgs() interface{}
+}
+
 // StackTraceSetter allows setting the stack trace of events that allow it
 type StackTraceSetter interface {
 	// SetStackTrace sets the event stack trace
@@ -100,6 +144,10 @@ type EventWithArgs struct {
 	// Args are arbitrary values associated with the event by the traced software, though sometimes events
 	// store their additional fields in the args
 	Args map[string]interface{}
+	// TypedArgs is any Go value, such as a struct, that is encoded as the args of the event when it is written, so that
+	// args can be given without building a map. It must encode as a JSON object, either by encoding/json or by
+	// implementing ArgMarshaler, and any Args are added to its fields. Events read from files only have Args.
+	TypedArgs interface{}
 }
 
 // SetArgs allows for events with arguments to have those arguments updated
@@ -107,9 +155,26 @@ func (e *EventWithArgs) SetArgs(args map[string]interface{}) {
 	e.Args = args
 }
 
+// GetArgs allows for the arguments of events with arguments to be read generically
+func (e *EventWithArgs) GetArgs() map[string]interface{} {
+	return e.Args
+}
+
+// SetTypedArgs allows for events with arguments to be given a Go value to encode as their arguments
+func (e *EventWithArgs) SetTypedArgs(args interface{}) {
+	e.TypedArgs = args
+}
+
+// GetTypedArgs allows for the typed arguments of events with arguments to be read generically
+func (e *EventWithArgs) GetTypedArgs() interface{} {
+	return e.TypedArgs
+}
+
 // EventStackTrace represents the fields included in events that have a stack trace
 type EventStackTrace struct {
 	StackTrace *StackTrace
+	// StackFrameID optionally refers to the most recently called frame of the stack trace in the file's stack frames
+	StackFrameID string
 }
 
 // SetStackTrace allows events with stack traces to have those stack traces updated
@@ -120,6 +185,9 @@ func (e *EventStackTrace) SetStackTrace(trace *StackTrace) {
 // EventEndStackTrace represents the fields included in events that have an 'ending' stack trace
 type EventEndStackTrace struct {
 	EndStackTrace *StackTrace
+	// EndStackFrameID optionally refers to the most recently called frame of the ending stack trace in the file's
+	// stack frames
+	EndStackFrameID string
 }
 
 // SetEndStackTrace allows events with ending stack traces to have those stack traces updated
@@ -152,27 +220,51 @@ type Complete struct {
 	EventEndStackTrace
 	// Duration of the event in microseconds
 	Duration int64
-	// ThreadDuration is an optional duration of the event according to the thread clock
+	// DurationFraction is the fraction of a microsecond, from 0 up to but not including 1, that the duration is beyond
+	// Duration, for traces with sub-microsecond precision. It is usually zero.
+	DurationFraction float64
+	// ThreadDuration is an optional duration of the event according to the thread clock, any fraction of a microsecond
+	// it is read with is discarded
 	ThreadDuration *int64
 }
 
 func (Complete) Phase() Phase { return PhaseComplete }
 
+// ThreadEndTimestamp is the thread clock timestamp at which the event ended, which is only known when the event has
+// both a ThreadTimestamp and a ThreadDuration
+func (c Complete) ThreadEndTimestamp() *int64 {
+	if c.ThreadTimestamp == nil || c.ThreadDuration == nil {
+		return nil
+	}
+	end := *c.ThreadTimestamp + *c.ThreadDuration
+	return &end
+}
+
 // InstantScope represents how widely an instantaneous event is relevant within a trace file
 type InstantScope string
 
 const (
 	// InstantScopeThread means this instant event is only relevant to one thread of a single process
 	InstantScopeThread InstantScope = "t"
-	// InstantScopeThread means this instant event is relevant to one process, but across all threads in that process
+	// InstantScopeProcess means this instant event is relevant to one process, but across all threads in that process
 	InstantScopeProcess InstantScope = "p"
-	// InstantScopeThread means this instant event is relevant to the entire trace across all processes
+	// InstantScopeGlobal means this instant event is relevant to the entire trace across all processes
 	InstantScopeGlobal InstantScope = "g"
 )
 
+// Valid reports whether the scope is one of the scopes of the Trace Event Format, or empty, which readers of traces
+// take to mean global
+func (s InstantScope) Valid() bool {
+	switch s {
+	case "", InstantScopeThread, InstantScopeProcess, InstantScopeGlobal:
+		return true
+	}
+	return false
+}
+
 // Instant corresponds to something that happens but has no duration associated with it
 type Instant struct {
-	EventCore
+	EventWithArgs
 	EventStackTrace
 	// Scope indicates how widely this event is relevant, within the thread, process, or globally
 	Scope InstantScope
@@ -180,15 +272,60 @@ type Instant struct {
 
 func (Instant) Phase() Phase { return PhaseInstant }
 
-// Counter is used to track one or more values as they change over time
+// Counter is used to track one or more values as they change over time. Each of the named values is a series of the
+// counter, and counters with several series are drawn as stacked charts with the series in the order they are written
+// in, which is given by SeriesOrder. The color of the chart is the Color of the event.
 type Counter struct {
 	EventCore
 	// Values records a snapshot of named values for tracking over time
 	Values map[string]float64
+	// Id optionally distinguishes counters with the same name, the counter is identified by the combination of the two
+	Id string
+	// Series optionally orders the series of Values, those not listed following in order of their names. Parsed counters
+	// only have it when their series were not written in order of their names.
+	Series []string
 }
 
 func (Counter) Phase() Phase { return PhaseCounter }
 
+// SeriesOrder returns the names of the series of Values in the order given by Series, followed by those it does not
+// list in order of their names. Names in Series without a value are left out.
+func (c *Counter) SeriesOrder() []string {
+	order := make([]string, 0, len(c.Values))
+	listed := make(map[string]bool, len(c.Series))
+	for _, name := range c.Series {
+		if _, ok := c.Values[name]; ok && !listed[name] {
+			listed[name] = true
+			order = append(order, name)
+		}
+	}
+	rest := len(order)
+	for name := range c.Values {
+		if !listed[name] {
+			order = append(order, name)
+		}
+	}
+	sort.Strings(order[rest:])
+	return order
+}
+
+// Sample records the stack of a thread at a moment in time, as captured by a sampling profiler
+type Sample struct {
+	EventCore
+	EventStackTrace
+}
+
+func (Sample) Phase() Phase { return PhaseSample }
+
+// Id2 identifies async, object, context and link events in place of an Id, stating whether the identifier is only unique within the
+// process that emitted the event or across all processes in the trace. Only one of Local or Global is set.
+type Id2 struct {
+	// Local identifies events only within the process that emitted them
+	Local string
+	// Global identifies events across all processes in the trace
+	Global string
+}
+
 // AsyncBegin represents the start of an asynchronous operation
 type AsyncBegin struct {
 	EventWithArgs
@@ -196,6 +333,8 @@ type AsyncBegin struct {
 	Id string
 	// Scope is an optional extra component to the identifier to help prevent name collisions for common Id values
 	Scope string
+	// Id2 is an alternative to Id that states whether the identifier is local to the process or global to the trace
+	Id2 *Id2
 }
 
 func (AsyncBegin) Phase() Phase { return PhaseAsyncBegin }
@@ -207,6 +346,8 @@ type AsyncEnd struct {
 	Id string
 	// Scope is an optional extra component to the identifier to help prevent name collisions for common Id values
 	Scope string
+	// Id2 is an alternative to Id that states whether the identifier is local to the process or global to the trace
+	Id2 *Id2
 }
 
 func (AsyncEnd) Phase() Phase { return PhaseAsyncEnd }
@@ -218,6 +359,8 @@ type AsyncInstant struct {
 	Id string
 	// Scope is an optional extra component to the identifier to help prevent name collisions for common Id values
 	Scope string
+	// Id2 is an alternative to Id that states whether the identifier is local to the process or global to the trace
+	Id2 *Id2
 }
 
 func (AsyncInstant) Phase() Phase { return PhaseAsyncInstant }
@@ -225,6 +368,10 @@ func (AsyncInstant) Phase() Phase { return PhaseAsyncInstant }
 // FlowStart is like an AsyncBegin but are used to represent links between Begin/End Duration events
 type FlowStart struct {
 	EventWithArgs
+	// Id is a unique identifier to correlate the events of a flow
+	Id string
+	// Scope is an optional extra component to the identifier to help prevent name collisions for common Id values
+	Scope string
 }
 
 func (FlowStart) Phase() Phase { return PhaseFlowStart }
@@ -232,6 +379,10 @@ func (FlowStart) Phase() Phase { return PhaseFlowStart }
 // FlowInstant is like an AsyncInstant but ... the documentation isn't particularly clear on what that means ^_^;
 type FlowInstant struct {
 	EventWithArgs
+	// Id is a unique identifier to correlate the events of a flow
+	Id string
+	// Scope is an optional extra component to the identifier to help prevent name collisions for common Id values
+	Scope string
 }
 
 func (FlowInstant) Phase() Phase { return PhaseFlowInstant }
@@ -249,6 +400,10 @@ const (
 // FlowFinish is like an AsyncEnd but is used to represent the links between Begin/End Duration events
 type FlowFinish struct {
 	EventWithArgs
+	// Id is a unique identifier to correlate the events of a flow
+	Id string
+	// Scope is an optional extra component to the identifier to help prevent name collisions for common Id values
+	Scope string
 	// BindingPoint indicates whether the event binds to the enclosing slice or next slice after this event
 	// but defaults to the enclosing slice
 	BindingPoint BindingPoint
@@ -261,6 +416,10 @@ type ObjectCreated struct {
 	EventCore
 	// Id uniquely identifies the created object
 	Id string
+	// Scope is an optional extra component to the identifier to help prevent name collisions for common Id values
+	Scope string
+	// Id2 is an alternative to Id that states whether the identifier is local to the process or global to the trace
+	Id2 *Id2
 }
 
 func (ObjectCreated) Phase() Phase { return PhaseObjectCreated }
@@ -270,6 +429,10 @@ type ObjectSnapshot struct {
 	EventWithArgs
 	// Id uniquely identifies the object for which this event records the state
 	Id string
+	// Scope is an optional extra component to the identifier to help prevent name collisions for common Id values
+	Scope string
+	// Id2 is an alternative to Id that states whether the identifier is local to the process or global to the trace
+	Id2 *Id2
 }
 
 func (ObjectSnapshot) Phase() Phase { return PhaseObjectSnapshot }
@@ -279,6 +442,10 @@ type ObjectDeleted struct {
 	EventCore
 	// Id uniquely identifies the deleted object
 	Id string
+	// Scope is an optional extra component to the identifier to help prevent name collisions for common Id values
+	Scope string
+	// Id2 is an alternative to Id that states whether the identifier is local to the process or global to the trace
+	Id2 *Id2
 }
 
 func (ObjectDeleted) Phase() Phase { return PhaseObjectDeleted }
@@ -292,6 +459,9 @@ const (
 	MetadataKindProcessSortIndex MetadataKind = "process_sort_index"
 	MetadataKindThreadName       MetadataKind = "thread_name"
 	MetadataKindThreadSortIndex  MetadataKind = "thread_sort_index"
+	// MetadataKindStackFrame is teffy's own metadata for carrying the stack frames of a trace in its events, for
+	// writers that stream events and so have nowhere else to put them
+	MetadataKindStackFrame MetadataKind = "stack_frame"
 )
 
 // MetadataProcessName is a metadata event conveying the name of the process the trace is from
@@ -380,6 +550,8 @@ type ContextEnter struct {
 	EventWithArgs
 	// Id uniquely identifies the context that is being entered
 	Id string
+	// Id2 is an alternative to Id that states whether the identifier is local to the process or global to the trace
+	Id2 *Id2
 }
 
 func (ContextEnter) Phase() Phase { return PhaseContextEnter }
@@ -389,6 +561,8 @@ type ContextExit struct {
 	EventWithArgs
 	// Id uniquely identifying the context that has been exited
 	Id string
+	// Id2 is an alternative to Id that states whether the identifier is local to the process or global to the trace
+	Id2 *Id2
 }
 
 func (ContextExit) Phase() Phase { return PhaseContextExit }
@@ -398,8 +572,21 @@ type LinkIds struct {
 	EventWithArgs
 	// Id is one of the Ids that is being specified as equivalent
 	Id string
+	// Id2 is an alternative to Id that states whether the identifier is local to the process or global to the trace
+	Id2 *Id2
 	// LinkedId is the second of the Ids that is being marked as equivalent
 	LinkedId string
 }
 
 func (LinkIds) Phase() Phase { return PhaseLinkIds }
+
+// Raw represents an event of a phase that is not otherwise supported, so that it can be carried through unchanged.
+// The common fields are decoded into EventCore so that the event can still be filtered, shifted and so on, whilst the
+// raw JSON of every other field is held in Extra.
+type Raw struct {
+	EventCore
+	// RawPhase is the phase of the event as it was found
+	RawPhase Phase
+}
+
+func (r Raw) Phase() Phase { return r.RawPhase }
@@ -0,0 +1,134 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
+// IDRef is a process or thread ID as a trace gave it when it was not a plain int64, as some producers identify
+// processes and threads by strings such as "Browser", by numeric strings, or by numbers too large for an int64. Events
+// read with such IDs keep an IDRef as their ProcessRef or ThreadRef, alongside the int64 that their ProcessID or
+// ThreadID holds in its place (see ID), and are written with the IDs as they were given.
+type IDRef struct {
+	// Label is the ID as it was given, the string or the digits of the number
+	Label string
+	// Numeric is true if the ID was given as a JSON number rather than a string
+	Numeric bool
+}
+
+// ID returns the int64 that stands in for the ID: numeric strings are the numbers they hold, numbers too large for an
+// int64 are those numbers wrapped around into negative numbers as their unsigned 64 bits, and other labels are
+// negative numbers derived from a hash of them, so that events with the same IDs have the same int64s
+func (r IDRef) ID() int64 {
+	if id, err := strconv.ParseInt(r.Label, 10, 64); err == nil {
+		return id
+	}
+	if id, err := strconv.ParseUint(r.Label, 10, 64); err == nil {
+		return int64(id)
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(r.Label))
+	return -int64(h.Sum64()>>1) - 1
+}
+
+// NewIDRef returns an IDRef for a process or thread identified by the label, and the ID that stands in for it
+func NewIDRef(label string) (*IDRef, int64) {
+	ref := &IDRef{Label: label}
+	return ref, ref.ID()
+}
+
+// jsonID is the pid or tid of an event as it is written, usually an int64 but possibly an IDRef
+type jsonID struct {
+	id      int64
+	ref     *IDRef
+	present bool
+}
+
+// newJsonID returns how the ID is written, as its IDRef if it has one that still stands for it, so that IDs that are
+// changed after being read are written as they were changed to
+func newJsonID(id *int64, ref *IDRef) jsonID {
+	if id == nil {
+		return jsonID{}
+	}
+	if ref != nil && ref.ID() != *id {
+		ref = nil
+	}
+	return jsonID{id: *id, ref: ref, present: true}
+}
+
+// idPtr returns the int64 ID, if there is one
+func (j jsonID) idPtr() *int64 {
+	if !j.present {
+		return nil
+	}
+	id := j.id
+	return &id
+}
+
+func (j jsonID) MarshalJSON() ([]byte, error) {
+	return appendJsonID(nil, j), nil
+}
+
+// appendJsonID appends the ID as it is written, an IDRef being written as it was given
+func appendJsonID(buf []byte, j jsonID) []byte {
+	switch {
+	case j.ref == nil:
+		return strconv.AppendInt(buf, j.id, 10)
+	case j.ref.Numeric:
+		return append(buf, j.ref.Label...)
+	default:
+		buf = append(buf, '"')
+		buf = appendStringContents(buf, j.ref.Label)
+		return append(buf, '"')
+	}
+}
+
+// UnmarshalJSON decodes the ID, keeping an IDRef of any ID that is not a plain int64
+func (j *jsonID) UnmarshalJSON(data []byte) error {
+	// converting the data to a string in the call itself keeps plain IDs, by far the commonest, from allocating
+	if id, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+		*j = jsonID{id: id, present: true}
+		return nil
+	}
+	if string(data) == "null" {
+		*j = jsonID{}
+		return nil
+	}
+
+	var ref IDRef
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &ref.Label); err != nil {
+			return err
+		}
+	} else if _, err := strconv.ParseUint(string(data), 10, 64); err == nil {
+		ref = IDRef{Label: string(data), Numeric: true}
+	} else {
+		return fmt.Errorf("'%s' is not a process or thread ID: %w", data, ErrInvalidDataType)
+	}
+	*j = jsonID{id: ref.ID(), ref: &ref, present: true}
+	return nil
+}
+
+// JsonID is a process or thread ID as it is written in the fields of events, for packages that decode events
+// themselves rather than through UnmarshalEvent, so that they accept the same IDs that it does
+type JsonID struct {
+	// ID is the ID, or the int64 that stands in for Ref if it has one
+	ID int64
+	// Ref is the ID as it was given, if it was not a plain int64
+	Ref *IDRef
+}
+
+func (j JsonID) MarshalJSON() ([]byte, error) {
+	return appendJsonID(nil, newJsonID(&j.ID, j.Ref)), nil
+}
+
+func (j *JsonID) UnmarshalJSON(data []byte) error {
+	var decoded jsonID
+	if err := decoded.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	*j = JsonID{ID: decoded.id, Ref: decoded.ref}
+	return nil
+}
@@ -0,0 +1,82 @@
+package events
+
+import "strings"
+
+// maxInterned limits how many distinct names and category lists an Interner holds, those seen after it is full are
+// decoded without interning so that traces of endlessly varied names do not grow it without bound
+const maxInterned = 1 << 16
+
+// Interner shares the memory of the names and categories of the events it decodes, so that the many events of a large
+// trace that share a name or categories hold one copy of them rather than one each. Events decoded by the same Interner
+// may share the slice holding their categories, which should therefore be replaced rather than changed in place.
+//
+// An Interner is not safe for concurrent use.
+type Interner struct {
+	names      map[string]string
+	categories map[string][]string
+}
+
+// NewInterner creates an Interner that has yet to see any events
+func NewInterner() *Interner {
+	return &Interner{
+		names:      map[string]string{},
+		categories: map[string][]string{},
+	}
+}
+
+// UnmarshalEvent decodes a single event as UnmarshalEvent does, interning its name and categories
+func (in *Interner) UnmarshalEvent(data []byte) (Event, error) {
+	return unmarshalEvent(data, in)
+}
+
+// eventCore converts the decoded fields common to all events, interning them unless the interner is nil
+func (in *Interner) eventCore(jsonCore jsonEventCore) EventCore {
+	return EventCore{
+		Name:              in.name(jsonCore.Name),
+		Categories:        in.categoryList(jsonCore.Categories),
+		Timestamp:         jsonCore.Timestamp.whole,
+		TimestampFraction: jsonCore.Timestamp.fraction,
+		ThreadTimestamp:   jsonCore.ThreadTimestamp.wholePtr(),
+		ProcessID:         jsonCore.ProcessID.idPtr(),
+		ThreadID:          jsonCore.ThreadID.idPtr(),
+		ProcessRef:        jsonCore.ProcessID.ref,
+		ThreadRef:         jsonCore.ThreadID.ref,
+		Color:             jsonCore.Color,
+	}
+}
+
+func (in *Interner) name(name string) string {
+	if in == nil {
+		return name
+	}
+	if interned, ok := in.names[name]; ok {
+		return interned
+	}
+	if len(in.names) < maxInterned {
+		in.names[name] = name
+	}
+	return name
+}
+
+// categoryList splits the comma separated categories, sharing the slice between events with the same categories
+func (in *Interner) categoryList(categories string) []string {
+	if categories == "" {
+		return make([]string, 0)
+	}
+	if in == nil {
+		return strings.Split(categories, ",")
+	}
+	if interned, ok := in.categories[categories]; ok {
+		return interned
+	}
+	split := strings.Split(categories, ",")
+	for i, category := range split {
+		split[i] = in.name(category)
+	}
+	// a full slice expression ensures appending to the shared slice copies it rather than writing into it
+	split = split[:len(split):len(split)]
+	if len(in.categories) < maxInterned {
+		in.categories[categories] = split
+	}
+	return split
+}
@@ -0,0 +1,47 @@
+package events_test
+
+import (
+	"unsafe"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+var _ = Describe("Interning", func() {
+	decode := func(in *events.Interner, data string) events.Event {
+		e, err := in.UnmarshalEvent([]byte(data))
+		Expect(err).ToNot(HaveOccurred())
+		return e
+	}
+
+	It("decodes events as UnmarshalEvent does", func() {
+		data := `{"ph":"X","name":"draw","cat":"gfx,frame","ts":1,"dur":2,"pid":1,"tid":2,"args":{"n":1},"custom":true}`
+		expected, err := events.UnmarshalEvent([]byte(data))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decode(events.NewInterner(), data)).To(Equal(expected))
+		Expect(decode(events.NewInterner(), `{"ph":"i","name":"tick","ts":1}`).Core().Categories).To(BeEmpty())
+	})
+
+	It("shares the names and categories of events", func() {
+		in := events.NewInterner()
+		a := decode(in, `{"ph":"B","name":"draw","cat":"gfx,frame","ts":1}`).Core()
+		b := decode(in, `{"ph":"E","name":"draw","cat":"gfx,frame","ts":2}`).Core()
+		c := decode(in, `{"ph":"i","name":"gfx","cat":"gfx","ts":3}`).Core()
+		Expect(unsafe.StringData(a.Name)).To(BeIdenticalTo(unsafe.StringData(b.Name)))
+		Expect(&a.Categories[0]).To(BeIdenticalTo(&b.Categories[0]))
+		Expect(unsafe.StringData(c.Name)).To(BeIdenticalTo(unsafe.StringData(a.Categories[0])))
+		Expect(unsafe.StringData(c.Categories[0])).To(BeIdenticalTo(unsafe.StringData(a.Categories[0])))
+	})
+
+	It("copies shared categories when they are appended to", func() {
+		in := events.NewInterner()
+		a := decode(in, `{"ph":"i","name":"tick","cat":"gfx","ts":1}`).Core()
+		b := decode(in, `{"ph":"i","name":"tick","cat":"gfx","ts":2}`).Core()
+		a.Categories = append(a.Categories, "extra")
+		Expect(b.Categories).To(Equal([]string{"gfx"}))
+		c := decode(in, `{"ph":"i","name":"tick","cat":"gfx","ts":3}`).Core()
+		Expect(c.Categories).To(Equal([]string{"gfx"}))
+	})
+})
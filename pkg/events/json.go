@@ -0,0 +1,1459 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/omaskery/teffy/internal/jsonfields"
+)
+
+// ErrInvalidDataType means that during parsing a value was of an unexpected type (e.g. getting a number instead of string)
+var ErrInvalidDataType = errors.New("data found in file does not match expected type")
+
+// ErrMissingId means that an event cannot be written because it lacks an identifier that its phase requires
+var ErrMissingId = errors.New("event is missing a required identifier")
+
+// ErrInvalidScope means that an instant event cannot be written because its scope is not one of those of the Trace
+// Event Format
+var ErrInvalidScope = errors.New("invalid instant event scope")
+
+// UnmarshalEvent decodes a single event as it would appear in a Trace Event Format file, returning the event type
+// that matches its phase
+func UnmarshalEvent(data []byte) (Event, error) {
+	return unmarshalEvent(data, nil)
+}
+
+// unmarshalEvent decodes the event, interning its name and categories if given an interner
+func unmarshalEvent(data []byte, in *Interner) (Event, error) {
+	if err := validateJson(data); err != nil {
+		return nil, err
+	}
+	event, err := parseJsonEvent(data, in)
+	if err != nil {
+		return nil, err
+	}
+	// the extra fields refer to the provided data, which the caller is free to reuse
+	for key, value := range event.Core().Extra {
+		event.Core().Extra[key] = append(json.RawMessage(nil), value...)
+	}
+	return event, nil
+}
+
+// validateJson checks that the data is valid JSON, as the fields of events are found assuming that it is
+func validateJson(data []byte) error {
+	if json.Valid(data) {
+		return nil
+	}
+	// decode again purely to report where the syntax error is
+	var raw json.RawMessage
+	return json.Unmarshal(data, &raw)
+}
+
+// MarshalEvent encodes a single event as it would appear in a Trace Event Format file
+func MarshalEvent(e Event) ([]byte, error) {
+	return marshalJsonEvent(e)
+}
+
+// MarshalLegacyEvent is like MarshalEvent, but uses the deprecated phases of async and instant events that older
+// consumers of Trace Event Format files understand in place of the current ones
+func MarshalLegacyEvent(e Event) ([]byte, error) {
+	return marshalJsonEvent(legacyPhased{e})
+}
+
+// legacyPhased presents an event as having the deprecated form of its phase, if it has one
+type legacyPhased struct {
+	Event
+}
+
+func (l legacyPhased) Phase() Phase {
+	switch phase := l.Event.Phase(); phase {
+	case PhaseInstant:
+		return PhaseInstantLegacy
+	case PhaseAsyncBegin:
+		return PhaseAsyncBeginLegacy
+	case PhaseAsyncInstant:
+		return PhaseAsyncStepInto
+	case PhaseAsyncEnd:
+		return PhaseAsyncEndLegacy
+	default:
+		return phase
+	}
+}
+
+// unmarshalEventInto decodes an event into the given pointer to a concrete event type, failing if the phase of the
+// encoded event (or its metadata kind) belongs to a different type
+func unmarshalEventInto(data []byte, target Event) error {
+	event, err := UnmarshalEvent(data)
+	if err != nil {
+		return err
+	}
+	decoded := reflect.ValueOf(event)
+	destination := reflect.ValueOf(target)
+	if decoded.Type() != destination.Type() {
+		return fmt.Errorf("cannot decode %T into %T: %w", event, target, ErrInvalidDataType)
+	}
+	destination.Elem().Set(decoded.Elem())
+	return nil
+}
+
+// Every event type implements json.Marshaler and json.Unmarshaler, encoding the event as it would appear in a Trace
+// Event Format file, so that events can be embedded in other JSON documents. Unmarshaling fails if the encoded event
+// is of a different type to the one being decoded into.
+
+func (e BeginDuration) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *BeginDuration) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e EndDuration) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *EndDuration) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e Complete) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *Complete) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e Instant) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *Instant) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e Counter) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *Counter) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e Sample) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *Sample) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e AsyncBegin) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *AsyncBegin) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e AsyncEnd) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *AsyncEnd) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e AsyncInstant) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *AsyncInstant) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e FlowStart) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *FlowStart) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e FlowInstant) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *FlowInstant) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e FlowFinish) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *FlowFinish) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e ObjectCreated) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *ObjectCreated) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e ObjectSnapshot) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *ObjectSnapshot) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e ObjectDeleted) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *ObjectDeleted) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e MetadataProcessName) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *MetadataProcessName) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e MetadataThreadName) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *MetadataThreadName) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e MetadataProcessLabels) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *MetadataProcessLabels) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e MetadataProcessSortIndex) MarshalJSON() ([]byte, error) { return MarshalEvent(&e) }
+func (e *MetadataProcessSortIndex) UnmarshalJSON(data []byte) error {
+	return unmarshalEventInto(data, e)
+}
+
+func (e MetadataThreadSortIndex) MarshalJSON() ([]byte, error) { return MarshalEvent(&e) }
+func (e *MetadataThreadSortIndex) UnmarshalJSON(data []byte) error {
+	return unmarshalEventInto(data, e)
+}
+
+func (e MetadataMisc) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *MetadataMisc) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e GlobalMemoryDump) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *GlobalMemoryDump) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e ProcessMemoryDump) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *ProcessMemoryDump) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e Mark) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *Mark) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e ClockSync) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *ClockSync) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e ContextEnter) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *ContextEnter) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e ContextExit) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *ContextExit) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e LinkIds) MarshalJSON() ([]byte, error)     { return MarshalEvent(&e) }
+func (e *LinkIds) UnmarshalJSON(data []byte) error { return unmarshalEventInto(data, e) }
+
+func (e Raw) MarshalJSON() ([]byte, error) { return MarshalEvent(&e) }
+
+// UnmarshalJSON decodes any event as a Raw event, regardless of whether its phase is supported
+func (e *Raw) UnmarshalJSON(data []byte) error {
+	if err := validateJson(data); err != nil {
+		return err
+	}
+	// the fields would otherwise refer to the provided data, which the caller is free to reuse
+	fields, err := jsonfields.Split(append([]byte(nil), data...))
+	if err != nil {
+		return fmt.Errorf("expected event to be a JSON object: %w", ErrInvalidDataType)
+	}
+	phase, err := decodeEventPhase(fields)
+	if err != nil {
+		return err
+	}
+	raw, err := decodeRawEvent(fields, phase, nil)
+	if err != nil {
+		return err
+	}
+	*e = *raw
+	return nil
+}
+
+func parseJsonEvent(rawEvent json.RawMessage, in *Interner) (Event, error) {
+	fields, err := jsonfields.Split(rawEvent)
+	if errors.Is(err, jsonfields.ErrNotObject) {
+		return nil, fmt.Errorf("expected event to be a JSON object: %w", ErrInvalidDataType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decoding json event: %w", err)
+	}
+	phase, err := decodeEventPhase(fields)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding json event: %w", err)
+	}
+
+	var event Event
+	var extra map[string]json.RawMessage
+	switch phase {
+	case PhaseBeginDuration:
+		var j jsonDurationEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode begin duration event: %w", err)
+		}
+		event = &BeginDuration{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			EventStackTrace: EventStackTrace{
+				StackTrace:   decodeRawStackTrace(j.Stack),
+				StackFrameID: j.StackFrame,
+			},
+		}
+	case PhaseEndDuration:
+		var j jsonDurationEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode end duration event: %w", err)
+		}
+		event = &EndDuration{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			EventStackTrace: EventStackTrace{
+				StackTrace:   decodeRawStackTrace(j.Stack),
+				StackFrameID: j.StackFrame,
+			},
+		}
+
+	case PhaseComplete:
+		var j jsonCompleteEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode complete event: %w", err)
+		}
+		event = &Complete{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			EventStackTrace: EventStackTrace{
+				StackTrace:   decodeRawStackTrace(j.Stack),
+				StackFrameID: j.StackFrame,
+			},
+			EventEndStackTrace: EventEndStackTrace{
+				EndStackTrace:   decodeRawStackTrace(j.EndStack),
+				EndStackFrameID: j.EndStackFrame,
+			},
+			Duration:         j.Duration.whole,
+			DurationFraction: j.Duration.fraction,
+			ThreadDuration:   j.ThreadDuration.wholePtr(),
+		}
+
+	case PhaseInstant, PhaseInstantLegacy:
+		var j jsonInstantEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode instant event: %w", err)
+		}
+		scope := normalizeInstantScope(j.Scope)
+		event = &Instant{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			EventStackTrace: EventStackTrace{
+				StackTrace:   decodeRawStackTrace(j.Stack),
+				StackFrameID: j.StackFrame,
+			},
+			Scope: scope,
+		}
+
+	case PhaseSample:
+		var j jsonSampleEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode sample event: %w", err)
+		}
+		event = &Sample{
+			EventCore: in.eventCore(j.jsonEventCore),
+			EventStackTrace: EventStackTrace{
+				StackTrace:   decodeRawStackTrace(j.Stack),
+				StackFrameID: j.StackFrame,
+			},
+		}
+
+	case PhaseCounter:
+		var j tempJsonCounterEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode counter event: %w", err)
+		}
+		values, err := decodeCounterValues(j.Values.values)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode counter event values: %w", err)
+		}
+		event = &Counter{
+			EventCore: in.eventCore(j.jsonEventCore),
+			Values:    values,
+			Id:        string(j.Id),
+			Series:    j.Values.series(),
+		}
+
+	case PhaseAsyncBeginLegacy:
+		var j jsonAsyncEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode (deprecated) async start event: %w", err)
+		}
+		event = &AsyncBegin{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:    string(j.Id),
+			Scope: j.Scope,
+			Id2:   decodeId2(j.Id2),
+		}
+	case PhaseAsyncStepInto:
+		var j jsonAsyncEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode (deprecated) async step into event: %w", err)
+		}
+		event = &AsyncInstant{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:    string(j.Id),
+			Scope: j.Scope,
+			Id2:   decodeId2(j.Id2),
+		}
+	case PhaseAsyncStepPast:
+		var j jsonAsyncEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode (deprecated) async step past event: %w", err)
+		}
+		event = &AsyncInstant{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:    string(j.Id),
+			Scope: j.Scope,
+			Id2:   decodeId2(j.Id2),
+		}
+	case PhaseAsyncEndLegacy:
+		var j jsonAsyncEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode (deprecated) async finish event: %w", err)
+		}
+		event = &AsyncEnd{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:    string(j.Id),
+			Scope: j.Scope,
+			Id2:   decodeId2(j.Id2),
+		}
+
+	case PhaseAsyncBegin:
+		var j jsonAsyncEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode async begin event: %w", err)
+		}
+		event = &AsyncBegin{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:    string(j.Id),
+			Scope: j.Scope,
+			Id2:   decodeId2(j.Id2),
+		}
+	case PhaseAsyncInstant:
+		var j jsonAsyncEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode async instant event: %w", err)
+		}
+		event = &AsyncInstant{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:    string(j.Id),
+			Scope: j.Scope,
+			Id2:   decodeId2(j.Id2),
+		}
+	case PhaseAsyncEnd:
+		var j jsonAsyncEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode async end event: %w", err)
+		}
+		event = &AsyncEnd{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:    string(j.Id),
+			Scope: j.Scope,
+			Id2:   decodeId2(j.Id2),
+		}
+
+	case PhaseFlowStart:
+		var j jsonFlowEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode flow start event: %w", err)
+		}
+		event = &FlowStart{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:    string(j.Id),
+			Scope: j.Scope,
+		}
+	case PhaseFlowInstant:
+		var j jsonFlowEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode flow instant event: %w", err)
+		}
+		event = &FlowInstant{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:    string(j.Id),
+			Scope: j.Scope,
+		}
+	case PhaseFlowFinish:
+		var j jsonFlowEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode flow finish event: %w", err)
+		}
+		bindingPoint := BindingPointNext
+		if j.BindingPoint == "e" {
+			bindingPoint = BindingPointEnclosing
+		}
+		event = &FlowFinish{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:           string(j.Id),
+			Scope:        j.Scope,
+			BindingPoint: bindingPoint,
+		}
+
+	case PhaseObjectCreated:
+		var j jsonObjectEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode object created event: %w", err)
+		}
+		event = &ObjectCreated{
+			EventCore: in.eventCore(j.jsonEventCore),
+			Id:        string(j.Id),
+			Scope:     j.Scope,
+			Id2:       decodeId2(j.Id2),
+		}
+	case PhaseObjectSnapshot:
+		var j jsonObjectEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode object snapshot event: %w", err)
+		}
+		event = &ObjectSnapshot{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:    string(j.Id),
+			Scope: j.Scope,
+			Id2:   decodeId2(j.Id2),
+		}
+	case PhaseObjectDeleted:
+		var j jsonObjectEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode object deleted event: %w", err)
+		}
+		event = &ObjectDeleted{
+			EventCore: in.eventCore(j.jsonEventCore),
+			Id:        string(j.Id),
+			Scope:     j.Scope,
+			Id2:       decodeId2(j.Id2),
+		}
+
+	case PhaseMetadata:
+		var j jsonMetadataEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode metadata event: %w", err)
+		}
+		switch MetadataKind(j.Name) {
+		case MetadataKindProcessName:
+			name, err := requireStrEntry(j.Args, "name")
+			if err != nil {
+				return nil, fmt.Errorf("failed to get process name metadata: %w", err)
+			}
+			event = &MetadataProcessName{
+				EventCore:   in.eventCore(j.jsonEventCore),
+				ProcessName: name,
+			}
+		case MetadataKindProcessLabels:
+			labels, err := requireStrEntry(j.Args, "labels")
+			if err != nil {
+				return nil, fmt.Errorf("failed to get process labels metadata: %w", err)
+			}
+			event = &MetadataProcessLabels{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Labels:    labels,
+			}
+		case MetadataKindProcessSortIndex:
+			sortIndex, err := requireIntEntry(j.Args, "sort_index")
+			if err != nil {
+				return nil, fmt.Errorf("failed to get process sort index metadata: %w", err)
+			}
+			event = &MetadataProcessSortIndex{
+				EventCore: in.eventCore(j.jsonEventCore),
+				SortIndex: sortIndex,
+			}
+		case MetadataKindThreadName:
+			name, err := requireStrEntry(j.Args, "name")
+			if err != nil {
+				return nil, fmt.Errorf("failed to get thread name metadata: %w", err)
+			}
+			event = &MetadataThreadName{
+				EventCore:  in.eventCore(j.jsonEventCore),
+				ThreadName: name,
+			}
+		case MetadataKindThreadSortIndex:
+			sortIndex, err := requireIntEntry(j.Args, "sort_index")
+			if err != nil {
+				return nil, fmt.Errorf("failed to get thread sort index metadata: %w", err)
+			}
+			event = &MetadataThreadSortIndex{
+				EventCore: in.eventCore(j.jsonEventCore),
+				SortIndex: sortIndex,
+			}
+		default:
+			event = &MetadataMisc{
+				EventWithArgs: EventWithArgs{
+					EventCore: in.eventCore(j.jsonEventCore),
+					Args:      j.Args,
+				},
+			}
+		}
+
+	case PhaseGlobalMemoryDump:
+		var j jsonMemoryDumpEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode global memory dump event: %w", err)
+		}
+		event = &GlobalMemoryDump{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+		}
+	case PhaseProcessMemoryDump:
+		var j jsonMemoryDumpEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode process memory dump event: %w", err)
+		}
+		event = &ProcessMemoryDump{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+		}
+
+	case PhaseMark:
+		var j jsonMarkEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode mark event: %w", err)
+		}
+		event = &Mark{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+		}
+
+	case PhaseClockSync:
+		var j jsonClockSyncEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode clock sync event: %w", err)
+		}
+		issueTs, err := getIntEntry(j.Args, "issue_ts")
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract issue timestamp: %w", err)
+		}
+		syncId, err := requireStrEntry(j.Args, "sync_id")
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract sync ID: %w", err)
+		}
+		event = &ClockSync{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			IssueTs: issueTs,
+			SyncId:  syncId,
+		}
+
+	case PhaseContextEnter:
+		var j jsonContextEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode context enter event: %w", err)
+		}
+		event = &ContextEnter{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:  string(j.Id),
+			Id2: decodeId2(j.Id2),
+		}
+	case PhaseContextExit:
+		var j jsonContextEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode context exit event: %w", err)
+		}
+		event = &ContextExit{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:  string(j.Id),
+			Id2: decodeId2(j.Id2),
+		}
+
+	case PhaseLinkIds:
+		var j jsonLinkedIdEvent
+		if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+			return nil, fmt.Errorf("unable to decode linked id event: %w", err)
+		}
+		linkedId, err := requireStrEntry(j.Args, "linked_id")
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract linked ID: %w", err)
+		}
+		event = &LinkIds{
+			EventWithArgs: EventWithArgs{
+				EventCore: in.eventCore(j.jsonEventCore),
+				Args:      j.Args,
+			},
+			Id:       string(j.Id),
+			Id2:      decodeId2(j.Id2),
+			LinkedId: linkedId,
+		}
+
+	default:
+		raw, err := decodeRawEvent(fields, phase, in)
+		if err != nil {
+			return nil, err
+		}
+		return raw, nil
+	}
+
+	if len(extra) > 0 {
+		event.Core().Extra = extra
+	}
+
+	return event, nil
+}
+
+func decodeRawEvent(fields map[string]json.RawMessage, phase Phase, in *Interner) (*Raw, error) {
+	var j jsonEventCore
+	var extra map[string]json.RawMessage
+	if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+		return nil, fmt.Errorf("unable to decode event with phase '%v': %w", phase, err)
+	}
+	raw := &Raw{
+		EventCore: in.eventCore(j),
+		RawPhase:  phase,
+	}
+	if len(extra) > 0 {
+		raw.Extra = extra
+	}
+	return raw, nil
+}
+
+func requireIntEntry(args map[string]interface{}, key string) (int64, error) {
+	v, err := getIntEntry(args, key)
+	if err != nil {
+		return 0, err
+	}
+	if v == nil {
+		return 0, fmt.Errorf("integer '%s' expected but was not found", key)
+	}
+	return *v, nil
+}
+
+func getIntEntry(args map[string]interface{}, key string) (*int64, error) {
+	v, ok := args[key]
+	if !ok {
+		return nil, nil
+	}
+
+	if f, ok := v.(float64); ok {
+		i := int64(f)
+		return &i, nil
+	}
+
+	if s, ok := v.(string); ok {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s to integer '%v'", s, err)
+		}
+
+		return &i, nil
+	}
+
+	return nil, fmt.Errorf("expected number, got '%v': %w", v, ErrInvalidDataType)
+}
+
+func requireStrEntry(args map[string]interface{}, key string) (string, error) {
+	v, err := getStrEntry(args, key)
+	if err != nil {
+		return "", err
+	}
+	if v == nil {
+		return "", fmt.Errorf("string '%s' expected but was not found", key)
+	}
+	return *v, nil
+}
+
+func getStrEntry(args map[string]interface{}, key string) (*string, error) {
+	v, ok := args[key]
+	if !ok {
+		return nil, nil
+	}
+
+	if s, ok := v.(string); ok {
+		return &s, nil
+	}
+
+	return nil, fmt.Errorf("expected string, got '%v': %w", v, ErrInvalidDataType)
+}
+
+func decodeRawStackTrace(trace []string) *StackTrace {
+	if len(trace) < 1 {
+		return nil
+	}
+
+	t := StackTrace{}
+	for _, entry := range trace {
+		t.Trace = append(t.Trace, &StackFrame{
+			Name: entry,
+		})
+	}
+	return &t
+}
+
+func decodeEventPhase(fields map[string]json.RawMessage) (Phase, error) {
+	var phase string
+	if raw, ok := fields["ph"]; ok {
+		if err := json.Unmarshal(raw, &phase); err != nil {
+			return "", fmt.Errorf("unable to decode phase from JSON event: %w", err)
+		}
+	}
+	return Phase(phase), nil
+}
+
+func marshalJsonEvent(event Event) (json.RawMessage, error) {
+	jsonEvent, err := writeJsonEvent(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed while preparing json event: %w", err)
+	}
+	msg, err := json.Marshal(jsonEvent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialise json event: %w", err)
+	}
+	if extra := event.Core().Extra; len(extra) > 0 {
+		msg, err = jsonfields.AppendExtra(msg, extra, jsonfields.Index(reflect.TypeOf(jsonEvent)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to include extra fields in json event: %w", err)
+		}
+	}
+	return msg, nil
+}
+
+func writeJsonEvent(event Event) (interface{}, error) {
+	concrete := event
+	if legacy, ok := event.(legacyPhased); ok {
+		concrete = legacy.Event
+	}
+	concrete, err := withEncodedTypedArgs(concrete)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e := concrete.(type) {
+	case *BeginDuration:
+		return jsonDurationEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+			jsonStackInfo: writeStackInfo(e.EventStackTrace),
+		}, nil
+	case *EndDuration:
+		return jsonDurationEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+			jsonStackInfo: writeStackInfo(e.EventStackTrace),
+		}, nil
+
+	case *Complete:
+		return jsonCompleteEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+			jsonStackInfo:  writeStackInfo(e.EventStackTrace),
+			EndStack:       writeStack(e.EndStackTrace),
+			EndStackFrame:  e.EndStackFrameID,
+			Duration:       micros{whole: e.Duration, fraction: e.DurationFraction},
+			ThreadDuration: toMicros(e.ThreadDuration),
+		}, nil
+
+	case *Instant:
+		if !e.Scope.Valid() {
+			return nil, fmt.Errorf("instant event has scope '%s': %w", e.Scope, ErrInvalidScope)
+		}
+		return jsonInstantEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+			jsonStackInfo: writeStackInfo(e.EventStackTrace),
+			Scope:         string(e.Scope),
+		}, nil
+
+	case *Sample:
+		return jsonSampleEvent{
+			jsonEventCore: writeJsonEventCore(event),
+			jsonStackInfo: writeStackInfo(e.EventStackTrace),
+		}, nil
+
+	case *Counter:
+		j := jsonCounterEvent{
+			jsonEventCore: writeJsonEventCore(event),
+			Id:            flexibleId(e.Id),
+		}
+		if len(e.Values) > 0 {
+			j.Values = &counterValues{values: e.Values, order: e.SeriesOrder()}
+		}
+		return j, nil
+
+	case *AsyncBegin:
+		return jsonAsyncEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+			jsonScopedId: jsonScopedId{
+				jsonId: jsonId{
+					Id:  flexibleId(e.Id),
+					Id2: encodeId2(e.Id2),
+				},
+				Scope: e.Scope,
+			},
+		}, nil
+	case *AsyncInstant:
+		return jsonAsyncEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+			jsonScopedId: jsonScopedId{
+				jsonId: jsonId{
+					Id:  flexibleId(e.Id),
+					Id2: encodeId2(e.Id2),
+				},
+				Scope: e.Scope,
+			},
+		}, nil
+	case *AsyncEnd:
+		return jsonAsyncEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+			jsonScopedId: jsonScopedId{
+				jsonId: jsonId{
+					Id:  flexibleId(e.Id),
+					Id2: encodeId2(e.Id2),
+				},
+				Scope: e.Scope,
+			},
+		}, nil
+
+	case *FlowStart:
+		return jsonFlowEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+			Id:    flexibleId(e.Id),
+			Scope: e.Scope,
+		}, nil
+	case *FlowInstant:
+		return jsonFlowEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+			Id:    flexibleId(e.Id),
+			Scope: e.Scope,
+		}, nil
+	case *FlowFinish:
+		bindingPoint := ""
+		if e.BindingPoint == BindingPointEnclosing {
+			bindingPoint = "e"
+		}
+		return jsonFlowEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+			Id:           flexibleId(e.Id),
+			Scope:        e.Scope,
+			BindingPoint: bindingPoint,
+		}, nil
+
+	case *ObjectCreated:
+		return jsonObjectEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+			},
+			jsonScopedId: jsonScopedId{
+				jsonId: jsonId{
+					Id:  flexibleId(e.Id),
+					Id2: encodeId2(e.Id2),
+				},
+				Scope: e.Scope,
+			},
+		}, nil
+	case *ObjectSnapshot:
+		return jsonObjectEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+			jsonScopedId: jsonScopedId{
+				jsonId: jsonId{
+					Id:  flexibleId(e.Id),
+					Id2: encodeId2(e.Id2),
+				},
+				Scope: e.Scope,
+			},
+		}, nil
+	case *ObjectDeleted:
+		return jsonObjectEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+			},
+			jsonScopedId: jsonScopedId{
+				jsonId: jsonId{
+					Id:  flexibleId(e.Id),
+					Id2: encodeId2(e.Id2),
+				},
+				Scope: e.Scope,
+			},
+		}, nil
+
+	case *MetadataProcessName:
+		return jsonMetadataEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCoreWithName(event, string(MetadataKindProcessName)),
+				Args: map[string]interface{}{
+					"name": e.ProcessName,
+				},
+			},
+		}, nil
+	case *MetadataProcessLabels:
+		return jsonMetadataEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCoreWithName(event, string(MetadataKindProcessLabels)),
+				Args: map[string]interface{}{
+					"labels": e.Labels,
+				},
+			},
+		}, nil
+	case *MetadataProcessSortIndex:
+		return jsonMetadataEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCoreWithName(event, string(MetadataKindProcessSortIndex)),
+				Args: map[string]interface{}{
+					"sort_index": e.SortIndex,
+				},
+			},
+		}, nil
+	case *MetadataThreadName:
+		return jsonMetadataEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCoreWithName(event, string(MetadataKindThreadName)),
+				Args: map[string]interface{}{
+					"name": e.ThreadName,
+				},
+			},
+		}, nil
+	case *MetadataThreadSortIndex:
+		return jsonMetadataEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCoreWithName(event, string(MetadataKindThreadSortIndex)),
+				Args: map[string]interface{}{
+					"sort_index": e.SortIndex,
+				},
+			},
+		}, nil
+	case *MetadataMisc:
+		return jsonMetadataEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+		}, nil
+
+	case *GlobalMemoryDump:
+		return jsonMemoryDumpEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+		}, nil
+	case *ProcessMemoryDump:
+		return jsonMemoryDumpEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+		}, nil
+
+	case *Mark:
+		return jsonMarkEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+		}, nil
+
+	case *ClockSync:
+		return jsonClockSyncEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args: mergeDicts(e.Args, map[string]interface{}{
+					"sync_id":  e.SyncId,
+					"issue_ts": e.IssueTs,
+				}),
+			},
+		}, nil
+
+	case *ContextEnter:
+		return jsonContextEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+			jsonId: jsonId{
+				Id:  flexibleId(e.Id),
+				Id2: encodeId2(e.Id2),
+			},
+		}, nil
+	case *ContextExit:
+		return jsonContextEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args:          e.Args,
+			},
+			jsonId: jsonId{
+				Id:  flexibleId(e.Id),
+				Id2: encodeId2(e.Id2),
+			},
+		}, nil
+
+	case *LinkIds:
+		if e.Id == "" && e.Id2 == nil {
+			return nil, fmt.Errorf("link ids event has neither an id nor an id2: %w", ErrMissingId)
+		}
+		if e.LinkedId == "" {
+			return nil, fmt.Errorf("link ids event has no linked id: %w", ErrMissingId)
+		}
+		return jsonLinkedIdEvent{
+			jsonEventWithArgs: jsonEventWithArgs{
+				jsonEventCore: writeJsonEventCore(event),
+				Args: mergeDicts(e.Args, map[string]interface{}{
+					"linked_id": e.LinkedId,
+				}),
+			},
+			jsonId: jsonId{
+				Id:  flexibleId(e.Id),
+				Id2: encodeId2(e.Id2),
+			},
+		}, nil
+
+	case *Raw:
+		return writeJsonEventCore(event), nil
+	}
+
+	return nil, fmt.Errorf("unknown phase encountered: '%v'", event.Phase())
+}
+
+func mergeDicts(a, b map[string]interface{}) map[string]interface{} {
+	r := map[string]interface{}{}
+	for k, v := range a {
+		if v != nil {
+			r[k] = v
+		}
+	}
+	for k, v := range b {
+		if v != nil {
+			r[k] = v
+		}
+	}
+	return r
+}
+
+func writeStackInfo(trace EventStackTrace) jsonStackInfo {
+	return jsonStackInfo{
+		Stack:      writeStack(trace.StackTrace),
+		StackFrame: trace.StackFrameID,
+	}
+}
+
+func writeStack(trace *StackTrace) []string {
+	var stack []string
+
+	if trace != nil {
+		stack = make([]string, 0, len(trace.Trace))
+		for _, frame := range trace.Trace {
+			stack = append(stack, frame.Name)
+		}
+	}
+
+	return stack
+}
+
+func writeJsonEventCoreWithName(e Event, name string) jsonEventCore {
+	core := writeJsonEventCore(e)
+	core.Name = name
+	return core
+}
+
+func writeJsonEventCore(e Event) jsonEventCore {
+	core := e.Core()
+	return jsonEventCore{
+		jsonEventPhase: jsonEventPhase{
+			Phase: string(e.Phase()),
+		},
+		Name:            core.Name,
+		Categories:      strings.Join(core.Categories, ","),
+		Timestamp:       micros{whole: core.Timestamp, fraction: core.TimestampFraction},
+		ThreadTimestamp: toMicros(core.ThreadTimestamp),
+		ProcessID:       newJsonID(core.ProcessID, core.ProcessRef),
+		ThreadID:        newJsonID(core.ThreadID, core.ThreadRef),
+		Color:           core.Color,
+	}
+}
+
+type jsonEventPhase struct {
+	Phase string `json:"ph"`
+}
+
+type jsonEventCore struct {
+	jsonEventPhase
+	Name            string  `json:"name"`
+	Categories      string  `json:"cat,omitempty"`
+	Timestamp       micros  `json:"ts"`
+	ThreadTimestamp *micros `json:"tts,omitempty"`
+	ProcessID       jsonID  `json:"pid,omitzero"`
+	ThreadID        jsonID  `json:"tid,omitzero"`
+	Color           string  `json:"cname,omitempty"`
+}
+
+type jsonEventWithArgs struct {
+	jsonEventCore
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type jsonStackInfo struct {
+	Stack      []string `json:"stack,omitempty"`
+	StackFrame string   `json:"sf,omitempty"`
+}
+
+type jsonDurationEvent struct {
+	jsonEventWithArgs
+	jsonStackInfo
+}
+
+type jsonCompleteEvent struct {
+	jsonEventWithArgs
+	jsonStackInfo
+	Duration       micros   `json:"dur,omitzero"`
+	ThreadDuration *micros  `json:"tdur,omitempty"`
+	EndStack       []string `json:"estack,omitempty"`
+	EndStackFrame  string   `json:"esf,omitempty"`
+}
+
+type jsonInstantEvent struct {
+	jsonEventWithArgs
+	jsonStackInfo
+	Scope string `json:"s,omitempty"`
+}
+
+type jsonSampleEvent struct {
+	jsonEventCore
+	jsonStackInfo
+}
+
+type jsonCounterEvent struct {
+	jsonEventCore
+	Values *counterValues `json:"args,omitempty"`
+	Id     flexibleId     `json:"id,omitempty"`
+}
+
+// counterValues writes the values of a counter as an object whose keys are in the order of its series
+type counterValues struct {
+	values map[string]float64
+	order  []string
+}
+
+func (c *counterValues) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, name := range c.order {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(c.values[name])
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(append(buf, key...), ':'), value...)
+	}
+	return append(buf, '}'), nil
+}
+
+type numberOrString struct {
+	number float64
+	str    string
+}
+
+func (nos *numberOrString) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &nos.str); err != nil {
+		return json.Unmarshal(data, &nos.number)
+	}
+
+	return nil
+}
+
+type tempJsonCounterEvent struct {
+	jsonEventCore
+	Values orderedCounterValues `json:"args,omitempty"`
+	Id     flexibleId           `json:"id,omitempty"`
+}
+
+// orderedCounterValues reads the values of a counter, keeping the order of their keys
+type orderedCounterValues struct {
+	values map[string]numberOrString
+	order  []string
+}
+
+func (o *orderedCounterValues) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	t, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return nil
+	}
+	if t != json.Delim('{') {
+		return fmt.Errorf("expected counter values to be an object: %w", ErrInvalidDataType)
+	}
+	o.values = map[string]numberOrString{}
+	for decoder.More() {
+		t, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key := t.(string)
+		var value numberOrString
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		if _, seen := o.values[key]; !seen {
+			o.order = append(o.order, key)
+		}
+		o.values[key] = value
+	}
+	_, err = decoder.Token()
+	return err
+}
+
+// series returns the order of the values, or nil when they were in order of their names anyway
+func (o *orderedCounterValues) series() []string {
+	if sort.StringsAreSorted(o.order) {
+		return nil
+	}
+	return o.order
+}
+
+// decodeCounterValues converts counter values, which some tools write as strings, into numbers
+func decodeCounterValues(values map[string]numberOrString) (map[string]float64, error) {
+	result := make(map[string]float64, len(values))
+	for k, numberOrStr := range values {
+		value := numberOrStr.number
+
+		if numberOrStr.str != "" {
+			f, err := strconv.ParseFloat(numberOrStr.str, 64)
+			if err != nil {
+				return nil, err
+			}
+			value = f
+		}
+
+		result[k] = value
+	}
+	return result, nil
+}
+
+type jsonId2 struct {
+	Local  flexibleId `json:"local,omitempty"`
+	Global flexibleId `json:"global,omitempty"`
+}
+
+func decodeId2(j *jsonId2) *Id2 {
+	if j == nil {
+		return nil
+	}
+	return &Id2{Local: string(j.Local), Global: string(j.Global)}
+}
+
+func encodeId2(id2 *Id2) *jsonId2 {
+	if id2 == nil {
+		return nil
+	}
+	return &jsonId2{Local: flexibleId(id2.Local), Global: flexibleId(id2.Global)}
+}
+
+type jsonId struct {
+	Id  flexibleId `json:"id,omitempty"`
+	Id2 *jsonId2   `json:"id2,omitempty"`
+}
+
+type jsonScopedId struct {
+	jsonId
+	Scope string `json:"scope,omitempty"`
+}
+
+type jsonAsyncEvent struct {
+	jsonEventWithArgs
+	jsonScopedId
+}
+
+// flexibleId is an id that may be written as either a string or a number, which is kept as its literal text
+type flexibleId string
+
+func (id *flexibleId) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = flexibleId(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("expected id to be a string or number: %w", ErrInvalidDataType)
+	}
+	*id = flexibleId(n)
+	return nil
+}
+
+type jsonFlowEvent struct {
+	jsonEventWithArgs
+	Id           flexibleId `json:"id,omitempty"`
+	Scope        string     `json:"scope,omitempty"`
+	BindingPoint string     `json:"bp,omitempty"`
+}
+
+type jsonObjectEvent struct {
+	jsonEventWithArgs
+	jsonScopedId
+}
+
+type jsonMetadataEvent struct {
+	jsonEventWithArgs
+}
+
+type jsonMemoryDumpEvent struct {
+	jsonEventWithArgs
+}
+
+type jsonMarkEvent struct {
+	jsonEventWithArgs
+}
+
+type jsonClockSyncEvent struct {
+	jsonEventWithArgs
+}
+
+type jsonContextEvent struct {
+	jsonEventWithArgs
+	jsonId
+}
+
+type jsonLinkedIdEvent struct {
+	jsonEventWithArgs
+	jsonId
+}
+
+// normalizeInstantScope returns the scope as written in a file, with the uppercase letters that some older producers
+// wrote in place of the lowercase ones converted, and a missing scope read as global as viewers read it
+func normalizeInstantScope(raw string) InstantScope {
+	switch raw {
+	case "":
+		return InstantScopeGlobal
+	case "T", "P", "G":
+		return InstantScope(strings.ToLower(raw))
+	}
+	return InstantScope(raw)
+}
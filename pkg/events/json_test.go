@@ -0,0 +1,343 @@
+package events_test
+
+import (
+	"encoding/json"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+var _ = Describe("JSON encoding", func() {
+	pid := int64(1)
+	tid := int64(2)
+
+	complete := events.Complete{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{
+				Name:       "work",
+				Categories: []string{"a", "b"},
+				Timestamp:  10,
+				ProcessID:  &pid,
+				ThreadID:   &tid,
+			},
+			Args: map[string]interface{}{"n": 5.0},
+		},
+		Duration: 20,
+	}
+
+	It("encodes events as they appear in trace files", func() {
+		encoded, err := json.Marshal(complete)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(encoded).To(MatchJSON(`{
+			"ph": "X", "name": "work", "cat": "a,b", "ts": 10, "pid": 1, "tid": 2, "dur": 20, "args": {"n": 5}
+		}`))
+	})
+
+	It("round trips events embedded in other documents", func() {
+		type document struct {
+			Label string                     `json:"label"`
+			Event events.Complete            `json:"event"`
+			Name  *events.MetadataThreadName `json:"name"`
+		}
+		original := document{
+			Label: "example",
+			Event: complete,
+			Name: &events.MetadataThreadName{
+				EventCore:  events.EventCore{Name: "thread_name", Categories: []string{}, ProcessID: &pid, ThreadID: &tid},
+				ThreadName: "main",
+			},
+		}
+
+		encoded, err := json.Marshal(original)
+		Expect(err).ToNot(HaveOccurred())
+
+		var decoded document
+		Expect(json.Unmarshal(encoded, &decoded)).To(Succeed())
+		Expect(decoded).To(Equal(original))
+	})
+
+	It("preserves fields it does not understand", func() {
+		var instant events.Instant
+		Expect(json.Unmarshal([]byte(`{"ph":"i","name":"x","ts":1,"s":"g","custom":[1,2]}`), &instant)).To(Succeed())
+		Expect(instant.Scope).To(Equal(events.InstantScopeGlobal))
+		Expect(instant.Extra).To(HaveKey("custom"))
+
+		encoded, err := json.Marshal(instant)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(encoded).To(MatchJSON(`{"ph":"I","name":"x","ts":1,"s":"g","custom":[1,2]}`))
+	})
+
+	It("reads and writes the args of instant events", func() {
+		var instant events.Instant
+		Expect(json.Unmarshal([]byte(`{"ph":"i","name":"x","ts":1,"s":"t","args":{"n":1}}`), &instant)).To(Succeed())
+		Expect(instant.Args).To(Equal(map[string]interface{}{"n": 1.0}))
+		Expect(instant.Extra).To(BeNil())
+
+		encoded, err := json.Marshal(instant)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(encoded).To(MatchJSON(`{"ph":"I","name":"x","ts":1,"s":"t","args":{"n":1}}`))
+	})
+
+	It("refuses to decode an event of a different type", func() {
+		var begin events.BeginDuration
+		err := json.Unmarshal([]byte(`{"ph":"E","name":"x","ts":1}`), &begin)
+		Expect(err).To(MatchError(events.ErrInvalidDataType))
+	})
+
+	It("decodes events of any type through UnmarshalEvent", func() {
+		event, err := events.UnmarshalEvent([]byte(`{"ph":"C","name":"mem","ts":3,"args":{"used":"12"}}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(event).To(BeAssignableToTypeOf(&events.Counter{}))
+		Expect(event.(*events.Counter).Values).To(Equal(map[string]float64{"used": 12}))
+	})
+
+	It("decodes the id and color of counters", func() {
+		var counter events.Counter
+		Expect(json.Unmarshal([]byte(`{"ph":"C","name":"mem","ts":3,"id":4,"cname":"bad","args":{"used":1}}`),
+			&counter)).To(Succeed())
+		Expect(counter.Id).To(Equal("4"))
+		Expect(counter.Color).To(Equal("bad"))
+		Expect(counter.Extra).To(BeEmpty())
+	})
+
+	DescribeTable("round trips the identifiers of async, object and context events",
+		func(encoded string, expected events.Event) {
+			event, err := events.UnmarshalEvent([]byte(encoded))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(event).To(Equal(expected))
+
+			reencoded, err := events.MarshalEvent(event)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reencoded).To(MatchJSON(encoded))
+		},
+		Entry("async begin", `{"ph":"b","name":"a","cat":"c","ts":1,"id":"0x1","scope":"s"}`,
+			&events.AsyncBegin{EventWithArgs: events.EventWithArgs{EventCore: idCore("a")}, Id: "0x1", Scope: "s"}),
+		Entry("async instant", `{"ph":"n","name":"a","cat":"c","ts":1,"id2":{"local":"0x2"}}`,
+			&events.AsyncInstant{
+				EventWithArgs: events.EventWithArgs{EventCore: idCore("a")},
+				Id2:           &events.Id2{Local: "0x2"},
+			}),
+		Entry("async end", `{"ph":"e","name":"a","cat":"c","ts":1,"id2":{"global":"0x3"}}`,
+			&events.AsyncEnd{EventWithArgs: events.EventWithArgs{EventCore: idCore("a")}, Id2: &events.Id2{Global: "0x3"}}),
+		Entry("object created", `{"ph":"N","name":"o","cat":"c","ts":1,"id":"0x4","scope":"s"}`,
+			&events.ObjectCreated{EventCore: idCore("o"), Id: "0x4", Scope: "s"}),
+		Entry("object snapshot", `{"ph":"O","name":"o","cat":"c","ts":1,"id":"0x4","args":{"snapshot":{}}}`,
+			&events.ObjectSnapshot{
+				EventWithArgs: events.EventWithArgs{EventCore: idCore("o"), Args: map[string]interface{}{"snapshot": map[string]interface{}{}}},
+				Id:            "0x4",
+			}),
+		Entry("object deleted", `{"ph":"D","name":"o","cat":"c","ts":1,"id2":{"local":"0x4"}}`,
+			&events.ObjectDeleted{EventCore: idCore("o"), Id2: &events.Id2{Local: "0x4"}}),
+		Entry("context enter", `{"ph":"(","name":"ctx","cat":"c","ts":1,"id":"0x5"}`,
+			&events.ContextEnter{EventWithArgs: events.EventWithArgs{EventCore: idCore("ctx")}, Id: "0x5"}),
+		Entry("context exit", `{"ph":")","name":"ctx","cat":"c","ts":1,"id2":{"global":"0x5"}}`,
+			&events.ContextExit{EventWithArgs: events.EventWithArgs{EventCore: idCore("ctx")}, Id2: &events.Id2{Global: "0x5"}}),
+	)
+
+	It("round trips the ids of link events", func() {
+		encoded := `{"ph":"=","name":"link","ts":1,"id":"0x1","args":{"linked_id":"0x2"}}`
+		event, err := events.UnmarshalEvent([]byte(encoded))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(event).To(BeAssignableToTypeOf(&events.LinkIds{}))
+		link := event.(*events.LinkIds)
+		Expect(link.Id).To(Equal("0x1"))
+		Expect(link.LinkedId).To(Equal("0x2"))
+
+		reencoded, err := events.MarshalEvent(event)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reencoded).To(MatchJSON(encoded))
+	})
+
+	It("decodes the id2 of link events", func() {
+		event, err := events.UnmarshalEvent([]byte(`{"ph":"=","name":"link","ts":1,"id2":{"local":"0x1"},"args":{"linked_id":"0x2"}}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(event.(*events.LinkIds).Id2).To(Equal(&events.Id2{Local: "0x1"}))
+	})
+
+	DescribeTable("refuses to write link events without both of their ids",
+		func(link *events.LinkIds) {
+			_, err := events.MarshalEvent(link)
+			Expect(errors.Is(err, events.ErrMissingId)).To(BeTrue())
+		},
+		Entry("no id", &events.LinkIds{EventWithArgs: events.EventWithArgs{EventCore: idCore("link")}, LinkedId: "0x2"}),
+		Entry("no linked id", &events.LinkIds{EventWithArgs: events.EventWithArgs{EventCore: idCore("link")}, Id: "0x1"}),
+	)
+
+	It("decodes numeric async ids", func() {
+		event, err := events.UnmarshalEvent([]byte(`{"ph":"S","name":"a","ts":1,"id":7}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(event).To(BeAssignableToTypeOf(&events.AsyncBegin{}))
+		Expect(event.(*events.AsyncBegin).Id).To(Equal("7"))
+	})
+
+	It("keeps the order of the series of counters", func() {
+		var counter events.Counter
+		Expect(json.Unmarshal([]byte(`{"ph":"C","name":"mem","ts":3,"args":{"used":1,"free":2,"cached":3}}`),
+			&counter)).To(Succeed())
+		Expect(counter.Series).To(Equal([]string{"used", "free", "cached"}))
+
+		encoded, err := json.Marshal(counter)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(encoded)).To(ContainSubstring(`"args":{"used":1,"free":2,"cached":3}`))
+	})
+
+	It("leaves the series of counters in order of their names unordered", func() {
+		var counter events.Counter
+		Expect(json.Unmarshal([]byte(`{"ph":"C","name":"mem","ts":3,"args":{"a":1,"b":2}}`), &counter)).To(Succeed())
+		Expect(counter.Series).To(BeNil())
+	})
+
+	It("orders the series of counters missing from Series by name", func() {
+		counter := events.Counter{
+			Values: map[string]float64{"a": 1, "b": 2, "c": 3, "d": 4},
+			Series: []string{"c", "missing", "a", "c"},
+		}
+		Expect(counter.SeriesOrder()).To(Equal([]string{"c", "a", "b", "d"}))
+	})
+
+	It("decodes events of any phase as Raw events", func() {
+		var raw events.Raw
+		Expect(json.Unmarshal([]byte(`{"ph":"X","name":"x","ts":1,"dur":2}`), &raw)).To(Succeed())
+		Expect(raw.Phase()).To(Equal(events.PhaseComplete))
+		Expect(raw.Extra).To(HaveKeyWithValue("dur", json.RawMessage("2")))
+
+		encoded, err := json.Marshal(raw)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(encoded).To(MatchJSON(`{"ph":"X","name":"x","ts":1,"dur":2}`))
+	})
+
+	DescribeTable("round trips timestamps and durations with fractions of a microsecond",
+		func(ts, dur string, whole, duration int64, fraction, durationFraction float64) {
+			encoded := `{"ph":"X","name":"x","ts":` + ts + `,"dur":` + dur + `}`
+			event, err := events.UnmarshalEvent([]byte(encoded))
+			Expect(err).ToNot(HaveOccurred())
+			c := event.(*events.Complete)
+			Expect(c.Timestamp).To(Equal(whole))
+			Expect(c.TimestampFraction).To(BeNumerically("~", fraction, 1e-9))
+			Expect(c.Duration).To(Equal(duration))
+			Expect(c.DurationFraction).To(BeNumerically("~", durationFraction, 1e-9))
+
+			marshalled, err := events.MarshalEvent(c)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(marshalled)).To(Equal(encoded))
+			appended, err := events.AppendEvent(nil, c)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(appended)).To(Equal(encoded))
+		},
+		Entry("whole microseconds", "12", "3", int64(12), int64(3), 0.0, 0.0),
+		Entry("fractions", "123.456", "7.5", int64(123), int64(7), 0.456, 0.5),
+		Entry("timestamps relative to the epoch", "1700000000123456.789", "0.001",
+			int64(1700000000123456), int64(0), 0.789, 0.001),
+		Entry("negative timestamps", "-1.25", "2", int64(-2), int64(2), 0.75, 0.0),
+	)
+
+	It("reads thread timestamps with fractions of a microsecond as whole microseconds", func() {
+		event, err := events.UnmarshalEvent([]byte(`{"ph":"X","name":"x","ts":1e3,"tts":3.7,"dur":2,"tdur":1.5}`))
+		Expect(err).ToNot(HaveOccurred())
+		c := event.(*events.Complete)
+		Expect(c.Timestamp).To(Equal(int64(1000)))
+		Expect(*c.ThreadTimestamp).To(Equal(int64(3)))
+		Expect(*c.ThreadDuration).To(Equal(int64(1)))
+
+		_, err = events.UnmarshalEvent([]byte(`{"ph":"i","name":"x","ts":"soon"}`))
+		Expect(err).To(MatchError(events.ErrInvalidDataType))
+	})
+
+	DescribeTable("round trips process and thread IDs that are not int64s",
+		func(pid, tid string, processRef, threadRef *events.IDRef) {
+			encoded := `{"ph":"I","name":"x","ts":1,"pid":` + pid + `,"tid":` + tid + `,"s":"t"}`
+			event, err := events.UnmarshalEvent([]byte(encoded))
+			Expect(err).ToNot(HaveOccurred())
+			core := event.Core()
+			Expect(core.ProcessRef).To(Equal(processRef))
+			Expect(core.ThreadRef).To(Equal(threadRef))
+			if processRef != nil {
+				Expect(*core.ProcessID).To(Equal(processRef.ID()))
+			}
+			if threadRef != nil {
+				Expect(*core.ThreadID).To(Equal(threadRef.ID()))
+			}
+
+			marshalled, err := events.MarshalEvent(event)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(marshalled)).To(Equal(encoded))
+			appended, err := events.AppendEvent(nil, event)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(appended)).To(Equal(encoded))
+		},
+		Entry("int64s", "1", "2", nil, nil),
+		Entry("labels", `"Browser"`, `"CrRendererMain"`,
+			&events.IDRef{Label: "Browser"}, &events.IDRef{Label: "CrRendererMain"}),
+		Entry("numeric strings", `"12"`, "3", &events.IDRef{Label: "12"}, nil),
+		Entry("numbers too large for an int64", "1", "18446744073709551615",
+			nil, &events.IDRef{Label: "18446744073709551615", Numeric: true}),
+	)
+
+	It("stands in for IDs that are not int64s consistently", func() {
+		Expect(events.IDRef{Label: "12"}.ID()).To(Equal(int64(12)))
+		Expect(events.IDRef{Label: "18446744073709551615", Numeric: true}.ID()).To(Equal(int64(-1)))
+		browser := events.IDRef{Label: "Browser"}.ID()
+		Expect(browser).To(BeNumerically("<", 0))
+		Expect(events.IDRef{Label: "Browser"}.ID()).To(Equal(browser))
+		Expect(events.IDRef{Label: "GPU"}.ID()).ToNot(Equal(browser))
+		ref, id := events.NewIDRef("Browser")
+		Expect(ref).To(Equal(&events.IDRef{Label: "Browser"}))
+		Expect(id).To(Equal(browser))
+	})
+
+	It("writes IDs changed after being read as they were changed to", func() {
+		event, err := events.UnmarshalEvent([]byte(`{"ph":"I","name":"x","ts":1,"pid":"Browser","s":"t"}`))
+		Expect(err).ToNot(HaveOccurred())
+		remapped := int64(7)
+		event.Core().ProcessID = &remapped
+		marshalled, err := events.MarshalEvent(event)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(marshalled)).To(Equal(`{"ph":"I","name":"x","ts":1,"pid":7,"s":"t"}`))
+	})
+
+	It("refuses IDs that are neither numbers nor strings", func() {
+		_, err := events.UnmarshalEvent([]byte(`{"ph":"I","name":"x","ts":1,"pid":true}`))
+		Expect(err).To(MatchError(events.ErrInvalidDataType))
+		_, err = events.UnmarshalEvent([]byte(`{"ph":"I","name":"x","ts":1,"tid":1.5}`))
+		Expect(err).To(MatchError(events.ErrInvalidDataType))
+	})
+
+	DescribeTable("normalizes the scopes of instant events",
+		func(scope string, expected events.InstantScope) {
+			event, err := events.UnmarshalEvent([]byte(`{"ph":"I","name":"x","ts":1` + scope + `}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(event.(*events.Instant).Scope).To(Equal(expected))
+		},
+		Entry("thread", `,"s":"t"`, events.InstantScopeThread),
+		Entry("legacy uppercase thread", `,"s":"T"`, events.InstantScopeThread),
+		Entry("legacy uppercase process", `,"s":"P"`, events.InstantScopeProcess),
+		Entry("legacy uppercase global", `,"s":"G"`, events.InstantScopeGlobal),
+		Entry("missing", ``, events.InstantScopeGlobal),
+	)
+
+	It("refuses to write instant events of unknown scopes", func() {
+		event := &events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "x"}},
+			Scope:         "sometimes",
+		}
+		_, err := events.MarshalEvent(event)
+		Expect(err).To(MatchError(events.ErrInvalidScope))
+		_, err = events.AppendEvent(nil, event)
+		Expect(err).To(MatchError(events.ErrInvalidScope))
+		Expect(events.InstantScope("").Valid()).To(BeTrue())
+		Expect(events.InstantScope("T").Valid()).To(BeFalse())
+	})
+
+	It("reports invalid JSON", func() {
+		_, err := events.UnmarshalEvent([]byte(`{"ph":"C",`))
+		Expect(err).To(BeAssignableToTypeOf(&json.SyntaxError{}))
+	})
+})
+
+func idCore(name string) events.EventCore {
+	return events.EventCore{Name: name, Categories: []string{"c"}, Timestamp: 1}
+}
@@ -0,0 +1,89 @@
+package events
+
+// NewProcessName creates a metadata event naming the process with the given ID
+func NewProcessName(pid int64, name string) *MetadataProcessName {
+	return &MetadataProcessName{
+		EventCore:   metadataCore(MetadataKindProcessName, &pid, nil),
+		ProcessName: name,
+	}
+}
+
+// NewProcessLabels creates a metadata event labelling the process with the given ID
+func NewProcessLabels(pid int64, labels string) *MetadataProcessLabels {
+	return &MetadataProcessLabels{
+		EventCore: metadataCore(MetadataKindProcessLabels, &pid, nil),
+		Labels:    labels,
+	}
+}
+
+// NewProcessSortIndex creates a metadata event controlling where the process with the given ID is drawn relative to
+// other processes, lower indices being drawn higher
+func NewProcessSortIndex(pid, index int64) *MetadataProcessSortIndex {
+	return &MetadataProcessSortIndex{
+		EventCore: metadataCore(MetadataKindProcessSortIndex, &pid, nil),
+		SortIndex: index,
+	}
+}
+
+// NewThreadName creates a metadata event naming the thread with the given ID in the process with the given ID
+func NewThreadName(pid, tid int64, name string) *MetadataThreadName {
+	return &MetadataThreadName{
+		EventCore:  metadataCore(MetadataKindThreadName, &pid, &tid),
+		ThreadName: name,
+	}
+}
+
+// NewThreadSortIndex creates a metadata event controlling where the thread with the given ID is drawn relative to
+// the other threads of the process with the given ID, lower indices being drawn higher
+func NewThreadSortIndex(pid, tid, index int64) *MetadataThreadSortIndex {
+	return &MetadataThreadSortIndex{
+		EventCore: metadataCore(MetadataKindThreadSortIndex, &pid, &tid),
+		SortIndex: index,
+	}
+}
+
+// NewStackFrameMetadata creates a metadata event carrying one of a trace's stack frames, with the given ID, in its
+// args, so that writers which stream events can still convey stack frames that events refer to by ID
+func NewStackFrameMetadata(id string, frame *StackFrame) *MetadataMisc {
+	args := map[string]interface{}{
+		"id":       id,
+		"category": frame.Category,
+		"name":     frame.Name,
+	}
+	if frame.Parent != "" {
+		args["parent"] = frame.Parent
+	}
+	return &MetadataMisc{
+		EventWithArgs: EventWithArgs{
+			EventCore: metadataCore(MetadataKindStackFrame, nil, nil),
+			Args:      args,
+		},
+	}
+}
+
+// StackFrameFromMetadata recovers the stack frame carried by an event created by NewStackFrameMetadata, reporting
+// whether the event is one
+func StackFrameFromMetadata(e Event) (string, *StackFrame, bool) {
+	misc, ok := e.(*MetadataMisc)
+	if !ok || misc.Name != string(MetadataKindStackFrame) {
+		return "", nil, false
+	}
+	id, ok := misc.Args["id"].(string)
+	if !ok || id == "" {
+		return "", nil, false
+	}
+	frame := &StackFrame{}
+	frame.Category, _ = misc.Args["category"].(string)
+	frame.Name, _ = misc.Args["name"].(string)
+	frame.Parent, _ = misc.Args["parent"].(string)
+	return id, frame, true
+}
+
+// metadataCore populates the fields common to metadata events, which are named after their kind
+func metadataCore(kind MetadataKind, pid, tid *int64) EventCore {
+	return EventCore{
+		Name:      string(kind),
+		ProcessID: pid,
+		ThreadID:  tid,
+	}
+}
@@ -0,0 +1,58 @@
+package events_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+var _ = Describe("Metadata builders", func() {
+	DescribeTable("encode metadata events as they appear in trace files",
+		func(e events.Event, expected string) {
+			encoded, err := json.Marshal(e)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(encoded).To(MatchJSON(expected))
+		},
+		Entry("process names", events.NewProcessName(1, "server"),
+			`{"ph": "M", "name": "process_name", "ts": 0, "pid": 1, "args": {"name": "server"}}`),
+		Entry("process labels", events.NewProcessLabels(1, "canary"),
+			`{"ph": "M", "name": "process_labels", "ts": 0, "pid": 1, "args": {"labels": "canary"}}`),
+		Entry("process sort indices", events.NewProcessSortIndex(1, 3),
+			`{"ph": "M", "name": "process_sort_index", "ts": 0, "pid": 1, "args": {"sort_index": 3}}`),
+		Entry("thread names", events.NewThreadName(1, 2, "worker"),
+			`{"ph": "M", "name": "thread_name", "ts": 0, "pid": 1, "tid": 2, "args": {"name": "worker"}}`),
+		Entry("thread sort indices", events.NewThreadSortIndex(1, 2, -1),
+			`{"ph": "M", "name": "thread_sort_index", "ts": 0, "pid": 1, "tid": 2, "args": {"sort_index": -1}}`),
+		Entry("stack frames", events.NewStackFrameMetadata("2", &events.StackFrame{Category: "main.go", Name: "main", Parent: "1"}),
+			`{"ph": "M", "name": "stack_frame", "ts": 0, "args": {"id": "2", "category": "main.go", "name": "main", "parent": "1"}}`),
+	)
+
+	It("recovers stack frames from metadata events once they have been read back", func() {
+		frame := &events.StackFrame{Category: "main.go", Name: "main"}
+		encoded, err := json.Marshal(events.NewStackFrameMetadata("1", frame))
+		Expect(err).ToNot(HaveOccurred())
+		decoded, err := events.UnmarshalEvent(encoded)
+		Expect(err).ToNot(HaveOccurred())
+
+		id, recovered, ok := events.StackFrameFromMetadata(decoded)
+		Expect(ok).To(BeTrue())
+		Expect(id).To(Equal("1"))
+		Expect(recovered).To(Equal(frame))
+
+		_, _, ok = events.StackFrameFromMetadata(events.NewProcessName(1, "server"))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("does not share IDs between events", func() {
+		first := events.NewThreadName(1, 2, "a")
+		second := events.NewThreadName(3, 4, "b")
+		Expect(*first.ProcessID).To(Equal(int64(1)))
+		Expect(*first.ThreadID).To(Equal(int64(2)))
+		Expect(*second.ProcessID).To(Equal(int64(3)))
+		Expect(*second.ThreadID).To(Equal(int64(4)))
+	})
+})
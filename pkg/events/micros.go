@@ -0,0 +1,110 @@
+package events
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// micros is a time in microseconds as it is written in the fields of events, usually a whole number but with a
+// fraction of a microsecond in traces of greater precision, such as Chrome's. The fraction is always in [0, 1), so
+// that the whole part is the time rounded down.
+type micros struct {
+	whole    int64
+	fraction float64
+}
+
+func (m micros) MarshalJSON() ([]byte, error) {
+	return appendMicros(nil, m.whole, m.fraction), nil
+}
+
+// UnmarshalJSON decodes the time, reading the whole and fractional parts of a decimal separately so that the precision
+// of the fraction is not lost to the size of the whole part, as timestamps relative to the epoch would otherwise lose it
+func (m *micros) UnmarshalJSON(data []byte) error {
+	// converting the data to a string in the call itself keeps whole numbers, by far the commonest, from allocating
+	if whole, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+		*m = micros{whole: whole}
+		return nil
+	}
+
+	s := string(data)
+
+	dot := strings.IndexByte(s, '.')
+	if dot > 0 && !strings.ContainsAny(s, "eE") {
+		whole, err := strconv.ParseInt(s[:dot], 10, 64)
+		fraction, fractionErr := strconv.ParseFloat("0"+s[dot:], 64)
+		if err == nil && fractionErr == nil {
+			if s[0] == '-' && fraction > 0 {
+				whole, fraction = whole-1, 1-fraction
+			}
+			*m = micros{whole: whole, fraction: fraction}
+			return nil
+		}
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < math.MinInt64 || f >= 1<<63 {
+		return fmt.Errorf("'%s' is not a time in microseconds: %w", s, ErrInvalidDataType)
+	}
+	whole := math.Floor(f)
+	*m = micros{whole: int64(whole), fraction: f - whole}
+	return nil
+}
+
+// wholePtr returns the whole microseconds of the time, if there is one, discarding any fraction
+func (m *micros) wholePtr() *int64 {
+	if m == nil {
+		return nil
+	}
+	return &m.whole
+}
+
+// toMicros returns the whole microseconds as a time, if there are any
+func toMicros(whole *int64) *micros {
+	if whole == nil {
+		return nil
+	}
+	return &micros{whole: *whole}
+}
+
+// appendMicros appends the time as a JSON number, a whole number unless it has a fraction of a microsecond
+func appendMicros(buf []byte, whole int64, fraction float64) []byte {
+	if fraction <= 0 || fraction >= 1 {
+		return strconv.AppendInt(buf, whole, 10)
+	}
+	if whole >= 0 {
+		buf = strconv.AppendInt(buf, whole, 10)
+		return append(buf, strconv.FormatFloat(fraction, 'f', -1, 64)[1:]...)
+	}
+	// negative times are written as the whole microseconds towards zero and the fraction beyond them
+	remainder := 1 - fraction
+	if remainder >= 1 {
+		return strconv.AppendInt(buf, whole, 10)
+	}
+	buf = append(buf, '-')
+	buf = strconv.AppendInt(buf, -(whole + 1), 10)
+	return append(buf, strconv.FormatFloat(remainder, 'f', -1, 64)[1:]...)
+}
+
+// JsonMicros is a time in microseconds as it is written in the fields of events, for packages that decode events
+// themselves rather than through UnmarshalEvent, so that they accept the same times that it does
+type JsonMicros struct {
+	// Whole is the time rounded down to a whole number of microseconds
+	Whole int64
+	// Fraction is the fraction of a microsecond, from 0 up to but not including 1, that the time is beyond Whole
+	Fraction float64
+}
+
+func (m JsonMicros) MarshalJSON() ([]byte, error) {
+	return appendMicros(nil, m.Whole, m.Fraction), nil
+}
+
+func (m *JsonMicros) UnmarshalJSON(data []byte) error {
+	var decoded micros
+	if err := decoded.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	*m = JsonMicros{Whole: decoded.whole, Fraction: decoded.fraction}
+	return nil
+}
@@ -0,0 +1,53 @@
+package events
+
+import "sync"
+
+// The events emitted most often when tracing can be taken from pools and released back into them once nothing refers
+// to them any more, so that tracing at a high rate, of hundreds of thousands of events a second, does not leave the
+// garbage collector an event to clean up for everything that happened.
+
+var beginDurationPool = sync.Pool{New: func() interface{} { return &BeginDuration{} }}
+var endDurationPool = sync.Pool{New: func() interface{} { return &EndDuration{} }}
+var completePool = sync.Pool{New: func() interface{} { return &Complete{} }}
+var instantPool = sync.Pool{New: func() interface{} { return &Instant{} }}
+
+// AcquireBeginDuration takes an empty BeginDuration from a pool, to be given back with Release
+func AcquireBeginDuration() *BeginDuration {
+	return beginDurationPool.Get().(*BeginDuration)
+}
+
+// AcquireEndDuration takes an empty EndDuration from a pool, to be given back with Release
+func AcquireEndDuration() *EndDuration {
+	return endDurationPool.Get().(*EndDuration)
+}
+
+// AcquireComplete takes an empty Complete from a pool, to be given back with Release
+func AcquireComplete() *Complete {
+	return completePool.Get().(*Complete)
+}
+
+// AcquireInstant takes an empty Instant from a pool, to be given back with Release
+func AcquireInstant() *Instant {
+	return instantPool.Get().(*Instant)
+}
+
+// Release empties the event and returns it to the pool for its type, so that it can be acquired again, doing nothing
+// for types of event that are not pooled. The event must not be used once released, so must not be released while
+// anything else refers to it, such as an EventWriter that keeps the events written to it. Values the event points to,
+// such as its IDs, are left alone.
+func Release(e Event) {
+	switch event := e.(type) {
+	case *BeginDuration:
+		*event = BeginDuration{}
+		beginDurationPool.Put(event)
+	case *EndDuration:
+		*event = EndDuration{}
+		endDurationPool.Put(event)
+	case *Complete:
+		*event = Complete{}
+		completePool.Put(event)
+	case *Instant:
+		*event = Instant{}
+		instantPool.Put(event)
+	}
+}
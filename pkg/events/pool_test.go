@@ -0,0 +1,50 @@
+package events_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+var _ = Describe("Event pools", func() {
+	It("empties events as they are released", func() {
+		pid := int64(1)
+		for i := 0; i < 10; i++ {
+			e := events.AcquireInstant()
+			Expect(*e).To(Equal(events.Instant{}))
+			e.Name = "tick"
+			e.ProcessID = &pid
+			e.Scope = events.InstantScopeGlobal
+			events.Release(e)
+		}
+		Expect(pid).To(Equal(int64(1)))
+	})
+
+	It("hands out empty events of each pooled type", func() {
+		begin := events.AcquireBeginDuration()
+		end := events.AcquireEndDuration()
+		complete := events.AcquireComplete()
+		Expect(*begin).To(Equal(events.BeginDuration{}))
+		Expect(*end).To(Equal(events.EndDuration{}))
+		Expect(*complete).To(Equal(events.Complete{}))
+		events.Release(begin)
+		events.Release(end)
+		events.Release(complete)
+	})
+
+	It("ignores events that are not pooled", func() {
+		counter := &events.Counter{EventCore: events.EventCore{Name: "mem"}}
+		events.Release(counter)
+		Expect(counter.Name).To(Equal("mem"))
+	})
+
+	It("reuses released events rather than allocating", func() {
+		allocs := testing.AllocsPerRun(100, func() {
+			events.Release(events.AcquireBeginDuration())
+		})
+		Expect(allocs).To(BeZero())
+	})
+})
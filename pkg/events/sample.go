@@ -0,0 +1,89 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/omaskery/teffy/internal/jsonfields"
+)
+
+// ProfileSample is an entry of the samples array of a JSON Object Format file, which sampling profilers use to record
+// the stacks of threads apart from the trace events. Unlike the Sample event, a ProfileSample has no phase and no
+// process, its stack always being given by reference to the file's stack frames.
+type ProfileSample struct {
+	// CPU is the processor the thread was running on, if it was recorded
+	CPU *int64
+	// ThreadID is the thread that was sampled
+	ThreadID int64
+	// Timestamp is when the sample was taken
+	Timestamp int64
+	// Name describes what triggered the sample, such as the performance counter that was being sampled
+	Name string
+	// StackFrameID refers to the innermost frame of the sampled stack in the file's stack frames
+	StackFrameID string
+	// Weight is how many samples this sample stands for, a missing weight meaning one
+	Weight *int64
+	// Extra holds any fields of the sample that are not otherwise understood, so they are preserved when it is written
+	Extra map[string]json.RawMessage
+}
+
+type jsonProfileSample struct {
+	CPU        *int64          `json:"cpu,omitempty"`
+	ThreadID   int64           `json:"tid"`
+	Timestamp  int64           `json:"ts"`
+	Name       string          `json:"name,omitempty"`
+	StackFrame json.RawMessage `json:"sf"`
+	Weight     *int64          `json:"weight,omitempty"`
+}
+
+func (s ProfileSample) MarshalJSON() ([]byte, error) {
+	// stack frame IDs are usually numbers, which are written back out as they were read
+	sf := strconv.Quote(s.StackFrameID)
+	if _, err := strconv.ParseUint(s.StackFrameID, 10, 64); err == nil {
+		sf = s.StackFrameID
+	}
+	j := jsonProfileSample{
+		CPU:        s.CPU,
+		ThreadID:   s.ThreadID,
+		Timestamp:  s.Timestamp,
+		Name:       s.Name,
+		StackFrame: json.RawMessage(sf),
+		Weight:     s.Weight,
+	}
+	msg, err := json.Marshal(j)
+	if err != nil || len(s.Extra) < 1 {
+		return msg, err
+	}
+	return jsonfields.AppendExtra(msg, s.Extra, jsonfields.Index(reflect.TypeOf(j)))
+}
+
+func (s *ProfileSample) UnmarshalJSON(data []byte) error {
+	fields, err := jsonfields.Split(data)
+	if err != nil {
+		return fmt.Errorf("expected sample to be a JSON object: %w", ErrInvalidDataType)
+	}
+	var j jsonProfileSample
+	var extra map[string]json.RawMessage
+	if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+		return fmt.Errorf("unable to decode sample: %w", err)
+	}
+	var sf flexibleId
+	if len(j.StackFrame) > 0 {
+		if err := json.Unmarshal(j.StackFrame, &sf); err != nil {
+			return fmt.Errorf("unable to decode sample stack frame: %w", err)
+		}
+	}
+
+	*s = ProfileSample{
+		CPU:          j.CPU,
+		ThreadID:     j.ThreadID,
+		Timestamp:    j.Timestamp,
+		Name:         j.Name,
+		StackFrameID: string(sf),
+		Weight:       j.Weight,
+		Extra:        extra,
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// filter provides predicates for selecting the events of interest from a trace
+package filter
+
+import (
+	"regexp"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// Predicate reports whether an event should be kept
+type Predicate = func(e events.Event) bool
+
+// Apply returns a copy of the trace containing only the events matching the predicate, all other data in the trace
+// (such as stack frames and metadata) is shared with the original
+func Apply(data *tio.TefData, predicate Predicate) *tio.TefData {
+	var kept []events.Event
+	for _, e := range data.Events() {
+		if predicate(e) {
+			kept = append(kept, e)
+		}
+	}
+
+	result := *data
+	result.SetEvents(kept)
+	return &result
+}
+
+// All matches events that match every one of the given predicates, or all events if there are none
+func All(predicates ...Predicate) Predicate {
+	return func(e events.Event) bool {
+		for _, p := range predicates {
+			if !p(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Any matches events that match at least one of the given predicates
+func Any(predicates ...Predicate) Predicate {
+	return func(e events.Event) bool {
+		for _, p := range predicates {
+			if p(e) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not matches events that do not match the given predicate
+func Not(predicate Predicate) Predicate {
+	return func(e events.Event) bool {
+		return !predicate(e)
+	}
+}
+
+// ByCategory matches events with at least one of the given categories. Metadata events are always matched, so that
+// the names of processes and threads are preserved.
+func ByCategory(categories ...string) Predicate {
+	wanted := map[string]struct{}{}
+	for _, c := range categories {
+		wanted[c] = struct{}{}
+	}
+	return func(e events.Event) bool {
+		if e.Phase() == events.PhaseMetadata {
+			return true
+		}
+		for _, c := range e.Core().Categories {
+			if _, ok := wanted[c]; ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ByName matches events whose name matches the regular expression. Metadata events are always matched, so that the
+// names of processes and threads are preserved.
+func ByName(re *regexp.Regexp) Predicate {
+	return func(e events.Event) bool {
+		return e.Phase() == events.PhaseMetadata || re.MatchString(e.Core().Name)
+	}
+}
+
+// ByProcessID matches events belonging to any of the given processes
+func ByProcessID(pids ...int64) Predicate {
+	wanted := map[int64]struct{}{}
+	for _, pid := range pids {
+		wanted[pid] = struct{}{}
+	}
+	return func(e events.Event) bool {
+		pid := e.Core().ProcessID
+		if pid == nil {
+			return false
+		}
+		_, ok := wanted[*pid]
+		return ok
+	}
+}
+
+// ByThreadID matches events belonging to any of the given threads
+func ByThreadID(tids ...int64) Predicate {
+	wanted := map[int64]struct{}{}
+	for _, tid := range tids {
+		wanted[tid] = struct{}{}
+	}
+	return func(e events.Event) bool {
+		tid := e.Core().ThreadID
+		if tid == nil {
+			return false
+		}
+		_, ok := wanted[*tid]
+		return ok
+	}
+}
+
+// Between matches events that occur within the window from start to end (in microseconds), Complete events are
+// matched if any part of them overlaps the window. Metadata events are always matched, so that the names of
+// processes and threads are preserved.
+func Between(start, end int64) Predicate {
+	return func(e events.Event) bool {
+		if e.Phase() == events.PhaseMetadata {
+			return true
+		}
+		ts := e.Core().Timestamp
+		if complete, ok := e.(*events.Complete); ok {
+			return ts <= end && ts+complete.Duration >= start
+		}
+		return ts >= start && ts <= end
+	}
+}
+
+// StartTime finds the earliest timestamp of any non-metadata event in the trace, or 0 if there are none
+func StartTime(data *tio.TefData) int64 {
+	var start int64
+	found := false
+	for _, e := range data.Events() {
+		if e.Phase() == events.PhaseMetadata {
+			continue
+		}
+		if ts := e.Core().Timestamp; !found || ts < start {
+			start = ts
+			found = true
+		}
+	}
+	return start
+}
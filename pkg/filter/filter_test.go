@@ -0,0 +1,88 @@
+package filter_test
+
+import (
+	"regexp"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/filter"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Apply", func() {
+	var data *tio.TefData
+
+	BeforeEach(func() {
+		data = &tio.TefData{}
+		data.SetDisplayTimeUnit(tio.DisplayTimeNs)
+		data.Write(&events.MetadataProcessName{
+			EventCore:   core("process_name", 0, 1, 1),
+			ProcessName: "proc",
+		})
+		data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: core("db.query", 10, 1, 1, "db")}})
+		data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: core("http.get", 20, 2, 1, "net")}})
+		data.Write(&events.Complete{
+			EventWithArgs: events.EventWithArgs{EventCore: core("db.commit", 30, 1, 2, "db", "net")},
+			Duration:      20,
+		})
+	})
+
+	names := func(d *tio.TefData) []string {
+		var result []string
+		for _, e := range d.Events() {
+			result = append(result, e.Core().Name)
+		}
+		return result
+	}
+
+	It("preserves the rest of the trace", func() {
+		result := filter.Apply(data, filter.ByCategory("none"))
+		Expect(result.DisplayTimeUnit()).To(Equal(tio.DisplayTimeNs))
+		Expect(data.Events()).To(HaveLen(4))
+	})
+
+	It("filters by category, keeping metadata", func() {
+		result := filter.Apply(data, filter.ByCategory("net"))
+		Expect(names(result)).To(Equal([]string{"process_name", "http.get", "db.commit"}))
+	})
+
+	It("filters by name", func() {
+		result := filter.Apply(data, filter.ByName(regexp.MustCompile("^db")))
+		Expect(names(result)).To(Equal([]string{"process_name", "db.query", "db.commit"}))
+	})
+
+	It("filters by process and thread", func() {
+		Expect(names(filter.Apply(data, filter.ByProcessID(2)))).To(Equal([]string{"http.get"}))
+		Expect(names(filter.Apply(data, filter.ByThreadID(2)))).To(Equal([]string{"db.commit"}))
+	})
+
+	It("filters by time, including overlapping complete events", func() {
+		result := filter.Apply(data, filter.Between(15, 35))
+		Expect(names(result)).To(Equal([]string{"process_name", "http.get", "db.commit"}))
+		result = filter.Apply(data, filter.Between(45, 60))
+		Expect(names(result)).To(Equal([]string{"process_name", "db.commit"}))
+	})
+
+	It("combines predicates", func() {
+		result := filter.Apply(data, filter.All(filter.ByCategory("db"), filter.Not(filter.ByProcessID(1))))
+		Expect(names(result)).To(BeEmpty())
+		result = filter.Apply(data, filter.Any(filter.ByProcessID(2), filter.ByThreadID(2)))
+		Expect(names(result)).To(Equal([]string{"http.get", "db.commit"}))
+	})
+
+	It("finds the start of the trace", func() {
+		Expect(filter.StartTime(data)).To(BeEquivalentTo(10))
+	})
+})
+
+func core(name string, ts, pid, tid int64, categories ...string) events.EventCore {
+	return events.EventCore{
+		Name:       name,
+		Categories: categories,
+		Timestamp:  ts,
+		ProcessID:  &pid,
+		ThreadID:   &tid,
+	}
+}
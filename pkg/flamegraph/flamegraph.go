@@ -0,0 +1,183 @@
+// flamegraph converts the spans of a trace into the folded stack format used by flame graph tools such as
+// flamegraph.pl and speedscope
+package flamegraph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// Option configures how spans are folded
+type Option = func(*folder)
+
+// WithMergedThreads folds the spans of every thread together, rather than beneath a frame for each thread
+func WithMergedThreads() Option {
+	return func(f *folder) {
+		f.mergeThreads = true
+	}
+}
+
+type folder struct {
+	mergeThreads bool
+}
+
+type threadKey struct {
+	pid int64
+	tid int64
+}
+
+type span struct {
+	name     string
+	start    int64
+	end      int64
+	children int64
+}
+
+// Fold aggregates the spans of the trace into stacks, mapping each stack of frames (outermost first, separated by
+// semicolons) to the time in microseconds spent in its innermost frame. Spans are formed from Complete events and
+// matching pairs of BeginDuration and EndDuration events on the same thread, and unless threads are merged each
+// stack begins with frames naming the process and thread.
+func Fold(data *tio.TefData, options ...Option) map[string]int64 {
+	f := &folder{}
+	for _, opt := range options {
+		opt(f)
+	}
+
+	processNames := map[int64]string{}
+	threadNames := map[threadKey]string{}
+	spansByThread := map[threadKey][]*span{}
+	open := map[threadKey][]*span{}
+	for _, e := range data.Events() {
+		core := e.Core()
+		key := threadKeyOf(core)
+		switch event := e.(type) {
+		case *events.MetadataProcessName:
+			processNames[key.pid] = event.ProcessName
+		case *events.MetadataThreadName:
+			threadNames[key] = event.ThreadName
+		case *events.Complete:
+			spansByThread[key] = append(spansByThread[key], &span{
+				name:  core.Name,
+				start: core.Timestamp,
+				end:   core.Timestamp + event.Duration,
+			})
+		case *events.BeginDuration:
+			open[key] = append(open[key], &span{name: core.Name, start: core.Timestamp})
+		case *events.EndDuration:
+			stack := open[key]
+			if len(stack) < 1 {
+				continue
+			}
+			begun := stack[len(stack)-1]
+			open[key] = stack[:len(stack)-1]
+			begun.end = core.Timestamp
+			spansByThread[key] = append(spansByThread[key], begun)
+		}
+	}
+
+	folded := map[string]int64{}
+	for key, spans := range spansByThread {
+		var root []string
+		if !f.mergeThreads {
+			root = []string{processFrame(key.pid, processNames), threadFrame(key, threadNames)}
+		}
+		foldThread(folded, root, spans)
+	}
+	return folded
+}
+
+// foldThread adds the self time of each of the spans of a single thread to the stack of frames enclosing it
+func foldThread(folded map[string]int64, root []string, spans []*span) {
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end > spans[j].end
+	})
+
+	var stack []*span
+	var frames []string
+	var stacks []string
+	for _, sp := range spans {
+		for len(stack) > 0 && stack[len(stack)-1].end <= sp.start {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) > 0 {
+			stack[len(stack)-1].children += sp.end - sp.start
+		}
+		stack = append(stack, sp)
+
+		frames = append(frames[:0], root...)
+		for _, enclosing := range stack {
+			frames = append(frames, frameName(enclosing.name))
+		}
+		stacks = append(stacks, strings.Join(frames, ";"))
+	}
+
+	// self time is only known once every child has been seen
+	for i, sp := range spans {
+		if self := sp.end - sp.start - sp.children; self > 0 {
+			folded[stacks[i]] += self
+		}
+	}
+}
+
+// Write writes the folded stacks of the trace's spans to the writer, one stack per line followed by its time in
+// microseconds, in order of their stacks
+func Write(w io.Writer, data *tio.TefData, options ...Option) error {
+	folded := Fold(data, options...)
+	stacks := make([]string, 0, len(folded))
+	for stack := range folded {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	bw := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(bw, "%s %d\n", stack, folded[stack]); err != nil {
+			return fmt.Errorf("failed to write folded stacks: %w", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write folded stacks: %w", err)
+	}
+	return nil
+}
+
+// frameReplacer makes names safe to use as frames, as semicolons separate frames and newlines separate stacks
+var frameReplacer = strings.NewReplacer(";", ":", "\n", " ", "\r", " ")
+
+func frameName(name string) string {
+	return frameReplacer.Replace(name)
+}
+
+func processFrame(pid int64, names map[int64]string) string {
+	if name, ok := names[pid]; ok {
+		return frameName(fmt.Sprintf("%s (pid %d)", name, pid))
+	}
+	return fmt.Sprintf("pid %d", pid)
+}
+
+func threadFrame(key threadKey, names map[threadKey]string) string {
+	if name, ok := names[key]; ok {
+		return frameName(fmt.Sprintf("%s (tid %d)", name, key.tid))
+	}
+	return fmt.Sprintf("tid %d", key.tid)
+}
+
+func threadKeyOf(core *events.EventCore) threadKey {
+	var key threadKey
+	if core.ProcessID != nil {
+		key.pid = *core.ProcessID
+	}
+	if core.ThreadID != nil {
+		key.tid = *core.ThreadID
+	}
+	return key
+}
@@ -0,0 +1,13 @@
+package flamegraph_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestFlamegraph(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Flamegraph Suite")
+}
@@ -0,0 +1,65 @@
+package flamegraph_test
+
+import (
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/flamegraph"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Fold", func() {
+	var data *tio.TefData
+
+	BeforeEach(func() {
+		data = &tio.TefData{}
+		data.Write(&events.MetadataThreadName{EventCore: core("thread_name", 0, 2), ThreadName: "main"})
+		data.Write(&events.BeginDuration{EventWithArgs: events.EventWithArgs{EventCore: core("outer", 0, 2)}})
+		data.Write(complete("inner", 10, 20, 2))
+		data.Write(complete("inner", 40, 30, 2))
+		data.Write(&events.EndDuration{EventWithArgs: events.EventWithArgs{EventCore: core("outer", 100, 2)}})
+		data.Write(complete("odd;name", 0, 5, 3))
+	})
+
+	It("folds spans beneath frames for their process and thread", func() {
+		Expect(flamegraph.Fold(data)).To(Equal(map[string]int64{
+			"pid 1;main (tid 2);outer":       50,
+			"pid 1;main (tid 2);outer;inner": 50,
+			"pid 1;tid 3;odd:name":           5,
+		}))
+	})
+
+	It("can merge threads together", func() {
+		Expect(flamegraph.Fold(data, flamegraph.WithMergedThreads())).To(Equal(map[string]int64{
+			"outer":       50,
+			"outer;inner": 50,
+			"odd:name":    5,
+		}))
+	})
+
+	It("writes the stacks in order", func() {
+		var output strings.Builder
+		Expect(flamegraph.Write(&output, data, flamegraph.WithMergedThreads())).To(Succeed())
+		Expect(output.String()).To(Equal("odd:name 5\nouter 50\nouter;inner 50\n"))
+	})
+})
+
+func core(name string, ts, tid int64) events.EventCore {
+	pid := int64(1)
+	return events.EventCore{
+		Name:      name,
+		Timestamp: ts,
+		ProcessID: &pid,
+		ThreadID:  &tid,
+	}
+}
+
+func complete(name string, ts, dur, tid int64) events.Event {
+	return &events.Complete{
+		EventWithArgs: events.EventWithArgs{EventCore: core(name, ts, tid)},
+		Duration:      dur,
+	}
+}
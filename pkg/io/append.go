@@ -0,0 +1,81 @@
+package io
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrCannotAppend means that a file cannot be appended to as it does not hold a JSON Array Format trace
+var ErrCannotAppend = errors.New("file is not a JSON array format trace")
+
+// OpenAppend opens a JSON Array Format trace written previously, such as by a streaming writer, so that more events
+// can be written to the end of it. The file may be complete or truncated, as when the process writing it crashed:
+// anything after the last complete event, including the end of the array or a partially written event, is discarded
+// before writing resumes. A file that does not exist or is empty is created as a new trace. An error wrapping
+// ErrCannotAppend is returned if the file holds anything other than a JSON array.
+func OpenAppend(path string, options ...WriteOption) (EventWriter, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	sw := newStreamingWriter(f, newWriteConfig(options...))
+	offset, err := findAppendOffset(f, sw)
+	if err == nil {
+		err = f.Truncate(offset)
+	}
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to prepare '%s' for appending: %w", path, err)
+	}
+
+	return sw, nil
+}
+
+// findAppendOffset reads the trace from the start to find the end of its last complete event, which is where
+// writing should resume, recording on the writer whether the array was started and has events in it
+func findAppendOffset(r io.Reader, sw *streamingWriter) (int64, error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+
+	t, err := decoder.Token()
+	if errors.Is(err, io.EOF) {
+		return 0, nil
+	}
+	if err != nil || t != json.Delim('[') {
+		return 0, ErrCannotAppend
+	}
+	sw.initialised = true
+
+	offset := decoder.InputOffset()
+	for decoder.More() {
+		var e json.RawMessage
+		if err := decoder.Decode(&e); err != nil {
+			// the rest of the file is a partially written event
+			break
+		}
+		offset = decoder.InputOffset()
+		sw.hasEvents = true
+	}
+	return offset, nil
+}
+
+// RepairTruncatedArray finalizes a JSON Array Format trace that was left unterminated, such as by a crash of the
+// process writing it, discarding any partially written event and ending the array so that tools that do not tolerate
+// truncated traces can read it. Traces that are already complete are left equivalent to how they were.
+func RepairTruncatedArray(path string) error {
+	w, err := OpenAppend(path)
+	if err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to finalize '%s': %w", path, err)
+	}
+	return nil
+}
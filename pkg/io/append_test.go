@@ -0,0 +1,91 @@
+package io_test
+
+import (
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("OpenAppend", func() {
+	var dir string
+	var path string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "teffy-append")
+		Expect(err).To(Succeed())
+		path = filepath.Join(dir, "some.trace")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	appendEvent := func() {
+		w, err := teffyio.OpenAppend(path)
+		Expect(err).To(Succeed())
+		Expect(w.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "appended", Timestamp: 2}},
+		})).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+	}
+
+	eventNames := func() []string {
+		f, err := os.Open(path)
+		Expect(err).To(Succeed())
+		defer f.Close()
+		data, err := teffyio.ParseJsonArray(f)
+		Expect(err).To(Succeed())
+		var names []string
+		for _, e := range data.Events() {
+			names = append(names, e.Core().Name)
+		}
+		return names
+	}
+
+	DescribeTable("appending to existing files",
+		func(contents string, expected ...string) {
+			Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+			appendEvent()
+			Expect(eventNames()).To(Equal(expected))
+		},
+		Entry("a complete trace", `[{"name":"a","ph":"I","ts":1}]`+"\n", "a", "appended"),
+		Entry("an empty array", `[ ]`, "appended"),
+		Entry("a trace with a trailing comma", `[{"name":"a","ph":"I","ts":1},`, "a", "appended"),
+		Entry("a trace without a trailing comma", `[{"name":"a","ph":"I","ts":1}`, "a", "appended"),
+		Entry("a trace with a partially written event", `[{"name":"a","ph":"I","ts":1},{"name":"b","p`, "a", "appended"),
+		Entry("an empty file", "", "appended"),
+	)
+
+	It("creates a file that does not exist", func() {
+		appendEvent()
+		appendEvent()
+		Expect(eventNames()).To(Equal([]string{"appended", "appended"}))
+	})
+
+	It("repairs truncated traces", func() {
+		Expect(ioutil.WriteFile(path, []byte(`[{"name":"a","ph":"I","ts":1},{"na`), 0644)).To(Succeed())
+		Expect(teffyio.RepairTruncatedArray(path)).To(Succeed())
+
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).To(Succeed())
+		Expect(contents).To(MatchJSON(`[{"name":"a","ph":"I","ts":1}]`))
+	})
+
+	It("refuses to append to other files", func() {
+		Expect(ioutil.WriteFile(path, []byte(`{"traceEvents":[]}`), 0644)).To(Succeed())
+		_, err := teffyio.OpenAppend(path)
+		Expect(err).To(MatchError(teffyio.ErrCannotAppend))
+
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).To(Succeed())
+		Expect(strings.TrimSpace(string(contents))).To(Equal(`{"traceEvents":[]}`))
+	})
+})
@@ -0,0 +1,121 @@
+package io_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/omaskery/teffy/pkg/events"
+
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+// benchmarkTrace builds a trace with a representative mix of events
+func benchmarkTrace(n int) *teffyio.TefData {
+	data := &teffyio.TefData{}
+	pid, tid := int64(1), int64(2)
+	for i := 0; i < n; i++ {
+		ts := int64(i * 10)
+		data.Write(&events.Complete{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{
+					Name: "work", Categories: []string{"cat"}, Timestamp: ts, ProcessID: &pid, ThreadID: &tid,
+				},
+				Args: map[string]interface{}{"index": float64(i), "label": "something"},
+			},
+			Duration: 5,
+		})
+		data.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "tick", Timestamp: ts + 5, ProcessID: &pid, ThreadID: &tid},
+			},
+			Scope: events.InstantScopeThread,
+		})
+		data.Write(&events.Counter{
+			EventCore: events.EventCore{Name: "mem", Timestamp: ts + 7, ProcessID: &pid},
+			Values:    map[string]float64{"heap": float64(i)},
+		})
+	}
+	return data
+}
+
+func BenchmarkParseJsonArray(b *testing.B) {
+	var buffer bytes.Buffer
+	if err := teffyio.WriteJsonArray(&buffer, benchmarkTrace(1000).Events()); err != nil {
+		b.Fatal(err)
+	}
+	input := buffer.Bytes()
+
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := teffyio.ParseJsonArray(bytes.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteJsonArray(b *testing.B) {
+	data := benchmarkTrace(1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := teffyio.WriteJsonArray(ioutil.Discard, data.Events()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteJsonObject(b *testing.B) {
+	data := benchmarkTrace(1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := teffyio.WriteJsonObject(ioutil.Discard, *data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// discardCloser is an io.WriteCloser that throws away everything written to it
+type discardCloser struct{}
+
+func (discardCloser) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (discardCloser) Close() error {
+	return nil
+}
+
+func BenchmarkStreamingWriter(b *testing.B) {
+	evs := benchmarkTrace(1000).Events()
+	w := teffyio.NewStreamingWriter(discardCloser{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := w.Write(evs[i%len(evs)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamingWriterPlainEvents(b *testing.B) {
+	pid, tid := int64(1), int64(2)
+	e := &events.BeginDuration{EventWithArgs: events.EventWithArgs{
+		EventCore: events.EventCore{Name: "work", Categories: []string{"cat"}, Timestamp: 10, ProcessID: &pid, ThreadID: &tid},
+	}}
+	w := teffyio.NewStreamingWriter(discardCloser{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := w.Write(e); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// ErrWriterClosed means an event was written to a writer after it was closed
+var ErrWriterClosed = errors.New("writer is closed")
+
+// DropPolicy determines how a BufferedWriter behaves when its queue of events is full
+type DropPolicy int
+
+const (
+	// DropPolicyBlock makes writes wait until there is room in the queue, so no events are dropped
+	DropPolicyBlock DropPolicy = iota
+	// DropPolicyNewest discards the event being written when the queue is full
+	DropPolicyNewest
+	// DropPolicyOldest discards the oldest queued event to make room for the event being written
+	DropPolicyOldest
+)
+
+// DefaultQueueSize is the number of events a BufferedWriter queues unless configured otherwise
+const DefaultQueueSize = 1024
+
+// BufferedWriterOption configures the behaviour of a BufferedWriter
+type BufferedWriterOption = func(bw *BufferedWriter)
+
+// WithQueueSize sets how many events may be queued waiting to be written. Sizes below 1 are treated as 1, as without
+// room for an event each write could only hand its event straight to the background goroutine, so that dropping
+// policies would drop nearly every event.
+func WithQueueSize(size int) BufferedWriterOption {
+	return func(bw *BufferedWriter) {
+		if size < 1 {
+			size = 1
+		}
+		bw.queueSize = size
+	}
+}
+
+// WithDropPolicy sets what happens when an event is written while the queue is full
+func WithDropPolicy(policy DropPolicy) BufferedWriterOption {
+	return func(bw *BufferedWriter) {
+		bw.policy = policy
+	}
+}
+
+// WithWriteErrorHandler provides a callback for errors that occur while writing events in the background,
+// without a handler the first such error is returned from Close
+func WithWriteErrorHandler(handler func(err error)) BufferedWriterOption {
+	return func(bw *BufferedWriter) {
+		bw.errHandler = handler
+	}
+}
+
+// BufferedWriter is an EventWriter that queues events to be written to another EventWriter on a background
+// goroutine, so that the cost of marshalling and writing events is not paid by the code emitting them. Events must
+// not be modified after being written.
+type BufferedWriter struct {
+	inner      EventWriter
+	queueSize  int
+	policy     DropPolicy
+	errHandler func(err error)
+
+	queue    chan events.Event
+	done     chan struct{}
+	lock     sync.RWMutex
+	closed   bool
+	dropped  uint64
+	firstErr error
+}
+
+// NewBufferedWriter creates a BufferedWriter that writes events to inner in the background
+func NewBufferedWriter(inner EventWriter, options ...BufferedWriterOption) *BufferedWriter {
+	bw := &BufferedWriter{
+		inner:     inner,
+		queueSize: DefaultQueueSize,
+		policy:    DropPolicyBlock,
+		done:      make(chan struct{}),
+	}
+	for _, opt := range options {
+		opt(bw)
+	}
+	bw.queue = make(chan events.Event, bw.queueSize)
+
+	go bw.run()
+
+	return bw
+}
+
+// Write queues the event to be written, according to the configured DropPolicy if the queue is full
+func (bw *BufferedWriter) Write(e events.Event) error {
+	bw.lock.RLock()
+	defer bw.lock.RUnlock()
+
+	if bw.closed {
+		return ErrWriterClosed
+	}
+
+	switch bw.policy {
+	case DropPolicyNewest:
+		select {
+		case bw.queue <- e:
+		default:
+			atomic.AddUint64(&bw.dropped, 1)
+		}
+	case DropPolicyOldest:
+		for {
+			select {
+			case bw.queue <- e:
+				return nil
+			default:
+			}
+			select {
+			case <-bw.queue:
+				atomic.AddUint64(&bw.dropped, 1)
+			default:
+			}
+		}
+	default:
+		bw.queue <- e
+	}
+
+	return nil
+}
+
+// Dropped reports how many events have been discarded because the queue was full
+func (bw *BufferedWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&bw.dropped)
+}
+
+// Close waits for all queued events to be written and then closes the underlying EventWriter
+func (bw *BufferedWriter) Close() error {
+	bw.lock.Lock()
+	if bw.closed {
+		bw.lock.Unlock()
+		return nil
+	}
+	bw.closed = true
+	close(bw.queue)
+	bw.lock.Unlock()
+
+	<-bw.done
+
+	if err := bw.inner.Close(); err != nil {
+		return fmt.Errorf("failed to close underlying writer: %w", err)
+	}
+	if bw.firstErr != nil {
+		return fmt.Errorf("failed to write buffered event: %w", bw.firstErr)
+	}
+	return nil
+}
+
+func (bw *BufferedWriter) run() {
+	defer close(bw.done)
+
+	for e := range bw.queue {
+		if err := bw.inner.Write(e); err != nil {
+			if bw.errHandler != nil {
+				bw.errHandler(err)
+			} else if bw.firstErr == nil {
+				bw.firstErr = err
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package io_test
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("BufferedWriter", func() {
+	var inner *gatedWriter
+	var options []teffyio.BufferedWriterOption
+	var writer *teffyio.BufferedWriter
+
+	BeforeEach(func() {
+		inner = newGatedWriter()
+		options = nil
+	})
+
+	JustBeforeEach(func() {
+		writer = teffyio.NewBufferedWriter(inner, options...)
+	})
+
+	namedInstant := func(name string) events.Event {
+		return &events.Instant{EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: name}}}
+	}
+
+	It("writes events to the inner writer in order", func() {
+		inner.open()
+		Expect(writer.Write(namedInstant("a"))).To(Succeed())
+		Expect(writer.Write(namedInstant("b"))).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+		Expect(inner.names()).To(Equal([]string{"a", "b"}))
+		Expect(inner.closed).To(BeTrue())
+		Expect(writer.Dropped()).To(BeZero())
+	})
+
+	It("refuses writes after being closed", func() {
+		inner.open()
+		Expect(writer.Close()).To(Succeed())
+		Expect(writer.Write(namedInstant("a"))).To(MatchError(teffyio.ErrWriterClosed))
+	})
+
+	When("the inner writer fails", func() {
+		BeforeEach(func() {
+			inner.err = errors.New("oh no")
+		})
+
+		It("reports the error on close", func() {
+			inner.open()
+			Expect(writer.Write(namedInstant("a"))).To(Succeed())
+			Expect(writer.Close()).To(MatchError(ContainSubstring("oh no")))
+		})
+
+		When("there is an error handler", func() {
+			var handled []error
+
+			BeforeEach(func() {
+				handled = nil
+				options = append(options, teffyio.WithWriteErrorHandler(func(err error) {
+					handled = append(handled, err)
+				}))
+			})
+
+			It("reports the error to the handler", func() {
+				inner.open()
+				Expect(writer.Write(namedInstant("a"))).To(Succeed())
+				Expect(writer.Close()).To(Succeed())
+				Expect(handled).To(HaveLen(1))
+			})
+		})
+	})
+
+	When("the queue size is below 1", func() {
+		BeforeEach(func() {
+			options = append(options, teffyio.WithQueueSize(0), teffyio.WithDropPolicy(teffyio.DropPolicyNewest))
+		})
+
+		It("queues one event", func() {
+			Expect(writer.Write(namedInstant("a"))).To(Succeed())
+			Eventually(inner.started).Should(Receive())
+			Expect(writer.Write(namedInstant("b"))).To(Succeed())
+			Expect(writer.Write(namedInstant("c"))).To(Succeed())
+			Expect(writer.Dropped()).To(BeEquivalentTo(1))
+			inner.open()
+			Expect(writer.Close()).To(Succeed())
+			Expect(inner.names()).To(Equal([]string{"a", "b"}))
+		})
+	})
+
+	When("the queue is full", func() {
+		// the first event is taken from the queue by the background goroutine, which then blocks on the inner
+		// writer, leaving the next two events to fill the queue
+		fill := func() {
+			Expect(writer.Write(namedInstant("a"))).To(Succeed())
+			Eventually(inner.started).Should(Receive())
+			Expect(writer.Write(namedInstant("b"))).To(Succeed())
+			Expect(writer.Write(namedInstant("c"))).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			options = append(options, teffyio.WithQueueSize(2))
+		})
+
+		When("dropping the newest events", func() {
+			BeforeEach(func() {
+				options = append(options, teffyio.WithDropPolicy(teffyio.DropPolicyNewest))
+			})
+
+			It("discards the event being written", func() {
+				fill()
+				Expect(writer.Write(namedInstant("d"))).To(Succeed())
+				Expect(writer.Dropped()).To(BeEquivalentTo(1))
+				inner.open()
+				Expect(writer.Close()).To(Succeed())
+				Expect(inner.names()).To(Equal([]string{"a", "b", "c"}))
+			})
+		})
+
+		When("dropping the oldest events", func() {
+			BeforeEach(func() {
+				options = append(options, teffyio.WithDropPolicy(teffyio.DropPolicyOldest))
+			})
+
+			It("discards the oldest queued event", func() {
+				fill()
+				Expect(writer.Write(namedInstant("d"))).To(Succeed())
+				Expect(writer.Dropped()).To(BeEquivalentTo(1))
+				inner.open()
+				Expect(writer.Close()).To(Succeed())
+				Expect(inner.names()).To(Equal([]string{"a", "c", "d"}))
+			})
+		})
+
+		When("blocking", func() {
+			It("waits for room in the queue", func() {
+				fill()
+				written := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					Expect(writer.Write(namedInstant("d"))).To(Succeed())
+					close(written)
+				}()
+				Consistently(written).ShouldNot(BeClosed())
+				inner.open()
+				Eventually(written).Should(BeClosed())
+				Expect(writer.Close()).To(Succeed())
+				Expect(inner.names()).To(Equal([]string{"a", "b", "c", "d"}))
+				Expect(writer.Dropped()).To(BeZero())
+			})
+		})
+	})
+})
+
+// gatedWriter is an EventWriter whose writes block until it is opened
+type gatedWriter struct {
+	lock    sync.Mutex
+	gate    chan struct{}
+	started chan struct{}
+	written []events.Event
+	err     error
+	closed  bool
+}
+
+func newGatedWriter() *gatedWriter {
+	return &gatedWriter{
+		gate:    make(chan struct{}),
+		started: make(chan struct{}, 1),
+	}
+}
+
+func (w *gatedWriter) open() {
+	close(w.gate)
+}
+
+func (w *gatedWriter) Write(e events.Event) error {
+	select {
+	case w.started <- struct{}{}:
+	default:
+	}
+	<-w.gate
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.written = append(w.written, e)
+	return w.err
+}
+
+func (w *gatedWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func (w *gatedWriter) names() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	var result []string
+	for _, e := range w.written {
+		result = append(result, e.Core().Name)
+	}
+	return result
+}
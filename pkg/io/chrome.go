@@ -0,0 +1,190 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/omaskery/teffy/internal/stackframes"
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// ErrNotChromeCompatible means that an event cannot be adjusted to import cleanly into chrome://tracing
+var ErrNotChromeCompatible = errors.New("event cannot be imported by chrome://tracing")
+
+// WriteJsonObjectStrict is like WriteJsonObject, but first adjusts the trace with ChromeCompatible so that the output
+// imports cleanly into chrome://tracing
+func WriteJsonObjectStrict(w io.Writer, data TefData) error {
+	compatible, err := ChromeCompatible(data)
+	if err != nil {
+		return err
+	}
+	return WriteJsonObject(w, *compatible)
+}
+
+// ChromeCompatible returns a copy of the trace adjusted to work around the quirks of the chrome://tracing importer,
+// the original trace is left unchanged:
+//   - inline stack traces are replaced with references to the trace's stack frames
+//   - events without a process or thread ID are given an ID of 0
+//   - instant event scopes are lowercased
+//   - a missing display time unit is set to milliseconds
+//
+// Events that cannot be fixed, such as those of unsupported phases, Complete events with negative durations, events
+// that require an ID but have none, and categories containing commas, cause an error wrapping ErrNotChromeCompatible.
+func ChromeCompatible(data TefData) (*TefData, error) {
+	result := data
+	switch result.displayTimeUnit {
+	case "":
+		result.displayTimeUnit = DisplayTimeMs
+	case DisplayTimeMs, DisplayTimeNs:
+	default:
+		return nil, fmt.Errorf("display time unit '%s' must be ms or ns: %w", result.displayTimeUnit,
+			ErrNotChromeCompatible)
+	}
+
+	frames := newFrameInterner(data.stackFrames)
+	evs := make([]events.Event, 0, len(data.traceEvents))
+	for i, e := range data.traceEvents {
+		compatible, err := chromeCompatibleEvent(e, frames)
+		if err != nil {
+			return nil, fmt.Errorf("event %d ('%s'): %w", i, e.Core().Name, err)
+		}
+		evs = append(evs, compatible)
+	}
+	result.traceEvents = evs
+	result.stackFrames = frames.frames
+
+	return &result, nil
+}
+
+func chromeCompatibleEvent(e events.Event, frames *frameInterner) (events.Event, error) {
+	if err := checkChromeCompatible(e); err != nil {
+		return nil, err
+	}
+
+	e = shallowCopyEvent(e)
+	core := e.Core()
+	for _, c := range core.Categories {
+		if strings.Contains(c, ",") {
+			return nil, fmt.Errorf("category '%s' contains a comma: %w", c, ErrNotChromeCompatible)
+		}
+	}
+	if core.ProcessID == nil {
+		core.ProcessID = new(int64)
+	}
+	if core.ThreadID == nil {
+		core.ThreadID = new(int64)
+	}
+
+	switch event := e.(type) {
+	case *events.BeginDuration:
+		frames.replaceInline(&event.EventStackTrace)
+	case *events.EndDuration:
+		frames.replaceInline(&event.EventStackTrace)
+	case *events.Complete:
+		frames.replaceInline(&event.EventStackTrace)
+		if event.EndStackTrace != nil {
+			event.EndStackFrameID = frames.intern(event.EndStackTrace)
+			event.EndStackTrace = nil
+		}
+	case *events.Instant:
+		frames.replaceInline(&event.EventStackTrace)
+		event.Scope = events.InstantScope(strings.ToLower(string(event.Scope)))
+		if !event.Scope.Valid() {
+			return nil, fmt.Errorf("unknown instant scope '%s': %w", event.Scope, ErrNotChromeCompatible)
+		}
+	case *events.Sample:
+		frames.replaceInline(&event.EventStackTrace)
+	}
+
+	return e, nil
+}
+
+// checkChromeCompatible finds the problems with an event that cannot be fixed
+func checkChromeCompatible(e events.Event) error {
+	var id string
+	switch event := e.(type) {
+	case *events.Raw:
+		return fmt.Errorf("unsupported phase '%s': %w", event.Phase(), ErrNotChromeCompatible)
+	case *events.Complete:
+		if event.Duration < 0 {
+			return fmt.Errorf("negative duration %d: %w", event.Duration, ErrNotChromeCompatible)
+		}
+		return nil
+	case *events.AsyncBegin:
+		id = event.Id
+	case *events.AsyncInstant:
+		id = event.Id
+	case *events.AsyncEnd:
+		id = event.Id
+	case *events.FlowStart:
+		id = event.Id
+	case *events.FlowInstant:
+		id = event.Id
+	case *events.FlowFinish:
+		id = event.Id
+	case *events.ObjectCreated:
+		id = event.Id
+	case *events.ObjectSnapshot:
+		id = event.Id
+	case *events.ObjectDeleted:
+		id = event.Id
+	case *events.ContextEnter:
+		id = event.Id
+	case *events.ContextExit:
+		id = event.Id
+	case *events.LinkIds:
+		id = event.Id
+	default:
+		return nil
+	}
+	if id == "" {
+		return fmt.Errorf("missing id: %w", ErrNotChromeCompatible)
+	}
+	return nil
+}
+
+// shallowCopyEvent copies the event so that its fields can be changed without affecting the original
+func shallowCopyEvent(e events.Event) events.Event {
+	original := reflect.ValueOf(e).Elem()
+	copied := reflect.New(original.Type())
+	copied.Elem().Set(original)
+	return copied.Interface().(events.Event)
+}
+
+// frameInterner adds the frames of inline stack traces to a trace's stack frames, reusing identical frames
+type frameInterner struct {
+	frames   map[string]*events.StackFrame
+	interner *stackframes.Interner
+}
+
+func newFrameInterner(existing map[string]*events.StackFrame) *frameInterner {
+	fi := &frameInterner{
+		frames:   make(map[string]*events.StackFrame, len(existing)),
+		interner: stackframes.New(existing),
+	}
+	for id, frame := range existing {
+		fi.frames[id] = frame
+	}
+	return fi
+}
+
+func (fi *frameInterner) replaceInline(st *events.EventStackTrace) {
+	if st.StackTrace == nil {
+		return
+	}
+	st.StackFrameID = fi.intern(st.StackTrace)
+	st.StackTrace = nil
+}
+
+// intern returns the ID of the most recently called frame of the stack trace
+func (fi *frameInterner) intern(trace *events.StackTrace) string {
+	// adding frames to the map cannot fail
+	id, _ := fi.interner.Intern(trace, func(id string, frame *events.StackFrame) error {
+		fi.frames[id] = frame
+		return nil
+	})
+	return id
+}
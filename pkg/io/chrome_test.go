@@ -0,0 +1,88 @@
+package io_test
+
+import (
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("ChromeCompatible", func() {
+	var data teffyio.TefData
+
+	BeforeEach(func() {
+		data = teffyio.TefData{}
+	})
+
+	It("replaces inline stack traces with stack frame references", func() {
+		trace := func(names ...string) *events.StackTrace {
+			st := &events.StackTrace{}
+			for _, name := range names {
+				st.Trace = append(st.Trace, &events.StackFrame{Name: name})
+			}
+			return st
+		}
+		original := &events.Instant{
+			EventWithArgs:   events.EventWithArgs{EventCore: events.EventCore{Name: "a"}},
+			EventStackTrace: events.EventStackTrace{StackTrace: trace("main", "work")},
+			Scope:           "G",
+		}
+		data.Write(original)
+		data.Write(&events.Sample{
+			EventCore:       events.EventCore{Name: "b"},
+			EventStackTrace: events.EventStackTrace{StackTrace: trace("main", "idle")},
+		})
+
+		compatible, err := teffyio.ChromeCompatible(data)
+		Expect(err).To(Succeed())
+
+		instant := compatible.Events()[0].(*events.Instant)
+		Expect(instant.StackTrace).To(BeNil())
+		Expect(instant.Scope).To(Equal(events.InstantScopeGlobal))
+		sample := compatible.Events()[1].(*events.Sample)
+		Expect(sample.StackTrace).To(BeNil())
+
+		frames := compatible.StackFrames()
+		Expect(frames).To(HaveLen(3))
+		work := frames[instant.StackFrameID]
+		idle := frames[sample.StackFrameID]
+		Expect(work.Name).To(Equal("work"))
+		Expect(idle.Name).To(Equal("idle"))
+		Expect(work.Parent).To(Equal(idle.Parent))
+		Expect(frames[work.Parent].Name).To(Equal("main"))
+
+		Expect(original.StackTrace).ToNot(BeNil())
+		Expect(original.Scope).To(Equal(events.InstantScope("G")))
+		Expect(data.StackFrames()).To(BeEmpty())
+	})
+
+	It("gives events default process and thread IDs", func() {
+		data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "a"}}})
+		var output strings.Builder
+		Expect(teffyio.WriteJsonObjectStrict(&output, data)).To(Succeed())
+		Expect(output.String()).To(MatchJSON(`{
+			"displayTimeUnit": "ms",
+			"traceEvents": [{"ph": "I", "name": "a", "ts": 0, "pid": 0, "tid": 0}]
+		}`))
+		Expect(data.Events()[0].Core().ProcessID).To(BeNil())
+	})
+
+	DescribeTable("refuses events that cannot be fixed",
+		func(e events.Event) {
+			data.Write(e)
+			_, err := teffyio.ChromeCompatible(data)
+			Expect(err).To(MatchError(teffyio.ErrNotChromeCompatible))
+		},
+		Entry("unsupported phases", &events.Raw{RawPhase: "Z"}),
+		Entry("negative durations", &events.Complete{Duration: -1}),
+		Entry("missing ids", &events.AsyncBegin{}),
+		Entry("categories containing commas", &events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Categories: []string{"a,b"}}},
+		}),
+		Entry("unknown instant scopes", &events.Instant{Scope: "x"}),
+	)
+})
@@ -0,0 +1,212 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// ErrNoChunks means that no files matched the pattern given for the chunks of a trace
+var ErrNoChunks = errors.New("no trace chunks found")
+
+// ChunkedWriterOption configures how a ChunkedWriter splits its output
+type ChunkedWriterOption = func(cw *ChunkedWriter)
+
+// WithMaxChunkEvents starts a new chunk once the current one holds the given number of events, not counting the
+// metadata events repeated at its start
+func WithMaxChunkEvents(n int) ChunkedWriterOption {
+	return func(cw *ChunkedWriter) {
+		cw.maxEvents = n
+	}
+}
+
+// WithMaxChunkBytes starts a new chunk once the current one has grown to at least the given size, so chunks exceed the
+// size by at most one event
+func WithMaxChunkBytes(n int64) ChunkedWriterOption {
+	return func(cw *ChunkedWriter) {
+		cw.maxBytes = n
+	}
+}
+
+// WithChunkWriteOptions configures how the events of each chunk are written, such as how often they are synced
+func WithChunkWriteOptions(options ...WriteOption) ChunkedWriterOption {
+	return func(cw *ChunkedWriter) {
+		cw.writeOptions = append(cw.writeOptions, options...)
+	}
+}
+
+// ChunkedWriter is an EventWriter that splits the events of a long tracing session across a series of JSON Array
+// Format files, each written as a streaming writer would, starting a new file whenever the current one reaches the
+// configured number of events or size. The metadata events written so far are repeated at the start of each chunk,
+// so that any chunk can be looked at on its own with its processes and threads named. LoadChunks reads the chunks back
+// as a single trace.
+type ChunkedWriter struct {
+	pattern      string
+	maxEvents    int
+	maxBytes     int64
+	writeOptions []WriteOption
+
+	current  *streamingWriter
+	file     *chunkFile
+	events   int
+	chunks   []string
+	metadata []events.Event
+}
+
+// NewChunkedWriter creates a ChunkedWriter that names its chunks by formatting the pattern with the number of each
+// chunk, counting from 1, such as "trace-%04d.json" for trace-0001.json, trace-0002.json and so on. The numbers
+// should be zero padded so that the chunks sort in the order they were written. Without options all events are
+// written to the first chunk.
+func NewChunkedWriter(pattern string, options ...ChunkedWriterOption) *ChunkedWriter {
+	cw := &ChunkedWriter{pattern: pattern}
+	for _, opt := range options {
+		opt(cw)
+	}
+	return cw
+}
+
+// Write writes the event to the current chunk, first starting a new chunk if the current one is full
+func (cw *ChunkedWriter) Write(e events.Event) error {
+	if cw.current == nil || cw.full() {
+		if err := cw.next(); err != nil {
+			return err
+		}
+	}
+	if err := cw.current.Write(e); err != nil {
+		return err
+	}
+	cw.events++
+	if e.Phase() == events.PhaseMetadata {
+		cw.metadata = append(cw.metadata, events.Clone(e))
+	}
+	return nil
+}
+
+// Transient reports that each event is finished with once it is written, as chunks encode events as they are written
+func (cw *ChunkedWriter) Transient() bool {
+	return true
+}
+
+// Chunks returns the paths of the chunks started so far, in the order they were written
+func (cw *ChunkedWriter) Chunks() []string {
+	return append([]string(nil), cw.chunks...)
+}
+
+// Close finishes the current chunk, leaving a single empty chunk if no events were written
+func (cw *ChunkedWriter) Close() error {
+	if cw.current == nil {
+		if err := cw.next(); err != nil {
+			return err
+		}
+	}
+	if err := cw.current.Close(); err != nil {
+		return fmt.Errorf("failed to finish chunk '%s': %w", cw.file.Name(), err)
+	}
+	return nil
+}
+
+func (cw *ChunkedWriter) full() bool {
+	return (cw.maxEvents > 0 && cw.events >= cw.maxEvents) || (cw.maxBytes > 0 && cw.file.written >= cw.maxBytes)
+}
+
+// next finishes the current chunk, if any, and starts the next one with the metadata events written so far
+func (cw *ChunkedWriter) next() error {
+	if cw.current != nil {
+		if err := cw.current.Close(); err != nil {
+			return fmt.Errorf("failed to finish chunk '%s': %w", cw.file.Name(), err)
+		}
+	}
+
+	path := fmt.Sprintf(cw.pattern, len(cw.chunks)+1)
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create chunk: %w", err)
+	}
+	cw.file = &chunkFile{File: f}
+	cw.current = newStreamingWriter(cw.file, newWriteConfig(cw.writeOptions...))
+	cw.chunks = append(cw.chunks, path)
+	cw.events = 0
+
+	for _, e := range cw.metadata {
+		if err := cw.current.Write(e); err != nil {
+			return fmt.Errorf("failed to repeat metadata in chunk '%s': %w", path, err)
+		}
+	}
+	return nil
+}
+
+// chunkFile counts the bytes written to a chunk, embedding the file so that chunks can still be synced
+type chunkFile struct {
+	*os.File
+	written int64
+}
+
+func (f *chunkFile) Write(b []byte) (int, error) {
+	n, err := f.File.Write(b)
+	f.written += int64(n)
+	return n, err
+}
+
+// LoadChunks reads the chunks of a trace written by a ChunkedWriter, the files matching the glob pattern (such as
+// "trace-*.json"), back into a single trace. Chunks are read in the order of their names, and the metadata events a
+// ChunkedWriter repeats at the start of each chunk are only kept the first time they are seen. As the last chunk may
+// have been left unfinished by a crash, chunks are parsed as ParseJsonArray parses them, with the given options. An
+// error wrapping ErrNoChunks is returned if no files match the pattern.
+func LoadChunks(pattern string, options ...ParseOption) (*TefData, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chunk pattern '%s': %w", pattern, err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("'%s': %w", pattern, ErrNoChunks)
+	}
+	sort.Strings(paths)
+
+	result := &TefData{
+		displayTimeUnit:        DisplayTimeMs,
+		metadata:               map[string]interface{}{},
+		stackFrames:            map[string]*events.StackFrame{},
+		controllerTraceDataKey: "traceEvents",
+	}
+	seenMetadata := map[string]bool{}
+	var parseErrs ParseErrors
+	for _, path := range paths {
+		chunk, err := loadChunk(path, options...)
+		var errs ParseErrors
+		if errors.As(err, &errs) {
+			parseErrs = append(parseErrs, errs...)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to load chunk '%s': %w", path, err)
+		}
+
+		for _, e := range chunk.traceEvents {
+			if e.Phase() == events.PhaseMetadata {
+				if encoded, err := events.MarshalEvent(e); err == nil {
+					if seenMetadata[string(encoded)] {
+						continue
+					}
+					seenMetadata[string(encoded)] = true
+				}
+			}
+			result.Write(e)
+		}
+	}
+
+	if len(parseErrs) > 0 {
+		return result, parseErrs
+	}
+	return result, nil
+}
+
+func loadChunk(path string, options ...ParseOption) (*TefData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseJsonArray(f, options...)
+}
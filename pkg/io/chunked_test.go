@@ -0,0 +1,124 @@
+package io_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Chunked output", func() {
+	var dir string
+	var pattern string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "teffy-chunked")
+		Expect(err).To(Succeed())
+		pattern = filepath.Join(dir, "trace-%04d.json")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	instant := func(i int) events.Event {
+		return &events.Instant{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: fmt.Sprintf("tick %d", i), Timestamp: int64(i)},
+			},
+		}
+	}
+
+	names := func(data *teffyio.TefData) []string {
+		var result []string
+		for _, e := range data.Events() {
+			result = append(result, e.Core().Name)
+		}
+		return result
+	}
+
+	loadChunk := func(path string) *teffyio.TefData {
+		f, err := os.Open(path)
+		Expect(err).To(Succeed())
+		defer f.Close()
+		data, err := teffyio.ParseJsonArray(f)
+		Expect(err).To(Succeed())
+		return data
+	}
+
+	It("splits events into chunks by count, repeating metadata in each", func() {
+		w := teffyio.NewChunkedWriter(pattern, teffyio.WithMaxChunkEvents(2))
+		Expect(w.Write(events.NewProcessName(1, "server"))).To(Succeed())
+		for i := 1; i <= 4; i++ {
+			Expect(w.Write(instant(i))).To(Succeed())
+		}
+		Expect(w.Close()).To(Succeed())
+
+		Expect(w.Chunks()).To(Equal([]string{
+			filepath.Join(dir, "trace-0001.json"),
+			filepath.Join(dir, "trace-0002.json"),
+			filepath.Join(dir, "trace-0003.json"),
+		}))
+		Expect(names(loadChunk(w.Chunks()[0]))).To(Equal([]string{"process_name", "tick 1"}))
+		Expect(names(loadChunk(w.Chunks()[1]))).To(Equal([]string{"process_name", "tick 2", "tick 3"}))
+		Expect(names(loadChunk(w.Chunks()[2]))).To(Equal([]string{"process_name", "tick 4"}))
+
+		data, err := teffyio.LoadChunks(filepath.Join(dir, "trace-*.json"))
+		Expect(err).To(Succeed())
+		Expect(names(data)).To(Equal([]string{"process_name", "tick 1", "tick 2", "tick 3", "tick 4"}))
+	})
+
+	It("splits events into chunks by size", func() {
+		w := teffyio.NewChunkedWriter(pattern, teffyio.WithMaxChunkBytes(60))
+		for i := 1; i <= 6; i++ {
+			Expect(w.Write(instant(i))).To(Succeed())
+		}
+		Expect(w.Close()).To(Succeed())
+
+		Expect(len(w.Chunks())).To(BeNumerically(">", 1))
+		for _, chunk := range w.Chunks()[:len(w.Chunks())-1] {
+			info, err := os.Stat(chunk)
+			Expect(err).To(Succeed())
+			Expect(info.Size()).To(BeNumerically(">=", 60))
+			Expect(info.Size()).To(BeNumerically("<", 120))
+		}
+
+		data, err := teffyio.LoadChunks(filepath.Join(dir, "trace-*.json"))
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(HaveLen(6))
+	})
+
+	It("leaves an empty chunk when nothing is written", func() {
+		w := teffyio.NewChunkedWriter(pattern)
+		Expect(w.Close()).To(Succeed())
+		Expect(w.Chunks()).To(HaveLen(1))
+		data, err := teffyio.LoadChunks(filepath.Join(dir, "trace-*.json"))
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(BeEmpty())
+	})
+
+	It("loads chunks left unfinished", func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "trace-0001.json"),
+			[]byte(`[{"ph":"M","name":"process_name","pid":1,"args":{"name":"server"}},{"ph":"i","name":"a","ts":1}]`),
+			0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "trace-0002.json"),
+			[]byte(`[{"ph":"M","name":"process_name","pid":1,"args":{"name":"server"}},{"ph":"i","name":"b","ts":2},`),
+			0644)).To(Succeed())
+
+		data, err := teffyio.LoadChunks(filepath.Join(dir, "trace-*.json"))
+		Expect(err).To(Succeed())
+		Expect(names(data)).To(Equal([]string{"process_name", "a", "b"}))
+	})
+
+	It("fails when there are no chunks", func() {
+		_, err := teffyio.LoadChunks(filepath.Join(dir, "trace-*.json"))
+		Expect(err).To(MatchError(teffyio.ErrNoChunks))
+	})
+})
@@ -0,0 +1,258 @@
+// ci provides conversion of the timings of the jobs and steps of CI pipelines, such as GitHub Actions workflow runs,
+// into trace events, so that how much of a pipeline ran in parallel, and what it spent its time waiting on, can be seen
+package ci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/omaskery/teffy/internal/lanes"
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+const (
+	// Category is the category given to all events converted from CI timings
+	Category = "ci"
+
+	// ArgStatus is the arg holding the status or conclusion of a job or step, such as "success"
+	ArgStatus = "status"
+
+	// DefaultJob names the job of steps that do not say which job they belong to
+	DefaultJob = "pipeline"
+)
+
+// ErrInvalidTimings means that the input is not one of the understood forms of CI timings
+var ErrInvalidTimings = errors.New("invalid CI timings")
+
+// Job is a job of a pipeline, which runs its steps one after another, though jobs may run in parallel with each other
+type Job struct {
+	Name string
+	// Start and End are when the job ran, when not given they are taken to be the span of its steps
+	Start, End time.Time
+	// Status is the status or conclusion of the job, such as "success" or "failure", if it is known
+	Status string
+	Steps  []Step
+}
+
+// Step is a step of a job
+type Step struct {
+	Name       string
+	Start, End time.Time
+	Status     string
+}
+
+// statusColors are the trace viewer's reserved colors that jobs and steps are drawn in according to their status
+var statusColors = map[string]string{
+	"success":   "good",
+	"failure":   "bad",
+	"failed":    "bad",
+	"error":     "terrible",
+	"cancelled": "grey",
+	"skipped":   "grey",
+}
+
+// Parse reads CI timings as JSON from the provided reader, converting them as Convert does. The timings can be:
+//   - an array of steps, or an object with a "steps" array, each step having a "name", a "start" and "end" time in
+//     RFC 3339 format, and optionally the name of its "job" and its "status"
+//   - GitHub Actions' list of the jobs of a workflow run, an object with a "jobs" array of jobs with "name",
+//     "started_at", "completed_at", "conclusion" and "steps", each step having the same fields bar its own steps
+func Parse(r io.Reader) (*tio.TefData, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode CI timings: %w", err)
+	}
+
+	var steps []jsonStep
+	if err := json.Unmarshal(raw, &steps); err == nil {
+		return Convert(groupSteps(steps)), nil
+	}
+	var doc struct {
+		Jobs  []jsonJob  `json:"jobs"`
+		Steps []jsonStep `json:"steps"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("expected an array of steps or an object of jobs or steps: %w", ErrInvalidTimings)
+	}
+	jobs := groupSteps(doc.Steps)
+	for _, j := range doc.Jobs {
+		job := Job{Name: j.Name, Start: j.start(), End: j.end(), Status: j.status()}
+		for _, s := range j.Steps {
+			job.Steps = append(job.Steps, s.step())
+		}
+		jobs = append(jobs, job)
+	}
+	return Convert(jobs), nil
+}
+
+// Convert converts the jobs into events. Each job becomes a process, in the order that the jobs started, holding a
+// Complete event for the job and one for each of its steps, which nest within the job's. Steps of the same job that
+// overlap are placed on further threads of the job's process. Steps that never started, such as skipped steps, are
+// left out.
+func Convert(jobs []Job) *tio.TefData {
+	data := &tio.TefData{}
+	data.SetDisplayTimeUnit(tio.DisplayTimeMs)
+
+	jobs = append([]Job(nil), jobs...)
+	for i := range jobs {
+		jobs[i] = withTimes(jobs[i])
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].Start.Before(jobs[j].Start)
+	})
+
+	for i, job := range jobs {
+		pid := int64(i + 1)
+		data.Write(events.NewProcessName(pid, job.Name))
+		data.Write(events.NewProcessSortIndex(pid, int64(i)))
+		writeJob(data, pid, job)
+	}
+	return data
+}
+
+// withTimes returns the job with only the steps that started, taking the times of the job from its steps if not given
+func withTimes(job Job) Job {
+	var steps []Step
+	for _, step := range job.Steps {
+		if step.Start.IsZero() {
+			continue
+		}
+		if step.End.Before(step.Start) {
+			step.End = step.Start
+		}
+		steps = append(steps, step)
+		if job.Start.IsZero() || step.Start.Before(job.Start) {
+			job.Start = step.Start
+		}
+		if step.End.After(job.End) {
+			job.End = step.End
+		}
+	}
+	if job.End.Before(job.Start) {
+		job.End = job.Start
+	}
+	job.Steps = steps
+	return job
+}
+
+// span is a job or step to be written as a Complete event
+type span struct {
+	name       string
+	start, end int64
+	status     string
+}
+
+func writeJob(data *tio.TefData, pid int64, job Job) {
+	spans := []span{{job.Name, micros(job.Start), micros(job.End), job.Status}}
+	for _, step := range job.Steps {
+		spans = append(spans, span{step.Name, micros(step.Start), micros(step.End), step.Status})
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end-spans[i].start > spans[j].end-spans[j].start
+	})
+
+	intervals := make([]lanes.Interval, 0, len(spans))
+	for _, s := range spans {
+		intervals = append(intervals, lanes.Interval{Start: s.start, End: s.end})
+	}
+	for i, lane := range lanes.Assign(intervals) {
+		s := spans[i]
+		tid := int64(lane + 1)
+		event := &events.Complete{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{
+					Name:       s.name,
+					Categories: []string{Category},
+					Timestamp:  s.start,
+					ProcessID:  &pid,
+					ThreadID:   &tid,
+					Color:      statusColors[s.status],
+				},
+			},
+			Duration: s.end - s.start,
+		}
+		if s.status != "" {
+			event.Args = map[string]interface{}{ArgStatus: s.status}
+		}
+		data.Write(event)
+	}
+}
+
+// micros converts the time into microseconds since the Unix epoch
+func micros(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Microsecond)
+}
+
+// groupSteps groups the steps into jobs, in the order that the jobs are first seen
+func groupSteps(steps []jsonStep) []Job {
+	var jobs []Job
+	byName := map[string]int{}
+	for _, s := range steps {
+		name := s.Job
+		if name == "" {
+			name = DefaultJob
+		}
+		i, ok := byName[name]
+		if !ok {
+			i = len(jobs)
+			byName[name] = i
+			jobs = append(jobs, Job{Name: name})
+		}
+		jobs[i].Steps = append(jobs[i].Steps, s.step())
+	}
+	return jobs
+}
+
+// jsonStep is a step as it appears in the input, in either the generic form or GitHub Actions' form
+type jsonStep struct {
+	Name        string     `json:"name"`
+	Job         string     `json:"job"`
+	Start       *time.Time `json:"start"`
+	End         *time.Time `json:"end"`
+	Status      string     `json:"status"`
+	StartedAt   *time.Time `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	Conclusion  string     `json:"conclusion"`
+}
+
+func (s jsonStep) start() time.Time {
+	return firstTime(s.Start, s.StartedAt)
+}
+
+func (s jsonStep) end() time.Time {
+	return firstTime(s.End, s.CompletedAt)
+}
+
+// status prefers the conclusion of GitHub Actions' jobs and steps, as their status is "completed" once they have one
+func (s jsonStep) status() string {
+	if s.Conclusion != "" {
+		return s.Conclusion
+	}
+	return s.Status
+}
+
+func (s jsonStep) step() Step {
+	return Step{Name: s.Name, Start: s.start(), End: s.end(), Status: s.status()}
+}
+
+type jsonJob struct {
+	jsonStep
+	Steps []jsonStep `json:"steps"`
+}
+
+// firstTime returns the first of the times that is given, or the zero time if none are
+func firstTime(times ...*time.Time) time.Time {
+	for _, t := range times {
+		if t != nil {
+			return *t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,93 @@
+package ci_test
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/ci"
+)
+
+const githubJobs = `{"total_count": 2, "jobs": [
+	{"name": "lint", "status": "completed", "conclusion": "failure",
+	 "started_at": "2024-05-01T10:00:05Z", "completed_at": "2024-05-01T10:01:00Z", "steps": []},
+	{"name": "build", "status": "completed", "conclusion": "success",
+	 "started_at": "2024-05-01T10:00:00Z", "completed_at": "2024-05-01T10:05:00Z", "steps": [
+		{"name": "checkout", "status": "completed", "conclusion": "success",
+		 "started_at": "2024-05-01T10:00:00Z", "completed_at": "2024-05-01T10:00:10Z"},
+		{"name": "compile", "status": "completed", "conclusion": "success",
+		 "started_at": "2024-05-01T10:00:10Z", "completed_at": "2024-05-01T10:04:00Z"},
+		{"name": "deploy", "status": "completed", "conclusion": "skipped", "started_at": null, "completed_at": null}
+	]}
+]}`
+
+var _ = Describe("CI timings", func() {
+	completes := func(data *tio.TefData) map[string]*events.Complete {
+		result := map[string]*events.Complete{}
+		for _, e := range data.Events() {
+			if complete, ok := e.(*events.Complete); ok {
+				result[complete.Name] = complete
+			}
+		}
+		return result
+	}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).UnixNano() / 1000
+
+	It("converts GitHub Actions jobs into a process per job, in the order they started", func() {
+		data, err := ci.Parse(strings.NewReader(githubJobs))
+		Expect(err).To(Succeed())
+
+		spans := completes(data)
+		Expect(spans).To(HaveLen(4))
+		Expect(*spans["build"].ProcessID).To(Equal(int64(1)))
+		Expect(*spans["lint"].ProcessID).To(Equal(int64(2)))
+
+		build := spans["build"]
+		Expect(build.Timestamp).To(Equal(start))
+		Expect(build.Duration).To(Equal(int64(5 * time.Minute / time.Microsecond)))
+		Expect(build.Categories).To(Equal([]string{ci.Category}))
+		Expect(build.Args).To(Equal(map[string]interface{}{ci.ArgStatus: "success"}))
+		Expect(build.Color).To(Equal("good"))
+		Expect(spans["lint"].Color).To(Equal("bad"))
+
+		compile := spans["compile"]
+		Expect(compile.Timestamp).To(Equal(start + int64(10*time.Second/time.Microsecond)))
+		Expect(*compile.ProcessID).To(Equal(int64(1)))
+		Expect(*compile.ThreadID).To(Equal(*build.ThreadID))
+	})
+
+	It("groups steps into jobs, moving overlapping steps to threads of their own", func() {
+		data, err := ci.Parse(strings.NewReader(`[
+			{"job": "test", "name": "unit", "start": "2024-05-01T10:00:00Z", "end": "2024-05-01T10:02:00Z"},
+			{"job": "test", "name": "integration", "start": "2024-05-01T10:01:00Z", "end": "2024-05-01T10:03:00Z"},
+			{"name": "notify", "start": "2024-05-01T10:03:00Z", "end": "2024-05-01T10:03:01Z", "status": "success"}
+		]`))
+		Expect(err).To(Succeed())
+
+		spans := completes(data)
+		Expect(spans).To(HaveKey("test"))
+		Expect(spans).To(HaveKey(ci.DefaultJob))
+		Expect(spans["test"].Duration).To(Equal(int64(3 * time.Minute / time.Microsecond)))
+		Expect(*spans["unit"].ThreadID).To(Equal(*spans["test"].ThreadID))
+		Expect(*spans["integration"].ThreadID).ToNot(Equal(*spans["unit"].ThreadID))
+		Expect(spans["notify"].Args).To(Equal(map[string]interface{}{ci.ArgStatus: "success"}))
+	})
+
+	It("converts jobs given directly", func() {
+		at := time.Unix(100, 0)
+		data := ci.Convert([]ci.Job{{Name: "job", Steps: []ci.Step{{Name: "step", Start: at, End: at.Add(time.Second)}}}})
+		spans := completes(data)
+		Expect(spans["job"].Timestamp).To(Equal(int64(100000000)))
+		Expect(spans["job"].Duration).To(Equal(int64(1000000)))
+	})
+
+	It("rejects timings of other shapes", func() {
+		_, err := ci.Parse(strings.NewReader(`"steps"`))
+		Expect(errors.Is(err, ci.ErrInvalidTimings)).To(BeTrue())
+	})
+})
@@ -0,0 +1,105 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// ErrNoCommonClockSync means that a trace has no ClockSync events in common with the traces it is being aligned with
+var ErrNoCommonClockSync = errors.New("no clock sync events in common")
+
+// ClockOffsets works out how many microseconds to add to the timestamps of each trace so that its clock agrees with
+// the clock of the first trace. A trace is aligned using a ClockSync event it shares, by sync ID, with the first trace
+// or with any trace already aligned to it, so agents need only sync with one another rather than with every agent.
+// An error wrapping ErrNoCommonClockSync is returned if any trace cannot be aligned this way.
+func ClockOffsets(traces ...*TefData) ([]int64, error) {
+	offsets := make([]int64, len(traces))
+	if len(traces) < 1 {
+		return offsets, nil
+	}
+
+	times := make([]map[string]int64, len(traces))
+	for i, data := range traces {
+		times[i] = clockSyncTimes(data)
+	}
+
+	aligned := []int{0}
+	isAligned := make([]bool, len(traces))
+	isAligned[0] = true
+	for progress := true; progress; {
+		progress = false
+		for i := range traces {
+			if isAligned[i] {
+				continue
+			}
+			offset, ok := alignTo(times[i], times, offsets, aligned)
+			if !ok {
+				continue
+			}
+			offsets[i] = offset
+			isAligned[i] = true
+			aligned = append(aligned, i)
+			progress = true
+		}
+	}
+
+	for i, ok := range isAligned {
+		if !ok {
+			return nil, fmt.Errorf("unable to align the clock of trace %d: %w", i, ErrNoCommonClockSync)
+		}
+	}
+	return offsets, nil
+}
+
+// AlignClocks shifts the timestamps of every event of each trace, in place, by the offsets found by ClockOffsets,
+// returning the offsets that were applied
+func AlignClocks(traces ...*TefData) ([]int64, error) {
+	offsets, err := ClockOffsets(traces...)
+	if err != nil {
+		return nil, err
+	}
+	for i, data := range traces {
+		ShiftTimestamps(data, offsets[i])
+	}
+	return offsets, nil
+}
+
+// alignTo finds the offset of a trace with the given sync times from the first already aligned trace that it shares
+// a sync ID with, preferring the lowest sync ID so that the result is deterministic
+func alignTo(syncTimes map[string]int64, times []map[string]int64, offsets []int64, aligned []int) (int64, bool) {
+	ids := make([]string, 0, len(syncTimes))
+	for id := range syncTimes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, j := range aligned {
+		for _, id := range ids {
+			if reference, ok := times[j][id]; ok {
+				return offsets[j] + reference - syncTimes[id], true
+			}
+		}
+	}
+	return 0, false
+}
+
+// clockSyncTimes finds the time of each ClockSync event in the trace by its sync ID. For the agent that issued the
+// sync, which records when it was issued as well as when it was recorded, the midpoint of the two is used.
+func clockSyncTimes(data *TefData) map[string]int64 {
+	times := map[string]int64{}
+	for _, e := range data.Events() {
+		sync, ok := e.(*events.ClockSync)
+		if !ok {
+			continue
+		}
+		ts := sync.Timestamp
+		if sync.IssueTs != nil {
+			ts = (*sync.IssueTs + sync.Timestamp) / 2
+		}
+		times[sync.SyncId] = ts
+	}
+	return times
+}
@@ -0,0 +1,62 @@
+package io_test
+
+import (
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("AlignClocks", func() {
+	var a, b, c *teffyio.TefData
+
+	clockSync := func(syncId string, ts int64, issueTs *int64) *events.ClockSync {
+		return &events.ClockSync{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "clock_sync", Timestamp: ts}},
+			SyncId:        syncId,
+			IssueTs:       issueTs,
+		}
+	}
+
+	BeforeEach(func() {
+		a, b, c = &teffyio.TefData{}, &teffyio.TefData{}, &teffyio.TefData{}
+	})
+
+	It("shifts each trace to line up with the first", func() {
+		issueTs := int64(40)
+		a.Write(clockSync("ab", 60, &issueTs))
+		b.Write(clockSync("ab", 20, nil))
+		b.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "later", Timestamp: 25}},
+		})
+
+		offsets, err := teffyio.AlignClocks(a, b)
+		Expect(err).To(Succeed())
+		Expect(offsets).To(Equal([]int64{0, 30}))
+		Expect(a.Events()[0].Core().Timestamp).To(BeEquivalentTo(60))
+		Expect(b.Events()[0].Core().Timestamp).To(BeEquivalentTo(50))
+		Expect(b.Events()[1].Core().Timestamp).To(BeEquivalentTo(55))
+	})
+
+	It("aligns traces via other traces they share a sync with", func() {
+		a.Write(clockSync("ab", 100, nil))
+		b.Write(clockSync("ab", 10, nil))
+		b.Write(clockSync("bc", 20, nil))
+		c.Write(clockSync("bc", 1000, nil))
+
+		offsets, err := teffyio.ClockOffsets(a, c, b)
+		Expect(err).To(Succeed())
+		Expect(offsets).To(Equal([]int64{0, -890, 90}))
+		Expect(c.Events()[0].Core().Timestamp).To(BeEquivalentTo(1000))
+	})
+
+	It("fails when a trace shares no syncs with the others", func() {
+		a.Write(clockSync("ab", 100, nil))
+		b.Write(clockSync("bc", 10, nil))
+
+		_, err := teffyio.AlignClocks(a, b)
+		Expect(err).To(MatchError(teffyio.ErrNoCommonClockSync))
+		Expect(b.Events()[0].Core().Timestamp).To(BeEquivalentTo(10))
+	})
+})
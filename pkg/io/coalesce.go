@@ -0,0 +1,181 @@
+package io
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// DefaultMergedName is the pattern that the events replacing coalesced durations are named by, unless WithMergedName
+// is used
+const DefaultMergedName = "merged %d events"
+
+// CoalesceOption configures how CoalesceDurations merges durations
+type CoalesceOption = func(*coalesceConfig)
+
+// WithMergedName names the events replacing coalesced durations by formatting the pattern with how many durations
+// each replaces, such as "merged %d events"
+func WithMergedName(pattern string) CoalesceOption {
+	return func(c *coalesceConfig) {
+		c.name = pattern
+	}
+}
+
+type coalesceConfig struct {
+	name string
+}
+
+// span is a Complete event, or a matching pair of BeginDuration and EndDuration events, of a thread
+type span struct {
+	index, endIndex int
+	start, end      int64
+	parent          int
+}
+
+// CoalesceDurations returns a copy of the trace in which runs of short durations on the same thread are merged, as
+// Bazel does, reducing traces of very many tiny spans to something viewers can render. Durations are Complete events
+// and matching pairs of BeginDuration and EndDuration events on the same thread. Durations shorter than the
+// threshold, in microseconds, that share the same enclosing duration (or none) and follow one another with gaps
+// shorter than the threshold are replaced by a single Complete event spanning them all, named as WithMergedName says,
+// which takes the place of the first of them. The durations nested within the merged durations are removed along with
+// them, while durations too long to merge, and short durations with no neighbours to merge with, are kept as they are.
+// Events that are kept, and all other data in the trace, are shared with the original.
+func CoalesceDurations(data *TefData, threshold int64, options ...CoalesceOption) *TefData {
+	config := &coalesceConfig{name: DefaultMergedName}
+	for _, opt := range options {
+		opt(config)
+	}
+
+	evs := data.Events()
+	threads := map[threadKey][]span{}
+	open := map[threadKey][]int{}
+	for i, e := range evs {
+		switch event := e.(type) {
+		case *events.Complete:
+			key := threadKeyOf(&event.EventCore)
+			threads[key] = append(threads[key], span{
+				index: i, endIndex: -1, start: event.Timestamp, end: event.Timestamp + event.Duration,
+			})
+		case *events.BeginDuration:
+			key := threadKeyOf(&event.EventCore)
+			open[key] = append(open[key], i)
+		case *events.EndDuration:
+			key := threadKeyOf(&event.EventCore)
+			stack := open[key]
+			if len(stack) < 1 {
+				continue
+			}
+			begun := stack[len(stack)-1]
+			open[key] = stack[:len(stack)-1]
+			if start := evs[begun].Core().Timestamp; event.Timestamp >= start {
+				threads[key] = append(threads[key], span{index: begun, endIndex: i, start: start, end: event.Timestamp})
+			}
+		}
+	}
+
+	removed := make([]bool, len(evs))
+	replaced := map[int]events.Event{}
+	for _, spans := range threads {
+		config.coalesceThread(evs, spans, threshold, removed, replaced)
+	}
+
+	result := *data
+	kept := make([]events.Event, 0, len(evs))
+	for i, e := range evs {
+		if merged, ok := replaced[i]; ok {
+			kept = append(kept, merged)
+		} else if !removed[i] {
+			kept = append(kept, e)
+		}
+	}
+	result.SetEvents(kept)
+	return &result
+}
+
+// coalesceThread merges the runs of short durations of a single thread, marking the events it removes and recording
+// the merged events by the index of the event each replaces
+func (c *coalesceConfig) coalesceThread(evs []events.Event, spans []span, threshold int64, removed []bool,
+	replaced map[int]events.Event) {
+	// parents come before the durations they enclose once sorted by start, longest first
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end-spans[i].start > spans[j].end-spans[j].start
+	})
+	var stack []int
+	for i := range spans {
+		for len(stack) > 0 && spans[stack[len(stack)-1]].end < spans[i].end {
+			stack = stack[:len(stack)-1]
+		}
+		spans[i].parent = -1
+		if len(stack) > 0 {
+			spans[i].parent = stack[len(stack)-1]
+		}
+		stack = append(stack, i)
+	}
+
+	// runs are built up amongst the children of each parent, which appear in order of their starts
+	var runs [][]int
+	current := map[int][]int{}
+	finish := func(parent int) {
+		if run := current[parent]; len(run) > 1 {
+			runs = append(runs, run)
+		}
+		delete(current, parent)
+	}
+	for i, s := range spans {
+		run := current[s.parent]
+		short := s.end-s.start < threshold
+		if len(run) > 0 && (!short || s.start-spans[run[len(run)-1]].end >= threshold) {
+			finish(s.parent)
+		}
+		if short {
+			current[s.parent] = append(current[s.parent], i)
+		}
+	}
+	for parent := range current {
+		finish(parent)
+	}
+
+	merged := make([]bool, len(spans))
+	for _, run := range runs {
+		for _, i := range run {
+			merged[i] = true
+		}
+	}
+	// durations within merged durations go with them
+	gone := make([]bool, len(spans))
+	for i, s := range spans {
+		gone[i] = s.parent >= 0 && (merged[s.parent] || gone[s.parent])
+	}
+
+	for _, run := range runs {
+		first, last := spans[run[0]], spans[run[len(run)-1]]
+		if first.parent >= 0 && (merged[first.parent] || gone[first.parent]) {
+			continue
+		}
+		core := evs[first.index].Core()
+		replaced[first.index] = &events.Complete{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{
+					Name:      fmt.Sprintf(c.name, len(run)),
+					Timestamp: first.start,
+					ProcessID: core.ProcessID,
+					ThreadID:  core.ThreadID,
+				},
+			},
+			Duration: last.end - first.start,
+		}
+	}
+
+	for i, s := range spans {
+		if merged[i] || gone[i] {
+			removed[s.index] = true
+			if s.endIndex >= 0 {
+				removed[s.endIndex] = true
+			}
+		}
+	}
+}
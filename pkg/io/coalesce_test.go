@@ -0,0 +1,118 @@
+package io_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("CoalesceDurations", func() {
+	complete := func(name string, tid, ts, dur int64) *events.Complete {
+		pid := int64(1)
+		return &events.Complete{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: name, Timestamp: ts, ProcessID: &pid, ThreadID: &tid},
+			},
+			Duration: dur,
+		}
+	}
+
+	type summary struct {
+		Name    string
+		Tid     int64
+		Ts, Dur int64
+		Phase   events.Phase
+	}
+	summarise := func(data *teffyio.TefData) []summary {
+		var result []summary
+		for _, e := range data.Events() {
+			s := summary{Name: e.Core().Name, Ts: e.Core().Timestamp, Phase: e.Phase()}
+			if e.Core().ThreadID != nil {
+				s.Tid = *e.Core().ThreadID
+			}
+			if c, ok := e.(*events.Complete); ok {
+				s.Dur = c.Duration
+			}
+			result = append(result, s)
+		}
+		return result
+	}
+
+	coalesce := func(threshold int64, options []teffyio.CoalesceOption, evs ...events.Event) []summary {
+		data := &teffyio.TefData{}
+		for _, e := range evs {
+			data.Write(e)
+		}
+		return summarise(teffyio.CoalesceDurations(data, threshold, options...))
+	}
+
+	It("merges runs of short durations on the same thread", func() {
+		Expect(coalesce(10, nil,
+			events.NewThreadName(1, 1, "worker"),
+			complete("a", 1, 0, 2),
+			complete("b", 1, 3, 2),
+			complete("c", 1, 6, 2),
+			complete("other thread", 2, 4, 2),
+			complete("long", 1, 10, 50),
+			complete("d", 1, 100, 2),
+			complete("e", 1, 103, 2),
+		)).To(Equal([]summary{
+			{Name: "thread_name", Tid: 1, Phase: events.PhaseMetadata},
+			{Name: "merged 3 events", Tid: 1, Ts: 0, Dur: 8, Phase: events.PhaseComplete},
+			{Name: "other thread", Tid: 2, Ts: 4, Dur: 2, Phase: events.PhaseComplete},
+			{Name: "long", Tid: 1, Ts: 10, Dur: 50, Phase: events.PhaseComplete},
+			{Name: "merged 2 events", Tid: 1, Ts: 100, Dur: 5, Phase: events.PhaseComplete},
+		}))
+	})
+
+	It("keeps short durations apart when the gap between them is long", func() {
+		Expect(coalesce(10, nil,
+			complete("a", 1, 0, 2),
+			complete("b", 1, 50, 2),
+		)).To(Equal([]summary{
+			{Name: "a", Tid: 1, Ts: 0, Dur: 2, Phase: events.PhaseComplete},
+			{Name: "b", Tid: 1, Ts: 50, Dur: 2, Phase: events.PhaseComplete},
+		}))
+	})
+
+	It("merges only durations with the same parent, removing their children", func() {
+		Expect(coalesce(10, nil,
+			complete("parent", 1, 0, 100),
+			complete("a", 1, 1, 5),
+			complete("a child", 1, 2, 1),
+			complete("b", 1, 7, 2),
+			complete("after", 1, 101, 2),
+		)).To(Equal([]summary{
+			{Name: "parent", Tid: 1, Ts: 0, Dur: 100, Phase: events.PhaseComplete},
+			{Name: "merged 2 events", Tid: 1, Ts: 1, Dur: 8, Phase: events.PhaseComplete},
+			{Name: "after", Tid: 1, Ts: 101, Dur: 2, Phase: events.PhaseComplete},
+		}))
+	})
+
+	It("merges pairs of begin and end events", func() {
+		pid, tid := int64(1), int64(1)
+		core := func(name string, ts int64) events.EventCore {
+			return events.EventCore{Name: name, Timestamp: ts, ProcessID: &pid, ThreadID: &tid}
+		}
+		Expect(coalesce(10, []teffyio.CoalesceOption{teffyio.WithMergedName("%d tiny spans")},
+			&events.BeginDuration{EventWithArgs: events.EventWithArgs{EventCore: core("a", 0)}},
+			&events.EndDuration{EventWithArgs: events.EventWithArgs{EventCore: core("a", 2)}},
+			&events.BeginDuration{EventWithArgs: events.EventWithArgs{EventCore: core("b", 3)}},
+			&events.EndDuration{EventWithArgs: events.EventWithArgs{EventCore: core("b", 4)}},
+			&events.BeginDuration{EventWithArgs: events.EventWithArgs{EventCore: core("unmatched", 5)}},
+		)).To(Equal([]summary{
+			{Name: "2 tiny spans", Tid: 1, Ts: 0, Dur: 4, Phase: events.PhaseComplete},
+			{Name: "unmatched", Tid: 1, Ts: 5, Phase: events.PhaseBeginDuration},
+		}))
+	})
+
+	It("leaves the original trace untouched", func() {
+		data := &teffyio.TefData{}
+		data.Write(complete("a", 1, 0, 2))
+		data.Write(complete("b", 1, 3, 2))
+		Expect(teffyio.CoalesceDurations(data, 10).Events()).To(HaveLen(1))
+		Expect(data.Events()).To(HaveLen(2))
+	})
+})
@@ -0,0 +1,301 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/omaskery/teffy/internal/jsonfields"
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+const (
+	compactHasProcessID uint8 = 1 << iota
+	compactHasThreadID
+	compactRawIsEvent
+)
+
+// CompactData is a read-only, memory-efficient alternative to TefData for analysing large traces. Rather than
+// allocating a struct per event, the common fields of events are stored in columns, with strings such as names and
+// categories interned, and any args or less common fields kept as compact JSON in a single arena. Individual events
+// are accessed through EventView, which can decode the full event when it is needed.
+type CompactData struct {
+	phases     []uint32
+	names      []uint32
+	categories []uint32
+	timestamps []int64
+	durations  []int64
+	processIDs []int64
+	threadIDs  []int64
+	flags      []uint8
+	// rawOffsets[i] to rawOffsets[i+1] is the range of the arena holding the raw JSON of event i, which is either its
+	// args or, if compactRawIsEvent is set, the entire event
+	rawOffsets []int
+	arena      []byte
+	strings    []string
+}
+
+// compactJsonEvent holds the fields of an event that CompactData stores in columns
+type compactJsonEvent struct {
+	Phase      string            `json:"ph"`
+	Name       string            `json:"name"`
+	Categories string            `json:"cat,omitempty"`
+	Timestamp  events.JsonMicros `json:"ts"`
+	Duration   events.JsonMicros `json:"dur,omitzero"`
+	ProcessID  *events.JsonID    `json:"pid,omitempty"`
+	ThreadID   *events.JsonID    `json:"tid,omitempty"`
+	Args       json.RawMessage   `json:"args,omitempty"`
+}
+
+// exact reports whether the columns CompactData stores hold the event's fields exactly, which they do not for times
+// with fractions of a microsecond or for IDs that were not plain int64s
+func (j *compactJsonEvent) exact() bool {
+	if j.Timestamp.Fraction != 0 || j.Duration.Fraction != 0 {
+		return false
+	}
+	if j.ProcessID != nil && j.ProcessID.Ref != nil {
+		return false
+	}
+	return j.ThreadID == nil || j.ThreadID.Ref == nil
+}
+
+// ParseJsonArrayCompact reads a JSON Array Format variant of a Trace Event Format file from the provided reader into
+// CompactData. Events are only checked against the requirements of their phase when decoded with EventView.Event.
+func ParseJsonArrayCompact(r io.Reader) (*CompactData, error) {
+	decoder := json.NewDecoder(r)
+
+	t, err := decoder.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse first token: %w", err)
+	}
+	if t != json.Delim('[') {
+		return nil, fmt.Errorf("expected '[' at start of json array format: %w", ErrSyntaxError)
+	}
+
+	b := &compactBuilder{
+		data:    &CompactData{rawOffsets: []int{0}},
+		interns: map[string]uint32{},
+	}
+
+	p := newParser()
+	for decoder.More() {
+		e, offset, err := p.decodeNext(decoder)
+		if err != nil && (errors.Is(err, io.EOF) || isTruncatedArray(decoder)) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := b.add(e); err != nil {
+			return nil, &ParseError{
+				Offset:  offset,
+				Index:   p.index,
+				Phase:   rawEventPhase(e),
+				Snippet: snippet(e),
+				Err:     err,
+			}
+		}
+		p.index++
+	}
+
+	return b.data, nil
+}
+
+type compactBuilder struct {
+	data    *CompactData
+	interns map[string]uint32
+}
+
+func (b *compactBuilder) add(raw json.RawMessage) error {
+	fields, err := jsonfields.Split(raw)
+	if err != nil {
+		return fmt.Errorf("error decoding json event: %w", err)
+	}
+	var j compactJsonEvent
+	var extra map[string]json.RawMessage
+	if err := jsonfields.Decode(fields, &j, &extra); err != nil {
+		return fmt.Errorf("error decoding json event: %w", err)
+	}
+
+	d := b.data
+	d.phases = append(d.phases, b.intern(j.Phase))
+	d.names = append(d.names, b.intern(j.Name))
+	d.categories = append(d.categories, b.intern(j.Categories))
+	d.timestamps = append(d.timestamps, j.Timestamp.Whole)
+	d.durations = append(d.durations, j.Duration.Whole)
+
+	var flags uint8
+	var pid, tid int64
+	if j.ProcessID != nil {
+		flags |= compactHasProcessID
+		pid = j.ProcessID.ID
+	}
+	if j.ThreadID != nil {
+		flags |= compactHasThreadID
+		tid = j.ThreadID.ID
+	}
+	d.processIDs = append(d.processIDs, pid)
+	d.threadIDs = append(d.threadIDs, tid)
+
+	var compacted bytes.Buffer
+	if len(extra) > 0 || !j.exact() {
+		flags |= compactRawIsEvent
+		if err := json.Compact(&compacted, raw); err != nil {
+			return err
+		}
+	} else if len(j.Args) > 0 && !bytes.Equal(j.Args, []byte("null")) {
+		if err := json.Compact(&compacted, j.Args); err != nil {
+			return err
+		}
+	}
+	d.arena = append(d.arena, compacted.Bytes()...)
+	d.rawOffsets = append(d.rawOffsets, len(d.arena))
+	d.flags = append(d.flags, flags)
+
+	return nil
+}
+
+func (b *compactBuilder) intern(s string) uint32 {
+	if index, ok := b.interns[s]; ok {
+		return index
+	}
+	index := uint32(len(b.data.strings))
+	b.data.strings = append(b.data.strings, s)
+	b.interns[s] = index
+	return index
+}
+
+// Len is the number of events stored
+func (cd *CompactData) Len() int {
+	return len(cd.phases)
+}
+
+// View provides access to the event at the given index
+func (cd *CompactData) View(i int) EventView {
+	return EventView{data: cd, index: i}
+}
+
+// ToTefData decodes all of the stored events into a TefData with the defaults of the JSON Array Format
+func (cd *CompactData) ToTefData() (*TefData, error) {
+	result := &TefData{
+		displayTimeUnit:        DisplayTimeMs,
+		metadata:               map[string]interface{}{},
+		stackFrames:            map[string]*events.StackFrame{},
+		controllerTraceDataKey: "traceEvents",
+		traceEvents:            make([]events.Event, 0, cd.Len()),
+	}
+	for i := 0; i < cd.Len(); i++ {
+		event, err := cd.View(i).Event()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing event %d: %w", i, err)
+		}
+		result.traceEvents = append(result.traceEvents, event)
+	}
+	return result, nil
+}
+
+// EventView is a lightweight handle to a single event stored in CompactData
+type EventView struct {
+	data  *CompactData
+	index int
+}
+
+// Phase is the phase of the event
+func (v EventView) Phase() events.Phase {
+	return events.Phase(v.data.strings[v.data.phases[v.index]])
+}
+
+// Name is the name of the event
+func (v EventView) Name() string {
+	return v.data.strings[v.data.names[v.index]]
+}
+
+// Categories are the categories of the event
+func (v EventView) Categories() []string {
+	categories := v.data.strings[v.data.categories[v.index]]
+	if categories == "" {
+		return nil
+	}
+	return strings.Split(categories, ",")
+}
+
+// Timestamp is the timestamp of the event in whole microseconds, any fraction of a microsecond is kept by Event
+func (v EventView) Timestamp() int64 {
+	return v.data.timestamps[v.index]
+}
+
+// Duration is the duration of the event in whole microseconds, which is zero for events other than Complete events,
+// any fraction of a microsecond is kept by Event
+func (v EventView) Duration() int64 {
+	return v.data.durations[v.index]
+}
+
+// ProcessID is the process ID of the event, or the int64 that stands in for it (see events.IDRef), the boolean reports
+// whether the event has one
+func (v EventView) ProcessID() (int64, bool) {
+	return v.data.processIDs[v.index], v.data.flags[v.index]&compactHasProcessID != 0
+}
+
+// ThreadID is the thread ID of the event, or the int64 that stands in for it (see events.IDRef), the boolean reports
+// whether the event has one
+func (v EventView) ThreadID() (int64, bool) {
+	return v.data.threadIDs[v.index], v.data.flags[v.index]&compactHasThreadID != 0
+}
+
+// Args decodes the args of the event, if it has any
+func (v EventView) Args() (map[string]interface{}, error) {
+	raw := v.raw()
+	if v.data.flags[v.index]&compactRawIsEvent != 0 {
+		var j struct {
+			Args json.RawMessage `json:"args"`
+		}
+		if err := json.Unmarshal(raw, &j); err != nil {
+			return nil, err
+		}
+		raw = j.Args
+	}
+	if len(raw) < 1 {
+		return nil, nil
+	}
+
+	var args map[string]interface{}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, fmt.Errorf("failed to decode args: %w", err)
+	}
+	return args, nil
+}
+
+// Event decodes the full event
+func (v EventView) Event() (events.Event, error) {
+	raw := v.raw()
+	if v.data.flags[v.index]&compactRawIsEvent == 0 {
+		j := compactJsonEvent{
+			Phase:      string(v.Phase()),
+			Name:       v.Name(),
+			Categories: v.data.strings[v.data.categories[v.index]],
+			Timestamp:  events.JsonMicros{Whole: v.Timestamp()},
+			Duration:   events.JsonMicros{Whole: v.Duration()},
+			Args:       raw,
+		}
+		if pid, ok := v.ProcessID(); ok {
+			j.ProcessID = &events.JsonID{ID: pid}
+		}
+		if tid, ok := v.ThreadID(); ok {
+			j.ThreadID = &events.JsonID{ID: tid}
+		}
+		var err error
+		raw, err = json.Marshal(j)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reconstruct event: %w", err)
+		}
+	}
+	return events.UnmarshalEvent(raw)
+}
+
+func (v EventView) raw() []byte {
+	return v.data.arena[v.data.rawOffsets[v.index]:v.data.rawOffsets[v.index+1]]
+}
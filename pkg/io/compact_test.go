@@ -0,0 +1,91 @@
+package io_test
+
+import (
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("ParseJsonArrayCompact", func() {
+	input := `[
+		{"ph": "X", "name": "work", "cat": "a,b", "ts": 10, "dur": 5, "pid": 1, "tid": 2, "args": {"n": 1}},
+		{"ph": "B", "name": "begin", "ts": 20, "pid": 1},
+		{"ph": "i", "name": "instant", "ts": 30, "s": "g", "custom": true},
+		{"ph": "M", "name": "thread_name", "pid": 1, "tid": 2, "args": {"name": "main"}},`
+
+	var data *teffyio.CompactData
+	var err error
+
+	BeforeEach(func() {
+		data, err = teffyio.ParseJsonArrayCompact(strings.NewReader(input))
+	})
+
+	It("stores every event", func() {
+		Expect(err).To(Succeed())
+		Expect(data.Len()).To(Equal(4))
+	})
+
+	It("provides access to common fields", func() {
+		Expect(err).To(Succeed())
+		v := data.View(0)
+		Expect(v.Phase()).To(Equal(events.PhaseComplete))
+		Expect(v.Name()).To(Equal("work"))
+		Expect(v.Categories()).To(Equal([]string{"a", "b"}))
+		Expect(v.Timestamp()).To(BeEquivalentTo(10))
+		Expect(v.Duration()).To(BeEquivalentTo(5))
+		pid, ok := v.ProcessID()
+		Expect(ok).To(BeTrue())
+		Expect(pid).To(BeEquivalentTo(1))
+		Expect(v.Args()).To(Equal(map[string]interface{}{"n": 1.0}))
+
+		_, ok = data.View(2).ThreadID()
+		Expect(ok).To(BeFalse())
+		Expect(data.View(2).Categories()).To(BeNil())
+		Expect(data.View(2).Args()).To(BeNil())
+	})
+
+	It("decodes the same events as ParseJsonArray", func() {
+		Expect(err).To(Succeed())
+		expected, err := teffyio.ParseJsonArray(strings.NewReader(input))
+		Expect(err).To(Succeed())
+
+		for i, e := range expected.Events() {
+			actual, err := data.View(i).Event()
+			Expect(err).To(Succeed())
+			Expect(actual).To(Equal(e))
+		}
+
+		full, err := data.ToTefData()
+		Expect(err).To(Succeed())
+		Expect(full).To(Equal(expected))
+	})
+
+	It("accepts fractions of a microsecond", func() {
+		input := `[{"ph": "X", "name": "work", "ts": 1.5, "dur": 2.25}]`
+		data, err := teffyio.ParseJsonArrayCompact(strings.NewReader(input))
+		Expect(err).To(Succeed())
+		Expect(data.View(0).Timestamp()).To(BeEquivalentTo(1))
+
+		expected, err := teffyio.ParseJsonArray(strings.NewReader(input))
+		Expect(err).To(Succeed())
+		Expect(data.View(0).Event()).To(Equal(expected.Events()[0]))
+	})
+
+	It("accepts string and unsigned 64-bit IDs", func() {
+		input := `[{"ph": "i", "name": "tick", "ts": 1, "pid": "Browser", "tid": 18446744073709551615}]`
+		data, err := teffyio.ParseJsonArrayCompact(strings.NewReader(input))
+		Expect(err).To(Succeed())
+		_, browser := events.NewIDRef("Browser")
+		pid, ok := data.View(0).ProcessID()
+		Expect(ok).To(BeTrue())
+		Expect(pid).To(Equal(browser))
+
+		expected, err := teffyio.ParseJsonArray(strings.NewReader(input))
+		Expect(err).To(Succeed())
+		Expect(data.View(0).Event()).To(Equal(expected.Events()[0]))
+	})
+})
@@ -0,0 +1,146 @@
+package io
+
+import (
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// ShiftTimestamps adds the delta, in microseconds, to the timestamps of every event of the trace other than metadata,
+// keeping their fractions of a microsecond, including the issue timestamps of ClockSync events. Events are modified in
+// place.
+func ShiftTimestamps(data *TefData, delta int64) {
+	if delta == 0 {
+		return
+	}
+	for _, e := range data.traceEvents {
+		if e.Phase() == events.PhaseMetadata {
+			continue
+		}
+		core := e.Core()
+		core.Timestamp, core.TimestampFraction = addMicros(core.Timestamp, core.TimestampFraction, delta, 0)
+		if sync, ok := e.(*events.ClockSync); ok && sync.IssueTs != nil {
+			issueTs := *sync.IssueTs + delta
+			sync.IssueTs = &issueTs
+		}
+	}
+}
+
+// Crop returns a copy of the trace holding only what happened within the window from start to end, in microseconds
+// and inclusive of both. Metadata events are always kept, and other events without a duration are kept if they fall
+// within the window. Complete events, and matching pairs of BeginDuration and EndDuration events on the same thread,
+// are kept if any part of them overlaps the window: those that lie wholly within it are kept as they are, while those
+// that cross its boundary are replaced by Complete events clipped to the window, without their thread timings as
+// these cannot be clipped. A BeginDuration event without a matching end is treated as lasting until the end of the
+// window. Events that are kept as they are, and all other data in the trace, are shared with the original.
+func Crop(data *TefData, start, end int64) *TefData {
+	evs := data.Events()
+	inWindow := func(core *events.EventCore) bool {
+		return !microsBefore(core.Timestamp, core.TimestampFraction, start, 0) &&
+			!microsBefore(end, 0, core.Timestamp, core.TimestampFraction)
+	}
+
+	// the index of the end of each duration, by the index of its beginning
+	ends := map[int]int{}
+	open := map[threadKey][]int{}
+	for i, e := range evs {
+		switch event := e.(type) {
+		case *events.BeginDuration:
+			key := threadKeyOf(&event.EventCore)
+			open[key] = append(open[key], i)
+		case *events.EndDuration:
+			key := threadKeyOf(&event.EventCore)
+			stack := open[key]
+			if len(stack) < 1 {
+				continue
+			}
+			begun := stack[len(stack)-1]
+			open[key] = stack[:len(stack)-1]
+			if begin := evs[begun].Core(); !microsBefore(event.Timestamp, event.TimestampFraction, begin.Timestamp,
+				begin.TimestampFraction) {
+				ends[begun] = i
+			}
+		}
+	}
+	keptEnds := map[int]bool{}
+	pairedEnds := map[int]bool{}
+	for _, j := range ends {
+		pairedEnds[j] = true
+	}
+
+	var kept []events.Event
+	for i, e := range evs {
+		if e.Phase() == events.PhaseMetadata {
+			kept = append(kept, e)
+			continue
+		}
+
+		switch event := e.(type) {
+		case *events.Complete:
+			if clipped := clipComplete(event, start, end); clipped != nil {
+				kept = append(kept, clipped)
+			}
+		case *events.BeginDuration:
+			j, paired := ends[i]
+			switch {
+			case !paired && inWindow(&event.EventCore):
+				kept = append(kept, event)
+			case !paired && microsBefore(event.Timestamp, event.TimestampFraction, start, 0):
+				complete := &events.Complete{
+					EventWithArgs:   event.EventWithArgs,
+					EventStackTrace: event.EventStackTrace,
+				}
+				complete.Duration, complete.DurationFraction = subtractMicros(end, 0, event.Timestamp,
+					event.TimestampFraction)
+				kept = append(kept, clipComplete(complete, start, end))
+			case paired && inWindow(&event.EventCore) && inWindow(evs[j].Core()):
+				kept = append(kept, event)
+				keptEnds[j] = true
+			case paired:
+				complete := completeFromPair(event, evs[j].(*events.EndDuration))
+				if clipped := clipComplete(complete, start, end); clipped != nil {
+					kept = append(kept, clipped)
+				}
+			}
+		case *events.EndDuration:
+			if keptEnds[i] || (!pairedEnds[i] && inWindow(&event.EventCore)) {
+				kept = append(kept, event)
+			}
+		default:
+			if inWindow(e.Core()) {
+				kept = append(kept, e)
+			}
+		}
+	}
+
+	result := *data
+	result.SetEvents(kept)
+	return &result
+}
+
+// clipComplete returns the event if it lies within the window, a copy of it clipped to the window if it crosses the
+// window's boundary, or nil if it lies outside of the window
+func clipComplete(complete *events.Complete, start, end int64) *events.Complete {
+	finish, finishFraction := addMicros(complete.Timestamp, complete.TimestampFraction, complete.Duration,
+		complete.DurationFraction)
+	if microsBefore(end, 0, complete.Timestamp, complete.TimestampFraction) ||
+		microsBefore(finish, finishFraction, start, 0) {
+		return nil
+	}
+	startsBefore := microsBefore(complete.Timestamp, complete.TimestampFraction, start, 0)
+	finishesAfter := microsBefore(end, 0, finish, finishFraction)
+	if !startsBefore && !finishesAfter {
+		return complete
+	}
+
+	clipped := *complete
+	if startsBefore {
+		clipped.Timestamp, clipped.TimestampFraction = start, 0
+	}
+	if finishesAfter {
+		finish, finishFraction = end, 0
+	}
+	clipped.Duration, clipped.DurationFraction = subtractMicros(finish, finishFraction, clipped.Timestamp,
+		clipped.TimestampFraction)
+	clipped.ThreadTimestamp = nil
+	clipped.ThreadDuration = nil
+	return &clipped
+}
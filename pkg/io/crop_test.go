@@ -0,0 +1,121 @@
+package io_test
+
+import (
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Crop", func() {
+	var data *teffyio.TefData
+	tid := int64(1)
+
+	withArgs := func(name string, ts int64) events.EventWithArgs {
+		return events.EventWithArgs{EventCore: events.EventCore{Name: name, Timestamp: ts, ThreadID: &tid}}
+	}
+	begin := func(name string, ts int64) *events.BeginDuration {
+		return &events.BeginDuration{EventWithArgs: withArgs(name, ts)}
+	}
+	end := func(name string, ts int64) *events.EndDuration {
+		return &events.EndDuration{EventWithArgs: withArgs(name, ts)}
+	}
+	complete := func(name string, ts, dur int64) *events.Complete {
+		return &events.Complete{EventWithArgs: withArgs(name, ts), Duration: dur}
+	}
+
+	type span struct {
+		phase events.Phase
+		name  string
+		ts    int64
+		dur   int64
+	}
+	spans := func(data *teffyio.TefData) []span {
+		var result []span
+		for _, e := range data.Events() {
+			s := span{phase: e.Phase(), name: e.Core().Name, ts: e.Core().Timestamp}
+			if c, ok := e.(*events.Complete); ok {
+				s.dur = c.Duration
+			}
+			result = append(result, s)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		data = &teffyio.TefData{}
+		data.Write(&events.MetadataThreadName{EventCore: events.EventCore{Name: "thread_name", ThreadID: &tid}})
+		data.Write(begin("outer", 0))
+		data.Write(begin("before", 5))
+		data.Write(end("before", 15))
+		data.Write(complete("crossing", 18, 10))
+		data.Write(begin("inside", 20))
+		data.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "instant", Timestamp: 25}},
+		})
+		data.Write(end("inside", 30))
+		data.Write(begin("after", 35))
+		data.Write(end("after", 50))
+		data.Write(complete("later", 60, 5))
+		data.Write(end("outer", 70))
+		data.Write(begin("unfinished", 5))
+	})
+
+	It("keeps what happened in the window, clipping durations that cross it", func() {
+		cropped := teffyio.Crop(data, 10, 40)
+		Expect(spans(cropped)).To(Equal([]span{
+			{phase: events.PhaseMetadata, name: "thread_name"},
+			{phase: events.PhaseComplete, name: "outer", ts: 10, dur: 30},
+			{phase: events.PhaseComplete, name: "before", ts: 10, dur: 5},
+			{phase: events.PhaseComplete, name: "crossing", ts: 18, dur: 10},
+			{phase: events.PhaseBeginDuration, name: "inside", ts: 20},
+			{phase: events.PhaseInstant, name: "instant", ts: 25},
+			{phase: events.PhaseEndDuration, name: "inside", ts: 30},
+			{phase: events.PhaseComplete, name: "after", ts: 35, dur: 5},
+			{phase: events.PhaseComplete, name: "unfinished", ts: 10, dur: 30},
+		}))
+		Expect(data.Events()).To(HaveLen(13))
+		Expect(data.Events()[1].Core().Timestamp).To(BeEquivalentTo(0))
+	})
+
+	It("clips durations with fractions of a microsecond to the window", func() {
+		fractional := &teffyio.TefData{}
+		starting := complete("starting", 9, 2)
+		starting.TimestampFraction, starting.DurationFraction = 0.75, 0.5
+		ending := complete("ending", 39, 5)
+		ending.TimestampFraction = 0.25
+		fractional.Write(starting)
+		fractional.Write(ending)
+		fractional.Write(&events.Instant{EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{Name: "late", Timestamp: 40, TimestampFraction: 0.5},
+		}})
+
+		cropped := teffyio.Crop(fractional, 10, 40)
+		Expect(cropped.Events()).To(HaveLen(2))
+		clipped := cropped.Events()[0].(*events.Complete)
+		Expect(clipped.Timestamp).To(BeEquivalentTo(10))
+		Expect(clipped.TimestampFraction).To(BeZero())
+		Expect(clipped.Duration).To(BeEquivalentTo(2))
+		Expect(clipped.DurationFraction).To(Equal(0.25))
+		clipped = cropped.Events()[1].(*events.Complete)
+		Expect(clipped.Timestamp).To(BeEquivalentTo(39))
+		Expect(clipped.TimestampFraction).To(Equal(0.25))
+		Expect(clipped.Duration).To(BeEquivalentTo(0))
+		Expect(clipped.DurationFraction).To(Equal(0.75))
+	})
+
+	It("shifts timestamps", func() {
+		teffyio.ShiftTimestamps(data, 100)
+		Expect(data.Events()[0].Core().Timestamp).To(BeEquivalentTo(0))
+		Expect(data.Events()[1].Core().Timestamp).To(BeEquivalentTo(100))
+		Expect(data.Events()[12].Core().Timestamp).To(BeEquivalentTo(105))
+	})
+
+	It("keeps fractions of a microsecond when shifting timestamps", func() {
+		data.Events()[1].Core().TimestampFraction = 0.5
+		teffyio.ShiftTimestamps(data, -3)
+		Expect(data.Events()[1].Core().Timestamp).To(BeEquivalentTo(-3))
+		Expect(data.Events()[1].Core().TimestampFraction).To(Equal(0.5))
+	})
+})
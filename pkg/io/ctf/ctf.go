@@ -0,0 +1,260 @@
+// ctf provides conversion of Common Trace Format traces, such as those recorded by LTTng, into trace events, reading
+// them in the text form that babeltrace prints
+package ctf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// Category is the category given to events converted from tracepoints that have no provider
+const Category = "ctf"
+
+var (
+	// linePattern matches each line of babeltrace output: the timestamp, the optional delta from the previous event,
+	// the optional hostname, the tracepoint name and the fields of the event
+	linePattern = regexp.MustCompile(`^\[([^\]]+)\]\s+(?:\([^)]*\)\s+)?(?:(\S+)\s+)??(\S+):(?:\s+(.*))?$`)
+	// timePattern matches the timestamps babeltrace prints: a time of day, optionally preceded by a date, or a
+	// number of seconds
+	timePattern = regexp.MustCompile(`^(?:(\d{4}-\d{2}-\d{2})\s+)?(?:(\d+):(\d+):)?(\d+)(?:\.(\d+))?$`)
+)
+
+// ErrUnrecognisedLine means that a line of the input was not understood as babeltrace's text output
+var ErrUnrecognisedLine = errors.New("unrecognised babeltrace line")
+
+// Action is what a rule does with the tracepoints it matches
+type Action int
+
+const (
+	// ActionInstant converts each tracepoint into an Instant event, which is what happens to tracepoints that no
+	// rule matches
+	ActionInstant Action = iota
+	// ActionBegin converts each tracepoint into a BeginDuration event
+	ActionBegin
+	// ActionEnd converts each tracepoint into an EndDuration event
+	ActionEnd
+	// ActionSkip drops the tracepoints
+	ActionSkip
+)
+
+// Rule decides how tracepoints whose full name, such as "provider:event", matches its pattern are converted
+type Rule struct {
+	// Pattern is matched against the full names of tracepoints
+	Pattern *regexp.Regexp
+	// Action is how the matching tracepoints are converted
+	Action Action
+	// Name optionally overrides the name of the events converted from the tracepoints, which are named after the
+	// tracepoint by default
+	Name string
+}
+
+type threadKey struct {
+	pid, tid int64
+}
+
+type converter struct {
+	data      *tio.TefData
+	rules     []Rule
+	processes map[int64]struct{}
+	threads   map[threadKey]struct{}
+}
+
+// Option allows configuring how tracepoints are converted
+type Option = func(c *converter)
+
+// WithRule adds a rule for converting tracepoints, the first rule to match a tracepoint deciding how it is converted
+func WithRule(rule Rule) Option {
+	return func(c *converter) {
+		c.rules = append(c.rules, rule)
+	}
+}
+
+// WithSpan pairs tracepoints into duration events with the given name, those matching begin starting the span and
+// those matching end finishing it. As with any duration events, spans must begin and end on the same thread.
+func WithSpan(name string, begin, end *regexp.Regexp) Option {
+	return func(c *converter) {
+		c.rules = append(c.rules,
+			Rule{Pattern: begin, Action: ActionBegin, Name: name},
+			Rule{Pattern: end, Action: ActionEnd, Name: name},
+		)
+	}
+}
+
+// WithSkip drops tracepoints whose full name matches the pattern
+func WithSkip(pattern *regexp.Regexp) Option {
+	return WithRule(Rule{Pattern: pattern, Action: ActionSkip})
+}
+
+// Parse reads babeltrace's text output from the provided reader, converting each tracepoint into an event according
+// to the given rules, or into an Instant event if no rule matches it. The fields of each tracepoint, including its
+// context, become the args of its event, and the provider of the tracepoint its category. The process and thread of
+// each event are taken from the vpid and vtid context fields, or the pid and tid fields, and are named after the
+// procname context field.
+func Parse(r io.Reader, options ...Option) (*tio.TefData, error) {
+	c := &converter{
+		data:      &tio.TefData{},
+		processes: map[int64]struct{}{},
+		threads:   map[threadKey]struct{}{},
+	}
+	for _, option := range options {
+		option(c)
+	}
+	c.data.SetDisplayTimeUnit(tio.DisplayTimeMs)
+
+	br := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read babeltrace output: %w", err)
+		}
+		if perr := c.line(strings.TrimRight(line, "\r\n")); perr != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, perr)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	return c.data, nil
+}
+
+func (c *converter) line(line string) error {
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	m := linePattern.FindStringSubmatch(line)
+	if m == nil {
+		return fmt.Errorf("'%s': %w", line, ErrUnrecognisedLine)
+	}
+	ts, err := microseconds(m[1])
+	if err != nil {
+		return err
+	}
+	tracepoint := m[3]
+	fields, err := parseFields(m[4])
+	if err != nil {
+		return fmt.Errorf("fields of '%s': %w", tracepoint, err)
+	}
+
+	rule := c.rule(tracepoint)
+	if rule.Action == ActionSkip {
+		return nil
+	}
+
+	core := events.EventCore{
+		Name:       tracepoint,
+		Categories: []string{Category},
+		Timestamp:  ts,
+		ProcessID:  intField(fields, "vpid", "pid"),
+		ThreadID:   intField(fields, "vtid", "tid"),
+	}
+	if rule.Name != "" {
+		core.Name = rule.Name
+	}
+	if provider := strings.SplitN(tracepoint, ":", 2); len(provider) == 2 {
+		core.Categories = []string{provider[0]}
+	}
+	if core.ProcessID == nil && core.ThreadID != nil {
+		core.ProcessID = core.ThreadID
+	}
+	if procname, ok := fields["procname"].(string); ok && core.ProcessID != nil {
+		c.nameThread(core.ProcessID, core.ThreadID, procname)
+	}
+
+	var args map[string]interface{}
+	if len(fields) > 0 {
+		args = fields
+	}
+	withArgs := events.EventWithArgs{EventCore: core, Args: args}
+	switch rule.Action {
+	case ActionBegin:
+		c.data.Write(&events.BeginDuration{EventWithArgs: withArgs})
+	case ActionEnd:
+		c.data.Write(&events.EndDuration{EventWithArgs: withArgs})
+	default:
+		c.data.Write(&events.Instant{EventWithArgs: withArgs, Scope: events.InstantScopeThread})
+	}
+	return nil
+}
+
+// rule finds the first rule matching the tracepoint, defaulting to converting it to an instant event
+func (c *converter) rule(tracepoint string) Rule {
+	for _, rule := range c.rules {
+		if rule.Pattern.MatchString(tracepoint) {
+			return rule
+		}
+	}
+	return Rule{Action: ActionInstant}
+}
+
+// nameThread names the process and thread of an event after its procname the first time each is seen
+func (c *converter) nameThread(pid, tid *int64, procname string) {
+	if _, ok := c.processes[*pid]; !ok {
+		c.processes[*pid] = struct{}{}
+		c.data.Write(events.NewProcessName(*pid, procname))
+	}
+	if tid == nil {
+		return
+	}
+	if _, ok := c.threads[threadKey{*pid, *tid}]; !ok {
+		c.threads[threadKey{*pid, *tid}] = struct{}{}
+		c.data.Write(events.NewThreadName(*pid, *tid, procname))
+	}
+}
+
+// intField finds the first of the named fields that holds an integer
+func intField(fields map[string]interface{}, names ...string) *int64 {
+	for _, name := range names {
+		switch value := fields[name].(type) {
+		case int64:
+			return &value
+		case uint64:
+			i := int64(value)
+			return &i
+		}
+	}
+	return nil
+}
+
+// microseconds converts one of babeltrace's timestamps to microseconds exactly. Times of day are measured from
+// midnight, or from the Unix epoch when babeltrace was asked to print the date too.
+func microseconds(timestamp string) (int64, error) {
+	m := timePattern.FindStringSubmatch(timestamp)
+	if m == nil {
+		return 0, fmt.Errorf("invalid timestamp '%s': %w", timestamp, ErrUnrecognisedLine)
+	}
+
+	var seconds int64
+	if m[1] != "" {
+		date, err := time.Parse("2006-01-02", m[1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp '%s': %w", timestamp, err)
+		}
+		seconds = date.Unix()
+	}
+	for _, part := range []struct {
+		digits string
+		scale  int64
+	}{{m[2], 3600}, {m[3], 60}, {m[4], 1}} {
+		if part.digits == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(part.digits, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp '%s': %w", timestamp, err)
+		}
+		seconds += n * part.scale
+	}
+
+	micros, _ := strconv.ParseInt((m[5] + "000000")[:6], 10, 64)
+	return seconds*1000000 + micros, nil
+}
@@ -0,0 +1,13 @@
+package ctf_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestCtf(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Ctf Suite")
+}
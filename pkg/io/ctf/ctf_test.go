@@ -0,0 +1,112 @@
+package ctf_test
+
+import (
+	"regexp"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/ctf"
+)
+
+const output = `[13:15:32.140000123] (+?.?????????) host app:request_begin: { cpu_id = 1 }, { vpid = 100, vtid = 101, procname = "server" }, { path = "/index, \"home\"", size = 0x10 }
+[13:15:32.140250000] (+0.000249877) host app:cache_miss: { cpu_id = 1 }, { vpid = 100, vtid = 101, procname = "server" }, { keys = [ [0] = 1, [1] = -2 ], kind = ( "COLD" : container = 2 ), ratio = 0.5, origin = { host = "db" } }
+[13:15:32.141000000] (+0.000750000) host app:request_end: { cpu_id = 1 }, { vpid = 100, vtid = 101, procname = "server" }, { status = 200 }
+[13:15:32.142000000] (+0.001000000) host app:noise: { cpu_id = 0 }, { vpid = 100, vtid = 102, procname = "worker" }, { }
+[13:15:32.143000000] (+0.001000000) host sched_switch: { cpu_id = 0 }, { prev_comm = "swapper/0", prev_tid = 0, next_tid = 7 }
+`
+
+var _ = Describe("Parse", func() {
+	var data *tio.TefData
+
+	BeforeEach(func() {
+		var err error
+		data, err = ctf.Parse(strings.NewReader(output),
+			ctf.WithSkip(regexp.MustCompile(`^app:noise$`)),
+			ctf.WithSpan("request", regexp.MustCompile(`_begin$`), regexp.MustCompile(`_end$`)),
+		)
+		Expect(err).To(Succeed())
+	})
+
+	nonMetadata := func() []events.Event {
+		var result []events.Event
+		for _, e := range data.Events() {
+			if e.Phase() != events.PhaseMetadata {
+				result = append(result, e)
+			}
+		}
+		return result
+	}
+
+	It("pairs tracepoints into spans according to the rules", func() {
+		evs := nonMetadata()
+		Expect(evs).To(HaveLen(4))
+
+		begin, ok := evs[0].(*events.BeginDuration)
+		Expect(ok).To(BeTrue())
+		Expect(begin.Name).To(Equal("request"))
+		Expect(begin.Categories).To(Equal([]string{"app"}))
+		Expect(begin.Timestamp).To(Equal(int64((13*3600+15*60+32)*1000000 + 140000)))
+		Expect(*begin.ProcessID).To(Equal(int64(100)))
+		Expect(*begin.ThreadID).To(Equal(int64(101)))
+		Expect(begin.Args).To(HaveKeyWithValue("path", `/index, "home"`))
+		Expect(begin.Args).To(HaveKeyWithValue("size", int64(16)))
+		Expect(begin.Args).To(HaveKeyWithValue("cpu_id", int64(0x1)))
+
+		end, ok := evs[2].(*events.EndDuration)
+		Expect(ok).To(BeTrue())
+		Expect(end.Name).To(Equal("request"))
+		Expect(end.Args).To(HaveKeyWithValue("status", int64(200)))
+	})
+
+	It("converts other tracepoints to instants, keeping their fields", func() {
+		evs := nonMetadata()
+		instant, ok := evs[1].(*events.Instant)
+		Expect(ok).To(BeTrue())
+		Expect(instant.Name).To(Equal("app:cache_miss"))
+		Expect(instant.Timestamp - evs[0].(*events.BeginDuration).Timestamp).To(Equal(int64(250)))
+
+		Expect(instant.Args).To(HaveKeyWithValue("keys", []interface{}{int64(1), int64(-2)}))
+		Expect(instant.Args).To(HaveKeyWithValue("kind", "COLD"))
+		Expect(instant.Args).To(HaveKeyWithValue("ratio", 0.5))
+		Expect(instant.Args).To(HaveKeyWithValue("origin", map[string]interface{}{"host": "db"}))
+
+		kernel, ok := evs[3].(*events.Instant)
+		Expect(ok).To(BeTrue())
+		Expect(kernel.Name).To(Equal("sched_switch"))
+		Expect(kernel.Categories).To(Equal([]string{ctf.Category}))
+		Expect(kernel.ProcessID).To(BeNil())
+	})
+
+	It("names processes and threads after their procname", func() {
+		var server *tio.Process
+		for _, p := range data.Processes() {
+			if p.ID == 100 {
+				server = p
+			}
+		}
+		Expect(server).NotTo(BeNil())
+		Expect(server.Name).To(Equal("server"))
+		Expect(server.Thread(101).Name).To(Equal("server"))
+	})
+
+	It("reads timestamps in seconds and with dates", func() {
+		data, err := ctf.Parse(strings.NewReader("[1.5] a:b: { }\n[1970-01-02 00:00:01.000001] c:d:\n"))
+		Expect(err).To(Succeed())
+		evs := data.Events()
+		Expect(evs).To(HaveLen(2))
+		Expect(evs[0].(*events.Instant).Timestamp).To(Equal(int64(1500000)))
+		Expect(evs[1].(*events.Instant).Timestamp).To(Equal(int64(86401000001)))
+	})
+
+	It("rejects input that is not babeltrace output", func() {
+		_, err := ctf.Parse(strings.NewReader(`[{"name":"a","ph":"I","ts":1}]`))
+		Expect(err).To(MatchError(ctf.ErrUnrecognisedLine))
+
+		_, err = ctf.Parse(strings.NewReader(`[1.5] a:b: { x = [ }`))
+		Expect(err).To(MatchError(ctf.ErrUnrecognisedLine))
+	})
+})
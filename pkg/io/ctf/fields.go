@@ -0,0 +1,239 @@
+package ctf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fieldParser parses the fields that babeltrace prints for each event, which look like:
+//
+//	{ cpu_id = 0 }, { vpid = 1, procname = "app" }, { count = 2, list = [ [0] = 1, [1] = 2 ], kind = ( "A" : container = 1 ) }
+type fieldParser struct {
+	s   string
+	pos int
+}
+
+// parseFields merges the fields of every block of fields into one map
+func parseFields(s string) (map[string]interface{}, error) {
+	p := &fieldParser{s: s}
+	fields := map[string]interface{}{}
+	for {
+		p.skipSpace()
+		if p.done() {
+			return fields, nil
+		}
+		if p.peek() != '{' {
+			return nil, p.errorf("expected '{'")
+		}
+		block, err := p.structure()
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range block {
+			fields[key] = value
+		}
+		p.skipSpace()
+		if !p.done() && p.peek() == ',' {
+			p.pos++
+		}
+	}
+}
+
+func (p *fieldParser) value() (interface{}, error) {
+	p.skipSpace()
+	if p.done() {
+		return nil, p.errorf("expected a value")
+	}
+	switch c := p.peek(); {
+	case c == '{':
+		return p.structure()
+	case c == '[':
+		return p.array()
+	case c == '(':
+		return p.enumeration()
+	case c == '"':
+		return p.string()
+	default:
+		return p.scalar()
+	}
+}
+
+func (p *fieldParser) structure() (map[string]interface{}, error) {
+	p.pos++
+	fields := map[string]interface{}{}
+	for {
+		p.skipSpace()
+		if p.done() {
+			return nil, p.errorf("unterminated '{'")
+		}
+		if p.peek() == '}' {
+			p.pos++
+			return fields, nil
+		}
+
+		start := p.pos
+		for !p.done() && p.peek() != '=' && p.peek() != '}' {
+			p.pos++
+		}
+		if p.done() || p.peek() != '=' {
+			return nil, p.errorf("expected '=' after field name")
+		}
+		name := strings.TrimSpace(p.s[start:p.pos])
+		p.pos++
+
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = value
+		if err := p.separator('}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// array parses babeltrace's arrays and sequences, whose items are each preceded by their index
+func (p *fieldParser) array() ([]interface{}, error) {
+	p.pos++
+	items := []interface{}{}
+	for {
+		p.skipSpace()
+		if p.done() {
+			return nil, p.errorf("unterminated '['")
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return items, nil
+		}
+
+		if p.peek() == '[' {
+			end := strings.IndexByte(p.s[p.pos:], ']')
+			if end < 0 {
+				return nil, p.errorf("unterminated array index")
+			}
+			p.pos += end + 1
+			p.skipSpace()
+			if p.done() || p.peek() != '=' {
+				return nil, p.errorf("expected '=' after array index")
+			}
+			p.pos++
+		}
+
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+		if err := p.separator(']'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// enumeration parses enumerations, which are printed with their label and integer value, the label is kept as it
+// is the meaningful part
+func (p *fieldParser) enumeration() (interface{}, error) {
+	p.pos++
+	label, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	depth := 1
+	for !p.done() && depth > 0 {
+		switch p.peek() {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '"':
+			if _, err := p.string(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		p.pos++
+	}
+	if depth > 0 {
+		return nil, p.errorf("unterminated '('")
+	}
+	return label, nil
+}
+
+func (p *fieldParser) string() (string, error) {
+	start := p.pos
+	p.pos++
+	for !p.done() {
+		switch p.peek() {
+		case '\\':
+			p.pos += 2
+			continue
+		case '"':
+			p.pos++
+			s, err := strconv.Unquote(p.s[start:p.pos])
+			if err != nil {
+				// babeltrace does not escape everything Go would, so fall back to the raw contents
+				return p.s[start+1 : p.pos-1], nil
+			}
+			return s, nil
+		}
+		p.pos++
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// scalar parses numbers, keeping anything else, such as an unknown value, as a string
+func (p *fieldParser) scalar() (interface{}, error) {
+	start := p.pos
+	for !p.done() && !strings.ContainsRune(",}] \t", rune(p.peek())) {
+		p.pos++
+	}
+	raw := p.s[start:p.pos]
+	if raw == "" {
+		return nil, p.errorf("expected a value")
+	}
+	if i, err := strconv.ParseInt(raw, 0, 64); err == nil {
+		return i, nil
+	}
+	if u, err := strconv.ParseUint(raw, 0, 64); err == nil {
+		return u, nil
+	}
+	if f, err := strconv.ParseFloat(raw, 64); err == nil {
+		return f, nil
+	}
+	return raw, nil
+}
+
+// separator consumes the comma between items, or finds the end of the items
+func (p *fieldParser) separator(end byte) error {
+	p.skipSpace()
+	if p.done() {
+		return p.errorf("expected ',' or '%c'", end)
+	}
+	switch p.peek() {
+	case ',':
+		p.pos++
+		return nil
+	case end:
+		return nil
+	}
+	return p.errorf("expected ',' or '%c'", end)
+}
+
+func (p *fieldParser) skipSpace() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func (p *fieldParser) peek() byte {
+	return p.s[p.pos]
+}
+
+func (p *fieldParser) done() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *fieldParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at column %d: %w", fmt.Sprintf(format, args...), p.pos+1, ErrUnrecognisedLine)
+}
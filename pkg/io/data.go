@@ -2,8 +2,11 @@ package io
 
 import (
 	"encoding/json"
-	"strconv"
+	"fmt"
+	"reflect"
 
+	"github.com/omaskery/teffy/internal/deepcopy"
+	"github.com/omaskery/teffy/internal/jsonfields"
 	"github.com/omaskery/teffy/pkg/events"
 )
 
@@ -19,10 +22,13 @@ const (
 type TefData struct {
 	traceEvents            []events.Event
 	displayTimeUnit        DisplayTimeUnit
+	rawDisplayTimeUnit     string
 	systemTraceEvents      string
 	powerTraceAsString     string
 	stackFrames            map[string]*events.StackFrame
+	samples                []*events.ProfileSample
 	controllerTraceDataKey string
+	otherData              map[string]interface{}
 	metadata               map[string]interface{}
 }
 
@@ -31,6 +37,11 @@ func (td *TefData) Write(e events.Event) {
 	td.traceEvents = append(td.traceEvents, e)
 }
 
+// SetEvents replaces all of the recorded trace events with the given events
+func (td *TefData) SetEvents(evs []events.Event) {
+	td.traceEvents = evs
+}
+
 // SetDisplayTimeUnit sets what units timestamps should be displayed in
 func (td *TefData) SetDisplayTimeUnit(d DisplayTimeUnit) {
 	td.displayTimeUnit = d
@@ -51,6 +62,23 @@ func (td *TefData) SetControllerTraceDataKey(s string) {
 	td.controllerTraceDataKey = s
 }
 
+// SetOtherData stores the provided key value pair in the otherData section of the file
+func (td *TefData) SetOtherData(key string, value interface{}) {
+	if td.otherData == nil {
+		td.otherData = map[string]interface{}{}
+	}
+	td.otherData[key] = value
+}
+
+// SetMetadata stores the provided key value pair at the top level of the file, keys that clash with the standard
+// top level keys of the format are ignored when writing
+func (td *TefData) SetMetadata(key string, value interface{}) {
+	if td.metadata == nil {
+		td.metadata = map[string]interface{}{}
+	}
+	td.metadata[key] = value
+}
+
 // SetStackFrame internally associates the given stack frame with the given id
 func (td *TefData) SetStackFrame(id string, frame *events.StackFrame) {
 	if td.stackFrames == nil {
@@ -59,6 +87,62 @@ func (td *TefData) SetStackFrame(id string, frame *events.StackFrame) {
 	td.stackFrames[id] = frame
 }
 
+// AddSample records the given entry of the file's samples
+func (td *TefData) AddSample(s *events.ProfileSample) {
+	td.samples = append(td.samples, s)
+}
+
+// CollectStackFrames moves the stack frames carried by metadata events, as a Tracer streaming events with interned
+// stack frames writes them, into the stack frames of the trace, removing the metadata events
+func (td *TefData) CollectStackFrames() {
+	kept := td.traceEvents[:0]
+	for _, e := range td.traceEvents {
+		if id, frame, ok := events.StackFrameFromMetadata(e); ok {
+			td.SetStackFrame(id, frame)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	td.traceEvents = kept
+}
+
+// SetSamples replaces all of the file's samples with the given samples
+func (td *TefData) SetSamples(samples []*events.ProfileSample) {
+	td.samples = samples
+}
+
+// Clone returns a deep copy of the trace, sharing nothing with the original, so that either can be changed without
+// affecting the other
+func (td *TefData) Clone() *TefData {
+	clone := *td
+	if td.traceEvents != nil {
+		clone.traceEvents = make([]events.Event, len(td.traceEvents))
+		for i, e := range td.traceEvents {
+			clone.traceEvents[i] = events.Clone(e)
+		}
+	}
+	if td.stackFrames != nil {
+		clone.stackFrames = make(map[string]*events.StackFrame, len(td.stackFrames))
+		for id, frame := range td.stackFrames {
+			copied := *frame
+			clone.stackFrames[id] = &copied
+		}
+	}
+	if td.samples != nil {
+		clone.samples = make([]*events.ProfileSample, len(td.samples))
+		for i, sample := range td.samples {
+			clone.samples[i] = sample.Clone()
+		}
+	}
+	if td.otherData != nil {
+		clone.otherData = deepcopy.Of(td.otherData).(map[string]interface{})
+	}
+	if td.metadata != nil {
+		clone.metadata = deepcopy.Of(td.metadata).(map[string]interface{})
+	}
+	return &clone
+}
+
 // Events retrieves the events stored in the file
 func (td TefData) Events() []events.Event {
 	return td.traceEvents
@@ -69,6 +153,12 @@ func (td TefData) DisplayTimeUnit() DisplayTimeUnit {
 	return td.displayTimeUnit
 }
 
+// RawDisplayTimeUnit gets the display time unit exactly as it appeared in the file this data was parsed from, which may
+// be one that DisplayTimeUnit could not represent, or is empty if the file gave none
+func (td TefData) RawDisplayTimeUnit() string {
+	return td.rawDisplayTimeUnit
+}
+
 // SystemTraceEvents retrieves the system trace string
 func (td TefData) SystemTraceEvents() string {
 	return td.systemTraceEvents
@@ -84,11 +174,21 @@ func (td TefData) StackFrames() map[string]*events.StackFrame {
 	return td.stackFrames
 }
 
+// Samples retrieves the entries of the file's samples, which refer to its stack frames
+func (td TefData) Samples() []*events.ProfileSample {
+	return td.samples
+}
+
 // ControllerTraceDataKey retrieves the key that trace events are stored under for this trace file
 func (td TefData) ControllerTraceDataKey() string {
 	return td.controllerTraceDataKey
 }
 
+// OtherData retrieves the key values stored in the otherData section of this file
+func (td TefData) OtherData() map[string]interface{} {
+	return td.otherData
+}
+
 // Metadata retrieves additional, non standard key values stored at the top level of this file
 func (td TefData) Metadata() map[string]interface{} {
 	return td.metadata
@@ -101,151 +201,68 @@ type stackFrame struct {
 }
 
 type jsonObjectFile struct {
-	TraceEvents            []json.RawMessage      `json:"traceEvents"`
-	DisplayTimeUnit        string                 `json:"displayTimeUnit,omitempty"`
-	StackFrames            map[string]*stackFrame `json:"stackFrames,omitempty"`
-	SystemTraceEvents      string                 `json:"systemTraceEvents,omitempty"`
-	PowerTraceAsString     string                 `json:"powerTraceAsString,omitempty"`
-	ControllerTraceDataKey string                 `json:"controllerTraceDataKey,omitempty"`
-	Metadata               map[string]interface{} `json:"-"`
-}
-
-type jsonEventPhase struct {
-	Phase string `json:"ph"`
-}
-
-type jsonEventCore struct {
-	jsonEventPhase
-	Name            string `json:"name"`
-	Categories      string `json:"cat,omitempty"`
-	Timestamp       int64  `json:"ts"`
-	ThreadTimestamp *int64 `json:"tts,omitempty"`
-	ProcessID       *int64 `json:"pid,omitempty"`
-	ThreadID        *int64 `json:"tid,omitempty"`
-}
-
-type jsonEventWithArgs struct {
-	jsonEventCore
-	Args map[string]interface{} `json:"args,omitempty"`
-}
-
-type jsonStackInfo struct {
-	Stack      []string `json:"stack,omitempty"`
-	StackFrame string   `json:"sf,omitempty"`
-}
-
-type jsonDurationEvent struct {
-	jsonEventWithArgs
-	jsonStackInfo
-}
-
-type jsonCompleteEvent struct {
-	jsonEventWithArgs
-	jsonStackInfo
-	Duration      int64    `json:"dur,omitempty"`
-	EndStack      []string `json:"estack,omitempty"`
-	EndStackFrame string   `json:"esf,omitempty"`
-}
-
-type jsonInstantEvent struct {
-	jsonEventCore
-	jsonStackInfo
-	Scope string `json:"s,omitempty"`
-}
-
-type jsonCounterEvent struct {
-	jsonEventCore
-	Values map[string]float64 `json:"args,omitempty"`
-}
-
-type numberOrString struct {
-	number float64
-	str    string
-}
-
-func (nos *numberOrString) UnmarshalJSON(data []byte) error {
-	if err := json.Unmarshal(data, &nos.str); err != nil {
-		return json.Unmarshal(data, &nos.number)
+	TraceEvents            []json.RawMessage       `json:"traceEvents,omitempty"`
+	DisplayTimeUnit        string                  `json:"displayTimeUnit,omitempty"`
+	StackFrames            map[string]*stackFrame  `json:"stackFrames,omitempty"`
+	Samples                []*events.ProfileSample `json:"samples,omitempty"`
+	SystemTraceEvents      string                  `json:"systemTraceEvents,omitempty"`
+	PowerTraceAsString     string                  `json:"powerTraceAsString,omitempty"`
+	ControllerTraceDataKey string                  `json:"controllerTraceDataKey,omitempty"`
+	OtherData              map[string]interface{}  `json:"otherData,omitempty"`
+	Metadata               map[string]interface{}  `json:"-"`
+}
+
+// jsonObjectFileFields is used to (un)marshal the well known fields of a jsonObjectFile without recursing
+type jsonObjectFileFields jsonObjectFile
+
+func (f *jsonObjectFile) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
 	}
 
-	return nil
-}
-
-type tempJsonCounterEvent struct {
-	jsonEventCore
-	Values map[string]numberOrString `json:"args,omitempty"`
-}
-
-func (ce *jsonCounterEvent) UnmarshalJSON(data []byte) error {
-	t := &tempJsonCounterEvent{}
-	if err := json.Unmarshal(data, t); err != nil {
+	var fields jsonObjectFileFields
+	if err := json.Unmarshal(data, &fields); err != nil {
 		return err
 	}
-	ce.jsonEventCore = t.jsonEventCore
-	ce.Values = make(map[string]float64)
-	for k, numberOrStr := range t.Values {
-		value := numberOrStr.number
-
-		if numberOrStr.str != "" {
-			f, err := strconv.ParseFloat(numberOrStr.str, 64)
-			if err != nil {
-				return err
-			}
-			value = f
-		}
+	*f = jsonObjectFile(fields)
 
-		ce.Values[k] = value
+	known := jsonfields.Index(reflect.TypeOf(fields))
+	for key, value := range members {
+		if _, ok := known[key]; ok {
+			continue
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(value, &decoded); err != nil {
+			return err
+		}
+		if f.Metadata == nil {
+			f.Metadata = map[string]interface{}{}
+		}
+		f.Metadata[key] = decoded
 	}
-	return nil
-}
 
-type jsonId2 struct {
-	Local  string `json:"local,omitempty"`
-	Global string `json:"global,omitempty"`
-}
-
-type jsonId struct {
-	Id  string   `json:"id,omitempty"`
-	Id2 *jsonId2 `json:"id2,omitempty"`
-}
-
-type jsonScopedId struct {
-	jsonId
-	Scope string `json:"scope,omitempty"`
-}
-
-type jsonAsyncEvent struct {
-	jsonEventWithArgs
-	jsonScopedId
-}
-
-type jsonObjectEvent struct {
-	jsonEventWithArgs
-	jsonScopedId
-}
-
-type jsonMetadataEvent struct {
-	jsonEventWithArgs
-}
-
-type jsonMemoryDumpEvent struct {
-	jsonEventWithArgs
-}
-
-type jsonMarkEvent struct {
-	jsonEventWithArgs
+	return nil
 }
 
-type jsonClockSyncEvent struct {
-	jsonEventWithArgs
-}
+func (f jsonObjectFile) MarshalJSON() ([]byte, error) {
+	msg, err := json.Marshal(jsonObjectFileFields(f))
+	if err != nil || len(f.Metadata) < 1 {
+		return msg, err
+	}
 
-type jsonContextEvent struct {
-	jsonEventWithArgs
-	jsonId
-}
+	known := jsonfields.Index(reflect.TypeOf(f))
+	extra := make(map[string]json.RawMessage, len(f.Metadata))
+	for key, value := range f.Metadata {
+		if _, ok := known[key]; ok {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal metadata '%s': %w", key, err)
+		}
+		extra[key] = encoded
+	}
 
-type jsonLinkedIdEvent struct {
-	jsonEventWithArgs
-	jsonId
+	return jsonfields.AppendExtra(msg, extra, known)
 }
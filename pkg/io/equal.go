@@ -0,0 +1,236 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// EqualOption configures what Equal takes into account when comparing traces
+type EqualOption = func(*equalConfig)
+
+// IgnoreOrder has Equal treat traces holding the same events in a different order as equal
+func IgnoreOrder() EqualOption {
+	return func(c *equalConfig) {
+		c.ignoreOrder = true
+	}
+}
+
+// IgnoreTimestamps has Equal ignore when events happened and how long they took, their timestamps, thread timestamps
+// and durations, along with the timestamps of the trace's samples
+func IgnoreTimestamps() EqualOption {
+	return func(c *equalConfig) {
+		c.ignoreTimestamps = true
+	}
+}
+
+// IgnoreArgs has Equal ignore the args of events
+func IgnoreArgs() EqualOption {
+	return func(c *equalConfig) {
+		c.ignoreArgs = true
+	}
+}
+
+type equalConfig struct {
+	ignoreOrder      bool
+	ignoreTimestamps bool
+	ignoreArgs       bool
+}
+
+// Equal reports whether two traces hold the same events and other data. Events are compared as they would be written
+// to a file, so that a trace read from a file equals the trace it was written from: args holding numbers of different
+// types are equal if they hold the same values, and missing values that a file is read as having by default, such as
+// the scope of instant events, equal those defaults. The options loosen the comparison, such as for golden file tests of
+// tools whose output varies from run to run.
+func Equal(a, b *TefData, options ...EqualOption) bool {
+	config := &equalConfig{}
+	for _, opt := range options {
+		opt(config)
+	}
+
+	if a == nil || b == nil {
+		return a == b
+	}
+	if displayedIn(a) != displayedIn(b) || a.systemTraceEvents != b.systemTraceEvents ||
+		a.powerTraceAsString != b.powerTraceAsString || controllerKey(a) != controllerKey(b) {
+		return false
+	}
+	if !equalStackFrames(a.stackFrames, b.stackFrames) {
+		return false
+	}
+	if len(a.samples) != len(b.samples) || (len(a.samples) > 0 &&
+		!equalEncoded(config.comparableSamples(a.samples), config.comparableSamples(b.samples))) {
+		return false
+	}
+	if !equalMaps(a.otherData, b.otherData) || !equalMaps(a.metadata, b.metadata) {
+		return false
+	}
+	return config.equalEvents(a.traceEvents, b.traceEvents)
+}
+
+func (c *equalConfig) equalEvents(a, b []events.Event) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	comparableA := make([]comparableEvent, len(a))
+	comparableB := make([]comparableEvent, len(b))
+	for i := range a {
+		comparableA[i] = c.strip(a[i])
+		comparableB[i] = c.strip(b[i])
+	}
+
+	if !c.ignoreOrder {
+		for i := range comparableA {
+			if !comparableA[i].equal(comparableB[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// events that can be encoded are compared as sorted encodings, any others are matched up one by one
+	var encodedA, encodedB []string
+	var otherA, otherB []comparableEvent
+	for i := range comparableA {
+		if comparableA[i].encoded != nil {
+			encodedA = append(encodedA, string(comparableA[i].encoded))
+		} else {
+			otherA = append(otherA, comparableA[i])
+		}
+		if comparableB[i].encoded != nil {
+			encodedB = append(encodedB, string(comparableB[i].encoded))
+		} else {
+			otherB = append(otherB, comparableB[i])
+		}
+	}
+	sort.Strings(encodedA)
+	sort.Strings(encodedB)
+	if !reflect.DeepEqual(encodedA, encodedB) || len(otherA) != len(otherB) {
+		return false
+	}
+	for _, event := range otherA {
+		matched := -1
+		for i, other := range otherB {
+			if event.equal(other) {
+				matched = i
+				break
+			}
+		}
+		if matched < 0 {
+			return false
+		}
+		otherB = append(otherB[:matched], otherB[matched+1:]...)
+	}
+	return true
+}
+
+// comparableEvent is an event without whatever is being ignored, along with its encoding if it can be encoded
+type comparableEvent struct {
+	event   events.Event
+	encoded []byte
+}
+
+func (c comparableEvent) equal(other comparableEvent) bool {
+	if c.encoded != nil && other.encoded != nil {
+		return bytes.Equal(c.encoded, other.encoded)
+	}
+	// events that cannot be written, such as those missing IDs, are compared as they are
+	return reflect.DeepEqual(c.event, other.event)
+}
+
+// strip prepares the event for comparison, without whatever is being ignored
+func (c *equalConfig) strip(e events.Event) comparableEvent {
+	if instant, ok := e.(*events.Instant); ok && instant.Scope == "" {
+		scoped := *instant
+		scoped.Scope = events.InstantScopeGlobal
+		e = &scoped
+	}
+	if c.ignoreTimestamps || c.ignoreArgs {
+		e = events.Clone(e)
+		core := e.Core()
+		if c.ignoreTimestamps {
+			core.Timestamp = 0
+			core.ThreadTimestamp = nil
+			if complete, ok := e.(*events.Complete); ok {
+				complete.Duration = 0
+				complete.ThreadDuration = nil
+			}
+		}
+		if c.ignoreArgs {
+			if setter, ok := e.(events.ArgSetter); ok {
+				setter.SetArgs(nil)
+			}
+		}
+	}
+
+	// events that cannot be encoded are left without an encoding
+	encoded, _ := events.MarshalEvent(e)
+	return comparableEvent{event: e, encoded: encoded}
+}
+
+func (c *equalConfig) comparableSamples(samples []*events.ProfileSample) []*events.ProfileSample {
+	if !c.ignoreTimestamps {
+		return samples
+	}
+	stripped := make([]*events.ProfileSample, len(samples))
+	for i, sample := range samples {
+		stripped[i] = sample.Clone()
+		stripped[i].Timestamp = 0
+	}
+	return stripped
+}
+
+// displayedIn returns the unit the trace is displayed in, which is milliseconds unless it says otherwise
+func displayedIn(td *TefData) DisplayTimeUnit {
+	if td.displayTimeUnit == "" {
+		return DisplayTimeMs
+	}
+	return td.displayTimeUnit
+}
+
+// controllerKey returns the key the trace's events are stored under, which is traceEvents unless it says otherwise
+func controllerKey(td *TefData) string {
+	if td.controllerTraceDataKey == "" {
+		return "traceEvents"
+	}
+	return td.controllerTraceDataKey
+}
+
+func equalStackFrames(a, b map[string]*events.StackFrame) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, frame := range a {
+		other, ok := b[id]
+		if !ok || *frame != *other {
+			return false
+		}
+	}
+	return true
+}
+
+// equalMaps reports whether the maps hold the same values, as equalEncoded does, missing and empty maps being equal
+func equalMaps(a, b map[string]interface{}) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	return equalEncoded(a, b)
+}
+
+// equalEncoded reports whether the values encode to the same JSON, so that numbers of different types are equal
+func equalEncoded(a, b interface{}) bool {
+	return bytes.Equal(mustMarshal(a), mustMarshal(b))
+}
+
+// mustMarshal encodes the value, falling back to its Go syntax representation should it not be encodable
+func mustMarshal(v interface{}) []byte {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return []byte(fmt.Sprintf("%#v", v))
+	}
+	return encoded
+}
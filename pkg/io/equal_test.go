@@ -0,0 +1,98 @@
+package io_test
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Cloning and comparing traces", func() {
+	var data *teffyio.TefData
+	pid := int64(1)
+
+	BeforeEach(func() {
+		data = &teffyio.TefData{}
+		data.Write(&events.Complete{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "work", Timestamp: 10, ProcessID: &pid},
+				Args:      map[string]interface{}{"n": 5, "nested": map[string]interface{}{"list": []interface{}{1, 2}}},
+			},
+			Duration: 5,
+		})
+		data.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "tick", Timestamp: 12, ProcessID: &pid},
+			},
+		})
+		data.SetStackFrame("s1", &events.StackFrame{Category: "main.go", Name: "main"})
+		data.AddSample(&events.ProfileSample{ThreadID: 1, Timestamp: 11, StackFrameID: "s1"})
+		data.SetOtherData("version", "1")
+	})
+
+	It("clones traces deeply", func() {
+		clone := data.Clone()
+		Expect(teffyio.Equal(data, clone)).To(BeTrue())
+
+		complete := clone.Events()[0].(*events.Complete)
+		*complete.ProcessID = 2
+		complete.Args["nested"].(map[string]interface{})["list"].([]interface{})[0] = 3
+		clone.StackFrames()["s1"].Name = "other"
+		clone.Samples()[0].Timestamp = 0
+		clone.OtherData()["version"] = "2"
+
+		original := data.Events()[0].(*events.Complete)
+		Expect(*original.ProcessID).To(Equal(int64(1)))
+		Expect(original.Args["nested"]).To(Equal(map[string]interface{}{"list": []interface{}{1, 2}}))
+		Expect(data.StackFrames()["s1"].Name).To(Equal("main"))
+		Expect(data.Samples()[0].Timestamp).To(Equal(int64(11)))
+		Expect(data.OtherData()["version"]).To(Equal("1"))
+		Expect(teffyio.Equal(data, clone)).To(BeFalse())
+	})
+
+	It("treats traces read back from files as equal to those they were written from", func() {
+		var buffer bytes.Buffer
+		Expect(teffyio.WriteJsonObject(&buffer, *data)).To(Succeed())
+		parsed, err := teffyio.ParseJsonObj(&buffer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(teffyio.Equal(data, parsed)).To(BeTrue())
+	})
+
+	It("can ignore the order of events", func() {
+		reordered := data.Clone()
+		evs := reordered.Events()
+		reordered.SetEvents([]events.Event{evs[1], evs[0]})
+		Expect(teffyio.Equal(data, reordered)).To(BeFalse())
+		Expect(teffyio.Equal(data, reordered, teffyio.IgnoreOrder())).To(BeTrue())
+	})
+
+	It("can ignore timestamps", func() {
+		shifted := data.Clone()
+		for _, e := range shifted.Events() {
+			e.Core().Timestamp += 100
+		}
+		shifted.Events()[0].(*events.Complete).Duration = 7
+		shifted.Samples()[0].Timestamp = 0
+		Expect(teffyio.Equal(data, shifted)).To(BeFalse())
+		Expect(teffyio.Equal(data, shifted, teffyio.IgnoreTimestamps())).To(BeTrue())
+	})
+
+	It("can ignore args", func() {
+		changed := data.Clone()
+		changed.Events()[0].(*events.Complete).Args = map[string]interface{}{"other": true}
+		Expect(teffyio.Equal(data, changed)).To(BeFalse())
+		Expect(teffyio.Equal(data, changed, teffyio.IgnoreArgs())).To(BeTrue())
+	})
+
+	It("compares events that cannot be written as they are", func() {
+		a := &teffyio.TefData{}
+		a.Write(&events.AsyncBegin{EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "a"}}})
+		b := a.Clone()
+		Expect(teffyio.Equal(a, b, teffyio.IgnoreOrder())).To(BeTrue())
+		b.Events()[0].Core().Name = "b"
+		Expect(teffyio.Equal(a, b)).To(BeFalse())
+	})
+})
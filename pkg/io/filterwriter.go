@@ -0,0 +1,72 @@
+package io
+
+import (
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// decoratedWriter holds what is common to the writers that change the events written to another writer
+type decoratedWriter struct {
+	inner EventWriter
+}
+
+// WriteStackFrame passes the stack frame on untouched, as a metadata event if the inner writer cannot hold stack
+// frames, as events that are written may refer to it
+func (dw *decoratedWriter) WriteStackFrame(id string, frame *events.StackFrame) error {
+	if sfw, ok := dw.inner.(StackFrameWriter); ok {
+		return sfw.WriteStackFrame(id, frame)
+	}
+	return dw.inner.Write(events.NewStackFrameMetadata(id, frame))
+}
+
+// Transient reports whether the inner writer has finished with each event once it is written
+func (dw *decoratedWriter) Transient() bool {
+	transient, ok := dw.inner.(TransientWriter)
+	return ok && transient.Transient()
+}
+
+// Close closes the inner writer
+func (dw *decoratedWriter) Close() error {
+	return dw.inner.Close()
+}
+
+type filterWriter struct {
+	decoratedWriter
+	predicate func(e events.Event) bool
+}
+
+// FilterWriter creates an event writer that writes only the events matching the predicate to inner, discarding the
+// rest, so that traces can be filtered as they are written rather than once they are loaded (predicates from the
+// filter package can be used). Stack frames are always written to inner, as the events kept may refer to them.
+func FilterWriter(inner EventWriter, predicate func(e events.Event) bool) EventWriter {
+	return &filterWriter{decoratedWriter: decoratedWriter{inner: inner}, predicate: predicate}
+}
+
+// Write writes the event to the inner writer if it matches the predicate
+func (fw *filterWriter) Write(e events.Event) error {
+	if !fw.predicate(e) {
+		return nil
+	}
+	return fw.inner.Write(e)
+}
+
+type mapWriter struct {
+	decoratedWriter
+	fn func(e events.Event) events.Event
+}
+
+// MapWriter creates an event writer that writes the event returned by fn for each event written to inner, discarding
+// the event if fn returns nil, so that events can be transformed as they are written, such as to rename or scrub them.
+// fn may change the event it is given and return it, or return another event. Stack frames are written to inner
+// untouched.
+func MapWriter(inner EventWriter, fn func(e events.Event) events.Event) EventWriter {
+	return &mapWriter{decoratedWriter: decoratedWriter{inner: inner}, fn: fn}
+}
+
+// Write writes the event returned by fn for the event to the inner writer, if there is one
+func (mw *mapWriter) Write(e events.Event) error {
+	mapped := mw.fn(e)
+	if mapped == nil {
+		return nil
+	}
+	return mw.inner.Write(mapped)
+}
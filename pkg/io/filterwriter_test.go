@@ -0,0 +1,90 @@
+package io_test
+
+import (
+	"bytes"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Decorating writers", func() {
+	var ring *teffyio.RingWriter
+
+	instant := func(name string) events.Event {
+		return &events.Instant{EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: name}}}
+	}
+	names := func(evs []events.Event) []string {
+		result := []string{}
+		for _, e := range evs {
+			result = append(result, e.Core().Name)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		ring = teffyio.NewRingWriter(10)
+	})
+
+	Describe("FilterWriter", func() {
+		It("writes only the events matching the predicate", func() {
+			writer := teffyio.FilterWriter(ring, func(e events.Event) bool {
+				return !strings.HasPrefix(e.Core().Name, "noisy")
+			})
+			for _, name := range []string{"a", "noisy b", "c", "noisy d"} {
+				Expect(writer.Write(instant(name))).To(Succeed())
+			}
+			Expect(writer.Close()).To(Succeed())
+			Expect(names(ring.Events())).To(Equal([]string{"a", "c"}))
+		})
+
+		It("always writes stack frames", func() {
+			writer := teffyio.FilterWriter(ring, func(events.Event) bool { return false })
+			frame := &events.StackFrame{Name: "main"}
+			Expect(writer.(teffyio.StackFrameWriter).WriteStackFrame("1", frame)).To(Succeed())
+			Expect(ring.StackFrames()).To(Equal(map[string]*events.StackFrame{"1": frame}))
+		})
+
+		It("writes stack frames as metadata events to writers that cannot hold them", func() {
+			var buf bytes.Buffer
+			writer := teffyio.FilterWriter(teffyio.NewJsonLinesWriter(writerNoopCloser(&buf)), func(events.Event) bool {
+				return false
+			})
+			Expect(writer.(teffyio.StackFrameWriter).WriteStackFrame("1", &events.StackFrame{Name: "main"})).To(Succeed())
+			Expect(writer.Close()).To(Succeed())
+			data, err := teffyio.ParseJsonLines(&buf)
+			Expect(err).To(Succeed())
+			data.CollectStackFrames()
+			Expect(data.StackFrames()).To(HaveKey("1"))
+		})
+
+		It("is transient when the inner writer is", func() {
+			var buf bytes.Buffer
+			transient := teffyio.FilterWriter(teffyio.NewJsonLinesWriter(writerNoopCloser(&buf)), nil)
+			Expect(transient.(teffyio.TransientWriter).Transient()).To(BeTrue())
+			Expect(teffyio.FilterWriter(ring, nil).(teffyio.TransientWriter).Transient()).To(BeFalse())
+		})
+	})
+
+	Describe("MapWriter", func() {
+		It("writes the events returned, discarding those mapped to nil", func() {
+			writer := teffyio.MapWriter(ring, func(e events.Event) events.Event {
+				if e.Core().Name == "secret" {
+					return nil
+				}
+				if e.Core().Name == "replaced" {
+					return instant("replacement")
+				}
+				e.Core().Name = strings.ToUpper(e.Core().Name)
+				return e
+			})
+			for _, name := range []string{"a", "secret", "replaced", "b"} {
+				Expect(writer.Write(instant(name))).To(Succeed())
+			}
+			Expect(names(ring.Events())).To(Equal([]string{"A", "replacement", "B"}))
+		})
+	})
+})
@@ -0,0 +1,355 @@
+// ftrace provides conversion of the text output of Linux's ftrace into trace events, such as the trace_pipe output
+// that systrace stores in the systemTraceEvents of the traces it captures
+package ftrace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// Category is the category given to all events converted from ftrace
+const Category = "ftrace"
+
+// DefaultCPUProcessID is the ID of the process that the CPUs are shown as the threads of, unless WithCPUProcessID
+// is used. Linux never gives a process this ID, so it cannot clash with the processes being traced.
+const DefaultCPUProcessID int64 = 1 << 22
+
+var (
+	// linePattern matches each line of ftrace output: the command and thread ID of the task, the optional thread
+	// group ID, the CPU, the optional irq and preemption flags, the time in seconds, the event name and its fields
+	linePattern = regexp.MustCompile(`^\s*(.+?)-(\d+)\s+(?:\(\s*([\d-]+)\)\s+)?\[(\d+)\]\s+(?:\S{4,5}\s+)?(\d+)\.(\d+):\s+([^:\s]+):\s?(.*)$`)
+	// fieldPattern matches the key=value fields that most events print
+	fieldPattern = regexp.MustCompile(`(\w+)=(\S*)`)
+	// lostPattern matches the lines ftrace writes when its buffers overflow
+	lostPattern = regexp.MustCompile(`^CPU:\d+ \[LOST \d+ EVENTS\]$`)
+)
+
+// ErrUnrecognisedLine means that a line of the input was not understood as ftrace output
+var ErrUnrecognisedLine = errors.New("unrecognised ftrace line")
+
+type threadKey struct {
+	pid, tid int64
+}
+
+// task is what is running on a CPU, and since when
+type task struct {
+	command string
+	tid     int64
+	since   int64
+}
+
+type converter struct {
+	data         *tio.TefData
+	cpuProcessID int64
+	// tgids are the thread group IDs of the threads, which are the IDs of their processes
+	tgids     map[int64]int64
+	processes map[int64]struct{}
+	threads   map[threadKey]struct{}
+	running   map[int64]*task
+	last      int64
+}
+
+// Option allows configuring how ftrace output is converted
+type Option = func(c *converter)
+
+// WithCPUProcessID changes the ID of the process that the CPUs are shown as the threads of
+func WithCPUProcessID(pid int64) Option {
+	return func(c *converter) {
+		c.cpuProcessID = pid
+	}
+}
+
+// Parse reads ftrace's text output from the provided reader, converting it into events:
+//   - the atrace markers that Android and systrace write with tracing_mark_write become duration, counter and async
+//     events of the process and thread that wrote them
+//   - sched_switch events become Complete events for each task scheduled onto a CPU, on a thread per CPU of a
+//     process separate from those being traced
+//   - any other event becomes an Instant event, its key=value fields becoming its args
+//
+// Threads and processes are named after the commands of their tasks. The process of a task is taken from its thread
+// group ID when ftrace prints it, or from the atrace markers it writes, and is otherwise assumed to be the task itself.
+func Parse(r io.Reader, options ...Option) (*tio.TefData, error) {
+	c := &converter{
+		data:         &tio.TefData{},
+		cpuProcessID: DefaultCPUProcessID,
+		tgids:        map[int64]int64{},
+		processes:    map[int64]struct{}{},
+		threads:      map[threadKey]struct{}{},
+		running:      map[int64]*task{},
+	}
+	for _, option := range options {
+		option(c)
+	}
+	c.data.SetDisplayTimeUnit(tio.DisplayTimeMs)
+
+	br := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read ftrace output: %w", err)
+		}
+		if perr := c.line(strings.TrimRight(line, "\r\n")); perr != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, perr)
+		}
+		if err != nil {
+			break
+		}
+	}
+	c.finishScheduling()
+
+	return c.data, nil
+}
+
+// ConvertSystemTraceEvents converts the systemTraceEvents of the data from ftrace's text output into events, as Parse
+// does, adding them to the data's events and clearing its systemTraceEvents
+func ConvertSystemTraceEvents(data *tio.TefData, options ...Option) error {
+	if data.SystemTraceEvents() == "" {
+		return nil
+	}
+	converted, err := Parse(strings.NewReader(data.SystemTraceEvents()), options...)
+	if err != nil {
+		return fmt.Errorf("failed to convert system trace events: %w", err)
+	}
+	for _, e := range converted.Events() {
+		data.Write(e)
+	}
+	data.SetSystemTraceEvents("")
+	return nil
+}
+
+func (c *converter) line(line string) error {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || lostPattern.MatchString(trimmed) {
+		return nil
+	}
+
+	m := linePattern.FindStringSubmatch(line)
+	if m == nil {
+		return fmt.Errorf("'%s': %w", line, ErrUnrecognisedLine)
+	}
+	command := m[1]
+	tid, _ := strconv.ParseInt(m[2], 10, 64)
+	if tgid, err := strconv.ParseInt(m[3], 10, 64); err == nil {
+		c.tgids[tid] = tgid
+	}
+	cpu, _ := strconv.ParseInt(m[4], 10, 64)
+	ts, err := microseconds(m[5], m[6])
+	if err != nil {
+		return err
+	}
+	c.last = ts
+	name, body := m[7], m[8]
+
+	switch name {
+	case "tracing_mark_write":
+		return c.marker(command, tid, ts, body)
+	case "sched_switch":
+		return c.schedSwitch(cpu, ts, body)
+	}
+
+	pid := c.pid(tid)
+	c.nameThread(pid, tid, command)
+	c.data.Write(&events.Instant{
+		EventWithArgs: events.EventWithArgs{EventCore: c.core(name, ts, pid, tid), Args: fields(body)},
+		Scope:         events.InstantScopeThread,
+	})
+	return nil
+}
+
+// marker converts the atrace markers that userspace writes to trace_marker, which look like B|pid|name, E|pid,
+// C|pid|name|value, S|pid|name|cookie and F|pid|name|cookie, markers in any other form becoming Instant events
+func (c *converter) marker(command string, tid, ts int64, body string) error {
+	parts := strings.Split(body, "|")
+	if len(parts) > 1 {
+		if pid, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64); err == nil {
+			c.tgids[tid] = pid
+		}
+	}
+	pid := c.pid(tid)
+	c.nameThread(pid, tid, command)
+
+	withArgs := func(name string) events.EventWithArgs {
+		return events.EventWithArgs{EventCore: c.core(name, ts, pid, tid)}
+	}
+	switch {
+	case parts[0] == "B" && len(parts) >= 3:
+		c.data.Write(&events.BeginDuration{EventWithArgs: withArgs(parts[2])})
+	case parts[0] == "E":
+		name := ""
+		if len(parts) >= 3 {
+			name = parts[2]
+		}
+		c.data.Write(&events.EndDuration{EventWithArgs: withArgs(name)})
+	case parts[0] == "C" && len(parts) >= 4:
+		value, err := strconv.ParseFloat(strings.TrimSpace(parts[3]), 64)
+		if err != nil {
+			return fmt.Errorf("invalid counter value '%s': %w", parts[3], ErrUnrecognisedLine)
+		}
+		core := c.core(parts[2], ts, pid, tid)
+		core.ThreadID = nil
+		c.data.Write(&events.Counter{EventCore: core, Values: map[string]float64{"value": value}})
+	case parts[0] == "S" && len(parts) >= 4:
+		c.data.Write(&events.AsyncBegin{EventWithArgs: withArgs(parts[2]), Id: strings.TrimSpace(parts[3])})
+	case parts[0] == "F" && len(parts) >= 4:
+		c.data.Write(&events.AsyncEnd{EventWithArgs: withArgs(parts[2]), Id: strings.TrimSpace(parts[3])})
+	default:
+		core := c.core(body, ts, pid, tid)
+		c.data.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: core},
+			Scope:         events.InstantScopeThread,
+		})
+	}
+	return nil
+}
+
+// schedSwitch records the task being switched away from as having run on the CPU since it was switched to
+func (c *converter) schedSwitch(cpu, ts int64, body string) error {
+	next, ok := c.switchedTo(body)
+	if !ok {
+		return fmt.Errorf("sched_switch without the next task '%s': %w", body, ErrUnrecognisedLine)
+	}
+
+	if _, ok := c.threads[threadKey{c.cpuProcessID, cpu}]; !ok {
+		if _, ok := c.processes[c.cpuProcessID]; !ok {
+			c.processes[c.cpuProcessID] = struct{}{}
+			c.data.Write(events.NewProcessName(c.cpuProcessID, "CPUs"))
+		}
+		c.threads[threadKey{c.cpuProcessID, cpu}] = struct{}{}
+		c.data.Write(events.NewThreadName(c.cpuProcessID, cpu, fmt.Sprintf("CPU %d", cpu)))
+	}
+
+	c.finishTask(cpu, ts)
+	next.since = ts
+	c.running[cpu] = next
+	return nil
+}
+
+// switchedTo finds the task that a sched_switch event switches to
+func (c *converter) switchedTo(body string) (*task, bool) {
+	i := strings.Index(body, "==>")
+	if i < 0 {
+		return nil, false
+	}
+	next := body[i+len("==>"):]
+	// the command is printed as it is, so may contain spaces, and is followed by the other fields
+	commandAt := strings.Index(next, "next_comm=")
+	pidAt := strings.Index(next, " next_pid=")
+	if commandAt < 0 || pidAt < commandAt {
+		return nil, false
+	}
+	pidField := strings.Fields(next[pidAt+len(" next_pid="):])
+	if len(pidField) == 0 {
+		return nil, false
+	}
+	tid, err := strconv.ParseInt(pidField[0], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &task{command: next[commandAt+len("next_comm=") : pidAt], tid: tid}, true
+}
+
+// finishTask emits the Complete event of the task running on the CPU, idle tasks being left out
+func (c *converter) finishTask(cpu, ts int64) {
+	t := c.running[cpu]
+	if t == nil || t.tid == 0 {
+		return
+	}
+	e := &events.Complete{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: c.core(t.command, t.since, c.cpuProcessID, cpu),
+			Args:      map[string]interface{}{"tid": t.tid},
+		},
+		Duration: ts - t.since,
+	}
+	if pid, ok := c.tgids[t.tid]; ok {
+		e.Args["pid"] = pid
+	}
+	c.data.Write(e)
+}
+
+// finishScheduling emits the tasks still running on each CPU at the end of the trace as running until its last event
+func (c *converter) finishScheduling() {
+	cpus := make([]int64, 0, len(c.running))
+	for cpu := range c.running {
+		cpus = append(cpus, cpu)
+	}
+	sort.Slice(cpus, func(i, j int) bool { return cpus[i] < cpus[j] })
+	for _, cpu := range cpus {
+		c.finishTask(cpu, c.last)
+	}
+}
+
+// pid finds the process of the thread, assuming it to be its own process if it is not known
+func (c *converter) pid(tid int64) int64 {
+	if pid, ok := c.tgids[tid]; ok {
+		return pid
+	}
+	return tid
+}
+
+func (c *converter) core(name string, ts, pid, tid int64) events.EventCore {
+	return events.EventCore{
+		Name:       name,
+		Categories: []string{Category},
+		Timestamp:  ts,
+		ProcessID:  &pid,
+		ThreadID:   &tid,
+	}
+}
+
+// nameThread names the thread after its command the first time it is seen, and the process too if the thread is its
+// main thread
+func (c *converter) nameThread(pid, tid int64, command string) {
+	if _, ok := c.processes[pid]; !ok && pid == tid {
+		c.processes[pid] = struct{}{}
+		c.data.Write(events.NewProcessName(pid, command))
+	}
+	if _, ok := c.threads[threadKey{pid, tid}]; !ok {
+		c.threads[threadKey{pid, tid}] = struct{}{}
+		c.data.Write(events.NewThreadName(pid, tid, command))
+	}
+}
+
+// fields parses the key=value fields of an event, integers being kept as numbers, or keeps the whole of its text as
+// its "message" if it has no such fields
+func fields(body string) map[string]interface{} {
+	matches := fieldPattern.FindAllStringSubmatch(body, -1)
+	if len(matches) == 0 {
+		if body = strings.TrimSpace(body); body == "" {
+			return nil
+		}
+		return map[string]interface{}{"message": body}
+	}
+	args := make(map[string]interface{}, len(matches))
+	for _, m := range matches {
+		if n, err := strconv.ParseInt(m[2], 0, 64); err == nil {
+			args[m[1]] = n
+		} else {
+			args[m[1]] = m[2]
+		}
+	}
+	return args
+}
+
+// microseconds converts a time in seconds, given as its whole and fractional digits, to microseconds exactly
+func microseconds(whole, fraction string) (int64, error) {
+	fraction = (fraction + "000000")[:6]
+	seconds, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s.%s': %w", whole, fraction, err)
+	}
+	micros, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s.%s': %w", whole, fraction, err)
+	}
+	return seconds*1000000 + micros, nil
+}
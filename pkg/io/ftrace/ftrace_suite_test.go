@@ -0,0 +1,13 @@
+package ftrace_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestFtrace(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Ftrace Suite")
+}
@@ -0,0 +1,133 @@
+package ftrace_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/ftrace"
+)
+
+const output = `# tracer: nop
+#
+#           TASK-PID    CPU#  ||||    TIMESTAMP  FUNCTION
+#              | |       |   ||||       |         |
+          <idle>-0     [001] d..2   100.000000: sched_switch: prev_comm=swapper/1 prev_pid=0 prev_prio=120 prev_state=R ==> next_comm=Render Thread next_pid=201 next_prio=120
+   Render Thread-201   [001] ...1   100.000010: tracing_mark_write: B|200|draw
+   Render Thread-201   [001] ...1   100.000020: tracing_mark_write: C|200|frames|3
+   Render Thread-201   [001] ...1   100.000030: tracing_mark_write: S|200|load|7
+   Render Thread-201   [001] ...1   100.000040: tracing_mark_write: E|200
+   Render Thread-201   [001] d..2   100.000050: sched_switch: prev_comm=Render Thread prev_pid=201 prev_prio=120 prev_state=S ==> next_comm=swapper/1 next_pid=0 next_prio=120
+            app-200   (  200) [000] ....   100.000060: sched_wakeup: comm=worker pid=202 prio=120 target_cpu=000
+CPU:2 [LOST 12 EVENTS]
+            app-200   (  200) [000] ....   100.000070: tracing_mark_write: F|200|load|7
+`
+
+var _ = Describe("Parse", func() {
+	var data *tio.TefData
+
+	BeforeEach(func() {
+		var err error
+		data, err = ftrace.Parse(strings.NewReader(output))
+		Expect(err).To(Succeed())
+	})
+
+	ofType := func(example events.Event) []events.Event {
+		var result []events.Event
+		for _, e := range data.Events() {
+			if e.Phase() == example.Phase() {
+				result = append(result, e)
+			}
+		}
+		return result
+	}
+
+	It("converts atrace markers into events of the process that wrote them", func() {
+		begins := ofType(&events.BeginDuration{})
+		Expect(begins).To(HaveLen(1))
+		begin := begins[0].(*events.BeginDuration)
+		Expect(begin.Name).To(Equal("draw"))
+		Expect(begin.Categories).To(Equal([]string{ftrace.Category}))
+		Expect(begin.Timestamp).To(Equal(int64(100000010)))
+		Expect(*begin.ProcessID).To(Equal(int64(200)))
+		Expect(*begin.ThreadID).To(Equal(int64(201)))
+
+		Expect(ofType(&events.EndDuration{})).To(HaveLen(1))
+
+		counters := ofType(&events.Counter{})
+		Expect(counters).To(HaveLen(1))
+		Expect(counters[0].(*events.Counter).Values).To(Equal(map[string]float64{"value": 3}))
+
+		begun := ofType(&events.AsyncBegin{})
+		Expect(begun).To(HaveLen(1))
+		Expect(begun[0].(*events.AsyncBegin).Id).To(Equal("7"))
+		ended := ofType(&events.AsyncEnd{})
+		Expect(ended).To(HaveLen(1))
+		Expect(ended[0].(*events.AsyncEnd).Id).To(Equal("7"))
+	})
+
+	It("converts scheduling into slices on a thread per CPU", func() {
+		slices := ofType(&events.Complete{})
+		Expect(slices).To(HaveLen(1))
+		slice := slices[0].(*events.Complete)
+		Expect(slice.Name).To(Equal("Render Thread"))
+		Expect(slice.Timestamp).To(Equal(int64(100000000)))
+		Expect(slice.Duration).To(Equal(int64(50)))
+		Expect(*slice.ProcessID).To(Equal(ftrace.DefaultCPUProcessID))
+		Expect(*slice.ThreadID).To(Equal(int64(1)))
+		Expect(slice.Args).To(Equal(map[string]interface{}{"tid": int64(201), "pid": int64(200)}))
+	})
+
+	It("converts other events into instants with their fields as args", func() {
+		instants := ofType(&events.Instant{})
+		Expect(instants).To(HaveLen(1))
+		instant := instants[0].(*events.Instant)
+		Expect(instant.Name).To(Equal("sched_wakeup"))
+		Expect(*instant.ProcessID).To(Equal(int64(200)))
+		Expect(json.Marshal(instant.Args)).To(MatchJSON(`{"comm": "worker", "pid": 202, "prio": 120, "target_cpu": 0}`))
+	})
+
+	It("names the CPUs, processes and threads", func() {
+		names := map[int64]string{}
+		for _, p := range data.Processes() {
+			names[p.ID] = p.Name
+		}
+		Expect(names).To(HaveKeyWithValue(ftrace.DefaultCPUProcessID, "CPUs"))
+		Expect(names).To(HaveKeyWithValue(int64(200), "app"))
+
+		var threads []string
+		for _, e := range data.Events() {
+			if name, ok := e.(*events.MetadataThreadName); ok {
+				threads = append(threads, name.ThreadName)
+			}
+		}
+		Expect(threads).To(ConsistOf("CPU 1", "Render Thread", "app"))
+	})
+
+	It("rejects lines that are not ftrace output", func() {
+		_, err := ftrace.Parse(strings.NewReader("not ftrace\n"))
+		Expect(errors.Is(err, ftrace.ErrUnrecognisedLine)).To(BeTrue())
+	})
+})
+
+var _ = Describe("ConvertSystemTraceEvents", func() {
+	It("replaces the system trace events with the events converted from them", func() {
+		data := &tio.TefData{}
+		data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "chrome"}}})
+		data.SetSystemTraceEvents(output)
+
+		Expect(ftrace.ConvertSystemTraceEvents(data, ftrace.WithCPUProcessID(-1))).To(Succeed())
+		Expect(data.SystemTraceEvents()).To(BeEmpty())
+		Expect(data.Events()[0].Core().Name).To(Equal("chrome"))
+		Expect(len(data.Events())).To(BeNumerically(">", 1))
+
+		encoded, err := json.Marshal(data.Events())
+		Expect(err).To(Succeed())
+		Expect(string(encoded)).To(ContainSubstring(`"pid":-1`))
+	})
+})
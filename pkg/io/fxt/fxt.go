@@ -0,0 +1,408 @@
+// fxt provides the ability to convert traces in Fuchsia's binary trace format (FXT) into trace events
+package fxt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// record types, taken from Fuchsia's trace format documentation
+const (
+	recordMetadata       = 0
+	recordInitialization = 1
+	recordString         = 2
+	recordThread         = 3
+	recordEvent          = 4
+	recordKernelObject   = 7
+	recordLarge          = 15
+)
+
+// event types of event records
+const (
+	eventInstant          = 0
+	eventCounter          = 1
+	eventDurationBegin    = 2
+	eventDurationEnd      = 3
+	eventDurationComplete = 4
+	eventAsyncBegin       = 5
+	eventAsyncInstant     = 6
+	eventAsyncEnd         = 7
+	eventFlowBegin        = 8
+	eventFlowStep         = 9
+	eventFlowEnd          = 10
+)
+
+// argument types
+const (
+	argNull    = 0
+	argInt32   = 1
+	argUint32  = 2
+	argInt64   = 3
+	argUint64  = 4
+	argDouble  = 5
+	argString  = 6
+	argPointer = 7
+	argKoid    = 8
+	argBool    = 9
+)
+
+// kernel object types
+const (
+	objectProcess = 1
+	objectThread  = 2
+)
+
+// magic is the magic number record that starts every FXT trace
+const magic = 0x0016547846040010
+
+// defaultTicksPerSecond is assumed when a trace has no initialization record, making its ticks nanoseconds
+const defaultTicksPerSecond = 1000000000
+
+var (
+	// ErrNotFxtTrace means that the input does not start with the FXT magic number record
+	ErrNotFxtTrace = errors.New("not a fuchsia trace")
+	// ErrMalformed means that a record of the trace could not be decoded
+	ErrMalformed = errors.New("malformed fuchsia trace record")
+)
+
+// IsFxtTrace reports whether the given start of a file is the start of an FXT trace
+func IsFxtTrace(start []byte) bool {
+	return len(start) >= 8 && binary.LittleEndian.Uint64(start) == magic
+}
+
+type thread struct {
+	pid, tid int64
+}
+
+type parser struct {
+	data           *tio.TefData
+	ticksPerSecond uint64
+	strings        map[uint64]string
+	threads        map[uint64]thread
+}
+
+// Parse reads an FXT trace from the provided reader, converting its event records into trace events and its process
+// and thread kernel objects into metadata naming them. Process and thread koids are used as process and thread IDs,
+// and timestamps are converted from ticks to microseconds. Records with no equivalent, such as scheduling and log
+// records, are skipped, as is a final record that was cut short.
+func Parse(r io.Reader) (*tio.TefData, error) {
+	br := bufio.NewReader(r)
+	start, err := br.Peek(8)
+	if err != nil || !IsFxtTrace(start) {
+		return nil, ErrNotFxtTrace
+	}
+
+	p := &parser{
+		data:           &tio.TefData{},
+		ticksPerSecond: defaultTicksPerSecond,
+		strings:        map[uint64]string{},
+		threads:        map[uint64]thread{},
+	}
+	p.data.SetDisplayTimeUnit(tio.DisplayTimeMs)
+
+	for {
+		words, err := readRecord(br)
+		if errors.Is(err, io.EOF) {
+			return p.data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := p.record(words); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readRecord reads the words of the next record, including its header
+func readRecord(r io.Reader) ([]uint64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	h := binary.LittleEndian.Uint64(header[:])
+
+	size := bits(h, 4, 15)
+	if bits(h, 0, 3) == recordLarge {
+		size = bits(h, 4, 35)
+	}
+	if size < 1 {
+		return nil, fmt.Errorf("record of no size: %w", ErrMalformed)
+	}
+
+	body := make([]byte, (size-1)*8)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// the final record was cut short
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read record: %w", err)
+	}
+
+	words := make([]uint64, size)
+	words[0] = h
+	for i := 1; i < len(words); i++ {
+		words[i] = binary.LittleEndian.Uint64(body[(i-1)*8:])
+	}
+	return words, nil
+}
+
+// bits extracts the bits from low to high, inclusive, of a word
+func bits(word uint64, low, high uint) uint64 {
+	return (word >> low) & (1<<(high-low+1) - 1)
+}
+
+func (p *parser) record(words []uint64) error {
+	h := words[0]
+	rec := &reader{words: words, pos: 1}
+	switch bits(h, 0, 3) {
+	case recordInitialization:
+		if tps := rec.word(); tps > 0 {
+			p.ticksPerSecond = tps
+		}
+	case recordString:
+		index := bits(h, 16, 30)
+		if index != 0 {
+			p.strings[index] = rec.inlineString(bits(h, 32, 46))
+		}
+	case recordThread:
+		index := bits(h, 16, 23)
+		pid, tid := rec.word(), rec.word()
+		p.threads[index] = thread{pid: int64(pid), tid: int64(tid)}
+	case recordEvent:
+		return p.event(h, rec)
+	case recordKernelObject:
+		return p.kernelObject(h, rec)
+	}
+	return rec.err
+}
+
+func (p *parser) event(h uint64, rec *reader) error {
+	eventType := bits(h, 16, 19)
+	argCount := bits(h, 20, 23)
+
+	ts := p.microseconds(rec.word())
+	th := p.thread(bits(h, 24, 31), rec)
+	category := p.string(bits(h, 32, 47), rec)
+	name := p.string(bits(h, 48, 63), rec)
+	args := p.args(argCount, rec)
+	if rec.err != nil {
+		return rec.err
+	}
+
+	core := events.EventCore{
+		Name:      name,
+		Timestamp: ts,
+		ProcessID: &th.pid,
+		ThreadID:  &th.tid,
+	}
+	if category != "" {
+		core.Categories = []string{category}
+	}
+	withArgs := events.EventWithArgs{EventCore: core, Args: args}
+
+	var e events.Event
+	switch eventType {
+	case eventInstant:
+		e = &events.Instant{EventWithArgs: withArgs, Scope: events.InstantScopeThread}
+	case eventCounter:
+		id := rec.word()
+		values := map[string]float64{}
+		for key, value := range args {
+			if f, ok := numeric(value); ok {
+				values[key] = f
+			}
+		}
+		e = &events.Counter{EventCore: core, Values: values, Id: fmt.Sprintf("0x%x", id)}
+	case eventDurationBegin:
+		e = &events.BeginDuration{EventWithArgs: withArgs}
+	case eventDurationEnd:
+		e = &events.EndDuration{EventWithArgs: withArgs}
+	case eventDurationComplete:
+		end := p.microseconds(rec.word())
+		e = &events.Complete{EventWithArgs: withArgs, Duration: end - ts}
+	case eventAsyncBegin:
+		e = &events.AsyncBegin{EventWithArgs: withArgs, Id: fmt.Sprintf("0x%x", rec.word())}
+	case eventAsyncInstant:
+		e = &events.AsyncInstant{EventWithArgs: withArgs, Id: fmt.Sprintf("0x%x", rec.word())}
+	case eventAsyncEnd:
+		e = &events.AsyncEnd{EventWithArgs: withArgs, Id: fmt.Sprintf("0x%x", rec.word())}
+	case eventFlowBegin:
+		e = &events.FlowStart{EventWithArgs: withArgs, Id: fmt.Sprintf("0x%x", rec.word())}
+	case eventFlowStep:
+		e = &events.FlowInstant{EventWithArgs: withArgs, Id: fmt.Sprintf("0x%x", rec.word())}
+	case eventFlowEnd:
+		e = &events.FlowFinish{
+			EventWithArgs: withArgs,
+			Id:            fmt.Sprintf("0x%x", rec.word()),
+			BindingPoint:  events.BindingPointEnclosing,
+		}
+	default:
+		return nil
+	}
+	if rec.err != nil {
+		return rec.err
+	}
+	p.data.Write(e)
+	return nil
+}
+
+func (p *parser) kernelObject(h uint64, rec *reader) error {
+	objectType := bits(h, 16, 23)
+	argCount := bits(h, 40, 43)
+	koid := int64(rec.word())
+	name := p.string(bits(h, 24, 39), rec)
+	args := p.args(argCount, rec)
+	if rec.err != nil || name == "" {
+		return rec.err
+	}
+
+	switch objectType {
+	case objectProcess:
+		p.data.Write(events.NewProcessName(koid, name))
+	case objectThread:
+		core := events.EventCore{Name: string(events.MetadataKindThreadName), ThreadID: &koid}
+		if process, ok := args["process"].(uint64); ok {
+			pid := int64(process)
+			core.ProcessID = &pid
+		}
+		p.data.Write(&events.MetadataThreadName{EventCore: core, ThreadName: name})
+	}
+	return nil
+}
+
+// microseconds converts a tick count to microseconds, without overflowing for large tick counts
+func (p *parser) microseconds(ticks uint64) int64 {
+	whole := ticks / p.ticksPerSecond
+	part := ticks % p.ticksPerSecond
+	return int64(whole*1000000 + part*1000000/p.ticksPerSecond)
+}
+
+// thread resolves a thread reference, which is either inline or an index into the thread table
+func (p *parser) thread(ref uint64, rec *reader) thread {
+	if ref == 0 {
+		return thread{pid: int64(rec.word()), tid: int64(rec.word())}
+	}
+	return p.threads[ref]
+}
+
+// string resolves a string reference, which is either inline or an index into the string table
+func (p *parser) string(ref uint64, rec *reader) string {
+	if ref&0x8000 != 0 {
+		return rec.inlineString(ref & 0x7fff)
+	}
+	if ref == 0 {
+		return ""
+	}
+	return p.strings[ref]
+}
+
+func (p *parser) args(count uint64, rec *reader) map[string]interface{} {
+	if count < 1 {
+		return nil
+	}
+	args := make(map[string]interface{}, count)
+	for i := uint64(0); i < count && rec.err == nil; i++ {
+		start := rec.pos
+		h := rec.word()
+		size := int(bits(h, 4, 15))
+		name := p.string(bits(h, 16, 31), rec)
+
+		switch bits(h, 0, 3) {
+		case argNull:
+			args[name] = nil
+		case argInt32:
+			args[name] = int64(int32(bits(h, 32, 63)))
+		case argUint32:
+			args[name] = bits(h, 32, 63)
+		case argInt64:
+			args[name] = int64(rec.word())
+		case argUint64:
+			args[name] = rec.word()
+		case argDouble:
+			args[name] = math.Float64frombits(rec.word())
+		case argString:
+			args[name] = p.string(bits(h, 32, 47), rec)
+		case argPointer:
+			args[name] = fmt.Sprintf("0x%x", rec.word())
+		case argKoid:
+			args[name] = rec.word()
+		case argBool:
+			args[name] = bits(h, 32, 32) == 1
+		}
+
+		// skip anything in the argument that was not understood
+		if size < 1 || start+size < rec.pos {
+			rec.fail("invalid argument size")
+		} else {
+			rec.pos = start + size
+		}
+	}
+	return args
+}
+
+func numeric(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// reader reads the words of a record in turn
+type reader struct {
+	words []uint64
+	pos   int
+	err   error
+}
+
+func (r *reader) word() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos >= len(r.words) {
+		r.fail("record ended early")
+		return 0
+	}
+	w := r.words[r.pos]
+	r.pos++
+	return w
+}
+
+// inlineString reads a string of the given length from the following words, which are padded to a whole word
+func (r *reader) inlineString(length uint64) string {
+	n := int(length+7) / 8
+	if r.err != nil {
+		return ""
+	}
+	if r.pos+n > len(r.words) {
+		r.fail("record ended early")
+		return ""
+	}
+	b := make([]byte, n*8)
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint64(b[i*8:], r.words[r.pos+i])
+	}
+	r.pos += n
+	return string(b[:length])
+}
+
+func (r *reader) fail(reason string) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%s: %w", reason, ErrMalformed)
+	}
+}
@@ -0,0 +1,13 @@
+package fxt_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestFxt(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "FXT Suite")
+}
@@ -0,0 +1,186 @@
+package fxt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/io/fxt"
+)
+
+// trace builds FXT input for tests a record at a time
+type trace struct {
+	bytes.Buffer
+}
+
+func (t *trace) record(recordType uint64, fields uint64, body ...uint64) *trace {
+	header := recordType | uint64(len(body)+1)<<4 | fields
+	_ = binary.Write(&t.Buffer, binary.LittleEndian, header)
+	_ = binary.Write(&t.Buffer, binary.LittleEndian, body)
+	return t
+}
+
+// stringWords pads a string to whole words
+func stringWords(s string) []uint64 {
+	b := make([]byte, (len(s)+7)/8*8)
+	copy(b, s)
+	words := make([]uint64, len(b)/8)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(b[i*8:])
+	}
+	return words
+}
+
+func newTrace() *trace {
+	t := &trace{}
+	_ = binary.Write(&t.Buffer, binary.LittleEndian, uint64(0x0016547846040010))
+	return t
+}
+
+func (t *trace) initialization(ticksPerSecond uint64) *trace {
+	return t.record(1, 0, ticksPerSecond)
+}
+
+func (t *trace) string(index uint64, s string) *trace {
+	return t.record(2, index<<16|uint64(len(s))<<32, stringWords(s)...)
+}
+
+func (t *trace) thread(index, pid, tid uint64) *trace {
+	return t.record(3, index<<16, pid, tid)
+}
+
+// event writes an event record referring to the thread, category and name by index, with the given args and
+// event type specific words
+func (t *trace) event(eventType, ts, thread, category, name uint64, args [][]uint64, extra ...uint64) *trace {
+	body := []uint64{ts}
+	for _, arg := range args {
+		body = append(body, arg...)
+	}
+	body = append(body, extra...)
+	return t.record(4, eventType<<16|uint64(len(args))<<20|thread<<24|category<<32|name<<48, body...)
+}
+
+func intArg(name uint64, v int32) []uint64 {
+	return []uint64{1 | 1<<4 | name<<16 | uint64(uint32(v))<<32}
+}
+
+func doubleArg(name uint64, v float64) []uint64 {
+	return []uint64{5 | 2<<4 | name<<16, math.Float64bits(v)}
+}
+
+func inlineStringArg(v string) []uint64 {
+	// the name refers to string table entry 5, the value is inline
+	words := stringWords(v)
+	return append([]uint64{6 | uint64(len(words)+1)<<4 | 5<<16 | (0x8000|uint64(len(v)))<<32}, words...)
+}
+
+var _ = Describe("Parse", func() {
+	pid, tid := int64(10), int64(11)
+	base := func() *trace {
+		return newTrace().
+			initialization(1000000000).
+			string(1, "cat").
+			string(2, "work").
+			string(3, "count").
+			string(4, "value").
+			string(5, "label").
+			thread(1, 10, 11)
+	}
+
+	It("converts duration events", func() {
+		t := base().
+			event(2, 1000, 1, 1, 2, [][]uint64{intArg(3, -2), inlineStringArg("hi")}).
+			event(3, 5000, 1, 1, 2, nil).
+			event(4, 6000, 1, 0, 2, nil, 9000)
+
+		data, err := fxt.Parse(t)
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(Equal([]events.Event{
+			&events.BeginDuration{EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "work", Categories: []string{"cat"}, Timestamp: 1, ProcessID: &pid, ThreadID: &tid},
+				Args:      map[string]interface{}{"count": int64(-2), "label": "hi"},
+			}},
+			&events.EndDuration{EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "work", Categories: []string{"cat"}, Timestamp: 5, ProcessID: &pid, ThreadID: &tid},
+			}},
+			&events.Complete{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: events.EventCore{Name: "work", Timestamp: 6, ProcessID: &pid, ThreadID: &tid},
+				},
+				Duration: 3,
+			},
+		}))
+	})
+
+	It("converts instant, counter, async and flow events", func() {
+		t := base().
+			event(0, 1000, 1, 1, 2, [][]uint64{intArg(3, 1)}).
+			event(1, 2000, 1, 1, 2, [][]uint64{doubleArg(4, 1.5)}, 7).
+			event(5, 3000, 1, 1, 2, nil, 0x20).
+			event(7, 4000, 1, 1, 2, nil, 0x20).
+			event(10, 5000, 1, 1, 2, nil, 3)
+
+		data, err := fxt.Parse(t)
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(HaveLen(5))
+
+		instant := data.Events()[0].(*events.Instant)
+		Expect(instant.Scope).To(Equal(events.InstantScopeThread))
+		Expect(json.Marshal(instant.Args)).To(MatchJSON(`{"count": 1}`))
+
+		counter := data.Events()[1].(*events.Counter)
+		Expect(counter.Values).To(Equal(map[string]float64{"value": 1.5}))
+		Expect(counter.Id).To(Equal("0x7"))
+
+		Expect(data.Events()[2].(*events.AsyncBegin).Id).To(Equal("0x20"))
+		Expect(data.Events()[3].(*events.AsyncEnd).Id).To(Equal("0x20"))
+		Expect(data.Events()[4].(*events.FlowFinish).BindingPoint).To(Equal(events.BindingPointEnclosing))
+	})
+
+	It("converts ticks to microseconds", func() {
+		t := base().
+			initialization(24000000).
+			event(0, 48000000, 1, 1, 2, nil)
+
+		data, err := fxt.Parse(t)
+		Expect(err).To(Succeed())
+		Expect(data.Events()[0].Core().Timestamp).To(Equal(int64(2000000)))
+	})
+
+	It("names processes and threads from kernel objects", func() {
+		processArg := append([]uint64{8 | 3<<4 | (0x8000|7)<<16}, stringWords("process")...)
+		processArg = append(processArg, 10)
+		t := base().
+			record(7, 1<<16|(0x8000|7)<<24, append([]uint64{10}, stringWords("browser")...)...).
+			record(7, 2<<16|2<<24|1<<40, append([]uint64{11}, processArg...)...).
+			event(0, 1000, 1, 1, 2, nil)
+
+		data, err := fxt.Parse(t)
+		Expect(err).To(Succeed())
+		processes := data.Processes()
+		Expect(processes).To(HaveLen(1))
+		Expect(processes[0].Name).To(Equal("browser"))
+		Expect(processes[0].Threads).To(HaveLen(1))
+		Expect(processes[0].Threads[0].Name).To(Equal("work"))
+		Expect(processes[0].Threads[0].Events).To(HaveLen(1))
+	})
+
+	It("ignores a final record that was cut short", func() {
+		t := base().event(0, 1000, 1, 1, 2, nil).event(0, 2000, 1, 1, 2, nil)
+		truncated := t.Bytes()[:t.Len()-4]
+
+		data, err := fxt.Parse(bytes.NewReader(truncated))
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(HaveLen(1))
+	})
+
+	It("rejects input that is not an FXT trace", func() {
+		_, err := fxt.Parse(bytes.NewReader([]byte(`[{"name":"a","ph":"I","ts":1}]`)))
+		Expect(err).To(MatchError(fxt.ErrNotFxtTrace))
+	})
+})
@@ -0,0 +1,201 @@
+// gotest provides conversion of the output of `go test -json` into trace events, so that which tests are slow, and
+// how well tests run in parallel, can be seen
+package gotest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/omaskery/teffy/internal/lanes"
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+const (
+	// Category is the category given to all events converted from test output
+	Category = "gotest"
+
+	// ArgResult is the arg holding the result of a test or package: pass, fail or skip, or incomplete if the output
+	// ends before the test does, such as when the test binary panics or times out
+	ArgResult = "result"
+	// ArgPackage is the arg holding the import path of the package of a test
+	ArgPackage = "package"
+	// ArgOutput is the arg holding the output of a test that failed
+	ArgOutput = "output"
+
+	// ResultIncomplete is the result of tests that never finished
+	ResultIncomplete = "incomplete"
+)
+
+// resultColors are the trace viewer's reserved colors that tests are drawn in according to their result
+var resultColors = map[string]string{
+	"fail":           "bad",
+	"skip":           "grey",
+	ResultIncomplete: "terrible",
+}
+
+// testEvent is a line of the output of `go test -json`, as described by `go doc test2json`
+type testEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// run is a run of a test, or of a whole package when its test is empty
+type run struct {
+	test       string
+	start, end time.Time
+	result     string
+	output     strings.Builder
+}
+
+// pkg holds the runs of the tests of a package, in the order they started
+type pkg struct {
+	name string
+	runs []*run
+	// open holds the runs that have started but not finished, by test name
+	open map[string]*run
+	last time.Time
+}
+
+// Parse reads the output of `go test -json` from the provided reader, converting each test and subtest into a
+// Complete event named after the test, holding its result (and its output, if it failed) as args. Each package
+// becomes a process holding a Complete event for the package's test binary, with the tests that ran in parallel placed
+// on threads of their own, and subtests nesting within their parents. Lines that are not JSON, such as those of build
+// failures, are ignored.
+func Parse(r io.Reader) (*tio.TefData, error) {
+	var packages []*pkg
+	byName := map[string]*pkg{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Bytes()
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		var e testEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("line %d: failed to decode test event: %w", lineNumber, err)
+		}
+		if e.Package == "" || e.Time.IsZero() {
+			continue
+		}
+		p, ok := byName[e.Package]
+		if !ok {
+			p = &pkg{name: e.Package, open: map[string]*run{}}
+			byName[e.Package] = p
+			packages = append(packages, p)
+		}
+		p.event(e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read test output: %w", err)
+	}
+
+	data := &tio.TefData{}
+	data.SetDisplayTimeUnit(tio.DisplayTimeMs)
+	for i, p := range packages {
+		p.write(data, int64(i+1))
+	}
+	return data, nil
+}
+
+func (p *pkg) event(e testEvent) {
+	p.last = e.Time
+	r, ok := p.open[e.Test]
+	switch e.Action {
+	case "start", "run":
+		if !ok {
+			r = &run{test: e.Test, start: e.Time}
+			p.open[e.Test] = r
+			p.runs = append(p.runs, r)
+		}
+	case "output":
+		if ok {
+			r.output.WriteString(e.Output)
+		}
+	case "pass", "fail", "skip":
+		if !ok {
+			// packages without a start action, from older versions of Go, began as long ago as they took
+			start := e.Time.Add(-time.Duration(e.Elapsed * float64(time.Second)))
+			r = &run{test: e.Test, start: start}
+			p.runs = append(p.runs, r)
+		}
+		r.end = e.Time
+		r.result = e.Action
+		delete(p.open, e.Test)
+	}
+}
+
+// write writes the runs of the package as events of the given process
+func (p *pkg) write(data *tio.TefData, pid int64) {
+	data.Write(events.NewProcessName(pid, p.name))
+	for _, r := range p.runs {
+		if r.result == "" {
+			r.end = p.last
+			r.result = ResultIncomplete
+		}
+		if r.start.After(r.end) {
+			r.start = r.end
+		}
+	}
+
+	runs := append([]*run(nil), p.runs...)
+	sort.SliceStable(runs, func(i, j int) bool {
+		if !runs[i].start.Equal(runs[j].start) {
+			return runs[i].start.Before(runs[j].start)
+		}
+		// the package encloses its tests, and tests their subtests
+		return runs[i].end.Sub(runs[i].start) > runs[j].end.Sub(runs[j].start) ||
+			(runs[i].end.Equal(runs[j].end) && len(runs[i].test) < len(runs[j].test))
+	})
+	intervals := make([]lanes.Interval, 0, len(runs))
+	for _, r := range runs {
+		intervals = append(intervals, lanes.Interval{Start: micros(r.start), End: micros(r.end)})
+	}
+	for i, lane := range lanes.Assign(intervals) {
+		data.Write(p.complete(runs[i], pid, int64(lane+1)))
+	}
+}
+
+func (p *pkg) complete(r *run, pid, tid int64) *events.Complete {
+	name := r.test
+	if name == "" {
+		name = p.name
+	}
+	args := map[string]interface{}{
+		ArgResult:  r.result,
+		ArgPackage: p.name,
+	}
+	if r.result == "fail" && r.test != "" {
+		args[ArgOutput] = r.output.String()
+	}
+	return &events.Complete{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{
+				Name:       name,
+				Categories: []string{Category},
+				Timestamp:  micros(r.start),
+				ProcessID:  &pid,
+				ThreadID:   &tid,
+				Color:      resultColors[r.result],
+			},
+			Args: args,
+		},
+		Duration: micros(r.end) - micros(r.start),
+	}
+}
+
+// micros converts the time into microseconds since the Unix epoch
+func micros(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Microsecond)
+}
@@ -0,0 +1,92 @@
+package gotest_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/gotest"
+)
+
+const output = `{"Time":"2024-05-01T10:00:00Z","Action":"start","Package":"example.com/a"}
+{"Time":"2024-05-01T10:00:00.1Z","Action":"run","Package":"example.com/a","Test":"TestSlow"}
+{"Time":"2024-05-01T10:00:00.1Z","Action":"run","Package":"example.com/a","Test":"TestSlow/sub"}
+{"Time":"2024-05-01T10:00:00.2Z","Action":"run","Package":"example.com/a","Test":"TestParallel"}
+{"Time":"2024-05-01T10:00:00.3Z","Action":"output","Package":"example.com/a","Test":"TestParallel","Output":"    a_test.go:9: broken\n"}
+{"Time":"2024-05-01T10:00:01Z","Action":"pass","Package":"example.com/a","Test":"TestSlow/sub","Elapsed":0.9}
+{"Time":"2024-05-01T10:00:01Z","Action":"pass","Package":"example.com/a","Test":"TestSlow","Elapsed":0.9}
+{"Time":"2024-05-01T10:00:01.05Z","Action":"fail","Package":"example.com/a","Test":"TestParallel","Elapsed":0.85}
+{"Time":"2024-05-01T10:00:01.1Z","Action":"fail","Package":"example.com/a","Elapsed":1.1}
+# example.com/b [build failed]
+{"Time":"2024-05-01T10:00:00Z","Action":"run","Package":"example.com/b","Test":"TestHangs"}
+{"Time":"2024-05-01T10:00:02Z","Action":"output","Package":"example.com/b","Test":"TestHangs","Output":"panic: test timed out\n"}
+`
+
+var _ = Describe("Parse", func() {
+	var data *tio.TefData
+
+	BeforeEach(func() {
+		var err error
+		data, err = gotest.Parse(strings.NewReader(output))
+		Expect(err).To(Succeed())
+	})
+
+	completes := func() map[string]*events.Complete {
+		result := map[string]*events.Complete{}
+		for _, e := range data.Events() {
+			if complete, ok := e.(*events.Complete); ok {
+				result[complete.Name] = complete
+			}
+		}
+		return result
+	}
+
+	It("converts each test and package into a complete event on a process per package", func() {
+		tests := completes()
+		Expect(tests).To(HaveLen(5))
+
+		slow := tests["TestSlow"]
+		Expect(slow.Duration).To(Equal(int64(900000)))
+		Expect(slow.Categories).To(Equal([]string{gotest.Category}))
+		Expect(slow.Args).To(Equal(map[string]interface{}{gotest.ArgResult: "pass", gotest.ArgPackage: "example.com/a"}))
+		Expect(*slow.ProcessID).To(Equal(int64(1)))
+		Expect(*tests["TestHangs"].ProcessID).To(Equal(int64(2)))
+
+		pkg := tests["example.com/a"]
+		Expect(pkg.Duration).To(Equal(int64(1100000)))
+		Expect(pkg.Args[gotest.ArgResult]).To(Equal("fail"))
+		Expect(pkg.Args).ToNot(HaveKey(gotest.ArgOutput))
+	})
+
+	It("nests subtests within their tests and moves parallel tests onto threads of their own", func() {
+		tests := completes()
+		Expect(*tests["TestSlow"].ThreadID).To(Equal(*tests["example.com/a"].ThreadID))
+		Expect(*tests["TestSlow/sub"].ThreadID).To(Equal(*tests["TestSlow"].ThreadID))
+		Expect(*tests["TestParallel"].ThreadID).ToNot(Equal(*tests["TestSlow"].ThreadID))
+	})
+
+	It("keeps the output of failed tests", func() {
+		failed := completes()["TestParallel"]
+		Expect(failed.Args[gotest.ArgOutput]).To(Equal("    a_test.go:9: broken\n"))
+		Expect(failed.Color).To(Equal("bad"))
+	})
+
+	It("ends tests that never finished with the last output of their package", func() {
+		hung := completes()["TestHangs"]
+		Expect(hung.Args[gotest.ArgResult]).To(Equal(gotest.ResultIncomplete))
+		Expect(hung.Duration).To(Equal(int64(2000000)))
+	})
+
+	It("names processes after their packages", func() {
+		var names []string
+		for _, e := range data.Events() {
+			if m, ok := e.(*events.MetadataProcessName); ok {
+				names = append(names, m.ProcessName)
+			}
+		}
+		Expect(names).To(Equal([]string{"example.com/a", "example.com/b"}))
+	})
+})
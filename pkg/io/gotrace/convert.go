@@ -0,0 +1,279 @@
+// gotrace provides conversion of Go execution traces (as produced by runtime/trace) into trace events
+package gotrace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"golang.org/x/exp/trace"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+const (
+	// ProcessID is the process ID attributed to all events converted from a Go execution trace
+	ProcessID = int64(1)
+
+	// CategoryGoroutine is the category of events representing a goroutine running
+	CategoryGoroutine = "goroutine"
+	// CategoryRuntime is the category of events representing runtime activity such as GC phases
+	CategoryRuntime = "runtime"
+	// CategoryTask is the category of events representing runtime/trace.Task spans
+	CategoryTask = "task"
+	// CategoryRegion is the category of events representing runtime/trace.Region durations
+	CategoryRegion = "region"
+	// CategoryLog is the category of events representing runtime/trace.Log messages
+	CategoryLog = "log"
+)
+
+// Parse reads a Go execution trace from the provided reader and converts it into trace events, see Convert
+func Parse(r io.Reader) (*tio.TefData, error) {
+	data := &tio.TefData{}
+	write := func(e events.Event) error {
+		data.Write(e)
+		return nil
+	}
+	if err := convert(r, write); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Convert reads a Go execution trace from the provided reader and writes the equivalent trace events to w.
+// Goroutines are represented as threads, with the time they spend running emitted as Complete events, user
+// regions are emitted as durations on the thread of their goroutine, tasks and runtime ranges (e.g. GC phases)
+// are emitted as async spans, logs are emitted as instants and runtime metrics as counters.
+func Convert(r io.Reader, w tio.EventWriter) error {
+	return convert(r, w.Write)
+}
+
+func convert(r io.Reader, write func(e events.Event) error) error {
+	reader, err := trace.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read go execution trace: %w", err)
+	}
+
+	c := &converter{
+		write:   write,
+		running: map[trace.GoID]trace.Time{},
+		seen:    map[trace.GoID]struct{}{},
+	}
+
+	if err := c.writeEvent(&events.MetadataProcessName{
+		EventCore:   c.core("process_name", 0, nil),
+		ProcessName: "go",
+	}); err != nil {
+		return err
+	}
+
+	for {
+		e, err := reader.ReadEvent()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read go execution trace event: %w", err)
+		}
+
+		if err := c.convert(e); err != nil {
+			return err
+		}
+	}
+
+	return c.finish()
+}
+
+type converter struct {
+	write    func(e events.Event) error
+	running  map[trace.GoID]trace.Time
+	seen     map[trace.GoID]struct{}
+	lastTime trace.Time
+}
+
+func (c *converter) convert(e trace.Event) error {
+	c.lastTime = e.Time()
+
+	switch e.Kind() {
+	case trace.EventStateTransition:
+		st := e.StateTransition()
+		if st.Resource.Kind != trace.ResourceGoroutine {
+			return nil
+		}
+		goID := st.Resource.Goroutine()
+		from, to := st.Goroutine()
+		if err := c.nameGoroutine(goID); err != nil {
+			return err
+		}
+		if to == trace.GoRunning && from != trace.GoRunning {
+			c.running[goID] = e.Time()
+		} else if from == trace.GoRunning && to != trace.GoRunning {
+			return c.endRunning(goID, e.Time())
+		}
+
+	case trace.EventRegionBegin, trace.EventRegionEnd:
+		goID := e.Goroutine()
+		if err := c.nameGoroutine(goID); err != nil {
+			return err
+		}
+		region := e.Region()
+		core := c.core(region.Type, e.Time(), &goID, CategoryRegion)
+		if e.Kind() == trace.EventRegionBegin {
+			return c.writeEvent(&events.BeginDuration{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: core,
+					Args:      taskArgs(region.Task),
+				},
+			})
+		}
+		return c.writeEvent(&events.EndDuration{
+			EventWithArgs: events.EventWithArgs{EventCore: core},
+		})
+
+	case trace.EventTaskBegin:
+		task := e.Task()
+		return c.writeEvent(&events.AsyncBegin{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: c.core(task.Type, e.Time(), nil, CategoryTask),
+				Args:      map[string]interface{}{"parent": taskID(task.Parent)},
+			},
+			Id: taskID(task.ID),
+		})
+	case trace.EventTaskEnd:
+		task := e.Task()
+		return c.writeEvent(&events.AsyncEnd{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: c.core(task.Type, e.Time(), nil, CategoryTask),
+			},
+			Id: taskID(task.ID),
+		})
+
+	case trace.EventRangeBegin, trace.EventRangeActive, trace.EventRangeEnd:
+		r := e.Range()
+		core := c.core(r.Name, e.Time(), nil, CategoryRuntime)
+		id := fmt.Sprintf("%s/%v", r.Name, r.Scope)
+		if e.Kind() == trace.EventRangeEnd {
+			return c.writeEvent(&events.AsyncEnd{
+				EventWithArgs: events.EventWithArgs{EventCore: core},
+				Id:            id,
+			})
+		}
+		return c.writeEvent(&events.AsyncBegin{
+			EventWithArgs: events.EventWithArgs{EventCore: core},
+			Id:            id,
+		})
+
+	case trace.EventLog:
+		goID := e.Goroutine()
+		log := e.Log()
+		categories := []string{CategoryLog}
+		if log.Category != "" {
+			categories = append(categories, log.Category)
+		}
+		return c.writeEvent(&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: c.core(log.Message, e.Time(), &goID, categories...)},
+			Scope:         events.InstantScopeThread,
+		})
+
+	case trace.EventMetric:
+		metric := e.Metric()
+		if metric.Value.Kind() != trace.ValueUint64 {
+			return nil
+		}
+		return c.writeEvent(&events.Counter{
+			EventCore: c.core(metric.Name, e.Time(), nil),
+			Values: map[string]float64{
+				"value": float64(metric.Value.Uint64()),
+			},
+		})
+	}
+
+	return nil
+}
+
+// finish ends the running slices of any goroutines still running at the end of the trace, in order of their IDs so
+// that the same trace is always converted to the same events
+func (c *converter) finish() error {
+	goIDs := make([]trace.GoID, 0, len(c.running))
+	for goID := range c.running {
+		goIDs = append(goIDs, goID)
+	}
+	sort.Slice(goIDs, func(i, j int) bool { return goIDs[i] < goIDs[j] })
+	for _, goID := range goIDs {
+		if err := c.endRunning(goID, c.lastTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *converter) endRunning(goID trace.GoID, end trace.Time) error {
+	start, ok := c.running[goID]
+	if !ok {
+		return nil
+	}
+	delete(c.running, goID)
+
+	return c.writeEvent(&events.Complete{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: c.core("running", start, &goID, CategoryGoroutine),
+		},
+		Duration: toMicroseconds(end) - toMicroseconds(start),
+	})
+}
+
+func (c *converter) nameGoroutine(goID trace.GoID) error {
+	if goID == trace.NoGoroutine {
+		return nil
+	}
+	if _, ok := c.seen[goID]; ok {
+		return nil
+	}
+	c.seen[goID] = struct{}{}
+
+	return c.writeEvent(&events.MetadataThreadName{
+		EventCore:  c.core("thread_name", 0, &goID),
+		ThreadName: fmt.Sprintf("goroutine %v", goID),
+	})
+}
+
+func (c *converter) core(name string, t trace.Time, goID *trace.GoID, categories ...string) events.EventCore {
+	pid := ProcessID
+	core := events.EventCore{
+		Name:       name,
+		Categories: categories,
+		Timestamp:  toMicroseconds(t),
+		ProcessID:  &pid,
+	}
+	if goID != nil && *goID != trace.NoGoroutine {
+		tid := int64(*goID)
+		core.ThreadID = &tid
+	}
+	return core
+}
+
+func (c *converter) writeEvent(e events.Event) error {
+	if err := c.write(e); err != nil {
+		return fmt.Errorf("failed to write converted event: %w", err)
+	}
+	return nil
+}
+
+func taskArgs(id trace.TaskID) map[string]interface{} {
+	if id == trace.NoTask || id == trace.BackgroundTask {
+		return nil
+	}
+	return map[string]interface{}{"task": taskID(id)}
+}
+
+func taskID(id trace.TaskID) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// toMicroseconds converts Go execution trace timestamps, which are in nanoseconds, to microseconds
+func toMicroseconds(t trace.Time) int64 {
+	return int64(t) / 1000
+}
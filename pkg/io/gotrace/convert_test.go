@@ -0,0 +1,92 @@
+package gotrace_test
+
+import (
+	"bytes"
+	"context"
+	"runtime/trace"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/gotrace"
+)
+
+var _ = Describe("Parse", func() {
+	var data *tio.TefData
+	var raw []byte
+	var err error
+
+	BeforeEach(func() {
+		var buffer bytes.Buffer
+		Expect(trace.Start(&buffer)).To(Succeed())
+		ctx, task := trace.NewTask(context.Background(), "such-task")
+		trace.WithRegion(ctx, "such-region", func() {
+			trace.Log(ctx, "such-category", "such-message")
+		})
+		task.End()
+		trace.Stop()
+
+		raw = buffer.Bytes()
+		data, err = gotrace.Parse(bytes.NewReader(raw))
+	})
+
+	It("names the process and goroutines", func() {
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(ContainElement(BeAssignableToTypeOf(&events.MetadataProcessName{})))
+		Expect(data.Events()).To(ContainElement(BeAssignableToTypeOf(&events.MetadataThreadName{})))
+	})
+
+	It("converts user regions into durations on the goroutine's thread", func() {
+		Expect(err).To(Succeed())
+		begin := findEvent(data, events.PhaseBeginDuration, "such-region")
+		end := findEvent(data, events.PhaseEndDuration, "such-region")
+		Expect(begin).ToNot(BeNil())
+		Expect(end).ToNot(BeNil())
+		Expect(begin.Core().ThreadID).ToNot(BeNil())
+		Expect(*begin.Core().ProcessID).To(Equal(gotrace.ProcessID))
+		Expect(begin.Core().ThreadID).To(Equal(end.Core().ThreadID))
+		Expect(end.Core().Timestamp).To(BeNumerically(">=", begin.Core().Timestamp))
+	})
+
+	It("converts tasks into async spans", func() {
+		Expect(err).To(Succeed())
+		begin, ok := findEvent(data, events.PhaseAsyncBegin, "such-task").(*events.AsyncBegin)
+		Expect(ok).To(BeTrue())
+		end, ok := findEvent(data, events.PhaseAsyncEnd, "such-task").(*events.AsyncEnd)
+		Expect(ok).To(BeTrue())
+		Expect(begin.Id).To(Equal(end.Id))
+		Expect(begin.Categories).To(Equal([]string{gotrace.CategoryTask}))
+	})
+
+	It("converts logs into instants", func() {
+		Expect(err).To(Succeed())
+		log := findEvent(data, events.PhaseInstant, "such-message")
+		Expect(log).ToNot(BeNil())
+		Expect(log.Core().Categories).To(Equal([]string{gotrace.CategoryLog, "such-category"}))
+	})
+
+	It("emits goroutine running slices", func() {
+		Expect(err).To(Succeed())
+		Expect(findEvent(data, events.PhaseComplete, "running")).ToNot(BeNil())
+	})
+
+	It("converts the same trace to the same events every time", func() {
+		Expect(err).To(Succeed())
+		for i := 0; i < 5; i++ {
+			again, err := gotrace.Parse(bytes.NewReader(raw))
+			Expect(err).To(Succeed())
+			Expect(again.Events()).To(Equal(data.Events()))
+		}
+	})
+})
+
+func findEvent(data *tio.TefData, phase events.Phase, name string) events.Event {
+	for _, e := range data.Events() {
+		if e.Phase() == phase && e.Core().Name == name {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package gotrace_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestGotrace(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Gotrace Suite")
+}
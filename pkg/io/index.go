@@ -0,0 +1,186 @@
+package io
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// Index finds the events of a trace by their name, categories and args without scanning every event of the trace for
+// each query, for tools that query the same trace many times
+type Index struct {
+	events     []events.Event
+	byName     map[string][]int
+	byCategory map[string][]int
+	byArg      map[string][]int
+}
+
+// Query describes the events to find with Index.Find, all of the conditions it gives must hold
+type Query struct {
+	// Name is the name of the events, any name matches if it is empty
+	Name string
+	// Category is one of the categories of the events, any categories match if it is empty
+	Category string
+	// Phase is the phase of the events, any phase matches if it is empty
+	Phase events.Phase
+	// Args are args that the events must have, with values equal to those given as they would be written to a file,
+	// so that numbers of different types are equal if they hold the same values. A nil value matches any value.
+	Args map[string]interface{}
+}
+
+// Index indexes the events of the trace by their name, categories and the keys of their args. The index shares the
+// events of the trace, but changes to the trace afterwards are not reflected in it.
+func (td TefData) Index() *Index {
+	ix := &Index{
+		events:     td.traceEvents,
+		byName:     map[string][]int{},
+		byCategory: map[string][]int{},
+		byArg:      map[string][]int{},
+	}
+	for i, e := range td.traceEvents {
+		core := e.Core()
+		ix.byName[core.Name] = append(ix.byName[core.Name], i)
+		for _, category := range core.Categories {
+			if list := ix.byCategory[category]; len(list) == 0 || list[len(list)-1] != i {
+				ix.byCategory[category] = append(list, i)
+			}
+		}
+		for key := range indexedArgs(e) {
+			ix.byArg[key] = append(ix.byArg[key], i)
+		}
+	}
+	return ix
+}
+
+// Find returns the events matching the query, in the order they appear in the trace
+func (ix *Index) Find(q Query) []events.Event {
+	var lists [][]int
+	if q.Name != "" {
+		lists = append(lists, ix.byName[q.Name])
+	}
+	if q.Category != "" {
+		lists = append(lists, ix.byCategory[q.Category])
+	}
+	for key := range q.Args {
+		lists = append(lists, ix.byArg[key])
+	}
+
+	var result []events.Event
+	if len(lists) == 0 {
+		for _, e := range ix.events {
+			if matches(e, q) {
+				result = append(result, e)
+			}
+		}
+		return result
+	}
+	// the fewest candidates are those of the rarest condition, which the others are then checked against
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+	for _, i := range lists[0] {
+		if e := ix.events[i]; matches(e, q) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Named returns the events with the given name, in the order they appear in the trace
+func (ix *Index) Named(name string) []events.Event {
+	return ix.lookup(ix.byName[name])
+}
+
+// InCategory returns the events with the given category amongst their categories, in the order they appear in the
+// trace
+func (ix *Index) InCategory(category string) []events.Event {
+	return ix.lookup(ix.byCategory[category])
+}
+
+// WithArg returns the events with an arg of the given key, in the order they appear in the trace
+func (ix *Index) WithArg(key string) []events.Event {
+	return ix.lookup(ix.byArg[key])
+}
+
+// Names returns the distinct names of the events, sorted
+func (ix *Index) Names() []string {
+	return sortedKeys(ix.byName)
+}
+
+// Categories returns the distinct categories of the events, sorted
+func (ix *Index) Categories() []string {
+	return sortedKeys(ix.byCategory)
+}
+
+func (ix *Index) lookup(indices []int) []events.Event {
+	if len(indices) == 0 {
+		return nil
+	}
+	result := make([]events.Event, len(indices))
+	for i, index := range indices {
+		result[i] = ix.events[index]
+	}
+	return result
+}
+
+func sortedKeys(m map[string][]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// matches reports whether the event meets every condition of the query
+func matches(e events.Event, q Query) bool {
+	core := e.Core()
+	if q.Name != "" && core.Name != q.Name {
+		return false
+	}
+	if q.Phase != "" && e.Phase() != q.Phase {
+		return false
+	}
+	if q.Category != "" {
+		found := false
+		for _, category := range core.Categories {
+			if category == q.Category {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(q.Args) > 0 {
+		args := indexedArgs(e)
+		for key, want := range q.Args {
+			got, ok := args[key]
+			if !ok || (want != nil && !reflect.DeepEqual(got, want) && !equalEncoded(got, want)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// indexedArgs returns the args of the event, including its typed args, with its args taking precedence over typed args
+// of the same key as they do when the event is written
+func indexedArgs(e events.Event) map[string]interface{} {
+	getter, ok := e.(events.ArgGetter)
+	if !ok {
+		return nil
+	}
+	args := getter.GetArgs()
+	if typed, ok := e.(events.TypedArgGetter); ok && typed.GetTypedArgs() != nil {
+		typedArgs, err := events.ArgsOf(typed.GetTypedArgs())
+		if err != nil || len(typedArgs) == 0 {
+			return args
+		}
+		for key, value := range args {
+			typedArgs[key] = value
+		}
+		return typedArgs
+	}
+	return args
+}
@@ -0,0 +1,75 @@
+package io_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Index", func() {
+	type action struct {
+		Mnemonic string `json:"mnemonic"`
+	}
+
+	var data *teffyio.TefData
+	var ix *teffyio.Index
+
+	complete := func(name string, args map[string]interface{}, categories ...string) *events.Complete {
+		return &events.Complete{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: name, Categories: categories},
+				Args:      args,
+			},
+			Duration: 1,
+		}
+	}
+
+	BeforeEach(func() {
+		data = &teffyio.TefData{}
+		data.Write(complete("compile", map[string]interface{}{"mnemonic": "CppCompile", "jobs": 4}, "action", "cpp"))
+		data.Write(complete("compile", map[string]interface{}{"mnemonic": "Javac"}, "action"))
+		data.Write(complete("link", map[string]interface{}{"mnemonic": "CppLink"}, "action", "cpp"))
+		typed := complete("compile", nil, "action", "cpp")
+		typed.TypedArgs = action{Mnemonic: "CppCompile"}
+		data.Write(typed)
+		data.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "compile", Categories: []string{"cpp", "cpp"}},
+			},
+		})
+		ix = data.Index()
+	})
+
+	names := func(evs []events.Event) []string {
+		result := []string{}
+		for _, e := range evs {
+			result = append(result, e.Core().Name)
+		}
+		return result
+	}
+
+	It("looks up events by name, category and arg", func() {
+		Expect(ix.Named("compile")).To(HaveLen(4))
+		Expect(ix.Named("missing")).To(BeEmpty())
+		Expect(names(ix.InCategory("cpp"))).To(Equal([]string{"compile", "link", "compile", "compile"}))
+		Expect(ix.WithArg("mnemonic")).To(HaveLen(4))
+		Expect(ix.WithArg("jobs")).To(Equal([]events.Event{data.Events()[0]}))
+		Expect(ix.Names()).To(Equal([]string{"compile", "link"}))
+		Expect(ix.Categories()).To(Equal([]string{"action", "cpp"}))
+	})
+
+	It("finds the events matching every condition of a query", func() {
+		found := ix.Find(teffyio.Query{Name: "compile", Args: map[string]interface{}{"mnemonic": "CppCompile"}})
+		Expect(found).To(Equal([]events.Event{data.Events()[0], data.Events()[3]}))
+
+		Expect(ix.Find(teffyio.Query{Category: "cpp", Phase: events.PhaseInstant})).To(
+			Equal([]events.Event{data.Events()[4]}))
+		Expect(ix.Find(teffyio.Query{Args: map[string]interface{}{"jobs": float64(4)}})).To(HaveLen(1))
+		Expect(ix.Find(teffyio.Query{Args: map[string]interface{}{"jobs": nil}})).To(HaveLen(1))
+		Expect(ix.Find(teffyio.Query{Name: "link", Args: map[string]interface{}{"mnemonic": "Javac"}})).To(BeEmpty())
+		Expect(ix.Find(teffyio.Query{Args: map[string]interface{}{"missing": nil}})).To(BeEmpty())
+		Expect(ix.Find(teffyio.Query{})).To(HaveLen(5))
+	})
+})
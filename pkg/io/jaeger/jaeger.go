@@ -0,0 +1,248 @@
+// jaeger provides reading and writing of traces in the JSON format that Jaeger's UI downloads and uploads
+package jaeger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/omaskery/teffy/internal/lanes"
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+const (
+	// Category is the category given to events converted from Jaeger spans and logs
+	Category = "jaeger"
+
+	// ArgTraceID is the arg holding the trace ID of a span
+	ArgTraceID = "traceID"
+	// ArgSpanID is the arg holding the ID of a span
+	ArgSpanID = "spanID"
+	// ArgParentSpanID is the arg holding the ID of the span that a span is a child of
+	ArgParentSpanID = "parentSpanID"
+	// ArgFollowsFromSpanID is the arg holding the ID of the span that a span follows from
+	ArgFollowsFromSpanID = "followsFromSpanID"
+
+	// logEventField is the field of a log that conventionally names it
+	logEventField = "event"
+	// defaultLogName names logs without an event field
+	defaultLogName = "log"
+
+	refChildOf     = "CHILD_OF"
+	refFollowsFrom = "FOLLOWS_FROM"
+)
+
+// The types below mirror Jaeger's JSON format for traces
+
+type document struct {
+	Data   []trace     `json:"data"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+	Errors interface{} `json:"errors"`
+}
+
+type trace struct {
+	TraceID   string             `json:"traceID"`
+	Spans     []span             `json:"spans"`
+	Processes map[string]process `json:"processes"`
+	Warnings  []string           `json:"warnings"`
+}
+
+type span struct {
+	TraceID       string      `json:"traceID"`
+	SpanID        string      `json:"spanID"`
+	Flags         int         `json:"flags"`
+	OperationName string      `json:"operationName"`
+	References    []reference `json:"references"`
+	StartTime     int64       `json:"startTime"`
+	Duration      int64       `json:"duration"`
+	Tags          []keyValue  `json:"tags"`
+	Logs          []log       `json:"logs"`
+	ProcessID     string      `json:"processID"`
+	Warnings      []string    `json:"warnings"`
+}
+
+type reference struct {
+	RefType string `json:"refType"`
+	TraceID string `json:"traceID"`
+	SpanID  string `json:"spanID"`
+}
+
+type log struct {
+	Timestamp int64      `json:"timestamp"`
+	Fields    []keyValue `json:"fields"`
+}
+
+type process struct {
+	ServiceName string     `json:"serviceName"`
+	Tags        []keyValue `json:"tags"`
+}
+
+type keyValue struct {
+	Key   string          `json:"key"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// value converts the tag value into the equivalent plain Go value
+func (kv keyValue) value() interface{} {
+	switch kv.Type {
+	case "int64":
+		var n json.Number
+		if json.Unmarshal(kv.Value, &n) == nil {
+			if i, err := n.Int64(); err == nil {
+				return i
+			}
+		}
+		var s string
+		if json.Unmarshal(kv.Value, &s) == nil {
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return i
+			}
+		}
+	case "float64":
+		var f float64
+		if json.Unmarshal(kv.Value, &f) == nil {
+			return f
+		}
+	case "bool":
+		var b bool
+		if json.Unmarshal(kv.Value, &b) == nil {
+			return b
+		}
+	}
+	var v interface{}
+	_ = json.Unmarshal(kv.Value, &v)
+	return v
+}
+
+// tags converts a list of tags into a map of plain Go values
+func tags(kvs []keyValue) map[string]interface{} {
+	result := make(map[string]interface{}, len(kvs))
+	for _, kv := range kvs {
+		result[kv.Key] = kv.value()
+	}
+	return result
+}
+
+// spanInfo holds a span along with where it has been placed in the trace
+type spanInfo struct {
+	span span
+	pid  int64
+	tid  int64
+}
+
+// Parse reads traces in Jaeger's JSON format from the provided reader. Each service becomes a process, named after
+// the service, and each span becomes a Complete event whose args hold the span's tags and IDs, along with the span it
+// is a child of or follows from. As the spans of a service can overlap without nesting, they are arranged into as
+// few lanes as possible, each lane being a thread of the process. The logs of each span become Instant events, named
+// after their event field.
+func Parse(r io.Reader) (*tio.TefData, error) {
+	var doc document
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode Jaeger JSON: %w", err)
+	}
+
+	data := &tio.TefData{}
+	data.SetDisplayTimeUnit(tio.DisplayTimeMs)
+
+	// services with the same name are treated as the same process, processes being numbered in order of appearance
+	pids := map[string]int64{}
+	var byProcess [][]*spanInfo
+	for _, t := range doc.Data {
+		for _, s := range t.Spans {
+			service := t.Processes[s.ProcessID].ServiceName
+			pid, ok := pids[service]
+			if !ok {
+				pid = int64(len(pids) + 1)
+				pids[service] = pid
+				byProcess = append(byProcess, nil)
+				data.Write(events.NewProcessName(pid, service))
+			}
+			byProcess[pid-1] = append(byProcess[pid-1], &spanInfo{span: s, pid: pid})
+		}
+	}
+
+	for _, infos := range byProcess {
+		sort.SliceStable(infos, func(i, j int) bool {
+			if infos[i].span.StartTime != infos[j].span.StartTime {
+				return infos[i].span.StartTime < infos[j].span.StartTime
+			}
+			return infos[i].span.Duration > infos[j].span.Duration
+		})
+		intervals := make([]lanes.Interval, 0, len(infos))
+		for _, info := range infos {
+			intervals = append(intervals, lanes.Interval{Start: info.span.StartTime, End: info.span.StartTime + info.span.Duration})
+		}
+		for i, lane := range lanes.Assign(intervals) {
+			infos[i].tid = int64(lane + 1)
+		}
+
+		for _, info := range infos {
+			if err := writeSpan(data, info); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return data, nil
+}
+
+func writeSpan(data *tio.TefData, info *spanInfo) error {
+	s := info.span
+	args := tags(s.Tags)
+	args[ArgTraceID] = s.TraceID
+	args[ArgSpanID] = s.SpanID
+	for _, ref := range s.References {
+		key := ArgParentSpanID
+		if ref.RefType == refFollowsFrom {
+			key = ArgFollowsFromSpanID
+		}
+		if _, ok := args[key]; !ok {
+			args[key] = ref.SpanID
+		}
+	}
+
+	data.Write(&events.Complete{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{
+				Name:       s.OperationName,
+				Categories: []string{Category},
+				Timestamp:  s.StartTime,
+				ProcessID:  &info.pid,
+				ThreadID:   &info.tid,
+			},
+			Args: args,
+		},
+		Duration: s.Duration,
+	})
+
+	for _, l := range s.Logs {
+		fields := tags(l.Fields)
+		name, ok := fields[logEventField].(string)
+		if ok {
+			delete(fields, logEventField)
+		} else {
+			name = defaultLogName
+		}
+		instant := &events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{
+				Name:       name,
+				Categories: []string{Category},
+				Timestamp:  l.Timestamp,
+				ProcessID:  &info.pid,
+				ThreadID:   &info.tid,
+			}},
+			Scope: events.InstantScopeThread,
+		}
+		if len(fields) > 0 {
+			instant.Args = fields
+		}
+		data.Write(instant)
+	}
+	return nil
+}
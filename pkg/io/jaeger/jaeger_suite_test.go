@@ -0,0 +1,13 @@
+package jaeger_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestJaeger(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Jaeger Suite")
+}
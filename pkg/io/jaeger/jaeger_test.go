@@ -0,0 +1,164 @@
+package jaeger_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/jaeger"
+)
+
+const download = `{"data": [{
+  "traceID": "aa",
+  "spans": [
+    {"traceID": "aa", "spanID": "01", "operationName": "GET /", "references": [],
+     "startTime": 1000, "duration": 8000, "processID": "p1",
+     "tags": [{"key": "http.status_code", "type": "int64", "value": 200}, {"key": "error", "type": "bool", "value": false}],
+     "logs": [{"timestamp": 1500, "fields": [{"key": "event", "type": "string", "value": "cache miss"}, {"key": "ratio", "type": "float64", "value": 0.5}]}]},
+    {"traceID": "aa", "spanID": "02", "operationName": "render",
+     "references": [{"refType": "CHILD_OF", "traceID": "aa", "spanID": "01"}],
+     "startTime": 2000, "duration": 3000, "processID": "p1", "tags": [], "logs": []},
+    {"traceID": "aa", "spanID": "03", "operationName": "query",
+     "references": [{"refType": "FOLLOWS_FROM", "traceID": "aa", "spanID": "02"}],
+     "startTime": 2500, "duration": 1500, "processID": "p2", "tags": [], "logs": []},
+    {"traceID": "aa", "spanID": "04", "operationName": "prefetch", "references": [],
+     "startTime": 3000, "duration": 7000, "processID": "p1", "tags": [], "logs": []}
+  ],
+  "processes": {"p1": {"serviceName": "frontend", "tags": []}, "p2": {"serviceName": "db", "tags": []}}
+}]}`
+
+func completes(data *tio.TefData) map[string]*events.Complete {
+	result := map[string]*events.Complete{}
+	for _, e := range data.Events() {
+		if c, ok := e.(*events.Complete); ok {
+			result[c.Name] = c
+		}
+	}
+	return result
+}
+
+var _ = Describe("Parse", func() {
+	var data *tio.TefData
+
+	BeforeEach(func() {
+		var err error
+		data, err = jaeger.Parse(strings.NewReader(download))
+		Expect(err).To(Succeed())
+	})
+
+	It("converts each span to a complete event", func() {
+		spans := completes(data)
+		Expect(spans).To(HaveLen(4))
+
+		get := spans["GET /"]
+		Expect(get.Categories).To(Equal([]string{jaeger.Category}))
+		Expect(get.Timestamp).To(Equal(int64(1000)))
+		Expect(get.Duration).To(Equal(int64(8000)))
+		Expect(get.Args).To(Equal(map[string]interface{}{
+			"http.status_code": int64(200),
+			"error":            false,
+			jaeger.ArgTraceID:  "aa",
+			jaeger.ArgSpanID:   "01",
+		}))
+		Expect(spans["render"].Args).To(HaveKeyWithValue(jaeger.ArgParentSpanID, "01"))
+		Expect(spans["query"].Args).To(HaveKeyWithValue(jaeger.ArgFollowsFromSpanID, "02"))
+	})
+
+	It("gives each service a process, arranging its spans into lanes that nest", func() {
+		spans := completes(data)
+		Expect(*spans["GET /"].ProcessID).To(Equal(int64(1)))
+		Expect(*spans["query"].ProcessID).To(Equal(int64(2)))
+		Expect(*spans["GET /"].ThreadID).To(Equal(int64(1)))
+		Expect(*spans["render"].ThreadID).To(Equal(int64(1)))
+		Expect(*spans["prefetch"].ThreadID).To(Equal(int64(2)))
+
+		processes := data.Processes()
+		Expect(processes[0].Name).To(Equal("frontend"))
+		Expect(processes[1].Name).To(Equal("db"))
+	})
+
+	It("converts logs to instants", func() {
+		for _, e := range data.Events() {
+			if i, ok := e.(*events.Instant); ok {
+				Expect(i.Name).To(Equal("cache miss"))
+				Expect(i.Timestamp).To(Equal(int64(1500)))
+				Expect(*i.ThreadID).To(Equal(int64(1)))
+				Expect(i.Args).To(Equal(map[string]interface{}{"ratio": 0.5}))
+				return
+			}
+		}
+		Fail("no instant event")
+	})
+})
+
+var _ = Describe("Write", func() {
+	It("round trips spans read from Jaeger", func() {
+		data, err := jaeger.Parse(strings.NewReader(download))
+		Expect(err).To(Succeed())
+
+		var buf bytes.Buffer
+		Expect(jaeger.Write(&buf, *data)).To(Succeed())
+		again, err := jaeger.Parse(&buf)
+		Expect(err).To(Succeed())
+
+		Expect(completes(again)).To(Equal(completes(data)))
+		Expect(again.Events()).To(HaveLen(len(data.Events())))
+	})
+
+	It("infers the parents of spans from their nesting", func() {
+		pid, tid := int64(7), int64(1)
+		complete := func(name string, ts, dur int64, args map[string]interface{}) events.Event {
+			return &events.Complete{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: events.EventCore{Name: name, Timestamp: ts, ProcessID: &pid, ThreadID: &tid},
+					Args:      args,
+				},
+				Duration: dur,
+			}
+		}
+		data := &tio.TefData{}
+		data.Write(complete("outer", 0, 100, map[string]interface{}{"count": 3.0, "ratio": 0.25, "list": []interface{}{"a"}}))
+		data.Write(complete("inner", 10, 20, nil))
+		data.Write(complete("next", 200, 10, nil))
+		data.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "mark", Timestamp: 15, ProcessID: &pid, ThreadID: &tid},
+			},
+		})
+
+		var buf bytes.Buffer
+		Expect(jaeger.Write(&buf, *data)).To(Succeed())
+		Expect(buf.String()).To(MatchJSON(`{"data": [
+		  {"traceID": "00000000000000000000000000000001", "processes": {"p7": {"serviceName": "pid 7", "tags": []}}, "warnings": null, "spans": [
+		    {"traceID": "00000000000000000000000000000001", "spanID": "0000000000000001", "flags": 1, "operationName": "outer",
+		     "references": [], "startTime": 0, "duration": 100, "processID": "p7", "logs": [], "warnings": null,
+		     "tags": [
+		       {"key": "count", "type": "int64", "value": 3},
+		       {"key": "list", "type": "string", "value": "[\"a\"]"},
+		       {"key": "ratio", "type": "float64", "value": 0.25}
+		     ]},
+		    {"traceID": "00000000000000000000000000000001", "spanID": "0000000000000002", "flags": 1, "operationName": "inner",
+		     "references": [{"refType": "CHILD_OF", "traceID": "00000000000000000000000000000001", "spanID": "0000000000000001"}],
+		     "startTime": 10, "duration": 20, "processID": "p7", "tags": [], "warnings": null,
+		     "logs": [{"timestamp": 15, "fields": [{"key": "event", "type": "string", "value": "mark"}]}]}
+		  ]},
+		  {"traceID": "00000000000000000000000000000002", "processes": {"p7": {"serviceName": "pid 7", "tags": []}}, "warnings": null, "spans": [
+		    {"traceID": "00000000000000000000000000000002", "spanID": "0000000000000003", "flags": 1, "operationName": "next",
+		     "references": [], "startTime": 200, "duration": 10, "processID": "p7", "tags": [], "logs": [], "warnings": null}
+		  ]}
+		], "total": 0, "limit": 0, "offset": 0, "errors": null}`))
+	})
+
+	It("rejects input that is not JSON", func() {
+		_, err := jaeger.Parse(strings.NewReader("not json"))
+		var syntax *json.SyntaxError
+		Expect(err).To(HaveOccurred())
+		Expect(errors.As(err, &syntax)).To(BeTrue())
+	})
+})
@@ -0,0 +1,246 @@
+package jaeger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+type threadKey struct {
+	pid, tid int64
+}
+
+// exportSpan holds a Complete event being converted to a span, along with the thread it was on
+type exportSpan struct {
+	event  *events.Complete
+	thread threadKey
+	span   *span
+}
+
+// Write writes the Complete events of the trace as spans in Jaeger's JSON format, the inverse of Parse. Spans keep the
+// trace, span and parent IDs held in their args, such as those of spans read by Parse, while other spans are given
+// new IDs, their parents being the Complete events enclosing them on the same thread. Instant events become logs of
+// the innermost span enclosing them on their thread, and processes become Jaeger processes named after the process.
+// Other events have no equivalent in Jaeger and are not written.
+func Write(w io.Writer, data tio.TefData) error {
+	processNames := map[int64]string{}
+	byThread := map[threadKey][]*exportSpan{}
+	var instants []*events.Instant
+	for _, e := range data.Events() {
+		switch ev := e.(type) {
+		case *events.MetadataProcessName:
+			if ev.ProcessID != nil {
+				processNames[*ev.ProcessID] = ev.ProcessName
+			}
+		case *events.Complete:
+			key := thread(ev.EventCore)
+			byThread[key] = append(byThread[key], &exportSpan{event: ev, thread: key})
+		case *events.Instant:
+			instants = append(instants, ev)
+		}
+	}
+
+	threads := make([]threadKey, 0, len(byThread))
+	for key, spans := range byThread {
+		threads = append(threads, key)
+		sort.SliceStable(spans, func(i, j int) bool {
+			if spans[i].event.Timestamp != spans[j].event.Timestamp {
+				return spans[i].event.Timestamp < spans[j].event.Timestamp
+			}
+			return spans[i].event.Duration > spans[j].event.Duration
+		})
+	}
+	sort.Slice(threads, func(i, j int) bool {
+		if threads[i].pid != threads[j].pid {
+			return threads[i].pid < threads[j].pid
+		}
+		return threads[i].tid < threads[j].tid
+	})
+
+	doc := document{Data: []trace{}}
+	traces := map[string]int{}
+	var spanCount, traceCount int
+	for _, key := range threads {
+		var stack []*exportSpan
+		for _, es := range byThread[key] {
+			for len(stack) > 0 && end(stack[len(stack)-1].event) <= es.event.Timestamp {
+				stack = stack[:len(stack)-1]
+			}
+			var enclosing *exportSpan
+			if len(stack) > 0 {
+				enclosing = stack[len(stack)-1]
+			}
+			stack = append(stack, es)
+
+			s := newSpan(es.event)
+			spanCount++
+			if s.SpanID == "" {
+				s.SpanID = fmt.Sprintf("%016x", spanCount)
+			}
+			if s.TraceID == "" {
+				if enclosing != nil {
+					s.TraceID = enclosing.span.TraceID
+					s.References = append(s.References, reference{RefType: refChildOf, TraceID: s.TraceID, SpanID: enclosing.span.SpanID})
+				} else {
+					traceCount++
+					s.TraceID = fmt.Sprintf("%032x", traceCount)
+				}
+			}
+			s.ProcessID = fmt.Sprintf("p%d", key.pid)
+			es.span = s
+
+			i, ok := traces[s.TraceID]
+			if !ok {
+				i = len(doc.Data)
+				traces[s.TraceID] = i
+				doc.Data = append(doc.Data, trace{TraceID: s.TraceID, Processes: map[string]process{}})
+			}
+			t := &doc.Data[i]
+			if _, ok := t.Processes[s.ProcessID]; !ok {
+				name, ok := processNames[key.pid]
+				if !ok {
+					name = fmt.Sprintf("pid %d", key.pid)
+				}
+				t.Processes[s.ProcessID] = process{ServiceName: name, Tags: []keyValue{}}
+			}
+		}
+	}
+
+	for _, instant := range instants {
+		es := innermost(byThread[thread(instant.EventCore)], instant.Timestamp)
+		if es == nil {
+			continue
+		}
+		es.span.Logs = append(es.span.Logs, newLog(instant))
+	}
+
+	for _, key := range threads {
+		for _, es := range byThread[key] {
+			t := &doc.Data[traces[es.span.TraceID]]
+			t.Spans = append(t.Spans, *es.span)
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		return fmt.Errorf("failed to write Jaeger JSON: %w", err)
+	}
+	return nil
+}
+
+func thread(core events.EventCore) threadKey {
+	var key threadKey
+	if core.ProcessID != nil {
+		key.pid = *core.ProcessID
+	}
+	if core.ThreadID != nil {
+		key.tid = *core.ThreadID
+	}
+	return key
+}
+
+func end(c *events.Complete) int64 {
+	return c.Timestamp + c.Duration
+}
+
+// innermost finds the latest starting of the spans, which are sorted by start time, that encloses the timestamp
+func innermost(spans []*exportSpan, ts int64) *exportSpan {
+	var result *exportSpan
+	for _, es := range spans {
+		if es.event.Timestamp > ts {
+			break
+		}
+		if end(es.event) >= ts {
+			result = es
+		}
+	}
+	return result
+}
+
+// newSpan converts a Complete event into a span, taking its IDs from its args if it has them
+func newSpan(c *events.Complete) *span {
+	s := &span{
+		Flags:         1,
+		OperationName: c.Name,
+		References:    []reference{},
+		StartTime:     c.Timestamp,
+		Duration:      c.Duration,
+		Tags:          []keyValue{},
+		Logs:          []log{},
+	}
+
+	keys := make([]string, 0, len(c.Args))
+	for key := range c.Args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := c.Args[key]
+		id, isString := value.(string)
+		switch {
+		case key == ArgTraceID && isString:
+			s.TraceID = id
+		case key == ArgSpanID && isString:
+			s.SpanID = id
+		case (key == ArgParentSpanID || key == ArgFollowsFromSpanID) && isString:
+		default:
+			s.Tags = append(s.Tags, tag(key, value))
+		}
+	}
+
+	for _, ref := range []struct{ arg, refType string }{{ArgParentSpanID, refChildOf}, {ArgFollowsFromSpanID, refFollowsFrom}} {
+		if id, ok := c.Args[ref.arg].(string); ok && s.TraceID != "" {
+			s.References = append(s.References, reference{RefType: ref.refType, TraceID: s.TraceID, SpanID: id})
+		}
+	}
+	return s
+}
+
+// newLog converts an Instant event into a log, its fields being its args along with its name as the event field
+func newLog(instant *events.Instant) log {
+	l := log{Timestamp: instant.Timestamp, Fields: []keyValue{tag(logEventField, instant.Name)}}
+	keys := make([]string, 0, len(instant.Args))
+	for key := range instant.Args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		l.Fields = append(l.Fields, tag(key, instant.Args[key]))
+	}
+	return l
+}
+
+// tag converts a value into a tag of the matching type, values with no equivalent type being encoded as JSON strings
+func tag(key string, value interface{}) keyValue {
+	kv := keyValue{Key: key}
+	switch v := value.(type) {
+	case string:
+		kv.Type = "string"
+	case bool:
+		kv.Type = "bool"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32:
+		kv.Type = "int64"
+	case float32:
+		kv.Type = "float64"
+	case float64:
+		// args read from JSON hold all numbers as floats, so whole numbers are taken to be integers
+		kv.Type = "float64"
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			kv.Type = "int64"
+			value = int64(v)
+		}
+	default:
+		kv.Type = "string"
+		raw, err := json.Marshal(value)
+		if err != nil {
+			raw = []byte(fmt.Sprint(value))
+		}
+		value = string(raw)
+	}
+	kv.Value, _ = json.Marshal(value)
+	return kv
+}
@@ -0,0 +1,166 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// ParseJsonLines reads a trace written as newline delimited JSON (also known as JSON Lines or NDJSON), where each
+// line holds a single trace event, from the provided reader. Blank lines are ignored, as is a final line that was
+// only partially written, as is expected of a file from a writer that was interrupted. As each event is on its own
+// line, parsing leniently also skips lines that are not valid JSON.
+func ParseJsonLines(r io.Reader, options ...ParseOption) (*TefData, error) {
+	p := newParser(options...)
+	reader := newJsonLinesReader(p.reader(r), p)
+
+	result := &TefData{
+		displayTimeUnit:        DisplayTimeMs,
+		metadata:               map[string]interface{}{},
+		stackFrames:            map[string]*events.StackFrame{},
+		controllerTraceDataKey: "traceEvents",
+	}
+
+	for {
+		event, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if event != nil {
+			result.traceEvents = append(result.traceEvents, event)
+		}
+	}
+
+	return reader.parser.result(result)
+}
+
+// NewJsonLinesReader creates an EventReader that decodes the events of a newline delimited JSON trace one line at a
+// time, in the manner of ParseJsonLines. A line that cannot be parsed is reported as a *ParseError, after which
+// reading can continue with the following line.
+func NewJsonLinesReader(r io.Reader) EventReader {
+	return newJsonLinesReader(r, newParser())
+}
+
+func newJsonLinesReader(r io.Reader, p *parser) *jsonLinesReader {
+	return &jsonLinesReader{
+		r:      bufio.NewReader(r),
+		parser: p,
+	}
+}
+
+type jsonLinesReader struct {
+	r      *bufio.Reader
+	parser *parser
+	offset int64
+	err    error
+}
+
+func (r *jsonLinesReader) Next() (events.Event, error) {
+	for r.err == nil {
+		line, err := r.r.ReadBytes('\n')
+		offset := r.offset
+		r.offset += int64(len(line))
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				err = fmt.Errorf("failed to read line: %w", err)
+			}
+			r.err = err
+		}
+
+		raw := bytes.TrimSpace(line)
+		if len(raw) < 1 {
+			continue
+		}
+		if r.err != nil && !json.Valid(raw) {
+			// the final line was cut short part way through being written
+			break
+		}
+
+		event, err := r.parser.parseEvent(raw, offset+int64(bytes.Index(line, raw)))
+		if err != nil || event != nil {
+			return event, err
+		}
+	}
+	return nil, r.err
+}
+
+type jsonLinesWriter struct {
+	w      io.WriteCloser
+	config *writeConfig
+	syncs  periodicSyncer
+	// buf is reused to encode each event, so that writing events does not allocate
+	buf []byte
+}
+
+// NewJsonLinesWriter creates an event writer that writes each event immediately as a line of newline delimited JSON
+// (also known as JSON Lines or NDJSON). Unlike the JSON Array Format used by NewStreamingWriter, every complete line
+// of the output is a valid event on its own, which suits tools that follow a file as it grows or ship it line by line.
+func NewJsonLinesWriter(w io.WriteCloser, options ...WriteOption) EventWriter {
+	config := newWriteConfig(options...)
+	return &jsonLinesWriter{
+		w:      w,
+		config: config,
+		syncs:  periodicSyncer{w: w, config: config},
+	}
+}
+
+// Write emits the provided event immediately to the backing io.Writer as a single line
+func (lw *jsonLinesWriter) Write(e events.Event) error {
+	msg, err := lw.config.appendEvent(lw.buf[:0], e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal json event: %w", err)
+	}
+	lw.buf = append(msg, '\n')
+
+	if _, err = lw.w.Write(lw.buf); err != nil {
+		return fmt.Errorf("failed to write json event: %w", err)
+	}
+
+	return lw.syncs.written()
+}
+
+// Transient reports that each event is finished with once it is written, as it has been encoded
+func (lw *jsonLinesWriter) Transient() bool {
+	return true
+}
+
+// Close syncs the events written, if syncing is enabled, and closes the underlying writer
+func (lw *jsonLinesWriter) Close() error {
+	if err := lw.syncs.sync(); err != nil {
+		return err
+	}
+	if err := lw.w.Close(); err != nil {
+		return fmt.Errorf("failed to close underlying writer: %w", err)
+	}
+	return nil
+}
+
+// WriteJsonLines writes the given events to the provided writer as newline delimited JSON, one event per line
+func WriteJsonLines(w io.Writer, evs []events.Event, options ...WriteOption) error {
+	config := newWriteConfig(options...)
+	bw := bufio.NewWriter(w)
+	for _, e := range evs {
+		msg, err := config.marshal(e)
+		if err != nil {
+			return fmt.Errorf("failed to marshal json event: %w", err)
+		}
+		if _, err := bw.Write(msg); err != nil {
+			return fmt.Errorf("failed to write JSON events: %w", err)
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return fmt.Errorf("failed to write JSON events: %w", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write JSON events: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package io_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("JSON Lines", func() {
+	names := func(data *teffyio.TefData) []string {
+		result := []string{}
+		for _, e := range data.Events() {
+			result = append(result, e.Core().Name)
+		}
+		return result
+	}
+
+	DescribeTable("parsing",
+		func(contents string, expected ...string) {
+			data, err := teffyio.ParseJsonLines(strings.NewReader(contents))
+			Expect(err).To(Succeed())
+			Expect(names(data)).To(Equal(append([]string{}, expected...)))
+		},
+		Entry("no input", ``),
+		Entry("one event per line", "{\"name\":\"a\",\"ph\":\"I\",\"ts\":1}\n{\"name\":\"b\",\"ph\":\"I\",\"ts\":2}\n", "a", "b"),
+		Entry("no final newline", "{\"name\":\"a\",\"ph\":\"I\",\"ts\":1}\n{\"name\":\"b\",\"ph\":\"I\",\"ts\":2}", "a", "b"),
+		Entry("blank lines and CRLF line endings", "\r\n{\"name\":\"a\",\"ph\":\"I\",\"ts\":1}\r\n\r\n  \n", "a"),
+		Entry("a partially written final line", "{\"name\":\"a\",\"ph\":\"I\",\"ts\":1}\n{\"name\":\"b\",\"ph", "a"),
+	)
+
+	It("locates events that cannot be parsed", func() {
+		_, err := teffyio.ParseJsonLines(strings.NewReader("{\"name\":\"a\",\"ph\":\"I\",\"ts\":1}\n\n  {\"name\":\"b\",\"ph\":\"C\",\"ts\":2,\"args\":{\"v\":\"x\"}}\n"))
+		var parseErr *teffyio.ParseError
+		Expect(errors.As(err, &parseErr)).To(BeTrue())
+		Expect(parseErr.Index).To(Equal(1))
+		Expect(parseErr.Offset).To(Equal(int64(32)))
+		Expect(parseErr.Phase).To(Equal(events.PhaseCounter))
+	})
+
+	It("skips malformed lines when parsing leniently", func() {
+		data, err := teffyio.ParseJsonLines(strings.NewReader("{\"name\":\"a\",\"ph\":\"I\",\"ts\":1}\nnot json\n{\"name\":\"c\",\"ph\":\"I\",\"ts\":3}\n"),
+			teffyio.WithLenientParsing())
+		var parseErrs teffyio.ParseErrors
+		Expect(errors.As(err, &parseErrs)).To(BeTrue())
+		Expect(parseErrs).To(HaveLen(1))
+		Expect(names(data)).To(Equal([]string{"a", "c"}))
+	})
+
+	It("continues reading after a line that cannot be parsed", func() {
+		r := teffyio.NewJsonLinesReader(strings.NewReader("{\"name\":\"a\",\"ph\":\"I\",\"ts\":1}\nnot json\n{\"name\":\"c\",\"ph\":\"I\",\"ts\":3}\n"))
+		e, err := r.Next()
+		Expect(err).To(Succeed())
+		Expect(e.Core().Name).To(Equal("a"))
+		_, err = r.Next()
+		Expect(err).To(BeAssignableToTypeOf(&teffyio.ParseError{}))
+		e, err = r.Next()
+		Expect(err).To(Succeed())
+		Expect(e.Core().Name).To(Equal("c"))
+		_, err = r.Next()
+		Expect(err).To(MatchError(io.EOF))
+	})
+
+	It("writes one event per line as it is written", func() {
+		buffer := &bytes.Buffer{}
+		w := teffyio.NewJsonLinesWriter(writerNoopCloser(buffer))
+		Expect(w.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "a", Timestamp: 1}},
+		})).To(Succeed())
+		Expect(buffer.String()).To(HaveSuffix("}\n"))
+		Expect(w.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "b", Timestamp: 2}},
+		})).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+		Expect(strings.Count(buffer.String(), "\n")).To(Equal(2))
+
+		data, err := teffyio.ParseJsonLines(buffer)
+		Expect(err).To(Succeed())
+		Expect(names(data)).To(Equal([]string{"a", "b"}))
+	})
+
+	It("round trips events written all at once", func() {
+		data := &teffyio.TefData{}
+		data.Write(&events.BeginDuration{EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "a", Categories: []string{"c"}, Timestamp: 1}}})
+		data.Write(&events.EndDuration{EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "a", Categories: []string{"c"}, Timestamp: 2}}})
+
+		buffer := &bytes.Buffer{}
+		Expect(teffyio.WriteJsonLines(buffer, data.Events())).To(Succeed())
+		parsed, err := teffyio.ParseJsonLines(buffer)
+		Expect(err).To(Succeed())
+		Expect(parsed.Events()).To(Equal(data.Events()))
+	})
+})
@@ -0,0 +1,120 @@
+package io
+
+import "github.com/omaskery/teffy/pkg/events"
+
+// The accessors below read and maintain the metadata events naming, labelling and sorting the processes and threads
+// of a trace, so that they need not be searched for amongst its events. Events without a process or thread ID are
+// treated as belonging to process or thread 0, and where several metadata events give the same process or thread the
+// same kind of metadata the last of them takes precedence, as it does in viewers. Setters change that event, or write
+// a new metadata event if there is none.
+
+// ProcessName returns the name given to the process by metadata, reporting whether it has one
+func (td TefData) ProcessName(pid int64) (string, bool) {
+	if e, ok := td.lastProcessMetadata(pid, events.MetadataKindProcessName).(*events.MetadataProcessName); ok {
+		return e.ProcessName, true
+	}
+	return "", false
+}
+
+// SetProcessName names the process
+func (td *TefData) SetProcessName(pid int64, name string) {
+	if e, ok := td.lastProcessMetadata(pid, events.MetadataKindProcessName).(*events.MetadataProcessName); ok {
+		e.ProcessName = name
+		return
+	}
+	td.Write(events.NewProcessName(pid, name))
+}
+
+// ProcessLabels returns the labels given to the process by metadata, reporting whether it has any
+func (td TefData) ProcessLabels(pid int64) (string, bool) {
+	if e, ok := td.lastProcessMetadata(pid, events.MetadataKindProcessLabels).(*events.MetadataProcessLabels); ok {
+		return e.Labels, true
+	}
+	return "", false
+}
+
+// SetProcessLabels labels the process
+func (td *TefData) SetProcessLabels(pid int64, labels string) {
+	if e, ok := td.lastProcessMetadata(pid, events.MetadataKindProcessLabels).(*events.MetadataProcessLabels); ok {
+		e.Labels = labels
+		return
+	}
+	td.Write(events.NewProcessLabels(pid, labels))
+}
+
+// ProcessSortIndex returns the index controlling where the process is drawn relative to others, reporting whether
+// metadata gives it one
+func (td TefData) ProcessSortIndex(pid int64) (int64, bool) {
+	if e, ok := td.lastProcessMetadata(pid, events.MetadataKindProcessSortIndex).(*events.MetadataProcessSortIndex); ok {
+		return e.SortIndex, true
+	}
+	return 0, false
+}
+
+// SetProcessSortIndex controls where the process is drawn relative to others, lower indices being drawn higher
+func (td *TefData) SetProcessSortIndex(pid, index int64) {
+	if e, ok := td.lastProcessMetadata(pid, events.MetadataKindProcessSortIndex).(*events.MetadataProcessSortIndex); ok {
+		e.SortIndex = index
+		return
+	}
+	td.Write(events.NewProcessSortIndex(pid, index))
+}
+
+// ThreadName returns the name given to the thread of the process by metadata, reporting whether it has one
+func (td TefData) ThreadName(pid, tid int64) (string, bool) {
+	if e, ok := td.lastThreadMetadata(pid, tid, events.MetadataKindThreadName).(*events.MetadataThreadName); ok {
+		return e.ThreadName, true
+	}
+	return "", false
+}
+
+// SetThreadName names the thread of the process
+func (td *TefData) SetThreadName(pid, tid int64, name string) {
+	if e, ok := td.lastThreadMetadata(pid, tid, events.MetadataKindThreadName).(*events.MetadataThreadName); ok {
+		e.ThreadName = name
+		return
+	}
+	td.Write(events.NewThreadName(pid, tid, name))
+}
+
+// ThreadSortIndex returns the index controlling where the thread is drawn relative to the other threads of its
+// process, reporting whether metadata gives it one
+func (td TefData) ThreadSortIndex(pid, tid int64) (int64, bool) {
+	if e, ok := td.lastThreadMetadata(pid, tid, events.MetadataKindThreadSortIndex).(*events.MetadataThreadSortIndex); ok {
+		return e.SortIndex, true
+	}
+	return 0, false
+}
+
+// SetThreadSortIndex controls where the thread is drawn relative to the other threads of its process, lower indices
+// being drawn higher
+func (td *TefData) SetThreadSortIndex(pid, tid, index int64) {
+	if e, ok := td.lastThreadMetadata(pid, tid, events.MetadataKindThreadSortIndex).(*events.MetadataThreadSortIndex); ok {
+		e.SortIndex = index
+		return
+	}
+	td.Write(events.NewThreadSortIndex(pid, tid, index))
+}
+
+// lastProcessMetadata finds the last metadata event of the given kind for the process, or nil if there is none
+func (td TefData) lastProcessMetadata(pid int64, kind events.MetadataKind) events.Event {
+	for i := len(td.traceEvents) - 1; i >= 0; i-- {
+		e := td.traceEvents[i]
+		if e.Phase() == events.PhaseMetadata && e.Core().Name == string(kind) && threadKeyOf(e.Core()).pid == pid {
+			return e
+		}
+	}
+	return nil
+}
+
+// lastThreadMetadata finds the last metadata event of the given kind for the thread, or nil if there is none
+func (td TefData) lastThreadMetadata(pid, tid int64, kind events.MetadataKind) events.Event {
+	for i := len(td.traceEvents) - 1; i >= 0; i-- {
+		e := td.traceEvents[i]
+		if e.Phase() == events.PhaseMetadata && e.Core().Name == string(kind) &&
+			threadKeyOf(e.Core()) == (threadKey{pid: pid, tid: tid}) {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package io_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Metadata accessors", func() {
+	var data *io.TefData
+
+	// present checks that the metadata is present, returning its value
+	present := func(value interface{}, ok bool) interface{} {
+		Expect(ok).To(BeTrue())
+		return value
+	}
+
+	BeforeEach(func() {
+		data = &io.TefData{}
+		data.Write(events.NewProcessName(1, "first"))
+		data.Write(events.NewThreadName(1, 2, "worker"))
+		data.Write(events.NewProcessName(1, "renamed"))
+		data.Write(events.NewProcessSortIndex(3, 7))
+	})
+
+	It("reads the metadata of processes and threads, later metadata taking precedence", func() {
+		Expect(present(data.ProcessName(1))).To(Equal("renamed"))
+		Expect(present(data.ThreadName(1, 2))).To(Equal("worker"))
+		Expect(present(data.ProcessSortIndex(3))).To(Equal(int64(7)))
+
+		_, ok := data.ProcessName(2)
+		Expect(ok).To(BeFalse())
+		_, ok = data.ThreadName(1, 3)
+		Expect(ok).To(BeFalse())
+		_, ok = data.ProcessLabels(1)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("changes existing metadata rather than adding more", func() {
+		data.SetProcessName(1, "changed")
+		data.SetThreadName(1, 2, "busy worker")
+		data.SetProcessSortIndex(3, 1)
+		Expect(data.Events()).To(HaveLen(4))
+		Expect(present(data.ProcessName(1))).To(Equal("changed"))
+		Expect(present(data.ThreadName(1, 2))).To(Equal("busy worker"))
+		Expect(present(data.ProcessSortIndex(3))).To(Equal(int64(1)))
+	})
+
+	It("writes metadata events for processes and threads without any", func() {
+		data.SetProcessLabels(1, "canary")
+		data.SetThreadName(4, 5, "main")
+		data.SetThreadSortIndex(4, 5, -1)
+		Expect(data.Events()).To(HaveLen(7))
+		Expect(present(data.ProcessLabels(1))).To(Equal("canary"))
+		Expect(present(data.ThreadName(4, 5))).To(Equal("main"))
+		Expect(present(data.ThreadSortIndex(4, 5))).To(Equal(int64(-1)))
+
+		processes := data.Processes()
+		Expect(processes[len(processes)-1].Thread(5).Name).To(Equal("main"))
+	})
+})
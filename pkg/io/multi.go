@@ -0,0 +1,102 @@
+package io
+
+import (
+	"fmt"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// WriterError describes a failure of one of the writers of a MultiWriter
+type WriterError struct {
+	// Writer is the position of the writer that failed within those given to MultiWriter
+	Writer int
+	// Err is the reason the writer failed
+	Err error
+}
+
+func (e *WriterError) Error() string {
+	return fmt.Sprintf("writer %d: %v", e.Writer, e.Err)
+}
+
+func (e *WriterError) Unwrap() error {
+	return e.Err
+}
+
+// WriterErrors are the failures of the writers of a MultiWriter to handle a single call, in the order of the writers
+type WriterErrors []*WriterError
+
+func (e WriterErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d writers failed, the first: %v", len(e), e[0])
+}
+
+func (e WriterErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+type multiWriter struct {
+	writers []EventWriter
+}
+
+// MultiWriter creates an event writer that writes each event to every one of the writers, in the order given, so that
+// one Tracer can write to a file, a RingWriter and a socket at once. The writers are isolated from one another's
+// failures: each event is written to every writer however many of them fail, as is each writer closed, and the
+// failures are returned together as a WriterErrors. Events must not be reused after being written unless every writer
+// has finished with them.
+func MultiWriter(writers ...EventWriter) EventWriter {
+	return &multiWriter{writers: append([]EventWriter(nil), writers...)}
+}
+
+// Write writes the event to every writer
+func (mw *multiWriter) Write(e events.Event) error {
+	return mw.each(func(w EventWriter) error {
+		return w.Write(e)
+	})
+}
+
+// WriteStackFrame writes the stack frame to every writer, as a metadata event to those that cannot hold stack frames
+func (mw *multiWriter) WriteStackFrame(id string, frame *events.StackFrame) error {
+	return mw.each(func(w EventWriter) error {
+		if sfw, ok := w.(StackFrameWriter); ok {
+			return sfw.WriteStackFrame(id, frame)
+		}
+		return w.Write(events.NewStackFrameMetadata(id, frame))
+	})
+}
+
+// Transient reports whether every writer has finished with each event once it is written
+func (mw *multiWriter) Transient() bool {
+	for _, w := range mw.writers {
+		if transient, ok := w.(TransientWriter); !ok || !transient.Transient() {
+			return false
+		}
+	}
+	return true
+}
+
+// Close closes every writer
+func (mw *multiWriter) Close() error {
+	return mw.each(func(w EventWriter) error {
+		return w.Close()
+	})
+}
+
+// each calls fn with every writer, collecting their failures
+func (mw *multiWriter) each(fn func(w EventWriter) error) error {
+	var errs WriterErrors
+	for i, w := range mw.writers {
+		if err := fn(w); err != nil {
+			errs = append(errs, &WriterError{Writer: i, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
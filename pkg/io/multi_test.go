@@ -0,0 +1,99 @@
+package io_test
+
+import (
+	"bytes"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("MultiWriter", func() {
+	var (
+		ring    *teffyio.RingWriter
+		buf     *bytes.Buffer
+		failing *failingWriter
+		writer  teffyio.EventWriter
+	)
+
+	BeforeEach(func() {
+		ring = teffyio.NewRingWriter(10)
+		buf = &bytes.Buffer{}
+		failing = &failingWriter{err: errors.New("sink unreachable")}
+		writer = teffyio.MultiWriter(failing, teffyio.NewStreamingWriter(writerNoopCloser(buf)), ring)
+	})
+
+	It("writes every event to every writer, however many of them fail", func() {
+		err := writer.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "a"}},
+		})
+		Expect(errors.Is(err, failing.err)).To(BeTrue())
+		var errs teffyio.WriterErrors
+		Expect(errors.As(err, &errs)).To(BeTrue())
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Writer).To(Equal(0))
+
+		Expect(writer.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "b"}},
+		})).ToNot(Succeed())
+		Expect(ring.Events()).To(HaveLen(2))
+		Expect(failing.attempts).To(Equal(2))
+		Expect(writer.Close()).ToNot(Succeed())
+		Expect(failing.closed).To(BeTrue())
+
+		data, err := teffyio.ParseJsonArray(buf)
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(HaveLen(2))
+	})
+
+	It("succeeds when every writer does", func() {
+		writer = teffyio.MultiWriter(ring, teffyio.NewRingWriter(1))
+		Expect(writer.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "a"}},
+		})).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+	})
+
+	It("writes stack frames to writers that hold them and as metadata events to those that do not", func() {
+		frameWriter, ok := writer.(teffyio.StackFrameWriter)
+		Expect(ok).To(BeTrue())
+		frame := &events.StackFrame{Name: "main"}
+		Expect(frameWriter.WriteStackFrame("1", frame)).ToNot(Succeed())
+		Expect(ring.StackFrames()).To(Equal(map[string]*events.StackFrame{"1": frame}))
+		Expect(writer.Close()).ToNot(Succeed())
+
+		data, err := teffyio.ParseJsonArray(buf)
+		Expect(err).To(Succeed())
+		data.CollectStackFrames()
+		Expect(data.StackFrames()).To(HaveKey("1"))
+	})
+
+	It("is only transient when every writer is", func() {
+		transient := func(w teffyio.EventWriter) bool {
+			tw, ok := w.(teffyio.TransientWriter)
+			return ok && tw.Transient()
+		}
+		Expect(transient(writer)).To(BeFalse())
+		Expect(transient(teffyio.MultiWriter(teffyio.NewJsonLinesWriter(writerNoopCloser(buf))))).To(BeTrue())
+	})
+})
+
+// failingWriter is an EventWriter that fails to write every event
+type failingWriter struct {
+	err      error
+	attempts int
+	closed   bool
+}
+
+func (w *failingWriter) Write(events.Event) error {
+	w.attempts++
+	return w.err
+}
+
+func (w *failingWriter) Close() error {
+	w.closed = true
+	return w.err
+}
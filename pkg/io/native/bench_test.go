@@ -0,0 +1,68 @@
+package native_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/io/native"
+)
+
+// benchmarkEvents builds a trace with the same mix of events as the JSON benchmarks, for comparison
+func benchmarkEvents(n int) []events.Event {
+	var evs []events.Event
+	pid, tid := int64(1), int64(2)
+	for i := 0; i < n; i++ {
+		ts := int64(i * 10)
+		evs = append(evs, &events.Complete{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{
+					Name: "work", Categories: []string{"cat"}, Timestamp: ts, ProcessID: &pid, ThreadID: &tid,
+				},
+				Args: map[string]interface{}{"index": float64(i), "label": "something"},
+			},
+			Duration: 5,
+		})
+		evs = append(evs, &events.Instant{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "tick", Timestamp: ts + 5, ProcessID: &pid, ThreadID: &tid},
+			},
+			Scope: events.InstantScopeThread,
+		})
+		evs = append(evs, &events.Counter{
+			EventCore: events.EventCore{Name: "mem", Timestamp: ts + 7, ProcessID: &pid},
+			Values:    map[string]float64{"heap": float64(i)},
+		})
+	}
+	return evs
+}
+
+func BenchmarkParse(b *testing.B) {
+	var buffer bytes.Buffer
+	if err := native.Write(&buffer, benchmarkEvents(1000)); err != nil {
+		b.Fatal(err)
+	}
+	input := buffer.Bytes()
+
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := native.Parse(bytes.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	evs := benchmarkEvents(1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := native.Write(ioutil.Discard, evs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
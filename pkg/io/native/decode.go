@@ -0,0 +1,448 @@
+package native
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// ErrCorrupt means that the input is not a well formed binary trace, such as one that has been modified since it was
+// written
+var ErrCorrupt = errors.New("corrupt binary trace")
+
+// decoder decodes the payloads of records, building up the same string table as the encoder that wrote them
+type decoder struct {
+	buf     []byte
+	pos     int
+	strings []string
+	err     error
+}
+
+func (d *decoder) record(payload []byte) (events.Event, error) {
+	d.buf = payload
+	d.pos = 0
+	d.err = nil
+
+	event := d.event()
+	if d.err == nil && d.pos != len(d.buf) {
+		d.fail("unexpected data after event")
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return event, nil
+}
+
+func (d *decoder) event() events.Event {
+	switch k := kind(d.byte()); k {
+	case kindBeginDuration:
+		e := &events.BeginDuration{}
+		d.withArgs(&e.EventWithArgs)
+		d.stackTrace(&e.EventStackTrace)
+		return e
+	case kindEndDuration:
+		e := &events.EndDuration{}
+		d.withArgs(&e.EventWithArgs)
+		d.stackTrace(&e.EventStackTrace)
+		return e
+	case kindComplete:
+		e := &events.Complete{}
+		d.withArgs(&e.EventWithArgs)
+		d.stackTrace(&e.EventStackTrace)
+		e.EndStackTrace = d.trace()
+		e.EndStackFrameID = d.string()
+		e.Duration = d.varint()
+		e.ThreadDuration = d.optionalInt()
+		return e
+	case kindInstant, kindInstantWithArgs:
+		e := &events.Instant{}
+		if k == kindInstant {
+			d.core(&e.EventCore)
+		} else {
+			d.withArgs(&e.EventWithArgs)
+		}
+		d.stackTrace(&e.EventStackTrace)
+		e.Scope = events.InstantScope(d.string())
+		return e
+	case kindCounter:
+		e := &events.Counter{}
+		d.core(&e.EventCore)
+		if n, ok := d.length(); ok {
+			e.Values = make(map[string]float64, n)
+			for i := 0; i < n && d.err == nil; i++ {
+				name := d.string()
+				e.Values[name] = d.float()
+			}
+		}
+		e.Id = d.string()
+		if color := d.string(); color != "" {
+			e.Color = color
+		}
+		return e
+	case kindSample:
+		e := &events.Sample{}
+		d.core(&e.EventCore)
+		d.stackTrace(&e.EventStackTrace)
+		return e
+	case kindAsyncBegin:
+		e := &events.AsyncBegin{}
+		d.withArgs(&e.EventWithArgs)
+		e.Id, e.Scope = d.string(), d.string()
+		return e
+	case kindAsyncEnd:
+		e := &events.AsyncEnd{}
+		d.withArgs(&e.EventWithArgs)
+		e.Id, e.Scope = d.string(), d.string()
+		return e
+	case kindAsyncInstant:
+		e := &events.AsyncInstant{}
+		d.withArgs(&e.EventWithArgs)
+		e.Id, e.Scope = d.string(), d.string()
+		return e
+	case kindFlowStart:
+		e := &events.FlowStart{}
+		d.withArgs(&e.EventWithArgs)
+		e.Id, e.Scope = d.string(), d.string()
+		return e
+	case kindFlowInstant:
+		e := &events.FlowInstant{}
+		d.withArgs(&e.EventWithArgs)
+		e.Id, e.Scope = d.string(), d.string()
+		return e
+	case kindFlowFinish:
+		e := &events.FlowFinish{}
+		d.withArgs(&e.EventWithArgs)
+		e.Id, e.Scope = d.string(), d.string()
+		e.BindingPoint = events.BindingPoint(d.uvarint())
+		return e
+	case kindObjectCreated:
+		e := &events.ObjectCreated{}
+		d.core(&e.EventCore)
+		e.Id = d.string()
+		return e
+	case kindObjectSnapshot:
+		e := &events.ObjectSnapshot{}
+		d.withArgs(&e.EventWithArgs)
+		e.Id = d.string()
+		return e
+	case kindObjectDeleted:
+		e := &events.ObjectDeleted{}
+		d.core(&e.EventCore)
+		e.Id = d.string()
+		return e
+	case kindMetadataProcessName:
+		e := &events.MetadataProcessName{}
+		d.core(&e.EventCore)
+		e.ProcessName = d.string()
+		return e
+	case kindMetadataThreadName:
+		e := &events.MetadataThreadName{}
+		d.core(&e.EventCore)
+		e.ThreadName = d.string()
+		return e
+	case kindMetadataProcessLabels:
+		e := &events.MetadataProcessLabels{}
+		d.core(&e.EventCore)
+		e.Labels = d.string()
+		return e
+	case kindMetadataProcessSortIndex:
+		e := &events.MetadataProcessSortIndex{}
+		d.core(&e.EventCore)
+		e.SortIndex = d.varint()
+		return e
+	case kindMetadataThreadSortIndex:
+		e := &events.MetadataThreadSortIndex{}
+		d.core(&e.EventCore)
+		e.SortIndex = d.varint()
+		return e
+	case kindMetadataMisc:
+		e := &events.MetadataMisc{}
+		d.withArgs(&e.EventWithArgs)
+		return e
+	case kindGlobalMemoryDump:
+		e := &events.GlobalMemoryDump{}
+		d.withArgs(&e.EventWithArgs)
+		return e
+	case kindProcessMemoryDump:
+		e := &events.ProcessMemoryDump{}
+		d.withArgs(&e.EventWithArgs)
+		return e
+	case kindMark:
+		e := &events.Mark{}
+		d.withArgs(&e.EventWithArgs)
+		return e
+	case kindClockSync:
+		e := &events.ClockSync{}
+		d.withArgs(&e.EventWithArgs)
+		e.SyncId = d.string()
+		e.IssueTs = d.optionalInt()
+		return e
+	case kindContextEnter:
+		e := &events.ContextEnter{}
+		d.withArgs(&e.EventWithArgs)
+		e.Id = d.string()
+		return e
+	case kindContextExit:
+		e := &events.ContextExit{}
+		d.withArgs(&e.EventWithArgs)
+		e.Id = d.string()
+		return e
+	case kindLinkIds:
+		e := &events.LinkIds{}
+		d.withArgs(&e.EventWithArgs)
+		e.Id = d.string()
+		e.LinkedId = d.string()
+		return e
+	case kindJson:
+		raw := d.bytes()
+		if d.err != nil {
+			return nil
+		}
+		e, err := events.UnmarshalEvent(raw)
+		if err != nil {
+			d.err = fmt.Errorf("failed to parse event stored as JSON: %w", err)
+		}
+		return e
+	default:
+		d.fail(fmt.Sprintf("unknown event kind %d", k))
+		return nil
+	}
+}
+
+func (d *decoder) core(c *events.EventCore) {
+	flags := d.byte()
+	c.Name = d.string()
+	if n, ok := d.length(); ok {
+		c.Categories = make([]string, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			c.Categories = append(c.Categories, d.string())
+		}
+	}
+	c.Timestamp = d.varint()
+	if flags&coreThreadTimestamp != 0 {
+		v := d.varint()
+		c.ThreadTimestamp = &v
+	}
+	if flags&coreProcessID != 0 {
+		v := d.varint()
+		c.ProcessID = &v
+	}
+	if flags&coreThreadID != 0 {
+		v := d.varint()
+		c.ThreadID = &v
+	}
+	if flags&coreExtra != 0 {
+		n := d.count()
+		c.Extra = make(map[string]json.RawMessage, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			key := d.string()
+			c.Extra[key] = json.RawMessage(d.bytes())
+		}
+	}
+	if flags&coreColor != 0 {
+		c.Color = d.string()
+	}
+}
+
+func (d *decoder) withArgs(e *events.EventWithArgs) {
+	d.core(&e.EventCore)
+	e.Args = d.args()
+}
+
+func (d *decoder) args() map[string]interface{} {
+	n, ok := d.length()
+	if !ok {
+		return nil
+	}
+	args := make(map[string]interface{}, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		key := d.string()
+		args[key] = d.value()
+	}
+	return args
+}
+
+func (d *decoder) value() interface{} {
+	switch tag := d.byte(); tag {
+	case valueNil:
+		return nil
+	case valueFalse:
+		return false
+	case valueTrue:
+		return true
+	case valueInt:
+		return d.varint()
+	case valueFloat:
+		return d.float()
+	case valueString:
+		return d.string()
+	case valueMap:
+		return d.args()
+	case valueSlice:
+		n, ok := d.length()
+		if !ok {
+			return []interface{}(nil)
+		}
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			items = append(items, d.value())
+		}
+		return items
+	case valueJson:
+		raw := d.bytes()
+		if d.err != nil {
+			return nil
+		}
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			d.fail(fmt.Sprintf("invalid JSON arg value: %v", err))
+		}
+		return v
+	default:
+		d.fail(fmt.Sprintf("unknown arg value type %d", tag))
+		return nil
+	}
+}
+
+func (d *decoder) stackTrace(st *events.EventStackTrace) {
+	st.StackTrace = d.trace()
+	st.StackFrameID = d.string()
+}
+
+func (d *decoder) trace() *events.StackTrace {
+	n, ok := d.length()
+	if !ok {
+		return nil
+	}
+	trace := &events.StackTrace{Trace: make([]*events.StackFrame, 0, n)}
+	for i := 0; i < n && d.err == nil; i++ {
+		frame := &events.StackFrame{}
+		frame.Category = d.string()
+		frame.Name = d.string()
+		frame.Parent = d.string()
+		trace.Trace = append(trace.Trace, frame)
+	}
+	return trace
+}
+
+func (d *decoder) optionalInt() *int64 {
+	if d.byte() == 0 {
+		return nil
+	}
+	v := d.varint()
+	return &v
+}
+
+// length decodes the length of a map or slice, reporting false if it was nil
+func (d *decoder) length() (int, bool) {
+	n := d.count()
+	if n == 0 {
+		return 0, false
+	}
+	return n - 1, true
+}
+
+// count decodes the number of items of something, which cannot be more than the bytes remaining
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)-d.pos)+1 {
+		d.fail("length exceeds event")
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) string() string {
+	ref := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if ref > 0 {
+		if ref > uint64(len(d.strings)) {
+			d.fail("reference to unknown string")
+			return ""
+		}
+		return d.strings[ref-1]
+	}
+	s := string(d.bytes())
+	if d.err == nil && len(d.strings) < maxInterned {
+		d.strings = append(d.strings, s)
+	}
+	return s
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.buf)-d.pos) {
+		d.fail("length exceeds event")
+		return nil
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.pos >= len(d.buf) {
+		d.fail("event ended early")
+		return 0
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	if n <= 0 {
+		d.fail("invalid varint")
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf[d.pos:])
+	if n <= 0 {
+		d.fail("invalid varint")
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) float() float64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf)-d.pos < 8 {
+		d.fail("event ended early")
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.buf[d.pos:]))
+	d.pos += 8
+	return v
+}
+
+func (d *decoder) fail(reason string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%s at byte %d of event: %w", reason, d.pos, ErrCorrupt)
+	}
+}
@@ -0,0 +1,523 @@
+package native
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// kind identifies how the payload of a record is encoded, one per event type, or as JSON for those without their own
+type kind byte
+
+const (
+	kindJson kind = iota
+	kindBeginDuration
+	kindEndDuration
+	kindComplete
+	kindInstant
+	kindCounter
+	kindSample
+	kindAsyncBegin
+	kindAsyncEnd
+	kindAsyncInstant
+	kindFlowStart
+	kindFlowInstant
+	kindFlowFinish
+	kindObjectCreated
+	kindObjectSnapshot
+	kindObjectDeleted
+	kindMetadataProcessName
+	kindMetadataThreadName
+	kindMetadataProcessLabels
+	kindMetadataProcessSortIndex
+	kindMetadataThreadSortIndex
+	kindMetadataMisc
+	kindGlobalMemoryDump
+	kindProcessMemoryDump
+	kindMark
+	kindClockSync
+	kindContextEnter
+	kindContextExit
+	kindLinkIds
+	// kindInstantWithArgs holds instant events with their args, the record of kindInstant predating them
+	kindInstantWithArgs
+)
+
+// flags of the optional fields of EventCore
+const (
+	coreThreadTimestamp = 1 << iota
+	coreProcessID
+	coreThreadID
+	coreExtra
+	coreColor
+)
+
+// tags of the types of arg values
+const (
+	valueNil byte = iota
+	valueFalse
+	valueTrue
+	valueInt
+	valueFloat
+	valueString
+	valueMap
+	valueSlice
+	valueJson
+)
+
+// maxInterned limits the size of the string table, strings seen after it is full are always written in full
+const maxInterned = 1 << 16
+
+// encoder encodes events into records, interning the strings it writes so that each is only written in full the
+// first time it is seen
+type encoder struct {
+	buf     []byte
+	strings map[string]uint64
+	// added are the strings interned while encoding the current event, which are forgotten again if it fails
+	added []string
+}
+
+func newEncoder() *encoder {
+	return &encoder{
+		strings: map[string]uint64{},
+	}
+}
+
+// record encodes the event as a length prefixed record, appending it to dst
+func (e *encoder) record(dst []byte, event events.Event) ([]byte, error) {
+	e.buf = e.buf[:0]
+	e.added = e.added[:0]
+	if err := e.event(event); err != nil {
+		// the event will not be written, so the strings it interned must not be referred to later
+		for _, s := range e.added {
+			delete(e.strings, s)
+		}
+		return dst, err
+	}
+	dst = binary.AppendUvarint(dst, uint64(len(e.buf)))
+	return append(dst, e.buf...), nil
+}
+
+func (e *encoder) event(event events.Event) error {
+	if hasId2(event) {
+		// the records of async, object, context and link events predate their id2 and object scopes, so such events are carried as
+		// JSON rather than changing the records
+		return e.json(event)
+	}
+	if hasFraction(event) {
+		// records hold whole microseconds, so times with fractions of a microsecond are carried as JSON too
+		return e.json(event)
+	}
+
+	switch ev := event.(type) {
+	case *events.BeginDuration:
+		e.kind(kindBeginDuration)
+		if err := e.withArgs(&ev.EventWithArgs); err != nil {
+			return err
+		}
+		e.stackTrace(&ev.EventStackTrace)
+	case *events.EndDuration:
+		e.kind(kindEndDuration)
+		if err := e.withArgs(&ev.EventWithArgs); err != nil {
+			return err
+		}
+		e.stackTrace(&ev.EventStackTrace)
+	case *events.Complete:
+		e.kind(kindComplete)
+		if err := e.withArgs(&ev.EventWithArgs); err != nil {
+			return err
+		}
+		e.stackTrace(&ev.EventStackTrace)
+		e.trace(ev.EndStackTrace)
+		e.string(ev.EndStackFrameID)
+		e.varint(ev.Duration)
+		e.optionalInt(ev.ThreadDuration)
+	case *events.Instant:
+		if !ev.Scope.Valid() {
+			return fmt.Errorf("instant event has scope '%s': %w", ev.Scope, events.ErrInvalidScope)
+		}
+		if ev.Args == nil && ev.TypedArgs == nil {
+			e.kind(kindInstant)
+			e.core(&ev.EventCore)
+		} else {
+			e.kind(kindInstantWithArgs)
+			if err := e.withArgs(&ev.EventWithArgs); err != nil {
+				return err
+			}
+		}
+		e.stackTrace(&ev.EventStackTrace)
+		e.string(string(ev.Scope))
+	case *events.Counter:
+		if ev.Series != nil {
+			// the record of counters predates the order of their series, so counters with one are carried as JSON
+			return e.json(event)
+		}
+		e.kind(kindCounter)
+		e.core(&ev.EventCore)
+		e.length(len(ev.Values), ev.Values == nil)
+		for name, value := range ev.Values {
+			e.string(name)
+			e.float(value)
+		}
+		e.string(ev.Id)
+		// the first version of the encoding kept the color of counters here, it is now kept with the core fields
+		e.string("")
+	case *events.Sample:
+		e.kind(kindSample)
+		e.core(&ev.EventCore)
+		e.stackTrace(&ev.EventStackTrace)
+	case *events.AsyncBegin:
+		return e.scoped(kindAsyncBegin, &ev.EventWithArgs, ev.Id, ev.Scope)
+	case *events.AsyncEnd:
+		return e.scoped(kindAsyncEnd, &ev.EventWithArgs, ev.Id, ev.Scope)
+	case *events.AsyncInstant:
+		return e.scoped(kindAsyncInstant, &ev.EventWithArgs, ev.Id, ev.Scope)
+	case *events.FlowStart:
+		return e.scoped(kindFlowStart, &ev.EventWithArgs, ev.Id, ev.Scope)
+	case *events.FlowInstant:
+		return e.scoped(kindFlowInstant, &ev.EventWithArgs, ev.Id, ev.Scope)
+	case *events.FlowFinish:
+		if err := e.scoped(kindFlowFinish, &ev.EventWithArgs, ev.Id, ev.Scope); err != nil {
+			return err
+		}
+		e.uvarint(uint64(ev.BindingPoint))
+	case *events.ObjectCreated:
+		e.kind(kindObjectCreated)
+		e.core(&ev.EventCore)
+		e.string(ev.Id)
+	case *events.ObjectSnapshot:
+		return e.identified(kindObjectSnapshot, &ev.EventWithArgs, ev.Id)
+	case *events.ObjectDeleted:
+		e.kind(kindObjectDeleted)
+		e.core(&ev.EventCore)
+		e.string(ev.Id)
+	case *events.MetadataProcessName:
+		e.kind(kindMetadataProcessName)
+		e.core(&ev.EventCore)
+		e.string(ev.ProcessName)
+	case *events.MetadataThreadName:
+		e.kind(kindMetadataThreadName)
+		e.core(&ev.EventCore)
+		e.string(ev.ThreadName)
+	case *events.MetadataProcessLabels:
+		e.kind(kindMetadataProcessLabels)
+		e.core(&ev.EventCore)
+		e.string(ev.Labels)
+	case *events.MetadataProcessSortIndex:
+		e.kind(kindMetadataProcessSortIndex)
+		e.core(&ev.EventCore)
+		e.varint(ev.SortIndex)
+	case *events.MetadataThreadSortIndex:
+		e.kind(kindMetadataThreadSortIndex)
+		e.core(&ev.EventCore)
+		e.varint(ev.SortIndex)
+	case *events.MetadataMisc:
+		e.kind(kindMetadataMisc)
+		return e.withArgs(&ev.EventWithArgs)
+	case *events.GlobalMemoryDump:
+		e.kind(kindGlobalMemoryDump)
+		return e.withArgs(&ev.EventWithArgs)
+	case *events.ProcessMemoryDump:
+		e.kind(kindProcessMemoryDump)
+		return e.withArgs(&ev.EventWithArgs)
+	case *events.Mark:
+		e.kind(kindMark)
+		return e.withArgs(&ev.EventWithArgs)
+	case *events.ClockSync:
+		if err := e.identified(kindClockSync, &ev.EventWithArgs, ev.SyncId); err != nil {
+			return err
+		}
+		e.optionalInt(ev.IssueTs)
+	case *events.ContextEnter:
+		return e.identified(kindContextEnter, &ev.EventWithArgs, ev.Id)
+	case *events.ContextExit:
+		return e.identified(kindContextExit, &ev.EventWithArgs, ev.Id)
+	case *events.LinkIds:
+		if ev.Id == "" || ev.LinkedId == "" {
+			// the JSON encoding rejects links that are missing either of their ids
+			return e.json(event)
+		}
+		if err := e.identified(kindLinkIds, &ev.EventWithArgs, ev.Id); err != nil {
+			return err
+		}
+		e.string(ev.LinkedId)
+	default:
+		// anything else, such as events of unsupported phases, is carried as JSON
+		return e.json(event)
+	}
+	return nil
+}
+
+func (e *encoder) json(event events.Event) error {
+	raw, err := events.MarshalEvent(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event of phase '%s': %w", event.Phase(), err)
+	}
+	e.kind(kindJson)
+	e.bytes(raw)
+	return nil
+}
+
+// hasFraction reports whether the event's timestamp, or duration, has a fraction of a microsecond
+func hasFraction(event events.Event) bool {
+	if complete, ok := event.(*events.Complete); ok && complete.DurationFraction != 0 {
+		return true
+	}
+	return event.Core().TimestampFraction != 0
+}
+
+// hasId2 reports whether the event is identified in a way that its record cannot hold
+func hasId2(event events.Event) bool {
+	switch ev := event.(type) {
+	case *events.AsyncBegin:
+		return ev.Id2 != nil
+	case *events.AsyncEnd:
+		return ev.Id2 != nil
+	case *events.AsyncInstant:
+		return ev.Id2 != nil
+	case *events.ObjectCreated:
+		return ev.Id2 != nil || ev.Scope != ""
+	case *events.ObjectSnapshot:
+		return ev.Id2 != nil || ev.Scope != ""
+	case *events.ObjectDeleted:
+		return ev.Id2 != nil || ev.Scope != ""
+	case *events.ContextEnter:
+		return ev.Id2 != nil
+	case *events.ContextExit:
+		return ev.Id2 != nil
+	case *events.LinkIds:
+		return ev.Id2 != nil
+	}
+	return false
+}
+
+// scoped encodes the events that have an ID and scope, such as async and flow events
+func (e *encoder) scoped(k kind, ev *events.EventWithArgs, id, scope string) error {
+	if err := e.identified(k, ev, id); err != nil {
+		return err
+	}
+	e.string(scope)
+	return nil
+}
+
+// identified encodes the events that have args and an ID
+func (e *encoder) identified(k kind, ev *events.EventWithArgs, id string) error {
+	e.kind(k)
+	if err := e.withArgs(ev); err != nil {
+		return err
+	}
+	e.string(id)
+	return nil
+}
+
+func (e *encoder) kind(k kind) {
+	e.buf = append(e.buf, byte(k))
+}
+
+func (e *encoder) core(c *events.EventCore) {
+	var flags byte
+	if c.ThreadTimestamp != nil {
+		flags |= coreThreadTimestamp
+	}
+	if c.ProcessID != nil {
+		flags |= coreProcessID
+	}
+	if c.ThreadID != nil {
+		flags |= coreThreadID
+	}
+	if c.Extra != nil {
+		flags |= coreExtra
+	}
+	if c.Color != "" {
+		flags |= coreColor
+	}
+	e.buf = append(e.buf, flags)
+
+	e.string(c.Name)
+	e.length(len(c.Categories), c.Categories == nil)
+	for _, category := range c.Categories {
+		e.string(category)
+	}
+	e.varint(c.Timestamp)
+	if c.ThreadTimestamp != nil {
+		e.varint(*c.ThreadTimestamp)
+	}
+	if c.ProcessID != nil {
+		e.varint(*c.ProcessID)
+	}
+	if c.ThreadID != nil {
+		e.varint(*c.ThreadID)
+	}
+	if c.Extra != nil {
+		e.uvarint(uint64(len(c.Extra)))
+		for key, raw := range c.Extra {
+			e.string(key)
+			e.bytes(raw)
+		}
+	}
+	if c.Color != "" {
+		e.string(c.Color)
+	}
+}
+
+func (e *encoder) withArgs(ev *events.EventWithArgs) error {
+	e.core(&ev.EventCore)
+	if ev.TypedArgs == nil {
+		return e.args(ev.Args)
+	}
+	// typed args are written as the args they encode to, as they are read back
+	args, err := events.ArgsOf(ev.TypedArgs)
+	if err != nil {
+		return err
+	}
+	for k, v := range ev.Args {
+		args[k] = v
+	}
+	return e.args(args)
+}
+
+func (e *encoder) args(args map[string]interface{}) error {
+	e.length(len(args), args == nil)
+	for key, value := range args {
+		e.string(key)
+		if err := e.value(value); err != nil {
+			return fmt.Errorf("failed to encode arg '%s': %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (e *encoder) value(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		e.buf = append(e.buf, valueNil)
+	case bool:
+		if v {
+			e.buf = append(e.buf, valueTrue)
+		} else {
+			e.buf = append(e.buf, valueFalse)
+		}
+	case int:
+		e.int(int64(v))
+	case int8:
+		e.int(int64(v))
+	case int16:
+		e.int(int64(v))
+	case int32:
+		e.int(int64(v))
+	case int64:
+		e.int(v)
+	case uint8:
+		e.int(int64(v))
+	case uint16:
+		e.int(int64(v))
+	case uint32:
+		e.int(int64(v))
+	case float32:
+		e.buf = append(e.buf, valueFloat)
+		e.float(float64(v))
+	case float64:
+		e.buf = append(e.buf, valueFloat)
+		e.float(v)
+	case string:
+		e.buf = append(e.buf, valueString)
+		e.string(v)
+	case map[string]interface{}:
+		e.buf = append(e.buf, valueMap)
+		return e.args(v)
+	case []interface{}:
+		e.buf = append(e.buf, valueSlice)
+		e.length(len(v), v == nil)
+		for _, item := range v {
+			if err := e.value(item); err != nil {
+				return err
+			}
+		}
+	default:
+		// anything else is stored as it would be in a JSON trace, and so reads back as it would from one
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		e.buf = append(e.buf, valueJson)
+		e.bytes(raw)
+	}
+	return nil
+}
+
+func (e *encoder) int(v int64) {
+	e.buf = append(e.buf, valueInt)
+	e.varint(v)
+}
+
+func (e *encoder) stackTrace(st *events.EventStackTrace) {
+	e.trace(st.StackTrace)
+	e.string(st.StackFrameID)
+}
+
+func (e *encoder) trace(trace *events.StackTrace) {
+	if trace == nil {
+		e.length(0, true)
+		return
+	}
+	e.length(len(trace.Trace), false)
+	for _, frame := range trace.Trace {
+		e.string(frame.Category)
+		e.string(frame.Name)
+		e.string(frame.Parent)
+	}
+}
+
+func (e *encoder) optionalInt(v *int64) {
+	if v == nil {
+		e.buf = append(e.buf, 0)
+		return
+	}
+	e.buf = append(e.buf, 1)
+	e.varint(*v)
+}
+
+// length encodes the length of a map or slice, distinguishing nil from empty so that events read back unchanged
+func (e *encoder) length(n int, isNil bool) {
+	if isNil {
+		e.uvarint(0)
+		return
+	}
+	e.uvarint(uint64(n) + 1)
+}
+
+// string encodes a reference to the string in the string table, or if this is the first time it is seen, a reference
+// of zero followed by the string itself
+func (e *encoder) string(s string) {
+	if index, ok := e.strings[s]; ok {
+		e.uvarint(index + 1)
+		return
+	}
+	e.uvarint(0)
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+	if len(e.strings) < maxInterned {
+		e.strings[s] = uint64(len(e.strings))
+		e.added = append(e.added, s)
+	}
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *encoder) float(v float64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v))
+}
@@ -0,0 +1,240 @@
+// native provides a compact binary encoding of trace events, which is much faster to write and read than JSON and
+// produces smaller files, for when traces are recorded on hot paths and only converted to JSON for viewing.
+//
+// A binary trace starts with Magic followed by a version byte, then holds a record per event, each being the length
+// of its payload as a uvarint followed by the payload. Strings are interned as they are written, so that names and
+// categories repeated throughout a trace are only written out in full once. Like a trace from a streaming writer, a
+// binary trace may be read up to the last complete record if the process writing it stopped part way through.
+package native
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// Magic identifies the start of a binary trace
+const Magic = "TEFFYBIN"
+
+// version is the version of the encoding written, following Magic. Traces of earlier versions, back to
+// oldestVersion, can still be read.
+const version byte = 3
+
+// oldestVersion is the earliest version of the encoding that can still be read
+const oldestVersion byte = 1
+
+// maxRecordLength limits the size of a single event, so that a corrupt length cannot exhaust memory
+const maxRecordLength = 64 << 20
+
+// ErrNotBinaryTrace means that the input does not start with Magic, or is of a version of the encoding that is not
+// understood
+var ErrNotBinaryTrace = errors.New("not a teffy binary trace")
+
+// IsBinaryTrace reports whether the given start of a file is the start of a binary trace
+func IsBinaryTrace(start []byte) bool {
+	return bytes.HasPrefix(start, []byte(Magic))
+}
+
+type writer struct {
+	w       io.WriteCloser
+	encoder *encoder
+	buf     []byte
+	started bool
+	err     error
+}
+
+// NewWriter creates an event writer that writes each event to the underlying writer as soon as it is written, in the
+// binary encoding
+func NewWriter(w io.WriteCloser) tio.EventWriter {
+	return &writer{
+		w:       w,
+		encoder: newEncoder(),
+	}
+}
+
+// Write emits the provided event immediately to the backing io.Writer
+func (w *writer) Write(e events.Event) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	w.buf = w.buf[:0]
+	if !w.started {
+		w.buf = appendHeader(w.buf)
+	}
+	buf, err := w.encoder.record(w.buf, e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	w.buf = buf
+
+	if _, err := w.w.Write(w.buf); err != nil {
+		// the string table of the encoder no longer matches what has been written, so nothing more can be written
+		w.err = fmt.Errorf("failed to write event: %w", err)
+		return w.err
+	}
+	w.started = true
+	return nil
+}
+
+// Close writes the header of the trace if no events were written, so that the output is still a valid trace, and
+// closes the underlying writer
+func (w *writer) Close() error {
+	if !w.started && w.err == nil {
+		if _, err := w.w.Write(appendHeader(nil)); err != nil {
+			return fmt.Errorf("failed to write header: %w", err)
+		}
+		w.started = true
+	}
+	if err := w.w.Close(); err != nil {
+		return fmt.Errorf("failed to close underlying writer: %w", err)
+	}
+	return nil
+}
+
+// Write writes all of the given events to the provided writer in the binary encoding
+func Write(w io.Writer, evs []events.Event) error {
+	bw := bufio.NewWriter(w)
+	enc := newEncoder()
+
+	buf := appendHeader(nil)
+	for _, e := range evs {
+		var err error
+		if buf, err = enc.record(buf, e); err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return fmt.Errorf("failed to write events: %w", err)
+		}
+		buf = buf[:0]
+	}
+	if len(evs) < 1 {
+		if _, err := bw.Write(buf); err != nil {
+			return fmt.Errorf("failed to write events: %w", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write events: %w", err)
+	}
+	return nil
+}
+
+func appendHeader(buf []byte) []byte {
+	return append(append(buf, Magic...), version)
+}
+
+type reader struct {
+	r       *bufio.Reader
+	decoder decoder
+	payload []byte
+	started bool
+	err     error
+}
+
+// NewReader creates an EventReader that decodes the events of a binary trace one at a time as they are requested.
+// A record left partially written at the end of the trace is treated as the end of it. Unlike the JSON readers, an
+// event that cannot be decoded ends reading, as the events after it cannot be decoded without it.
+func NewReader(r io.Reader) tio.EventReader {
+	return &reader{
+		r: bufio.NewReader(r),
+	}
+}
+
+func (r *reader) Next() (events.Event, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	e, err := r.next()
+	if err != nil {
+		r.err = err
+	}
+	return e, err
+}
+
+func (r *reader) next() (events.Event, error) {
+	if !r.started {
+		if err := r.readHeader(); err != nil {
+			return nil, err
+		}
+		r.started = true
+	}
+
+	length, err := readUvarint(r.r)
+	if err != nil {
+		return nil, err
+	}
+
+	if length > maxRecordLength {
+		return nil, fmt.Errorf("event of %d bytes is too long: %w", length, ErrCorrupt)
+	}
+	if uint64(cap(r.payload)) < length {
+		r.payload = make([]byte, length)
+	}
+	r.payload = r.payload[:length]
+	if _, err := io.ReadFull(r.r, r.payload); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// the last record was only partially written
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read event: %w", err)
+	}
+
+	e, err := r.decoder.record(r.payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode event: %w", err)
+	}
+	return e, nil
+}
+
+// readUvarint reads the length of the next record, treating a length that was only partially written as the end of
+// the trace
+func readUvarint(r io.ByteReader) (uint64, error) {
+	length, err := binary.ReadUvarint(r)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, io.EOF
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, fmt.Errorf("failed to read event length: %w", err)
+	}
+	return length, err
+}
+
+func (r *reader) readHeader() error {
+	header := make([]byte, len(Magic)+1)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrNotBinaryTrace
+		}
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	if !IsBinaryTrace(header) {
+		return ErrNotBinaryTrace
+	}
+	if v := header[len(Magic)]; v < oldestVersion || v > version {
+		return fmt.Errorf("unsupported version %d: %w", header[len(Magic)], ErrNotBinaryTrace)
+	}
+	return nil
+}
+
+// Parse reads all of the events of a binary trace from the provided reader
+func Parse(r io.Reader) (*tio.TefData, error) {
+	reader := NewReader(r)
+	data := &tio.TefData{}
+	data.SetDisplayTimeUnit(tio.DisplayTimeMs)
+	for {
+		e, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data.Write(e)
+	}
+}
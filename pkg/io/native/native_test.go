@@ -0,0 +1,270 @@
+package native_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/native"
+)
+
+type closingBuffer struct {
+	bytes.Buffer
+}
+
+func (b *closingBuffer) Close() error {
+	return nil
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+var _ = Describe("Native", func() {
+	core := func(name string, ts int64) events.EventCore {
+		return events.EventCore{
+			Name:       name,
+			Categories: []string{"a", "b"},
+			Timestamp:  ts,
+			ProcessID:  int64Ptr(1),
+			ThreadID:   int64Ptr(2),
+		}
+	}
+	colored := func(core events.EventCore, color string) events.EventCore {
+		core.Color = color
+		return core
+	}
+	withArgs := func(name string, ts int64) events.EventWithArgs {
+		return events.EventWithArgs{
+			EventCore: core(name, ts),
+			Args: map[string]interface{}{
+				"count":  int64(3),
+				"ratio":  0.5,
+				"label":  "value",
+				"flag":   true,
+				"none":   nil,
+				"nested": map[string]interface{}{"list": []interface{}{int64(1), "two"}},
+			},
+		}
+	}
+	stack := &events.StackTrace{Trace: []*events.StackFrame{{Category: "main.go", Name: "main"}, {Name: "work"}}}
+
+	all := []events.Event{
+		&events.BeginDuration{EventWithArgs: withArgs("begin", 1), EventStackTrace: events.EventStackTrace{StackTrace: stack}},
+		&events.EndDuration{EventWithArgs: withArgs("begin", 2), EventStackTrace: events.EventStackTrace{StackFrameID: "7"}},
+		&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: colored(core("highlighted", 2), "thread_state_runnable")},
+		},
+		&events.Complete{
+			EventWithArgs:      withArgs("complete", -3),
+			EventEndStackTrace: events.EventEndStackTrace{EndStackTrace: stack, EndStackFrameID: "8"},
+			Duration:           4,
+			ThreadDuration:     int64Ptr(2),
+		},
+		&events.Instant{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "instant", Timestamp: 5, ThreadTimestamp: int64Ptr(4)},
+			},
+			Scope: events.InstantScopeGlobal,
+		},
+		&events.Instant{EventWithArgs: withArgs("instant", 5), Scope: events.InstantScopeThread},
+		&events.Counter{EventCore: colored(core("counter", 6), "good"), Values: map[string]float64{"x": 1.5, "y": -2}, Id: "c"},
+		&events.Counter{EventCore: core("counter", 6), Values: map[string]float64{"x": 1.5, "y": -2}, Series: []string{"y", "x"}},
+		&events.Sample{EventCore: core("sample", 7), EventStackTrace: events.EventStackTrace{StackTrace: stack}},
+		&events.AsyncBegin{EventWithArgs: withArgs("async", 8), Id: "0x1", Scope: "s"},
+		&events.AsyncInstant{EventWithArgs: withArgs("async", 9), Id: "0x1"},
+		&events.AsyncEnd{EventWithArgs: withArgs("async", 10), Id: "0x1", Scope: "s"},
+		&events.FlowStart{EventWithArgs: withArgs("flow", 11), Id: "f"},
+		&events.FlowInstant{EventWithArgs: withArgs("flow", 12), Id: "f"},
+		&events.FlowFinish{EventWithArgs: withArgs("flow", 13), Id: "f", BindingPoint: events.BindingPointNext},
+		&events.ObjectCreated{EventCore: core("object", 14), Id: "o"},
+		&events.ObjectSnapshot{EventWithArgs: withArgs("object", 15), Id: "o"},
+		&events.ObjectDeleted{EventCore: core("object", 16), Id: "o"},
+		&events.MetadataProcessName{EventCore: core("process_name", 0), ProcessName: "proc"},
+		&events.MetadataThreadName{EventCore: core("thread_name", 0), ThreadName: "thread"},
+		&events.MetadataProcessLabels{EventCore: core("process_labels", 0), Labels: "label"},
+		&events.MetadataProcessSortIndex{EventCore: core("process_sort_index", 0), SortIndex: -1},
+		&events.MetadataThreadSortIndex{EventCore: core("thread_sort_index", 0), SortIndex: 2},
+		&events.MetadataMisc{EventWithArgs: withArgs("misc", 0)},
+		&events.GlobalMemoryDump{EventWithArgs: withArgs("global", 17)},
+		&events.ProcessMemoryDump{EventWithArgs: withArgs("process", 18)},
+		&events.Mark{EventWithArgs: withArgs("mark", 19)},
+		&events.ClockSync{EventWithArgs: withArgs("clock_sync", 20), SyncId: "sync", IssueTs: int64Ptr(19)},
+		&events.ContextEnter{EventWithArgs: withArgs("context", 21), Id: "ctx"},
+		&events.ContextExit{EventWithArgs: withArgs("context", 22), Id: "ctx"},
+		&events.LinkIds{EventWithArgs: withArgs("link", 23), Id: "a", LinkedId: "b"},
+		&events.Raw{EventCore: events.EventCore{Name: "raw", Categories: []string{}, Timestamp: 24, Extra: map[string]json.RawMessage{"x": json.RawMessage(`[1,2]`)}}, RawPhase: "?"},
+	}
+
+	It("round trips every kind of event", func() {
+		buffer := &bytes.Buffer{}
+		Expect(native.Write(buffer, all)).To(Succeed())
+
+		data, err := native.Parse(buffer)
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(Equal(all))
+	})
+
+	It("round trips events identified by id2", func() {
+		identified := []events.Event{
+			&events.AsyncBegin{EventWithArgs: events.EventWithArgs{EventCore: core("async", 1)}, Id2: &events.Id2{Local: "0x2"}},
+			&events.ObjectCreated{EventCore: core("object", 2), Id: "o", Scope: "s"},
+			&events.ObjectDeleted{EventCore: core("object", 3), Id2: &events.Id2{Global: "g"}},
+			&events.ContextEnter{EventWithArgs: events.EventWithArgs{EventCore: core("context", 4)}, Id2: &events.Id2{Local: "c"}},
+		}
+
+		buffer := &bytes.Buffer{}
+		Expect(native.Write(buffer, identified)).To(Succeed())
+
+		data, err := native.Parse(buffer)
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(Equal(identified))
+	})
+
+	It("round trips times with fractions of a microsecond", func() {
+		fractional := core("fractional", 5)
+		fractional.TimestampFraction = 0.25
+		precise := []events.Event{
+			&events.Complete{EventWithArgs: events.EventWithArgs{EventCore: core("complete", 1)}, Duration: 2, DurationFraction: 0.5},
+			&events.Instant{
+				EventWithArgs: events.EventWithArgs{EventCore: fractional},
+				Scope:         events.InstantScopeThread,
+			},
+		}
+
+		buffer := &bytes.Buffer{}
+		Expect(native.Write(buffer, precise)).To(Succeed())
+
+		data, err := native.Parse(buffer)
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(Equal(precise))
+	})
+
+	It("writes typed args as the args they encode to", func() {
+		type request struct {
+			Method string `json:"method"`
+		}
+		typed := &events.BeginDuration{EventWithArgs: events.EventWithArgs{
+			EventCore: core("typed", 1),
+			TypedArgs: request{Method: "GET"},
+			Args:      map[string]interface{}{"retry": true},
+		}}
+
+		buffer := &bytes.Buffer{}
+		Expect(native.Write(buffer, []events.Event{typed})).To(Succeed())
+
+		data, err := native.Parse(buffer)
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(HaveLen(1))
+		Expect(data.Events()[0].(*events.BeginDuration).Args).To(Equal(map[string]interface{}{
+			"method": "GET", "retry": true,
+		}))
+	})
+
+	It("streams events as they are written", func() {
+		buffer := &closingBuffer{}
+		w := native.NewWriter(buffer)
+		for _, e := range all {
+			Expect(w.Write(e)).To(Succeed())
+		}
+		Expect(w.Close()).To(Succeed())
+		Expect(native.IsBinaryTrace(buffer.Bytes())).To(BeTrue())
+
+		r := native.NewReader(buffer)
+		for _, expected := range all {
+			e, err := r.Next()
+			Expect(err).To(Succeed())
+			Expect(e).To(Equal(expected))
+		}
+		_, err := r.Next()
+		Expect(err).To(MatchError(io.EOF))
+	})
+
+	It("writes a valid trace without any events", func() {
+		buffer := &closingBuffer{}
+		Expect(native.NewWriter(buffer).Close()).To(Succeed())
+
+		data, err := native.Parse(buffer)
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(BeEmpty())
+	})
+
+	It("writes repeated strings only once", func() {
+		buffer := &closingBuffer{}
+		w := native.NewWriter(buffer)
+		Expect(w.Write(all[0])).To(Succeed())
+		first := buffer.Len()
+		Expect(w.Write(all[0])).To(Succeed())
+		Expect(buffer.Len() - first).To(BeNumerically("<", first/2))
+	})
+
+	It("reads up to the last complete event of a truncated trace", func() {
+		buffer := &bytes.Buffer{}
+		Expect(native.Write(buffer, all[:3])).To(Succeed())
+		truncated := buffer.Bytes()[:buffer.Len()-5]
+
+		data, err := native.Parse(bytes.NewReader(truncated))
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(Equal(all[:2]))
+	})
+
+	It("does not forget strings of events that could not be encoded", func() {
+		unencodable := &events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "never seen"}},
+		}
+		bad := &events.MetadataMisc{EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{Name: "never seen"},
+			Args:      map[string]interface{}{"fn": func() {}},
+		}}
+
+		buffer := &closingBuffer{}
+		w := native.NewWriter(buffer)
+		Expect(w.Write(bad)).NotTo(Succeed())
+		Expect(w.Write(unencodable)).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		data, err := native.Parse(buffer)
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(Equal([]events.Event{unencodable}))
+	})
+
+	It("refuses to write link events without both of their ids", func() {
+		link := &events.LinkIds{EventWithArgs: events.EventWithArgs{EventCore: core("link", 1)}, Id: "a"}
+		err := native.Write(&bytes.Buffer{}, []events.Event{link})
+		Expect(errors.Is(err, events.ErrMissingId)).To(BeTrue())
+	})
+
+	It("rejects input that is not a binary trace", func() {
+		_, err := native.Parse(bytes.NewReader([]byte(`[{"name":"a","ph":"I","ts":1}]`)))
+		Expect(errors.Is(err, native.ErrNotBinaryTrace)).To(BeTrue())
+	})
+
+	It("rejects corrupt events", func() {
+		buffer := &bytes.Buffer{}
+		Expect(native.Write(buffer, all[:1])).To(Succeed())
+		corrupt := buffer.Bytes()
+		corrupt[len(native.Magic)+2] = 0xff
+
+		_, err := native.Parse(bytes.NewReader(corrupt))
+		Expect(errors.Is(err, native.ErrCorrupt)).To(BeTrue())
+	})
+
+	It("converts to and from JSON", func() {
+		var jsonBuffer bytes.Buffer
+		Expect(tio.WriteJsonArray(&jsonBuffer, all)).To(Succeed())
+		fromJson, err := tio.ParseJsonArray(&jsonBuffer)
+		Expect(err).To(Succeed())
+
+		buffer := &bytes.Buffer{}
+		Expect(native.Write(buffer, fromJson.Events())).To(Succeed())
+		data, err := native.Parse(buffer)
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(Equal(fromJson.Events()))
+	})
+})
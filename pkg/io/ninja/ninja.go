@@ -0,0 +1,261 @@
+// ninja provides conversion of ninja's build log, the .ninja_log file in a build directory, into trace events, so that
+// the timeline of a build can be looked at to find what held it up
+package ninja
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// Category is the category given to all events converted from ninja's build log
+const Category = "ninja"
+
+// DefaultProcessID is the ID of the process that the build is shown as, unless WithProcessID is used
+const DefaultProcessID int64 = 1
+
+// logHeader starts the first line of every ninja log, followed by its version
+const logHeader = "# ninja log v"
+
+var (
+	// ErrNotNinjaLog means that the input does not start with the header of a ninja log
+	ErrNotNinjaLog = errors.New("not a ninja log")
+	// ErrUnrecognisedLine means that a line of the input was not understood as an entry of a ninja log
+	ErrUnrecognisedLine = errors.New("unrecognised ninja log line")
+)
+
+// IsNinjaLog reports whether the given start of a file is the start of a ninja log
+func IsNinjaLog(start []byte) bool {
+	return strings.HasPrefix(string(start), logHeader)
+}
+
+// entry is a line of the log, recording an output of a command that ran during the build
+type entry struct {
+	start, end int64
+	output     string
+	// command is the hash of the command from version 5 of the log onwards, and the command itself before that
+	command string
+}
+
+// edge is a command that ran during the build, along with all of the outputs it produced
+type edge struct {
+	start, end int64
+	outputs    []string
+	command    string
+}
+
+type converter struct {
+	data      *tio.TefData
+	processID int64
+	allBuilds bool
+	// hashed is whether the log records the hashes of commands rather than the commands themselves
+	hashed bool
+}
+
+// Option allows configuring how a ninja log is converted
+type Option = func(c *converter)
+
+// WithProcessID changes the ID of the process that the build is shown as
+func WithProcessID(pid int64) Option {
+	return func(c *converter) {
+		c.processID = pid
+	}
+}
+
+// WithAllBuilds converts every build recorded in the log, rather than only the most recent one, each build being
+// shown as a process of its own with IDs counting up from the process ID, oldest first
+func WithAllBuilds() Option {
+	return func(c *converter) {
+		c.allBuilds = true
+	}
+}
+
+// Parse reads a ninja log from the provided reader, converting each command that ran into a Complete event named after
+// the first of its outputs, with all of its outputs and its command (or the hash of its command) as args. The
+// commands are placed on a thread per job slot, each command taking the lowest numbered slot free when it started, so
+// that the threads show how many jobs ran in parallel and what kept them busy.
+//
+// Ninja appends the commands run by each build to the log, the times of each build starting again from zero, so only
+// the most recent build is converted unless WithAllBuilds is used.
+func Parse(r io.Reader, options ...Option) (*tio.TefData, error) {
+	c := &converter{
+		data:      &tio.TefData{},
+		processID: DefaultProcessID,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	c.data.SetDisplayTimeUnit(tio.DisplayTimeMs)
+
+	builds, err := c.read(r)
+	if err != nil {
+		return nil, err
+	}
+	if !c.allBuilds && len(builds) > 1 {
+		builds = builds[len(builds)-1:]
+	}
+	for i, build := range builds {
+		c.convert(c.processID+int64(i), build)
+	}
+	return c.data, nil
+}
+
+// read reads the entries of the log, split into the builds that recorded them
+func (c *converter) read(r io.Reader) ([][]entry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read ninja log: %w", err)
+		}
+		return nil, ErrNotNinjaLog
+	}
+	header := strings.TrimSpace(scanner.Text())
+	if !strings.HasPrefix(header, logHeader) {
+		return nil, ErrNotNinjaLog
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(header, logHeader))
+	if err != nil {
+		return nil, fmt.Errorf("invalid version in '%s': %w", header, ErrNotNinjaLog)
+	}
+	c.hashed = version >= 5
+
+	var builds [][]entry
+	var build []entry
+	var lastEnd int64
+	for lineNumber := 2; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		e, err := parseEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		// entries are written as commands finish and each build's times start again from zero, so an entry ending
+		// before the last one did begins a new build
+		if e.end < lastEnd && len(build) > 0 {
+			builds = append(builds, build)
+			build = nil
+		}
+		build = append(build, e)
+		lastEnd = e.end
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ninja log: %w", err)
+	}
+	if len(build) > 0 {
+		builds = append(builds, build)
+	}
+	return builds, nil
+}
+
+// parseEntry parses a line of the log, which holds the start and end of the command in milliseconds since the build
+// began, the modification time of the output, the output, and the command or its hash, separated by tabs
+func parseEntry(line string) (entry, error) {
+	fields := strings.SplitN(line, "\t", 5)
+	if len(fields) != 5 {
+		return entry{}, fmt.Errorf("'%s': %w", line, ErrUnrecognisedLine)
+	}
+	start, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return entry{}, fmt.Errorf("invalid start '%s': %w", fields[0], ErrUnrecognisedLine)
+	}
+	end, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return entry{}, fmt.Errorf("invalid end '%s': %w", fields[1], ErrUnrecognisedLine)
+	}
+	return entry{start: start, end: end, output: fields[3], command: fields[4]}, nil
+}
+
+// convert writes the commands of the build as events of the given process
+func (c *converter) convert(pid int64, build []entry) {
+	edges := groupEdges(build)
+	sort.SliceStable(edges, func(i, j int) bool {
+		if edges[i].start != edges[j].start {
+			return edges[i].start < edges[j].start
+		}
+		return edges[i].end < edges[j].end
+	})
+
+	name := "ninja"
+	if c.allBuilds {
+		name = fmt.Sprintf("ninja build %d", pid-c.processID+1)
+	}
+	c.data.Write(events.NewProcessName(pid, name))
+
+	// slots holds when the command running in each job slot ends
+	var slots []int64
+	for _, e := range edges {
+		slot := -1
+		for i, end := range slots {
+			if end <= e.start {
+				slot = i
+				break
+			}
+		}
+		if slot < 0 {
+			slot = len(slots)
+			slots = append(slots, 0)
+			c.data.Write(events.NewThreadName(pid, int64(slot), fmt.Sprintf("job %d", slot)))
+		}
+		slots[slot] = e.end
+		c.data.Write(c.complete(pid, int64(slot), e))
+	}
+}
+
+// groupEdges groups the outputs produced by the same command, which ninja records as entries with the same times and
+// command
+func groupEdges(build []entry) []*edge {
+	type edgeKey struct {
+		start, end int64
+		command    string
+	}
+	var edges []*edge
+	byKey := map[edgeKey]*edge{}
+	for _, e := range build {
+		key := edgeKey{e.start, e.end, e.command}
+		if existing, ok := byKey[key]; ok {
+			existing.outputs = append(existing.outputs, e.output)
+			continue
+		}
+		grouped := &edge{start: e.start, end: e.end, outputs: []string{e.output}, command: e.command}
+		byKey[key] = grouped
+		edges = append(edges, grouped)
+	}
+	return edges
+}
+
+func (c *converter) complete(pid, tid int64, e *edge) *events.Complete {
+	outputs := make([]interface{}, 0, len(e.outputs))
+	for _, output := range e.outputs {
+		outputs = append(outputs, output)
+	}
+	commandArg := "command"
+	if c.hashed {
+		commandArg = "command_hash"
+	}
+	return &events.Complete{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{
+				Name:       e.outputs[0],
+				Categories: []string{Category},
+				Timestamp:  e.start * 1000,
+				ProcessID:  &pid,
+				ThreadID:   &tid,
+			},
+			Args: map[string]interface{}{
+				"outputs":  outputs,
+				commandArg: e.command,
+			},
+		},
+		Duration: (e.end - e.start) * 1000,
+	}
+}
@@ -0,0 +1,13 @@
+package ninja_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestNinja(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Ninja Suite")
+}
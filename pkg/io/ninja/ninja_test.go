@@ -0,0 +1,94 @@
+package ninja_test
+
+import (
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/ninja"
+)
+
+const log = "# ninja log v5\n" +
+	"0\t100\t0\tobj/old.o\taaaa\n" +
+	"5\t30\t0\tobj/b.o\t2222\n" +
+	"5\t30\t0\tobj/b.d\t2222\n" +
+	"0\t40\t0\tobj/a.o\t1111\n" +
+	"30\t60\t0\tobj/c.o\t3333\n" +
+	"60\t90\t0\tbin/app\t4444\n"
+
+var _ = Describe("Parse", func() {
+	completes := func(data *tio.TefData) []*events.Complete {
+		var result []*events.Complete
+		for _, e := range data.Events() {
+			if complete, ok := e.(*events.Complete); ok {
+				result = append(result, complete)
+			}
+		}
+		return result
+	}
+
+	It("converts the commands of the most recent build into complete events on job slots", func() {
+		data, err := ninja.Parse(strings.NewReader(log))
+		Expect(err).To(Succeed())
+
+		commands := completes(data)
+		Expect(commands).To(HaveLen(4))
+		slots := map[string]int64{}
+		for _, command := range commands {
+			slots[command.Name] = *command.ThreadID
+			Expect(*command.ProcessID).To(Equal(ninja.DefaultProcessID))
+			Expect(command.Categories).To(Equal([]string{ninja.Category}))
+		}
+		Expect(slots).To(Equal(map[string]int64{"obj/a.o": 0, "obj/b.o": 1, "obj/c.o": 1, "bin/app": 0}))
+
+		Expect(commands[0].Timestamp).To(Equal(int64(0)))
+		Expect(commands[0].Duration).To(Equal(int64(40000)))
+		Expect(commands[1].Args).To(Equal(map[string]interface{}{
+			"outputs":      []interface{}{"obj/b.o", "obj/b.d"},
+			"command_hash": "2222",
+		}))
+	})
+
+	It("names the process and its job slots", func() {
+		data, err := ninja.Parse(strings.NewReader(log))
+		Expect(err).To(Succeed())
+		var names []string
+		for _, e := range data.Events() {
+			switch m := e.(type) {
+			case *events.MetadataProcessName:
+				names = append(names, m.ProcessName)
+			case *events.MetadataThreadName:
+				names = append(names, m.ThreadName)
+			}
+		}
+		Expect(names).To(Equal([]string{"ninja", "job 0", "job 1"}))
+	})
+
+	It("converts every build when asked to", func() {
+		data, err := ninja.Parse(strings.NewReader(log), ninja.WithAllBuilds(), ninja.WithProcessID(10))
+		Expect(err).To(Succeed())
+		commands := completes(data)
+		Expect(commands).To(HaveLen(5))
+		Expect(commands[0].Name).To(Equal("obj/old.o"))
+		Expect(*commands[0].ProcessID).To(Equal(int64(10)))
+		Expect(*commands[1].ProcessID).To(Equal(int64(11)))
+	})
+
+	It("records the commands of logs from before they were hashed", func() {
+		data, err := ninja.Parse(strings.NewReader("# ninja log v4\n0\t10\t0\tout.txt\techo hi > out.txt\n"))
+		Expect(err).To(Succeed())
+		Expect(completes(data)[0].Args["command"]).To(Equal("echo hi > out.txt"))
+	})
+
+	It("rejects input that is not a ninja log", func() {
+		_, err := ninja.Parse(strings.NewReader(`{"traceEvents": []}`))
+		Expect(errors.Is(err, ninja.ErrNotNinjaLog)).To(BeTrue())
+
+		_, err = ninja.Parse(strings.NewReader("# ninja log v5\nnot an entry\n"))
+		Expect(errors.Is(err, ninja.ErrUnrecognisedLine)).To(BeTrue())
+	})
+})
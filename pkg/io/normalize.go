@@ -0,0 +1,307 @@
+package io
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// SortByTimestamp stably sorts the events by their timestamp, including its fraction of a microsecond, with metadata
+// events placed before all others
+func (td *TefData) SortByTimestamp() {
+	sort.SliceStable(td.traceEvents, func(i, j int) bool {
+		a, b := td.traceEvents[i], td.traceEvents[j]
+		aMeta, bMeta := a.Phase() == events.PhaseMetadata, b.Phase() == events.PhaseMetadata
+		if aMeta != bMeta {
+			return aMeta
+		}
+		aCore, bCore := a.Core(), b.Core()
+		return microsBefore(aCore.Timestamp, aCore.TimestampFraction, bCore.Timestamp, bCore.TimestampFraction)
+	})
+}
+
+// Normalize rewrites the trace into a simpler, equivalent form that viewers tend to handle better: matching pairs of
+// BeginDuration and EndDuration events are combined into Complete events, timestamps are shifted so that the
+// earliest event is at zero, empty args are removed and events are sorted by timestamp. Events are modified in place.
+func (td *TefData) Normalize() {
+	// pairs that cannot be combined are left as they were, which is the best normalization can do for them
+	td.traceEvents, _ = pairDurations(td.traceEvents)
+
+	var origin int64
+	var originFraction float64
+	found := false
+	for _, e := range td.traceEvents {
+		if e.Phase() == events.PhaseMetadata {
+			continue
+		}
+		if core := e.Core(); !found || microsBefore(core.Timestamp, core.TimestampFraction, origin, originFraction) {
+			origin, originFraction = core.Timestamp, core.TimestampFraction
+			found = true
+		}
+	}
+
+	for _, e := range td.traceEvents {
+		if e.Phase() != events.PhaseMetadata {
+			core := e.Core()
+			core.Timestamp, core.TimestampFraction = subtractMicros(core.Timestamp, core.TimestampFraction, origin,
+				originFraction)
+			if sync, ok := e.(*events.ClockSync); ok && sync.IssueTs != nil {
+				// issue timestamps are whole microseconds, so can only be moved by the whole microseconds of the origin
+				issueTs := *sync.IssueTs - origin
+				sync.IssueTs = &issueTs
+			}
+		}
+		if withArgs, ok := e.(interface {
+			events.ArgGetter
+			events.ArgSetter
+		}); ok && withArgs.GetArgs() != nil && len(withArgs.GetArgs()) < 1 {
+			withArgs.SetArgs(nil)
+		}
+	}
+
+	td.SortByTimestamp()
+}
+
+type threadKey struct {
+	pid int64
+	tid int64
+}
+
+func threadKeyOf(core *events.EventCore) threadKey {
+	var key threadKey
+	if core.ProcessID != nil {
+		key.pid = *core.ProcessID
+	}
+	if core.ThreadID != nil {
+		key.tid = *core.ThreadID
+	}
+	return key
+}
+
+// CompactDurations returns a copy of the trace in which each BeginDuration event with a matching EndDuration event
+// on the same thread is replaced by an equivalent Complete event, taking the place of the BeginDuration event, and
+// the EndDuration event is removed. The stack of the BeginDuration becomes the stack of the Complete event and the
+// stack of the EndDuration becomes its end stack, while args from both are combined. Events without a match are left
+// as they are. An error is returned if any EndDuration event occurs before the BeginDuration event it matches. Events
+// that are not replaced, and all other data in the trace, are shared with the original.
+func CompactDurations(data *TefData) (*TefData, error) {
+	compacted, err := pairDurations(data.Events())
+	if err != nil {
+		return nil, err
+	}
+
+	result := *data
+	result.SetEvents(compacted)
+	return &result, nil
+}
+
+// pairDurations replaces each BeginDuration event that has a matching EndDuration event on the same thread with an
+// equivalent Complete event, removing the EndDuration event. Unmatched events are left as they are, as are pairs
+// where the end precedes the beginning, the first of which is reported as an error.
+func pairDurations(evs []events.Event) ([]events.Event, error) {
+	type openDuration struct {
+		index int
+		begin *events.BeginDuration
+	}
+
+	result := make([]events.Event, len(evs))
+	copy(result, evs)
+	removed := make([]bool, len(evs))
+	open := map[threadKey][]openDuration{}
+	var err error
+
+	for i, e := range evs {
+		switch event := e.(type) {
+		case *events.BeginDuration:
+			key := threadKeyOf(&event.EventCore)
+			open[key] = append(open[key], openDuration{index: i, begin: event})
+		case *events.EndDuration:
+			key := threadKeyOf(&event.EventCore)
+			stack := open[key]
+			if len(stack) < 1 {
+				continue
+			}
+			begun := stack[len(stack)-1]
+			open[key] = stack[:len(stack)-1]
+			if ends, _ := subtractMicros(event.Timestamp, event.TimestampFraction,
+				begun.begin.Timestamp, begun.begin.TimestampFraction); ends < 0 {
+				if err == nil {
+					err = fmt.Errorf("duration '%s' ends (ts=%v) before it begins (ts=%v)",
+						begun.begin.Name, event.Timestamp, begun.begin.Timestamp)
+				}
+				continue
+			}
+			result[begun.index] = completeFromPair(begun.begin, event)
+			removed[i] = true
+		}
+	}
+
+	kept := result[:0]
+	for i, e := range result {
+		if !removed[i] {
+			kept = append(kept, e)
+		}
+	}
+	return kept, err
+}
+
+// completeFromPair creates a Complete event spanning the given BeginDuration and EndDuration events, args from both
+// are combined (with those of the end taking precedence) and the stack of the end becomes the end stack
+func completeFromPair(begin *events.BeginDuration, end *events.EndDuration) *events.Complete {
+	complete := &events.Complete{
+		EventWithArgs:   begin.EventWithArgs,
+		EventStackTrace: begin.EventStackTrace,
+		EventEndStackTrace: events.EventEndStackTrace{
+			EndStackTrace:   end.StackTrace,
+			EndStackFrameID: end.StackFrameID,
+		},
+	}
+	complete.Duration, complete.DurationFraction = subtractMicros(
+		end.Timestamp, end.TimestampFraction, begin.Timestamp, begin.TimestampFraction)
+	if len(end.Args) > 0 {
+		complete.Args = mergeDicts(begin.Args, end.Args)
+	}
+	if begin.ThreadTimestamp != nil && end.ThreadTimestamp != nil {
+		threadDuration := *end.ThreadTimestamp - *begin.ThreadTimestamp
+		complete.ThreadDuration = &threadDuration
+	}
+	return complete
+}
+
+// ExpandDurations returns a copy of the trace in which each Complete event is replaced by an equivalent pair of
+// BeginDuration and EndDuration events, the inverse of CompactDurations. The args and stack of the Complete event are
+// kept by the BeginDuration event, while its end stack becomes the stack of the EndDuration event. As the ends of
+// durations may fall anywhere in the trace the events of the result are sorted by timestamp, with ends placed before
+// other events at the same timestamp. An error is returned if any Complete event has a negative duration. Events that
+// are not replaced, and all other data in the trace, are shared with the original.
+func ExpandDurations(data *TefData) (*TefData, error) {
+	type entry struct {
+		event events.Event
+		// key orders entries: metadata first, then by timestamp, then ends of durations, innermost first, then all
+		// other events in their original order with the ends of zero length durations directly after their beginning
+		key [5]int64
+	}
+
+	metaRank := func(e events.Event) int64 {
+		if e.Phase() == events.PhaseMetadata {
+			return 0
+		}
+		return 1
+	}
+
+	entries := make([]entry, 0, len(data.Events()))
+	for i, e := range data.Events() {
+		index := int64(i)
+		complete, ok := e.(*events.Complete)
+		if !ok {
+			entries = append(entries, entry{event: e, key: [5]int64{metaRank(e), e.Core().Timestamp, 1, index, 0}})
+			continue
+		}
+		if complete.Duration < 0 {
+			return nil, fmt.Errorf("complete event '%s' (ts=%v) has a negative duration: %v",
+				complete.Name, complete.Timestamp, complete.Duration)
+		}
+
+		begin, end := expandComplete(complete)
+		entries = append(entries, entry{event: begin, key: [5]int64{1, begin.Timestamp, 1, index, 0}})
+		if complete.Duration == 0 {
+			entries = append(entries, entry{event: end, key: [5]int64{1, end.Timestamp, 1, index, 1}})
+		} else {
+			entries = append(entries, entry{event: end, key: [5]int64{1, end.Timestamp, 0, -begin.Timestamp, -index}})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].key, entries[j].key
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+
+	expanded := make([]events.Event, len(entries))
+	for i, e := range entries {
+		expanded[i] = e.event
+	}
+
+	result := *data
+	result.SetEvents(expanded)
+	return &result, nil
+}
+
+func expandComplete(complete *events.Complete) (*events.BeginDuration, *events.EndDuration) {
+	begin := &events.BeginDuration{
+		EventWithArgs:   complete.EventWithArgs,
+		EventStackTrace: complete.EventStackTrace,
+	}
+
+	end := &events.EndDuration{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{
+				Name:       complete.Name,
+				Categories: complete.Categories,
+				ProcessID:  complete.ProcessID,
+				ThreadID:   complete.ThreadID,
+			},
+		},
+		EventStackTrace: events.EventStackTrace{
+			StackTrace:   complete.EndStackTrace,
+			StackFrameID: complete.EndStackFrameID,
+		},
+	}
+	end.Timestamp, end.TimestampFraction = addMicros(
+		complete.Timestamp, complete.TimestampFraction, complete.Duration, complete.DurationFraction)
+	end.ThreadTimestamp = complete.ThreadEndTimestamp()
+
+	return begin, end
+}
+
+// microsFractionPrecision is the precision, in fractions of a microsecond, that sums and differences of times are
+// rounded to, so that adding up their fractions does not leave floating point error such as 0.49999999999999994
+const microsFractionPrecision = 1e12
+
+// addMicros adds two times in microseconds given as their whole microseconds and their fractions of a microsecond
+func addMicros(aWhole int64, aFraction float64, bWhole int64, bFraction float64) (int64, float64) {
+	return normalizeMicros(aWhole+bWhole, aFraction+bFraction)
+}
+
+// subtractMicros subtracts the second time in microseconds from the first, both given as their whole microseconds
+// and their fractions of a microsecond
+func subtractMicros(aWhole int64, aFraction float64, bWhole int64, bFraction float64) (int64, float64) {
+	return normalizeMicros(aWhole-bWhole, aFraction-bFraction)
+}
+
+// microsBefore reports whether the first time in microseconds is before the second, both given as their whole
+// microseconds and their fractions of a microsecond
+func microsBefore(aWhole int64, aFraction float64, bWhole int64, bFraction float64) bool {
+	if aWhole != bWhole {
+		return aWhole < bWhole
+	}
+	return aFraction < bFraction
+}
+
+// normalizeMicros carries a fraction of a microsecond outside of [0, 1) into the whole microseconds
+func normalizeMicros(whole int64, fraction float64) (int64, float64) {
+	fraction = math.Round(fraction*microsFractionPrecision) / microsFractionPrecision
+	carry := math.Floor(fraction)
+	return whole + int64(carry), fraction - carry
+}
+
+func mergeDicts(a, b map[string]interface{}) map[string]interface{} {
+	r := map[string]interface{}{}
+	for k, v := range a {
+		if v != nil {
+			r[k] = v
+		}
+	}
+	for k, v := range b {
+		if v != nil {
+			r[k] = v
+		}
+	}
+	return r
+}
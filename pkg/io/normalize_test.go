@@ -0,0 +1,299 @@
+package io_test
+
+import (
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("TefData", func() {
+	var data teffyio.TefData
+	pid, tid, otherTid := int64(1), int64(2), int64(3)
+
+	threadCore := func(name string, ts int64, tid *int64) events.EventCore {
+		return events.EventCore{Name: name, Timestamp: ts, ProcessID: &pid, ThreadID: tid}
+	}
+
+	BeforeEach(func() {
+		data = teffyio.TefData{}
+	})
+
+	Describe("SortByTimestamp", func() {
+		It("orders events by time after any metadata", func() {
+			data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: threadCore("b", 20, &tid)}})
+			data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: threadCore("a", 10, &tid)}})
+			data.Write(&events.MetadataThreadName{EventCore: threadCore("thread_name", 0, &tid), ThreadName: "t"})
+			data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: threadCore("c", 20, &tid)}})
+			data.SortByTimestamp()
+
+			var names []string
+			for _, e := range data.Events() {
+				names = append(names, e.Core().Name)
+			}
+			Expect(names).To(Equal([]string{"thread_name", "a", "b", "c"}))
+		})
+
+		It("orders events at the same microsecond by their fractions", func() {
+			later := threadCore("later", 5, &tid)
+			later.TimestampFraction = 0.75
+			earlier := threadCore("earlier", 5, &tid)
+			earlier.TimestampFraction = 0.25
+			data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: later}})
+			data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: earlier}})
+			data.SortByTimestamp()
+
+			Expect(data.Events()[0].Core().Name).To(Equal("earlier"))
+			Expect(data.Events()[1].Core().Name).To(Equal("later"))
+		})
+	})
+
+	Describe("Normalize with fractions of a microsecond", func() {
+		It("shifts the earliest event to zero", func() {
+			first := threadCore("first", 5, &tid)
+			first.TimestampFraction = 0.75
+			second := threadCore("second", 7, &tid)
+			second.TimestampFraction = 0.25
+			data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: second}})
+			data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: first}})
+			data.Normalize()
+
+			Expect(data.Events()[0].Core().Name).To(Equal("first"))
+			Expect(data.Events()[0].Core().Timestamp).To(BeEquivalentTo(0))
+			Expect(data.Events()[0].Core().TimestampFraction).To(BeZero())
+			Expect(data.Events()[1].Core().Timestamp).To(BeEquivalentTo(1))
+			Expect(data.Events()[1].Core().TimestampFraction).To(Equal(0.5))
+		})
+	})
+
+	Describe("Normalize", func() {
+		BeforeEach(func() {
+			data.Write(&events.BeginDuration{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: threadCore("outer", 100, &tid),
+					Args:      map[string]interface{}{"a": 1.0},
+				},
+			})
+			data.Write(&events.BeginDuration{
+				EventWithArgs: events.EventWithArgs{EventCore: threadCore("unmatched", 105, &otherTid)},
+			})
+			data.Write(&events.BeginDuration{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: threadCore("inner", 110, &tid),
+					Args:      map[string]interface{}{},
+				},
+			})
+			data.Write(&events.EndDuration{
+				EventWithArgs: events.EventWithArgs{EventCore: threadCore("", 120, &tid)},
+				EventStackTrace: events.EventStackTrace{
+					StackFrameID: "5",
+				},
+			})
+			data.Write(&events.EndDuration{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: threadCore("", 150, &tid),
+					Args:      map[string]interface{}{"b": 2.0},
+				},
+			})
+			data.Normalize()
+		})
+
+		It("combines matching durations into complete events", func() {
+			Expect(data.Events()).To(HaveLen(3))
+			Expect(data.Events()[0]).To(Equal(&events.Complete{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: threadCore("outer", 0, &tid),
+					Args:      map[string]interface{}{"a": 1.0, "b": 2.0},
+				},
+				Duration: 50,
+			}))
+			Expect(data.Events()[1]).To(Equal(&events.BeginDuration{
+				EventWithArgs: events.EventWithArgs{EventCore: threadCore("unmatched", 5, &otherTid)},
+			}))
+			Expect(data.Events()[2]).To(Equal(&events.Complete{
+				EventWithArgs: events.EventWithArgs{EventCore: threadCore("inner", 10, &tid)},
+				EventEndStackTrace: events.EventEndStackTrace{
+					EndStackFrameID: "5",
+				},
+				Duration: 10,
+			}))
+		})
+	})
+})
+
+var _ = Describe("CompactDurations", func() {
+	var data *teffyio.TefData
+	pid, tid := int64(1), int64(2)
+
+	BeforeEach(func() {
+		data = &teffyio.TefData{}
+		data.SetDisplayTimeUnit(teffyio.DisplayTimeNs)
+		data.Write(&events.BeginDuration{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "work", Timestamp: 10, ProcessID: &pid, ThreadID: &tid},
+			},
+			EventStackTrace: events.EventStackTrace{
+				StackTrace: &events.StackTrace{Trace: []*events.StackFrame{{Name: "begin"}}},
+			},
+		})
+	})
+
+	When("durations are matched", func() {
+		BeforeEach(func() {
+			data.Write(&events.EndDuration{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: events.EventCore{Name: "work", Timestamp: 30, ProcessID: &pid, ThreadID: &tid},
+				},
+				EventStackTrace: events.EventStackTrace{
+					StackTrace: &events.StackTrace{Trace: []*events.StackFrame{{Name: "end"}}},
+				},
+			})
+		})
+
+		It("replaces them with complete events preserving both stacks", func() {
+			result, err := teffyio.CompactDurations(data)
+			Expect(err).To(Succeed())
+			Expect(result.DisplayTimeUnit()).To(Equal(teffyio.DisplayTimeNs))
+			Expect(result.Events()).To(Equal([]events.Event{
+				&events.Complete{
+					EventWithArgs: events.EventWithArgs{
+						EventCore: events.EventCore{Name: "work", Timestamp: 10, ProcessID: &pid, ThreadID: &tid},
+					},
+					EventStackTrace: events.EventStackTrace{
+						StackTrace: &events.StackTrace{Trace: []*events.StackFrame{{Name: "begin"}}},
+					},
+					EventEndStackTrace: events.EventEndStackTrace{
+						EndStackTrace: &events.StackTrace{Trace: []*events.StackFrame{{Name: "end"}}},
+					},
+					Duration: 20,
+				},
+			}))
+		})
+
+		It("leaves the original trace untouched", func() {
+			_, err := teffyio.CompactDurations(data)
+			Expect(err).To(Succeed())
+			Expect(data.Events()).To(HaveLen(2))
+		})
+	})
+
+	It("keeps fractions of a microsecond in the duration", func() {
+		parsed, err := teffyio.ParseJsonArray(strings.NewReader(
+			`[{"ph": "B", "name": "work", "ts": 1.2}, {"ph": "E", "name": "work", "ts": 3.7}]`))
+		Expect(err).To(Succeed())
+		result, err := teffyio.CompactDurations(parsed)
+		Expect(err).To(Succeed())
+		Expect(result.Events()).To(HaveLen(1))
+		complete := result.Events()[0].(*events.Complete)
+		Expect(complete.Timestamp).To(BeEquivalentTo(1))
+		Expect(complete.Duration).To(BeEquivalentTo(2))
+		Expect(complete.DurationFraction).To(Equal(0.5))
+	})
+
+	When("a duration ends before it begins", func() {
+		BeforeEach(func() {
+			data.Write(&events.EndDuration{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: events.EventCore{Name: "work", Timestamp: 5, ProcessID: &pid, ThreadID: &tid},
+				},
+			})
+		})
+
+		It("returns an error", func() {
+			_, err := teffyio.CompactDurations(data)
+			Expect(err).To(MatchError(ContainSubstring("before it begins")))
+		})
+	})
+})
+
+var _ = Describe("ExpandDurations", func() {
+	var data *teffyio.TefData
+	pid, tid := int64(1), int64(2)
+
+	complete := func(name string, ts, dur int64) *events.Complete {
+		return &events.Complete{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: name, Timestamp: ts, ProcessID: &pid, ThreadID: &tid},
+			},
+			Duration: dur,
+		}
+	}
+
+	describe := func(d *teffyio.TefData) []string {
+		var result []string
+		for _, e := range d.Events() {
+			result = append(result, string(e.Phase())+":"+e.Core().Name)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		data = &teffyio.TefData{}
+	})
+
+	It("splits complete events into begin and end events in order", func() {
+		data.Write(complete("outer", 0, 30))
+		data.Write(complete("inner", 10, 20))
+		data.Write(complete("empty", 30, 0))
+		data.Write(complete("next", 30, 5))
+		result, err := teffyio.ExpandDurations(data)
+		Expect(err).To(Succeed())
+		Expect(describe(result)).To(Equal([]string{
+			"B:outer", "B:inner", "E:inner", "E:outer", "B:empty", "E:empty", "B:next", "E:next",
+		}))
+		Expect(describe(data)).To(HaveLen(4))
+	})
+
+	It("keeps args on the beginning and the end stack on the end", func() {
+		c := complete("work", 10, 5)
+		c.Args = map[string]interface{}{"a": 1.0}
+		c.EndStackFrameID = "7"
+		data.Write(c)
+		result, err := teffyio.ExpandDurations(data)
+		Expect(err).To(Succeed())
+		Expect(result.Events()).To(Equal([]events.Event{
+			&events.BeginDuration{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: events.EventCore{Name: "work", Timestamp: 10, ProcessID: &pid, ThreadID: &tid},
+					Args:      map[string]interface{}{"a": 1.0},
+				},
+			},
+			&events.EndDuration{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: events.EventCore{Name: "work", Timestamp: 15, ProcessID: &pid, ThreadID: &tid},
+				},
+				EventStackTrace: events.EventStackTrace{StackFrameID: "7"},
+			},
+		}))
+	})
+
+	It("keeps fractions of a microsecond in the end time", func() {
+		c := complete("work", 1, 2)
+		c.TimestampFraction, c.DurationFraction = 0.2, 0.5
+		data.Write(c)
+		result, err := teffyio.ExpandDurations(data)
+		Expect(err).To(Succeed())
+		end := result.Events()[1].(*events.EndDuration)
+		Expect(end.Timestamp).To(BeEquivalentTo(3))
+		Expect(end.TimestampFraction).To(Equal(0.7))
+	})
+
+	It("is the inverse of CompactDurations", func() {
+		data.Write(complete("outer", 0, 30))
+		data.Write(complete("inner", 10, 20))
+		expanded, err := teffyio.ExpandDurations(data)
+		Expect(err).To(Succeed())
+		compacted, err := teffyio.CompactDurations(expanded)
+		Expect(err).To(Succeed())
+		Expect(compacted.Events()).To(Equal(data.Events()))
+	})
+
+	It("rejects negative durations", func() {
+		data.Write(complete("broken", 10, -5))
+		_, err := teffyio.ExpandDurations(data)
+		Expect(err).To(MatchError(ContainSubstring("negative duration")))
+	})
+})
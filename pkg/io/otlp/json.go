@@ -0,0 +1,141 @@
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// The types below mirror the JSON encoding of OTLP's trace export messages, accepting both the current field names and
+// the older instrumentation library ones
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource                    resource     `json:"resource"`
+	ScopeSpans                  []scopeSpans `json:"scopeSpans"`
+	InstrumentationLibrarySpans []scopeSpans `json:"instrumentationLibrarySpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope                  scope  `json:"scope"`
+	InstrumentationLibrary scope  `json:"instrumentationLibrary"`
+	Spans                  []span `json:"spans"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type span struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId"`
+	Name              string          `json:"name"`
+	Kind              json.RawMessage `json:"kind"`
+	StartTimeUnixNano integer         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   integer         `json:"endTimeUnixNano"`
+	Attributes        []keyValue      `json:"attributes"`
+	Events            []spanEvent     `json:"events"`
+	Status            *status         `json:"status"`
+}
+
+type spanEvent struct {
+	TimeUnixNano integer    `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []keyValue `json:"attributes"`
+}
+
+type status struct {
+	Code    json.RawMessage `json:"code"`
+	Message string          `json:"message"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue"`
+	BoolValue   *bool    `json:"boolValue"`
+	IntValue    *integer `json:"intValue"`
+	DoubleValue *float64 `json:"doubleValue"`
+	BytesValue  *string  `json:"bytesValue"`
+	ArrayValue  *struct {
+		Values []anyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []keyValue `json:"values"`
+	} `json:"kvlistValue"`
+}
+
+// integer is a 64 bit integer, which the JSON encoding of protobuf writes as a string but which is accepted either way
+type integer int64
+
+func (i *integer) UnmarshalJSON(b []byte) error {
+	b = bytes.Trim(b, `"`)
+	if len(b) == 0 || string(b) == "null" {
+		return nil
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = integer(n)
+	return nil
+}
+
+// value converts the attribute value into the equivalent plain Go value
+func (v anyValue) value() interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		return int64(*v.IntValue)
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.BytesValue != nil:
+		return *v.BytesValue
+	case v.ArrayValue != nil:
+		values := make([]interface{}, 0, len(v.ArrayValue.Values))
+		for _, item := range v.ArrayValue.Values {
+			values = append(values, item.value())
+		}
+		return values
+	case v.KvlistValue != nil:
+		return attributes(v.KvlistValue.Values)
+	}
+	return nil
+}
+
+// attributes converts a list of attributes into a map of plain Go values
+func attributes(kvs []keyValue) map[string]interface{} {
+	result := make(map[string]interface{}, len(kvs))
+	for _, kv := range kvs {
+		result[kv.Key] = kv.Value.value()
+	}
+	return result
+}
+
+// enumName gives the name of a protobuf enum value, which the JSON encoding may give as its number or its name
+func enumName(raw json.RawMessage, names []string) string {
+	var name string
+	if json.Unmarshal(raw, &name) == nil {
+		return name
+	}
+	var n int
+	if json.Unmarshal(raw, &n) == nil && n >= 0 && n < len(names) {
+		return names[n]
+	}
+	return ""
+}
@@ -0,0 +1,263 @@
+// otlp provides conversion of OpenTelemetry traces, exported as OTLP JSON, into trace events
+package otlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/omaskery/teffy/internal/lanes"
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+const (
+	// Category is the category given to spans whose instrumentation scope has no name
+	Category = "otlp"
+	// UnknownService is the name OpenTelemetry gives to services that have not named themselves
+	UnknownService = "unknown_service"
+	// ServiceNameAttribute is the resource attribute naming the service that recorded spans
+	ServiceNameAttribute = "service.name"
+)
+
+var (
+	spanKinds   = []string{"SPAN_KIND_UNSPECIFIED", "SPAN_KIND_INTERNAL", "SPAN_KIND_SERVER", "SPAN_KIND_CLIENT", "SPAN_KIND_PRODUCER", "SPAN_KIND_CONSUMER"}
+	statusCodes = []string{"STATUS_CODE_UNSET", "STATUS_CODE_OK", "STATUS_CODE_ERROR"}
+)
+
+type converter struct {
+	async bool
+}
+
+// Option allows configuring how spans are converted
+type Option = func(c *converter)
+
+// WithAsyncEvents converts spans into pairs of AsyncBegin and AsyncEnd events, identified by their span and trace
+// IDs, rather than into Complete events. Spans no longer need arranging into lanes, but as flow events only bind to
+// events on threads, the parent of each span is recorded only by its parentSpanId arg.
+func WithAsyncEvents() Option {
+	return func(c *converter) {
+		c.async = true
+	}
+}
+
+// spanInfo holds a span along with where it has been placed in the trace
+type spanInfo struct {
+	span     span
+	category string
+	pid      int64
+	tid      int64
+	start    int64
+	end      int64
+}
+
+// Parse reads an OTLP JSON trace export, as written by the OpenTelemetry collector's file exporter or sent to its
+// HTTP receiver, from the provided reader. Each service becomes a process, named after the service, and each span
+// becomes a Complete event whose args hold the span's attributes, IDs, kind and status, with the span's category
+// being the name of its instrumentation scope. As the spans of a service can overlap without nesting, they are
+// arranged into as few lanes as possible, each lane being a thread of the process. The parent of each span is
+// linked to it by a pair of flow events, and the events recorded during spans become Instant events.
+func Parse(r io.Reader, options ...Option) (*tio.TefData, error) {
+	c := &converter{}
+	for _, option := range options {
+		option(c)
+	}
+
+	var request exportRequest
+	if err := json.NewDecoder(r).Decode(&request); err != nil {
+		return nil, fmt.Errorf("failed to decode OTLP JSON: %w", err)
+	}
+
+	data := &tio.TefData{}
+	data.SetDisplayTimeUnit(tio.DisplayTimeMs)
+
+	// services with the same name are treated as the same process, processes being numbered in order of appearance
+	pids := map[string]int64{}
+	var byProcess [][]*spanInfo
+	for _, rs := range request.ResourceSpans {
+		service, _ := attributes(rs.Resource.Attributes)[ServiceNameAttribute].(string)
+		if service == "" {
+			service = UnknownService
+		}
+		pid, ok := pids[service]
+		if !ok {
+			pid = int64(len(pids) + 1)
+			pids[service] = pid
+			byProcess = append(byProcess, nil)
+			data.Write(events.NewProcessName(pid, service))
+		}
+
+		for _, ss := range append(rs.ScopeSpans, rs.InstrumentationLibrarySpans...) {
+			category := ss.Scope.Name
+			if category == "" {
+				category = ss.InstrumentationLibrary.Name
+			}
+			if category == "" {
+				category = Category
+			}
+			for _, s := range ss.Spans {
+				byProcess[pid-1] = append(byProcess[pid-1], &spanInfo{
+					span:     s,
+					category: category,
+					pid:      pid,
+					start:    int64(s.StartTimeUnixNano) / 1000,
+					end:      int64(s.EndTimeUnixNano) / 1000,
+				})
+			}
+		}
+	}
+
+	spans := map[string]*spanInfo{}
+	for _, infos := range byProcess {
+		sort.SliceStable(infos, func(i, j int) bool {
+			if infos[i].start != infos[j].start {
+				return infos[i].start < infos[j].start
+			}
+			return infos[i].end > infos[j].end
+		})
+		if !c.async {
+			assignLanes(infos)
+		}
+		for _, info := range infos {
+			spans[info.span.TraceID+"/"+info.span.SpanID] = info
+			c.writeSpan(data, info)
+		}
+	}
+
+	if !c.async {
+		for _, infos := range byProcess {
+			for _, info := range infos {
+				if parent, ok := spans[info.span.TraceID+"/"+info.span.ParentSpanID]; ok {
+					writeFlow(data, parent, info)
+				}
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// assignLanes gives each span, which must be sorted by start time, a thread ID such that the spans of each thread
+// nest within each other as duration events must
+func assignLanes(infos []*spanInfo) {
+	intervals := make([]lanes.Interval, 0, len(infos))
+	for _, info := range infos {
+		intervals = append(intervals, lanes.Interval{Start: info.start, End: info.end})
+	}
+	for i, lane := range lanes.Assign(intervals) {
+		infos[i].tid = int64(lane + 1)
+	}
+}
+
+func (c *converter) writeSpan(data *tio.TefData, info *spanInfo) {
+	s := info.span
+	args := attributes(s.Attributes)
+	args["traceId"] = s.TraceID
+	args["spanId"] = s.SpanID
+	if s.ParentSpanID != "" {
+		args["parentSpanId"] = s.ParentSpanID
+	}
+	if kind := enumName(s.Kind, spanKinds); kind != "" {
+		args["kind"] = kind
+	}
+	if s.Status != nil {
+		if code := enumName(s.Status.Code, statusCodes); code != "" {
+			args["status"] = code
+		}
+		if s.Status.Message != "" {
+			args["statusMessage"] = s.Status.Message
+		}
+	}
+
+	core := events.EventCore{
+		Name:       s.Name,
+		Categories: []string{info.category},
+		Timestamp:  info.start,
+		ProcessID:  &info.pid,
+	}
+
+	if c.async {
+		data.Write(&events.AsyncBegin{
+			EventWithArgs: events.EventWithArgs{EventCore: core, Args: args},
+			Id:            s.SpanID,
+			Scope:         s.TraceID,
+		})
+		for _, e := range s.Events {
+			data.Write(&events.AsyncInstant{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: events.EventCore{
+						Name:       e.Name,
+						Categories: core.Categories,
+						Timestamp:  int64(e.TimeUnixNano) / 1000,
+						ProcessID:  &info.pid,
+					},
+					Args: attributes(e.Attributes),
+				},
+				Id:    s.SpanID,
+				Scope: s.TraceID,
+			})
+		}
+		end := core
+		end.Timestamp = info.end
+		data.Write(&events.AsyncEnd{EventWithArgs: events.EventWithArgs{EventCore: end}, Id: s.SpanID, Scope: s.TraceID})
+		return
+	}
+
+	core.ThreadID = &info.tid
+	data.Write(&events.Complete{
+		EventWithArgs: events.EventWithArgs{EventCore: core, Args: args},
+		Duration:      info.end - info.start,
+	})
+	for _, e := range s.Events {
+		instant := &events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{
+				Name:       e.Name,
+				Categories: core.Categories,
+				Timestamp:  int64(e.TimeUnixNano) / 1000,
+				ProcessID:  &info.pid,
+				ThreadID:   &info.tid,
+			}},
+			Scope: events.InstantScopeThread,
+		}
+		if len(e.Attributes) > 0 {
+			instant.Args = attributes(e.Attributes)
+		}
+		data.Write(instant)
+	}
+}
+
+// writeFlow links a span to its parent, starting the flow within the parent at the time the child started
+func writeFlow(data *tio.TefData, parent, child *spanInfo) {
+	ts := child.start
+	if ts < parent.start {
+		ts = parent.start
+	}
+	if ts > parent.end {
+		ts = parent.end
+	}
+	categories := []string{child.category}
+	data.Write(&events.FlowStart{
+		EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{
+			Name:       child.span.Name,
+			Categories: categories,
+			Timestamp:  ts,
+			ProcessID:  &parent.pid,
+			ThreadID:   &parent.tid,
+		}},
+		Id:    child.span.SpanID,
+		Scope: child.span.TraceID,
+	})
+	data.Write(&events.FlowFinish{
+		EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{
+			Name:       child.span.Name,
+			Categories: categories,
+			Timestamp:  child.start,
+			ProcessID:  &child.pid,
+			ThreadID:   &child.tid,
+		}},
+		Id:           child.span.SpanID,
+		Scope:        child.span.TraceID,
+		BindingPoint: events.BindingPointEnclosing,
+	})
+}
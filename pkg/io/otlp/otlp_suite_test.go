@@ -0,0 +1,13 @@
+package otlp_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestOtlp(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Otlp Suite")
+}
@@ -0,0 +1,164 @@
+package otlp_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/otlp"
+)
+
+const export = `{"resourceSpans": [
+  {
+    "resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "frontend"}}]},
+    "scopeSpans": [{"scope": {"name": "http"}, "spans": [
+      {"traceId": "aa", "spanId": "01", "name": "GET /", "kind": 2,
+       "startTimeUnixNano": "1000000", "endTimeUnixNano": "9000000",
+       "attributes": [
+         {"key": "http.status_code", "value": {"intValue": "200"}},
+         {"key": "tags", "value": {"arrayValue": {"values": [{"stringValue": "a"}, {"boolValue": true}]}}}
+       ],
+       "status": {"code": "STATUS_CODE_OK"},
+       "events": [{"timeUnixNano": "1500000", "name": "cache miss", "attributes": [{"key": "ratio", "value": {"doubleValue": 0.5}}]}]},
+      {"traceId": "aa", "spanId": "02", "parentSpanId": "01", "name": "render",
+       "startTimeUnixNano": "2000000", "endTimeUnixNano": "5000000"},
+      {"traceId": "bb", "spanId": "03", "name": "GET /other",
+       "startTimeUnixNano": "3000000", "endTimeUnixNano": "7000000"}
+    ]}]
+  },
+  {
+    "resource": {"attributes": []},
+    "instrumentationLibrarySpans": [{"instrumentationLibrary": {"name": "db"}, "spans": [
+      {"traceId": "aa", "spanId": "04", "parentSpanId": "02", "name": "query",
+       "startTimeUnixNano": 2500000, "endTimeUnixNano": 4000000, "status": {"code": 2, "message": "timeout"}}
+    ]}]
+  }
+]}`
+
+var _ = Describe("Parse", func() {
+	var data *tio.TefData
+
+	BeforeEach(func() {
+		var err error
+		data, err = otlp.Parse(strings.NewReader(export))
+		Expect(err).To(Succeed())
+	})
+
+	completes := func() map[string]*events.Complete {
+		result := map[string]*events.Complete{}
+		for _, e := range data.Events() {
+			if c, ok := e.(*events.Complete); ok {
+				result[c.Name] = c
+			}
+		}
+		return result
+	}
+
+	It("converts each span to a complete event", func() {
+		spans := completes()
+		Expect(spans).To(HaveLen(4))
+
+		get := spans["GET /"]
+		Expect(get.Categories).To(Equal([]string{"http"}))
+		Expect(get.Timestamp).To(Equal(int64(1000)))
+		Expect(get.Duration).To(Equal(int64(8000)))
+		Expect(*get.ProcessID).To(Equal(int64(1)))
+		Expect(get.Args).To(Equal(map[string]interface{}{
+			"http.status_code": int64(200),
+			"tags":             []interface{}{"a", true},
+			"traceId":          "aa",
+			"spanId":           "01",
+			"kind":             "SPAN_KIND_SERVER",
+			"status":           "STATUS_CODE_OK",
+		}))
+
+		query := spans["query"]
+		Expect(query.Categories).To(Equal([]string{"db"}))
+		Expect(*query.ProcessID).To(Equal(int64(2)))
+		Expect(query.Args).To(HaveKeyWithValue("parentSpanId", "02"))
+		Expect(query.Args).To(HaveKeyWithValue("status", "STATUS_CODE_ERROR"))
+		Expect(query.Args).To(HaveKeyWithValue("statusMessage", "timeout"))
+	})
+
+	It("arranges overlapping spans into lanes that nest", func() {
+		spans := completes()
+		Expect(*spans["GET /"].ThreadID).To(Equal(int64(1)))
+		Expect(*spans["render"].ThreadID).To(Equal(int64(1)))
+		Expect(*spans["GET /other"].ThreadID).To(Equal(int64(2)))
+	})
+
+	It("names processes after their services", func() {
+		processes := data.Processes()
+		Expect(processes).To(HaveLen(2))
+		Expect(processes[0].Name).To(Equal("frontend"))
+		Expect(processes[1].Name).To(Equal(otlp.UnknownService))
+	})
+
+	It("links spans to their parents with flow events", func() {
+		var starts []*events.FlowStart
+		var finishes []*events.FlowFinish
+		for _, e := range data.Events() {
+			switch f := e.(type) {
+			case *events.FlowStart:
+				starts = append(starts, f)
+			case *events.FlowFinish:
+				finishes = append(finishes, f)
+			}
+		}
+		Expect(starts).To(HaveLen(2))
+		Expect(finishes).To(HaveLen(2))
+
+		Expect(starts[0].Id).To(Equal("02"))
+		Expect(starts[0].Scope).To(Equal("aa"))
+		Expect(starts[0].Timestamp).To(Equal(int64(2000)))
+		Expect(*starts[0].ThreadID).To(Equal(int64(1)))
+
+		Expect(starts[1].Id).To(Equal("04"))
+		Expect(*starts[1].ProcessID).To(Equal(int64(1)))
+		Expect(*finishes[1].ProcessID).To(Equal(int64(2)))
+		Expect(finishes[1].Timestamp).To(Equal(int64(2500)))
+	})
+
+	It("converts span events to instants", func() {
+		for _, e := range data.Events() {
+			if i, ok := e.(*events.Instant); ok {
+				Expect(i.Name).To(Equal("cache miss"))
+				Expect(i.Timestamp).To(Equal(int64(1500)))
+				Expect(i.Args).To(Equal(map[string]interface{}{"ratio": 0.5}))
+				return
+			}
+		}
+		Fail("no instant event")
+	})
+
+	It("can convert spans to async events instead", func() {
+		data, err := otlp.Parse(strings.NewReader(export), otlp.WithAsyncEvents())
+		Expect(err).To(Succeed())
+
+		var phases []events.Phase
+		for _, e := range data.Events() {
+			switch a := e.(type) {
+			case *events.AsyncBegin:
+				Expect(a.Scope).To(Equal(a.Args["traceId"]))
+				Expect(a.Id).To(Equal(a.Args["spanId"]))
+				Expect(a.ThreadID).To(BeNil())
+			case *events.FlowStart, *events.Complete:
+				Fail("unexpected event")
+			}
+			phases = append(phases, e.Phase())
+		}
+		Expect(phases).To(ContainElement(events.PhaseAsyncInstant))
+		Expect(phases).To(ContainElement(events.PhaseAsyncEnd))
+	})
+
+	It("rejects input that is not JSON", func() {
+		_, err := otlp.Parse(strings.NewReader("not json"))
+		var syntax *json.SyntaxError
+		Expect(errors.As(err, &syntax)).To(BeTrue())
+	})
+})
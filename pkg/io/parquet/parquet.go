@@ -0,0 +1,400 @@
+// parquet provides writing of trace events as Parquet files, so that large traces can be queried with tools such as
+// DuckDB or Spark. Each event is a row, with typed columns for the fields common to events and the args of the event
+// encoded as JSON.
+//
+// The files are written without compression or dictionary encoding, each column of a row group being a single page of
+// plainly encoded values, which keeps the writer free of dependencies while remaining readable by any Parquet reader.
+package parquet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/omaskery/teffy/internal/jsonfields"
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// Magic starts and ends every Parquet file
+const Magic = "PAR1"
+
+// DefaultRowGroupSize is the number of events written in each row group unless configured otherwise
+const DefaultRowGroupSize = 65536
+
+// ErrFinished means that an event was written after the file was completed by closing the writer
+var ErrFinished = errors.New("parquet file already finished")
+
+// createdBy identifies teffy as the writer of the files in their metadata
+const createdBy = "teffy"
+
+// The values of the enumerations of Parquet's metadata that teffy uses
+const (
+	typeInt64     int32 = 2
+	typeByteArray int32 = 6
+
+	repetitionRequired int32 = 0
+	repetitionOptional int32 = 1
+
+	convertedUtf8 int32 = 0
+
+	encodingPlain int32 = 0
+	encodingRle   int32 = 3
+
+	codecUncompressed int32 = 0
+	pageTypeData      int32 = 0
+)
+
+// column is the name and type of one of the columns events are written to
+type column struct {
+	name     string
+	typ      int32
+	optional bool
+}
+
+// columns are the columns of the files written, in order: the phase, name and categories of each event, its
+// timestamps and durations in microseconds, its process and thread, its ID and its args as JSON
+var columns = []column{
+	{name: "ph", typ: typeByteArray},
+	{name: "name", typ: typeByteArray},
+	{name: "cat", typ: typeByteArray, optional: true},
+	{name: "ts", typ: typeInt64},
+	{name: "dur", typ: typeInt64, optional: true},
+	{name: "tts", typ: typeInt64, optional: true},
+	{name: "tdur", typ: typeInt64, optional: true},
+	{name: "pid", typ: typeInt64, optional: true},
+	{name: "tid", typ: typeInt64, optional: true},
+	{name: "id", typ: typeByteArray, optional: true},
+	{name: "args", typ: typeByteArray, optional: true},
+}
+
+// chunk accumulates the values of a column for the row group being written
+type chunk struct {
+	// defined records, for optional columns, whether each row has a value
+	defined []bool
+	// values holds the plainly encoded values of the rows that have one
+	values []byte
+}
+
+func (c *chunk) int64(v *int64, optional bool) {
+	if optional {
+		c.defined = append(c.defined, v != nil)
+	}
+	if v != nil {
+		c.values = binary.LittleEndian.AppendUint64(c.values, uint64(*v))
+	}
+}
+
+func (c *chunk) bytes(v []byte, present, optional bool) {
+	if optional {
+		c.defined = append(c.defined, present)
+	}
+	if present {
+		c.values = binary.LittleEndian.AppendUint32(c.values, uint32(len(v)))
+		c.values = append(c.values, v...)
+	}
+}
+
+type writer struct {
+	w            io.Writer
+	closer       io.Closer
+	rowGroupSize int
+	chunks       []chunk
+	rows         int
+	totalRows    int64
+	rowGroups    []*message
+	offset       int64
+	finished     bool
+	err          error
+}
+
+// Option allows configuring how events are written
+type Option = func(w *writer)
+
+// WithRowGroupSize sets how many events are written in each row group, larger row groups being quicker to scan at the
+// cost of holding more events in memory while writing
+func WithRowGroupSize(rows int) Option {
+	return func(w *writer) {
+		if rows > 0 {
+			w.rowGroupSize = rows
+		}
+	}
+}
+
+func newWriter(w io.Writer, options ...Option) *writer {
+	pw := &writer{
+		w:            w,
+		rowGroupSize: DefaultRowGroupSize,
+		chunks:       make([]chunk, len(columns)),
+	}
+	for _, option := range options {
+		option(pw)
+	}
+	return pw
+}
+
+// NewWriter creates an event writer that writes events to the underlying writer as a Parquet file, a row group at a
+// time. The file is only complete, and readable, once the writer has been closed.
+func NewWriter(w io.WriteCloser, options ...Option) tio.EventWriter {
+	pw := newWriter(bufio.NewWriter(w), options...)
+	pw.closer = w
+	return pw
+}
+
+// Write writes all of the given events to the provided writer as a Parquet file
+func Write(w io.Writer, evs []events.Event, options ...Option) error {
+	bw := bufio.NewWriter(w)
+	pw := newWriter(bw, options...)
+	for _, e := range evs {
+		if err := pw.Write(e); err != nil {
+			return err
+		}
+	}
+	if err := pw.finish(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write events: %w", err)
+	}
+	return nil
+}
+
+// Write adds the event to the row group being written, writing the row group out once it is full
+func (w *writer) Write(e events.Event) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.finished {
+		return ErrFinished
+	}
+	if err := w.row(e); err != nil {
+		return err
+	}
+	if w.rows >= w.rowGroupSize {
+		w.err = w.flushRowGroup()
+	}
+	return w.err
+}
+
+// Close writes out the last row group and the metadata of the file, then closes the underlying writer
+func (w *writer) Close() error {
+	err := w.finish()
+	if err == nil {
+		if flusher, ok := w.w.(*bufio.Writer); ok {
+			if flushErr := flusher.Flush(); flushErr != nil {
+				err = fmt.Errorf("failed to write events: %w", flushErr)
+			}
+		}
+	}
+	if closeErr := w.closer.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close underlying writer: %w", closeErr)
+	}
+	return err
+}
+
+// row appends the values of the event's columns to the row group being written
+func (w *writer) row(e events.Event) error {
+	raw, err := events.MarshalEvent(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	fields, err := jsonfields.Split(raw)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	core := e.Core()
+	var duration, threadDuration *int64
+	if complete, ok := e.(*events.Complete); ok {
+		duration = &complete.Duration
+		threadDuration = complete.ThreadDuration
+	}
+	id, hasId := fields["id"]
+	if hasId {
+		// IDs may be strings or numbers, both being written as the text of the ID
+		var s string
+		if json.Unmarshal(id, &s) == nil {
+			id = json.RawMessage(s)
+		}
+	}
+	args, hasArgs := fields["args"]
+
+	w.chunks[0].bytes([]byte(e.Phase()), true, false)
+	w.chunks[1].bytes([]byte(core.Name), true, false)
+	w.chunks[2].bytes([]byte(strings.Join(core.Categories, ",")), len(core.Categories) > 0, true)
+	w.chunks[3].int64(&core.Timestamp, false)
+	w.chunks[4].int64(duration, true)
+	w.chunks[5].int64(core.ThreadTimestamp, true)
+	w.chunks[6].int64(threadDuration, true)
+	w.chunks[7].int64(core.ProcessID, true)
+	w.chunks[8].int64(core.ThreadID, true)
+	w.chunks[9].bytes(id, hasId, true)
+	w.chunks[10].bytes(args, hasArgs, true)
+	w.rows++
+	return nil
+}
+
+// flushRowGroup writes out the row group being written, each of its columns as a single data page
+func (w *writer) flushRowGroup() error {
+	if w.rows < 1 {
+		return nil
+	}
+	if err := w.start(); err != nil {
+		return err
+	}
+
+	rowGroup := &message{}
+	chunks := make([]*message, 0, len(columns))
+	var totalSize int64
+	for i, col := range columns {
+		page, err := w.page(col, &w.chunks[i])
+		if err != nil {
+			return err
+		}
+		offset := w.offset
+		if err := w.write(page); err != nil {
+			return err
+		}
+		totalSize += int64(len(page))
+
+		meta := &message{}
+		meta.i32Field(1, col.typ)
+		meta.i32ListField(2, encodingPlain, encodingRle)
+		meta.stringListField(3, col.name)
+		meta.i32Field(4, codecUncompressed)
+		meta.i64Field(5, int64(w.rows))
+		meta.i64Field(6, int64(len(page)))
+		meta.i64Field(7, int64(len(page)))
+		meta.i64Field(9, offset)
+
+		columnChunk := &message{}
+		columnChunk.i64Field(2, offset)
+		columnChunk.structField(3, meta)
+		chunks = append(chunks, columnChunk)
+
+		w.chunks[i] = chunk{defined: w.chunks[i].defined[:0], values: w.chunks[i].values[:0]}
+	}
+	rowGroup.structListField(1, chunks)
+	rowGroup.i64Field(2, totalSize)
+	rowGroup.i64Field(3, int64(w.rows))
+
+	w.rowGroups = append(w.rowGroups, rowGroup)
+	w.totalRows += int64(w.rows)
+	w.rows = 0
+	return nil
+}
+
+// page encodes the values of a column chunk as a data page, preceded by its header
+func (w *writer) page(col column, c *chunk) ([]byte, error) {
+	var body []byte
+	if col.optional {
+		levels := definitionLevels(c.defined)
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(levels)))
+		body = append(body, levels...)
+	}
+	body = append(body, c.values...)
+	if len(body) > math.MaxInt32 {
+		return nil, fmt.Errorf("column '%s' is too large for a single page, use a smaller row group size", col.name)
+	}
+
+	dataPage := &message{}
+	dataPage.i32Field(1, int32(w.rows))
+	dataPage.i32Field(2, encodingPlain)
+	dataPage.i32Field(3, encodingRle)
+	dataPage.i32Field(4, encodingRle)
+
+	header := &message{}
+	header.i32Field(1, pageTypeData)
+	header.i32Field(2, int32(len(body)))
+	header.i32Field(3, int32(len(body)))
+	header.structField(5, dataPage)
+
+	return append(header.bytes(), body...), nil
+}
+
+// definitionLevels encodes whether each row of an optional column has a value as runs of Parquet's RLE encoding,
+// with a bit width of one
+func definitionLevels(defined []bool) []byte {
+	var levels []byte
+	for i := 0; i < len(defined); {
+		run := 1
+		for i+run < len(defined) && defined[i+run] == defined[i] {
+			run++
+		}
+		levels = binary.AppendUvarint(levels, uint64(run)<<1)
+		if defined[i] {
+			levels = append(levels, 1)
+		} else {
+			levels = append(levels, 0)
+		}
+		i += run
+	}
+	return levels
+}
+
+// start writes the magic that begins the file, if it has not been written yet
+func (w *writer) start() error {
+	if w.offset > 0 {
+		return nil
+	}
+	return w.write([]byte(Magic))
+}
+
+// finish writes out the last row group and the metadata of the file, followed by its length and the closing magic
+func (w *writer) finish() error {
+	if w.err != nil || w.finished {
+		return w.err
+	}
+	w.err = w.flushRowGroup()
+	if w.err == nil {
+		w.err = w.start()
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	schema := []*message{{}}
+	schema[0].stringField(4, "schema")
+	schema[0].i32Field(5, int32(len(columns)))
+	for _, col := range columns {
+		element := &message{}
+		element.i32Field(1, col.typ)
+		if col.optional {
+			element.i32Field(3, repetitionOptional)
+		} else {
+			element.i32Field(3, repetitionRequired)
+		}
+		element.stringField(4, col.name)
+		if col.typ == typeByteArray {
+			element.i32Field(6, convertedUtf8)
+		}
+		schema = append(schema, element)
+	}
+
+	metadata := &message{}
+	metadata.i32Field(1, 1)
+	metadata.structListField(2, schema)
+	metadata.i64Field(3, w.totalRows)
+	metadata.structListField(4, w.rowGroups)
+	metadata.stringField(6, createdBy)
+
+	footer := metadata.bytes()
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, Magic...)
+	w.finished = true
+	return w.write(footer)
+}
+
+func (w *writer) write(b []byte) error {
+	if _, err := w.w.Write(b); err != nil {
+		w.err = fmt.Errorf("failed to write events: %w", err)
+		return w.err
+	}
+	w.offset += int64(len(b))
+	return nil
+}
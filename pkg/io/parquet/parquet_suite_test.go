@@ -0,0 +1,13 @@
+package parquet_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestParquet(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Parquet Suite")
+}
@@ -0,0 +1,255 @@
+package parquet_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/io/parquet"
+)
+
+type closingBuffer struct {
+	bytes.Buffer
+}
+
+func (b *closingBuffer) Close() error {
+	return nil
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+// thriftStruct is a struct decoded from Thrift's compact protocol, holding its fields by ID
+type thriftStruct map[int16]interface{}
+
+// decodeStruct decodes a struct in Thrift's compact protocol from the start of the given bytes, returning the rest
+func decodeStruct(b []byte) (thriftStruct, []byte) {
+	s := thriftStruct{}
+	var last int16
+	for {
+		header := b[0]
+		b = b[1:]
+		if header == 0 {
+			return s, b
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			v, n := binary.Uvarint(b)
+			id, b = int16(unzigzag(v)), b[n:]
+		}
+		last = id
+		s[id], b = decodeValue(header&0x0f, b)
+	}
+}
+
+func decodeValue(t byte, b []byte) (interface{}, []byte) {
+	switch t {
+	case 5, 6:
+		v, n := binary.Uvarint(b)
+		return unzigzag(v), b[n:]
+	case 8:
+		length, n := binary.Uvarint(b)
+		b = b[n:]
+		return string(b[:length]), b[length:]
+	case 9:
+		size, elem := int(b[0]>>4), b[0]&0x0f
+		b = b[1:]
+		if size == 15 {
+			v, n := binary.Uvarint(b)
+			size, b = int(v), b[n:]
+		}
+		var list []interface{}
+		for i := 0; i < size; i++ {
+			var v interface{}
+			v, b = decodeValue(elem, b)
+			list = append(list, v)
+		}
+		return list, b
+	case 12:
+		return decodeStruct(b)
+	}
+	panic("unexpected thrift type")
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// footer decodes the metadata at the end of a Parquet file
+func footer(file []byte) thriftStruct {
+	ExpectWithOffset(1, string(file[:4])).To(Equal(parquet.Magic))
+	ExpectWithOffset(1, string(file[len(file)-4:])).To(Equal(parquet.Magic))
+	length := binary.LittleEndian.Uint32(file[len(file)-8:])
+	metadata, rest := decodeStruct(file[len(file)-8-int(length) : len(file)-8])
+	ExpectWithOffset(1, rest).To(BeEmpty())
+	return metadata
+}
+
+// columnValues decodes the values of the named column in each row group of a Parquet file, nil standing for rows
+// without a value
+func columnValues(file []byte, name string) []interface{} {
+	metadata := footer(file)
+	var values []interface{}
+	for _, rowGroup := range metadata[4].([]interface{}) {
+		for _, columnChunk := range rowGroup.(thriftStruct)[1].([]interface{}) {
+			meta := columnChunk.(thriftStruct)[3].(thriftStruct)
+			if meta[3].([]interface{})[0] != name {
+				continue
+			}
+			header, body := decodeStruct(file[meta[9].(int64):])
+			rows := int(header[5].(thriftStruct)[1].(int64))
+			body = body[:header[2].(int64)]
+
+			defined := make([]bool, rows)
+			for i := range defined {
+				defined[i] = true
+			}
+			if name != "ph" && name != "name" && name != "ts" {
+				length := binary.LittleEndian.Uint32(body)
+				levels := body[4 : 4+length]
+				body = body[4+length:]
+				defined = defined[:0]
+				for len(levels) > 0 {
+					run, n := binary.Uvarint(levels)
+					for i := uint64(0); i < run>>1; i++ {
+						defined = append(defined, levels[n] == 1)
+					}
+					levels = levels[n+1:]
+				}
+			}
+
+			for _, ok := range defined {
+				switch {
+				case !ok:
+					values = append(values, nil)
+				case meta[1].(int64) == 2:
+					values = append(values, int64(binary.LittleEndian.Uint64(body)))
+					body = body[8:]
+				default:
+					length := binary.LittleEndian.Uint32(body)
+					values = append(values, string(body[4:4+length]))
+					body = body[4+length:]
+				}
+			}
+		}
+	}
+	return values
+}
+
+var _ = Describe("Parquet", func() {
+	evs := []events.Event{
+		events.NewProcessName(1, "server"),
+		&events.Complete{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{
+					Name:            "request",
+					Categories:      []string{"http", "db"},
+					Timestamp:       100,
+					ThreadTimestamp: int64Ptr(50),
+					ProcessID:       int64Ptr(1),
+					ThreadID:        int64Ptr(2),
+				},
+				Args: map[string]interface{}{"path": "/"},
+			},
+			Duration:       25,
+			ThreadDuration: int64Ptr(10),
+		},
+		&events.AsyncBegin{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "job", Categories: []string{"jobs"}, Timestamp: 110},
+			},
+			Id: "0x1f",
+		},
+		&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "tick", Timestamp: 120}},
+			Scope:         events.InstantScopeGlobal,
+		},
+	}
+
+	It("writes each event as a row of typed columns", func() {
+		var buf bytes.Buffer
+		Expect(parquet.Write(&buf, evs)).To(Succeed())
+		file := buf.Bytes()
+
+		metadata := footer(file)
+		Expect(metadata[3]).To(Equal(int64(len(evs))))
+		var names []interface{}
+		for _, element := range metadata[2].([]interface{}) {
+			names = append(names, element.(thriftStruct)[4])
+		}
+		Expect(names).To(Equal([]interface{}{
+			"schema", "ph", "name", "cat", "ts", "dur", "tts", "tdur", "pid", "tid", "id", "args",
+		}))
+
+		Expect(columnValues(file, "ph")).To(Equal([]interface{}{"M", "X", "b", "I"}))
+		Expect(columnValues(file, "name")).To(Equal([]interface{}{"process_name", "request", "job", "tick"}))
+		Expect(columnValues(file, "cat")).To(Equal([]interface{}{nil, "http,db", "jobs", nil}))
+		Expect(columnValues(file, "ts")).To(Equal([]interface{}{int64(0), int64(100), int64(110), int64(120)}))
+		Expect(columnValues(file, "dur")).To(Equal([]interface{}{nil, int64(25), nil, nil}))
+		Expect(columnValues(file, "tts")).To(Equal([]interface{}{nil, int64(50), nil, nil}))
+		Expect(columnValues(file, "tdur")).To(Equal([]interface{}{nil, int64(10), nil, nil}))
+		Expect(columnValues(file, "pid")).To(Equal([]interface{}{int64(1), int64(1), nil, nil}))
+		Expect(columnValues(file, "tid")).To(Equal([]interface{}{nil, int64(2), nil, nil}))
+		Expect(columnValues(file, "id")).To(Equal([]interface{}{nil, nil, "0x1f", nil}))
+
+		args := columnValues(file, "args")
+		Expect(args[0]).To(MatchJSON(`{"name": "server"}`))
+		Expect(args[1]).To(MatchJSON(`{"path": "/"}`))
+		Expect(args[2:]).To(Equal([]interface{}{nil, nil}))
+	})
+
+	It("splits the events into row groups of the configured size", func() {
+		var buf bytes.Buffer
+		Expect(parquet.Write(&buf, evs, parquet.WithRowGroupSize(3))).To(Succeed())
+		file := buf.Bytes()
+
+		metadata := footer(file)
+		Expect(metadata[3]).To(Equal(int64(len(evs))))
+		rowGroups := metadata[4].([]interface{})
+		Expect(rowGroups).To(HaveLen(2))
+		Expect(rowGroups[0].(thriftStruct)[3]).To(Equal(int64(3)))
+		Expect(rowGroups[1].(thriftStruct)[3]).To(Equal(int64(1)))
+		Expect(columnValues(file, "name")).To(Equal([]interface{}{"process_name", "request", "job", "tick"}))
+	})
+
+	It("streams events through an event writer", func() {
+		buf := &closingBuffer{}
+		w := parquet.NewWriter(buf, parquet.WithRowGroupSize(2))
+		for _, e := range evs {
+			Expect(w.Write(e)).To(Succeed())
+		}
+		Expect(w.Close()).To(Succeed())
+
+		Expect(footer(buf.Bytes())[4]).To(HaveLen(2))
+		Expect(columnValues(buf.Bytes(), "ts")).To(Equal([]interface{}{int64(0), int64(100), int64(110), int64(120)}))
+
+		err := w.Write(evs[0])
+		Expect(errors.Is(err, parquet.ErrFinished)).To(BeTrue())
+	})
+
+	It("writes a valid file without any events", func() {
+		var buf bytes.Buffer
+		Expect(parquet.Write(&buf, nil)).To(Succeed())
+
+		metadata := footer(buf.Bytes())
+		Expect(metadata[3]).To(Equal(int64(0)))
+		Expect(metadata[4]).To(BeEmpty())
+	})
+
+	It("writes numeric IDs as their text", func() {
+		var buf bytes.Buffer
+		Expect(parquet.Write(&buf, []events.Event{
+			&events.FlowStart{
+				EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "flow", Timestamp: 1}},
+				Id:            "42",
+			},
+		})).To(Succeed())
+
+		Expect(columnValues(buf.Bytes(), "id")).To(Equal([]interface{}{"42"}))
+	})
+})
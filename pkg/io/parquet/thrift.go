@@ -0,0 +1,102 @@
+package parquet
+
+import "encoding/binary"
+
+// thriftType is the type of a field in Thrift's compact protocol, which Parquet uses to encode its metadata
+type thriftType byte
+
+const (
+	thriftI32    thriftType = 5
+	thriftI64    thriftType = 6
+	thriftBinary thriftType = 8
+	thriftList   thriftType = 9
+	thriftStruct thriftType = 12
+)
+
+// message is a minimal encoder of structs in Thrift's compact protocol, sufficient for the metadata of the Parquet
+// files teffy writes. Fields must be added in increasing order of their IDs.
+type message struct {
+	buf  []byte
+	last int16
+}
+
+func (m *message) field(id int16, t thriftType) {
+	if delta := id - m.last; delta > 0 && delta <= 15 {
+		m.buf = append(m.buf, byte(delta)<<4|byte(t))
+	} else {
+		m.buf = append(m.buf, byte(t))
+		m.varint(zigzag(int64(id)))
+	}
+	m.last = id
+}
+
+func (m *message) varint(v uint64) {
+	m.buf = binary.AppendUvarint(m.buf, v)
+}
+
+func (m *message) binary(v []byte) {
+	m.varint(uint64(len(v)))
+	m.buf = append(m.buf, v...)
+}
+
+func (m *message) listHeader(t thriftType, size int) {
+	if size < 15 {
+		m.buf = append(m.buf, byte(size)<<4|byte(t))
+		return
+	}
+	m.buf = append(m.buf, 0xf0|byte(t))
+	m.varint(uint64(size))
+}
+
+func (m *message) i32Field(id int16, v int32) {
+	m.field(id, thriftI32)
+	m.varint(zigzag(int64(v)))
+}
+
+func (m *message) i64Field(id int16, v int64) {
+	m.field(id, thriftI64)
+	m.varint(zigzag(v))
+}
+
+func (m *message) stringField(id int16, v string) {
+	m.field(id, thriftBinary)
+	m.binary([]byte(v))
+}
+
+func (m *message) structField(id int16, v *message) {
+	m.field(id, thriftStruct)
+	m.buf = append(m.buf, v.bytes()...)
+}
+
+func (m *message) i32ListField(id int16, vs ...int32) {
+	m.field(id, thriftList)
+	m.listHeader(thriftI32, len(vs))
+	for _, v := range vs {
+		m.varint(zigzag(int64(v)))
+	}
+}
+
+func (m *message) stringListField(id int16, vs ...string) {
+	m.field(id, thriftList)
+	m.listHeader(thriftBinary, len(vs))
+	for _, v := range vs {
+		m.binary([]byte(v))
+	}
+}
+
+func (m *message) structListField(id int16, vs []*message) {
+	m.field(id, thriftList)
+	m.listHeader(thriftStruct, len(vs))
+	for _, v := range vs {
+		m.buf = append(m.buf, v.bytes()...)
+	}
+}
+
+// bytes returns the encoded struct, terminated by its stop field
+func (m *message) bytes() []byte {
+	return append(m.buf[:len(m.buf):len(m.buf)], 0)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
@@ -1,11 +1,13 @@
 package io
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"strconv"
+	"io/ioutil"
 	"strings"
 
 	"github.com/omaskery/teffy/pkg/events"
@@ -15,14 +17,209 @@ var (
 	// ErrInvalidDisplayTimeUnit means the file being parsed requests being rendered as an unknown display time unit
 	ErrInvalidDisplayTimeUnit = errors.New("invalid display time unit")
 	// ErrInvalidDataType means that during parsing a value was of an unexpected type (e.g. getting a number instead of string)
-	ErrInvalidDataType = errors.New("data found in file does not match expected type")
+	ErrInvalidDataType = events.ErrInvalidDataType
 	// ErrSyntaxError means that the file being parsed contains invalid JSON
 	ErrSyntaxError = errors.New("file format contained a syntax error")
 )
 
+// maxSnippetLength limits how much of an event's raw JSON is kept in a ParseError
+const maxSnippetLength = 256
+
+// ParseError describes a failure to parse a single trace event, locating it within the input
+type ParseError struct {
+	// Offset is the byte offset of the start of the event within the input
+	Offset int64
+	// Index is the position of the event within the trace events
+	Index int
+	// Phase is the phase of the event, if it could be determined
+	Phase events.Phase
+	// Snippet is the start of the raw JSON of the event, if it could be read
+	Snippet string
+	// Err is the underlying reason that the event could not be parsed
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	if e.Phase != "" {
+		return fmt.Sprintf("error parsing event %d (phase '%s') at byte offset %d: %v", e.Index, e.Phase, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("error parsing event %d at byte offset %d: %v", e.Index, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors are the errors collected while parsing leniently, in the order they were encountered
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d events could not be parsed, the first: %v", len(e), e[0])
+}
+
+func (e ParseErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ParseOption configures how a Trace Event Format file is parsed
+type ParseOption = func(*parser)
+
+// WithLenientParsing skips events that cannot be parsed rather than failing, the parsed data is returned along with
+// a ParseErrors describing every event that was skipped. Input that is not valid JSON still stops parsing.
+func WithLenientParsing() ParseOption {
+	return func(p *parser) {
+		p.lenient = true
+	}
+}
+
+// WithTraceDataKeys reads events from the named members of JSON Object Format files as well as from traceEvents and
+// the member named by controllerTraceDataKey, such as members holding the trace data of other tracing agents.
+// Members that are absent are ignored.
+func WithTraceDataKeys(keys ...string) ParseOption {
+	return func(p *parser) {
+		p.traceDataKeys = append(p.traceDataKeys, keys...)
+	}
+}
+
+// WithLenientDisplayTimeUnit reads a display time unit that is not understood as milliseconds rather than failing,
+// passing warn an error wrapping ErrInvalidDisplayTimeUnit that describes it. The unit as it appeared in the file
+// remains available from TefData.RawDisplayTimeUnit.
+func WithLenientDisplayTimeUnit(warn func(err error)) ParseOption {
+	return func(p *parser) {
+		p.displayTimeUnitWarning = warn
+		if warn == nil {
+			p.displayTimeUnitWarning = func(error) {}
+		}
+	}
+}
+
+type parser struct {
+	lenient       bool
+	traceDataKeys []string
+	index         int
+	errors        ParseErrors
+	// offset locates the input being decoded within the whole input, for members only decoded after being read
+	offset int64
+	// interner shares the names and categories of the events parsed, which large traces repeat many times over
+	interner *events.Interner
+	// ctx cancels parsing, when parsing with a context
+	ctx       context.Context
+	progress  func(Progress)
+	bytesRead int64
+	// displayTimeUnitWarning is told of display time units that are not understood, when they are read leniently
+	displayTimeUnitWarning func(err error)
+	// schemas are checked against each event parsed, when given
+	schemas *SchemaRegistry
+	// stackFramePolicy decides what becomes of stack frames whose IDs are already used
+	stackFramePolicy StackFramePolicy
+	// warn is told of problems with the input that are worked around, when given
+	warn func(err error)
+}
+
+func newParser(options ...ParseOption) *parser {
+	p := &parser{interner: events.NewInterner()}
+	for _, opt := range options {
+		opt(p)
+	}
+	return p
+}
+
+// decodeNext reads the next raw event from the decoder, along with the byte offset it starts at
+func (p *parser) decodeNext(decoder *json.Decoder) (json.RawMessage, int64, error) {
+	var e json.RawMessage
+	if err := decoder.Decode(&e); err != nil {
+		offset := decoder.InputOffset()
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			offset = syntaxErr.Offset
+		}
+		return nil, p.offset + offset, &ParseError{Offset: p.offset + offset, Index: p.index, Err: err}
+	}
+	return e, p.offset + decoder.InputOffset() - int64(len(e)), nil
+}
+
+// parseEvent parses a single raw event, when parsing leniently an event that fails to parse is recorded and nil is
+// returned in its place
+func (p *parser) parseEvent(raw json.RawMessage, offset int64) (events.Event, error) {
+	if err := p.cancelled(); err != nil {
+		return nil, err
+	}
+	index := p.index
+	p.index++
+
+	event, err := p.interner.UnmarshalEvent(raw)
+	if err == nil && p.schemas != nil {
+		err = p.schemas.ValidateEvent(event)
+	}
+	if err == nil {
+		return event, nil
+	}
+
+	parseErr := &ParseError{
+		Offset:  offset,
+		Index:   index,
+		Phase:   rawEventPhase(raw),
+		Snippet: snippet(raw),
+		Err:     err,
+	}
+	if !p.lenient {
+		return nil, parseErr
+	}
+	p.errors = append(p.errors, parseErr)
+	return nil, nil
+}
+
+// result combines the parsed data with any errors collected while parsing leniently
+func (p *parser) result(data *TefData) (*TefData, error) {
+	// a cancelled read can look like the end of a truncated array, so the context has the final say
+	if err := p.cancelled(); err != nil {
+		return nil, err
+	}
+	p.report()
+	if len(p.errors) > 0 {
+		return data, p.errors
+	}
+	return data, nil
+}
+
+// rawEventPhase makes a best effort attempt to find the phase of an event that could not be parsed
+func rawEventPhase(raw json.RawMessage) events.Phase {
+	var j struct {
+		Phase events.Phase `json:"ph"`
+	}
+	_ = json.Unmarshal(raw, &j)
+	return j.Phase
+}
+
+func snippet(raw json.RawMessage) string {
+	if len(raw) > maxSnippetLength {
+		return string(raw[:maxSnippetLength]) + "..."
+	}
+	return string(raw)
+}
+
 // ParseJsonArray reads a JSON Array Format variant of a Trace Event Format file from the provided reader
-func ParseJsonArray(r io.Reader) (*TefData, error) {
-	decoder := json.NewDecoder(r)
+func ParseJsonArray(r io.Reader, options ...ParseOption) (*TefData, error) {
+	return newParser(options...).parseJsonArray(r)
+}
+
+// ParseJsonArrayCtx reads a JSON Array Format file as ParseJsonArray does, stopping with an error wrapping the
+// context's error should the context be cancelled before parsing finishes
+func ParseJsonArrayCtx(ctx context.Context, r io.Reader, options ...ParseOption) (*TefData, error) {
+	p := newParser(options...)
+	p.ctx = ctx
+	return p.parseJsonArray(r)
+}
+
+func (p *parser) parseJsonArray(r io.Reader) (*TefData, error) {
+	decoder := json.NewDecoder(p.reader(r))
 
 	t, err := decoder.Token()
 	if err != nil {
@@ -40,528 +237,235 @@ func ParseJsonArray(r io.Reader) (*TefData, error) {
 	}
 
 	for decoder.More() {
-		var e json.RawMessage
-		err = decoder.Decode(&e)
-		if err != nil && errors.Is(err, io.EOF) {
+		e, offset, err := p.decodeNext(decoder)
+		if err != nil && (errors.Is(err, io.EOF) || isTruncatedArray(decoder)) {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("error parsing JSON: %w", err)
+			return nil, err
 		}
 
-		event, err := parseJsonEvent(e)
+		event, err := p.parseEvent(e, offset)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing event: %w", err)
+			return nil, err
+		}
+		if event != nil {
+			result.traceEvents = append(result.traceEvents, event)
 		}
+	}
+
+	return p.result(result)
+}
 
-		result.traceEvents = append(result.traceEvents, event)
+// isTruncatedArray determines whether the only input remaining after a failed decode is the tail of an unterminated
+// array, as is expected of a file from a streaming writer that was never closed
+func isTruncatedArray(decoder *json.Decoder) bool {
+	remaining, err := ioutil.ReadAll(decoder.Buffered())
+	if err != nil {
+		return false
 	}
+	return strings.Trim(string(remaining), " \t\r\n,") == ""
+}
 
-	return result, nil
+// ParseJsonObj reads a JSON Object Format variant of a Trace Event Format file from the provided reader. Events are
+// read from the traceEvents member, from the member named by controllerTraceDataKey if the file has one, and from any
+// members named by WithTraceDataKeys, in the order the members appear in the file.
+func ParseJsonObj(r io.Reader, options ...ParseOption) (*TefData, error) {
+	return newParser(options...).parseJsonObj(r)
 }
 
-// ParseJsonObj reads a JSON Object Format variant of a Trace Event Format file from the provided reader
-func ParseJsonObj(r io.Reader) (*TefData, error) {
-	var jsonFile jsonObjectFile
-	decoder := json.NewDecoder(r)
-	err := decoder.Decode(&jsonFile)
+// ParseJsonObjCtx reads a JSON Object Format file as ParseJsonObj does, stopping with an error wrapping the context's
+// error should the context be cancelled before parsing finishes
+func ParseJsonObjCtx(ctx context.Context, r io.Reader, options ...ParseOption) (*TefData, error) {
+	p := newParser(options...)
+	p.ctx = ctx
+	return p.parseJsonObj(r)
+}
+
+func (p *parser) parseJsonObj(r io.Reader) (*TefData, error) {
+	decoder := json.NewDecoder(p.reader(r))
+
+	traceEvents, members, err := p.decodeObjectFile(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	// the members other than the trace events are small, so are simply reassembled and decoded together
+	rest, err := json.Marshal(members)
 	if err != nil {
 		return nil, fmt.Errorf("JSON decode error while parsing: %w", err)
 	}
+	var jsonFile jsonObjectFile
+	if err := json.Unmarshal(rest, &jsonFile); err != nil {
+		return nil, fmt.Errorf("JSON decode error while parsing: %w", err)
+	}
 
 	result := &TefData{
+		traceEvents:            traceEvents,
 		displayTimeUnit:        DisplayTimeMs,
 		metadata:               map[string]interface{}{},
 		stackFrames:            map[string]*events.StackFrame{},
 		controllerTraceDataKey: "traceEvents",
 	}
 
-	switch jsonFile.DisplayTimeUnit {
-	case "":
-		fallthrough
-	case string(DisplayTimeMs):
-		result.displayTimeUnit = DisplayTimeMs
-	case string(DisplayTimeNs):
-		result.displayTimeUnit = DisplayTimeNs
-	default:
-		return nil, ErrInvalidDisplayTimeUnit
+	result.rawDisplayTimeUnit = jsonFile.DisplayTimeUnit
+	if result.displayTimeUnit, err = p.displayTimeUnit(jsonFile.DisplayTimeUnit); err != nil {
+		return nil, err
 	}
 
 	result.powerTraceAsString = jsonFile.PowerTraceAsString
+	result.samples = jsonFile.Samples
 	result.systemTraceEvents = jsonFile.SystemTraceEvents
+	result.otherData = jsonFile.OtherData
+	for key, value := range jsonFile.Metadata {
+		result.metadata[key] = value
+	}
 	if jsonFile.ControllerTraceDataKey != "" {
 		result.controllerTraceDataKey = jsonFile.ControllerTraceDataKey
 	}
 
-	for id, f := range jsonFile.StackFrames {
-		frame := &events.StackFrame{
-			Category: f.Category,
-			Name:     f.Name,
-			Parent:   f.Parent,
-		}
-		result.stackFrames[id] = frame
+	if result.stackFrames, err = p.decodeStackFrames(members["stackFrames"]); err != nil {
+		return nil, err
 	}
 
-	for _, e := range jsonFile.TraceEvents {
-		event, err := parseJsonEvent(e)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing event: %w", err)
-		}
-		result.traceEvents = append(result.traceEvents, event)
-	}
+	return p.result(result)
+}
 
-	return result, nil
+// displayTimeUnit interprets the display time unit of a file. Microseconds, which some tools write though viewers do
+// not understand them, are displayed as milliseconds, the default.
+func (p *parser) displayTimeUnit(raw string) (DisplayTimeUnit, error) {
+	switch raw {
+	case "", string(DisplayTimeMs), "us", "µs", "μs":
+		return DisplayTimeMs, nil
+	case string(DisplayTimeNs):
+		return DisplayTimeNs, nil
+	}
+	err := fmt.Errorf("'%s': %w", raw, ErrInvalidDisplayTimeUnit)
+	if p.displayTimeUnitWarning == nil {
+		return "", err
+	}
+	p.displayTimeUnitWarning(err)
+	return DisplayTimeMs, nil
 }
 
-func parseJsonEvent(rawEvent json.RawMessage) (events.Event, error) {
-	phase, err := decodeEventPhase(rawEvent)
+// decodeObjectFile reads a JSON Object Format file member by member, parsing the trace events as they are read so
+// that any errors in them can be located, and returning the raw values of all other members
+func (p *parser) decodeObjectFile(decoder *json.Decoder) ([]events.Event, map[string]json.RawMessage, error) {
+	t, err := decoder.Token()
 	if err != nil {
-		return nil, fmt.Errorf("error decoding json event: %w", err)
+		return nil, nil, fmt.Errorf("JSON decode error while parsing: %w", err)
+	}
+	if t != json.Delim('{') {
+		return nil, nil, fmt.Errorf("expected '{' at start of json object format: %w", ErrSyntaxError)
 	}
 
-	var event events.Event
-	switch phase {
-	case events.PhaseBeginDuration:
-		var j jsonDurationEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode begin duration event: %w", err)
-		}
-		event = &events.BeginDuration{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-			EventStackTrace: events.EventStackTrace{
-				StackTrace: decodeRawStackTrace(j.Stack),
-			},
-		}
-	case events.PhaseEndDuration:
-		var j jsonDurationEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode end duration event: %w", err)
-		}
-		event = &events.EndDuration{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-			EventStackTrace: events.EventStackTrace{
-				StackTrace: decodeRawStackTrace(j.Stack),
-			},
-		}
-
-	case events.PhaseComplete:
-		var j jsonCompleteEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode complete event: %w", err)
-		}
-		event = &events.Complete{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-			EventStackTrace: events.EventStackTrace{
-				StackTrace: decodeRawStackTrace(j.Stack),
-			},
-			EventEndStackTrace: events.EventEndStackTrace{
-				EndStackTrace: decodeRawStackTrace(j.EndStack),
-			},
-			Duration: j.Duration,
-		}
-
-	case events.PhaseInstant, events.PhaseInstantLegacy:
-		var j jsonInstantEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode instant event: %w", err)
-		}
-		scope := events.InstantScope(j.Scope)
-		if scope == "" {
-			scope = events.InstantScopeGlobal
-		}
-		event = &events.Instant{
-			EventCore: decodeEventCore(j.jsonEventCore),
-			EventStackTrace: events.EventStackTrace{
-				StackTrace: decodeRawStackTrace(j.Stack),
-			},
-			Scope: scope,
-		}
-
-	case events.PhaseCounter:
-		var j jsonCounterEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode counter event: %w", err)
-		}
-		event = &events.Counter{
-			EventCore: decodeEventCore(j.jsonEventCore),
-			Values:    j.Values,
-		}
-
-	case "S": // deprecated async start
-		var j jsonAsyncEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode (deprecated) async start event: %w", err)
-		}
-		event = &events.AsyncBegin{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-		}
-	case "T": // deprecated async step into
-		var j jsonAsyncEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode (deprecated) async step into event: %w", err)
-		}
-		event = &events.AsyncInstant{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-		}
-	case "p": // deprecated async step past
-		var j jsonAsyncEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode (deprecated) async step past event: %w", err)
-		}
-		event = &events.AsyncInstant{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-		}
-	case "F": // deprecated async finish
-		var j jsonAsyncEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode (deprecated) async finish event: %w", err)
-		}
-		event = &events.AsyncEnd{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-		}
+	eventKeys := map[string]bool{"traceEvents": true}
+	for _, key := range p.traceDataKeys {
+		eventKeys[key] = true
+	}
 
-	case events.PhaseAsyncBegin:
-		var j jsonAsyncEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode async begin event: %w", err)
-		}
-		event = &events.AsyncBegin{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-		}
-	case events.PhaseAsyncInstant:
-		var j jsonAsyncEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode async instant event: %w", err)
-		}
-		event = &events.AsyncInstant{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-		}
-	case events.PhaseAsyncEnd:
-		var j jsonAsyncEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode async end event: %w", err)
-		}
-		event = &events.AsyncEnd{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
+	// the events of each member are kept apart until the end, as members read before they were known to hold events
+	// are only parsed then
+	var order []string
+	parsed := map[string][]events.Event{}
+	members := map[string]json.RawMessage{}
+	offsets := map[string]int64{}
+	for decoder.More() {
+		t, err := decoder.Token()
+		if err != nil {
+			return nil, nil, fmt.Errorf("JSON decode error while parsing: %w", err)
+		}
+		key := t.(string)
+		order = append(order, key)
+		if !eventKeys[key] {
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err != nil {
+				return nil, nil, fmt.Errorf("JSON decode error while parsing: %w", err)
+			}
+			members[key] = value
+			offsets[key] = decoder.InputOffset() - int64(len(value))
+			if key == "controllerTraceDataKey" {
+				var controllerKey string
+				if err := json.Unmarshal(value, &controllerKey); err == nil && controllerKey != "" {
+					eventKeys[controllerKey] = true
+				}
+			}
+			continue
 		}
 
-	case events.PhaseObjectCreated:
-		var j jsonObjectEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode object created event: %w", err)
-		}
-		event = &events.ObjectCreated{
-			EventCore: decodeEventCore(j.jsonEventCore),
-		}
-	case events.PhaseObjectSnapshot:
-		var j jsonObjectEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode object snapshot event: %w", err)
+		t, err = decoder.Token()
+		if err != nil {
+			return nil, nil, fmt.Errorf("JSON decode error while parsing: %w", err)
 		}
-		event = &events.ObjectSnapshot{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
+		if t == nil {
+			continue
 		}
-	case events.PhaseObjectDeleted:
-		var j jsonObjectEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode object deleted event: %w", err)
+		if t != json.Delim('[') {
+			return nil, nil, fmt.Errorf("expected trace data to be an array: %w", ErrInvalidDataType)
 		}
-		event = &events.ObjectDeleted{
-			EventCore: decodeEventCore(j.jsonEventCore),
+		if parsed[key], err = p.decodeEvents(decoder); err != nil {
+			return nil, nil, err
 		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, fmt.Errorf("JSON decode error while parsing: %w", err)
+	}
 
-	case events.PhaseMetadata:
-		var j jsonMetadataEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode metadata event: %w", err)
-		}
-		switch events.MetadataKind(j.Name) {
-		case events.MetadataKindProcessName:
-			name, err := requireStrEntry(j.Args, "name")
+	// a controllerTraceDataKey that follows the member it names is only known once that member has been read past
+	var traceEvents []events.Event
+	for _, key := range order {
+		if raw, ok := members[key]; ok && eventKeys[key] {
+			delete(members, key)
+			evs, err := p.decodeMember(raw, offsets[key])
 			if err != nil {
-				return nil, fmt.Errorf("failed to get process name metadata: %w", err)
-			}
-			event = &events.MetadataProcessName{
-				EventCore:   decodeEventCore(j.jsonEventCore),
-				ProcessName: name,
-			}
-		case events.MetadataKindProcessLabels:
-			labels, err := requireStrEntry(j.Args, "labels")
-			if err != nil {
-				return nil, fmt.Errorf("failed to get process labels metadata: %w", err)
-			}
-			event = &events.MetadataProcessLabels{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Labels:    labels,
-			}
-		case events.MetadataKindProcessSortIndex:
-			sortIndex, err := requireIntEntry(j.Args, "sort_index")
-			if err != nil {
-				return nil, fmt.Errorf("failed to get process sort index metadata: %w", err)
-			}
-			event = &events.MetadataProcessSortIndex{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				SortIndex: sortIndex,
-			}
-		case events.MetadataKindThreadName:
-			name, err := requireStrEntry(j.Args, "name")
-			if err != nil {
-				return nil, fmt.Errorf("failed to get thread name metadata: %w", err)
-			}
-			event = &events.MetadataThreadName{
-				EventCore:  decodeEventCore(j.jsonEventCore),
-				ThreadName: name,
-			}
-		case events.MetadataKindThreadSortIndex:
-			sortIndex, err := requireIntEntry(j.Args, "sort_index")
-			if err != nil {
-				return nil, fmt.Errorf("failed to get thread sort index metadata: %w", err)
-			}
-			event = &events.MetadataThreadSortIndex{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				SortIndex: sortIndex,
-			}
-		default:
-			event = &events.MetadataMisc{
-				EventWithArgs: events.EventWithArgs{
-					EventCore: decodeEventCore(j.jsonEventCore),
-					Args:      j.Args,
-				},
+				return nil, nil, err
 			}
+			parsed[key] = evs
 		}
+		traceEvents = append(traceEvents, parsed[key]...)
+	}
 
-	case events.PhaseGlobalMemoryDump:
-		var j jsonMemoryDumpEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode global memory dump event: %w", err)
-		}
-		event = &events.GlobalMemoryDump{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-		}
-	case events.PhaseProcessMemoryDump:
-		var j jsonMemoryDumpEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode process memory dump event: %w", err)
-		}
-		event = &events.ProcessMemoryDump{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-		}
-
-	case events.PhaseMark:
-		var j jsonMarkEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode mark event: %w", err)
-		}
-		event = &events.Mark{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-		}
+	return traceEvents, members, nil
+}
 
-	case events.PhaseClockSync:
-		var j jsonClockSyncEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode clock sync event: %w", err)
-		}
-		issueTs, err := getIntEntry(j.Args, "issue_ts")
+// decodeEvents parses the events of an array of trace data, the decoder having just read the start of the array
+func (p *parser) decodeEvents(decoder *json.Decoder) ([]events.Event, error) {
+	var evs []events.Event
+	for decoder.More() {
+		e, offset, err := p.decodeNext(decoder)
 		if err != nil {
-			return nil, fmt.Errorf("failed to extract issue timestamp: %w", err)
+			return nil, err
 		}
-		syncId, err := requireStrEntry(j.Args, "sync_id")
+		event, err := p.parseEvent(e, offset)
 		if err != nil {
-			return nil, fmt.Errorf("failed to extract sync ID: %w", err)
-		}
-		event = &events.ClockSync{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-			IssueTs: issueTs,
-			SyncId:  syncId,
+			return nil, err
 		}
-
-	case events.PhaseContextEnter:
-		var j jsonContextEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode context enter event: %w", err)
+		if event != nil {
+			evs = append(evs, event)
 		}
-		event = &events.ContextEnter{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-		}
-	case events.PhaseContextExit:
-		var j jsonContextEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode context exit event: %w", err)
-		}
-		event = &events.ContextExit{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-		}
-
-	case events.PhaseLinkIds:
-		var j jsonLinkedIdEvent
-		if err := json.Unmarshal(rawEvent, &j); err != nil {
-			return nil, fmt.Errorf("unable to decode linked id event: %w", err)
-		}
-		linkedId, err := requireStrEntry(j.Args, "linked_id")
-		if err != nil {
-			return nil, fmt.Errorf("failed to extract linked ID: %w", err)
-		}
-		event = &events.LinkIds{
-			EventWithArgs: events.EventWithArgs{
-				EventCore: decodeEventCore(j.jsonEventCore),
-				Args:      j.Args,
-			},
-			LinkedId: linkedId,
-		}
-
-	default:
-		return nil, fmt.Errorf("unknown phase encountered: '%v'", phase)
 	}
-
-	return event, nil
-}
-
-func requireIntEntry(args map[string]interface{}, key string) (int64, error) {
-	v, err := getIntEntry(args, key)
-	if err != nil {
-		return 0, err
-	}
-	if v == nil {
-		return 0, fmt.Errorf("integer '%s' expected but was not found", key)
-	}
-	return *v, nil
-}
-
-func getIntEntry(args map[string]interface{}, key string) (*int64, error) {
-	v, ok := args[key]
-	if !ok {
-		return nil, nil
-	}
-
-	if f, ok := v.(float64); ok {
-		i := int64(f)
-		return &i, nil
-	}
-
-	if s, ok := v.(string); ok {
-		i, err := strconv.ParseInt(s, 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("could not parse %s to integer '%v'", s, err)
-		}
-
-		return &i, nil
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("JSON decode error while parsing: %w", err)
 	}
-
-	return nil, fmt.Errorf("expected number, got '%v': %w", v, ErrInvalidDataType)
+	return evs, nil
 }
 
-func requireStrEntry(args map[string]interface{}, key string) (string, error) {
-	v, err := getStrEntry(args, key)
+// decodeMember parses the events of a member of trace data that was read before it was known to hold events, offset
+// being where the member's value starts within the input
+func (p *parser) decodeMember(raw json.RawMessage, offset int64) ([]events.Event, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	t, err := decoder.Token()
 	if err != nil {
-		return "", err
-	}
-	if v == nil {
-		return "", fmt.Errorf("string '%s' expected but was not found", key)
+		return nil, fmt.Errorf("JSON decode error while parsing: %w", err)
 	}
-	return *v, nil
-}
-
-func getStrEntry(args map[string]interface{}, key string) (*string, error) {
-	v, ok := args[key]
-	if !ok {
+	if t == nil {
 		return nil, nil
 	}
-
-	if s, ok := v.(string); ok {
-		return &s, nil
-	}
-
-	return nil, fmt.Errorf("expected string, got '%v': %w", v, ErrInvalidDataType)
-}
-
-func decodeRawStackTrace(trace []string) *events.StackTrace {
-	if len(trace) < 1 {
-		return nil
-	}
-
-	t := events.StackTrace{}
-	for _, entry := range trace {
-		t.Trace = append(t.Trace, &events.StackFrame{
-			Name: entry,
-		})
-	}
-	return &t
-}
-
-func decodeEventPhase(j json.RawMessage) (events.Phase, error) {
-	var jsonPhase jsonEventPhase
-	err := json.Unmarshal(j, &jsonPhase)
-	if err != nil {
-		return "", fmt.Errorf("unable to decode phase from JSON event: %w", err)
-	}
-	return events.Phase(jsonPhase.Phase), nil
-}
-
-func decodeEventCore(jsonCore jsonEventCore) events.EventCore {
-	categories := make([]string, 0)
-	if jsonCore.Categories != "" {
-		categories = strings.Split(jsonCore.Categories, ",")
-	}
-
-	core := events.EventCore{
-		Name:            jsonCore.Name,
-		Categories:      categories,
-		Timestamp:       jsonCore.Timestamp,
-		ThreadTimestamp: jsonCore.ThreadTimestamp,
-		ProcessID:       jsonCore.ProcessID,
-		ThreadID:        jsonCore.ThreadID,
+	if t != json.Delim('[') {
+		return nil, fmt.Errorf("expected trace data to be an array: %w", ErrInvalidDataType)
 	}
 
-	return core
+	p.offset = offset
+	defer func() { p.offset = 0 }()
+	return p.decodeEvents(decoder)
 }
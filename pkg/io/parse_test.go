@@ -1,6 +1,9 @@
 package io_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/omaskery/teffy/pkg/events"
 	. "github.com/onsi/ginkgo"
@@ -12,12 +15,17 @@ import (
 
 var _ = Describe("ParseJsonFile", func() {
 	var testFileContents string
+	var options []io.ParseOption
 	var data *io.TefData
 	var err error
 
+	BeforeEach(func() {
+		options = nil
+	})
+
 	JustBeforeEach(func() {
 		r := strings.NewReader(testFileContents)
-		data, err = io.ParseJsonObj(r)
+		data, err = io.ParseJsonObj(r, options...)
 	})
 
 	When("there are no traces", func() {
@@ -73,6 +81,184 @@ var _ = Describe("ParseJsonFile", func() {
 				Expect(data.StackFrames()).To(HaveLen(2))
 			})
 		})
+
+		When("it has other data and unrecognised top level members", func() {
+			BeforeEach(func() {
+				testFileContents = `
+					{
+						"traceEvents": [],
+						"otherData": {
+							"version": "1.2.3"
+						},
+						"metadata": {
+							"command_line": "chrome --trace"
+						},
+						"beginningOfTime": 100
+					}
+				`
+			})
+
+			It("stores the other data and metadata", func() {
+				Expect(err).To(Succeed())
+				Expect(data.OtherData()).To(Equal(map[string]interface{}{
+					"version": "1.2.3",
+				}))
+				Expect(data.Metadata()).To(Equal(map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"command_line": "chrome --trace",
+					},
+					"beginningOfTime": float64(100),
+				}))
+			})
+		})
+
+		When("its display time unit is unusual", func() {
+			var warnings []error
+
+			BeforeEach(func() {
+				warnings = nil
+			})
+
+			for _, unit := range []string{"us", "µs"} {
+				unit := unit
+				When(fmt.Sprintf("it is %s", unit), func() {
+					BeforeEach(func() {
+						testFileContents = fmt.Sprintf(`{"traceEvents": [], "displayTimeUnit": "%s"}`, unit)
+					})
+
+					It("displays microseconds as milliseconds", func() {
+						Expect(err).To(Succeed())
+						Expect(data.DisplayTimeUnit()).To(Equal(io.DisplayTimeMs))
+						Expect(data.RawDisplayTimeUnit()).To(Equal(unit))
+					})
+				})
+			}
+
+			When("it is unknown", func() {
+				BeforeEach(func() {
+					testFileContents = `{"traceEvents": [], "displayTimeUnit": "fortnights"}`
+				})
+
+				It("fails", func() {
+					Expect(err).To(MatchError(io.ErrInvalidDisplayTimeUnit))
+					Expect(err).To(MatchError(ContainSubstring("fortnights")))
+				})
+
+				When("parsing leniently", func() {
+					BeforeEach(func() {
+						options = append(options, io.WithLenientDisplayTimeUnit(func(err error) {
+							warnings = append(warnings, err)
+						}))
+					})
+
+					It("warns and displays milliseconds", func() {
+						Expect(err).To(Succeed())
+						Expect(data.DisplayTimeUnit()).To(Equal(io.DisplayTimeMs))
+						Expect(data.RawDisplayTimeUnit()).To(Equal("fortnights"))
+						Expect(warnings).To(HaveLen(1))
+						Expect(warnings[0]).To(MatchError(io.ErrInvalidDisplayTimeUnit))
+					})
+				})
+
+				When("parsing leniently without a warning", func() {
+					BeforeEach(func() {
+						options = append(options, io.WithLenientDisplayTimeUnit(nil))
+					})
+
+					It("displays milliseconds", func() {
+						Expect(err).To(Succeed())
+						Expect(data.DisplayTimeUnit()).To(Equal(io.DisplayTimeMs))
+					})
+				})
+			})
+		})
+	})
+
+	When("it has samples", func() {
+		BeforeEach(func() {
+			testFileContents = `
+				{
+					"traceEvents": [],
+					"stackFrames": {"1": {"category": "app", "name": "main"}},
+					"samples": [
+						{"cpu": 2, "tid": 10, "ts": 100, "name": "cycles", "sf": 1, "weight": 3},
+						{"tid": 11, "ts": 200, "sf": "1", "custom": true}
+					]
+				}
+			`
+		})
+
+		It("stores the samples rather than treating them as metadata", func() {
+			Expect(err).To(Succeed())
+			cpu, weight := int64(2), int64(3)
+			Expect(data.Samples()).To(Equal([]*events.ProfileSample{
+				{CPU: &cpu, ThreadID: 10, Timestamp: 100, Name: "cycles", StackFrameID: "1", Weight: &weight},
+				{ThreadID: 11, Timestamp: 200, StackFrameID: "1", Extra: map[string]json.RawMessage{"custom": json.RawMessage("true")}},
+			}))
+			Expect(data.Metadata()).To(BeEmpty())
+		})
+
+		It("writes them back out", func() {
+			var buffer bytes.Buffer
+			Expect(io.WriteJsonObject(&buffer, *data)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring(
+				`"samples":[{"cpu":2,"tid":10,"ts":100,"name":"cycles","sf":1,"weight":3},{"tid":11,"ts":200,"sf":1,"custom":true}]`))
+		})
+	})
+
+	When("the controller's trace data is stored in another member", func() {
+		names := func() []string {
+			var result []string
+			for _, e := range data.Events() {
+				result = append(result, e.Core().Name)
+			}
+			return result
+		}
+
+		BeforeEach(func() {
+			testFileContents = `
+				{
+					"traceEvents": [{"name": "a", "ph": "i", "ts": 1}],
+					"controllerTraceEvents": [{"name": "b", "ph": "i", "ts": 2}],
+					"gpuTraceEvents": [{"name": "c", "ph": "i", "ts": 3}],
+					"controllerTraceDataKey": "controllerTraceEvents"
+				}
+			`
+		})
+
+		It("reads the events of the named member as well as traceEvents", func() {
+			Expect(err).To(Succeed())
+			Expect(names()).To(Equal([]string{"a", "b"}))
+			Expect(data.ControllerTraceDataKey()).To(Equal("controllerTraceEvents"))
+			Expect(data.Metadata()).ToNot(HaveKey("controllerTraceEvents"))
+			Expect(data.Metadata()).To(HaveKey("gpuTraceEvents"))
+		})
+
+		When("the trace data of other agents is requested", func() {
+			BeforeEach(func() {
+				options = []io.ParseOption{io.WithTraceDataKeys("gpuTraceEvents", "missingTraceEvents")}
+			})
+
+			It("reads their events too", func() {
+				Expect(err).To(Succeed())
+				Expect(names()).To(Equal([]string{"a", "b", "c"}))
+				Expect(data.Metadata()).To(BeEmpty())
+			})
+		})
+
+		When("an event of the named member cannot be parsed", func() {
+			const badEvent = `{"name": "b", "ph": "X", "ts": "soon"}`
+
+			BeforeEach(func() {
+				testFileContents = `{"controllerTraceEvents": [` + badEvent + `], "controllerTraceDataKey": "controllerTraceEvents"}`
+			})
+
+			It("locates the event within the file", func() {
+				var parseErr *io.ParseError
+				Expect(errors.As(err, &parseErr)).To(BeTrue())
+				Expect(parseErr.Offset).To(BeNumerically("==", strings.Index(testFileContents, badEvent)))
+			})
+		})
 	})
 })
 
@@ -264,6 +450,71 @@ var _ = Describe("Parsing EventCore", func() {
 			Expect(event.Core().Categories).To(HaveLen(2))
 			Expect(event.Core().Categories[0]).To(Equal("one"))
 			Expect(event.Core().Categories[1]).To(Equal("two"))
+			Expect(event.Core().Extra).To(BeEmpty())
+		})
+	})
+
+	When("fields contain characters that are significant to JSON", func() {
+		BeforeEach(func() {
+			testFileContents = `[{"name":"A \"quoted\", {braced} [bracketed]","ph":"B","ts":0,
+				"ke\"y":"va,l}ue","n\u00e4me":[{"a":"]"}, -1.5e3, true, null]}]`
+		})
+
+		It("splits the fields correctly", func() {
+			Expect(err).To(Succeed())
+			Expect(data.Events()).To(HaveLen(1))
+			Expect(data.Events()[0].Core().Name).To(Equal(`A "quoted", {braced} [bracketed]`))
+			extra := data.Events()[0].Core().Extra
+			Expect(extra).To(HaveLen(2))
+			Expect(string(extra[`ke"y`])).To(Equal(`"va,l}ue"`))
+			Expect(string(extra["näme"])).To(MatchJSON(`[{"a":"]"}, -1.5e3, true, null]`))
+		})
+	})
+
+	When("when a color is present", func() {
+		BeforeEach(func() {
+			testFileContents = `[{"name": "A", "ph": "B", "ts": 0, "cname": "thread_state_runnable"}]`
+		})
+
+		It("parses the color", func() {
+			Expect(err).To(Succeed())
+			Expect(data.Events()).To(HaveLen(1))
+			Expect(data.Events()[0].Core().Color).To(Equal("thread_state_runnable"))
+			Expect(data.Events()[0].Core().Extra).To(BeEmpty())
+		})
+	})
+
+	When("when unknown fields are present", func() {
+		BeforeEach(func() {
+			testFileContents = `[{
+				"name": "A",
+				"ph": "B",
+				"ts": 0,
+				"custom": "good",
+				"vendor": {"nested": [1, 2]}
+			}]`
+		})
+
+		It("preserves the unknown fields", func() {
+			Expect(err).To(Succeed())
+			Expect(data.Events()).To(HaveLen(1))
+			extra := data.Events()[0].Core().Extra
+			Expect(extra).To(HaveLen(2))
+			Expect(string(extra["custom"])).To(MatchJSON(`"good"`))
+			Expect(string(extra["vendor"])).To(MatchJSON(`{"nested": [1, 2]}`))
+		})
+
+		It("writes the unknown fields back out", func() {
+			Expect(err).To(Succeed())
+			var output strings.Builder
+			Expect(io.WriteJsonArray(&output, data.Events())).To(Succeed())
+			Expect(output.String()).To(MatchJSON(`[{
+				"name": "A",
+				"ph": "B",
+				"ts": 0,
+				"custom": "good",
+				"vendor": {"nested": [1, 2]}
+			}]`))
 		})
 	})
 })
@@ -326,6 +577,27 @@ var _ = Describe("Parsing Begin Duration", func() {
 		})
 	})
 
+	When("when a stack frame reference is present", func() {
+		BeforeEach(func() {
+			testFileContents = `[{
+				"name": "A",
+				"ph": "B",
+				"ts": 0,
+				"sf": "7"
+			}]`
+		})
+
+		It("correctly parses the reference", func() {
+			Expect(err).To(Succeed())
+			Expect(data.Events()).To(HaveLen(1))
+			event, ok := data.Events()[0].(*events.BeginDuration)
+			Expect(ok).To(BeTrue())
+			Expect(event.StackTrace).To(BeNil())
+			Expect(event.StackFrameID).To(Equal("7"))
+			Expect(event.Core().Extra).To(BeEmpty())
+		})
+	})
+
 	When("when arguments are present", func() {
 		BeforeEach(func() {
 			testFileContents = `[{
@@ -352,6 +624,126 @@ var _ = Describe("Parsing Begin Duration", func() {
 	})
 })
 
+var _ = Describe("Parsing Sample", func() {
+	var testFileContents string
+	var data *io.TefData
+	var err error
+
+	JustBeforeEach(func() {
+		r := strings.NewReader(testFileContents)
+		data, err = io.ParseJsonArray(r)
+	})
+
+	When("parsing", func() {
+		BeforeEach(func() {
+			testFileContents = `[{
+				"name": "A",
+				"ph": "P",
+				"ts": 0,
+				"stack": ["one", "two"]
+			}]`
+		})
+
+		It("generates the correct type", func() {
+			Expect(err).To(Succeed())
+			Expect(data.Events()).To(HaveLen(1))
+			event, ok := data.Events()[0].(*events.Sample)
+			Expect(ok).To(BeTrue())
+			Expect(event.StackTrace.Trace).To(HaveLen(2))
+		})
+	})
+})
+
+var _ = Describe("Parsing Complete", func() {
+	var testFileContents string
+	var data *io.TefData
+	var err error
+
+	JustBeforeEach(func() {
+		r := strings.NewReader(testFileContents)
+		data, err = io.ParseJsonArray(r)
+	})
+
+	When("thread clock timings are present", func() {
+		BeforeEach(func() {
+			testFileContents = `[{
+				"name": "A",
+				"ph": "X",
+				"ts": 10,
+				"dur": 20,
+				"tts": 4,
+				"tdur": 5
+			}]`
+		})
+
+		It("parses the thread duration", func() {
+			Expect(err).To(Succeed())
+			Expect(data.Events()).To(HaveLen(1))
+			event, ok := data.Events()[0].(*events.Complete)
+			Expect(ok).To(BeTrue())
+			Expect(event.Duration).To(BeNumerically("==", 20))
+			Expect(event.ThreadDuration).ToNot(BeNil())
+			Expect(*event.ThreadDuration).To(BeNumerically("==", int64(5)))
+			Expect(event.ThreadEndTimestamp()).ToNot(BeNil())
+			Expect(*event.ThreadEndTimestamp()).To(BeNumerically("==", int64(9)))
+		})
+	})
+})
+
+var _ = Describe("Parsing Flow events", func() {
+	var testFileContents string
+	var data *io.TefData
+	var err error
+
+	JustBeforeEach(func() {
+		r := strings.NewReader(testFileContents)
+		data, err = io.ParseJsonArray(r)
+	})
+
+	When("parsing a flow", func() {
+		BeforeEach(func() {
+			testFileContents = `[
+				{"name": "A", "ph": "s", "ts": 1, "id": 7, "scope": "such-scope"},
+				{"name": "A", "ph": "t", "ts": 2, "id": "7"},
+				{"name": "A", "ph": "f", "ts": 3, "id": "0x7", "bp": "e"},
+				{"name": "A", "ph": "f", "ts": 4, "id": 7}
+			]`
+		})
+
+		It("parses the ids and binding points", func() {
+			Expect(err).To(Succeed())
+			Expect(data.Events()).To(HaveLen(4))
+
+			start, ok := data.Events()[0].(*events.FlowStart)
+			Expect(ok).To(BeTrue())
+			Expect(start.Id).To(Equal("7"))
+			Expect(start.Scope).To(Equal("such-scope"))
+
+			step, ok := data.Events()[1].(*events.FlowInstant)
+			Expect(ok).To(BeTrue())
+			Expect(step.Id).To(Equal("7"))
+
+			enclosing, ok := data.Events()[2].(*events.FlowFinish)
+			Expect(ok).To(BeTrue())
+			Expect(enclosing.Id).To(Equal("0x7"))
+			Expect(enclosing.BindingPoint).To(Equal(events.BindingPointEnclosing))
+
+			next, ok := data.Events()[3].(*events.FlowFinish)
+			Expect(ok).To(BeTrue())
+			Expect(next.BindingPoint).To(Equal(events.BindingPointNext))
+		})
+
+		It("writes them back out unchanged", func() {
+			Expect(err).To(Succeed())
+			var buf bytes.Buffer
+			Expect(io.WriteJsonArray(&buf, data.Events())).To(Succeed())
+			reparsed, err := io.ParseJsonArray(&buf)
+			Expect(err).To(Succeed())
+			Expect(reparsed.Events()).To(Equal(data.Events()))
+		})
+	})
+})
+
 var _ = Describe("Parsing Async Start", func() {
 	var testFileContents string
 	var data *io.TefData
@@ -620,6 +1012,84 @@ var _ = Describe("Parsing Context Exit", func() {
 	})
 })
 
+var _ = Describe("Parsing unsupported phases", func() {
+	It("preserves the event as a Raw event", func() {
+		contents := `[{"name": "odd", "ph": "Z", "ts": 5, "pid": 1, "cat": "a,b", "args": {"x": 1}, "custom": true}]`
+		data, err := io.ParseJsonArray(strings.NewReader(contents))
+		Expect(err).To(Succeed())
+		Expect(data.Events()).To(HaveLen(1))
+		raw, ok := data.Events()[0].(*events.Raw)
+		Expect(ok).To(BeTrue())
+		Expect(raw.Phase()).To(Equal(events.Phase("Z")))
+		Expect(raw.Name).To(Equal("odd"))
+		Expect(raw.Categories).To(Equal([]string{"a", "b"}))
+		Expect(raw.Timestamp).To(BeNumerically("==", 5))
+
+		var output strings.Builder
+		Expect(io.WriteJsonArray(&output, data.Events())).To(Succeed())
+		Expect(output.String()).To(MatchJSON(contents))
+	})
+})
+
+var _ = Describe("Parse errors", func() {
+	badEvent := `{"name": "bad", "ph": "C", "ts": 1, "args": {"value": "many"}}`
+	arrayContents := `[
+		{"name": "good", "ph": "i", "ts": 0},
+		` + badEvent + `,
+		{"name": "also-good", "ph": "i", "ts": 2}
+	]`
+	objectContents := `{"otherData": {"version": "1"}, "traceEvents": ` + arrayContents + `}`
+
+	expectLocated := func(err error, contents string) {
+		var parseErr *io.ParseError
+		Expect(errors.As(err, &parseErr)).To(BeTrue())
+		Expect(parseErr.Index).To(Equal(1))
+		Expect(parseErr.Offset).To(BeNumerically("==", strings.Index(contents, badEvent)))
+		Expect(parseErr.Phase).To(Equal(events.PhaseCounter))
+		Expect(parseErr.Snippet).To(Equal(badEvent))
+		Expect(parseErr.Error()).To(ContainSubstring("invalid syntax"))
+	}
+
+	It("locates the failing event in the array format", func() {
+		_, err := io.ParseJsonArray(strings.NewReader(arrayContents))
+		expectLocated(err, arrayContents)
+	})
+
+	It("locates the failing event in the object format", func() {
+		_, err := io.ParseJsonObj(strings.NewReader(objectContents))
+		expectLocated(err, objectContents)
+	})
+
+	It("locates invalid JSON", func() {
+		contents := `[{"name": "good", "ph": "i", "ts": 0}, {"name": ]`
+		_, err := io.ParseJsonArray(strings.NewReader(contents))
+		var parseErr *io.ParseError
+		Expect(errors.As(err, &parseErr)).To(BeTrue())
+		Expect(parseErr.Index).To(Equal(1))
+		Expect(parseErr.Offset).To(BeNumerically(">", strings.Index(contents, `{"name": ]`)))
+	})
+
+	When("parsing leniently", func() {
+		It("skips the failing events and reports them", func() {
+			data, err := io.ParseJsonObj(strings.NewReader(objectContents), io.WithLenientParsing())
+			Expect(data).ToNot(BeNil())
+			Expect(data.Events()).To(HaveLen(2))
+			Expect(data.OtherData()).To(HaveKeyWithValue("version", "1"))
+
+			var parseErrs io.ParseErrors
+			Expect(errors.As(err, &parseErrs)).To(BeTrue())
+			Expect(parseErrs).To(HaveLen(1))
+			expectLocated(parseErrs[0], objectContents)
+		})
+
+		It("reports nothing when every event parses", func() {
+			data, err := io.ParseJsonArray(strings.NewReader(makeTrivialEventWithPhase("i")), io.WithLenientParsing())
+			Expect(err).To(Succeed())
+			Expect(data.Events()).To(HaveLen(1))
+		})
+	})
+})
+
 func makeTrivialEventWithPhase(phase events.Phase) string {
 	return fmt.Sprintf(`[{
 		"name": "event-name",
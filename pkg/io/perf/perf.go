@@ -0,0 +1,183 @@
+// perf provides conversion of the text output of Linux's `perf script` into trace events
+package perf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// Category is the category given to all events converted from perf samples
+const Category = "perf"
+
+var (
+	// headerPattern matches the first line of each sample: the command, the pid/tid or just the tid, the cpu, the
+	// time in seconds, the period and the event name, the cpu and period being optional
+	headerPattern = regexp.MustCompile(`^(\S.*?)\s+(\d+)(?:/(\d+))?\s+(?:\[\d+\]\s+)?(\d+)\.(\d+):\s+(?:\d+\s+)?(\S+)`)
+	// framePattern matches each line of a sample's call stack: the address, the symbol and the module
+	framePattern = regexp.MustCompile(`^\s+[0-9a-fA-F]+\s+(.*?)(?:\s+\((.*)\))?\s*$`)
+	// offsetPattern matches the offset into the function that perf appends to symbols
+	offsetPattern = regexp.MustCompile(`\+0x[0-9a-fA-F]+$`)
+)
+
+// ErrUnrecognisedLine means that a line of the input was not understood as part of `perf script` output
+var ErrUnrecognisedLine = errors.New("unrecognised perf script line")
+
+type frameKey struct {
+	parent, module, symbol string
+}
+
+type threadKey struct {
+	pid, tid int64
+}
+
+type converter struct {
+	data        *tio.TefData
+	frames      map[frameKey]string
+	processes   map[int64]struct{}
+	threads     map[threadKey]struct{}
+	sample      *events.Sample
+	stack       []frameKey
+	nextFrameID int
+}
+
+// Parse reads the output of `perf script` from the provided reader, converting each sample into a Sample event. The
+// call stacks of the samples are recorded in the returned data's stack frames, the module of each frame being its
+// category, and the command of each process and thread is used to name them. When perf gives only the thread ID of
+// a sample it is used as the process ID too.
+func Parse(r io.Reader) (*tio.TefData, error) {
+	c := &converter{
+		data:      &tio.TefData{},
+		frames:    map[frameKey]string{},
+		processes: map[int64]struct{}{},
+		threads:   map[threadKey]struct{}{},
+	}
+	c.data.SetDisplayTimeUnit(tio.DisplayTimeMs)
+
+	br := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read perf script output: %w", err)
+		}
+		if perr := c.line(strings.TrimRight(line, "\r\n")); perr != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, perr)
+		}
+		if err != nil {
+			break
+		}
+	}
+	c.finishSample()
+
+	return c.data, nil
+}
+
+func (c *converter) line(line string) error {
+	switch {
+	case strings.TrimSpace(line) == "":
+		c.finishSample()
+	case strings.HasPrefix(line, "#"):
+	case line[0] == ' ' || line[0] == '\t':
+		if c.sample == nil {
+			return fmt.Errorf("call stack outside of a sample: %w", ErrUnrecognisedLine)
+		}
+		m := framePattern.FindStringSubmatch(line)
+		if m == nil {
+			return fmt.Errorf("'%s': %w", line, ErrUnrecognisedLine)
+		}
+		c.stack = append(c.stack, frameKey{module: m[2], symbol: offsetPattern.ReplaceAllString(m[1], "")})
+	default:
+		c.finishSample()
+		return c.header(line)
+	}
+	return nil
+}
+
+func (c *converter) header(line string) error {
+	m := headerPattern.FindStringSubmatch(line)
+	if m == nil {
+		return fmt.Errorf("'%s': %w", line, ErrUnrecognisedLine)
+	}
+	command := strings.TrimSpace(m[1])
+	pid, _ := strconv.ParseInt(m[2], 10, 64)
+	tid := pid
+	if m[3] != "" {
+		tid, _ = strconv.ParseInt(m[3], 10, 64)
+	}
+	ts, err := microseconds(m[4], m[5])
+	if err != nil {
+		return err
+	}
+
+	c.nameThread(pid, tid, command)
+	c.sample = &events.Sample{
+		EventCore: events.EventCore{
+			Name:       strings.TrimRight(m[6], ":"),
+			Categories: []string{Category},
+			Timestamp:  ts,
+			ProcessID:  &pid,
+			ThreadID:   &tid,
+		},
+	}
+	return nil
+}
+
+// microseconds converts a time in seconds, given as its whole and fractional digits, to microseconds exactly
+func microseconds(whole, fraction string) (int64, error) {
+	fraction = (fraction + "000000")[:6]
+	seconds, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s.%s': %w", whole, fraction, err)
+	}
+	micros, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s.%s': %w", whole, fraction, err)
+	}
+	return seconds*1000000 + micros, nil
+}
+
+// nameThread names the process and thread of a sample after its command the first time each is seen
+func (c *converter) nameThread(pid, tid int64, command string) {
+	if _, ok := c.processes[pid]; !ok {
+		c.processes[pid] = struct{}{}
+		c.data.Write(events.NewProcessName(pid, command))
+	}
+	if _, ok := c.threads[threadKey{pid, tid}]; !ok {
+		c.threads[threadKey{pid, tid}] = struct{}{}
+		c.data.Write(events.NewThreadName(pid, tid, command))
+	}
+}
+
+// finishSample writes out the sample being read, if any, with its call stack. perf lists the most recently called
+// frame first, so the stack is walked from its end to build the frames from the outermost caller inwards.
+func (c *converter) finishSample() {
+	if c.sample == nil {
+		return
+	}
+
+	parent := ""
+	for i := len(c.stack) - 1; i >= 0; i-- {
+		key := c.stack[i]
+		key.parent = parent
+		id, ok := c.frames[key]
+		if !ok {
+			id = strconv.Itoa(c.nextFrameID)
+			c.nextFrameID++
+			c.frames[key] = id
+			c.data.SetStackFrame(id, &events.StackFrame{Category: key.module, Name: key.symbol, Parent: parent})
+		}
+		parent = id
+	}
+	c.sample.StackFrameID = parent
+
+	c.data.Write(c.sample)
+	c.sample = nil
+	c.stack = c.stack[:0]
+}
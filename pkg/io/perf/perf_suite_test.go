@@ -0,0 +1,13 @@
+package perf_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPerf(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Perf Suite")
+}
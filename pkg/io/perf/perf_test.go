@@ -0,0 +1,101 @@
+package perf_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/perf"
+)
+
+const output = `# ========
+# captured on    : Thu Jan  1 00:00:00 1970
+# ========
+#
+my prog  1234/1235 [002] 12345.678901:     250000 cycles:u: 
+	    55d4f0001234 compute+0x14 (/usr/bin/myprog)
+	    55d4f0002345 main+0x2a (/usr/bin/myprog)
+	    7f0011223344 __libc_start_main+0xf3 (/usr/lib/libc.so.6)
+
+my prog  1234/1235 [002] 12345.679:     250000 cycles:u: 
+	    55d4f0005678 helper (/usr/bin/myprog)
+	    55d4f0002345 main+0x10 (/usr/bin/myprog)
+	    7f0011223344 __libc_start_main+0xf3 (/usr/lib/libc.so.6)
+
+swapper     0 [000] 12345.680000:    1000000 cpu-clock:pppH: 
+	ffffffff81012345 [unknown] ([kernel.kallsyms])
+`
+
+var _ = Describe("Parse", func() {
+	var data *tio.TefData
+
+	BeforeEach(func() {
+		var err error
+		data, err = perf.Parse(strings.NewReader(output))
+		Expect(err).To(Succeed())
+	})
+
+	samples := func() []*events.Sample {
+		var result []*events.Sample
+		for _, e := range data.Events() {
+			if s, ok := e.(*events.Sample); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+
+	It("converts each sample", func() {
+		s := samples()
+		Expect(s).To(HaveLen(3))
+
+		Expect(s[0].Name).To(Equal("cycles:u"))
+		Expect(s[0].Categories).To(Equal([]string{perf.Category}))
+		Expect(s[0].Timestamp).To(Equal(int64(12345678901)))
+		Expect(*s[0].ProcessID).To(Equal(int64(1234)))
+		Expect(*s[0].ThreadID).To(Equal(int64(1235)))
+		Expect(s[1].Timestamp).To(Equal(int64(12345679000)))
+
+		Expect(*s[2].ProcessID).To(Equal(int64(0)))
+		Expect(*s[2].ThreadID).To(Equal(int64(0)))
+	})
+
+	It("records call stacks as stack frames shared between samples", func() {
+		s := samples()
+		frames := data.StackFrames()
+
+		stack := func(id string) []string {
+			var names []string
+			for id != "" {
+				frame := frames[id]
+				names = append([]string{frame.Category + ":" + frame.Name}, names...)
+				id = frame.Parent
+			}
+			return names
+		}
+		Expect(stack(s[0].StackFrameID)).To(Equal([]string{
+			"/usr/lib/libc.so.6:__libc_start_main", "/usr/bin/myprog:main", "/usr/bin/myprog:compute",
+		}))
+		Expect(stack(s[1].StackFrameID)).To(Equal([]string{
+			"/usr/lib/libc.so.6:__libc_start_main", "/usr/bin/myprog:main", "/usr/bin/myprog:helper",
+		}))
+		Expect(stack(s[2].StackFrameID)).To(Equal([]string{"[kernel.kallsyms]:[unknown]"}))
+		Expect(frames).To(HaveLen(5))
+	})
+
+	It("names processes and threads after their commands", func() {
+		processes := data.Processes()
+		Expect(processes).To(HaveLen(2))
+		Expect(processes[0].Name).To(Equal("swapper"))
+		Expect(processes[1].Name).To(Equal("my prog"))
+		Expect(processes[1].Thread(1235).Name).To(Equal("my prog"))
+	})
+
+	It("rejects input that is not perf script output", func() {
+		_, err := perf.Parse(strings.NewReader(`[{"name":"a","ph":"I","ts":1}]`))
+		Expect(err).To(MatchError(perf.ErrUnrecognisedLine))
+	})
+})
@@ -0,0 +1,569 @@
+package perfetto
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// field numbers taken from Chromium's legacy protos/perfetto/trace/chrome/chrome_trace_event.proto
+const (
+	packetFieldChromeEvents = 5
+
+	bundleFieldTraceEvents = 1
+	bundleFieldMetadata    = 2
+	bundleFieldStringTable = 3
+
+	stringTableFieldValue = 1
+	stringTableFieldIndex = 2
+
+	chromeMetadataFieldName        = 1
+	chromeMetadataFieldStringValue = 2
+	chromeMetadataFieldBoolValue   = 3
+	chromeMetadataFieldIntValue    = 4
+	chromeMetadataFieldJsonValue   = 5
+
+	chromeEventFieldName                   = 1
+	chromeEventFieldTimestamp              = 2
+	chromeEventFieldPhase                  = 3
+	chromeEventFieldThreadId               = 4
+	chromeEventFieldDuration               = 5
+	chromeEventFieldThreadDuration         = 6
+	chromeEventFieldScope                  = 7
+	chromeEventFieldId                     = 8
+	chromeEventFieldFlags                  = 9
+	chromeEventFieldCategoryGroupName      = 10
+	chromeEventFieldProcessId              = 11
+	chromeEventFieldThreadTimestamp        = 12
+	chromeEventFieldArgs                   = 14
+	chromeEventFieldNameIndex              = 15
+	chromeEventFieldCategoryGroupNameIndex = 16
+
+	chromeArgFieldName         = 1
+	chromeArgFieldBoolValue    = 2
+	chromeArgFieldUintValue    = 3
+	chromeArgFieldIntValue     = 4
+	chromeArgFieldDoubleValue  = 5
+	chromeArgFieldStringValue  = 6
+	chromeArgFieldPointerValue = 7
+	chromeArgFieldJsonValue    = 8
+	chromeArgFieldNameIndex    = 9
+	chromeArgFieldTracedValue  = 10
+
+	tracedValueFieldNestedType  = 1
+	tracedValueFieldDictKeys    = 2
+	tracedValueFieldDictValues  = 3
+	tracedValueFieldArrayValues = 4
+	tracedValueFieldIntValue    = 5
+	tracedValueFieldDoubleValue = 6
+	tracedValueFieldBoolValue   = 7
+	tracedValueFieldStringValue = 8
+)
+
+// flags of ChromeTraceEvent, taken from Chromium's trace_event_common.h
+const (
+	chromeFlagHasId           = 1 << 1
+	chromeFlagScopeMask       = 1<<2 | 1<<3
+	chromeFlagScopeProcess    = 1 << 2
+	chromeFlagScopeThread     = 2 << 2
+	chromeFlagBindToEnclosing = 1 << 5
+)
+
+// tracedValueNestedTypeArray is the NestedType of ChromeTracedValues holding arrays rather than dictionaries
+const tracedValueNestedTypeArray = 1
+
+// WriteChromeEvents writes all the events and metadata of the given data to the provided writer as a Perfetto trace
+// holding Chrome's legacy ChromeEventBundle encoding of Trace Event Format events, for tools that speak it. Unlike
+// WriteTrace nothing is lost in translating events to tracks, but the encoding has no place for stack traces, which
+// are dropped. IDs are numbers in this encoding, so IDs that are not numbers, in decimal or hex, are hashed.
+func WriteChromeEvents(w io.Writer, data tio.TefData) error {
+	bundle := message{}
+	for _, e := range data.Events() {
+		ev, err := chromeEvent(e)
+		if err != nil {
+			return err
+		}
+		bundle.messageField(bundleFieldTraceEvents, ev)
+	}
+
+	keys := make([]string, 0, len(data.Metadata()))
+	for key := range data.Metadata() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		md, err := chromeMetadata(key, data.Metadata()[key])
+		if err != nil {
+			return err
+		}
+		bundle.messageField(bundleFieldMetadata, md)
+	}
+
+	packet := message{}
+	packet.messageField(packetFieldChromeEvents, bundle)
+	packet.uintField(packetFieldTrustedPacketSequenceId, sequenceId)
+	trace := message{}
+	trace.messageField(traceFieldPacket, packet)
+	if _, err := w.Write(trace); err != nil {
+		return fmt.Errorf("failed to write trace packet: %w", err)
+	}
+	return nil
+}
+
+// chromeEvent translates an event via its JSON form, so that every phase is translated the same way that it is
+// written to Trace Event Format files
+func chromeEvent(e events.Event) (message, error) {
+	raw, err := events.MarshalEvent(e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var j map[string]interface{}
+	if err := decoder.Decode(&j); err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ev := message{}
+	phase, _ := j["ph"].(string)
+	if len(phase) != 1 {
+		return nil, fmt.Errorf("unable to encode event of phase '%s'", phase)
+	}
+	ev.stringField(chromeEventFieldName, e.Core().Name)
+	ev.intField(chromeEventFieldTimestamp, e.Core().Timestamp)
+	ev.intField(chromeEventFieldPhase, int64(phase[0]))
+	if cat, ok := j["cat"].(string); ok {
+		ev.stringField(chromeEventFieldCategoryGroupName, cat)
+	}
+	if e.Core().ProcessID != nil {
+		ev.intField(chromeEventFieldProcessId, *e.Core().ProcessID)
+	}
+	if e.Core().ThreadID != nil {
+		ev.intField(chromeEventFieldThreadId, *e.Core().ThreadID)
+	}
+	if e.Core().ThreadTimestamp != nil {
+		ev.intField(chromeEventFieldThreadTimestamp, *e.Core().ThreadTimestamp)
+	}
+	if dur, ok := jsonInt(j["dur"]); ok {
+		ev.intField(chromeEventFieldDuration, dur)
+	}
+	if tdur, ok := jsonInt(j["tdur"]); ok {
+		ev.intField(chromeEventFieldThreadDuration, tdur)
+	}
+	if scope, ok := j["scope"].(string); ok {
+		ev.stringField(chromeEventFieldScope, scope)
+	}
+
+	var flags uint64
+	if id, ok := j["id"]; ok {
+		flags |= chromeFlagHasId
+		ev.uintField(chromeEventFieldId, chromeId(id))
+	}
+	switch j["s"] {
+	case string(events.InstantScopeProcess):
+		flags |= chromeFlagScopeProcess
+	case string(events.InstantScopeThread):
+		flags |= chromeFlagScopeThread
+	}
+	if bp, _ := j["bp"].(string); bp == "e" {
+		flags |= chromeFlagBindToEnclosing
+	}
+	if flags != 0 {
+		ev.uintField(chromeEventFieldFlags, flags)
+	}
+
+	args, _ := j["args"].(map[string]interface{})
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		arg, err := chromeArg(key, args[key])
+		if err != nil {
+			return nil, err
+		}
+		ev.messageField(chromeEventFieldArgs, arg)
+	}
+	return ev, nil
+}
+
+// chromeId converts an ID to the number Chrome uses, hashing IDs that are not numbers
+func chromeId(id interface{}) uint64 {
+	var s string
+	switch v := id.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	default:
+		s = fmt.Sprint(v)
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		if n, err := strconv.ParseUint(s[2:], 16, 64); err == nil {
+			return n
+		}
+	} else if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return n
+	}
+	h := fnv.New64a()
+	_, _ = io.WriteString(h, s)
+	return h.Sum64()
+}
+
+func jsonInt(v interface{}) (int64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := n.Int64()
+	return i, err == nil
+}
+
+func chromeArg(key string, value interface{}) (message, error) {
+	arg := message{}
+	arg.stringField(chromeArgFieldName, key)
+	switch v := value.(type) {
+	case bool:
+		arg.boolField(chromeArgFieldBoolValue, v)
+	case string:
+		arg.stringField(chromeArgFieldStringValue, v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			arg.intField(chromeArgFieldIntValue, i)
+		} else if f, err := v.Float64(); err == nil {
+			arg.doubleField(chromeArgFieldDoubleValue, f)
+		} else {
+			arg.stringField(chromeArgFieldJsonValue, v.String())
+		}
+	default:
+		j, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode argument '%s': %w", key, err)
+		}
+		arg.stringField(chromeArgFieldJsonValue, string(j))
+	}
+	return arg, nil
+}
+
+func chromeMetadata(key string, value interface{}) (message, error) {
+	md := message{}
+	md.stringField(chromeMetadataFieldName, key)
+	switch v := value.(type) {
+	case bool:
+		md.boolField(chromeMetadataFieldBoolValue, v)
+	case string:
+		md.stringField(chromeMetadataFieldStringValue, v)
+	case int:
+		md.intField(chromeMetadataFieldIntValue, int64(v))
+	case int64:
+		md.intField(chromeMetadataFieldIntValue, v)
+	default:
+		j, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode metadata '%s': %w", key, err)
+		}
+		md.stringField(chromeMetadataFieldJsonValue, string(j))
+	}
+	return md, nil
+}
+
+// ParseChromeEvents reads the ChromeEventBundles of a Perfetto trace, as written by Chrome's legacy tracing backend or
+// by WriteChromeEvents, into trace events. Any other packets of the trace are ignored. Chrome IDs are numbers, which
+// are given to events as hex strings.
+func ParseChromeEvents(r io.Reader) (*tio.TefData, error) {
+	input, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trace: %w", err)
+	}
+	trace, err := decodeMessage(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode trace: %w", err)
+	}
+
+	data := &tio.TefData{}
+	data.SetDisplayTimeUnit(tio.DisplayTimeMs)
+	for _, packetField := range trace {
+		if packetField.number != traceFieldPacket || packetField.wire != wireLengthDelimited {
+			continue
+		}
+		packet, err := decodeMessage(packetField.bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode trace packet: %w", err)
+		}
+		for _, f := range packet {
+			if f.number != packetFieldChromeEvents || f.wire != wireLengthDelimited {
+				continue
+			}
+			if err := parseChromeBundle(f.bytes, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return data, nil
+}
+
+func parseChromeBundle(b []byte, data *tio.TefData) error {
+	bundle, err := decodeMessage(b)
+	if err != nil {
+		return fmt.Errorf("failed to decode chrome event bundle: %w", err)
+	}
+
+	// the string table must be known before the events referring to it can be parsed
+	stringTable := map[uint64]string{}
+	for _, f := range bundle {
+		if f.number != bundleFieldStringTable {
+			continue
+		}
+		entry, err := decodeMessage(f.bytes)
+		if err != nil {
+			return fmt.Errorf("failed to decode string table: %w", err)
+		}
+		var value string
+		var index uint64
+		for _, ef := range entry {
+			switch ef.number {
+			case stringTableFieldValue:
+				value = string(ef.bytes)
+			case stringTableFieldIndex:
+				index = ef.value
+			}
+		}
+		stringTable[index] = value
+	}
+
+	for _, f := range bundle {
+		switch f.number {
+		case bundleFieldTraceEvents:
+			e, err := parseChromeEvent(f.bytes, stringTable)
+			if err != nil {
+				return err
+			}
+			data.Write(e)
+		case bundleFieldMetadata:
+			key, value, err := parseChromeMetadata(f.bytes)
+			if err != nil {
+				return err
+			}
+			data.SetMetadata(key, value)
+		}
+	}
+	return nil
+}
+
+// parseChromeEvent translates the event into its JSON form, so that every phase is parsed the same way that it is
+// read from Trace Event Format files
+func parseChromeEvent(b []byte, stringTable map[uint64]string) (events.Event, error) {
+	fields, err := decodeMessage(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode chrome trace event: %w", err)
+	}
+
+	j := map[string]interface{}{}
+	args := map[string]interface{}{}
+	var phase byte
+	var flags uint64
+	var id *uint64
+	for _, f := range fields {
+		switch f.number {
+		case chromeEventFieldName:
+			j["name"] = string(f.bytes)
+		case chromeEventFieldNameIndex:
+			if _, ok := j["name"]; !ok {
+				j["name"] = stringTable[f.value]
+			}
+		case chromeEventFieldTimestamp:
+			j["ts"] = f.int()
+		case chromeEventFieldPhase:
+			phase = byte(f.value)
+		case chromeEventFieldThreadId:
+			j["tid"] = int64(int32(f.value))
+		case chromeEventFieldProcessId:
+			j["pid"] = int64(int32(f.value))
+		case chromeEventFieldThreadTimestamp:
+			j["tts"] = f.int()
+		case chromeEventFieldDuration:
+			j["dur"] = f.int()
+		case chromeEventFieldThreadDuration:
+			j["tdur"] = f.int()
+		case chromeEventFieldScope:
+			j["scope"] = string(f.bytes)
+		case chromeEventFieldId:
+			value := f.value
+			id = &value
+		case chromeEventFieldFlags:
+			flags = f.value
+		case chromeEventFieldCategoryGroupName:
+			j["cat"] = string(f.bytes)
+		case chromeEventFieldCategoryGroupNameIndex:
+			if _, ok := j["cat"]; !ok {
+				j["cat"] = stringTable[f.value]
+			}
+		case chromeEventFieldArgs:
+			key, value, err := parseChromeArg(f.bytes, stringTable)
+			if err != nil {
+				return nil, err
+			}
+			args[key] = value
+		}
+	}
+
+	j["ph"] = string(rune(phase))
+	if id != nil {
+		j["id"] = fmt.Sprintf("0x%x", *id)
+	}
+	if events.Phase(j["ph"].(string)) == events.PhaseInstant || events.Phase(j["ph"].(string)) == events.PhaseInstantLegacy {
+		switch flags & chromeFlagScopeMask {
+		case chromeFlagScopeProcess:
+			j["s"] = string(events.InstantScopeProcess)
+		case chromeFlagScopeThread:
+			j["s"] = string(events.InstantScopeThread)
+		default:
+			j["s"] = string(events.InstantScopeGlobal)
+		}
+	}
+	if flags&chromeFlagBindToEnclosing != 0 {
+		j["bp"] = "e"
+	}
+	if len(args) > 0 {
+		j["args"] = args
+	}
+
+	raw, err := json.Marshal(j)
+	if err != nil {
+		return nil, fmt.Errorf("failed to translate chrome trace event: %w", err)
+	}
+	e, err := events.UnmarshalEvent(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to translate chrome trace event: %w", err)
+	}
+	return e, nil
+}
+
+func parseChromeArg(b []byte, stringTable map[uint64]string) (string, interface{}, error) {
+	fields, err := decodeMessage(b)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decode chrome trace event arg: %w", err)
+	}
+	var name string
+	var value interface{}
+	for _, f := range fields {
+		switch f.number {
+		case chromeArgFieldName:
+			name = string(f.bytes)
+		case chromeArgFieldNameIndex:
+			if name == "" {
+				name = stringTable[f.value]
+			}
+		case chromeArgFieldBoolValue:
+			value = f.value != 0
+		case chromeArgFieldUintValue:
+			value = f.value
+		case chromeArgFieldIntValue:
+			value = f.int()
+		case chromeArgFieldDoubleValue:
+			value = f.double()
+		case chromeArgFieldStringValue:
+			value = string(f.bytes)
+		case chromeArgFieldPointerValue:
+			value = fmt.Sprintf("0x%x", f.value)
+		case chromeArgFieldJsonValue:
+			value = json.RawMessage(f.bytes)
+		case chromeArgFieldTracedValue:
+			if value, err = parseTracedValue(f.bytes); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	return name, value, nil
+}
+
+func parseTracedValue(b []byte) (interface{}, error) {
+	fields, err := decodeMessage(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode chrome traced value: %w", err)
+	}
+
+	nested := false
+	isArray := false
+	var keys []string
+	var values []interface{}
+	var scalar interface{}
+	for _, f := range fields {
+		switch f.number {
+		case tracedValueFieldNestedType:
+			nested = true
+			isArray = f.value == tracedValueNestedTypeArray
+		case tracedValueFieldDictKeys:
+			keys = append(keys, string(f.bytes))
+		case tracedValueFieldDictValues, tracedValueFieldArrayValues:
+			nested = true
+			v, err := parseTracedValue(f.bytes)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		case tracedValueFieldIntValue:
+			scalar = f.int()
+		case tracedValueFieldDoubleValue:
+			scalar = f.double()
+		case tracedValueFieldBoolValue:
+			scalar = f.value != 0
+		case tracedValueFieldStringValue:
+			scalar = string(f.bytes)
+		}
+	}
+
+	switch {
+	case !nested:
+		return scalar, nil
+	case isArray:
+		if values == nil {
+			values = []interface{}{}
+		}
+		return values, nil
+	default:
+		dict := make(map[string]interface{}, len(keys))
+		for i, key := range keys {
+			if i < len(values) {
+				dict[key] = values[i]
+			}
+		}
+		return dict, nil
+	}
+}
+
+func parseChromeMetadata(b []byte) (string, interface{}, error) {
+	fields, err := decodeMessage(b)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decode chrome metadata: %w", err)
+	}
+	var name string
+	var value interface{}
+	for _, f := range fields {
+		switch f.number {
+		case chromeMetadataFieldName:
+			name = string(f.bytes)
+		case chromeMetadataFieldStringValue:
+			value = string(f.bytes)
+		case chromeMetadataFieldBoolValue:
+			value = f.value != 0
+		case chromeMetadataFieldIntValue:
+			value = f.int()
+		case chromeMetadataFieldJsonValue:
+			if err := json.Unmarshal(f.bytes, &value); err != nil {
+				return "", nil, fmt.Errorf("invalid JSON value of metadata '%s': %w", name, err)
+			}
+		}
+	}
+	return name, value, nil
+}
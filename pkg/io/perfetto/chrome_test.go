@@ -0,0 +1,134 @@
+package perfetto_test
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/perfetto"
+)
+
+// pb builds protobuf messages for test input
+type pb []byte
+
+func (m pb) varint(field int, v uint64) pb {
+	m = binary.AppendUvarint(m, uint64(field)<<3)
+	return binary.AppendUvarint(m, v)
+}
+
+func (m pb) bytes(field int, b []byte) pb {
+	m = binary.AppendUvarint(m, uint64(field)<<3|2)
+	m = binary.AppendUvarint(m, uint64(len(b)))
+	return append(m, b...)
+}
+
+func (m pb) string(field int, s string) pb {
+	return m.bytes(field, []byte(s))
+}
+
+func (m pb) message(field int, message pb) pb {
+	return m.bytes(field, message)
+}
+
+var _ = Describe("Chrome events", func() {
+	pid := int64(1)
+	tid := int64(2)
+	core := func(name string, ts int64) events.EventCore {
+		return events.EventCore{Name: name, Categories: []string{"a", "b"}, Timestamp: ts, ProcessID: &pid, ThreadID: &tid}
+	}
+	args := map[string]interface{}{"count": float64(3), "ratio": 0.5, "label": "x", "ok": true, "nested": map[string]interface{}{"k": "v"}}
+
+	It("round trips events and metadata", func() {
+		data := tio.TefData{}
+		written := []events.Event{
+			&events.MetadataProcessName{EventCore: core("process_name", 0), ProcessName: "proc"},
+			&events.BeginDuration{EventWithArgs: events.EventWithArgs{EventCore: core("work", 10), Args: args}},
+			&events.EndDuration{EventWithArgs: events.EventWithArgs{EventCore: core("work", 20)}},
+			&events.Complete{EventWithArgs: events.EventWithArgs{EventCore: core("all", 30)}, Duration: 5},
+			&events.Instant{
+				EventWithArgs: events.EventWithArgs{EventCore: core("tick", 40)},
+				Scope:         events.InstantScopeProcess,
+			},
+			&events.Counter{EventCore: core("mem", 50), Values: map[string]float64{"heap": 1.5}},
+			&events.FlowStart{EventWithArgs: events.EventWithArgs{EventCore: core("job", 60)}, Id: "0x1f", Scope: "jobs"},
+			&events.FlowFinish{EventWithArgs: events.EventWithArgs{EventCore: core("flow", 80)}, Id: "0x2"},
+		}
+		for _, e := range written {
+			data.Write(e)
+		}
+		data.SetMetadata("version", "1.0")
+
+		var buffer bytes.Buffer
+		Expect(perfetto.WriteChromeEvents(&buffer, data)).To(Succeed())
+		parsed, err := perfetto.ParseChromeEvents(&buffer)
+		Expect(err).To(Succeed())
+
+		Expect(parsed.Events()).To(Equal(written))
+		Expect(parsed.Metadata()).To(Equal(map[string]interface{}{"version": "1.0"}))
+	})
+
+	It("hashes IDs that are not numbers", func() {
+		data := tio.TefData{}
+		data.Write(&events.FlowStart{EventWithArgs: events.EventWithArgs{EventCore: core("job", 60)}, Id: "request-1"})
+
+		var buffer bytes.Buffer
+		Expect(perfetto.WriteChromeEvents(&buffer, data)).To(Succeed())
+		parsed, err := perfetto.ParseChromeEvents(&buffer)
+		Expect(err).To(Succeed())
+		Expect(parsed.Events()).To(HaveLen(1))
+		Expect(parsed.Events()[0].(*events.FlowStart).Id).To(MatchRegexp("^0x[0-9a-f]+$"))
+	})
+
+	It("parses events as written by Chrome", func() {
+		tracedValue := pb{}.
+			string(2, "depth").
+			message(3, pb{}.varint(5, 4)).
+			string(2, "list").
+			message(3, pb{}.varint(1, 1).message(4, pb{}.string(8, "a")))
+		event := pb{}.
+			varint(15, 7).
+			varint(16, 8).
+			varint(2, 100).
+			varint(3, 'I').
+			varint(11, 3).
+			varint(4, 4).
+			message(14, pb{}.varint(9, 9).varint(7, 0xbeef)).
+			message(14, pb{}.string(1, "value").message(10, tracedValue))
+		bundle := pb{}.
+			message(3, pb{}.string(1, "navigate").varint(2, 7)).
+			message(3, pb{}.string(1, "loading").varint(2, 8)).
+			message(3, pb{}.string(1, "frame").varint(2, 9)).
+			message(1, event).
+			message(2, pb{}.string(1, "cpu-count").varint(4, 8))
+		trace := pb{}.message(1, pb{}.varint(10, 1)).message(1, pb{}.message(5, bundle))
+
+		parsed, err := perfetto.ParseChromeEvents(bytes.NewReader(trace))
+		Expect(err).To(Succeed())
+
+		pid, tid := int64(3), int64(4)
+		Expect(parsed.Events()).To(Equal([]events.Event{
+			&events.Instant{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: events.EventCore{
+						Name: "navigate", Categories: []string{"loading"}, Timestamp: 100, ProcessID: &pid, ThreadID: &tid,
+					},
+					Args: map[string]interface{}{
+						"frame": "0xbeef",
+						"value": map[string]interface{}{"depth": 4.0, "list": []interface{}{"a"}},
+					},
+				},
+				Scope: events.InstantScopeGlobal,
+			},
+		}))
+		Expect(parsed.Metadata()).To(HaveKeyWithValue("cpu-count", int64(8)))
+	})
+
+	It("rejects malformed input", func() {
+		_, err := perfetto.ParseChromeEvents(bytes.NewReader([]byte{0x0a, 0xff}))
+		Expect(err).To(MatchError(perfetto.ErrMalformed))
+	})
+})
@@ -0,0 +1,13 @@
+package perfetto_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPerfetto(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Perfetto Suite")
+}
@@ -0,0 +1,133 @@
+package perfetto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+type wireType uint64
+
+const (
+	wireVarint          wireType = 0
+	wireFixed64         wireType = 1
+	wireLengthDelimited wireType = 2
+)
+
+// message is a minimal protobuf encoder, sufficient for emitting the handful of Perfetto messages teffy produces
+type message []byte
+
+func (m *message) tag(field int, wt wireType) {
+	m.varint(uint64(field)<<3 | uint64(wt))
+}
+
+func (m *message) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	*m = append(*m, buf[:n]...)
+}
+
+func (m *message) uintField(field int, v uint64) {
+	m.tag(field, wireVarint)
+	m.varint(v)
+}
+
+func (m *message) intField(field int, v int64) {
+	m.uintField(field, uint64(v))
+}
+
+func (m *message) boolField(field int, v bool) {
+	if v {
+		m.uintField(field, 1)
+	} else {
+		m.uintField(field, 0)
+	}
+}
+
+func (m *message) doubleField(field int, v float64) {
+	m.tag(field, wireFixed64)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	*m = append(*m, buf[:]...)
+}
+
+func (m *message) bytesField(field int, v []byte) {
+	m.tag(field, wireLengthDelimited)
+	m.varint(uint64(len(v)))
+	*m = append(*m, v...)
+}
+
+func (m *message) stringField(field int, v string) {
+	m.bytesField(field, []byte(v))
+}
+
+func (m *message) messageField(field int, v message) {
+	m.bytesField(field, v)
+}
+
+const wireFixed32 wireType = 5
+
+// ErrMalformed means that the input is not a well formed protobuf message
+var ErrMalformed = errors.New("malformed protobuf message")
+
+// field is a single decoded field of a protobuf message, holding either its varint or fixed value, or its bytes
+type field struct {
+	number int
+	wire   wireType
+	value  uint64
+	bytes  []byte
+}
+
+func (f field) int() int64 {
+	return int64(f.value)
+}
+
+func (f field) double() float64 {
+	return math.Float64frombits(f.value)
+}
+
+// decodeMessage is a minimal protobuf decoder, splitting a message into its fields in the order they appear
+func decodeMessage(b []byte) ([]field, error) {
+	var fields []field
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid field tag: %w", ErrMalformed)
+		}
+		b = b[n:]
+
+		f := field{number: int(tag >> 3), wire: wireType(tag & 7)}
+		switch f.wire {
+		case wireVarint:
+			f.value, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid varint in field %d: %w", f.number, ErrMalformed)
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("truncated field %d: %w", f.number, ErrMalformed)
+			}
+			f.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return nil, fmt.Errorf("truncated field %d: %w", f.number, ErrMalformed)
+			}
+			f.value = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case wireLengthDelimited:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || length > uint64(len(b)-n) {
+				return nil, fmt.Errorf("invalid length of field %d: %w", f.number, ErrMalformed)
+			}
+			f.bytes = b[n : n+int(length)]
+			b = b[n+int(length):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d of field %d: %w", f.wire, f.number, ErrMalformed)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
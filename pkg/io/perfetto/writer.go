@@ -0,0 +1,443 @@
+// perfetto provides the ability to write trace events in Perfetto's protobuf trace format
+package perfetto
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// field numbers taken from Perfetto's protos/perfetto/trace/*.proto
+const (
+	traceFieldPacket = 1
+
+	packetFieldTimestamp               = 8
+	packetFieldTrustedPacketSequenceId = 10
+	packetFieldTrackEvent              = 11
+	packetFieldSequenceFlags           = 13
+	packetFieldTrackDescriptor         = 60
+
+	trackEventFieldDebugAnnotations   = 4
+	trackEventFieldType               = 9
+	trackEventFieldTrackUuid          = 11
+	trackEventFieldCategories         = 22
+	trackEventFieldName               = 23
+	trackEventFieldDoubleCounterValue = 44
+
+	trackDescriptorFieldUuid       = 1
+	trackDescriptorFieldName       = 2
+	trackDescriptorFieldProcess    = 3
+	trackDescriptorFieldThread     = 4
+	trackDescriptorFieldParentUuid = 5
+	trackDescriptorFieldCounter    = 8
+
+	processDescriptorFieldPid         = 1
+	processDescriptorFieldProcessName = 6
+
+	threadDescriptorFieldPid        = 1
+	threadDescriptorFieldTid        = 2
+	threadDescriptorFieldThreadName = 5
+
+	debugAnnotationFieldBoolValue       = 2
+	debugAnnotationFieldIntValue        = 4
+	debugAnnotationFieldDoubleValue     = 5
+	debugAnnotationFieldStringValue     = 6
+	debugAnnotationFieldLegacyJsonValue = 9
+	debugAnnotationFieldName            = 10
+)
+
+type trackEventType uint64
+
+const (
+	trackEventTypeSliceBegin trackEventType = 1
+	trackEventTypeSliceEnd   trackEventType = 2
+	trackEventTypeInstant    trackEventType = 3
+	trackEventTypeCounter    trackEventType = 4
+)
+
+const (
+	sequenceFlagIncrementalStateCleared = 1
+	// sequenceId is the trusted packet sequence that all packets emitted by teffy belong to
+	sequenceId = 1
+	// nanosecondsPerMicrosecond converts Trace Event Format timestamps to Perfetto timestamps
+	nanosecondsPerMicrosecond = 1000
+)
+
+type encoder struct {
+	w            io.Writer
+	started      bool
+	tracks       map[uint64]struct{}
+	processNames map[int64]string
+	threadNames  map[[2]int64]string
+}
+
+func newEncoder(w io.Writer) *encoder {
+	return &encoder{
+		w:            w,
+		tracks:       map[uint64]struct{}{},
+		processNames: map[int64]string{},
+		threadNames:  map[[2]int64]string{},
+	}
+}
+
+type eventWriter struct {
+	*encoder
+	w io.WriteCloser
+}
+
+// NewWriter creates an event writer that emits events as Perfetto TracePackets to the provided io.WriteCloser as
+// they are written. Perfetto traces are a concatenation of packets, so the output is valid even if never closed.
+// Events that have no Perfetto equivalent (e.g. flow, object and memory dump events) are skipped.
+func NewWriter(w io.WriteCloser) tio.EventWriter {
+	return &eventWriter{
+		encoder: newEncoder(w),
+		w:       w,
+	}
+}
+
+// Write converts the provided event to Perfetto TracePackets and writes them to the backing io.Writer
+func (ew *eventWriter) Write(e events.Event) error {
+	return ew.encoder.write(e)
+}
+
+// Close closes the underlying stream
+func (ew *eventWriter) Close() error {
+	if err := ew.w.Close(); err != nil {
+		return fmt.Errorf("failed to close underlying writer: %w", err)
+	}
+	return nil
+}
+
+// WriteTrace converts all the events in the given data to a Perfetto protobuf trace written to the provided writer
+func WriteTrace(w io.Writer, data tio.TefData) error {
+	enc := newEncoder(w)
+	for _, e := range data.Events() {
+		if err := enc.write(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *encoder) write(e events.Event) error {
+	core := e.Core()
+	ts := nanoseconds(core.Timestamp, core.TimestampFraction)
+	pid := valueOrZero(core.ProcessID)
+	tid := valueOrZero(core.ThreadID)
+
+	switch event := e.(type) {
+	case *events.BeginDuration:
+		track, err := enc.threadTrack(pid, tid)
+		if err != nil {
+			return err
+		}
+		return enc.writeTrackEvent(track, trackEventTypeSliceBegin, ts, core, event.Args)
+	case *events.EndDuration:
+		track, err := enc.threadTrack(pid, tid)
+		if err != nil {
+			return err
+		}
+		return enc.writeTrackEvent(track, trackEventTypeSliceEnd, ts, nil, event.Args)
+	case *events.Complete:
+		track, err := enc.threadTrack(pid, tid)
+		if err != nil {
+			return err
+		}
+		if err := enc.writeTrackEvent(track, trackEventTypeSliceBegin, ts, core, event.Args); err != nil {
+			return err
+		}
+		end := ts + nanoseconds(event.Duration, event.DurationFraction)
+		return enc.writeTrackEvent(track, trackEventTypeSliceEnd, end, nil, nil)
+
+	case *events.Instant:
+		var track uint64
+		var err error
+		switch event.Scope {
+		case events.InstantScopeGlobal:
+			track, err = enc.globalTrack()
+		case events.InstantScopeProcess:
+			track, err = enc.processTrack(pid)
+		default:
+			track, err = enc.threadTrack(pid, tid)
+		}
+		if err != nil {
+			return err
+		}
+		return enc.writeTrackEvent(track, trackEventTypeInstant, ts, core, event.Args)
+
+	case *events.Counter:
+		for _, key := range event.SeriesOrder() {
+			track, err := enc.counterTrack(pid, counterName(core.Name, key))
+			if err != nil {
+				return err
+			}
+			te := message{}
+			te.uintField(trackEventFieldType, uint64(trackEventTypeCounter))
+			te.uintField(trackEventFieldTrackUuid, track)
+			te.doubleField(trackEventFieldDoubleCounterValue, event.Values[key])
+			if err := enc.writeTrackEventMessage(ts, te); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *events.AsyncBegin:
+		track, err := enc.asyncTrack(pid, core, event.Scope, event.Id)
+		if err != nil {
+			return err
+		}
+		return enc.writeTrackEvent(track, trackEventTypeSliceBegin, ts, core, event.Args)
+	case *events.AsyncInstant:
+		track, err := enc.asyncTrack(pid, core, event.Scope, event.Id)
+		if err != nil {
+			return err
+		}
+		return enc.writeTrackEvent(track, trackEventTypeInstant, ts, core, event.Args)
+	case *events.AsyncEnd:
+		track, err := enc.asyncTrack(pid, core, event.Scope, event.Id)
+		if err != nil {
+			return err
+		}
+		return enc.writeTrackEvent(track, trackEventTypeSliceEnd, ts, nil, event.Args)
+
+	case *events.MetadataProcessName:
+		enc.processNames[pid] = event.ProcessName
+		return enc.writeProcessDescriptor(pid)
+	case *events.MetadataThreadName:
+		enc.threadNames[[2]int64{pid, tid}] = event.ThreadName
+		if _, err := enc.processTrack(pid); err != nil {
+			return err
+		}
+		return enc.writeThreadDescriptor(pid, tid)
+	}
+
+	return nil
+}
+
+func counterName(name, key string) string {
+	if key == "" {
+		return name
+	}
+	return fmt.Sprintf("%s.%s", name, key)
+}
+
+func (enc *encoder) globalTrack() (uint64, error) {
+	uuid := trackUuid("global")
+	if enc.knownTrack(uuid) {
+		return uuid, nil
+	}
+	td := message{}
+	td.uintField(trackDescriptorFieldUuid, uuid)
+	td.stringField(trackDescriptorFieldName, "Global")
+	return uuid, enc.writeTrackDescriptor(td)
+}
+
+func (enc *encoder) processTrack(pid int64) (uint64, error) {
+	uuid := trackUuid("process", pid)
+	if enc.knownTrack(uuid) {
+		return uuid, nil
+	}
+	return uuid, enc.writeProcessDescriptor(pid)
+}
+
+func (enc *encoder) writeProcessDescriptor(pid int64) error {
+	uuid := trackUuid("process", pid)
+	enc.tracks[uuid] = struct{}{}
+
+	pd := message{}
+	pd.intField(processDescriptorFieldPid, pid)
+	if name, ok := enc.processNames[pid]; ok {
+		pd.stringField(processDescriptorFieldProcessName, name)
+	}
+
+	td := message{}
+	td.uintField(trackDescriptorFieldUuid, uuid)
+	td.messageField(trackDescriptorFieldProcess, pd)
+	return enc.writeTrackDescriptor(td)
+}
+
+func (enc *encoder) threadTrack(pid, tid int64) (uint64, error) {
+	uuid := trackUuid("thread", pid, tid)
+	if enc.knownTrack(uuid) {
+		return uuid, nil
+	}
+	if _, err := enc.processTrack(pid); err != nil {
+		return 0, err
+	}
+	return uuid, enc.writeThreadDescriptor(pid, tid)
+}
+
+func (enc *encoder) writeThreadDescriptor(pid, tid int64) error {
+	uuid := trackUuid("thread", pid, tid)
+	enc.tracks[uuid] = struct{}{}
+
+	thd := message{}
+	thd.intField(threadDescriptorFieldPid, pid)
+	thd.intField(threadDescriptorFieldTid, tid)
+	if name, ok := enc.threadNames[[2]int64{pid, tid}]; ok {
+		thd.stringField(threadDescriptorFieldThreadName, name)
+	}
+
+	td := message{}
+	td.uintField(trackDescriptorFieldUuid, uuid)
+	td.uintField(trackDescriptorFieldParentUuid, trackUuid("process", pid))
+	td.messageField(trackDescriptorFieldThread, thd)
+	return enc.writeTrackDescriptor(td)
+}
+
+func (enc *encoder) counterTrack(pid int64, name string) (uint64, error) {
+	uuid := trackUuid("counter", pid, name)
+	if enc.knownTrack(uuid) {
+		return uuid, nil
+	}
+	parent, err := enc.processTrack(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	td := message{}
+	td.uintField(trackDescriptorFieldUuid, uuid)
+	td.uintField(trackDescriptorFieldParentUuid, parent)
+	td.stringField(trackDescriptorFieldName, name)
+	td.messageField(trackDescriptorFieldCounter, message{})
+	return uuid, enc.writeTrackDescriptor(td)
+}
+
+func (enc *encoder) asyncTrack(pid int64, core *events.EventCore, scope, id string) (uint64, error) {
+	categories := strings.Join(core.Categories, ",")
+	uuid := trackUuid("async", pid, categories, core.Name, scope, id)
+	if enc.knownTrack(uuid) {
+		return uuid, nil
+	}
+	parent, err := enc.processTrack(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	td := message{}
+	td.uintField(trackDescriptorFieldUuid, uuid)
+	td.uintField(trackDescriptorFieldParentUuid, parent)
+	td.stringField(trackDescriptorFieldName, core.Name)
+	return uuid, enc.writeTrackDescriptor(td)
+}
+
+// knownTrack reports whether the track has already been described, marking it as described if not
+func (enc *encoder) knownTrack(uuid uint64) bool {
+	_, ok := enc.tracks[uuid]
+	if !ok {
+		enc.tracks[uuid] = struct{}{}
+	}
+	return ok
+}
+
+func (enc *encoder) writeTrackDescriptor(td message) error {
+	packet := message{}
+	packet.messageField(packetFieldTrackDescriptor, td)
+	return enc.writePacket(packet)
+}
+
+// trackUuid derives a stable identifier for a track from the components that uniquely identify it
+func trackUuid(components ...interface{}) uint64 {
+	h := fnv.New64a()
+	for _, c := range components {
+		_, _ = fmt.Fprintf(h, "%v\x00", c)
+	}
+	return h.Sum64()
+}
+
+func valueOrZero(v *int64) int64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+func debugAnnotations(args map[string]interface{}) ([]message, error) {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	annotations := make([]message, 0, len(keys))
+	for _, key := range keys {
+		da := message{}
+		da.stringField(debugAnnotationFieldName, key)
+		switch v := args[key].(type) {
+		case bool:
+			da.boolField(debugAnnotationFieldBoolValue, v)
+		case int:
+			da.intField(debugAnnotationFieldIntValue, int64(v))
+		case int64:
+			da.intField(debugAnnotationFieldIntValue, v)
+		case float64:
+			da.doubleField(debugAnnotationFieldDoubleValue, v)
+		case string:
+			da.stringField(debugAnnotationFieldStringValue, v)
+		default:
+			j, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode argument '%s': %w", key, err)
+			}
+			da.stringField(debugAnnotationFieldLegacyJsonValue, string(j))
+		}
+		annotations = append(annotations, da)
+	}
+
+	return annotations, nil
+}
+
+func (enc *encoder) writeTrackEvent(track uint64, t trackEventType, ts int64, core *events.EventCore, args map[string]interface{}) error {
+	te := message{}
+	te.uintField(trackEventFieldType, uint64(t))
+	te.uintField(trackEventFieldTrackUuid, track)
+	if core != nil {
+		te.stringField(trackEventFieldName, core.Name)
+		for _, category := range core.Categories {
+			te.stringField(trackEventFieldCategories, category)
+		}
+	}
+	annotations, err := debugAnnotations(args)
+	if err != nil {
+		return err
+	}
+	for _, annotation := range annotations {
+		te.messageField(trackEventFieldDebugAnnotations, annotation)
+	}
+	return enc.writeTrackEventMessage(ts, te)
+}
+
+// nanoseconds converts a Trace Event Format time in microseconds, and any fraction of a microsecond, to a Perfetto
+// time in nanoseconds
+func nanoseconds(whole int64, fraction float64) int64 {
+	return whole*nanosecondsPerMicrosecond + int64(math.Round(fraction*nanosecondsPerMicrosecond))
+}
+
+func (enc *encoder) writeTrackEventMessage(ts int64, te message) error {
+	packet := message{}
+	packet.uintField(packetFieldTimestamp, uint64(ts))
+	packet.messageField(packetFieldTrackEvent, te)
+	return enc.writePacket(packet)
+}
+
+func (enc *encoder) writePacket(packet message) error {
+	packet.uintField(packetFieldTrustedPacketSequenceId, sequenceId)
+	if !enc.started {
+		packet.uintField(packetFieldSequenceFlags, sequenceFlagIncrementalStateCleared)
+		enc.started = true
+	}
+
+	trace := message{}
+	trace.messageField(traceFieldPacket, packet)
+	if _, err := enc.w.Write(trace); err != nil {
+		return fmt.Errorf("failed to write trace packet: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package perfetto_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/perfetto"
+)
+
+var _ = Describe("WriteTrace", func() {
+	var data tio.TefData
+	var packets []fields
+	var err error
+
+	BeforeEach(func() {
+		data = tio.TefData{}
+		packets = nil
+	})
+
+	JustBeforeEach(func() {
+		var buffer bytes.Buffer
+		err = perfetto.WriteTrace(&buffer, data)
+		for _, packet := range decode(buffer.Bytes())[1] {
+			packets = append(packets, decode(packet.([]byte)))
+		}
+	})
+
+	When("there are no events", func() {
+		It("produces an empty trace", func() {
+			Expect(err).To(Succeed())
+			Expect(packets).To(BeEmpty())
+		})
+	})
+
+	When("a duration is written", func() {
+		BeforeEach(func() {
+			pid := int64(1)
+			tid := int64(2)
+			data.Write(&events.BeginDuration{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: events.EventCore{
+						Name:       "work",
+						Categories: []string{"cat"},
+						Timestamp:  10,
+						ProcessID:  &pid,
+						ThreadID:   &tid,
+					},
+					Args: map[string]interface{}{
+						"count": float64(3),
+					},
+				},
+			})
+			data.Write(&events.EndDuration{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: events.EventCore{
+						Name:      "work",
+						Timestamp: 25,
+						ProcessID: &pid,
+						ThreadID:  &tid,
+					},
+				},
+			})
+		})
+
+		It("describes the process and thread tracks before the events", func() {
+			Expect(err).To(Succeed())
+			Expect(packets).To(HaveLen(4))
+
+			process := decode(packets[0][60][0].([]byte))
+			Expect(decode(process[3][0].([]byte))[1]).To(Equal([]interface{}{uint64(1)}))
+
+			thread := decode(packets[1][60][0].([]byte))
+			Expect(thread[5]).To(Equal(process[1]))
+			threadDescriptor := decode(thread[4][0].([]byte))
+			Expect(threadDescriptor[1]).To(Equal([]interface{}{uint64(1)}))
+			Expect(threadDescriptor[2]).To(Equal([]interface{}{uint64(2)}))
+		})
+
+		It("emits slice begin and end events in nanoseconds", func() {
+			Expect(err).To(Succeed())
+			Expect(packets).To(HaveLen(4))
+			thread := decode(packets[1][60][0].([]byte))
+
+			Expect(packets[2][8]).To(Equal([]interface{}{uint64(10000)}))
+			begin := decode(packets[2][11][0].([]byte))
+			Expect(begin[9]).To(Equal([]interface{}{uint64(1)}))
+			Expect(begin[11]).To(Equal(thread[1]))
+			Expect(begin[23]).To(Equal([]interface{}{[]byte("work")}))
+			Expect(begin[22]).To(Equal([]interface{}{[]byte("cat")}))
+			annotation := decode(begin[4][0].([]byte))
+			Expect(annotation[10]).To(Equal([]interface{}{[]byte("count")}))
+			Expect(annotation[5]).To(Equal([]interface{}{math.Float64bits(3)}))
+
+			Expect(packets[3][8]).To(Equal([]interface{}{uint64(25000)}))
+			end := decode(packets[3][11][0].([]byte))
+			Expect(end[9]).To(Equal([]interface{}{uint64(2)}))
+			Expect(end[11]).To(Equal(thread[1]))
+		})
+
+		It("marks the first packet as clearing incremental state", func() {
+			Expect(err).To(Succeed())
+			Expect(packets[0][13]).To(Equal([]interface{}{uint64(1)}))
+			Expect(packets[1][13]).To(BeEmpty())
+			for _, packet := range packets {
+				Expect(packet[10]).To(Equal([]interface{}{uint64(1)}))
+			}
+		})
+	})
+
+	When("a complete event has fractions of a microsecond", func() {
+		BeforeEach(func() {
+			data.Write(&events.Complete{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: events.EventCore{Name: "work", Timestamp: 1, TimestampFraction: 0.25},
+				},
+				Duration:         2,
+				DurationFraction: 0.5,
+			})
+		})
+
+		It("keeps them in the nanoseconds of its slice", func() {
+			Expect(err).To(Succeed())
+			Expect(packets).To(HaveLen(4))
+			Expect(packets[2][8]).To(Equal([]interface{}{uint64(1250)}))
+			Expect(packets[3][8]).To(Equal([]interface{}{uint64(3750)}))
+		})
+	})
+
+	When("a counter is written", func() {
+		BeforeEach(func() {
+			data.Write(&events.Counter{
+				EventCore: events.EventCore{
+					Name:      "mem",
+					Timestamp: 1,
+				},
+				Values: map[string]float64{
+					"heap":  5,
+					"stack": 2,
+				},
+			})
+		})
+
+		It("emits a counter track and value per series", func() {
+			Expect(err).To(Succeed())
+			Expect(packets).To(HaveLen(5))
+			heapTrack := decode(packets[1][60][0].([]byte))
+			Expect(heapTrack[2]).To(Equal([]interface{}{[]byte("mem.heap")}))
+			heapValue := decode(packets[2][11][0].([]byte))
+			Expect(heapValue[9]).To(Equal([]interface{}{uint64(4)}))
+			Expect(heapValue[11]).To(Equal(heapTrack[1]))
+			Expect(heapValue[44]).To(Equal([]interface{}{math.Float64bits(5)}))
+		})
+	})
+
+	When("unsupported events are written", func() {
+		BeforeEach(func() {
+			data.Write(&events.Mark{})
+		})
+
+		It("skips them", func() {
+			Expect(err).To(Succeed())
+			Expect(packets).To(BeEmpty())
+		})
+	})
+})
+
+// fields is a loosely decoded protobuf message, mapping field numbers to their uint64 or []byte values
+type fields map[int][]interface{}
+
+func decode(b []byte) fields {
+	result := fields{}
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			b = b[n:]
+			result[field] = append(result[field], v)
+		case 1:
+			result[field] = append(result[field], binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			b = b[n:]
+			result[field] = append(result[field], b[:l])
+			b = b[l:]
+		default:
+			panic(fmt.Sprintf("unexpected wire type in test data: %v", key&7))
+		}
+	}
+	return result
+}
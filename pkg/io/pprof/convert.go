@@ -0,0 +1,192 @@
+// pprof provides conversion of pprof profiles into trace events, allowing profiles to be viewed in trace viewers
+package pprof
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+const (
+	// ProcessID is the process ID attributed to all events converted from a profile
+	ProcessID = int64(1)
+	// ThreadID is the thread ID attributed to all events converted from a profile
+	ThreadID = int64(1)
+	// Category is the category given to all events converted from a profile
+	Category = "pprof"
+)
+
+// Parse reads a pprof protobuf profile (optionally gzipped) from the provided reader and converts it, see Convert
+func Parse(r io.Reader) (*tio.TefData, error) {
+	p, err := profile.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse profile: %w", err)
+	}
+	return Convert(p)
+}
+
+// Convert lays out the samples of a profile, such as a CPU profile, as a flame chart of synthetic Complete events.
+// Samples are merged by call stack, with each function in a stack becoming a Complete event whose duration is the
+// total value of the samples it appears in, nested within the event of its caller. The stack of every event is
+// recorded in the returned data's stack frames. Values of the default sample type are used, if their unit is a unit
+// of time they are converted to microseconds, otherwise they are used as if they were already microseconds.
+func Convert(p *profile.Profile) (*tio.TefData, error) {
+	valueIndex, scale, err := chooseSampleType(p)
+	if err != nil {
+		return nil, err
+	}
+
+	root := newCallNode("", "")
+	for _, sample := range p.Sample {
+		value := int64(float64(sample.Value[valueIndex]) * scale)
+		if value <= 0 {
+			continue
+		}
+
+		node := root
+		node.value += value
+		// locations are ordered from the most recently called, and lines within a location from the most inlined
+		for i := len(sample.Location) - 1; i >= 0; i-- {
+			lines := sample.Location[i].Line
+			for j := len(lines) - 1; j >= 0; j-- {
+				var name, file string
+				if fn := lines[j].Function; fn != nil {
+					name, file = fn.Name, fn.Filename
+				}
+				node = node.child(name, file)
+				node.value += value
+			}
+			if len(lines) < 1 {
+				node = node.child(fmt.Sprintf("0x%x", sample.Location[i].Address), "")
+				node.value += value
+			}
+		}
+	}
+
+	data := &tio.TefData{}
+	data.Write(events.NewProcessName(ProcessID, "pprof"))
+	data.Write(events.NewThreadName(ProcessID, ThreadID, sampleTypeName(p.SampleType[valueIndex])))
+
+	l := layout{data: data}
+	l.emitChildren(root, p.TimeNanos/1000, "")
+
+	return data, nil
+}
+
+// chooseSampleType finds the index of the sample value to use, and the factor to convert its unit to microseconds
+func chooseSampleType(p *profile.Profile) (int, float64, error) {
+	if len(p.SampleType) < 1 {
+		return 0, 0, fmt.Errorf("profile has no sample types")
+	}
+
+	index := len(p.SampleType) - 1
+	if p.DefaultSampleType != "" {
+		for i, st := range p.SampleType {
+			if st.Type == p.DefaultSampleType {
+				index = i
+			}
+		}
+	}
+
+	scale := 1.0
+	switch p.SampleType[index].Unit {
+	case "nanoseconds":
+		scale = 1e-3
+	case "milliseconds":
+		scale = 1e3
+	case "seconds":
+		scale = 1e6
+	}
+
+	return index, scale, nil
+}
+
+func sampleTypeName(st *profile.ValueType) string {
+	return fmt.Sprintf("%s/%s", st.Type, st.Unit)
+}
+
+type callNode struct {
+	name     string
+	file     string
+	value    int64
+	children map[string]*callNode
+}
+
+func newCallNode(name, file string) *callNode {
+	return &callNode{
+		name:     name,
+		file:     file,
+		children: map[string]*callNode{},
+	}
+}
+
+func (n *callNode) child(name, file string) *callNode {
+	c, ok := n.children[name]
+	if !ok {
+		c = newCallNode(name, file)
+		n.children[name] = c
+	}
+	return c
+}
+
+type layout struct {
+	data        *tio.TefData
+	nextFrameID int
+}
+
+// emitChildren writes events for the children of the given node one after another starting at the given timestamp,
+// children are ordered by name so that the output is deterministic, much like a flame graph
+func (l *layout) emitChildren(n *callNode, start int64, parentFrameID string) {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ts := start
+	for _, name := range names {
+		child := n.children[name]
+
+		frameID := strconv.Itoa(l.nextFrameID)
+		l.nextFrameID++
+		l.data.SetStackFrame(frameID, &events.StackFrame{
+			Category: child.file,
+			Name:     child.name,
+			Parent:   parentFrameID,
+		})
+
+		var childValue int64
+		for _, grandchild := range child.children {
+			childValue += grandchild.value
+		}
+
+		pid, tid := ProcessID, ThreadID
+		l.data.Write(&events.Complete{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{
+					Name:       child.name,
+					Categories: []string{Category},
+					Timestamp:  ts,
+					ProcessID:  &pid,
+					ThreadID:   &tid,
+				},
+				Args: map[string]interface{}{
+					"self": child.value - childValue,
+				},
+			},
+			EventStackTrace: events.EventStackTrace{
+				StackFrameID: frameID,
+			},
+			Duration: child.value,
+		})
+
+		l.emitChildren(child, ts, frameID)
+		ts += child.value
+	}
+}
@@ -0,0 +1,83 @@
+package pprof_test
+
+import (
+	"bytes"
+
+	"github.com/google/pprof/profile"
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/pprof"
+)
+
+var _ = Describe("Parse", func() {
+	var data *tio.TefData
+	var err error
+
+	BeforeEach(func() {
+		mainFn := &profile.Function{ID: 1, Name: "main", Filename: "main.go"}
+		workFn := &profile.Function{ID: 2, Name: "work", Filename: "work.go"}
+		sleepFn := &profile.Function{ID: 3, Name: "sleep", Filename: "work.go"}
+		mainLoc := &profile.Location{ID: 1, Line: []profile.Line{{Function: mainFn}}}
+		workLoc := &profile.Location{ID: 2, Line: []profile.Line{{Function: workFn}}}
+		sleepLoc := &profile.Location{ID: 3, Line: []profile.Line{{Function: sleepFn}}}
+
+		p := &profile.Profile{
+			SampleType: []*profile.ValueType{
+				{Type: "samples", Unit: "count"},
+				{Type: "cpu", Unit: "nanoseconds"},
+			},
+			TimeNanos: 5000,
+			Sample: []*profile.Sample{
+				{Location: []*profile.Location{workLoc, mainLoc}, Value: []int64{1, 3000}},
+				{Location: []*profile.Location{sleepLoc, workLoc, mainLoc}, Value: []int64{1, 2000}},
+				{Location: []*profile.Location{mainLoc}, Value: []int64{1, 1000}},
+			},
+			Location: []*profile.Location{mainLoc, workLoc, sleepLoc},
+			Function: []*profile.Function{mainFn, workFn, sleepFn},
+		}
+
+		var buffer bytes.Buffer
+		Expect(p.Write(&buffer)).To(Succeed())
+		data, err = pprof.Parse(&buffer)
+	})
+
+	It("lays out merged stacks as nested complete events", func() {
+		Expect(err).To(Succeed())
+		var completes []*events.Complete
+		for _, e := range data.Events() {
+			if c, ok := e.(*events.Complete); ok {
+				completes = append(completes, c)
+			}
+		}
+		Expect(completes).To(HaveLen(3))
+
+		Expect(completes[0].Name).To(Equal("main"))
+		Expect(completes[0].Timestamp).To(BeNumerically("==", 5))
+		Expect(completes[0].Duration).To(BeNumerically("==", 6))
+		Expect(completes[0].Args).To(HaveKeyWithValue("self", int64(1)))
+
+		Expect(completes[1].Name).To(Equal("work"))
+		Expect(completes[1].Timestamp).To(BeNumerically("==", 5))
+		Expect(completes[1].Duration).To(BeNumerically("==", 5))
+		Expect(completes[1].Args).To(HaveKeyWithValue("self", int64(3)))
+
+		Expect(completes[2].Name).To(Equal("sleep"))
+		Expect(completes[2].Duration).To(BeNumerically("==", 2))
+	})
+
+	It("records the stack of each event in the stack frames", func() {
+		Expect(err).To(Succeed())
+		sleep := data.Events()[len(data.Events())-1].(*events.Complete)
+		frame := data.StackFrames()[sleep.StackFrameID]
+		Expect(frame.Name).To(Equal("sleep"))
+		Expect(frame.Category).To(Equal("work.go"))
+		parent := data.StackFrames()[frame.Parent]
+		Expect(parent.Name).To(Equal("work"))
+		root := data.StackFrames()[parent.Parent]
+		Expect(root.Name).To(Equal("main"))
+		Expect(root.Parent).To(Equal(""))
+	})
+})
@@ -0,0 +1,65 @@
+package io
+
+import (
+	"fmt"
+	"io"
+)
+
+// Progress describes how far parsing has got through its input
+type Progress struct {
+	// BytesRead is how many bytes of the input have been read
+	BytesRead int64
+	// Events is how many events have been parsed
+	Events int
+}
+
+// WithProgress has the callback told of how far parsing has got each time more of the input is read, and once more
+// when parsing finishes, such as to show a progress bar. The callback is called by the goroutine doing the parsing, so
+// should return quickly.
+func WithProgress(callback func(Progress)) ParseOption {
+	return func(p *parser) {
+		p.progress = callback
+	}
+}
+
+// reader wraps the input so that reading it reports progress and stops once parsing is cancelled, if need be
+func (p *parser) reader(r io.Reader) io.Reader {
+	if p.ctx == nil && p.progress == nil {
+		return r
+	}
+	return &progressReader{r: r, p: p}
+}
+
+// cancelled returns an error if parsing has been cancelled
+func (p *parser) cancelled() error {
+	if p.ctx == nil {
+		return nil
+	}
+	if err := p.ctx.Err(); err != nil {
+		return fmt.Errorf("parsing cancelled: %w", err)
+	}
+	return nil
+}
+
+func (p *parser) report() {
+	if p.progress != nil {
+		p.progress(Progress{BytesRead: p.bytesRead, Events: p.index})
+	}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *parser
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	if err := r.p.cancelled(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(b)
+	if n > 0 {
+		r.p.bytesRead += int64(n)
+		r.p.report()
+	}
+	return n, err
+}
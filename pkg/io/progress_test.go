@@ -0,0 +1,83 @@
+package io_test
+
+import (
+	"context"
+	"strings"
+	"testing/iotest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Parsing progress and cancellation", func() {
+	const array = `[{"ph":"i","name":"a","ts":1},{"ph":"i","name":"b","ts":2},{"ph":"i","name":"c","ts":3}]`
+	const object = `{"traceEvents":` + array + `,"displayTimeUnit":"ns"}`
+
+	It("reports how much has been read and parsed", func() {
+		var reports []teffyio.Progress
+		record := teffyio.WithProgress(func(p teffyio.Progress) {
+			reports = append(reports, p)
+		})
+		data, err := teffyio.ParseJsonArray(iotest.HalfReader(strings.NewReader(array)), record)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data.Events()).To(HaveLen(3))
+		Expect(len(reports)).To(BeNumerically(">", 2))
+		for i := 1; i < len(reports); i++ {
+			Expect(reports[i].BytesRead).To(BeNumerically(">=", reports[i-1].BytesRead))
+			Expect(reports[i].Events).To(BeNumerically(">=", reports[i-1].Events))
+		}
+		Expect(reports[len(reports)-1]).To(Equal(teffyio.Progress{BytesRead: int64(len(array)), Events: 3}))
+	})
+
+	It("reports the progress of JSON Lines files", func() {
+		var last teffyio.Progress
+		lines := "{\"ph\":\"i\",\"name\":\"a\",\"ts\":1}\n{\"ph\":\"i\",\"name\":\"b\",\"ts\":2}\n"
+		_, err := teffyio.ParseJsonLines(strings.NewReader(lines), teffyio.WithProgress(func(p teffyio.Progress) {
+			last = p
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(last).To(Equal(teffyio.Progress{BytesRead: int64(len(lines)), Events: 2}))
+	})
+
+	It("parses as usual with a context that is not cancelled", func() {
+		data, err := teffyio.ParseJsonArrayCtx(context.Background(), strings.NewReader(array))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data.Events()).To(HaveLen(3))
+
+		data, err = teffyio.ParseJsonObjCtx(context.Background(), strings.NewReader(object))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data.Events()).To(HaveLen(3))
+		Expect(data.DisplayTimeUnit()).To(Equal(teffyio.DisplayTimeNs))
+	})
+
+	It("stops once the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelAfterFirst := teffyio.WithProgress(func(p teffyio.Progress) {
+			if p.Events > 0 {
+				cancel()
+			}
+		})
+
+		data, err := teffyio.ParseJsonArrayCtx(ctx, iotest.OneByteReader(strings.NewReader(array)), cancelAfterFirst)
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(data).To(BeNil())
+
+		data, err = teffyio.ParseJsonObjCtx(ctx, strings.NewReader(object))
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(data).To(BeNil())
+	})
+
+	It("stops when the array read so far looks truncated", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelAfterFirst := teffyio.WithProgress(func(p teffyio.Progress) {
+			if p.BytesRead >= int64(strings.Index(array, ",")+1) {
+				cancel()
+			}
+		})
+
+		_, err := teffyio.ParseJsonArrayCtx(ctx, iotest.OneByteReader(strings.NewReader(array)), cancelAfterFirst)
+		Expect(err).To(MatchError(context.Canceled))
+	})
+})
@@ -0,0 +1,219 @@
+package io
+
+import (
+	"sync"
+	"time"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// DroppedEventsName is the name of the instant events a RateLimitWriter writes to summarise the events it dropped
+const DroppedEventsName = "teffy: events dropped"
+
+// RateLimitOption configures the limits of a RateLimitWriter
+type RateLimitOption = func(lw *RateLimitWriter)
+
+// WithMaxEventsPerSecond limits how many events are written in each second, the events beyond the limit being dropped
+// until the next second begins
+func WithMaxEventsPerSecond(n int) RateLimitOption {
+	return func(lw *RateLimitWriter) {
+		lw.maxPerSecond = n
+	}
+}
+
+// WithMaxBytes limits the total size of the events written, as encoded in JSON, the events beyond the limit all being
+// dropped. The summaries of dropped events are written regardless of the limit.
+func WithMaxBytes(n int64) RateLimitOption {
+	return func(lw *RateLimitWriter) {
+		lw.maxBytes = n
+	}
+}
+
+// WithDroppedEventsByName has the summaries of dropped events count the dropped events of each name, and total the
+// durations of those that are Complete events, rather than only counting them
+func WithDroppedEventsByName() RateLimitOption {
+	return func(lw *RateLimitWriter) {
+		lw.byName = true
+	}
+}
+
+// WithRateLimitClock sets the clock the seconds of WithMaxEventsPerSecond are measured by, such as for tests
+func WithRateLimitClock(now func() time.Time) RateLimitOption {
+	return func(lw *RateLimitWriter) {
+		lw.now = now
+	}
+}
+
+// RateLimitWriter is an EventWriter that protects another EventWriter, and whatever it writes to, from pathological
+// instrumentation, such as a tight loop tracing every iteration, by dropping the events beyond its limits. When an
+// event is written after a second in which events were dropped, and when the writer is closed, it writes a process
+// scoped Instant event named DroppedEventsName summarising what was dropped in its args: "dropped" counts the events,
+// "rate_limited" and "over_quota" count those dropped for exceeding WithMaxEventsPerSecond and WithMaxBytes, and with
+// WithDroppedEventsByName "by_name" counts them by name and "duration_us" totals their durations by name. Metadata
+// events, which name processes and threads, and stack frames are never dropped. RateLimitWriter is safe for concurrent
+// use.
+type RateLimitWriter struct {
+	decoratedWriter
+	maxPerSecond int
+	maxBytes     int64
+	byName       bool
+	now          func() time.Time
+
+	lock        sync.Mutex
+	windowStart time.Time
+	inWindow    int
+	bytes       int64
+	buf         []byte
+	dropped     uint64
+	pending     *droppedEvents
+}
+
+// droppedEvents is what has been dropped since the last summary was written, the summary being placed where the last
+// event dropped was. The place is copied rather than the event kept, as events may be reused once written.
+type droppedEvents struct {
+	timestamp   int64
+	pid, tid    *int64
+	rateLimited int
+	overQuota   int
+	names       map[string]int
+	durations   map[string]int64
+}
+
+// NewRateLimitWriter creates a RateLimitWriter that writes the events within its limits to inner, without any limits
+// unless some are given
+func NewRateLimitWriter(inner EventWriter, options ...RateLimitOption) *RateLimitWriter {
+	lw := &RateLimitWriter{
+		decoratedWriter: decoratedWriter{inner: inner},
+		now:             time.Now,
+	}
+	for _, opt := range options {
+		opt(lw)
+	}
+	return lw
+}
+
+// Write writes the event to the inner writer if it is within the limits, otherwise dropping it
+func (lw *RateLimitWriter) Write(e events.Event) error {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+
+	if e.Phase() == events.PhaseMetadata {
+		return lw.inner.Write(e)
+	}
+
+	now := lw.now()
+	if lw.windowStart.IsZero() || now.Sub(lw.windowStart) >= time.Second {
+		if err := lw.writeSummary(); err != nil {
+			return err
+		}
+		lw.windowStart = now
+		lw.inWindow = 0
+	}
+	if lw.maxPerSecond > 0 && lw.inWindow >= lw.maxPerSecond {
+		lw.drop(e).rateLimited++
+		return nil
+	}
+
+	if lw.maxBytes > 0 {
+		if lw.bytes >= lw.maxBytes {
+			lw.drop(e).overQuota++
+			return nil
+		}
+		var err error
+		if lw.buf, err = events.AppendEvent(lw.buf[:0], e); err != nil {
+			return err
+		}
+		if lw.bytes+int64(len(lw.buf)) > lw.maxBytes {
+			lw.bytes = lw.maxBytes
+			lw.drop(e).overQuota++
+			return nil
+		}
+		lw.bytes += int64(len(lw.buf))
+	}
+
+	lw.inWindow++
+	return lw.inner.Write(e)
+}
+
+// Dropped reports how many events have been dropped for exceeding the limits
+func (lw *RateLimitWriter) Dropped() uint64 {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	return lw.dropped
+}
+
+// Close writes the summary of any events dropped since the last summary and then closes the inner writer
+func (lw *RateLimitWriter) Close() error {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+
+	err := lw.writeSummary()
+	if closeErr := lw.inner.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// drop records that the event was dropped, returning the record for the reason to be counted
+func (lw *RateLimitWriter) drop(e events.Event) *droppedEvents {
+	lw.dropped++
+	if lw.pending == nil {
+		lw.pending = &droppedEvents{}
+	}
+	p := lw.pending
+	core := e.Core()
+	p.timestamp, p.pid, p.tid = core.Timestamp, copyID(core.ProcessID), copyID(core.ThreadID)
+	if lw.byName {
+		if p.names == nil {
+			p.names = map[string]int{}
+			p.durations = map[string]int64{}
+		}
+		name := core.Name
+		p.names[name]++
+		if complete, ok := e.(*events.Complete); ok {
+			p.durations[name] += complete.Duration
+		}
+	}
+	return p
+}
+
+// writeSummary writes an instant event summarising the events dropped since the last summary, if there were any
+func (lw *RateLimitWriter) writeSummary() error {
+	p := lw.pending
+	if p == nil {
+		return nil
+	}
+	lw.pending = nil
+
+	args := map[string]interface{}{
+		"dropped":      p.rateLimited + p.overQuota,
+		"rate_limited": p.rateLimited,
+		"over_quota":   p.overQuota,
+	}
+	if lw.byName {
+		args["by_name"] = p.names
+		args["duration_us"] = p.durations
+	}
+	summary := &events.Instant{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{
+				Name:      DroppedEventsName,
+				Timestamp: p.timestamp,
+				ProcessID: p.pid,
+				ThreadID:  p.tid,
+			},
+			Args: args,
+		},
+		Scope: events.InstantScopeProcess,
+	}
+	return lw.inner.Write(summary)
+}
+
+// copyID copies the process or thread ID, if there is one
+func copyID(id *int64) *int64 {
+	if id == nil {
+		return nil
+	}
+	copied := *id
+	return &copied
+}
@@ -0,0 +1,103 @@
+package io_test
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("RateLimitWriter", func() {
+	var (
+		ring *teffyio.RingWriter
+		now  time.Time
+	)
+
+	clock := func() time.Time { return now }
+	complete := func(name string, ts, dur int64) events.Event {
+		pid := int64(1)
+		return &events.Complete{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: name, Timestamp: ts, ProcessID: &pid}},
+			Duration:      dur,
+		}
+	}
+	names := func() []string {
+		result := []string{}
+		for _, e := range ring.Events() {
+			result = append(result, e.Core().Name)
+		}
+		return result
+	}
+	summaryArgs := func(e events.Event) map[string]interface{} {
+		Expect(e.Core().Name).To(Equal(teffyio.DroppedEventsName))
+		// the args are compared as they are written
+		raw, err := json.Marshal(e.(*events.Instant).Args)
+		Expect(err).To(Succeed())
+		var args map[string]interface{}
+		Expect(json.Unmarshal(raw, &args)).To(Succeed())
+		return args
+	}
+
+	BeforeEach(func() {
+		ring = teffyio.NewRingWriter(100)
+		now = time.Unix(1000, 0)
+	})
+
+	It("drops the events beyond the rate, summarising them once the second is over", func() {
+		writer := teffyio.NewRateLimitWriter(ring, teffyio.WithMaxEventsPerSecond(2), teffyio.WithRateLimitClock(clock))
+		for i, name := range []string{"a", "b", "c", "d"} {
+			Expect(writer.Write(complete(name, int64(i), 1))).To(Succeed())
+		}
+		Expect(writer.Write(events.NewProcessName(1, "server"))).To(Succeed())
+		Expect(names()).To(Equal([]string{"process_name", "a", "b"}))
+		Expect(writer.Dropped()).To(BeEquivalentTo(2))
+
+		now = now.Add(time.Second)
+		Expect(writer.Write(complete("e", 10, 1))).To(Succeed())
+		Expect(names()).To(Equal([]string{"process_name", "a", "b", teffyio.DroppedEventsName, "e"}))
+		summary := ring.Events()[3]
+		Expect(summary.Core().Timestamp).To(BeEquivalentTo(3))
+		Expect(*summary.Core().ProcessID).To(BeEquivalentTo(1))
+		Expect(summaryArgs(summary)).To(Equal(map[string]interface{}{
+			"dropped": 2.0, "rate_limited": 2.0, "over_quota": 0.0,
+		}))
+
+		Expect(writer.Close()).To(Succeed())
+		Expect(ring.Events()).To(HaveLen(5))
+	})
+
+	It("drops every event beyond the quota, summarising them when closed", func() {
+		size, err := events.MarshalEvent(complete("a", 0, 1))
+		Expect(err).To(Succeed())
+		writer := teffyio.NewRateLimitWriter(ring, teffyio.WithMaxBytes(int64(len(size)*2)),
+			teffyio.WithDroppedEventsByName(), teffyio.WithRateLimitClock(clock))
+		for _, name := range []string{"a", "b", "c", "d", "c"} {
+			Expect(writer.Write(complete(name, 0, 5))).To(Succeed())
+		}
+		Expect(names()).To(Equal([]string{"a", "b"}))
+
+		Expect(writer.Close()).To(Succeed())
+		Expect(names()).To(Equal([]string{"a", "b", teffyio.DroppedEventsName}))
+		Expect(summaryArgs(ring.Events()[2])).To(Equal(map[string]interface{}{
+			"dropped":      3.0,
+			"rate_limited": 0.0,
+			"over_quota":   3.0,
+			"by_name":      map[string]interface{}{"c": 2.0, "d": 1.0},
+			"duration_us":  map[string]interface{}{"c": 10.0, "d": 5.0},
+		}))
+	})
+
+	It("writes everything without limits", func() {
+		writer := teffyio.NewRateLimitWriter(ring)
+		for i := 0; i < 50; i++ {
+			Expect(writer.Write(complete("a", int64(i), 1))).To(Succeed())
+		}
+		Expect(writer.Close()).To(Succeed())
+		Expect(ring.Events()).To(HaveLen(50))
+		Expect(writer.Dropped()).To(BeZero())
+	})
+})
@@ -0,0 +1,222 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// EventReader represents a source of trace events
+type EventReader interface {
+	// Next returns the next event, or io.EOF once there are no more events
+	Next() (events.Event, error)
+}
+
+// NewJsonArrayReader creates an EventReader that decodes the events of a JSON Array Format trace one at a time as
+// they are requested, so that a trace can be processed without holding all of it in memory. Like ParseJsonArray it
+// tolerates the array being left unterminated. An event that cannot be parsed is reported as a *ParseError, after
+// which reading can continue with the following event.
+func NewJsonArrayReader(r io.Reader) EventReader {
+	return &jsonEventReader{
+		decoder: json.NewDecoder(r),
+		parser:  newParser(),
+	}
+}
+
+// NewJsonObjectReader is like NewJsonArrayReader but for JSON Object Format traces. Like ParseJsonObj it reads events
+// from the traceEvents member and from the member named by controllerTraceDataKey, the other members of the trace are
+// skipped over. As the controllerTraceDataKey may follow the member it names, arrays skipped over before it are held in
+// memory until it is found, and the events of the member it names are read once it is found.
+func NewJsonObjectReader(r io.Reader) EventReader {
+	return &jsonEventReader{
+		decoder:   json.NewDecoder(r),
+		parser:    newParser(),
+		object:    true,
+		eventKeys: map[string]bool{"traceEvents": true},
+		skipped:   map[string]skippedMember{},
+	}
+}
+
+type jsonEventReader struct {
+	decoder *json.Decoder
+	parser  *parser
+	object  bool
+
+	started  bool
+	inEvents bool
+	err      error
+
+	// eventKeys are the members of a JSON Object Format trace that hold events
+	eventKeys map[string]bool
+	// skipped holds the arrays skipped over before the controllerTraceDataKey has been found, by key, and is nil once
+	// it has been
+	skipped map[string]skippedMember
+	// member decodes the events of a skipped member once it is known to hold events, starting at memberOffset in the
+	// input
+	member       *json.Decoder
+	memberOffset int64
+}
+
+type skippedMember struct {
+	value  json.RawMessage
+	offset int64
+}
+
+func (r *jsonEventReader) Next() (events.Event, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	event, recoverable, err := r.next()
+	if err != nil && !recoverable {
+		r.err = err
+	}
+	return event, err
+}
+
+// next reads the next event, reporting whether any error was in the content of an event, which leaves the decoder
+// somewhere that it can continue reading from
+func (r *jsonEventReader) next() (events.Event, bool, error) {
+	if !r.started {
+		if err := r.start(); err != nil {
+			return nil, false, err
+		}
+		r.started = true
+	}
+
+	for {
+		if r.member != nil {
+			if !r.member.More() {
+				r.member = nil
+				continue
+			}
+			r.parser.offset = r.memberOffset
+			raw, offset, err := r.parser.decodeNext(r.member)
+			r.parser.offset = 0
+			if err != nil {
+				return nil, false, err
+			}
+			event, err := r.parser.parseEvent(raw, offset)
+			return event, true, err
+		}
+
+		if !r.inEvents {
+			if err := r.findEvents(); err != nil {
+				return nil, false, err
+			}
+			if r.member != nil {
+				continue
+			}
+		}
+
+		if r.decoder.More() {
+			raw, offset, err := r.parser.decodeNext(r.decoder)
+			if err != nil && !r.object && (errors.Is(err, io.EOF) || isTruncatedArray(r.decoder)) {
+				return nil, false, io.EOF
+			}
+			if err != nil {
+				return nil, false, err
+			}
+			event, err := r.parser.parseEvent(raw, offset)
+			return event, true, err
+		}
+
+		_, err := r.decoder.Token()
+		if !r.object {
+			// anything after the end of the array, or the lack of an end, is of no interest
+			return nil, false, io.EOF
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("JSON decode error while parsing: %w", err)
+		}
+		r.inEvents = false
+	}
+}
+
+func (r *jsonEventReader) start() error {
+	t, err := r.decoder.Token()
+	if err != nil {
+		return fmt.Errorf("failed to parse first token: %w", err)
+	}
+	if !r.object {
+		if t != json.Delim('[') {
+			return fmt.Errorf("expected '[' at start of json array format: %w", ErrSyntaxError)
+		}
+		r.inEvents = true
+		return nil
+	}
+	if t != json.Delim('{') {
+		return fmt.Errorf("expected '{' at start of json object format: %w", ErrSyntaxError)
+	}
+	return nil
+}
+
+// findEvents skips over the members of a JSON Object Format trace until the start of the next member holding events,
+// or until a controllerTraceDataKey naming a member already skipped over is found, returning io.EOF if the end of the
+// trace is reached first
+func (r *jsonEventReader) findEvents() error {
+	for r.decoder.More() {
+		t, err := r.decoder.Token()
+		if err != nil {
+			return fmt.Errorf("JSON decode error while parsing: %w", err)
+		}
+		if key := t.(string); !r.eventKeys[key] {
+			var value json.RawMessage
+			if err := r.decoder.Decode(&value); err != nil {
+				return fmt.Errorf("JSON decode error while parsing: %w", err)
+			}
+			if key == "controllerTraceDataKey" {
+				if r.readControllerKey(value) {
+					return nil
+				}
+			} else if r.skipped != nil && len(value) > 0 && value[0] == '[' {
+				r.skipped[key] = skippedMember{value: value, offset: r.decoder.InputOffset() - int64(len(value))}
+			}
+			continue
+		}
+
+		t, err = r.decoder.Token()
+		if err != nil {
+			return fmt.Errorf("JSON decode error while parsing: %w", err)
+		}
+		if t == nil {
+			continue
+		}
+		if t != json.Delim('[') {
+			return fmt.Errorf("expected trace data to be an array: %w", ErrInvalidDataType)
+		}
+		r.inEvents = true
+		return nil
+	}
+
+	if _, err := r.decoder.Token(); err != nil {
+		return fmt.Errorf("JSON decode error while parsing: %w", err)
+	}
+	return io.EOF
+}
+
+// readControllerKey notes the member named by the controllerTraceDataKey as holding events, reporting whether it was
+// already skipped over, in which case its events are read next
+func (r *jsonEventReader) readControllerKey(value json.RawMessage) bool {
+	skipped := r.skipped
+	r.skipped = nil
+	var key string
+	if err := json.Unmarshal(value, &key); err != nil || key == "" || r.eventKeys[key] {
+		return false
+	}
+	r.eventKeys[key] = true
+
+	member, ok := skipped[key]
+	if !ok {
+		return false
+	}
+	r.member = json.NewDecoder(bytes.NewReader(member.value))
+	r.memberOffset = member.offset
+	// the member is known to be an array, so its first token is its start
+	_, _ = r.member.Token()
+	return true
+}
@@ -0,0 +1,126 @@
+package io_test
+
+import (
+	"errors"
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"io"
+	"strings"
+
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("EventReader", func() {
+	readNames := func(r teffyio.EventReader) ([]string, error) {
+		names := []string{}
+		for {
+			e, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				return names, nil
+			}
+			if err != nil {
+				return names, err
+			}
+			names = append(names, e.Core().Name)
+		}
+	}
+
+	DescribeTable("reading array format traces",
+		func(contents string, expected ...string) {
+			names, err := readNames(teffyio.NewJsonArrayReader(strings.NewReader(contents)))
+			Expect(err).To(Succeed())
+			Expect(names).To(Equal(append([]string{}, expected...)))
+		},
+		Entry("an empty array", `[]`),
+		Entry("a complete array", `[{"name":"a","ph":"I","ts":1},{"name":"b","ph":"I","ts":2}]`, "a", "b"),
+		Entry("an unterminated array", `[{"name":"a","ph":"I","ts":1},{"name":"b","ph":"I","ts":2}`, "a", "b"),
+		Entry("an array with a trailing comma", `[{"name":"a","ph":"I","ts":1},`, "a"),
+	)
+
+	DescribeTable("reading object format traces",
+		func(contents string, expected ...string) {
+			names, err := readNames(teffyio.NewJsonObjectReader(strings.NewReader(contents)))
+			Expect(err).To(Succeed())
+			Expect(names).To(Equal(append([]string{}, expected...)))
+		},
+		Entry("an empty object", `{}`),
+		Entry("null trace events", `{"traceEvents":null,"displayTimeUnit":"ns"}`),
+		Entry("events after other members",
+			`{"otherData":{"a":[1,2]},"traceEvents":[{"name":"a","ph":"I","ts":1}],"displayTimeUnit":"ns"}`, "a"),
+		Entry("events in the member named by a preceding controllerTraceDataKey",
+			`{"controllerTraceDataKey":"agentEvents","traceEvents":[{"name":"a","ph":"I","ts":1}],`+
+				`"agentEvents":[{"name":"b","ph":"I","ts":2}]}`, "a", "b"),
+		Entry("events in the member named by a following controllerTraceDataKey",
+			`{"agentEvents":[{"name":"b","ph":"I","ts":2}],"samples":[],"traceEvents":[{"name":"a","ph":"I","ts":1}],`+
+				`"controllerTraceDataKey":"agentEvents"}`, "a", "b"),
+		Entry("a controllerTraceDataKey naming traceEvents",
+			`{"controllerTraceDataKey":"traceEvents","traceEvents":[{"name":"a","ph":"I","ts":1}]}`, "a"),
+	)
+
+	It("reads the same events as ParseJsonObj", func() {
+		contents := `{"agentEvents":[{"name":"b","ph":"I","ts":2},{"name":"c","ph":"I","ts":3}],` +
+			`"traceEvents":[{"name":"a","ph":"I","ts":1}],"controllerTraceDataKey":"agentEvents"}`
+		data, err := teffyio.ParseJsonObj(strings.NewReader(contents))
+		Expect(err).To(Succeed())
+		var parsed []string
+		for _, e := range data.Events() {
+			parsed = append(parsed, e.Core().Name)
+		}
+
+		names, err := readNames(teffyio.NewJsonObjectReader(strings.NewReader(contents)))
+		Expect(err).To(Succeed())
+		Expect(names).To(ConsistOf(parsed))
+	})
+
+	It("locates unparseable events in a member named by a following controllerTraceDataKey", func() {
+		contents := `{"agentEvents":[{"name":"bad","ph":"C","ts":2,"args":{"value":"many"}}],` +
+			`"controllerTraceDataKey":"agentEvents"}`
+		r := teffyio.NewJsonObjectReader(strings.NewReader(contents))
+
+		_, err := r.Next()
+		var parseErr *teffyio.ParseError
+		Expect(errors.As(err, &parseErr)).To(BeTrue())
+		Expect(parseErr.Offset).To(Equal(int64(strings.Index(contents, `{"name":"bad"`))))
+
+		_, err = r.Next()
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	It("continues reading after an event that cannot be parsed", func() {
+		r := teffyio.NewJsonArrayReader(strings.NewReader(`[
+			{"name":"a","ph":"I","ts":1},
+			{"name":"bad","ph":"C","ts":2,"args":{"value":"many"}},
+			{"name":"c","ph":"I","ts":3}
+		]`))
+
+		e, err := r.Next()
+		Expect(err).To(Succeed())
+		Expect(e.Core().Name).To(Equal("a"))
+
+		_, err = r.Next()
+		var parseErr *teffyio.ParseError
+		Expect(errors.As(err, &parseErr)).To(BeTrue())
+		Expect(parseErr.Index).To(Equal(1))
+		Expect(parseErr.Phase).To(Equal(events.PhaseCounter))
+
+		e, err = r.Next()
+		Expect(err).To(Succeed())
+		Expect(e.Core().Name).To(Equal("c"))
+
+		_, err = r.Next()
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	It("stops reading at invalid JSON", func() {
+		r := teffyio.NewJsonObjectReader(strings.NewReader(`{"traceEvents":[{"name":"a","ph":"I","ts":1},{"name":}]}`))
+
+		_, err := r.Next()
+		Expect(err).To(Succeed())
+		_, err = r.Next()
+		Expect(err).To(HaveOccurred())
+		_, second := r.Next()
+		Expect(second).To(Equal(err))
+	})
+})
@@ -0,0 +1,91 @@
+package io
+
+import (
+	"sort"
+)
+
+// RemapProcessIDs replaces the process ID of every event of the trace whose process ID appears in the mapping.
+// Events are modified in place.
+func RemapProcessIDs(data *TefData, mapping map[int64]int64) {
+	for _, e := range data.traceEvents {
+		core := e.Core()
+		if core.ProcessID != nil {
+			if pid, ok := mapping[*core.ProcessID]; ok {
+				core.ProcessID = &pid
+			}
+		}
+	}
+}
+
+// RemapThreadIDs replaces the thread ID of every event of the trace whose thread ID appears in the mapping, whichever
+// process the thread belongs to. Events are modified in place.
+func RemapThreadIDs(data *TefData, mapping map[int64]int64) {
+	for _, e := range data.traceEvents {
+		core := e.Core()
+		if core.ThreadID != nil {
+			if tid, ok := mapping[*core.ThreadID]; ok {
+				core.ThreadID = &tid
+			}
+		}
+	}
+}
+
+// ProcessIDRemapper gives the processes of traces new process IDs where they clash with those of traces it has
+// already seen, so that processes from traces that reuse process IDs, such as forked workers, can be told apart once
+// the traces are combined. Replacement IDs are allocated above the highest ID seen so far.
+type ProcessIDRemapper struct {
+	used   map[int64]struct{}
+	maxPid int64
+}
+
+// NewProcessIDRemapper creates a ProcessIDRemapper that has not seen any traces
+func NewProcessIDRemapper() *ProcessIDRemapper {
+	return &ProcessIDRemapper{
+		used: map[int64]struct{}{},
+	}
+}
+
+// Mapping works out which process IDs of the trace clash with those of the traces seen before, returning their
+// replacements, and records the process IDs the trace will have once they are replaced as used
+func (r *ProcessIDRemapper) Mapping(data *TefData) map[int64]int64 {
+	seen := map[int64]struct{}{}
+	for _, e := range data.traceEvents {
+		if pid := e.Core().ProcessID; pid != nil {
+			seen[*pid] = struct{}{}
+			if *pid > r.maxPid {
+				r.maxPid = *pid
+			}
+		}
+	}
+
+	var clashing []int64
+	for pid := range seen {
+		if _, clash := r.used[pid]; clash {
+			clashing = append(clashing, pid)
+		}
+	}
+	// allocate replacements in a deterministic order
+	sort.Slice(clashing, func(i, j int) bool { return clashing[i] < clashing[j] })
+	mapping := make(map[int64]int64, len(clashing))
+	for _, pid := range clashing {
+		r.maxPid++
+		mapping[pid] = r.maxPid
+	}
+
+	for pid := range seen {
+		if replacement, ok := mapping[pid]; ok {
+			pid = replacement
+		}
+		r.used[pid] = struct{}{}
+	}
+
+	return mapping
+}
+
+// Remap replaces the process IDs of the trace that clash with those of the traces seen before, as found by Mapping,
+// returning the replacements that were made. Events are modified in place.
+func (r *ProcessIDRemapper) Remap(data *TefData) map[int64]int64 {
+	mapping := r.Mapping(data)
+	RemapProcessIDs(data, mapping)
+	return mapping
+}
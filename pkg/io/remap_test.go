@@ -0,0 +1,53 @@
+package io_test
+
+import (
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Remapping IDs", func() {
+	trace := func(ids ...[2]int64) *teffyio.TefData {
+		data := &teffyio.TefData{}
+		for _, id := range ids {
+			pid, tid := id[0], id[1]
+			data.Write(&events.Instant{
+				EventWithArgs: events.EventWithArgs{
+					EventCore: events.EventCore{Name: "i", ProcessID: &pid, ThreadID: &tid},
+				},
+			})
+		}
+		return data
+	}
+	ids := func(data *teffyio.TefData) [][2]int64 {
+		var result [][2]int64
+		for _, e := range data.Events() {
+			result = append(result, [2]int64{*e.Core().ProcessID, *e.Core().ThreadID})
+		}
+		return result
+	}
+
+	It("replaces process and thread IDs by mapping", func() {
+		data := trace([2]int64{1, 10}, [2]int64{2, 20}, [2]int64{3, 10})
+		teffyio.RemapProcessIDs(data, map[int64]int64{1: 4, 2: 1})
+		teffyio.RemapThreadIDs(data, map[int64]int64{10: 11})
+		Expect(ids(data)).To(Equal([][2]int64{{4, 11}, {1, 20}, {3, 11}}))
+	})
+
+	It("gives clashing processes new IDs", func() {
+		remapper := teffyio.NewProcessIDRemapper()
+		first := trace([2]int64{1, 1}, [2]int64{5, 1})
+		second := trace([2]int64{1, 1}, [2]int64{2, 1}, [2]int64{5, 1})
+		third := trace([2]int64{1, 1}, [2]int64{7, 1})
+
+		Expect(remapper.Remap(first)).To(BeEmpty())
+		Expect(remapper.Remap(second)).To(Equal(map[int64]int64{1: 6, 5: 7}))
+		Expect(remapper.Remap(third)).To(Equal(map[int64]int64{1: 8, 7: 9}))
+
+		Expect(ids(first)).To(Equal([][2]int64{{1, 1}, {5, 1}}))
+		Expect(ids(second)).To(Equal([][2]int64{{6, 1}, {2, 1}, {7, 1}}))
+		Expect(ids(third)).To(Equal([][2]int64{{8, 1}, {9, 1}}))
+	})
+})
@@ -0,0 +1,133 @@
+package remote
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// DefaultMaxBodySize is the largest batch, after decompression, that a Collector accepts unless configured otherwise
+const DefaultMaxBodySize = 64 << 20
+
+// CollectorOption configures the behaviour of a Collector
+type CollectorOption = func(c *Collector)
+
+// WithMaxBodySize sets the largest batch, after decompression, that is accepted, larger batches being rejected with
+// 413 Request Entity Too Large
+func WithMaxBodySize(size int64) CollectorOption {
+	return func(c *Collector) {
+		c.maxBodySize = size
+	}
+}
+
+// Collector is an http.Handler that receives batches of events posted by Writers and writes them to an EventWriter,
+// such as one streaming them to a file. The batches of concurrent requests are written one at a time, so events from
+// the same batch are never interleaved with those of another.
+type Collector struct {
+	w           tio.EventWriter
+	maxBodySize int64
+
+	lock     sync.Mutex
+	received uint64
+	invalid  uint64
+}
+
+// NewCollector creates a Collector that writes the events it receives to w
+func NewCollector(w tio.EventWriter, options ...CollectorOption) *Collector {
+	c := &Collector{
+		w:           w,
+		maxBodySize: DefaultMaxBodySize,
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	return c
+}
+
+// Received reports how many events have been received and written
+func (c *Collector) Received() uint64 {
+	return atomic.LoadUint64(&c.received)
+}
+
+// Invalid reports how many events have been skipped because they could not be parsed
+func (c *Collector) Invalid() uint64 {
+	return atomic.LoadUint64(&c.invalid)
+}
+
+// ServeHTTP writes the events of a posted batch, responding with 204 No Content once they have all been written. Any
+// events in the batch that cannot be parsed are skipped, as sending them again would not help.
+func (c *Collector) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "events must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body io.Reader = r.Body
+	switch encoding := strings.ToLower(r.Header.Get("Content-Encoding")); encoding {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("invalid gzip body: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		http.Error(rw, fmt.Sprintf("unsupported content encoding '%s'", encoding), http.StatusUnsupportedMediaType)
+		return
+	}
+	// read the whole batch before writing any of it, so that a batch that is too large is not partially written
+	raw, err := io.ReadAll(io.LimitReader(body, c.maxBodySize+1))
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed to read events: %v", err), http.StatusBadRequest)
+		return
+	}
+	if int64(len(raw)) > c.maxBodySize {
+		http.Error(rw, "batch of events is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := c.write(raw); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Collector) write(raw []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	reader := tio.NewJsonLinesReader(bytes.NewReader(raw))
+	for {
+		event, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var parseErr *tio.ParseError
+		if errors.As(err, &parseErr) {
+			atomic.AddUint64(&c.invalid, 1)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read events: %w", err)
+		}
+		if event == nil {
+			continue
+		}
+		if err := c.w.Write(event); err != nil {
+			return fmt.Errorf("failed to write event: %w", err)
+		}
+		atomic.AddUint64(&c.received, 1)
+	}
+}
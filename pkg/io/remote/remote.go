@@ -0,0 +1,344 @@
+// remote provides streaming of trace events over HTTP, so that the traces of a fleet of processes can be gathered on a
+// central host. A Writer posts events to an endpoint in batches, and a Collector is an http.Handler that receives them
+// and writes them to an EventWriter of its own.
+//
+// Each batch is the body of a POST request holding the events as newline delimited JSON, compressed with gzip unless
+// configured otherwise.
+package remote
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+const (
+	// ContentType is the content type of the batches of events posted to a collector
+	ContentType = "application/x-ndjson"
+
+	// DefaultBatchSize is the most events a Writer sends in a single request unless configured otherwise
+	DefaultBatchSize = 500
+	// DefaultFlushInterval is how long a Writer waits for a batch to fill before sending it unless configured otherwise
+	DefaultFlushInterval = time.Second
+	// DefaultRetries is how many times a Writer retries sending a batch that failed unless configured otherwise
+	DefaultRetries = 3
+	// DefaultRetryBackoff is how long a Writer waits before first retrying a batch unless configured otherwise, the
+	// wait doubling with each further retry
+	DefaultRetryBackoff = 100 * time.Millisecond
+)
+
+// ErrRejected means that the endpoint responded to a batch of events with a status other than success
+var ErrRejected = errors.New("batch rejected by endpoint")
+
+// Option configures the behaviour of a Writer
+type Option = func(w *Writer)
+
+// WithBatchSize sets the most events sent in a single request
+func WithBatchSize(size int) Option {
+	return func(w *Writer) {
+		w.batchSize = size
+	}
+}
+
+// WithFlushInterval sets how long events may wait for their batch to fill before it is sent anyway
+func WithFlushInterval(interval time.Duration) Option {
+	return func(w *Writer) {
+		w.flushInterval = interval
+	}
+}
+
+// WithRetries sets how many times a batch that failed to send is retried, and how long to wait before the first
+// retry, the wait doubling with each further retry. Batches are retried when the request fails or the endpoint
+// responds with 429 or a 5xx status, other statuses meaning that retrying would not help.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(w *Writer) {
+		w.retries = retries
+		w.backoff = backoff
+	}
+}
+
+// WithoutCompression sends batches uncompressed, rather than compressed with gzip
+func WithoutCompression() Option {
+	return func(w *Writer) {
+		w.compress = false
+	}
+}
+
+// WithHeader adds a header to every request, such as to authenticate with the endpoint
+func WithHeader(key, value string) Option {
+	return func(w *Writer) {
+		w.header.Add(key, value)
+	}
+}
+
+// WithHTTPClient sets the client used to send requests, which is http.DefaultClient by default
+func WithHTTPClient(client *http.Client) Option {
+	return func(w *Writer) {
+		w.client = client
+	}
+}
+
+// WithQueueSize sets how many events may be queued waiting to be sent. While a batch is being sent, including while
+// waiting to retry it, further events are queued, and once the queue is full the DropPolicy decides what happens.
+func WithQueueSize(size int) Option {
+	return func(w *Writer) {
+		w.queueSize = size
+	}
+}
+
+// WithDropPolicy sets what happens when an event is written while the queue is full. By default writes wait for room
+// in the queue, slowing the process to the rate the endpoint accepts events.
+func WithDropPolicy(policy tio.DropPolicy) Option {
+	return func(w *Writer) {
+		w.policy = policy
+	}
+}
+
+// WithSendErrorHandler provides a callback for batches that could not be sent, after any retries. Without a handler
+// the first such error is returned from Close.
+func WithSendErrorHandler(handler func(err error)) Option {
+	return func(w *Writer) {
+		w.errHandler = handler
+	}
+}
+
+// Writer is an EventWriter that posts events to an HTTP endpoint in batches from a background goroutine. Events must
+// not be modified after being written.
+type Writer struct {
+	url           string
+	client        *http.Client
+	header        http.Header
+	batchSize     int
+	flushInterval time.Duration
+	retries       int
+	backoff       time.Duration
+	compress      bool
+	queueSize     int
+	policy        tio.DropPolicy
+	errHandler    func(err error)
+
+	queue    chan events.Event
+	done     chan struct{}
+	lock     sync.RWMutex
+	closed   bool
+	dropped  uint64
+	sent     uint64
+	firstErr error
+}
+
+// NewWriter creates a Writer that posts events to the given URL, such as that of a Collector
+func NewWriter(url string, options ...Option) *Writer {
+	w := &Writer{
+		url:           url,
+		client:        http.DefaultClient,
+		header:        http.Header{},
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+		retries:       DefaultRetries,
+		backoff:       DefaultRetryBackoff,
+		compress:      true,
+		queueSize:     tio.DefaultQueueSize,
+		policy:        tio.DropPolicyBlock,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range options {
+		opt(w)
+	}
+	if w.batchSize < 1 {
+		w.batchSize = 1
+	}
+	w.queue = make(chan events.Event, w.queueSize)
+
+	go w.run()
+
+	return w
+}
+
+// Write queues the event to be sent, according to the configured DropPolicy if the queue is full
+func (w *Writer) Write(e events.Event) error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	if w.closed {
+		return tio.ErrWriterClosed
+	}
+
+	switch w.policy {
+	case tio.DropPolicyNewest:
+		select {
+		case w.queue <- e:
+		default:
+			atomic.AddUint64(&w.dropped, 1)
+		}
+	case tio.DropPolicyOldest:
+		for {
+			select {
+			case w.queue <- e:
+				return nil
+			default:
+			}
+			select {
+			case <-w.queue:
+				atomic.AddUint64(&w.dropped, 1)
+			default:
+			}
+		}
+	default:
+		w.queue <- e
+	}
+
+	return nil
+}
+
+// Dropped reports how many events have been discarded, either because the queue was full or because the batch they
+// were in could not be sent
+func (w *Writer) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Sent reports how many events have been accepted by the endpoint
+func (w *Writer) Sent() uint64 {
+	return atomic.LoadUint64(&w.sent)
+}
+
+// Close sends all queued events, waiting for them to be accepted or to fail, and stops the background goroutine
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.lock.Unlock()
+
+	<-w.done
+
+	if w.firstErr != nil {
+		return fmt.Errorf("failed to send events: %w", w.firstErr)
+	}
+	return nil
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]events.Event, 0, w.batchSize)
+	for {
+		select {
+		case e, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= w.batchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			w.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush sends the batch, retrying as configured, and reports the error if it could not be sent
+func (w *Writer) flush(batch []events.Event) {
+	if len(batch) < 1 {
+		return
+	}
+	err := w.send(batch)
+	if err == nil {
+		atomic.AddUint64(&w.sent, uint64(len(batch)))
+		return
+	}
+
+	atomic.AddUint64(&w.dropped, uint64(len(batch)))
+	if w.errHandler != nil {
+		w.errHandler(err)
+	} else if w.firstErr == nil {
+		w.firstErr = err
+	}
+}
+
+func (w *Writer) send(batch []events.Event) error {
+	body, err := w.encode(batch)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// encode writes the events of the batch as newline delimited JSON, compressing them if configured to
+func (w *Writer) encode(batch []events.Event) ([]byte, error) {
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	var gz *gzip.Writer
+	if w.compress {
+		gz = gzip.NewWriter(&buf)
+		out = gz
+	}
+	if err := tio.WriteJsonLines(out, batch); err != nil {
+		return nil, fmt.Errorf("failed to encode events: %w", err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress events: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends a single request holding the encoded batch, reporting whether it is worth retrying if it fails
+func (w *Writer) post(body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range w.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if w.compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to post events: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("status %s: %w", resp.Status, ErrRejected)
+}
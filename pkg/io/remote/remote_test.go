@@ -0,0 +1,206 @@
+package remote_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/io/remote"
+)
+
+// recordingWriter is an EventWriter that remembers the names of the events written to it
+type recordingWriter struct {
+	lock  sync.Mutex
+	names []string
+	err   error
+}
+
+func (r *recordingWriter) Write(e events.Event) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.names = append(r.names, e.Core().Name)
+	return nil
+}
+
+func (r *recordingWriter) Close() error {
+	return nil
+}
+
+func (r *recordingWriter) written() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.names...)
+}
+
+func namedInstant(name string) events.Event {
+	return &events.Instant{EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: name}}}
+}
+
+var _ = Describe("Remote", func() {
+	var recorder *recordingWriter
+	var collector *remote.Collector
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		recorder = &recordingWriter{}
+		collector = remote.NewCollector(recorder)
+		server = httptest.NewServer(collector)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("sends events to the collector in batches", func() {
+		var requests int32
+		server.Config.Handler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			Expect(r.Header.Get("Content-Encoding")).To(Equal("gzip"))
+			Expect(r.Header.Get("Authorization")).To(Equal("Bearer secret"))
+			collector.ServeHTTP(rw, r)
+		})
+
+		w := remote.NewWriter(server.URL, remote.WithBatchSize(2), remote.WithHeader("Authorization", "Bearer secret"))
+		for _, name := range []string{"a", "b", "c", "d", "e"} {
+			Expect(w.Write(namedInstant(name))).To(Succeed())
+		}
+		Expect(w.Close()).To(Succeed())
+
+		Expect(recorder.written()).To(Equal([]string{"a", "b", "c", "d", "e"}))
+		Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(3))
+		Expect(w.Sent()).To(BeEquivalentTo(5))
+		Expect(collector.Received()).To(BeEquivalentTo(5))
+		Expect(w.Write(namedInstant("f"))).To(MatchError(tio.ErrWriterClosed))
+	})
+
+	It("sends uncompressed batches when configured to", func() {
+		w := remote.NewWriter(server.URL, remote.WithoutCompression())
+		Expect(w.Write(namedInstant("a"))).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+		Expect(recorder.written()).To(Equal([]string{"a"}))
+	})
+
+	It("sends partial batches after the flush interval", func() {
+		w := remote.NewWriter(server.URL, remote.WithFlushInterval(10*time.Millisecond))
+		defer w.Close()
+
+		Expect(w.Write(namedInstant("a"))).To(Succeed())
+		Eventually(recorder.written).Should(Equal([]string{"a"}))
+	})
+
+	It("retries batches the endpoint fails to accept", func() {
+		var failures int32 = 2
+		server.Config.Handler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			collector.ServeHTTP(rw, r)
+		})
+
+		w := remote.NewWriter(server.URL, remote.WithRetries(2, time.Millisecond))
+		Expect(w.Write(namedInstant("a"))).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+		Expect(recorder.written()).To(Equal([]string{"a"}))
+	})
+
+	It("reports batches that could not be sent", func() {
+		server.Config.Handler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rw.WriteHeader(http.StatusBadRequest)
+		})
+
+		var handled []error
+		w := remote.NewWriter(server.URL, remote.WithSendErrorHandler(func(err error) {
+			handled = append(handled, err)
+		}))
+		Expect(w.Write(namedInstant("a"))).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		Expect(handled).To(HaveLen(1))
+		Expect(errors.Is(handled[0], remote.ErrRejected)).To(BeTrue())
+		Expect(w.Dropped()).To(BeEquivalentTo(1))
+	})
+
+	It("returns the first failure from close without an error handler", func() {
+		w := remote.NewWriter("http://127.0.0.1:0", remote.WithRetries(1, time.Millisecond))
+		Expect(w.Write(namedInstant("a"))).To(Succeed())
+		Expect(w.Close()).To(MatchError(ContainSubstring("failed to post events")))
+	})
+
+	It("drops the newest events while the queue is full if configured to", func() {
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		server.Config.Handler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-release
+			collector.ServeHTTP(rw, r)
+		})
+
+		w := remote.NewWriter(server.URL,
+			remote.WithBatchSize(1),
+			remote.WithQueueSize(1),
+			remote.WithDropPolicy(tio.DropPolicyNewest),
+		)
+		Expect(w.Write(namedInstant("a"))).To(Succeed())
+		// wait for the first event to be taken from the queue and held up being sent
+		Eventually(started).Should(Receive())
+		Expect(w.Write(namedInstant("b"))).To(Succeed())
+		Expect(w.Write(namedInstant("c"))).To(Succeed())
+		close(release)
+		Expect(w.Close()).To(Succeed())
+
+		Expect(recorder.written()).To(Equal([]string{"a", "b"}))
+		Expect(w.Dropped()).To(BeEquivalentTo(1))
+	})
+
+	Describe("Collector", func() {
+		post := func(body string) *http.Response {
+			resp, err := http.Post(server.URL, remote.ContentType, strings.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			_ = resp.Body.Close()
+			return resp
+		}
+
+		It("skips events that cannot be parsed", func() {
+			resp := post(`{"ph":"i","name":"a","ts":1}` + "\n" + `{"ph":` + "\n" + `{"ph":"i","name":"b","ts":2}` + "\n")
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			Expect(recorder.written()).To(Equal([]string{"a", "b"}))
+			Expect(collector.Invalid()).To(BeEquivalentTo(1))
+		})
+
+		It("only accepts posted events", func() {
+			resp, err := http.Get(server.URL)
+			Expect(err).ToNot(HaveOccurred())
+			_ = resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+		})
+
+		It("rejects batches that are too large", func() {
+			server.Config.Handler = remote.NewCollector(recorder, remote.WithMaxBodySize(10))
+			resp := post(`{"ph":"i","name":"a","ts":1}`)
+			Expect(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+			Expect(recorder.written()).To(BeEmpty())
+		})
+
+		It("fails batches that cannot be written", func() {
+			recorder.err = errors.New("disk full")
+			resp := post(`{"ph":"i","name":"a","ts":1}`)
+			Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
@@ -0,0 +1,93 @@
+package io
+
+import (
+	"sync"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// RingWriter is an EventWriter that keeps only the most recently written events in memory, discarding the oldest
+// event as each new one is written once it is full, so that a process can always have the recent history of its trace
+// at hand without the cost of writing it anywhere, in the manner of a flight recorder.
+//
+// Metadata events, which name processes and threads, are kept apart from the other events and are never discarded, so
+// that the history still makes sense once the events naming things have been pushed out of it. For the same reason
+// the stack frames written to it are all kept. RingWriter is safe for concurrent use.
+type RingWriter struct {
+	lock     sync.Mutex
+	ring     []events.Event
+	next     int
+	full     bool
+	metadata []events.Event
+	frames   map[string]*events.StackFrame
+}
+
+// NewRingWriter creates a RingWriter that keeps the most recent size events
+func NewRingWriter(size int) *RingWriter {
+	if size < 1 {
+		size = 1
+	}
+	return &RingWriter{
+		ring: make([]events.Event, size),
+	}
+}
+
+// Write records the event, discarding the oldest event if the ring is full
+func (rw *RingWriter) Write(e events.Event) error {
+	rw.lock.Lock()
+	defer rw.lock.Unlock()
+
+	if e.Phase() == events.PhaseMetadata {
+		rw.metadata = append(rw.metadata, e)
+		return nil
+	}
+	rw.ring[rw.next] = e
+	rw.next++
+	if rw.next == len(rw.ring) {
+		rw.next = 0
+		rw.full = true
+	}
+	return nil
+}
+
+// Events returns the metadata events followed by the events in the ring, oldest first
+func (rw *RingWriter) Events() []events.Event {
+	rw.lock.Lock()
+	defer rw.lock.Unlock()
+
+	result := make([]events.Event, 0, len(rw.metadata)+len(rw.ring))
+	result = append(result, rw.metadata...)
+	if rw.full {
+		result = append(result, rw.ring[rw.next:]...)
+	}
+	return append(result, rw.ring[:rw.next]...)
+}
+
+// WriteStackFrame keeps the stack frame, which is never discarded
+func (rw *RingWriter) WriteStackFrame(id string, frame *events.StackFrame) error {
+	rw.lock.Lock()
+	defer rw.lock.Unlock()
+
+	if rw.frames == nil {
+		rw.frames = map[string]*events.StackFrame{}
+	}
+	rw.frames[id] = frame
+	return nil
+}
+
+// StackFrames returns a copy of the stack frames written to the ring
+func (rw *RingWriter) StackFrames() map[string]*events.StackFrame {
+	rw.lock.Lock()
+	defer rw.lock.Unlock()
+
+	frames := make(map[string]*events.StackFrame, len(rw.frames))
+	for id, frame := range rw.frames {
+		frames[id] = frame
+	}
+	return frames
+}
+
+// Close does nothing, the events remaining available from Events
+func (rw *RingWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,57 @@
+package io_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("RingWriter", func() {
+	instant := func(name string) events.Event {
+		return &events.Instant{EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: name}}}
+	}
+	names := func(evs []events.Event) []string {
+		result := []string{}
+		for _, e := range evs {
+			result = append(result, e.Core().Name)
+		}
+		return result
+	}
+
+	It("keeps every event until it is full", func() {
+		ring := teffyio.NewRingWriter(3)
+		Expect(ring.Write(instant("a"))).To(Succeed())
+		Expect(ring.Write(instant("b"))).To(Succeed())
+		Expect(names(ring.Events())).To(Equal([]string{"a", "b"}))
+	})
+
+	It("keeps only the most recent events, oldest first", func() {
+		ring := teffyio.NewRingWriter(3)
+		for _, name := range []string{"a", "b", "c", "d", "e"} {
+			Expect(ring.Write(instant(name))).To(Succeed())
+		}
+		Expect(names(ring.Events())).To(Equal([]string{"c", "d", "e"}))
+	})
+
+	It("never discards metadata events", func() {
+		ring := teffyio.NewRingWriter(2)
+		Expect(ring.Write(events.NewProcessName(1, "server"))).To(Succeed())
+		for _, name := range []string{"a", "b", "c"} {
+			Expect(ring.Write(instant(name))).To(Succeed())
+		}
+		Expect(ring.Close()).To(Succeed())
+		Expect(names(ring.Events())).To(Equal([]string{"process_name", "b", "c"}))
+	})
+
+	It("keeps every stack frame written to it", func() {
+		ring := teffyio.NewRingWriter(1)
+		frame := &events.StackFrame{Category: "main.go", Name: "main.main:10"}
+		Expect(ring.WriteStackFrame("s1", frame)).To(Succeed())
+		for _, name := range []string{"a", "b"} {
+			Expect(ring.Write(instant(name))).To(Succeed())
+		}
+		Expect(ring.StackFrames()).To(Equal(map[string]*events.StackFrame{"s1": frame}))
+	})
+})
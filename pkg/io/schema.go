@@ -0,0 +1,226 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// ErrSchemaViolation means that the args of an event do not match a schema registered for it
+var ErrSchemaViolation = errors.New("event args do not match their schema")
+
+// ArgType is the type of JSON value an ArgSchema expects an arg to hold
+type ArgType string
+
+const (
+	// ArgAny accepts any value
+	ArgAny ArgType = ""
+	// ArgString accepts strings
+	ArgString ArgType = "string"
+	// ArgNumber accepts numbers
+	ArgNumber ArgType = "number"
+	// ArgInteger accepts whole numbers, including those read from a file as float64s
+	ArgInteger ArgType = "integer"
+	// ArgBool accepts booleans
+	ArgBool ArgType = "bool"
+	// ArgObject accepts JSON objects
+	ArgObject ArgType = "object"
+	// ArgArray accepts JSON arrays
+	ArgArray ArgType = "array"
+)
+
+// ArgSchema describes the args expected of the events with a given name and/or category
+type ArgSchema struct {
+	// Name is the name of the events the schema applies to, it applies to events of any name if it is empty
+	Name string
+	// Category is a category of the events the schema applies to, it applies to events of any categories if it is
+	// empty
+	Category string
+	// Required are the keys of the args that the events must have
+	Required []string
+	// Types are the types of JSON value that args of the given keys must hold, if the events have them
+	Types map[string]ArgType
+	// Check is an optional check of anything else expected of the args, which are those of the event including its
+	// typed args. An error returned by Check describes how the args are wrong.
+	Check func(args map[string]interface{}) error
+}
+
+// appliesTo determines whether the schema describes the event
+func (s *ArgSchema) appliesTo(core *events.EventCore) bool {
+	if s.Name != "" && core.Name != s.Name {
+		return false
+	}
+	if s.Category == "" {
+		return true
+	}
+	for _, category := range core.Categories {
+		if category == s.Category {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the args against the schema, describing the first way they do not match it
+func (s *ArgSchema) validate(args map[string]interface{}) error {
+	for _, key := range s.Required {
+		if _, ok := args[key]; !ok {
+			return fmt.Errorf("required arg %q is missing: %w", key, ErrSchemaViolation)
+		}
+	}
+	keys := make([]string, 0, len(s.Types))
+	for key := range s.Types {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, ok := args[key]
+		if !ok {
+			continue
+		}
+		if want := s.Types[key]; !isArgType(value, want) {
+			return fmt.Errorf("arg %q holds %T %v, expected %s: %w", key, value, value, want, ErrSchemaViolation)
+		}
+	}
+	if s.Check != nil {
+		if err := s.Check(args); err != nil {
+			return fmt.Errorf("%v: %w", err, ErrSchemaViolation)
+		}
+	}
+	return nil
+}
+
+// isArgType determines whether the value, read from a file or given in memory, is of the type
+func isArgType(value interface{}, t ArgType) bool {
+	if t == ArgAny {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return t == ArgString
+	case reflect.Bool:
+		return t == ArgBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return t == ArgNumber || t == ArgInteger
+	case reflect.Float32, reflect.Float64:
+		return t == ArgNumber || (t == ArgInteger && v.Float() == math.Trunc(v.Float()))
+	case reflect.Map, reflect.Struct:
+		return t == ArgObject
+	case reflect.Slice, reflect.Array:
+		return t == ArgArray
+	}
+	return false
+}
+
+// SchemaRegistry holds the schemas that the args of events are expected to match, so that the bugs of the producers
+// of traces are caught early. An event must match every schema that applies to it.
+type SchemaRegistry struct {
+	lock    sync.RWMutex
+	schemas []*ArgSchema
+}
+
+// NewSchemaRegistry creates a SchemaRegistry with no schemas
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{}
+}
+
+// Register adds a schema to the registry
+func (r *SchemaRegistry) Register(schema ArgSchema) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.schemas = append(r.schemas, &schema)
+}
+
+// ValidateEvent checks the args of the event against the schemas that apply to it, returning an error wrapping
+// ErrSchemaViolation that describes the first mismatch found
+func (r *SchemaRegistry) ValidateEvent(e events.Event) error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	core := e.Core()
+	var args map[string]interface{}
+	decoded := false
+	for _, schema := range r.schemas {
+		if !schema.appliesTo(core) {
+			continue
+		}
+		// args are only gathered once an event has a schema, as doing so decodes any typed args
+		if !decoded {
+			args = indexedArgs(e)
+			decoded = true
+		}
+		if err := schema.validate(args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchemaError describes an event of a trace whose args do not match their schema
+type SchemaError struct {
+	// Index is the position of the event within the trace events
+	Index int
+	// Event is the event that does not match
+	Event events.Event
+	// Err describes the mismatch, it wraps ErrSchemaViolation
+	Err error
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("event %d ('%s'): %v", e.Index, e.Event.Core().Name, e.Err)
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.Err
+}
+
+// SchemaErrors are the events of a trace that do not match their schemas, in the order they appear in the trace
+type SchemaErrors []*SchemaError
+
+func (e SchemaErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d events do not match their schemas, the first: %v", len(e), e[0])
+}
+
+func (e SchemaErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Validate checks every event of the trace against the schemas that apply to it, returning a SchemaErrors describing
+// each event that does not match, or nil if they all do
+func (r *SchemaRegistry) Validate(data *TefData) error {
+	var errs SchemaErrors
+	for i, e := range data.Events() {
+		if err := r.ValidateEvent(e); err != nil {
+			errs = append(errs, &SchemaError{Index: i, Event: e, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// WithArgSchemas checks each event parsed against the schemas of the registry, an event that does not match failing
+// parsing with a ParseError wrapping ErrSchemaViolation, or being skipped and recorded when parsing leniently
+func WithArgSchemas(registry *SchemaRegistry) ParseOption {
+	return func(p *parser) {
+		p.schemas = registry
+	}
+}
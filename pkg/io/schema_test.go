@@ -0,0 +1,109 @@
+package io_test
+
+import (
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Arg schemas", func() {
+	var registry *teffyio.SchemaRegistry
+
+	BeforeEach(func() {
+		registry = teffyio.NewSchemaRegistry()
+		registry.Register(teffyio.ArgSchema{
+			Name:     "compile",
+			Required: []string{"mnemonic"},
+			Types:    map[string]teffyio.ArgType{"mnemonic": teffyio.ArgString, "bytes": teffyio.ArgInteger},
+		})
+		registry.Register(teffyio.ArgSchema{
+			Category: "net",
+			Types:    map[string]teffyio.ArgType{"status": teffyio.ArgNumber, "headers": teffyio.ArgObject},
+			Check: func(args map[string]interface{}) error {
+				if url, ok := args["url"].(string); ok && !strings.HasPrefix(url, "http") {
+					return errors.New("url must be absolute")
+				}
+				return nil
+			},
+		})
+	})
+
+	event := func(name string, args map[string]interface{}, categories ...string) events.Event {
+		return &events.Complete{EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{Name: name, Categories: categories},
+			Args:      args,
+		}}
+	}
+
+	It("checks the args of events against the schemas that apply to them", func() {
+		Expect(registry.ValidateEvent(event("compile", map[string]interface{}{"mnemonic": "CppCompile", "bytes": 12.0}))).
+			To(Succeed())
+		Expect(registry.ValidateEvent(event("link", nil))).To(Succeed())
+		Expect(registry.ValidateEvent(event("fetch", map[string]interface{}{"status": 200}, "net"))).To(Succeed())
+
+		err := registry.ValidateEvent(event("compile", map[string]interface{}{"bytes": 12}))
+		Expect(err).To(MatchError(teffyio.ErrSchemaViolation))
+		Expect(err.Error()).To(ContainSubstring(`"mnemonic" is missing`))
+		Expect(registry.ValidateEvent(event("compile", map[string]interface{}{"mnemonic": "x", "bytes": 1.5}))).
+			To(MatchError(teffyio.ErrSchemaViolation))
+		Expect(registry.ValidateEvent(event("fetch", map[string]interface{}{"status": "200"}, "io", "net"))).
+			To(MatchError(teffyio.ErrSchemaViolation))
+		Expect(registry.ValidateEvent(event("fetch", map[string]interface{}{"headers": []interface{}{}}, "net"))).
+			To(MatchError(teffyio.ErrSchemaViolation))
+		err = registry.ValidateEvent(event("fetch", map[string]interface{}{"url": "/index.html"}, "net"))
+		Expect(err).To(MatchError(teffyio.ErrSchemaViolation))
+		Expect(err.Error()).To(ContainSubstring("url must be absolute"))
+	})
+
+	It("checks typed args", func() {
+		type compileArgs struct {
+			Mnemonic string `json:"mnemonic"`
+		}
+		e := &events.Complete{EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{Name: "compile"},
+			TypedArgs: compileArgs{Mnemonic: "Javac"},
+		}}
+		Expect(registry.ValidateEvent(e)).To(Succeed())
+	})
+
+	It("validates whole traces", func() {
+		data := &teffyio.TefData{}
+		data.Write(event("compile", map[string]interface{}{"mnemonic": "CppCompile"}))
+		data.Write(event("compile", nil))
+		data.Write(event("fetch", map[string]interface{}{"status": true}, "net"))
+
+		err := registry.Validate(data)
+		var schemaErrs teffyio.SchemaErrors
+		Expect(errors.As(err, &schemaErrs)).To(BeTrue())
+		Expect(schemaErrs).To(HaveLen(2))
+		Expect(schemaErrs[0].Index).To(Equal(1))
+		Expect(schemaErrs[1].Index).To(Equal(2))
+		Expect(errors.Is(err, teffyio.ErrSchemaViolation)).To(BeTrue())
+
+		Expect(teffyio.NewSchemaRegistry().Validate(data)).To(Succeed())
+	})
+
+	Context("when parsing", func() {
+		const trace = `[{"ph":"X","name":"compile","dur":1,"ts":1,"args":{"mnemonic":"Javac"}},{"ph":"X","name":"compile","dur":1,"ts":2}]`
+
+		It("fails on events that do not match", func() {
+			_, err := teffyio.ParseJsonArray(strings.NewReader(trace), teffyio.WithArgSchemas(registry))
+			var parseErr *teffyio.ParseError
+			Expect(errors.As(err, &parseErr)).To(BeTrue())
+			Expect(parseErr.Index).To(Equal(1))
+			Expect(err).To(MatchError(teffyio.ErrSchemaViolation))
+		})
+
+		It("skips events that do not match when parsing leniently", func() {
+			data, err := teffyio.ParseJsonArray(strings.NewReader(trace), teffyio.WithArgSchemas(registry),
+				teffyio.WithLenientParsing())
+			Expect(errors.Is(err, teffyio.ErrSchemaViolation)).To(BeTrue())
+			Expect(data.Events()).To(HaveLen(1))
+		})
+	})
+})
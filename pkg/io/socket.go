@@ -0,0 +1,145 @@
+package io
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// maxSocketFrameLength limits the size of a single event read from a socket, so that a corrupt or hostile length
+// cannot exhaust memory
+const maxSocketFrameLength = 64 << 20
+
+// ErrFrameTooLarge means that an event read from a socket claimed to be larger than any event teffy will read
+var ErrFrameTooLarge = errors.New("socket frame too large")
+
+// SocketHandler is called by ServeSocket for each connection, to read the events streamed over it. The connection is
+// closed once the handler returns.
+type SocketHandler = func(addr net.Addr, r EventReader)
+
+type socketWriter struct {
+	w      io.WriteCloser
+	config *writeConfig
+	// buf is reused to encode each event, so that writing events does not allocate
+	buf []byte
+}
+
+// NewSocketWriter creates an event writer that streams each event immediately over a connection, such as to a Unix or
+// TCP socket served by ServeSocket, so that another process can watch the trace live. Each event is sent as a frame
+// holding the length of the event as a 4 byte big endian integer, followed by the event as JSON. As each frame is sent
+// with a single write, the writer may be used from several goroutines at once if the connection allows it, as a
+// net.Conn does.
+func NewSocketWriter(conn io.WriteCloser, options ...WriteOption) EventWriter {
+	return &socketWriter{
+		w:      conn,
+		config: newWriteConfig(options...),
+	}
+}
+
+// Write sends the provided event immediately as a single frame
+func (sw *socketWriter) Write(e events.Event) error {
+	// the length is filled in ahead of the event once it has been encoded
+	frame, err := sw.config.appendEvent(append(sw.buf[:0], 0, 0, 0, 0), e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal json event: %w", err)
+	}
+	sw.buf = frame
+
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	if _, err := sw.w.Write(frame); err != nil {
+		return fmt.Errorf("failed to send event: %w", err)
+	}
+	return nil
+}
+
+// Transient reports that each event is finished with once it is sent, as it has been encoded
+func (sw *socketWriter) Transient() bool {
+	return true
+}
+
+// Close closes the underlying connection
+func (sw *socketWriter) Close() error {
+	if err := sw.w.Close(); err != nil {
+		return fmt.Errorf("failed to close underlying connection: %w", err)
+	}
+	return nil
+}
+
+// NewSocketReader creates an EventReader that decodes the frames sent by a socket writer as they arrive. The stream
+// ending part way through a frame, as it does when the process sending it is killed, is treated as the end of it. An
+// event that cannot be parsed is reported as a *ParseError, after which reading can continue with the following event.
+func NewSocketReader(r io.Reader) EventReader {
+	return &socketReader{
+		r:      bufio.NewReader(r),
+		parser: newParser(),
+	}
+}
+
+type socketReader struct {
+	r      *bufio.Reader
+	parser *parser
+	offset int64
+	err    error
+}
+
+func (r *socketReader) Next() (events.Event, error) {
+	for r.err == nil {
+		var length [4]byte
+		if _, err := io.ReadFull(r.r, length[:]); err != nil {
+			r.err = endOfFrames(err)
+			break
+		}
+		size := binary.BigEndian.Uint32(length[:])
+		if size > maxSocketFrameLength {
+			r.err = fmt.Errorf("frame of %d bytes at byte offset %d: %w", size, r.offset, ErrFrameTooLarge)
+			break
+		}
+		// each event gets a buffer of its own, as the fields of an event that are not understood are kept as raw JSON
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(r.r, frame); err != nil {
+			r.err = endOfFrames(err)
+			break
+		}
+		offset := r.offset + int64(len(length))
+		r.offset = offset + int64(size)
+
+		event, err := r.parser.parseEvent(frame, offset)
+		if err != nil || event != nil {
+			return event, err
+		}
+	}
+	return nil, r.err
+}
+
+// endOfFrames treats the stream ending, including part way through a frame, as the end of the events
+func endOfFrames(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return io.EOF
+	}
+	return fmt.Errorf("failed to read frame: %w", err)
+}
+
+// ServeSocket accepts connections from the listener, such as a Unix or TCP socket, calling the handler on its own
+// goroutine with the events streamed over each connection by a socket writer. It returns once the listener is closed,
+// or with the error if accepting a connection otherwise fails.
+func ServeSocket(listener net.Listener, handler SocketHandler) error {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		go func() {
+			defer conn.Close()
+			handler(conn.RemoteAddr(), NewSocketReader(conn))
+		}()
+	}
+}
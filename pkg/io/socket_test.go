@@ -0,0 +1,95 @@
+package io_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Socket", func() {
+	instant := func(name string) events.Event {
+		return &events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: name, Timestamp: 1}},
+		}
+	}
+
+	readNames := func(r teffyio.EventReader) ([]string, error) {
+		names := []string{}
+		for {
+			e, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				return names, nil
+			}
+			if err != nil {
+				return names, err
+			}
+			names = append(names, e.Core().Name)
+		}
+	}
+
+	frame := func(payload string) string {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(payload)))
+		return string(length[:]) + payload
+	}
+
+	It("streams events between processes over a listener", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		received := make(chan []string, 1)
+		served := make(chan error, 1)
+		go func() {
+			served <- teffyio.ServeSocket(listener, func(addr net.Addr, r teffyio.EventReader) {
+				names, _ := readNames(r)
+				received <- names
+			})
+		}()
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		w := teffyio.NewSocketWriter(conn)
+		Expect(w.Write(instant("a"))).To(Succeed())
+		Expect(w.Write(instant("b"))).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		Eventually(received).Should(Receive(Equal([]string{"a", "b"})))
+
+		Expect(listener.Close()).To(Succeed())
+		Eventually(served).Should(Receive(BeNil()))
+	})
+
+	It("treats a frame cut short as the end of the events", func() {
+		stream := frame(`{"ph":"i","name":"a","ts":1}`) + frame(`{"ph":"i","name":"b","ts":2}`)[:10]
+		names, err := readNames(teffyio.NewSocketReader(strings.NewReader(stream)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names).To(Equal([]string{"a"}))
+	})
+
+	It("continues reading after an event that cannot be parsed", func() {
+		r := teffyio.NewSocketReader(strings.NewReader(frame(`{"ph":"X","name":"a","ts":"x"}`) + frame(`{"ph":"i","name":"b","ts":2}`)))
+
+		_, err := r.Next()
+		var parseErr *teffyio.ParseError
+		Expect(errors.As(err, &parseErr)).To(BeTrue())
+		Expect(parseErr.Offset).To(BeEquivalentTo(4))
+
+		e, err := r.Next()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e.Core().Name).To(Equal("b"))
+	})
+
+	It("refuses frames that are too large", func() {
+		r := teffyio.NewSocketReader(strings.NewReader("\xff\xff\xff\xff"))
+		_, err := r.Next()
+		Expect(errors.Is(err, teffyio.ErrFrameTooLarge)).To(BeTrue())
+	})
+})
@@ -0,0 +1,149 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// ErrDuplicateStackFrame means that more than one stack frame was given the same ID
+var ErrDuplicateStackFrame = errors.New("duplicate stack frame ID")
+
+// StackFramePolicy decides what becomes of a stack frame whose ID is already used by another, such as in a malformed
+// file or when merging traces whose frame IDs clash
+type StackFramePolicy int
+
+const (
+	// StackFramesKeepLast replaces the earlier frame with the later one
+	StackFramesKeepLast StackFramePolicy = iota
+	// StackFramesError fails with an error wrapping ErrDuplicateStackFrame
+	StackFramesError
+	// StackFramesKeepFirst keeps the earlier frame and discards the later one
+	StackFramesKeepFirst
+	// StackFramesRename keeps both frames, giving the later one an unused ID and remapping what refers to it
+	StackFramesRename
+)
+
+func (p StackFramePolicy) String() string {
+	switch p {
+	case StackFramesKeepLast:
+		return "keep-last"
+	case StackFramesError:
+		return "error"
+	case StackFramesKeepFirst:
+		return "keep-first"
+	case StackFramesRename:
+		return "rename"
+	}
+	return fmt.Sprintf("StackFramePolicy(%d)", int(p))
+}
+
+// ParseStackFramePolicy returns the policy with the given name, as given by its String method
+func ParseStackFramePolicy(name string) (StackFramePolicy, error) {
+	for _, p := range []StackFramePolicy{StackFramesKeepLast, StackFramesError, StackFramesKeepFirst, StackFramesRename} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown stack frame policy '%s'", name)
+}
+
+// WithStackFramePolicy decides what becomes of stack frames of a JSON Object Format file whose IDs are already used by
+// earlier frames of the file. Parsing keeps the last of them by default. Renamed frames are given the ID of the
+// original followed by '#' and a number, and the frames that follow them in the file and name their ID as their parent
+// are remapped to them; events, which cannot say which of the frames they meant, keep referring to the first.
+func WithStackFramePolicy(policy StackFramePolicy) ParseOption {
+	return func(p *parser) {
+		p.stackFramePolicy = policy
+	}
+}
+
+// WithWarnings passes warn an error describing each problem with the input that parsing works around rather than
+// failing, such as stack frames that share an ID, which wrap ErrDuplicateStackFrame
+func WithWarnings(warn func(err error)) ParseOption {
+	return func(p *parser) {
+		p.warn = warn
+	}
+}
+
+// warning tells the parser's warnings of a problem that was worked around, if anything is listening for them
+func (p *parser) warning(err error) {
+	if p.warn != nil {
+		p.warn(err)
+	}
+}
+
+// decodeStackFrames reads the stackFrames member of a JSON Object Format file, which is decoded token by token so that
+// frames sharing an ID are found rather than silently overwriting one another
+func (p *parser) decodeStackFrames(raw json.RawMessage) (map[string]*events.StackFrame, error) {
+	frames := map[string]*events.StackFrame{}
+	if len(raw) == 0 {
+		return frames, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	t, err := decoder.Token()
+	if err != nil {
+		return nil, fmt.Errorf("JSON decode error while parsing: %w", err)
+	}
+	if t == nil {
+		return frames, nil
+	}
+	if t != json.Delim('{') {
+		return nil, fmt.Errorf("expected stack frames to be an object: %w", ErrInvalidDataType)
+	}
+
+	// renamed holds the latest ID given to each duplicated ID, for the frames that follow to refer to
+	renamed := map[string]string{}
+	for decoder.More() {
+		t, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("JSON decode error while parsing: %w", err)
+		}
+		id := t.(string)
+		var f stackFrame
+		if err := decoder.Decode(&f); err != nil {
+			return nil, fmt.Errorf("JSON decode error while parsing stack frame '%s': %w", id, err)
+		}
+		frame := &events.StackFrame{Category: f.Category, Name: f.Name, Parent: f.Parent}
+		if parent, ok := renamed[frame.Parent]; ok {
+			frame.Parent = parent
+		}
+
+		if _, clash := frames[id]; !clash {
+			frames[id] = frame
+			continue
+		}
+		switch p.stackFramePolicy {
+		case StackFramesError:
+			return nil, fmt.Errorf("stack frame '%s': %w", id, ErrDuplicateStackFrame)
+		case StackFramesKeepFirst:
+			p.warning(fmt.Errorf("stack frame '%s', keeping the first: %w", id, ErrDuplicateStackFrame))
+		case StackFramesRename:
+			unused := unusedStackFrameID(frames, id)
+			p.warning(fmt.Errorf("stack frame '%s', renaming it '%s': %w", id, unused, ErrDuplicateStackFrame))
+			frames[unused] = frame
+			renamed[id] = unused
+		default:
+			p.warning(fmt.Errorf("stack frame '%s', keeping the last: %w", id, ErrDuplicateStackFrame))
+			frames[id] = frame
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("JSON decode error while parsing: %w", err)
+	}
+	return frames, nil
+}
+
+// unusedStackFrameID returns the first of the ID followed by '#' and a number that no frame has
+func unusedStackFrameID(frames map[string]*events.StackFrame, id string) string {
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s#%d", id, n)
+		if _, used := frames[candidate]; !used {
+			return candidate
+		}
+	}
+}
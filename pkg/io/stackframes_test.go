@@ -0,0 +1,108 @@
+package io_test
+
+import (
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Duplicate stack frames", func() {
+	const file = `{
+		"traceEvents": [],
+		"stackFrames": {
+			"1": {"category": "c", "name": "first"},
+			"2": {"category": "c", "name": "child", "parent": "1"},
+			"1": {"category": "c", "name": "second"},
+			"3": {"category": "c", "name": "grandchild", "parent": "1"}
+		}
+	}`
+
+	var (
+		data     *io.TefData
+		err      error
+		options  []io.ParseOption
+		warnings []error
+	)
+
+	BeforeEach(func() {
+		warnings = nil
+		options = []io.ParseOption{io.WithWarnings(func(err error) { warnings = append(warnings, err) })}
+	})
+
+	JustBeforeEach(func() {
+		data, err = io.ParseJsonObj(strings.NewReader(file), options...)
+	})
+
+	names := func() map[string]string {
+		result := map[string]string{}
+		for id, frame := range data.StackFrames() {
+			result[id] = frame.Name + "<" + frame.Parent
+		}
+		return result
+	}
+
+	It("keeps the last by default, warning of them", func() {
+		Expect(err).To(Succeed())
+		Expect(names()).To(Equal(map[string]string{"1": "second<", "2": "child<1", "3": "grandchild<1"}))
+		Expect(warnings).To(HaveLen(1))
+		Expect(warnings[0]).To(MatchError(io.ErrDuplicateStackFrame))
+	})
+
+	When("asked to fail", func() {
+		BeforeEach(func() {
+			options = append(options, io.WithStackFramePolicy(io.StackFramesError))
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(io.ErrDuplicateStackFrame))
+			Expect(warnings).To(BeEmpty())
+		})
+	})
+
+	When("asked to keep the first", func() {
+		BeforeEach(func() {
+			options = append(options, io.WithStackFramePolicy(io.StackFramesKeepFirst))
+		})
+
+		It("keeps the first, warning of them", func() {
+			Expect(err).To(Succeed())
+			Expect(names()).To(Equal(map[string]string{"1": "first<", "2": "child<1", "3": "grandchild<1"}))
+			Expect(warnings).To(HaveLen(1))
+		})
+	})
+
+	When("asked to rename them", func() {
+		BeforeEach(func() {
+			options = append(options, io.WithStackFramePolicy(io.StackFramesRename))
+		})
+
+		It("renames the later and remaps the frames that follow it", func() {
+			Expect(err).To(Succeed())
+			Expect(names()).To(Equal(map[string]string{
+				"1": "first<", "2": "child<1", "1#2": "second<", "3": "grandchild<1#2",
+			}))
+			Expect(warnings).To(HaveLen(1))
+			Expect(warnings[0].Error()).To(ContainSubstring("'1#2'"))
+		})
+	})
+
+	It("does not warn of files without them", func() {
+		warnings = nil
+		data, err = io.ParseJsonObj(strings.NewReader(`{"stackFrames": {"1": {"name": "a"}}}`), options...)
+		Expect(err).To(Succeed())
+		Expect(data.StackFrames()).To(Equal(map[string]*events.StackFrame{"1": {Name: "a"}}))
+		Expect(warnings).To(BeEmpty())
+	})
+
+	It("names policies as they are parsed", func() {
+		for _, policy := range []io.StackFramePolicy{io.StackFramesKeepLast, io.StackFramesError, io.StackFramesKeepFirst, io.StackFramesRename} {
+			Expect(io.ParseStackFramePolicy(policy.String())).To(Equal(policy))
+		}
+		_, err := io.ParseStackFramePolicy("shrug")
+		Expect(err).To(HaveOccurred())
+	})
+})
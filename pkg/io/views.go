@@ -0,0 +1,109 @@
+package io
+
+import (
+	"sort"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// Process is a view of the events of a single process within a trace
+type Process struct {
+	// ID is the process ID, events without one are treated as belonging to process 0
+	ID int64
+	// Name is the name given to the process by metadata, if any
+	Name string
+	// Labels are the labels given to the process by metadata, if any
+	Labels string
+	// SortIndex controls where the process is drawn relative to others, if given by metadata
+	SortIndex *int64
+	// Threads are the threads of the process, ordered by thread ID
+	Threads []*Thread
+	// Events are the events of the process that have no thread ID, in the order they appear in the trace
+	Events []events.Event
+}
+
+// Thread returns the thread of the process with the given ID, or nil if it has no events
+func (p *Process) Thread(tid int64) *Thread {
+	i := sort.Search(len(p.Threads), func(i int) bool { return p.Threads[i].ID >= tid })
+	if i < len(p.Threads) && p.Threads[i].ID == tid {
+		return p.Threads[i]
+	}
+	return nil
+}
+
+// Thread is a view of the events of a single thread within a trace
+type Thread struct {
+	// ProcessID is the ID of the process the thread belongs to
+	ProcessID int64
+	// ID is the thread ID
+	ID int64
+	// Name is the name given to the thread by metadata, if any
+	Name string
+	// SortIndex controls where the thread is drawn relative to others in its process, if given by metadata
+	SortIndex *int64
+	// Events are the events of the thread, in the order they appear in the trace
+	Events []events.Event
+}
+
+// Processes groups the events of the trace by process and thread, ordered by process ID. The metadata events naming,
+// labelling and sorting processes and threads are resolved into the fields of the views rather than being included
+// amongst their events, while all other events, including other metadata, are included. The views share the events
+// of the trace, but changes to the trace afterwards are not reflected in them.
+func (td TefData) Processes() []*Process {
+	processes := map[int64]*Process{}
+	threads := map[threadKey]*Thread{}
+
+	process := func(pid int64) *Process {
+		p, ok := processes[pid]
+		if !ok {
+			p = &Process{ID: pid}
+			processes[pid] = p
+		}
+		return p
+	}
+	thread := func(key threadKey) *Thread {
+		t, ok := threads[key]
+		if !ok {
+			t = &Thread{ProcessID: key.pid, ID: key.tid}
+			threads[key] = t
+			p := process(key.pid)
+			p.Threads = append(p.Threads, t)
+		}
+		return t
+	}
+
+	for _, e := range td.traceEvents {
+		core := e.Core()
+		key := threadKeyOf(core)
+		switch event := e.(type) {
+		case *events.MetadataProcessName:
+			process(key.pid).Name = event.ProcessName
+		case *events.MetadataProcessLabels:
+			process(key.pid).Labels = event.Labels
+		case *events.MetadataProcessSortIndex:
+			index := event.SortIndex
+			process(key.pid).SortIndex = &index
+		case *events.MetadataThreadName:
+			thread(key).Name = event.ThreadName
+		case *events.MetadataThreadSortIndex:
+			index := event.SortIndex
+			thread(key).SortIndex = &index
+		default:
+			if core.ThreadID == nil {
+				p := process(key.pid)
+				p.Events = append(p.Events, e)
+			} else {
+				t := thread(key)
+				t.Events = append(t.Events, e)
+			}
+		}
+	}
+
+	result := make([]*Process, 0, len(processes))
+	for _, p := range processes {
+		sort.Slice(p.Threads, func(i, j int) bool { return p.Threads[i].ID < p.Threads[j].ID })
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
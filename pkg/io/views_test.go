@@ -0,0 +1,59 @@
+package io_test
+
+import (
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	teffyio "github.com/omaskery/teffy/pkg/io"
+)
+
+var _ = Describe("Processes", func() {
+	var data teffyio.TefData
+
+	core := func(name string, pid, tid *int64) events.EventCore {
+		return events.EventCore{Name: name, ProcessID: pid, ThreadID: tid}
+	}
+
+	BeforeEach(func() {
+		pid1, pid2, tid1, tid2 := int64(1), int64(2), int64(10), int64(20)
+		data = teffyio.TefData{}
+		data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: core("b", &pid2, &tid1)}})
+		data.Write(&events.MetadataProcessName{EventCore: core("process_name", &pid1, nil), ProcessName: "browser"})
+		data.Write(&events.MetadataThreadName{EventCore: core("thread_name", &pid1, &tid2), ThreadName: "io"})
+		data.Write(&events.MetadataThreadSortIndex{EventCore: core("thread_sort_index", &pid1, &tid2), SortIndex: -1})
+		data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: core("a", &pid1, &tid2)}})
+		data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: core("c", &pid1, &tid1)}})
+		data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: core("d", &pid1, nil)}})
+		data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: core("e", nil, nil)}})
+	})
+
+	It("groups events by process and thread", func() {
+		processes := data.Processes()
+		Expect(processes).To(HaveLen(3))
+
+		Expect(processes[0].ID).To(BeEquivalentTo(0))
+		Expect(processes[0].Events).To(HaveLen(1))
+		Expect(processes[0].Events[0].Core().Name).To(Equal("e"))
+
+		browser := processes[1]
+		Expect(browser.ID).To(BeEquivalentTo(1))
+		Expect(browser.Name).To(Equal("browser"))
+		Expect(browser.Events).To(HaveLen(1))
+		Expect(browser.Events[0].Core().Name).To(Equal("d"))
+		Expect(browser.Threads).To(HaveLen(2))
+		Expect(browser.Threads[0].ID).To(BeEquivalentTo(10))
+		Expect(browser.Threads[0].Name).To(BeEmpty())
+		Expect(browser.Threads[1].ID).To(BeEquivalentTo(20))
+		Expect(browser.Threads[1].Name).To(Equal("io"))
+		Expect(browser.Threads[1].SortIndex).ToNot(BeNil())
+		Expect(*browser.Threads[1].SortIndex).To(BeEquivalentTo(-1))
+		Expect(browser.Threads[1].Events).To(HaveLen(1))
+		Expect(browser.Threads[1].Events[0].Core().Name).To(Equal("a"))
+
+		Expect(processes[2].ID).To(BeEquivalentTo(2))
+		Expect(processes[2].Thread(10)).ToNot(BeNil())
+		Expect(processes[2].Thread(10).Events[0].Core().Name).To(Equal("b"))
+		Expect(processes[2].Thread(20)).To(BeNil())
+	})
+})
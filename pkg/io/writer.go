@@ -1,11 +1,16 @@
 package io
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/omaskery/teffy/internal/jsonfields"
 	"github.com/omaskery/teffy/pkg/events"
 )
 
@@ -16,15 +21,161 @@ type EventWriter interface {
 	io.Closer
 }
 
+// StackFrameWriter is implemented by event writers that can hold the stack frames that events refer to by ID, as the
+// stack frames of a JSON Object Format file do, in place of being sent them as metadata events
+type StackFrameWriter interface {
+	// WriteStackFrame records the stack frame with the given ID
+	WriteStackFrame(id string, frame *events.StackFrame) error
+}
+
+// TransientWriter is implemented by event writers that have finished with each event by the time Write returns, such
+// as those encoding each event as it is written, allowing callers to reuse the events they write
+type TransientWriter interface {
+	EventWriter
+	// Transient reports whether the writer has finished with each event once Write returns
+	Transient() bool
+}
+
+// WriteOption configures how trace events are written
+type WriteOption = func(*writeConfig)
+
+// WithLegacyPhases writes async and instant events using their deprecated phases ("S", "T", "F" and "i"), for
+// consumers that only understand the older form of the Trace Event Format
+func WithLegacyPhases() WriteOption {
+	return func(c *writeConfig) {
+		c.legacyPhases = true
+	}
+}
+
+// WithSyncEvery has a streaming writer flush the events it has written to stable storage, by calling the Sync method of
+// the underlying writer (as provided by *os.File), after every n events. The events are also synced when the writer
+// is closed. This is ignored by writers that write a whole trace at once, or if the underlying writer cannot sync.
+func WithSyncEvery(n int) WriteOption {
+	return func(c *writeConfig) {
+		c.syncEvery = n
+	}
+}
+
+// WithSyncInterval is like WithSyncEvery, but syncs when an event is written at least the interval after the last
+// sync, so that writing many events in a short time does not cause as many syncs
+func WithSyncInterval(interval time.Duration) WriteOption {
+	return func(c *writeConfig) {
+		c.syncInterval = interval
+	}
+}
+
+// WithIndent writes each event of a JSON Array or JSON Object Format trace on lines of its own, indenting the fields of
+// the events, and the members of JSON Object Format files, by the given string (such as two spaces) so that traces
+// diff cleanly in code review and golden file tests. Writers of newline delimited JSON and of framed events ignore it.
+func WithIndent(indent string) WriteOption {
+	return func(c *writeConfig) {
+		c.indent = indent
+	}
+}
+
+// WithSortedKeys writes the fields of events, and of every object within them such as their args, in sorted order
+// rather than in the order the fields of each type of event are declared in (followed by those of typed args and any
+// unknown fields), so that the output does not depend on how the events were made. The members of JSON Object Format
+// files are sorted too. Events are no longer encoded without allocating when their keys are sorted.
+func WithSortedKeys() WriteOption {
+	return func(c *writeConfig) {
+		c.sortKeys = true
+	}
+}
+
+type writeConfig struct {
+	legacyPhases bool
+	syncEvery    int
+	syncInterval time.Duration
+	indent       string
+	sortKeys     bool
+}
+
+func newWriteConfig(options ...WriteOption) *writeConfig {
+	c := &writeConfig{}
+	for _, opt := range options {
+		opt(c)
+	}
+	return c
+}
+
+func (c *writeConfig) marshal(e events.Event) ([]byte, error) {
+	var msg []byte
+	var err error
+	if c.legacyPhases {
+		msg, err = events.MarshalLegacyEvent(e)
+	} else {
+		msg, err = events.MarshalEvent(e)
+	}
+	if err != nil || !c.sortKeys {
+		return msg, err
+	}
+	return sortKeys(msg)
+}
+
+// appendEvent appends the encoded event to the buffer, avoiding allocating for the most common events
+func (c *writeConfig) appendEvent(buf []byte, e events.Event) ([]byte, error) {
+	if c.legacyPhases || c.sortKeys {
+		msg, err := c.marshal(e)
+		return append(buf, msg...), err
+	}
+	return events.AppendEvent(buf, e)
+}
+
+// appendIndentedEvent appends the encoded event to the buffer as appendEvent does, indenting it as an event nested at
+// the given depth within a file when indenting
+func (c *writeConfig) appendIndentedEvent(buf []byte, e events.Event, depth int) ([]byte, error) {
+	if c.indent == "" {
+		return c.appendEvent(buf, e)
+	}
+	msg, err := c.marshal(e)
+	if err != nil {
+		return buf, err
+	}
+	indented := bytes.NewBuffer(buf)
+	if err := json.Indent(indented, msg, strings.Repeat(c.indent, depth), c.indent); err != nil {
+		return buf, err
+	}
+	return indented.Bytes(), nil
+}
+
+// newline returns what begins a line at the given depth of nesting within a file, which is nothing unless indenting
+func (c *writeConfig) newline(depth int) string {
+	if c.indent == "" {
+		return ""
+	}
+	return "\n" + strings.Repeat(c.indent, depth)
+}
+
+// space returns what follows the colon after a key, which is nothing unless indenting
+func (c *writeConfig) space() string {
+	if c.indent == "" {
+		return ""
+	}
+	return " "
+}
+
+// sortKeys encodes the JSON again with the keys of every object within it sorted, leaving numbers exactly as they were
+func sortKeys(msg []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(msg))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to sort keys: %w", err)
+	}
+	return json.Marshal(value)
+}
+
 // WriteJsonObject marshals the given data to the provided writer in the JSON Object Format form of Tracing Event Format
-func WriteJsonObject(w io.Writer, data TefData) error {
+func WriteJsonObject(w io.Writer, data TefData, options ...WriteOption) error {
 	jsonFile := jsonObjectFile{
-		TraceEvents:            make([]json.RawMessage, 0, len(data.Events())),
 		DisplayTimeUnit:        string(data.DisplayTimeUnit()),
 		StackFrames:            make(map[string]*stackFrame),
+		Samples:                data.Samples(),
 		SystemTraceEvents:      data.SystemTraceEvents(),
 		PowerTraceAsString:     data.PowerTraceAsString(),
 		ControllerTraceDataKey: data.ControllerTraceDataKey(),
+		OtherData:              data.OtherData(),
 		Metadata:               data.Metadata(),
 	}
 
@@ -36,57 +187,188 @@ func WriteJsonObject(w io.Writer, data TefData) error {
 		}
 	}
 
-	for _, event := range data.Events() {
-		msg, err := marshalJsonEvent(event)
-		if err != nil {
-			return fmt.Errorf("failed to marshal json event: %w", err)
-		}
+	// everything but the events is marshalled up front, the events are then written ahead of it one at a time
+	rest, err := json.Marshal(&jsonFile)
+	if err != nil {
+		return fmt.Errorf("failed to write JSON object file: %w", err)
+	}
 
-		jsonFile.TraceEvents = append(jsonFile.TraceEvents, msg)
+	config := newWriteConfig(options...)
+	bw := bufio.NewWriter(w)
+	if config.sortKeys {
+		err = writeSortedJsonObject(bw, data.Events(), rest, config)
+	} else {
+		err = writeJsonObject(bw, data.Events(), rest, config)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := bw.WriteString("\n"); err != nil {
+		return fmt.Errorf("failed to write JSON object file: %w", err)
 	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write JSON object file: %w", err)
+	}
+
+	return nil
+}
 
-	encoder := json.NewEncoder(w)
-	err := encoder.Encode(&jsonFile)
+// writeJsonObject writes the events as the first member of a JSON Object Format file, followed by the members that
+// rest, an encoded JSON object, holds
+func writeJsonObject(w *bufio.Writer, evs []events.Event, rest []byte, config *writeConfig) error {
+	if _, err := w.WriteString("{" + config.newline(1) + `"traceEvents":` + config.space()); err != nil {
+		return fmt.Errorf("failed to write JSON object file: %w", err)
+	}
+	if err := writeJsonEvents(w, evs, config, 1); err != nil {
+		return err
+	}
+	if config.indent != "" {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, rest, "", config.indent); err != nil {
+			return fmt.Errorf("failed to write JSON object file: %w", err)
+		}
+		rest = indented.Bytes()
+	}
+	// the members follow the events in place of the opening brace of the object they were encoded as
+	end := append([]byte(","), rest[1:]...)
+	if len(rest) <= 2 {
+		end = []byte(config.newline(0) + "}")
+	}
+	if _, err := w.Write(end); err != nil {
+		return fmt.Errorf("failed to write JSON object file: %w", err)
+	}
+	return nil
+}
+
+// writeSortedJsonObject writes a JSON Object Format file whose members, the events amongst them, are in sorted order
+func writeSortedJsonObject(w *bufio.Writer, evs []events.Event, rest []byte, config *writeConfig) error {
+	rest, err := sortKeys(rest)
+	if err != nil {
+		return err
+	}
+	members, err := jsonfields.Split(rest)
 	if err != nil {
 		return fmt.Errorf("failed to write JSON object file: %w", err)
 	}
+	keys := []string{"traceEvents"}
+	for key := range members {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
+	if err := w.WriteByte('{'); err != nil {
+		return fmt.Errorf("failed to write JSON object file: %w", err)
+	}
+	for i, key := range keys {
+		encodedKey, _ := json.Marshal(key)
+		separator := config.newline(1)
+		if i > 0 {
+			separator = "," + separator
+		}
+		if _, err := w.WriteString(separator + string(encodedKey) + ":" + config.space()); err != nil {
+			return fmt.Errorf("failed to write JSON object file: %w", err)
+		}
+		if key == "traceEvents" {
+			if err := writeJsonEvents(w, evs, config, 1); err != nil {
+				return err
+			}
+			continue
+		}
+		value := []byte(members[key])
+		if config.indent != "" {
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, value, config.indent, config.indent); err != nil {
+				return fmt.Errorf("failed to write JSON object file: %w", err)
+			}
+			value = indented.Bytes()
+		}
+		if _, err := w.Write(value); err != nil {
+			return fmt.Errorf("failed to write JSON object file: %w", err)
+		}
+	}
+	if _, err := w.WriteString(config.newline(0) + "}"); err != nil {
+		return fmt.Errorf("failed to write JSON object file: %w", err)
+	}
 	return nil
 }
 
 // WriteJsonArray marshals the given events to the provided writer in the JSON Array Format form of Tracing Event Format
-func WriteJsonArray(w io.Writer, events []events.Event) error {
-	jsonEvents := make([]json.RawMessage, 0, len(events))
+func WriteJsonArray(w io.Writer, events []events.Event, options ...WriteOption) error {
+	bw := bufio.NewWriter(w)
+	if err := writeJsonEvents(bw, events, newWriteConfig(options...), 0); err != nil {
+		return err
+	}
+	if _, err := bw.WriteString("\n"); err != nil {
+		return fmt.Errorf("failed to write JSON array file: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write JSON array file: %w", err)
+	}
 
-	for _, e := range events {
-		msg, err := marshalJsonEvent(e)
+	return nil
+}
+
+// writeJsonEvents writes the events as a JSON array nested at the given depth within the file, each event is
+// marshalled once and written as it is, rather than being collected and encoded again as part of a larger value
+func writeJsonEvents(w *bufio.Writer, evs []events.Event, config *writeConfig, depth int) error {
+	if err := w.WriteByte('['); err != nil {
+		return fmt.Errorf("failed to write JSON events: %w", err)
+	}
+	var buf []byte
+	for i, e := range evs {
+		buf = buf[:0]
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, config.newline(depth+1)...)
+		msg, err := config.appendIndentedEvent(buf, e, depth+1)
 		if err != nil {
 			return fmt.Errorf("failed to marshal json event: %w", err)
 		}
-
-		jsonEvents = append(jsonEvents, msg)
+		buf = msg
+		if _, err := w.Write(msg); err != nil {
+			return fmt.Errorf("failed to write JSON events: %w", err)
+		}
 	}
-
-	encoder := json.NewEncoder(w)
-	if err := encoder.Encode(jsonEvents); err != nil {
-		return fmt.Errorf("failed to write JSON array file: %w", err)
+	if len(evs) > 0 {
+		if _, err := w.WriteString(config.newline(depth)); err != nil {
+			return fmt.Errorf("failed to write JSON events: %w", err)
+		}
+	}
+	if err := w.WriteByte(']'); err != nil {
+		return fmt.Errorf("failed to write JSON events: %w", err)
 	}
-
 	return nil
 }
 
+// syncer is implemented by writers, like *os.File, that can flush what has been written to them to stable storage
+type syncer interface {
+	Sync() error
+}
+
 type streamingWriter struct {
 	w           io.WriteCloser
+	config      *writeConfig
+	syncs       periodicSyncer
 	initialised bool
+	hasEvents   bool
 	finalised   bool
+	// buf is reused to encode each event, so that writing events does not allocate
+	buf []byte
 }
 
 // NewStreamingWriter creates a new event writer designed to write events out immediately,
 // particularly useful when streaming events out continuously to disk for analysing in the event of
 // a full crash of the tracing application. To achieve this the JSON Array Format is used.
-func NewStreamingWriter(w io.WriteCloser) EventWriter {
+func NewStreamingWriter(w io.WriteCloser, options ...WriteOption) EventWriter {
+	return newStreamingWriter(w, newWriteConfig(options...))
+}
+
+func newStreamingWriter(w io.WriteCloser, config *writeConfig) *streamingWriter {
 	return &streamingWriter{
-		w: w,
+		w:      w,
+		config: config,
+		syncs:  periodicSyncer{w: w, config: config},
 	}
 }
 
@@ -104,349 +386,103 @@ func (sw *streamingWriter) Write(e events.Event) error {
 		if err := sw.initialise(); err != nil {
 			return err
 		}
-	} else {
-		if _, err := io.WriteString(sw.w, ","); err != nil {
-			return fmt.Errorf("error writing comma after previous event: %w", err)
-		}
 	}
-
-	msg, err := marshalJsonEvent(e)
+	sw.buf = sw.buf[:0]
+	if sw.hasEvents {
+		sw.buf = append(sw.buf, ',')
+	}
+	sw.buf = append(sw.buf, sw.config.newline(1)...)
+	msg, err := sw.config.appendIndentedEvent(sw.buf, e, 1)
 	if err != nil {
 		return fmt.Errorf("failed to marshal json event: %w", err)
 	}
+	sw.buf = msg
 
 	if _, err = sw.w.Write(msg); err != nil {
 		return fmt.Errorf("failed to write json event: %w", err)
 	}
+	sw.hasEvents = true
 
-	return nil
+	return sw.syncs.written()
 }
 
-// Close allows the streaming writer to close the underlying stream and ensure the output file is correctly formatted
-func (sw *streamingWriter) Close() error {
-	if sw.finalised {
-		return nil
-	}
+// Transient reports that each event is finished with once it is written, as it has been encoded
+func (sw *streamingWriter) Transient() bool {
+	return true
+}
 
-	if !sw.initialised {
-		if err := sw.initialise(); err != nil {
-			return err
-		}
-	}
+// periodicSyncer syncs the events written to a writer as often as the write options ask for
+type periodicSyncer struct {
+	w      io.Writer
+	config *writeConfig
 
-	if _, err := io.WriteString(sw.w, "]"); err != nil {
-		return fmt.Errorf("failed to write final array end: %w", err)
-	}
+	unsynced int
+	lastSync time.Time
+}
 
-	if err := sw.w.Close(); err != nil {
-		return fmt.Errorf("failed to close underlying writer: %w", err)
+// written records that an event was written, syncing if it is time to
+func (s *periodicSyncer) written() error {
+	s.unsynced++
+	if s.shouldSync() {
+		return s.sync()
 	}
-
 	return nil
 }
 
-func marshalJsonEvent(event events.Event) (json.RawMessage, error) {
-	jsonEvent, err := writeJsonEvent(event)
-	if err != nil {
-		return nil, fmt.Errorf("failed while preparing json event: %w", err)
+func (s *periodicSyncer) shouldSync() bool {
+	if s.config.syncEvery > 0 && s.unsynced >= s.config.syncEvery {
+		return true
 	}
-	msg, err := json.Marshal(jsonEvent)
-	if err != nil {
-		return nil, fmt.Errorf("failed to serialise json event: %w", err)
-	}
-	return msg, nil
-}
-
-func writeJsonEvent(event events.Event) (interface{}, error) {
-	switch e := event.(type) {
-	case *events.BeginDuration:
-		return jsonDurationEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args:          e.Args,
-			},
-			jsonStackInfo: writeStackInfo(e.StackTrace),
-		}, nil
-	case *events.EndDuration:
-		return jsonDurationEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args:          e.Args,
-			},
-			jsonStackInfo: writeStackInfo(e.StackTrace),
-		}, nil
-
-	case *events.Complete:
-		return jsonCompleteEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args:          e.Args,
-			},
-			jsonStackInfo: writeStackInfo(e.StackTrace),
-			EndStack:      writeStackInfo(e.EndStackTrace).Stack,
-			Duration:      e.Duration,
-		}, nil
-
-	case *events.Instant:
-		return jsonInstantEvent{
-			jsonEventCore: writeJsonEventCore(event),
-			jsonStackInfo: writeStackInfo(e.StackTrace),
-			Scope:         string(e.Scope),
-		}, nil
-
-	case *events.Counter:
-		return jsonCounterEvent{
-			jsonEventCore: writeJsonEventCore(event),
-			Values:        e.Values,
-		}, nil
-
-	case *events.AsyncBegin:
-		return jsonAsyncEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args:          e.Args,
-			},
-			jsonScopedId: jsonScopedId{
-				jsonId: jsonId{
-					Id: e.Id,
-				},
-				Scope: e.Scope,
-			},
-		}, nil
-	case *events.AsyncInstant:
-		return jsonAsyncEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args:          e.Args,
-			},
-			jsonScopedId: jsonScopedId{
-				jsonId: jsonId{
-					Id: e.Id,
-				},
-				Scope: e.Scope,
-			},
-		}, nil
-	case *events.AsyncEnd:
-		return jsonAsyncEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args:          e.Args,
-			},
-			jsonScopedId: jsonScopedId{
-				jsonId: jsonId{
-					Id: e.Id,
-				},
-				Scope: e.Scope,
-			},
-		}, nil
-
-	case *events.ObjectCreated:
-		return jsonObjectEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-			},
-			jsonScopedId: jsonScopedId{
-				jsonId: jsonId{
-					Id: e.Id,
-				},
-			},
-		}, nil
-	case *events.ObjectSnapshot:
-		return jsonObjectEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args:          e.Args,
-			},
-			jsonScopedId: jsonScopedId{
-				jsonId: jsonId{
-					Id: e.Id,
-				},
-			},
-		}, nil
-	case *events.ObjectDeleted:
-		return jsonObjectEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-			},
-			jsonScopedId: jsonScopedId{
-				jsonId: jsonId{
-					Id: e.Id,
-				},
-			},
-		}, nil
-
-	case *events.MetadataProcessName:
-		return jsonMetadataEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCoreWithName(event, string(events.MetadataKindProcessName)),
-				Args: map[string]interface{}{
-					"name": e.ProcessName,
-				},
-			},
-		}, nil
-	case *events.MetadataProcessLabels:
-		return jsonMetadataEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCoreWithName(event, string(events.MetadataKindProcessLabels)),
-				Args: map[string]interface{}{
-					"labels": e.Labels,
-				},
-			},
-		}, nil
-	case *events.MetadataProcessSortIndex:
-		return jsonMetadataEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCoreWithName(event, string(events.MetadataKindProcessSortIndex)),
-				Args: map[string]interface{}{
-					"sort_index": e.SortIndex,
-				},
-			},
-		}, nil
-	case *events.MetadataThreadName:
-		return jsonMetadataEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCoreWithName(event, string(events.MetadataKindThreadName)),
-				Args: map[string]interface{}{
-					"name": e.ThreadName,
-				},
-			},
-		}, nil
-	case *events.MetadataThreadSortIndex:
-		return jsonMetadataEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCoreWithName(event, string(events.MetadataKindThreadSortIndex)),
-				Args: map[string]interface{}{
-					"sort_index": e.SortIndex,
-				},
-			},
-		}, nil
-	case *events.MetadataMisc:
-		return jsonMetadataEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args:          e.Args,
-			},
-		}, nil
-
-	case *events.GlobalMemoryDump:
-		return jsonMemoryDumpEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args:          e.Args,
-			},
-		}, nil
-	case *events.ProcessMemoryDump:
-		return jsonMemoryDumpEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args:          e.Args,
-			},
-		}, nil
-
-	case *events.Mark:
-		return jsonMarkEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args:          e.Args,
-			},
-		}, nil
-
-	case *events.ClockSync:
-		return jsonClockSyncEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args: mergeDicts(e.Args, map[string]interface{}{
-					"sync_id":  e.SyncId,
-					"issue_ts": e.IssueTs,
-				}),
-			},
-		}, nil
-
-	case *events.ContextEnter:
-		return jsonContextEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args:          e.Args,
-			},
-			jsonId: jsonId{
-				Id: e.Id,
-			},
-		}, nil
-	case *events.ContextExit:
-		return jsonContextEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args:          e.Args,
-			},
-			jsonId: jsonId{
-				Id: e.Id,
-			},
-		}, nil
-
-	case *events.LinkIds:
-		return jsonLinkedIdEvent{
-			jsonEventWithArgs: jsonEventWithArgs{
-				jsonEventCore: writeJsonEventCore(event),
-				Args: mergeDicts(e.Args, map[string]interface{}{
-					"linked_id": e.LinkedId,
-				}),
-			},
-			jsonId: jsonId{
-				Id: e.Id,
-			},
-		}, nil
-	}
-
-	return nil, fmt.Errorf("unknown phase encountered: '%v'", event.Phase())
-}
-
-func mergeDicts(a, b map[string]interface{}) map[string]interface{} {
-	r := map[string]interface{}{}
-	for k, v := range a {
-		if v != nil {
-			r[k] = v
+	if s.config.syncInterval > 0 {
+		if s.lastSync.IsZero() {
+			s.lastSync = time.Now()
 		}
+		return time.Since(s.lastSync) >= s.config.syncInterval
 	}
-	for k, v := range b {
-		if v != nil {
-			r[k] = v
-		}
+	return false
+}
+
+// sync flushes the written events to stable storage, if syncing is enabled and the underlying writer supports it
+func (s *periodicSyncer) sync() error {
+	sy, ok := s.w.(syncer)
+	if !ok || (s.config.syncEvery < 1 && s.config.syncInterval <= 0) {
+		return nil
 	}
-	return r
+	s.unsynced = 0
+	s.lastSync = time.Now()
+	if err := sy.Sync(); err != nil {
+		return fmt.Errorf("failed to sync written events: %w", err)
+	}
+	return nil
 }
 
-func writeStackInfo(trace *events.StackTrace) jsonStackInfo {
-	var stack []string
+// Close allows the streaming writer to close the underlying stream and ensure the output file is correctly formatted
+func (sw *streamingWriter) Close() error {
+	if sw.finalised {
+		return nil
+	}
 
-	if trace != nil {
-		stack = make([]string, 0, len(trace.Trace))
-		for _, frame := range trace.Trace {
-			stack = append(stack, frame.Name)
+	if !sw.initialised {
+		if err := sw.initialise(); err != nil {
+			return err
 		}
 	}
 
-	return jsonStackInfo{
-		Stack: stack,
+	end := "]"
+	if sw.hasEvents {
+		end = sw.config.newline(0) + end
+	}
+	if _, err := io.WriteString(sw.w, end); err != nil {
+		return fmt.Errorf("failed to write final array end: %w", err)
 	}
-}
 
-func writeJsonEventCoreWithName(e events.Event, name string) jsonEventCore {
-	core := writeJsonEventCore(e)
-	core.Name = name
-	return core
-}
+	if err := sw.syncs.sync(); err != nil {
+		return err
+	}
 
-func writeJsonEventCore(e events.Event) jsonEventCore {
-	core := e.Core()
-	return jsonEventCore{
-		jsonEventPhase: jsonEventPhase{
-			Phase: string(e.Phase()),
-		},
-		Name:            core.Name,
-		Categories:      strings.Join(core.Categories, ","),
-		Timestamp:       core.Timestamp,
-		ThreadTimestamp: core.ThreadTimestamp,
-		ProcessID:       core.ProcessID,
-		ThreadID:        core.ThreadID,
+	if err := sw.w.Close(); err != nil {
+		return fmt.Errorf("failed to close underlying writer: %w", err)
 	}
+
+	return nil
 }
@@ -57,6 +57,25 @@ var _ = Describe("WriteJsonObject", func() {
 		})
 	})
 
+	When("other data and metadata are stored", func() {
+		BeforeEach(func() {
+			data.SetOtherData("version", "1.2.3")
+			data.SetMetadata("beginningOfTime", 100)
+			data.SetMetadata("traceEvents", "ignored")
+		})
+
+		It("generates expected output", func() {
+			Expect(err).To(Succeed())
+			Expect(output).To(MatchJSON(mustJson(map[string]interface{}{
+				"traceEvents": []interface{}{},
+				"otherData": map[string]interface{}{
+					"version": "1.2.3",
+				},
+				"beginningOfTime": 100,
+			})))
+		})
+	})
+
 	When("stack frames are stored", func() {
 		BeforeEach(func() {
 			data.SetStackFrame("one", &events.StackFrame{
@@ -168,6 +187,28 @@ var _ = Describe("WriteJsonObject", func() {
 		})
 	})
 
+	When("an event with extra fields is written", func() {
+		BeforeEach(func() {
+			core := minimalEventCore()
+			core.Extra = map[string]json.RawMessage{
+				"custom": json.RawMessage(`"good"`),
+				"ts":     json.RawMessage(`999`),
+			}
+			data.Write(&events.Instant{
+				EventWithArgs: events.EventWithArgs{EventCore: core},
+			})
+		})
+
+		It("includes the extra fields without overriding known fields", func() {
+			Expect(err).To(Succeed())
+			Expect(output).To(MatchJSON(testJsonObjFile(
+				eventJson(events.PhaseInstant, nil, map[string]interface{}{
+					"custom": "good",
+				}),
+			)))
+		})
+	})
+
 	When("a BeginDuration event is written", func() {
 		BeforeEach(func() {
 			data.Write(&events.BeginDuration{
@@ -211,13 +252,34 @@ var _ = Describe("WriteJsonObject", func() {
 				eventJson(events.PhaseComplete, minimalArgs(), nil),
 			)))
 		})
+
+		When("it has thread clock timings", func() {
+			BeforeEach(func() {
+				tts, tdur := int64(4), int64(5)
+				data.SetEvents([]events.Event{&events.Complete{
+					EventWithArgs:  minimalEventWithArgs(minimalArgs()),
+					ThreadDuration: &tdur,
+				}})
+				data.Events()[0].Core().ThreadTimestamp = &tts
+			})
+
+			It("writes the thread timestamp and duration", func() {
+				Expect(err).To(Succeed())
+				Expect(output).To(MatchJSON(testJsonObjFile(
+					eventJson(events.PhaseComplete, minimalArgs(), map[string]interface{}{
+						"tts":  4,
+						"tdur": 5,
+					}),
+				)))
+			})
+		})
 	})
 
 	When("an Instant event is written", func() {
 		Context("with no scope specified", func() {
 			BeforeEach(func() {
 				data.Write(&events.Instant{
-					EventCore: minimalEventCore(),
+					EventWithArgs: events.EventWithArgs{EventCore: minimalEventCore()},
 				})
 			})
 
@@ -232,8 +294,8 @@ var _ = Describe("WriteJsonObject", func() {
 		Context("with a scope specified", func() {
 			BeforeEach(func() {
 				data.Write(&events.Instant{
-					EventCore: minimalEventCore(),
-					Scope:     events.InstantScopeProcess,
+					EventWithArgs: events.EventWithArgs{EventCore: minimalEventCore()},
+					Scope:         events.InstantScopeProcess,
 				})
 			})
 
@@ -248,6 +310,28 @@ var _ = Describe("WriteJsonObject", func() {
 		})
 	})
 
+	When("a Sample event is written", func() {
+		BeforeEach(func() {
+			data.Write(&events.Sample{
+				EventCore: minimalEventCore(),
+				EventStackTrace: events.EventStackTrace{
+					StackTrace: &events.StackTrace{
+						Trace: []*events.StackFrame{{Name: "frame"}},
+					},
+				},
+			})
+		})
+
+		It("generates expected output", func() {
+			Expect(err).To(Succeed())
+			Expect(output).To(MatchJSON(testJsonObjFile(
+				eventJson(events.PhaseSample, nil, map[string]interface{}{
+					"stack": []interface{}{"frame"},
+				}),
+			)))
+		})
+	})
+
 	When("a Counter event is written", func() {
 		BeforeEach(func() {
 			data.Write(&events.Counter{
@@ -270,6 +354,47 @@ var _ = Describe("WriteJsonObject", func() {
 		})
 	})
 
+	When("a Counter event with an id and color is written", func() {
+		BeforeEach(func() {
+			core := minimalEventCore()
+			core.Color = "good"
+			data.Write(&events.Counter{
+				EventCore: core,
+				Values: map[string]float64{
+					"hello": 24,
+				},
+				Id: "such-id",
+			})
+		})
+
+		It("generates expected output", func() {
+			Expect(err).To(Succeed())
+			Expect(output).To(MatchJSON(testJsonObjFile(
+				eventJson(events.PhaseCounter, map[string]interface{}{
+					"hello": 24,
+				}, map[string]interface{}{
+					"id":    "such-id",
+					"cname": "good",
+				}),
+			)))
+		})
+	})
+
+	When("a Counter event with ordered series is written", func() {
+		BeforeEach(func() {
+			data.Write(&events.Counter{
+				EventCore: minimalEventCore(),
+				Values:    map[string]float64{"a": 1, "b": 2, "c": 3},
+				Series:    []string{"c", "a"},
+			})
+		})
+
+		It("writes the series in order", func() {
+			Expect(err).To(Succeed())
+			Expect(output).To(ContainSubstring(`"args":{"c":3,"a":1,"b":2}`))
+		})
+	})
+
 	When("a AsyncBegin event is written", func() {
 		BeforeEach(func() {
 			data.Write(&events.AsyncBegin{
@@ -597,18 +722,20 @@ var _ = Describe("WriteJsonObject", func() {
 var _ = Describe("WriteJsonArray", func() {
 	var writer strings.Builder
 	var data []events.Event
+	var options []teffyio.WriteOption
 	var err error
 	var output string
 
 	BeforeEach(func() {
 		writer = strings.Builder{}
 		data = make([]events.Event, 0)
+		options = nil
 		output = ""
 		err = nil
 	})
 
 	JustBeforeEach(func() {
-		err = teffyio.WriteJsonArray(&writer, data)
+		err = teffyio.WriteJsonArray(&writer, data, options...)
 		output = writer.String()
 	})
 
@@ -633,6 +760,33 @@ var _ = Describe("WriteJsonArray", func() {
 			)))
 		})
 	})
+
+	When("writing with legacy phases", func() {
+		BeforeEach(func() {
+			options = append(options, teffyio.WithLegacyPhases())
+			data = append(data,
+				&events.AsyncBegin{EventWithArgs: minimalEventWithArgs(nil), Id: "1"},
+				&events.AsyncInstant{EventWithArgs: minimalEventWithArgs(nil), Id: "1"},
+				&events.AsyncEnd{EventWithArgs: minimalEventWithArgs(nil), Id: "1"},
+				&events.Instant{
+					EventWithArgs: events.EventWithArgs{EventCore: minimalEventCore()},
+					Scope:         events.InstantScopeGlobal,
+				},
+				&events.BeginDuration{EventWithArgs: minimalEventWithArgs(nil)},
+			)
+		})
+
+		It("uses the deprecated phases of async and instant events", func() {
+			Expect(err).To(Succeed())
+			Expect(output).To(MatchJSON(testJsonArrFile(
+				eventJson(events.PhaseAsyncBeginLegacy, nil, map[string]interface{}{"id": "1"}),
+				eventJson(events.PhaseAsyncStepInto, nil, map[string]interface{}{"id": "1"}),
+				eventJson(events.PhaseAsyncEndLegacy, nil, map[string]interface{}{"id": "1"}),
+				eventJson(events.PhaseInstantLegacy, nil, map[string]interface{}{"s": "g"}),
+				eventJson(events.PhaseBeginDuration, nil, nil),
+			)))
+		})
+	})
 })
 
 var _ = Describe("StreamingWriter", func() {
@@ -734,8 +888,42 @@ var _ = Describe("StreamingWriter", func() {
 			})
 		})
 	})
+
+	Context("when syncing every few events", func() {
+		var syncing *syncingWriter
+
+		BeforeEach(func() {
+			syncing = &syncingWriter{}
+			stream = teffyio.NewStreamingWriter(syncing, teffyio.WithSyncEvery(2))
+		})
+
+		It("syncs after every few events and when closed", func() {
+			for i := 0; i < 5; i++ {
+				Expect(stream.Write(&events.Instant{
+					EventWithArgs: events.EventWithArgs{EventCore: minimalEventCore()},
+				})).To(Succeed())
+			}
+			Expect(syncing.syncs).To(Equal(2))
+			Expect(stream.Close()).To(Succeed())
+			Expect(syncing.syncs).To(Equal(3))
+		})
+	})
 })
 
+type syncingWriter struct {
+	strings.Builder
+	syncs int
+}
+
+func (w *syncingWriter) Sync() error {
+	w.syncs++
+	return nil
+}
+
+func (w *syncingWriter) Close() error {
+	return nil
+}
+
 type wrapper struct {
 	io.Writer
 }
@@ -785,6 +973,121 @@ func eventJson(phase events.Phase, args map[string]interface{}, extra map[string
 	return mustJson(j)
 }
 
+var _ = Describe("Write options", func() {
+	event := func() *events.Complete {
+		pid := int64(1)
+		return &events.Complete{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "compile", Timestamp: 1, ProcessID: &pid},
+				Args:      map[string]interface{}{"mnemonic": "CppCompile", "bytes": 12},
+			},
+			Duration: 2,
+		}
+	}
+
+	It("indents arrays of events", func() {
+		var w strings.Builder
+		Expect(teffyio.WriteJsonArray(&w, []events.Event{event(), event()}, teffyio.WithIndent("  "))).To(Succeed())
+		Expect(w.String()).To(Equal(`[
+  {
+    "ph": "X",
+    "name": "compile",
+    "ts": 1,
+    "pid": 1,
+    "args": {
+      "bytes": 12,
+      "mnemonic": "CppCompile"
+    },
+    "dur": 2
+  },
+  {
+    "ph": "X",
+    "name": "compile",
+    "ts": 1,
+    "pid": 1,
+    "args": {
+      "bytes": 12,
+      "mnemonic": "CppCompile"
+    },
+    "dur": 2
+  }
+]
+`))
+
+		w.Reset()
+		Expect(teffyio.WriteJsonArray(&w, nil, teffyio.WithIndent("  "))).To(Succeed())
+		Expect(w.String()).To(Equal("[]\n"))
+	})
+
+	It("sorts the keys of events", func() {
+		var w strings.Builder
+		Expect(teffyio.WriteJsonArray(&w, []events.Event{event()}, teffyio.WithSortedKeys())).To(Succeed())
+		Expect(w.String()).To(Equal(
+			`[{"args":{"bytes":12,"mnemonic":"CppCompile"},"dur":2,"name":"compile","ph":"X","pid":1,"ts":1}]` + "\n"))
+	})
+
+	It("indents and sorts the members of object files", func() {
+		data := teffyio.TefData{}
+		data.Write(event())
+		data.SetMetadata("zebra", map[string]interface{}{"b": 1, "a": 2})
+
+		var w strings.Builder
+		Expect(teffyio.WriteJsonObject(&w, data, teffyio.WithIndent("\t"), teffyio.WithSortedKeys())).To(Succeed())
+		Expect(w.String()).To(Equal(`{
+	"traceEvents": [
+		{
+			"args": {
+				"bytes": 12,
+				"mnemonic": "CppCompile"
+			},
+			"dur": 2,
+			"name": "compile",
+			"ph": "X",
+			"pid": 1,
+			"ts": 1
+		}
+	],
+	"zebra": {
+		"a": 2,
+		"b": 1
+	}
+}
+`))
+
+		w.Reset()
+		Expect(teffyio.WriteJsonObject(&w, data, teffyio.WithIndent(" "))).To(Succeed())
+		Expect(w.String()).To(HavePrefix("{\n \"traceEvents\": [\n  {\n   \"ph\": \"X\","))
+		Expect(w.String()).To(HaveSuffix("],\n \"zebra\": {\n  \"a\": 2,\n  \"b\": 1\n }\n}\n"))
+		parsed, err := teffyio.ParseJsonObj(strings.NewReader(w.String()))
+		Expect(err).To(Succeed())
+		Expect(parsed.Events()).To(HaveLen(1))
+	})
+
+	It("indents streamed events", func() {
+		var w strings.Builder
+		stream := teffyio.NewStreamingWriter(writerNoopCloser(&w), teffyio.WithIndent("  "), teffyio.WithSortedKeys())
+		Expect(stream.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "a"}},
+		})).To(Succeed())
+		Expect(stream.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "b"}},
+		})).To(Succeed())
+		Expect(stream.Close()).To(Succeed())
+		Expect(w.String()).To(Equal(`[
+  {
+    "name": "a",
+    "ph": "I",
+    "ts": 0
+  },
+  {
+    "name": "b",
+    "ph": "I",
+    "ts": 0
+  }
+]`))
+	})
+})
+
 func minimalEventCore() events.EventCore {
 	return events.EventCore{
 		Name:      "event-name",
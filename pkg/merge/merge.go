@@ -0,0 +1,225 @@
+// merge provides the combination of several traces into one, for viewing traces from multiple sources together
+package merge
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// Source is a trace to be merged along with adjustments to make to its events
+type Source struct {
+	// Data is the trace to merge
+	Data *tio.TefData
+	// TimestampShift is added to the timestamp of every event in the trace, in microseconds
+	TimestampShift int64
+}
+
+// Option configures how traces are merged
+type Option = func(m *merger)
+
+// WithProcessIDRemapping gives the processes of a source new, unused process IDs if their process ID is already used
+// by an earlier source, so that processes from different traces are not confused with each other
+func WithProcessIDRemapping() Option {
+	return func(m *merger) {
+		m.remapPids = true
+	}
+}
+
+// WithClockSyncAlignment shifts the timestamps of each source, in addition to its TimestampShift, so that its
+// ClockSync events line up with ClockSync events sharing the same sync ID in the first source, or in another source
+// already lined up with it, as found by io.ClockOffsets. An error is returned if a source cannot be lined up.
+func WithClockSyncAlignment() Option {
+	return func(m *merger) {
+		m.alignClocks = true
+	}
+}
+
+// WithStackFramePolicy decides what becomes of the stack frames of a source whose IDs are already used by an earlier
+// source. By default every frame of such a source is renamed, as described by Merge. With io.StackFramesKeepFirst or
+// io.StackFramesKeepLast the events of both sources refer to the frame kept, and with io.StackFramesError merging fails
+// with an error wrapping io.ErrDuplicateStackFrame.
+func WithStackFramePolicy(policy tio.StackFramePolicy) Option {
+	return func(m *merger) {
+		m.stackFramePolicy = policy
+	}
+}
+
+// Merge combines the sources into a single trace. Events are taken from each source in turn and adjusted in place,
+// so they are shared with the sources and should not be used via the sources afterwards. Stack frame IDs that clash
+// between sources are renamed, and where other data such as metadata clashes the earliest source takes precedence.
+func Merge(sources []Source, options ...Option) (*tio.TefData, error) {
+	m := &merger{
+		result:           &tio.TefData{},
+		pids:             tio.NewProcessIDRemapper(),
+		stackFramePolicy: tio.StackFramesRename,
+	}
+	for _, opt := range options {
+		opt(m)
+	}
+
+	offsets := make([]int64, len(sources))
+	if m.alignClocks {
+		traces := make([]*tio.TefData, 0, len(sources))
+		for _, source := range sources {
+			traces = append(traces, source.Data)
+		}
+		var err error
+		if offsets, err = tio.ClockOffsets(traces...); err != nil {
+			return nil, fmt.Errorf("failed to align clocks: %w", err)
+		}
+	}
+
+	for i, source := range sources {
+		if err := m.add(i, source, source.TimestampShift+offsets[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.result, nil
+}
+
+type merger struct {
+	remapPids        bool
+	alignClocks      bool
+	stackFramePolicy tio.StackFramePolicy
+
+	result *tio.TefData
+	pids   *tio.ProcessIDRemapper
+}
+
+func (m *merger) add(index int, source Source, shift int64) error {
+	data := source.Data
+
+	var pids map[int64]int64
+	if m.remapPids {
+		pids = m.pids.Mapping(data)
+	}
+	frameIDs, err := m.mergeStackFrames(index, data)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range data.Events() {
+		core := e.Core()
+		if e.Phase() != events.PhaseMetadata {
+			core.Timestamp += shift
+		}
+		if core.ProcessID != nil {
+			if pid, ok := pids[*core.ProcessID]; ok {
+				core.ProcessID = &pid
+			}
+		}
+		renameStackFrames(e, frameIDs)
+		m.result.Write(e)
+	}
+	for _, s := range data.Samples() {
+		copied := *s
+		copied.Timestamp += shift
+		if id, ok := frameIDs[copied.StackFrameID]; ok {
+			copied.StackFrameID = id
+		}
+		m.result.AddSample(&copied)
+	}
+
+	m.mergeProperties(data)
+	return nil
+}
+
+// mergeStackFrames copies the stack frames of the trace into the result, returning the renamed frame IDs if any of
+// them clash with the frames of an earlier source and are renamed
+func (m *merger) mergeStackFrames(index int, data *tio.TefData) (map[string]string, error) {
+	frames := data.StackFrames()
+	existing := m.result.StackFrames()
+
+	var clashes []string
+	for id := range frames {
+		if _, clash := existing[id]; clash {
+			clashes = append(clashes, id)
+		}
+	}
+	rename := len(clashes) > 0 && m.stackFramePolicy == tio.StackFramesRename
+	if len(clashes) > 0 && m.stackFramePolicy == tio.StackFramesError {
+		sort.Strings(clashes)
+		return nil, fmt.Errorf("source %d, stack frame '%s': %w", index, clashes[0], tio.ErrDuplicateStackFrame)
+	}
+
+	mapping := map[string]string{}
+	for id := range frames {
+		mapping[id] = id
+		if rename {
+			mapping[id] = fmt.Sprintf("%d:%s", index, id)
+		}
+	}
+
+	for id, frame := range frames {
+		if _, clash := existing[id]; clash && m.stackFramePolicy == tio.StackFramesKeepFirst {
+			continue
+		}
+		copied := *frame
+		if copied.Parent != "" {
+			if parent, ok := mapping[copied.Parent]; ok {
+				copied.Parent = parent
+			}
+		}
+		m.result.SetStackFrame(mapping[id], &copied)
+	}
+
+	if !rename {
+		return nil, nil
+	}
+	return mapping, nil
+}
+
+func (m *merger) mergeProperties(data *tio.TefData) {
+	if m.result.DisplayTimeUnit() == "" {
+		m.result.SetDisplayTimeUnit(data.DisplayTimeUnit())
+	}
+	if m.result.SystemTraceEvents() == "" {
+		m.result.SetSystemTraceEvents(data.SystemTraceEvents())
+	}
+	if m.result.PowerTraceAsString() == "" {
+		m.result.SetPowerTraceString(data.PowerTraceAsString())
+	}
+	if m.result.ControllerTraceDataKey() == "" {
+		m.result.SetControllerTraceDataKey(data.ControllerTraceDataKey())
+	}
+	for key, value := range data.OtherData() {
+		if _, ok := m.result.OtherData()[key]; !ok {
+			m.result.SetOtherData(key, value)
+		}
+	}
+	for key, value := range data.Metadata() {
+		if _, ok := m.result.Metadata()[key]; !ok {
+			m.result.SetMetadata(key, value)
+		}
+	}
+}
+
+func renameStackFrames(e events.Event, mapping map[string]string) {
+	if mapping == nil {
+		return
+	}
+	switch event := e.(type) {
+	case *events.Complete:
+		event.StackFrameID = renamed(event.StackFrameID, mapping)
+		event.EndStackFrameID = renamed(event.EndStackFrameID, mapping)
+	case *events.BeginDuration:
+		event.StackFrameID = renamed(event.StackFrameID, mapping)
+	case *events.EndDuration:
+		event.StackFrameID = renamed(event.StackFrameID, mapping)
+	case *events.Instant:
+		event.StackFrameID = renamed(event.StackFrameID, mapping)
+	case *events.Sample:
+		event.StackFrameID = renamed(event.StackFrameID, mapping)
+	}
+}
+
+func renamed(id string, mapping map[string]string) string {
+	if replacement, ok := mapping[id]; ok {
+		return replacement
+	}
+	return id
+}
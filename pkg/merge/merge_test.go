@@ -0,0 +1,119 @@
+package merge_test
+
+import (
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/merge"
+)
+
+var _ = Describe("Merge", func() {
+	var a, b *tio.TefData
+
+	BeforeEach(func() {
+		a = &tio.TefData{}
+		a.SetDisplayTimeUnit(tio.DisplayTimeNs)
+		a.SetMetadata("source", "a")
+		a.SetStackFrame("1", &events.StackFrame{Name: "main"})
+		a.Write(&events.Instant{
+			EventWithArgs:   events.EventWithArgs{EventCore: core("a1", 10, 1)},
+			EventStackTrace: events.EventStackTrace{StackFrameID: "1"},
+		})
+
+		b = &tio.TefData{}
+		b.SetMetadata("source", "b")
+		b.SetMetadata("other", "b")
+		b.SetStackFrame("1", &events.StackFrame{Name: "worker"})
+		b.SetStackFrame("2", &events.StackFrame{Name: "child", Parent: "1"})
+		b.Write(&events.Instant{
+			EventWithArgs:   events.EventWithArgs{EventCore: core("b1", 5, 1)},
+			EventStackTrace: events.EventStackTrace{StackFrameID: "2"},
+		})
+		b.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: core("b2", 7, 2)}})
+	})
+
+	It("combines the events and data of all sources", func() {
+		result, err := merge.Merge([]merge.Source{{Data: a}, {Data: b, TimestampShift: 100}})
+		Expect(err).To(Succeed())
+		Expect(result.Events()).To(HaveLen(3))
+		Expect(result.Events()[1].Core().Timestamp).To(BeEquivalentTo(105))
+		Expect(*result.Events()[1].Core().ProcessID).To(BeEquivalentTo(1))
+		Expect(result.DisplayTimeUnit()).To(Equal(tio.DisplayTimeNs))
+		Expect(result.Metadata()).To(Equal(map[string]interface{}{"source": "a", "other": "b"}))
+	})
+
+	It("renames clashing stack frames", func() {
+		result, err := merge.Merge([]merge.Source{{Data: a}, {Data: b}})
+		Expect(err).To(Succeed())
+		Expect(result.StackFrames()).To(HaveLen(3))
+		Expect(result.StackFrames()["1"].Name).To(Equal("main"))
+		Expect(result.StackFrames()["1:1"].Name).To(Equal("worker"))
+		Expect(result.StackFrames()["1:2"].Parent).To(Equal("1:1"))
+		instant := result.Events()[1].(*events.Instant)
+		Expect(instant.StackFrameID).To(Equal("1:2"))
+	})
+
+	It("keeps the first of clashing stack frames when asked", func() {
+		result, err := merge.Merge([]merge.Source{{Data: a}, {Data: b}}, merge.WithStackFramePolicy(tio.StackFramesKeepFirst))
+		Expect(err).To(Succeed())
+		Expect(result.StackFrames()).To(HaveLen(2))
+		Expect(result.StackFrames()["1"].Name).To(Equal("main"))
+		Expect(result.StackFrames()["2"].Parent).To(Equal("1"))
+		Expect(result.Events()[1].(*events.Instant).StackFrameID).To(Equal("2"))
+	})
+
+	It("keeps the last of clashing stack frames when asked", func() {
+		result, err := merge.Merge([]merge.Source{{Data: a}, {Data: b}}, merge.WithStackFramePolicy(tio.StackFramesKeepLast))
+		Expect(err).To(Succeed())
+		Expect(result.StackFrames()).To(HaveLen(2))
+		Expect(result.StackFrames()["1"].Name).To(Equal("worker"))
+	})
+
+	It("fails on clashing stack frames when asked", func() {
+		_, err := merge.Merge([]merge.Source{{Data: a}, {Data: b}}, merge.WithStackFramePolicy(tio.StackFramesError))
+		Expect(err).To(MatchError(tio.ErrDuplicateStackFrame))
+	})
+
+	It("shifts the samples of each source and renames their stack frames", func() {
+		b.AddSample(&events.ProfileSample{ThreadID: 1, Timestamp: 6, StackFrameID: "2"})
+		result, err := merge.Merge([]merge.Source{{Data: a}, {Data: b, TimestampShift: 100}})
+		Expect(err).To(Succeed())
+		Expect(result.Samples()).To(Equal([]*events.ProfileSample{{ThreadID: 1, Timestamp: 106, StackFrameID: "1:2"}}))
+		Expect(b.Samples()[0].Timestamp).To(BeEquivalentTo(6))
+	})
+
+	It("remaps clashing process IDs when asked", func() {
+		result, err := merge.Merge([]merge.Source{{Data: a}, {Data: b}}, merge.WithProcessIDRemapping())
+		Expect(err).To(Succeed())
+		Expect(*result.Events()[0].Core().ProcessID).To(BeEquivalentTo(1))
+		Expect(*result.Events()[1].Core().ProcessID).To(BeEquivalentTo(3))
+		Expect(*result.Events()[2].Core().ProcessID).To(BeEquivalentTo(2))
+	})
+
+	When("aligning clocks", func() {
+		It("shifts sources to line up clock sync events", func() {
+			issue := int64(40)
+			a.Write(&events.ClockSync{EventWithArgs: events.EventWithArgs{EventCore: core("sync", 60, 1)}, SyncId: "s", IssueTs: &issue})
+			b.Write(&events.ClockSync{EventWithArgs: events.EventWithArgs{EventCore: core("sync", 20, 1)}, SyncId: "s"})
+			result, err := merge.Merge([]merge.Source{{Data: a}, {Data: b}}, merge.WithClockSyncAlignment())
+			Expect(err).To(Succeed())
+			Expect(result.Events()[2].Core().Timestamp).To(BeEquivalentTo(35))
+			Expect(result.Events()[4].Core().Timestamp).To(BeEquivalentTo(50))
+		})
+
+		It("fails when there are no common clock sync events", func() {
+			_, err := merge.Merge([]merge.Source{{Data: a}, {Data: b}}, merge.WithClockSyncAlignment())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+func core(name string, ts, pid int64) events.EventCore {
+	return events.EventCore{
+		Name:      name,
+		Timestamp: ts,
+		ProcessID: &pid,
+	}
+}
@@ -0,0 +1,70 @@
+// pipeline provides the processing of traces event by event through a series of composable transforms
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// Transform processes a single event, returning the event to pass on in its place and whether to pass on any event
+// at all. Transforms may modify the events they are given rather than copying them.
+type Transform = func(e events.Event) (events.Event, bool)
+
+// Run reads every event from the reader, passes it through each of the transforms in turn and writes whatever
+// remains to the writer. Events are processed one at a time, so traces of any size can be processed in constant
+// memory. The writer is not closed, so that more events can be written to it. An error is returned if an event
+// cannot be read or written.
+func Run(r tio.EventReader, w tio.EventWriter, transforms ...Transform) error {
+	for {
+		e, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read event: %w", err)
+		}
+
+		e, keep := apply(e, transforms)
+		if !keep {
+			continue
+		}
+		if err := w.Write(e); err != nil {
+			return fmt.Errorf("failed to write event: %w", err)
+		}
+	}
+}
+
+// Apply returns a copy of the trace in which each event has been passed through the transforms, all other data in
+// the trace (such as stack frames and metadata) is shared with the original
+func Apply(data *tio.TefData, transforms ...Transform) *tio.TefData {
+	var kept []events.Event
+	for _, e := range data.Events() {
+		if e, keep := apply(e, transforms); keep {
+			kept = append(kept, e)
+		}
+	}
+	result := *data
+	result.SetEvents(kept)
+	return &result
+}
+
+// Chain combines several transforms into one that applies each of them in turn
+func Chain(transforms ...Transform) Transform {
+	return func(e events.Event) (events.Event, bool) {
+		return apply(e, transforms)
+	}
+}
+
+func apply(e events.Event, transforms []Transform) (events.Event, bool) {
+	for _, t := range transforms {
+		var keep bool
+		if e, keep = t(e); !keep {
+			return nil, false
+		}
+	}
+	return e, true
+}
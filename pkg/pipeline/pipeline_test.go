@@ -0,0 +1,86 @@
+package pipeline_test
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/filter"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/pipeline"
+)
+
+type recordingWriter struct {
+	events []events.Event
+}
+
+func (w *recordingWriter) Write(e events.Event) error {
+	w.events = append(w.events, e)
+	return nil
+}
+
+func (w *recordingWriter) Close() error {
+	return nil
+}
+
+var _ = Describe("Run", func() {
+	It("passes each event read through the transforms to the writer", func() {
+		r := tio.NewJsonArrayReader(strings.NewReader(`[
+			{"name": "process_name", "ph": "M", "pid": 1, "args": {"name": "p"}},
+			{"name": "keep-a", "ph": "X", "ts": 10, "dur": 5, "pid": 1},
+			{"name": "drop", "ph": "X", "ts": 20, "dur": 5, "pid": 1},
+			{"name": "keep-b", "ph": "X", "ts": 30, "dur": 5, "pid": 2}
+		]`))
+		w := &recordingWriter{}
+
+		Expect(pipeline.Run(r, w,
+			pipeline.Filter(filter.ByName(regexp.MustCompile("^keep"))),
+			pipeline.Rename(regexp.MustCompile("^keep-"), "kept-"),
+			pipeline.ShiftTime(-10),
+			pipeline.RemapProcessIDs(map[int64]int64{2: 3}),
+		)).To(Succeed())
+
+		Expect(w.events).To(HaveLen(3))
+		Expect(w.events[0].Core().Name).To(Equal("process_name"))
+		Expect(w.events[1].Core().Name).To(Equal("kept-a"))
+		Expect(w.events[1].Core().Timestamp).To(BeEquivalentTo(0))
+		Expect(*w.events[1].Core().ProcessID).To(BeEquivalentTo(1))
+		Expect(w.events[2].Core().Name).To(Equal("kept-b"))
+		Expect(w.events[2].Core().Timestamp).To(BeEquivalentTo(20))
+		Expect(*w.events[2].Core().ProcessID).To(BeEquivalentTo(3))
+	})
+
+	It("fails when an event cannot be read", func() {
+		r := tio.NewJsonArrayReader(strings.NewReader(`[{"name": "bad", "ph": "C", "ts": 1, "args": {"value": "many"}}]`))
+		Expect(pipeline.Run(r, &recordingWriter{})).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("Downsample", func() {
+	It("keeps at most one event of each series per interval", func() {
+		data := &tio.TefData{}
+		counter := func(name string, ts int64) *events.Counter {
+			return &events.Counter{EventCore: events.EventCore{Name: name, Timestamp: ts}}
+		}
+		data.Write(counter("a", 0))
+		data.Write(counter("b", 5))
+		data.Write(counter("a", 5))
+		data.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "i", Timestamp: 6}},
+		})
+		data.Write(counter("a", 10))
+		data.Write(counter("b", 12))
+
+		result := pipeline.Apply(data, pipeline.Downsample(10))
+
+		var kept []string
+		for _, e := range result.Events() {
+			kept = append(kept, e.Core().Name)
+		}
+		Expect(kept).To(Equal([]string{"a", "b", "i", "a"}))
+		Expect(data.Events()).To(HaveLen(6))
+	})
+})
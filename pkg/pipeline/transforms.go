@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"regexp"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/filter"
+)
+
+// Filter passes on only the events matching the predicate
+func Filter(predicate filter.Predicate) Transform {
+	return func(e events.Event) (events.Event, bool) {
+		return e, predicate(e)
+	}
+}
+
+// Rename replaces the parts of event names matching the regular expression with the replacement, which may refer to
+// submatches as with regexp.Regexp.ReplaceAllString. The names of metadata events say what kind of metadata they are,
+// so are left unchanged.
+func Rename(re *regexp.Regexp, replacement string) Transform {
+	return func(e events.Event) (events.Event, bool) {
+		if e.Phase() != events.PhaseMetadata {
+			core := e.Core()
+			core.Name = re.ReplaceAllString(core.Name, replacement)
+		}
+		return e, true
+	}
+}
+
+// ShiftTime adds the delta, in microseconds, to the timestamps of every event other than metadata
+func ShiftTime(delta int64) Transform {
+	return func(e events.Event) (events.Event, bool) {
+		if e.Phase() == events.PhaseMetadata {
+			return e, true
+		}
+		e.Core().Timestamp += delta
+		if sync, ok := e.(*events.ClockSync); ok && sync.IssueTs != nil {
+			issueTs := *sync.IssueTs + delta
+			sync.IssueTs = &issueTs
+		}
+		return e, true
+	}
+}
+
+// RemapProcessIDs replaces the process ID of events whose process ID appears in the mapping
+func RemapProcessIDs(mapping map[int64]int64) Transform {
+	return func(e events.Event) (events.Event, bool) {
+		core := e.Core()
+		if core.ProcessID != nil {
+			if pid, ok := mapping[*core.ProcessID]; ok {
+				core.ProcessID = &pid
+			}
+		}
+		return e, true
+	}
+}
+
+type seriesKey struct {
+	name string
+	id   string
+	pid  int64
+	tid  int64
+}
+
+// Downsample thins out Counter and Sample events, which tend to be the most numerous, keeping at most one event of
+// each counter (or of each thread, for samples) per interval in microseconds. Events are assumed to arrive in order
+// of their timestamps, and all other events are passed on.
+func Downsample(interval int64) Transform {
+	last := map[seriesKey]int64{}
+	return func(e events.Event) (events.Event, bool) {
+		var key seriesKey
+		switch event := e.(type) {
+		case *events.Counter:
+			key = seriesKey{name: event.Name, id: event.Id}
+		case *events.Sample:
+		default:
+			return e, true
+		}
+
+		core := e.Core()
+		if core.ProcessID != nil {
+			key.pid = *core.ProcessID
+		}
+		if core.ThreadID != nil {
+			key.tid = *core.ThreadID
+		}
+		if ts, seen := last[key]; seen && core.Timestamp-ts < interval {
+			return nil, false
+		}
+		last[key] = core.Timestamp
+		return e, true
+	}
+}
@@ -0,0 +1,218 @@
+// scrub provides the redaction of sensitive information from traces, so that traces of production systems can be
+// shared more widely
+package scrub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/pipeline"
+)
+
+// Redacted replaces scrubbed values unless they are being hashed
+const Redacted = "<redacted>"
+
+// hashLength is the number of hex digits of the hash of a value kept when hashing, which is plenty to tell values
+// apart while keeping the trace readable
+const hashLength = 16
+
+// Option configures what a Scrubber removes from traces
+type Option = func(s *Scrubber)
+
+// WithNames scrubs the names of events matching the regular expression, along with the names given to processes and
+// threads by metadata that match it
+func WithNames(re *regexp.Regexp) Option {
+	return func(s *Scrubber) {
+		s.names = append(s.names, re)
+	}
+}
+
+// WithArgKeys scrubs the values of args with any of the given keys, including those of args nested within others
+func WithArgKeys(keys ...string) Option {
+	return func(s *Scrubber) {
+		for _, key := range keys {
+			s.argKeys[key] = struct{}{}
+		}
+	}
+}
+
+// WithStackFrameFiles scrubs the categories of stack frames, which hold the paths of the files that the frames'
+// functions are defined in
+func WithStackFrameFiles() Option {
+	return func(s *Scrubber) {
+		s.stackFrameFiles = true
+	}
+}
+
+// WithHashing replaces scrubbed values with a hash of the value and the salt rather than redacting them, so that
+// equal values can still be matched up with each other without revealing them. The salt should be kept secret, as
+// values that are easily guessed could otherwise be recovered by hashing guesses.
+func WithHashing(salt string) Option {
+	return func(s *Scrubber) {
+		s.hash = true
+		s.salt = salt
+	}
+}
+
+// Scrubber removes sensitive information from the events and stack frames of traces
+type Scrubber struct {
+	names           []*regexp.Regexp
+	argKeys         map[string]struct{}
+	stackFrameFiles bool
+	hash            bool
+	salt            string
+}
+
+// New creates a Scrubber removing the information selected by the options
+func New(options ...Option) *Scrubber {
+	s := &Scrubber{
+		argKeys: map[string]struct{}{},
+	}
+	for _, opt := range options {
+		opt(s)
+	}
+	return s
+}
+
+// Trace scrubs every event and stack frame of the trace in place
+func (s *Scrubber) Trace(data *tio.TefData) {
+	for _, e := range data.Events() {
+		s.Event(e)
+	}
+	if s.stackFrameFiles {
+		for id, frame := range data.StackFrames() {
+			data.SetStackFrame(id, s.frame(frame))
+		}
+	}
+}
+
+// Transform scrubs each event passing through a pipeline
+func (s *Scrubber) Transform() pipeline.Transform {
+	return func(e events.Event) (events.Event, bool) {
+		s.Event(e)
+		return e, true
+	}
+}
+
+// Event scrubs the event in place. Args and stack traces are replaced rather than modified, as they may be shared
+// with other events.
+func (s *Scrubber) Event(e events.Event) {
+	core := e.Core()
+	if e.Phase() != events.PhaseMetadata && s.matchesName(core.Name) {
+		core.Name = s.scrub(core.Name)
+	}
+
+	switch event := e.(type) {
+	case *events.MetadataProcessName:
+		if s.matchesName(event.ProcessName) {
+			event.ProcessName = s.scrub(event.ProcessName)
+		}
+	case *events.MetadataThreadName:
+		if s.matchesName(event.ThreadName) {
+			event.ThreadName = s.scrub(event.ThreadName)
+		}
+	case *events.BeginDuration:
+		s.stackTrace(&event.StackTrace)
+	case *events.EndDuration:
+		s.stackTrace(&event.StackTrace)
+	case *events.Complete:
+		s.stackTrace(&event.StackTrace)
+		s.stackTrace(&event.EndStackTrace)
+	case *events.Instant:
+		s.stackTrace(&event.StackTrace)
+	case *events.Sample:
+		s.stackTrace(&event.StackTrace)
+	}
+
+	if withArgs, ok := e.(interface {
+		events.ArgGetter
+		events.ArgSetter
+	}); ok && len(s.argKeys) > 0 && withArgs.GetArgs() != nil {
+		withArgs.SetArgs(s.args(withArgs.GetArgs()))
+	}
+}
+
+func (s *Scrubber) matchesName(name string) bool {
+	for _, re := range s.names {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// args returns a copy of the args with the values of the scrubbed keys replaced
+func (s *Scrubber) args(args map[string]interface{}) map[string]interface{} {
+	scrubbed := make(map[string]interface{}, len(args))
+	for key, value := range args {
+		if _, ok := s.argKeys[key]; ok {
+			scrubbed[key] = s.scrubValue(value)
+		} else {
+			scrubbed[key] = s.nestedArgs(value)
+		}
+	}
+	return scrubbed
+}
+
+// nestedArgs scrubs any args nested within the value of another arg
+func (s *Scrubber) nestedArgs(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return s.args(v)
+	case []interface{}:
+		scrubbed := make([]interface{}, len(v))
+		for i, item := range v {
+			scrubbed[i] = s.nestedArgs(item)
+		}
+		return scrubbed
+	default:
+		return value
+	}
+}
+
+func (s *Scrubber) scrubValue(value interface{}) interface{} {
+	if str, ok := value.(string); ok {
+		return s.scrub(str)
+	}
+	if !s.hash {
+		return Redacted
+	}
+	// values other than strings are hashed by their JSON encoding, so that equal values still match
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return Redacted
+	}
+	return s.scrub(string(encoded))
+}
+
+func (s *Scrubber) stackTrace(trace **events.StackTrace) {
+	if !s.stackFrameFiles || *trace == nil {
+		return
+	}
+	scrubbed := &events.StackTrace{Trace: make([]*events.StackFrame, 0, len((*trace).Trace))}
+	for _, frame := range (*trace).Trace {
+		scrubbed.Trace = append(scrubbed.Trace, s.frame(frame))
+	}
+	*trace = scrubbed
+}
+
+func (s *Scrubber) frame(frame *events.StackFrame) *events.StackFrame {
+	scrubbed := *frame
+	if scrubbed.Category != "" {
+		scrubbed.Category = s.scrub(scrubbed.Category)
+	}
+	return &scrubbed
+}
+
+// scrub redacts or hashes a single value
+func (s *Scrubber) scrub(value string) string {
+	if !s.hash {
+		return Redacted
+	}
+	sum := sha256.Sum256([]byte(s.salt + value))
+	return hex.EncodeToString(sum[:])[:hashLength]
+}
@@ -0,0 +1,79 @@
+package scrub_test
+
+import (
+	"regexp"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/scrub"
+)
+
+var _ = Describe("Scrubber", func() {
+	var data *tio.TefData
+	var begin *events.BeginDuration
+	var sharedArgs map[string]interface{}
+
+	BeforeEach(func() {
+		sharedArgs = map[string]interface{}{
+			"query":  "select * from customers",
+			"nested": map[string]interface{}{"query": 12, "rows": 3},
+		}
+		begin = &events.BeginDuration{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "lookup alice@example.com"},
+				Args:      sharedArgs,
+			},
+			EventStackTrace: events.EventStackTrace{
+				StackTrace: &events.StackTrace{Trace: []*events.StackFrame{
+					{Category: "/home/alice/src/main.go", Name: "main.main:10"},
+				}},
+			},
+		}
+
+		data = &tio.TefData{}
+		data.Write(&events.MetadataThreadName{
+			EventCore:  events.EventCore{Name: "thread_name"},
+			ThreadName: "worker for alice@example.com",
+		})
+		data.Write(begin)
+		data.Write(&events.EndDuration{
+			EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{Name: "lookup"}, Args: sharedArgs},
+		})
+		data.SetStackFrame("1", &events.StackFrame{Category: "/home/alice/src/lib.go", Name: "lib.Do:5"})
+	})
+
+	It("redacts the selected information", func() {
+		scrub.New(
+			scrub.WithNames(regexp.MustCompile(`@example\.com`)),
+			scrub.WithArgKeys("query"),
+			scrub.WithStackFrameFiles(),
+		).Trace(data)
+
+		Expect(data.Events()[0].Core().Name).To(Equal("thread_name"))
+		Expect(data.Events()[0].(*events.MetadataThreadName).ThreadName).To(Equal(scrub.Redacted))
+		Expect(begin.Name).To(Equal(scrub.Redacted))
+		Expect(begin.Args).To(Equal(map[string]interface{}{
+			"query":  scrub.Redacted,
+			"nested": map[string]interface{}{"query": scrub.Redacted, "rows": 3},
+		}))
+		Expect(begin.StackTrace.Trace[0].Category).To(Equal(scrub.Redacted))
+		Expect(begin.StackTrace.Trace[0].Name).To(Equal("main.main:10"))
+		Expect(data.StackFrames()["1"].Category).To(Equal(scrub.Redacted))
+		Expect(data.Events()[2].Core().Name).To(Equal("lookup"))
+		Expect(sharedArgs["query"]).To(Equal("select * from customers"))
+	})
+
+	It("hashes equal values to equal hashes", func() {
+		scrub.New(scrub.WithArgKeys("query"), scrub.WithHashing("secret")).Trace(data)
+
+		beginArgs := begin.Args
+		endArgs := data.Events()[2].(*events.EndDuration).Args
+		Expect(beginArgs["query"]).ToNot(Equal(scrub.Redacted))
+		Expect(beginArgs["query"]).ToNot(ContainSubstring("customers"))
+		Expect(beginArgs["query"]).To(Equal(endArgs["query"]))
+		Expect(beginArgs["nested"].(map[string]interface{})["query"]).ToNot(Equal(beginArgs["query"]))
+	})
+})
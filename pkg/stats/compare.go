@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"sort"
+)
+
+// Change compares the spans of a single name or category between two traces
+type Change struct {
+	// Name is the span name or category being compared
+	Name string
+	// Old summarises the spans in the old trace, it is nil if there were none
+	Old *Aggregate
+	// New summarises the spans in the new trace, it is nil if there were none
+	New *Aggregate
+	// CountDelta is how many more spans there are in the new trace
+	CountDelta int
+	// TotalDelta is how much longer the spans took in total in the new trace
+	TotalDelta int64
+	// MeanDelta is how much longer the spans took on average in the new trace
+	MeanDelta float64
+}
+
+// Added is true if the spans only appear in the new trace
+func (c Change) Added() bool {
+	return c.Old == nil
+}
+
+// Removed is true if the spans only appear in the old trace
+func (c Change) Removed() bool {
+	return c.New == nil
+}
+
+// TotalChange is the fractional change in total duration from the old trace to the new trace, which is zero if the
+// spans only appear in one of the traces
+func (c Change) TotalChange() float64 {
+	if c.Old == nil || c.New == nil || c.Old.Total == 0 {
+		return 0
+	}
+	return float64(c.TotalDelta) / float64(c.Old.Total)
+}
+
+// Comparison describes how the spans of a trace changed between an old and new version of it
+type Comparison struct {
+	// ByName compares spans by their name, ordered by the greatest increase in total duration first
+	ByName []Change
+	// ByCategory compares spans by each of their categories, ordered by the greatest increase in total duration first
+	ByCategory []Change
+}
+
+// Compare matches the spans of two traces by name and category, describing how they changed from the trace before
+// to the trace after
+func Compare(before, after *Stats) *Comparison {
+	return &Comparison{
+		ByName:     compareAggregates(before.ByName, after.ByName),
+		ByCategory: compareAggregates(before.ByCategory, after.ByCategory),
+	}
+}
+
+func compareAggregates(before, after map[string]*Aggregate) []Change {
+	names := map[string]struct{}{}
+	for name := range before {
+		names[name] = struct{}{}
+	}
+	for name := range after {
+		names[name] = struct{}{}
+	}
+
+	changes := make([]Change, 0, len(names))
+	for name := range names {
+		c := Change{Name: name, Old: before[name], New: after[name]}
+		if c.Old != nil {
+			c.CountDelta -= c.Old.Count
+			c.TotalDelta -= c.Old.Total
+			c.MeanDelta -= c.Old.Mean
+		}
+		if c.New != nil {
+			c.CountDelta += c.New.Count
+			c.TotalDelta += c.New.Total
+			c.MeanDelta += c.New.Mean
+		}
+		changes = append(changes, c)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].TotalDelta != changes[j].TotalDelta {
+			return changes[i].TotalDelta > changes[j].TotalDelta
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
@@ -0,0 +1,146 @@
+package stats
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// ConcurrencyCounter names the Counter events that AppendConcurrency plots the concurrency of a trace with
+const ConcurrencyCounter = "concurrency"
+
+// ConcurrencySample describes how much of a trace was running in parallel during a bucket of time
+type ConcurrencySample struct {
+	// Start is the timestamp the bucket begins at
+	Start int64
+	// Threads is the average number of threads within a span during the bucket
+	Threads float64
+	// Processes is the average number of processes with a thread within a span during the bucket
+	Processes float64
+}
+
+// Concurrency returns how many threads, and how many processes, were busy within spans over the course of the trace,
+// as a series of samples each averaging a bucket of time of the given size in microseconds. The samples run from the
+// start of the earliest span to the end of the latest, so that the points at which parallelism collapses can be seen.
+func (s *Stats) Concurrency(bucket int64) []ConcurrencySample {
+	if bucket <= 0 {
+		return nil
+	}
+
+	byProcess := map[int64][]interval{}
+	first, last := int64(0), int64(0)
+	found := false
+	for key, intervals := range s.busy {
+		if len(intervals) == 0 {
+			continue
+		}
+		byProcess[key.ProcessID] = append(byProcess[key.ProcessID], intervals...)
+		if start := intervals[0].start; !found || start < first {
+			first = start
+		}
+		if end := intervals[len(intervals)-1].end; !found || end > last {
+			last = end
+		}
+		found = true
+	}
+	if !found {
+		return nil
+	}
+
+	count := (last - first + bucket - 1) / bucket
+	if count < 1 {
+		count = 1
+	}
+	samples := make([]ConcurrencySample, count)
+	for i := range samples {
+		samples[i].Start = first + int64(i)*bucket
+	}
+	spread := func(intervals []interval, into func(*ConcurrencySample, float64)) {
+		for _, iv := range intervals {
+			for i := (iv.start - first) / bucket; i < count; i++ {
+				start, end := samples[i].Start, samples[i].Start+bucket
+				if start >= iv.end {
+					break
+				}
+				if iv.start > start {
+					start = iv.start
+				}
+				if iv.end < end {
+					end = iv.end
+				}
+				into(&samples[i], float64(end-start)/float64(bucket))
+			}
+		}
+	}
+
+	for _, intervals := range s.busy {
+		spread(intervals, func(sample *ConcurrencySample, busy float64) { sample.Threads += busy })
+	}
+	for _, intervals := range byProcess {
+		sort.Slice(intervals, func(i, j int) bool { return intervals[i].start < intervals[j].start })
+		spread(mergeIntervals(intervals), func(sample *ConcurrencySample, busy float64) { sample.Processes += busy })
+	}
+	return samples
+}
+
+// mergeIntervals merges the intervals, which must be sorted by their start, into the times that they cover
+func mergeIntervals(intervals []interval) []interval {
+	var result []interval
+	for _, iv := range intervals {
+		if n := len(result); n > 0 && iv.start <= result[n-1].end {
+			if iv.end > result[n-1].end {
+				result[n-1].end = iv.end
+			}
+			continue
+		}
+		result = append(result, iv)
+	}
+	return result
+}
+
+// AppendConcurrency plots the samples in the trace as Counter events named ConcurrencyCounter, with a series for the
+// threads and for the processes, on a process of their own named "concurrency" whose ID follows those of the trace's
+// other processes. The bucket is the size of the buckets the samples were taken with.
+func AppendConcurrency(data *tio.TefData, samples []ConcurrencySample, bucket int64) {
+	if len(samples) == 0 {
+		return
+	}
+	var pid int64
+	for _, e := range data.Events() {
+		if core := e.Core(); core.ProcessID != nil && *core.ProcessID >= pid {
+			pid = *core.ProcessID + 1
+		}
+	}
+
+	data.Write(events.NewProcessName(pid, ConcurrencyCounter))
+	counter := func(ts int64, threads, processes float64) *events.Counter {
+		return &events.Counter{
+			EventCore: events.EventCore{Name: ConcurrencyCounter, Timestamp: ts, ProcessID: &pid},
+			Values:    map[string]float64{"threads": threads, "processes": processes},
+		}
+	}
+	for _, sample := range samples {
+		data.Write(counter(sample.Start, sample.Threads, sample.Processes))
+	}
+	// the plot drops back to nothing once the last bucket is over
+	data.Write(counter(samples[len(samples)-1].Start+bucket, 0, 0))
+}
+
+// WriteConcurrencyCsv writes the samples as CSV, with a header row followed by the start, threads and processes of
+// each sample
+func WriteConcurrencyCsv(w io.Writer, samples []ConcurrencySample) error {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	rows := [][]string{{"start", "threads", "processes"}}
+	for _, sample := range samples {
+		rows = append(rows, []string{strconv.FormatInt(sample.Start, 10), f(sample.Threads), f(sample.Processes)})
+	}
+	if err := csv.NewWriter(w).WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write concurrency: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,404 @@
+// stats provides aggregate statistics about the events in a trace
+package stats
+
+import (
+	"sort"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// Aggregate summarises the durations of a group of spans, all durations are in microseconds
+type Aggregate struct {
+	// Count is the number of spans in the group
+	Count int
+	// Total is the sum of the durations of all spans in the group
+	Total int64
+	// Self is the sum of the durations of all spans in the group, excluding time spent in spans nested within them
+	Self int64
+	// ThreadTotal is the sum of the thread clock durations of the spans in the group that recorded them, which
+	// approximates the CPU time spent in the group
+	ThreadTotal int64
+	// Min is the shortest duration of any span in the group
+	Min int64
+	// Max is the longest duration of any span in the group
+	Max int64
+	// Mean is the average duration of the spans in the group
+	Mean float64
+	// P50 is the median duration of the spans in the group
+	P50 int64
+	// P95 is the 95th percentile duration of the spans in the group
+	P95 int64
+	// P99 is the 99th percentile duration of the spans in the group
+	P99 int64
+
+	durations []int64
+}
+
+// ThreadKey identifies a single thread within a single process
+type ThreadKey struct {
+	ProcessID int64
+	ThreadID  int64
+}
+
+// ThreadCoverage describes how much of a thread's lifetime in the trace was spent within spans
+type ThreadCoverage struct {
+	// Start is the earliest timestamp of any event on the thread
+	Start int64
+	// End is the latest timestamp of any event on the thread, including the end of any spans
+	End int64
+	// Busy is the total time that the thread was within at least one span
+	Busy int64
+	// Coverage is the fraction of the time between Start and End that the thread was Busy
+	Coverage float64
+}
+
+// Span is a single span of the trace, formed from a Complete event or a pair of duration events
+type Span struct {
+	ThreadKey
+	Name       string
+	Categories []string
+	// Start is the timestamp the span began at
+	Start int64
+	// Duration is how long the span lasted, in microseconds
+	Duration int64
+}
+
+// CounterKey identifies one series of the values of a counter
+type CounterKey struct {
+	Name   string
+	Series string
+}
+
+// CounterRange summarises the values that a series of a counter took
+type CounterRange struct {
+	// Count is the number of Counter events that gave the series a value
+	Count int
+	// Min is the smallest value of the series
+	Min float64
+	// Max is the largest value of the series
+	Max float64
+}
+
+// Stats are the aggregate statistics computed for a trace
+type Stats struct {
+	// EventCount is the number of events in the trace
+	EventCount int
+	// ByName aggregates spans by their name
+	ByName map[string]*Aggregate
+	// ByCategory aggregates spans by each of their categories
+	ByCategory map[string]*Aggregate
+	// Threads describes the wall clock coverage of each thread
+	Threads map[ThreadKey]*ThreadCoverage
+	// Counters describes the range of each series of each counter
+	Counters map[CounterKey]*CounterRange
+
+	spans []Span
+	// busy holds the times each thread was within at least one span, in order
+	busy map[ThreadKey][]interval
+}
+
+// NamedAggregate pairs an Aggregate with the name or category it was grouped by
+type NamedAggregate struct {
+	Name string
+	*Aggregate
+}
+
+// NamedThread pairs a ThreadCoverage with the thread it describes
+type NamedThread struct {
+	ThreadKey
+	*ThreadCoverage
+}
+
+// NamedCounter pairs a CounterRange with the series it describes
+type NamedCounter struct {
+	CounterKey
+	*CounterRange
+}
+
+// Compute calculates statistics for the events in the given trace. Spans are formed from Complete events and
+// matching pairs of BeginDuration and EndDuration events on the same thread, unmatched events are ignored.
+func Compute(data *tio.TefData) *Stats {
+	s := &Stats{
+		EventCount: len(data.Events()),
+		ByName:     map[string]*Aggregate{},
+		ByCategory: map[string]*Aggregate{},
+		Threads:    map[ThreadKey]*ThreadCoverage{},
+		Counters:   map[CounterKey]*CounterRange{},
+		busy:       map[ThreadKey][]interval{},
+	}
+
+	spansByThread := map[ThreadKey][]*span{}
+	open := map[ThreadKey][]*span{}
+	for _, e := range data.Events() {
+		if e.Phase() == events.PhaseMetadata {
+			continue
+		}
+		core := e.Core()
+		key := threadKeyOf(core)
+		s.observe(key, core.Timestamp)
+
+		switch event := e.(type) {
+		case *events.Complete:
+			spansByThread[key] = append(spansByThread[key], &span{
+				core:           core,
+				start:          core.Timestamp,
+				end:            core.Timestamp + event.Duration,
+				threadDuration: event.ThreadDuration,
+			})
+			s.observe(key, core.Timestamp+event.Duration)
+		case *events.BeginDuration:
+			open[key] = append(open[key], &span{
+				core:  core,
+				start: core.Timestamp,
+			})
+		case *events.EndDuration:
+			stack := open[key]
+			if len(stack) < 1 {
+				continue
+			}
+			begun := stack[len(stack)-1]
+			open[key] = stack[:len(stack)-1]
+			begun.end = core.Timestamp
+			if begun.core.ThreadTimestamp != nil && core.ThreadTimestamp != nil {
+				threadDuration := *core.ThreadTimestamp - *begun.core.ThreadTimestamp
+				begun.threadDuration = &threadDuration
+			}
+			spansByThread[key] = append(spansByThread[key], begun)
+		case *events.Counter:
+			for series, value := range event.Values {
+				s.observeCounter(CounterKey{Name: core.Name, Series: series}, value)
+			}
+		}
+	}
+
+	for key, spans := range spansByThread {
+		s.Threads[key].Busy = computeNesting(spans)
+		s.busy[key] = busyIntervals(spans)
+		for _, sp := range spans {
+			d := sp.end - sp.start
+			self := d - sp.childTime
+			s.spans = append(s.spans, Span{
+				ThreadKey:  key,
+				Name:       sp.core.Name,
+				Categories: sp.core.Categories,
+				Start:      sp.start,
+				Duration:   d,
+			})
+			aggregateInto(s.ByName, sp.core.Name, d, self, sp.threadDuration)
+			for _, category := range sp.core.Categories {
+				aggregateInto(s.ByCategory, category, d, self, sp.threadDuration)
+			}
+		}
+	}
+
+	for _, aggregates := range []map[string]*Aggregate{s.ByName, s.ByCategory} {
+		for _, a := range aggregates {
+			a.finalise()
+		}
+	}
+	for _, t := range s.Threads {
+		if t.End > t.Start {
+			t.Coverage = float64(t.Busy) / float64(t.End-t.Start)
+		}
+	}
+
+	return s
+}
+
+// Slowest returns up to n of the span names with the greatest total duration, slowest first
+func (s *Stats) Slowest(n int) []NamedAggregate {
+	return ranked(s.ByName, n)
+}
+
+// SlowestCategories returns up to n of the categories with the greatest total duration, slowest first
+func (s *Stats) SlowestCategories(n int) []NamedAggregate {
+	return ranked(s.ByCategory, n)
+}
+
+// Longest returns up to n of the individual spans with the greatest duration, longest first
+func (s *Stats) Longest(n int) []Span {
+	result := append([]Span(nil), s.spans...)
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Duration != b.Duration {
+			return a.Duration > b.Duration
+		}
+		if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		return a.Name < b.Name
+	})
+	if n >= 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// BusiestThreads returns up to n of the threads that spent the most time within spans, busiest first
+func (s *Stats) BusiestThreads(n int) []NamedThread {
+	result := make([]NamedThread, 0, len(s.Threads))
+	for key, t := range s.Threads {
+		result = append(result, NamedThread{ThreadKey: key, ThreadCoverage: t})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Busy != b.Busy {
+			return a.Busy > b.Busy
+		}
+		if a.ProcessID != b.ProcessID {
+			return a.ProcessID < b.ProcessID
+		}
+		return a.ThreadID < b.ThreadID
+	})
+	if n >= 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// SortedCounters returns the series of every counter, ordered by the name of the counter and then of the series
+func (s *Stats) SortedCounters() []NamedCounter {
+	result := make([]NamedCounter, 0, len(s.Counters))
+	for key, c := range s.Counters {
+		result = append(result, NamedCounter{CounterKey: key, CounterRange: c})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Series < result[j].Series
+	})
+	return result
+}
+
+func ranked(aggregates map[string]*Aggregate, n int) []NamedAggregate {
+	result := make([]NamedAggregate, 0, len(aggregates))
+	for name, a := range aggregates {
+		result = append(result, NamedAggregate{Name: name, Aggregate: a})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Total != result[j].Total {
+			return result[i].Total > result[j].Total
+		}
+		return result[i].Name < result[j].Name
+	})
+	if n >= 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+func (s *Stats) observe(key ThreadKey, ts int64) {
+	t, ok := s.Threads[key]
+	if !ok {
+		s.Threads[key] = &ThreadCoverage{Start: ts, End: ts}
+		return
+	}
+	if ts < t.Start {
+		t.Start = ts
+	}
+	if ts > t.End {
+		t.End = ts
+	}
+}
+
+func (s *Stats) observeCounter(key CounterKey, value float64) {
+	c, ok := s.Counters[key]
+	if !ok {
+		c = &CounterRange{Min: value, Max: value}
+		s.Counters[key] = c
+	}
+	c.Count++
+	if value < c.Min {
+		c.Min = value
+	}
+	if value > c.Max {
+		c.Max = value
+	}
+}
+
+type span struct {
+	core           *events.EventCore
+	start          int64
+	end            int64
+	childTime      int64
+	threadDuration *int64
+}
+
+// computeNesting attributes the duration of each span to the span directly enclosing it, returning the total
+// time covered by the outermost spans
+func computeNesting(spans []*span) int64 {
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end > spans[j].end
+	})
+
+	var busy int64
+	var stack []*span
+	for _, sp := range spans {
+		for len(stack) > 0 && stack[len(stack)-1].end <= sp.start {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) > 0 {
+			stack[len(stack)-1].childTime += sp.end - sp.start
+		} else {
+			busy += sp.end - sp.start
+		}
+		stack = append(stack, sp)
+	}
+
+	return busy
+}
+
+func aggregateInto(aggregates map[string]*Aggregate, key string, duration, self int64, threadDuration *int64) {
+	a, ok := aggregates[key]
+	if !ok {
+		a = &Aggregate{Min: duration, Max: duration}
+		aggregates[key] = a
+	}
+	a.Count++
+	a.Total += duration
+	a.Self += self
+	if threadDuration != nil {
+		a.ThreadTotal += *threadDuration
+	}
+	if duration < a.Min {
+		a.Min = duration
+	}
+	if duration > a.Max {
+		a.Max = duration
+	}
+	a.durations = append(a.durations, duration)
+}
+
+func (a *Aggregate) finalise() {
+	sort.Slice(a.durations, func(i, j int) bool { return a.durations[i] < a.durations[j] })
+	a.Mean = float64(a.Total) / float64(a.Count)
+	a.P50 = percentile(a.durations, 50)
+	a.P95 = percentile(a.durations, 95)
+	a.P99 = percentile(a.durations, 99)
+	a.durations = nil
+}
+
+// percentile uses the nearest-rank method to find the pth percentile of the sorted values
+func percentile(sorted []int64, p int) int64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func threadKeyOf(core *events.EventCore) ThreadKey {
+	var key ThreadKey
+	if core.ProcessID != nil {
+		key.ProcessID = *core.ProcessID
+	}
+	if core.ThreadID != nil {
+		key.ThreadID = *core.ThreadID
+	}
+	return key
+}
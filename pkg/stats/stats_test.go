@@ -0,0 +1,362 @@
+package stats_test
+
+import (
+	"bytes"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/stats"
+)
+
+var _ = Describe("Compute", func() {
+	var data tio.TefData
+	var s *stats.Stats
+
+	BeforeEach(func() {
+		data = tio.TefData{}
+	})
+
+	JustBeforeEach(func() {
+		s = stats.Compute(&data)
+	})
+
+	When("there are no events", func() {
+		It("produces empty statistics", func() {
+			Expect(s.EventCount).To(Equal(0))
+			Expect(s.ByName).To(BeEmpty())
+			Expect(s.ByCategory).To(BeEmpty())
+			Expect(s.Threads).To(BeEmpty())
+		})
+	})
+
+	When("there are nested spans", func() {
+		BeforeEach(func() {
+			data.Write(begin("outer", 0, "a"))
+			data.Write(complete("inner", 10, 20, "b"))
+			data.Write(complete("inner", 40, 40, "b"))
+			data.Write(end("outer", 100))
+			data.Write(complete("later", 150, 50, "a"))
+		})
+
+		It("aggregates by name", func() {
+			Expect(s.EventCount).To(Equal(5))
+			Expect(s.ByName).To(HaveLen(3))
+
+			outer := s.ByName["outer"]
+			Expect(outer.Count).To(Equal(1))
+			Expect(outer.Total).To(BeNumerically("==", 100))
+			Expect(outer.Self).To(BeNumerically("==", 40))
+
+			inner := s.ByName["inner"]
+			Expect(inner.Count).To(Equal(2))
+			Expect(inner.Total).To(BeNumerically("==", 60))
+			Expect(inner.Self).To(BeNumerically("==", 60))
+			Expect(inner.Min).To(BeNumerically("==", 20))
+			Expect(inner.Max).To(BeNumerically("==", 40))
+			Expect(inner.Mean).To(BeNumerically("==", 30))
+			Expect(inner.P50).To(BeNumerically("==", 20))
+			Expect(inner.P99).To(BeNumerically("==", 40))
+		})
+
+		It("aggregates by category", func() {
+			Expect(s.ByCategory).To(HaveLen(2))
+			Expect(s.ByCategory["a"].Count).To(Equal(2))
+			Expect(s.ByCategory["a"].Total).To(BeNumerically("==", 150))
+			Expect(s.ByCategory["b"].Total).To(BeNumerically("==", 60))
+		})
+
+		It("computes thread coverage", func() {
+			Expect(s.Threads).To(HaveLen(1))
+			thread := s.Threads[stats.ThreadKey{ProcessID: 1, ThreadID: 2}]
+			Expect(thread).ToNot(BeNil())
+			Expect(thread.Start).To(BeNumerically("==", 0))
+			Expect(thread.End).To(BeNumerically("==", 200))
+			Expect(thread.Busy).To(BeNumerically("==", 150))
+			Expect(thread.Coverage).To(BeNumerically("~", 0.75))
+		})
+
+		It("ranks the slowest operations", func() {
+			slowest := s.Slowest(2)
+			Expect(slowest).To(HaveLen(2))
+			Expect(slowest[0].Name).To(Equal("outer"))
+			Expect(slowest[1].Name).To(Equal("inner"))
+			Expect(s.SlowestCategories(-1)[0].Name).To(Equal("a"))
+		})
+
+		It("ranks the longest individual spans", func() {
+			longest := s.Longest(2)
+			Expect(longest).To(HaveLen(2))
+			Expect(longest[0]).To(Equal(stats.Span{
+				ThreadKey:  stats.ThreadKey{ProcessID: 1, ThreadID: 2},
+				Name:       "outer",
+				Categories: []string{"a"},
+				Start:      0,
+				Duration:   100,
+			}))
+			Expect(longest[1].Name).To(Equal("later"))
+			Expect(s.Longest(-1)).To(HaveLen(4))
+		})
+	})
+
+	When("there are several threads and counters", func() {
+		BeforeEach(func() {
+			data.Write(complete("short", 0, 10))
+			other := complete("long", 0, 30)
+			tid := int64(3)
+			other.Core().ThreadID = &tid
+			data.Write(other)
+			data.Write(&events.Counter{EventCore: core("memory", 5), Values: map[string]float64{"used": 4, "free": 1}})
+			data.Write(&events.Counter{EventCore: core("memory", 6), Values: map[string]float64{"used": 2}})
+		})
+
+		It("ranks the busiest threads", func() {
+			busiest := s.BusiestThreads(1)
+			Expect(busiest).To(HaveLen(1))
+			Expect(busiest[0].ThreadKey).To(Equal(stats.ThreadKey{ProcessID: 1, ThreadID: 3}))
+			Expect(busiest[0].Busy).To(BeNumerically("==", 30))
+		})
+
+		It("finds the range of each counter series", func() {
+			Expect(s.SortedCounters()).To(Equal([]stats.NamedCounter{
+				{CounterKey: stats.CounterKey{Name: "memory", Series: "free"}, CounterRange: &stats.CounterRange{Count: 1, Min: 1, Max: 1}},
+				{CounterKey: stats.CounterKey{Name: "memory", Series: "used"}, CounterRange: &stats.CounterRange{Count: 2, Min: 2, Max: 4}},
+			}))
+		})
+	})
+
+	When("spans have thread clock timings", func() {
+		BeforeEach(func() {
+			b := begin("outer", 0)
+			tts := int64(100)
+			b.Core().ThreadTimestamp = &tts
+			e := end("outer", 50)
+			ets := int64(130)
+			e.Core().ThreadTimestamp = &ets
+			c := complete("outer", 60, 10)
+			tdur := int64(7)
+			c.(*events.Complete).ThreadDuration = &tdur
+
+			data.Write(b)
+			data.Write(e)
+			data.Write(c)
+			data.Write(complete("outer", 80, 10))
+		})
+
+		It("sums the thread durations of the spans that have them", func() {
+			Expect(s.ByName["outer"].Total).To(BeNumerically("==", 70))
+			Expect(s.ByName["outer"].ThreadTotal).To(BeNumerically("==", 37))
+		})
+	})
+})
+
+func core(name string, ts int64, categories ...string) events.EventCore {
+	pid, tid := int64(1), int64(2)
+	return events.EventCore{
+		Name:       name,
+		Categories: categories,
+		Timestamp:  ts,
+		ProcessID:  &pid,
+		ThreadID:   &tid,
+	}
+}
+
+func begin(name string, ts int64, categories ...string) events.Event {
+	return &events.BeginDuration{
+		EventWithArgs: events.EventWithArgs{EventCore: core(name, ts, categories...)},
+	}
+}
+
+func end(name string, ts int64) events.Event {
+	return &events.EndDuration{
+		EventWithArgs: events.EventWithArgs{EventCore: core(name, ts)},
+	}
+}
+
+func complete(name string, ts, dur int64, categories ...string) events.Event {
+	return &events.Complete{
+		EventWithArgs: events.EventWithArgs{EventCore: core(name, ts, categories...)},
+		Duration:      dur,
+	}
+}
+
+var _ = Describe("Compare", func() {
+	var before, after tio.TefData
+	var comparison *stats.Comparison
+
+	BeforeEach(func() {
+		before = tio.TefData{}
+		before.Write(complete("compile", 0, 100, "build"))
+		before.Write(complete("link", 100, 50, "build"))
+		before.Write(complete("gone", 150, 5))
+
+		after = tio.TefData{}
+		after.Write(complete("compile", 0, 100, "build"))
+		after.Write(complete("compile", 100, 60, "build"))
+		after.Write(complete("link", 160, 40, "build"))
+		after.Write(complete("fresh", 200, 7))
+	})
+
+	JustBeforeEach(func() {
+		comparison = stats.Compare(stats.Compute(&before), stats.Compute(&after))
+	})
+
+	It("orders changes by the greatest increase in total duration", func() {
+		var names []string
+		for _, c := range comparison.ByName {
+			names = append(names, c.Name)
+		}
+		Expect(names).To(Equal([]string{"compile", "fresh", "gone", "link"}))
+	})
+
+	It("describes how matching spans changed", func() {
+		compile := comparison.ByName[0]
+		Expect(compile.Added()).To(BeFalse())
+		Expect(compile.Removed()).To(BeFalse())
+		Expect(compile.CountDelta).To(Equal(1))
+		Expect(compile.TotalDelta).To(BeNumerically("==", 60))
+		Expect(compile.MeanDelta).To(BeNumerically("~", -20))
+		Expect(compile.TotalChange()).To(BeNumerically("~", 0.6))
+	})
+
+	It("identifies new and removed spans", func() {
+		fresh, gone := comparison.ByName[1], comparison.ByName[2]
+		Expect(fresh.Added()).To(BeTrue())
+		Expect(fresh.TotalDelta).To(BeNumerically("==", 7))
+		Expect(gone.Removed()).To(BeTrue())
+		Expect(gone.CountDelta).To(Equal(-1))
+		Expect(gone.TotalChange()).To(BeZero())
+	})
+
+	It("compares categories", func() {
+		Expect(comparison.ByCategory).To(HaveLen(1))
+		Expect(comparison.ByCategory[0].TotalDelta).To(BeNumerically("==", 50))
+	})
+})
+
+var _ = Describe("Utilization", func() {
+	var data tio.TefData
+	var s *stats.Stats
+
+	worker := stats.ThreadKey{ProcessID: 1, ThreadID: 2}
+	idler := stats.ThreadKey{ProcessID: 1, ThreadID: 3}
+
+	BeforeEach(func() {
+		data = tio.TefData{}
+		data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: core("queued", 0)}})
+		data.Write(complete("build", 10, 20))
+		data.Write(complete("overlapping", 25, 15))
+		data.Write(complete("nested", 26, 2))
+		data.Write(complete("link", 41, 9))
+		data.Write(complete("test", 100, 50))
+		data.Write(&events.Instant{EventWithArgs: events.EventWithArgs{EventCore: core("done", 200)}})
+
+		pid, tid := int64(1), int64(3)
+		data.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "waiting", Timestamp: 5, ProcessID: &pid, ThreadID: &tid},
+			},
+		})
+		data.Write(&events.Instant{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: "waiting", Timestamp: 45, ProcessID: &pid, ThreadID: &tid},
+			},
+		})
+		s = stats.Compute(&data)
+	})
+
+	It("computes the busy and idle time of each thread with its gaps", func() {
+		utilization := s.Utilization(5)
+		Expect(utilization).To(HaveLen(2))
+
+		Expect(utilization[0].ThreadKey).To(Equal(worker))
+		Expect(utilization[0].Busy).To(BeNumerically("==", 89))
+		Expect(utilization[0].Idle).To(BeNumerically("==", 111))
+		Expect(utilization[0].Gaps).To(Equal([]stats.Gap{
+			{ThreadKey: worker, Start: 0, Duration: 10},
+			{ThreadKey: worker, Start: 50, Duration: 50},
+			{ThreadKey: worker, Start: 150, Duration: 50},
+		}))
+
+		Expect(utilization[1].ThreadKey).To(Equal(idler))
+		Expect(utilization[1].Busy).To(BeZero())
+		Expect(utilization[1].Idle).To(BeNumerically("==", 40))
+		Expect(utilization[1].Gaps).To(Equal([]stats.Gap{{ThreadKey: idler, Start: 5, Duration: 40}}))
+	})
+
+	It("reports every gap without a threshold", func() {
+		Expect(s.Utilization(0)[0].Gaps).To(ContainElement(stats.Gap{ThreadKey: worker, Start: 40, Duration: 1}))
+	})
+
+	It("ranks the longest gaps of all threads", func() {
+		Expect(s.LongestGaps(20, 3)).To(Equal([]stats.Gap{
+			{ThreadKey: worker, Start: 50, Duration: 50},
+			{ThreadKey: worker, Start: 150, Duration: 50},
+			{ThreadKey: idler, Start: 5, Duration: 40},
+		}))
+		Expect(s.LongestGaps(20, -1)).To(HaveLen(3))
+		Expect(s.LongestGaps(100, -1)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Concurrency", func() {
+	span := func(name string, pid, tid, ts, dur int64) events.Event {
+		return &events.Complete{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{Name: name, Timestamp: ts, ProcessID: &pid, ThreadID: &tid},
+			},
+			Duration: dur,
+		}
+	}
+
+	var data *tio.TefData
+	BeforeEach(func() {
+		data = &tio.TefData{}
+		data.Write(span("compile a", 1, 1, 0, 20))
+		data.Write(span("compile b", 1, 2, 0, 10))
+		data.Write(span("nested", 1, 2, 2, 5))
+		data.Write(span("compile c", 2, 1, 5, 10))
+		data.Write(span("link", 1, 1, 30, 10))
+	})
+
+	It("averages how many threads and processes were busy in each bucket", func() {
+		Expect(stats.Compute(data).Concurrency(10)).To(Equal([]stats.ConcurrencySample{
+			{Start: 0, Threads: 2.5, Processes: 1.5},
+			{Start: 10, Threads: 1.5, Processes: 1.5},
+			{Start: 20, Threads: 0, Processes: 0},
+			{Start: 30, Threads: 1, Processes: 1},
+		}))
+	})
+
+	It("has no samples without spans or buckets", func() {
+		Expect(stats.Compute(&tio.TefData{}).Concurrency(10)).To(BeEmpty())
+		Expect(stats.Compute(data).Concurrency(0)).To(BeEmpty())
+	})
+
+	It("plots the samples as counters on a process of their own", func() {
+		stats.AppendConcurrency(data, stats.Compute(data).Concurrency(20), 20)
+		evs := data.Events()[5:]
+		Expect(evs).To(HaveLen(4))
+		Expect(evs[0]).To(Equal(events.NewProcessName(3, stats.ConcurrencyCounter)))
+		var values []map[string]float64
+		for _, e := range evs[1:] {
+			counter := e.(*events.Counter)
+			Expect(*counter.ProcessID).To(Equal(int64(3)))
+			values = append(values, counter.Values)
+		}
+		Expect(values).To(Equal([]map[string]float64{
+			{"threads": 2, "processes": 1.5},
+			{"threads": 0.5, "processes": 0.5},
+			{"threads": 0, "processes": 0},
+		}))
+		Expect(evs[3].Core().Timestamp).To(Equal(int64(40)))
+	})
+
+	It("writes the samples as CSV", func() {
+		var buf bytes.Buffer
+		Expect(stats.WriteConcurrencyCsv(&buf, stats.Compute(data).Concurrency(20))).To(Succeed())
+		Expect(buf.String()).To(Equal("start,threads,processes\n0,2,1.5\n20,0.5,0.5\n"))
+	})
+})
@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"sort"
+)
+
+// Gap is a time that a thread spent outside of every span, idle as far as the trace can tell
+type Gap struct {
+	ThreadKey
+	// Start is the timestamp the thread became idle at
+	Start int64
+	// Duration is how long the thread was idle, in microseconds
+	Duration int64
+}
+
+// ThreadUtilization describes how busy a thread was between its first and last events, and when it sat idle
+type ThreadUtilization struct {
+	ThreadKey
+	ThreadCoverage
+	// Idle is the total time that the thread was not within any span
+	Idle int64
+	// Gaps are the times the thread was idle for at least the threshold asked for, in the order they happened
+	Gaps []Gap
+}
+
+// interval is a time that a thread was busy
+type interval struct {
+	start, end int64
+}
+
+// Utilization returns how busy each thread was, ordered by process and thread ID, along with the gaps between spans
+// (or between the first or last event of the thread and its spans) during which the thread was idle for at least the
+// threshold, in microseconds. Threads without any spans were idle throughout. Unlike the coverage of Threads, spans that
+// overlap without one nesting within the other are only counted once towards the time a thread was busy, so that
+// its busy and idle times add up to its lifetime.
+func (s *Stats) Utilization(threshold int64) []ThreadUtilization {
+	result := make([]ThreadUtilization, 0, len(s.Threads))
+	for key, t := range s.Threads {
+		u := ThreadUtilization{ThreadKey: key, ThreadCoverage: ThreadCoverage{Start: t.Start, End: t.End}}
+		idleFrom := t.Start
+		for _, busy := range append(s.busy[key], interval{start: t.End, end: t.End}) {
+			if gap := busy.start - idleFrom; gap > 0 {
+				u.Idle += gap
+				if gap >= threshold {
+					u.Gaps = append(u.Gaps, Gap{ThreadKey: key, Start: idleFrom, Duration: gap})
+				}
+			}
+			u.Busy += busy.end - busy.start
+			idleFrom = busy.end
+		}
+		if u.End > u.Start {
+			u.Coverage = float64(u.Busy) / float64(u.End-u.Start)
+		}
+		result = append(result, u)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ProcessID != result[j].ProcessID {
+			return result[i].ProcessID < result[j].ProcessID
+		}
+		return result[i].ThreadID < result[j].ThreadID
+	})
+	return result
+}
+
+// LongestGaps returns up to n of the gaps of all threads during which the thread was idle for at least the threshold,
+// longest first
+func (s *Stats) LongestGaps(threshold int64, n int) []Gap {
+	var result []Gap
+	for _, u := range s.Utilization(threshold) {
+		result = append(result, u.Gaps...)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Duration > result[j].Duration
+	})
+	if n >= 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// busyIntervals merges the spans, which must be sorted by their start, into the times that they cover
+func busyIntervals(spans []*span) []interval {
+	intervals := make([]interval, 0, len(spans))
+	for _, sp := range spans {
+		intervals = append(intervals, interval{start: sp.start, end: sp.end})
+	}
+	return mergeIntervals(intervals)
+}
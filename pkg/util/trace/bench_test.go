@@ -0,0 +1,118 @@
+package trace_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/util/trace"
+)
+
+// discardCloser is an io.WriteCloser that throws away everything written to it
+type discardCloser struct{}
+
+func (discardCloser) Write(p []byte) (int, error) {
+	return ioutil.Discard.Write(p)
+}
+
+func (discardCloser) Close() error {
+	return nil
+}
+
+// discardWriter is an EventWriter that throws away every event without looking at it, so that benchmarks using it
+// measure only the cost of the Tracer
+type discardWriter struct{}
+
+func (discardWriter) Write(events.Event) error {
+	return nil
+}
+
+func (discardWriter) Close() error {
+	return nil
+}
+
+func (discardWriter) Transient() bool {
+	return true
+}
+
+func BenchmarkBeginDuration(b *testing.B) {
+	tracer := trace.NewTracer(discardWriter{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tracer.BeginDuration("work").End()
+	}
+}
+
+func BenchmarkInstant(b *testing.B) {
+	tracer := trace.NewTracer(discardWriter{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tracer.Instant("tick")
+	}
+}
+
+func BenchmarkBeginDurationWithArgs(b *testing.B) {
+	tracer := trace.NewTracer(discardWriter{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tracer.BeginDuration("work", trace.WithArgs(map[string]interface{}{"index": i})).End()
+	}
+}
+
+func BenchmarkInstantWithStackTrace(b *testing.B) {
+	tracer := trace.NewTracer(discardWriter{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tracer.Instant("tick", trace.WithStackTrace(0))
+	}
+}
+
+func BenchmarkStreamingBeginDuration(b *testing.B) {
+	tracer := trace.NewTracer(tio.NewStreamingWriter(discardCloser{}))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tracer.BeginDuration("work").End()
+	}
+}
+
+func BenchmarkStreamingBeginDurationWithCategories(b *testing.B) {
+	tracer := trace.NewTracer(tio.NewStreamingWriter(discardCloser{}))
+	categories := trace.WithCategories("bench", "work")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tracer.BeginDuration("work", categories).End()
+	}
+}
+
+func BenchmarkStreamingInstant(b *testing.B) {
+	tracer := trace.NewTracer(tio.NewStreamingWriter(discardCloser{}))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tracer.Instant("tick")
+	}
+}
+
+func BenchmarkNoopBeginDuration(b *testing.B) {
+	tracer := trace.Noop()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tracer.BeginDuration("work").End()
+	}
+}
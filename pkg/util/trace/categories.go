@@ -0,0 +1,145 @@
+package trace
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// CategoriesEnvVar is the environment variable used to configure DefaultCategories when the program starts,
+// see CategoryRegistry.Configure for its format
+const CategoriesEnvVar = "TEFFY_CATEGORIES"
+
+// DefaultCategories is the CategoryRegistry consulted by Tracers unless they are given another with
+// WithCategoryRegistry
+var DefaultCategories = NewCategoryRegistry()
+
+func init() {
+	DefaultCategories.Configure(os.Getenv(CategoriesEnvVar))
+}
+
+// EnableCategory enables events with the given category in DefaultCategories
+func EnableCategory(category string) {
+	DefaultCategories.Enable(category)
+}
+
+// DisableCategory disables events with the given category in DefaultCategories
+func DisableCategory(category string) {
+	DefaultCategories.Disable(category)
+}
+
+// CategoryEnabled reports whether the given category is enabled in DefaultCategories, allowing expensive
+// instrumentation to be skipped entirely when its events would be discarded
+func CategoryEnabled(category string) bool {
+	return DefaultCategories.IsEnabled(category)
+}
+
+// WithCategoryRegistry makes the Tracer consult the given CategoryRegistry rather than DefaultCategories
+func WithCategoryRegistry(registry *CategoryRegistry) TracerOption {
+	return func(t *Tracer) {
+		t.categories = registry
+	}
+}
+
+// CategoryRegistry records which categories of events are enabled, so that instrumentation can be left in code and
+// toggled at runtime. Events are emitted if they have no categories or if any of their categories are enabled.
+// Categories that have not been explicitly enabled or disabled take the registry's default, which is enabled.
+type CategoryRegistry struct {
+	lock           sync.RWMutex
+	overrides      map[string]bool
+	defaultEnabled bool
+}
+
+// NewCategoryRegistry creates a CategoryRegistry in which all categories are enabled
+func NewCategoryRegistry() *CategoryRegistry {
+	return &CategoryRegistry{
+		overrides:      map[string]bool{},
+		defaultEnabled: true,
+	}
+}
+
+// Enable enables events with the given category, "*" enables all categories not explicitly disabled
+func (r *CategoryRegistry) Enable(category string) {
+	r.set(category, true)
+}
+
+// Disable disables events with the given category, "*" disables all categories not explicitly enabled
+func (r *CategoryRegistry) Disable(category string) {
+	r.set(category, false)
+}
+
+// IsEnabled reports whether events with the given category are enabled
+func (r *CategoryRegistry) IsEnabled(category string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.isEnabled(category)
+}
+
+// Configure replaces the state of the registry with that described by a comma separated list of categories,
+// like Chrome's tracing categories. Categories prefixed with "-" are disabled, others are enabled, and if any
+// categories are enabled then all unlisted categories are disabled. "*" and "-*" explicitly set whether unlisted
+// categories are enabled. An empty list enables all categories. For example "net,db" enables only the "net" and
+// "db" categories, while "-gc" enables everything but the "gc" category.
+func (r *CategoryRegistry) Configure(spec string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.overrides = map[string]bool{}
+	r.defaultEnabled = true
+
+	var explicitDefault bool
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		enabled := !strings.HasPrefix(entry, "-")
+		entry = strings.TrimPrefix(entry, "-")
+		switch entry {
+		case "":
+			continue
+		case "*":
+			r.defaultEnabled = enabled
+			explicitDefault = true
+		default:
+			r.overrides[entry] = enabled
+			if enabled && !explicitDefault {
+				r.defaultEnabled = false
+			}
+		}
+	}
+}
+
+func (r *CategoryRegistry) set(category string, enabled bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if category == "*" {
+		r.defaultEnabled = enabled
+		return
+	}
+	r.overrides[category] = enabled
+}
+
+func (r *CategoryRegistry) isEnabled(category string) bool {
+	if enabled, ok := r.overrides[category]; ok {
+		return enabled
+	}
+	return r.defaultEnabled
+}
+
+// allows reports whether an event with the given categories should be emitted
+func (r *CategoryRegistry) allows(e events.Event) bool {
+	categories := e.Core().Categories
+	if len(categories) < 1 {
+		return true
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, category := range categories {
+		if r.isEnabled(category) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package trace_test
+
+import (
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/util/trace"
+	"github.com/omaskery/teffy/pkg/util/trace/tracetest"
+)
+
+var _ = Describe("CategoryRegistry", func() {
+	var registry *trace.CategoryRegistry
+
+	BeforeEach(func() {
+		registry = trace.NewCategoryRegistry()
+	})
+
+	It("enables all categories by default", func() {
+		Expect(registry.IsEnabled("net")).To(BeTrue())
+	})
+
+	It("can enable and disable categories", func() {
+		registry.Disable("net")
+		Expect(registry.IsEnabled("net")).To(BeFalse())
+		registry.Disable("*")
+		registry.Enable("net")
+		Expect(registry.IsEnabled("net")).To(BeTrue())
+		Expect(registry.IsEnabled("gc")).To(BeFalse())
+	})
+
+	DescribeTable("configuring from a list of categories",
+		func(spec string, enabled []string, disabled []string) {
+			registry.Configure(spec)
+			for _, category := range enabled {
+				Expect(registry.IsEnabled(category)).To(BeTrue(), category)
+			}
+			for _, category := range disabled {
+				Expect(registry.IsEnabled(category)).To(BeFalse(), category)
+			}
+		},
+		Entry("empty", "", []string{"net", "gc"}, nil),
+		Entry("only listed", "net, db", []string{"net", "db"}, []string{"gc"}),
+		Entry("excluded", "-gc", []string{"net"}, []string{"gc"}),
+		Entry("mixed", "net,-gc", []string{"net"}, []string{"gc", "db"}),
+		Entry("explicit default", "*,-gc,net", []string{"net", "db"}, []string{"gc"}),
+		Entry("everything disabled", "-*", nil, []string{"net", "gc"}),
+	)
+
+	When("used by a Tracer", func() {
+		var eventWriter *tracetest.Recorder
+		var tracer *trace.Tracer
+
+		BeforeEach(func() {
+			eventWriter = tracetest.NewRecorder()
+			tracer = trace.NewTracer(eventWriter, trace.WithCategoryRegistry(registry))
+			registry.Configure("net")
+		})
+
+		It("only emits events with an enabled category or no categories", func() {
+			tracer.Instant("a", trace.WithCategories("gc"))
+			tracer.Instant("b", trace.WithCategories("gc", "net"))
+			tracer.Instant("c")
+			Expect(eventWriter.Events()).To(HaveLen(2))
+			Expect(eventWriter.Events()[0].Core().Name).To(Equal("b"))
+			Expect(eventWriter.Events()[1].Core().Name).To(Equal("c"))
+		})
+
+		It("responds to changes at runtime", func() {
+			registry.Enable("gc")
+			tracer.BeginDuration("a", trace.WithCategories("gc")).End()
+			Expect(eventWriter.Events()).To(HaveLen(2))
+			Expect(eventWriter.Events()[1]).To(BeAssignableToTypeOf(&events.EndDuration{}))
+		})
+	})
+})
@@ -0,0 +1,49 @@
+package trace
+
+import (
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// clockSyncName is the name given to ClockSync events, matching the name used by Chrome's tracing agents
+const clockSyncName = "clock_sync"
+
+// ClockSync generates an event recording when this agent received the clock sync with the given ID, so that its
+// trace can later be aligned with the traces of other agents that recorded the same sync, see io.AlignClocks
+func (t *Tracer) ClockSync(syncId string, options ...EventOption) {
+	pid := getPid()
+
+	event := &events.ClockSync{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{
+				Name:      clockSyncName,
+				Timestamp: t.getTimestamp(),
+				ProcessID: &pid,
+			},
+		},
+		SyncId: syncId,
+	}
+
+	t.writeEvent(event, options...)
+}
+
+// IssueClockSync calls issue to ask other agents to record a clock sync with the given ID, then generates an event
+// recording both when the sync was issued and when issue returned, which improves the accuracy of aligning the traces
+func (t *Tracer) IssueClockSync(syncId string, issue func(syncId string), options ...EventOption) {
+	pid := getPid()
+	issueTs := t.getTimestamp()
+	issue(syncId)
+
+	event := &events.ClockSync{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{
+				Name:      clockSyncName,
+				Timestamp: t.getTimestamp(),
+				ProcessID: &pid,
+			},
+		},
+		SyncId:  syncId,
+		IssueTs: &issueTs,
+	}
+
+	t.writeEvent(event, options...)
+}
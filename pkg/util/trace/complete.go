@@ -0,0 +1,146 @@
+package trace
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// WithCompleteEvents has the Tracer emit each duration as a single Complete event when it ends, rather than as a
+// BeginDuration when it starts and an EndDuration when it ends, halving the events written for durations and making
+// traces smaller. As nothing is written for a duration until it ends, any durations still open when the Tracer is
+// closed, or when a panic is traced by RecoverAndTrace or dumped by DumpOnPanic, are written as BeginDurations so that
+// the work in progress at a crash still shows in the trace. Those durations are ended with an EndDuration.
+//
+// The args and stack trace given when a duration ends are added to those of the Complete event, its stack trace
+// becoming the end stack trace of the event.
+func WithCompleteEvents() TracerOption {
+	return func(t *Tracer) {
+		t.openDurations = &openDurations{
+			open:    map[uint64]*events.BeginDuration{},
+			flushed: map[uint64]struct{}{},
+		}
+	}
+}
+
+// openDurations holds the durations that have begun but not ended when the Tracer emits Complete events
+type openDurations struct {
+	lock sync.Mutex
+	// open holds the beginning of each duration that has not been written yet, by the ID given to its Duration
+	open map[uint64]*events.BeginDuration
+	// flushed holds the IDs of open durations whose beginnings have been written, to be ended with an EndDuration
+	flushed map[uint64]struct{}
+}
+
+// beginOpenDuration prepares the beginning of a duration, holding on to it until the duration ends, reporting
+// whether it passed the Tracer's filters
+func (t *Tracer) beginOpenDuration(id uint64, event *events.BeginDuration, options ...EventOption) bool {
+	if !t.prepareEvent(event, options...) {
+		events.Release(event)
+		return false
+	}
+
+	durations := t.openDurations
+	durations.lock.Lock()
+	durations.open[id] = event
+	durations.lock.Unlock()
+	return true
+}
+
+// endOpenDuration emits a Complete event for the duration begun by beginOpenDuration, reporting false if its beginning
+// has already been written and so it must end with an EndDuration. Ending a duration twice does nothing.
+func (t *Tracer) endOpenDuration(id uint64, timestamp int64, options ...EventOption) bool {
+	durations := t.openDurations
+	durations.lock.Lock()
+	begin, open := durations.open[id]
+	delete(durations.open, id)
+	_, flushed := durations.flushed[id]
+	delete(durations.flushed, id)
+	durations.lock.Unlock()
+	if !open {
+		return !flushed
+	}
+
+	end := events.AcquireEndDuration()
+	t.applyOptions(end, options...)
+
+	event := events.AcquireComplete()
+	event.EventWithArgs = begin.EventWithArgs
+	event.EventStackTrace = begin.EventStackTrace
+	event.Duration = timestamp - begin.Timestamp
+	event.EndStackTrace = end.StackTrace
+	if len(end.Args) > 0 {
+		args := make(map[string]interface{}, len(event.Args)+len(end.Args))
+		for k, v := range event.Args {
+			args[k] = v
+		}
+		for k, v := range end.Args {
+			args[k] = v
+		}
+		event.Args = args
+	}
+	if end.TypedArgs != nil {
+		t.mergeEndTypedArgs(event, end.TypedArgs)
+	}
+	events.Release(end)
+	events.Release(begin)
+
+	if t.emit(event) {
+		events.Release(event)
+	}
+	return true
+}
+
+// mergeEndTypedArgs adds the typed args given when a duration ended to the Complete event written for it, encoding
+// them into its args should it already have typed args of its own
+func (t *Tracer) mergeEndTypedArgs(event *events.Complete, typedArgs interface{}) {
+	if event.TypedArgs == nil {
+		event.TypedArgs = typedArgs
+		return
+	}
+	endArgs, err := events.ArgsOf(typedArgs)
+	if err != nil {
+		t.handleError(fmt.Sprintf("failed to encode the args of the end of %q", event.Name), err)
+		return
+	}
+	args := make(map[string]interface{}, len(event.Args)+len(endArgs))
+	for k, v := range event.Args {
+		args[k] = v
+	}
+	for k, v := range endArgs {
+		args[k] = v
+	}
+	event.Args = args
+}
+
+// flushOpenDurations writes the beginnings of the durations that are still open, oldest first, so that they appear in
+// the trace should the process not live to end them
+func (t *Tracer) flushOpenDurations() {
+	durations := t.openDurations
+	if durations == nil {
+		return
+	}
+
+	durations.lock.Lock()
+	ids := make([]uint64, 0, len(durations.open))
+	for id := range durations.open {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	begins := make([]*events.BeginDuration, 0, len(ids))
+	for _, id := range ids {
+		begins = append(begins, durations.open[id])
+		delete(durations.open, id)
+		durations.flushed[id] = struct{}{}
+	}
+	durations.lock.Unlock()
+
+	for _, begin := range begins {
+		// the beginnings are not released, as the writer may keep them
+		t.emit(begin)
+	}
+}
@@ -0,0 +1,107 @@
+package trace_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/util/trace"
+	"github.com/omaskery/teffy/pkg/util/trace/tracetest"
+)
+
+var _ = Describe("Complete events", func() {
+	var mockTime mockTimestamp
+	var eventWriter *tracetest.Recorder
+	var tracer *trace.Tracer
+
+	BeforeEach(func() {
+		mockTime = mockTimestamp{}
+		eventWriter = tracetest.NewRecorder()
+		tracer = trace.NewTracer(eventWriter, trace.WithTimestampFn(mockTime.getTimestamp), trace.WithCompleteEvents())
+	})
+
+	phases := func() []string {
+		result := []string{}
+		for _, e := range eventWriter.Events() {
+			result = append(result, string(e.Phase())+e.Core().Name)
+		}
+		return result
+	}
+
+	It("emits nothing until a duration ends, then a single Complete event", func() {
+		mockTime.time = 5
+		d := tracer.BeginDuration("work", trace.WithCategories("cat"), trace.WithArgs(map[string]interface{}{"a": 1}))
+		Expect(eventWriter.Events()).To(BeEmpty())
+
+		mockTime.time = 15
+		d.End(trace.WithArgs(map[string]interface{}{"b": 2}))
+		Expect(eventWriter.Events()).To(HaveLen(1))
+		complete, ok := eventWriter.LastEvent().(*events.Complete)
+		Expect(ok).To(BeTrue())
+		Expect(complete.Name).To(Equal("work"))
+		Expect(complete.Categories).To(Equal([]string{"cat"}))
+		Expect(complete.Timestamp).To(Equal(int64(5)))
+		Expect(complete.Duration).To(Equal(int64(10)))
+		Expect(complete.Args).To(Equal(map[string]interface{}{"a": 1, "b": 2}))
+	})
+
+	It("keeps the typed args given when a duration begins and ends", func() {
+		type request struct {
+			Method string `json:"method"`
+		}
+		type response struct {
+			Status int `json:"status"`
+		}
+		d := tracer.BeginDuration("request", trace.WithTypedArgs(request{Method: "GET"}))
+		d.End(trace.WithTypedArgs(response{Status: 200}))
+		complete, ok := eventWriter.LastEvent().(*events.Complete)
+		Expect(ok).To(BeTrue())
+		var args map[string]interface{}
+		Expect(complete.DecodeArgs(&args)).To(Succeed())
+		Expect(args).To(Equal(map[string]interface{}{"method": "GET", "status": 200.0}))
+	})
+
+	It("emits nested durations as they end", func() {
+		outer := tracer.BeginDuration("outer")
+		inner := tracer.BeginDuration("inner")
+		inner.End()
+		outer.End()
+		Expect(phases()).To(Equal([]string{"Xinner", "Xouter"}))
+	})
+
+	It("does nothing when a duration is ended twice", func() {
+		d := tracer.BeginDuration("work")
+		d.End()
+		d.End()
+		Expect(phases()).To(Equal([]string{"Xwork"}))
+	})
+
+	It("writes the beginnings of durations still open when the tracer is closed", func() {
+		tracer.BeginDuration("first")
+		tracer.BeginDuration("second")
+		tracer.BeginDuration("ended").End()
+		Expect(tracer.Close()).To(Succeed())
+		Expect(phases()).To(Equal([]string{"Xended", "Bfirst", "Bsecond"}))
+	})
+
+	It("ends durations open at a panic with EndDurations", func() {
+		var d trace.Duration
+		panicking := func() {
+			defer trace.RecoverAndTrace(tracer)
+			d = tracer.BeginDuration("work")
+			panic("oh no")
+		}
+		Expect(panicking).To(Panic())
+		d.End()
+		Expect(phases()).To(Equal([]string{"Bwork", "I" + trace.PanicEventName, "Ework"}))
+	})
+
+	It("drops durations that are filtered out", func() {
+		tracer = trace.NewTracer(eventWriter, trace.WithCompleteEvents(), trace.WithEventFilter(func(e events.Event) bool {
+			return e.Core().Name != "dropped"
+		}))
+		tracer.BeginDuration("dropped").End()
+		Expect(tracer.Close()).To(Succeed())
+		Expect(eventWriter.Events()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,111 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// ErrNoHistory means that a Tracer was asked to dump its recent events, but its EventWriter does not keep them, as a
+// tio.RingWriter does
+var ErrNoHistory = errors.New("tracer's event writer keeps no history")
+
+// eventHistory is implemented by event writers that keep the events written to them, such as tio.RingWriter
+type eventHistory interface {
+	Events() []events.Event
+}
+
+// stackFrameHistory is implemented by event writers that keep the stack frames written to them, such as
+// tio.RingWriter
+type stackFrameHistory interface {
+	StackFrames() map[string]*events.StackFrame
+}
+
+// DumpHistory writes the events kept by the Tracer's EventWriter, such as the recent events kept by a
+// tio.RingWriter, to the file at the given path in JSON Object Format. The dump is written alongside the file and then
+// moved into its place, replacing any earlier dump, so that the file never holds a partially written dump.
+func DumpHistory(t *Tracer, path string) error {
+	history, ok := t.stream.(eventHistory)
+	if !ok {
+		return ErrNoHistory
+	}
+
+	data := tio.TefData{}
+	data.SetEvents(history.Events())
+	if frames, ok := history.(stackFrameHistory); ok {
+		for id, frame := range frames.StackFrames() {
+			data.SetStackFrame(id, frame)
+		}
+	}
+	partial := path + ".partial"
+	f, err := os.Create(partial)
+	if err != nil {
+		return fmt.Errorf("failed to create dump file: %w", err)
+	}
+	if err := tio.WriteJsonObject(f, data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(partial)
+		return fmt.Errorf("failed to write dump: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(partial)
+		return fmt.Errorf("failed to close dump file: %w", err)
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return fmt.Errorf("failed to replace dump file: %w", err)
+	}
+	return nil
+}
+
+// DumpOnSignal dumps the Tracer's recent events to the file at the given path, as DumpHistory does, each time the
+// process receives the signal, such as SIGUSR1. Used with a Tracer writing to a tio.RingWriter this gives flight
+// recorder style debugging, the history leading up to a problem being captured on demand. Failures to dump are
+// reported to the Tracer's error handler. The returned function stops listening for the signal.
+func DumpOnSignal(t *Tracer, sig os.Signal, path string) (stop func(), err error) {
+	if _, ok := t.stream.(eventHistory); !ok {
+		return nil, ErrNoHistory
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sig)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				if err := DumpHistory(t, path); err != nil {
+					t.handleError("failed to dump trace history", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+		})
+	}, nil
+}
+
+// DumpOnPanic is deferred to dump the Tracer's recent events to the file at the given path, as DumpHistory does, if
+// the function deferring it panics. The panic then continues on its way.
+//
+//	defer trace.DumpOnPanic(t, "crash.trace")
+func DumpOnPanic(t *Tracer, path string) {
+	if r := recover(); r != nil {
+		t.flushOpenDurations()
+		if err := DumpHistory(t, path); err != nil {
+			t.handleError("failed to dump trace history", err)
+		}
+		panic(r)
+	}
+}
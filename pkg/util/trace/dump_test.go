@@ -0,0 +1,91 @@
+package trace_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/util/trace"
+)
+
+var _ = Describe("Dumping history", func() {
+	var ring *tio.RingWriter
+	var tracer *trace.Tracer
+	var dir string
+	var path string
+
+	BeforeEach(func() {
+		ring = tio.NewRingWriter(2)
+		tracer = trace.NewTracer(ring, trace.WithProcessName("server"))
+		var err error
+		dir, err = ioutil.TempDir("", "teffy-dump")
+		Expect(err).To(Succeed())
+		path = filepath.Join(dir, "dump.trace")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	dumped := func() []string {
+		f, err := os.Open(path)
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+		data, err := tio.ParseJsonObj(f)
+		Expect(err).ToNot(HaveOccurred())
+		names := []string{}
+		for _, e := range data.Events() {
+			names = append(names, e.Core().Name)
+		}
+		return names
+	}
+
+	It("writes the recent events to a file", func() {
+		tracer.Instant("a")
+		tracer.Instant("b")
+		tracer.Instant("c")
+
+		Expect(trace.DumpHistory(tracer, path)).To(Succeed())
+		Expect(dumped()).To(Equal([]string{"process_name", "b", "c"}))
+	})
+
+	It("refuses to dump a tracer whose writer keeps no history", func() {
+		tracer = trace.Noop()
+		Expect(trace.DumpHistory(tracer, path)).To(MatchError(trace.ErrNoHistory))
+		_, err := trace.DumpOnSignal(tracer, syscall.SIGUSR1, path)
+		Expect(err).To(MatchError(trace.ErrNoHistory))
+	})
+
+	It("dumps the recent events when the process receives the signal", func() {
+		stop, err := trace.DumpOnSignal(tracer, syscall.SIGUSR1, path)
+		Expect(err).ToNot(HaveOccurred())
+		defer stop()
+
+		tracer.Instant("a")
+		self, err := os.FindProcess(os.Getpid())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(self.Signal(syscall.SIGUSR1)).To(Succeed())
+
+		Eventually(func() error {
+			_, err := os.Stat(path)
+			return err
+		}).Should(Succeed())
+		Eventually(dumped).Should(Equal([]string{"process_name", "a"}))
+	})
+
+	It("dumps the recent events when the deferring function panics", func() {
+		panicking := func() {
+			defer trace.DumpOnPanic(tracer, path)
+			tracer.Instant("a")
+			panic("oh no")
+		}
+
+		Expect(panicking).To(PanicWith("oh no"))
+		Expect(dumped()).To(Equal([]string{"process_name", "a"}))
+	})
+})
@@ -0,0 +1,88 @@
+package trace
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// durationArgs holds the args added to durations by AddArg until the durations end, by the ID given to their Duration,
+// as Durations are handed around by value and so cannot hold them themselves
+type durationArgs struct {
+	lock sync.Mutex
+	args map[uint64]map[string]interface{}
+	// pending counts the durations holding args, so that ending durations does not take the lock unless some do
+	pending int64
+}
+
+// add adds the arg to those of the duration
+func (da *durationArgs) add(id uint64, key string, value interface{}) {
+	da.lock.Lock()
+	defer da.lock.Unlock()
+
+	if da.args == nil {
+		da.args = map[uint64]map[string]interface{}{}
+	}
+	args, ok := da.args[id]
+	if !ok {
+		args = map[string]interface{}{}
+		da.args[id] = args
+		atomic.AddInt64(&da.pending, 1)
+	}
+	args[key] = value
+}
+
+// take removes and returns the args added to the duration, if any were
+func (da *durationArgs) take(id uint64) map[string]interface{} {
+	if atomic.LoadInt64(&da.pending) == 0 {
+		return nil
+	}
+
+	da.lock.Lock()
+	defer da.lock.Unlock()
+
+	args, ok := da.args[id]
+	if !ok {
+		return nil
+	}
+	delete(da.args, id)
+	atomic.AddInt64(&da.pending, -1)
+	return args
+}
+
+// AddArg adds an arg to the event that ends the duration, the EndDuration or, with WithCompleteEvents, the Complete
+// event, so that what is only learnt during the work, such as how many rows a query returned, can be recorded without
+// delaying beginning the duration until it is known. Adding an arg of the same key again replaces its value, and args
+// given to End take precedence over those added. AddArg is safe to call from any goroutine, but must not be called
+// once the duration has ended.
+func (d Duration) AddArg(key string, value interface{}) {
+	if d.dropped {
+		return
+	}
+	d.t.durationArgs.add(d.id, key, value)
+}
+
+// withAddedArgs adds the args added to a duration to the event ending it, without replacing the args given to End
+func withAddedArgs(added map[string]interface{}) EventOption {
+	return func(e events.Event) error {
+		event, ok := e.(interface {
+			events.ArgGetter
+			events.ArgSetter
+		})
+		if !ok {
+			return fmt.Errorf("cannot set arguments on %T: %w", e, ErrUnsupportedOption)
+		}
+		given := event.GetArgs()
+		args := make(map[string]interface{}, len(added)+len(given))
+		for k, v := range added {
+			args[k] = v
+		}
+		for k, v := range given {
+			args[k] = v
+		}
+		event.SetArgs(args)
+		return nil
+	}
+}
@@ -0,0 +1,64 @@
+package trace_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/util/trace"
+	"github.com/omaskery/teffy/pkg/util/trace/tracetest"
+)
+
+var _ = Describe("Duration args", func() {
+	var eventWriter *tracetest.Recorder
+
+	BeforeEach(func() {
+		eventWriter = tracetest.NewRecorder()
+	})
+
+	It("emits the args added during a duration on the event ending it", func() {
+		tracer := trace.NewTracer(eventWriter)
+		d := tracer.BeginDuration("query")
+		// ending a copy of the Duration, as deferring End does, still finds the args
+		defer func(d trace.Duration) {
+			end, ok := eventWriter.LastEvent().(*events.EndDuration)
+			Expect(ok).To(BeTrue())
+			Expect(end.Args).To(Equal(map[string]interface{}{"rows": 3, "table": "users", "cached": false}))
+		}(d)
+		defer d.End(trace.WithArgs(map[string]interface{}{"cached": false, "table": "users"}))
+
+		d.AddArg("rows", 1)
+		d.AddArg("rows", 3)
+		d.AddArg("table", "ignored, as End's args take precedence")
+	})
+
+	It("emits the args added during a duration on the Complete event written for it", func() {
+		tracer := trace.NewTracer(eventWriter, trace.WithCompleteEvents())
+		d := tracer.BeginDuration("query", trace.WithArgs(map[string]interface{}{"sql": "SELECT 1"}))
+		d.AddArg("rows", 1)
+		d.End()
+
+		complete, ok := eventWriter.LastEvent().(*events.Complete)
+		Expect(ok).To(BeTrue())
+		Expect(complete.Args).To(Equal(map[string]interface{}{"sql": "SELECT 1", "rows": 1}))
+	})
+
+	It("does not carry the args of one duration over to another", func() {
+		tracer := trace.NewTracer(eventWriter)
+		first := tracer.BeginDuration("first")
+		second := tracer.BeginDuration("second")
+		first.AddArg("a", 1)
+		second.End()
+		first.End()
+
+		evs := eventWriter.Events()
+		Expect(evs[len(evs)-2].(*events.EndDuration).Args).To(BeNil())
+		Expect(evs[len(evs)-1].(*events.EndDuration).Args).To(Equal(map[string]interface{}{"a": 1}))
+	})
+
+	It("ignores args added to durations that are not traced", func() {
+		d := trace.Noop().BeginDuration("nothing")
+		d.AddArg("a", 1)
+		d.End()
+	})
+})
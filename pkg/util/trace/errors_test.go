@@ -0,0 +1,81 @@
+package trace_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/util/trace"
+	"github.com/omaskery/teffy/pkg/util/trace/tracetest"
+)
+
+var errBroken = errors.New("broken")
+
+// failingEventWriter fails to write every event
+type failingEventWriter struct{}
+
+func (failingEventWriter) Write(events.Event) error {
+	return errBroken
+}
+
+func (failingEventWriter) Close() error {
+	return nil
+}
+
+var _ = Describe("Tracer errors", func() {
+	var eventWriter *tracetest.Recorder
+	var errs []error
+	var tracer *trace.Tracer
+
+	BeforeEach(func() {
+		eventWriter = tracetest.NewRecorder()
+		errs = nil
+		tracer = trace.NewTracer(eventWriter, trace.WithErrorHandler(func(err error) {
+			errs = append(errs, err)
+		}))
+	})
+
+	It("reports options unsupported by the event rather than panicking", func() {
+		tracer.Instant("tick", trace.WithEndStackTrace())
+		Expect(errs).To(HaveLen(1))
+		Expect(errors.Is(errs[0], trace.ErrUnsupportedOption)).To(BeTrue())
+		Expect(eventWriter.Events()).To(HaveLen(1))
+	})
+
+	It("reports scopes that are invalid or given to events other than instants", func() {
+		tracer.Instant("tick", trace.WithScope("x"))
+		Expect(errs).To(HaveLen(1))
+		Expect(errors.Is(errs[0], events.ErrInvalidScope)).To(BeTrue())
+		Expect(eventWriter.LastEvent().(*events.Instant).Scope).To(Equal(events.InstantScopeThread))
+
+		tracer.BeginDuration("work", trace.WithScope(events.InstantScopeGlobal))
+		Expect(errs).To(HaveLen(2))
+		Expect(errors.Is(errs[1], trace.ErrUnsupportedOption)).To(BeTrue())
+	})
+
+	It("returns the errors of events written explicitly", func() {
+		counter := &events.Counter{EventCore: events.EventCore{Name: "mem"}}
+		Expect(tracer.WriteEvent(counter)).To(Succeed())
+		Expect(eventWriter.LastEvent()).To(Equal(counter))
+
+		err := tracer.WriteEvent(counter, trace.WithStackTrace(0))
+		Expect(errors.Is(err, trace.ErrUnsupportedOption)).To(BeTrue())
+		Expect(errs).To(BeEmpty())
+
+		tracer = trace.NewTracer(failingEventWriter{})
+		Expect(errors.Is(tracer.WriteEvent(counter), errBroken)).To(BeTrue())
+		Expect(func() {
+			tracer.MustWriteEvent(counter)
+		}).To(Panic())
+	})
+
+	It("returns the first error from Close when there is no error handler", func() {
+		tracer = trace.NewTracer(failingEventWriter{})
+		tracer.Instant("first")
+		tracer.Instant("second")
+		err := tracer.Close()
+		Expect(errors.Is(err, errBroken)).To(BeTrue())
+	})
+})
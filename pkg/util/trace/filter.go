@@ -0,0 +1,45 @@
+package trace
+
+import (
+	"math/rand"
+	"path"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// EventFilter decides whether an event should be emitted by a Tracer, returning false to discard it
+type EventFilter = func(e events.Event) bool
+
+// WithEventFilter discards any event for which the filter returns false, filters are applied after any EventOptions.
+// The end of a duration is always kept or discarded along with its beginning, so filters only see the beginning.
+func WithEventFilter(filter EventFilter) TracerOption {
+	return func(t *Tracer) {
+		t.filters = append(t.filters, filter)
+	}
+}
+
+// WithSampleRate keeps only the given fraction (between 0 and 1) of events that have a category matching the
+// pattern, chosen at random. Patterns use the syntax of path.Match, so "net.*" matches both "net.read" and
+// "net.write". When several sample rates match an event, each of them must keep it for it to be emitted.
+func WithSampleRate(categoryPattern string, rate float64) TracerOption {
+	return WithEventFilter(func(e events.Event) bool {
+		for _, category := range e.Core().Categories {
+			if matched, _ := path.Match(categoryPattern, category); matched {
+				return rand.Float64() < rate
+			}
+		}
+		return true
+	})
+}
+
+func (t *Tracer) shouldEmit(e events.Event) bool {
+	if t.categories != nil && !t.categories.allows(e) {
+		return false
+	}
+	for _, filter := range t.filters {
+		if !filter(e) {
+			return false
+		}
+	}
+	return true
+}
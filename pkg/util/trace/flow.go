@@ -0,0 +1,89 @@
+package trace
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// flowIdKey is the context key that StartFlow stores the ID of a flow under
+type flowIdKey struct{}
+
+// FlowStart generates an event signalling the start of a flow, drawn as an arrow from the enclosing Duration to the
+// Durations enclosing the steps and end of the flow with the same ID
+func (t *Tracer) FlowStart(name, id string, options ...EventOption) {
+	pid := getPid()
+
+	event := &events.FlowStart{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{
+				Name:      name,
+				Timestamp: t.getTimestamp(),
+				ProcessID: &pid,
+			},
+		},
+		Id: id,
+	}
+
+	t.writeEvent(event, options...)
+}
+
+// FlowStep generates an event signalling an intermediate step of the flow with the given ID
+func (t *Tracer) FlowStep(name, id string, options ...EventOption) {
+	pid := getPid()
+
+	event := &events.FlowInstant{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{
+				Name:      name,
+				Timestamp: t.getTimestamp(),
+				ProcessID: &pid,
+			},
+		},
+		Id: id,
+	}
+
+	t.writeEvent(event, options...)
+}
+
+// FlowEnd generates an event signalling the end of the flow with the given ID, which is bound to the enclosing Duration
+func (t *Tracer) FlowEnd(name, id string, options ...EventOption) {
+	pid := getPid()
+
+	event := &events.FlowFinish{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{
+				Name:      name,
+				Timestamp: t.getTimestamp(),
+				ProcessID: &pid,
+			},
+		},
+		Id:           id,
+		BindingPoint: events.BindingPointEnclosing,
+	}
+
+	t.writeEvent(event, options...)
+}
+
+// StartFlow starts a new flow with a unique ID, returning a context carrying that ID so that the work it is handed to,
+// such as another goroutine, can use FollowFrom to draw an arrow back to where the flow started
+func (t *Tracer) StartFlow(ctx context.Context, name string, options ...EventOption) context.Context {
+	id := strconv.FormatInt(atomic.AddInt64(&t.lastFlowId, 1), 10)
+	t.FlowStart(name, id, options...)
+	return context.WithValue(ctx, flowIdKey{}, id)
+}
+
+// FollowFrom ends the flow carried by the context, if there is one, binding it to the enclosing Duration
+func (t *Tracer) FollowFrom(ctx context.Context, name string, options ...EventOption) {
+	if id, ok := FlowIdFromContext(ctx); ok {
+		t.FlowEnd(name, id, options...)
+	}
+}
+
+// FlowIdFromContext returns the ID of the flow started by StartFlow that the context carries, if any
+func FlowIdFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(flowIdKey{}).(string)
+	return id, ok
+}
@@ -0,0 +1,117 @@
+package trace
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// GCCategory is the category of the events emitted by WithGCEvents, allowing them to be toggled like any other
+// category
+const GCCategory = "gc"
+
+// gcPauseName is the name of the async spans emitted for the pauses of GC cycles
+const gcPauseName = "GC pause"
+
+// WithGCEvents emits an async span for the stop-the-world pauses of each GC cycle that completes while the Tracer is
+// open, so that GC pauses appear alongside the spans of the application. Each span's ID is the number of its GC
+// cycle. The cycles are noticed by a finalizer that runs after each collection, which reads the runtime's memory
+// statistics and so briefly stops the world itself once per cycle.
+func WithGCEvents() TracerOption {
+	return func(t *Tracer) {
+		t.gcEvents = true
+	}
+}
+
+type gcWatcher struct {
+	t       *Tracer
+	lock    sync.Mutex
+	stopped bool
+	lastGC  uint32
+	stats   runtime.MemStats
+}
+
+// gcSentinel is allocated and dropped for each GC cycle, its finalizer running once the cycle has completed. It
+// holds a pointer so that it is not batched with other tiny allocations, which would delay its finalizer.
+type gcSentinel struct {
+	w *gcWatcher
+}
+
+func startGCWatcher(t *Tracer) *gcWatcher {
+	w := &gcWatcher{t: t}
+	runtime.ReadMemStats(&w.stats)
+	w.lastGC = w.stats.NumGC
+	w.arm()
+	return w
+}
+
+func (w *gcWatcher) arm() {
+	runtime.SetFinalizer(&gcSentinel{w: w}, func(s *gcSentinel) {
+		if s.w.collect() {
+			s.w.arm()
+		}
+	})
+}
+
+// close stops the watcher, waiting for any in-progress cycles to be emitted
+func (w *gcWatcher) close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.stopped = true
+}
+
+// collect emits the GC cycles completed since it was last called, reporting whether the watcher is still running
+func (w *gcWatcher) collect() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stopped {
+		return false
+	}
+
+	runtime.ReadMemStats(&w.stats)
+	// the runtime records the pauses of the most recent cycles in circular buffers, older cycles are lost
+	first := w.lastGC + 1
+	if w.stats.NumGC > uint32(len(w.stats.PauseEnd)) && first <= w.stats.NumGC-uint32(len(w.stats.PauseEnd)) {
+		first = w.stats.NumGC - uint32(len(w.stats.PauseEnd)) + 1
+	}
+	// the pauses are measured by the wall clock, which is moved onto the Tracer's clock in case it has its own
+	offset := w.t.getTimestamp() - time.Now().UnixNano()/1000
+
+	pid := getPid()
+	for cycle := first; cycle <= w.stats.NumGC; cycle++ {
+		i := (cycle + uint32(len(w.stats.PauseEnd)) - 1) % uint32(len(w.stats.PauseEnd))
+		end := int64(w.stats.PauseEnd[i]/1000) + offset
+		id := strconv.FormatUint(uint64(cycle), 10)
+
+		begin := &events.AsyncBegin{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{
+					Name:       gcPauseName,
+					Categories: []string{GCCategory},
+					Timestamp:  end - int64(w.stats.PauseNs[i]/1000),
+					ProcessID:  &pid,
+				},
+			},
+			Id: id,
+		}
+		if !w.t.writeEvent(begin) {
+			continue
+		}
+		w.t.emit(&events.AsyncEnd{
+			EventWithArgs: events.EventWithArgs{
+				EventCore: events.EventCore{
+					Name:       gcPauseName,
+					Categories: []string{GCCategory},
+					Timestamp:  end,
+					ProcessID:  &pid,
+				},
+			},
+			Id: id,
+		})
+	}
+	w.lastGC = w.stats.NumGC
+	return true
+}
@@ -0,0 +1,143 @@
+package trace
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// Handler creates an http.Handler for pulling traces from a running process on demand, in the spirit of
+// net/http/pprof. The handler dispatches on the final element of the request path, so may be mounted at any prefix:
+//
+//   - POST .../start begins recording events emitted by the Tracer
+//   - POST .../stop ends the recording, responding with the recorded trace
+//   - GET .../trace responds with the events recorded so far without ending the recording, or if the "seconds"
+//     query parameter is given records for that many seconds and responds with the result
+//
+// Traces are returned in the JSON Object Format, gzipped if the "gzip" query parameter is true or the client
+// accepts gzip encoding.
+func Handler(t *Tracer) http.Handler {
+	return &handler{t: t}
+}
+
+type handler struct {
+	t *Tracer
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch path.Base(r.URL.Path) {
+	case "start":
+		if !requireMethod(w, r, http.MethodPost) {
+			return
+		}
+		if err := h.t.StartRecording(); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case "stop":
+		if !requireMethod(w, r, http.MethodPost) {
+			return
+		}
+		data, err := h.t.StopRecording()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeTrace(w, r, data)
+
+	case "trace":
+		if !requireMethod(w, r, http.MethodGet) {
+			return
+		}
+		if seconds := r.URL.Query().Get("seconds"); seconds != "" {
+			h.recordFor(w, r, seconds)
+			return
+		}
+		data, err := h.t.Recording()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeTrace(w, r, data)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *handler) recordFor(w http.ResponseWriter, r *http.Request, seconds string) {
+	s, err := strconv.ParseFloat(seconds, 64)
+	if err != nil || s <= 0 {
+		http.Error(w, "seconds must be a positive number", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.t.StartRecording(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	select {
+	case <-time.After(time.Duration(s * float64(time.Second))):
+	case <-r.Context().Done():
+	}
+
+	data, err := h.t.StopRecording()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeTrace(w, r, data)
+}
+
+func requireMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, fmt.Sprintf("method must be %s", method), http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, ErrAlreadyRecording) || errors.Is(err, ErrNotRecording) {
+		status = http.StatusConflict
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func writeTrace(w http.ResponseWriter, r *http.Request, data *tio.TefData) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace.json"`)
+
+	var out io.Writer = w
+	if wantsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+
+	if err := tio.WriteJsonObject(out, *data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func wantsGzip(r *http.Request) bool {
+	if v := r.URL.Query().Get("gzip"); v != "" {
+		b, err := strconv.ParseBool(v)
+		return err == nil && b
+	}
+	return strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+}
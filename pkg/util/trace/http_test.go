@@ -0,0 +1,123 @@
+package trace_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/util/trace"
+	"github.com/omaskery/teffy/pkg/util/trace/tracetest"
+)
+
+var _ = Describe("Handler", func() {
+	var tracer *trace.Tracer
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		tracer = trace.NewTracer(tracetest.NewRecorder(), trace.WithTimestampFn(func() int64 { return 5 }))
+		server = httptest.NewServer(http.StripPrefix("/debug/teffy", trace.Handler(tracer)))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(tracer.Close()).To(Succeed())
+	})
+
+	post := func(endpoint string) *http.Response {
+		resp, err := http.Post(server.URL+"/debug/teffy/"+endpoint, "", nil)
+		Expect(err).To(Succeed())
+		return resp
+	}
+
+	get := func(endpoint string) *http.Response {
+		resp, err := http.Get(server.URL + "/debug/teffy/" + endpoint)
+		Expect(err).To(Succeed())
+		return resp
+	}
+
+	parse := func(resp *http.Response) *tio.TefData {
+		defer resp.Body.Close()
+		data, err := tio.ParseJsonObj(resp.Body)
+		Expect(err).To(Succeed())
+		return data
+	}
+
+	When("not recording", func() {
+		It("refuses to stop or download a recording", func() {
+			Expect(post("stop").StatusCode).To(Equal(http.StatusConflict))
+			Expect(get("trace").StatusCode).To(Equal(http.StatusConflict))
+		})
+
+		It("rejects unknown endpoints and methods", func() {
+			Expect(get("kittens").StatusCode).To(Equal(http.StatusNotFound))
+			Expect(get("start").StatusCode).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	When("recording", func() {
+		BeforeEach(func() {
+			tracer.Instant("before")
+			Expect(post("start").StatusCode).To(Equal(http.StatusNoContent))
+			tracer.Instant("during")
+		})
+
+		It("refuses to start again", func() {
+			Expect(post("start").StatusCode).To(Equal(http.StatusConflict))
+		})
+
+		It("downloads the events recorded so far", func() {
+			resp := get("trace")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			data := parse(resp)
+			Expect(data.Events()).To(HaveLen(1))
+			Expect(data.Events()[0].Core().Name).To(Equal("during"))
+			Expect(tracer.IsRecording()).To(BeTrue())
+		})
+
+		It("returns the recording when stopped", func() {
+			resp := post("stop")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			data := parse(resp)
+			Expect(data.Events()).To(HaveLen(1))
+			Expect(data.Events()[0].Phase()).To(Equal(events.PhaseInstant))
+			Expect(tracer.IsRecording()).To(BeFalse())
+		})
+
+		It("compresses the trace when asked", func() {
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/debug/teffy/trace?gzip=true", nil)
+			Expect(err).To(Succeed())
+			// stop the transport transparently decompressing the response
+			req.Header.Set("Accept-Encoding", "identity")
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).To(Succeed())
+			defer resp.Body.Close()
+			Expect(resp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+			gz, err := gzip.NewReader(resp.Body)
+			Expect(err).To(Succeed())
+			body, err := ioutil.ReadAll(gz)
+			Expect(err).To(Succeed())
+			Expect(string(body)).To(ContainSubstring(`"during"`))
+		})
+	})
+
+	When("recording for a fixed time", func() {
+		It("records and returns the trace", func() {
+			resp := get("trace?seconds=0.01")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(parse(resp).Events()).To(BeEmpty())
+			Expect(tracer.IsRecording()).To(BeFalse())
+		})
+
+		It("rejects invalid durations", func() {
+			resp := get("trace?seconds=" + strings.Repeat("x", 3))
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
@@ -0,0 +1,56 @@
+package trace
+
+import (
+	"sort"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// WithProcessName names the process in the trace, the name is emitted as metadata when the Tracer is created
+func WithProcessName(name string) TracerOption {
+	return func(t *Tracer) {
+		t.processName = name
+	}
+}
+
+// WithProcessSortIndex controls where the process is drawn relative to others in trace viewers, lower indices are
+// drawn higher, the index is emitted as metadata when the Tracer is created
+func WithProcessSortIndex(index int64) TracerOption {
+	return func(t *Tracer) {
+		t.processSortIndex = &index
+	}
+}
+
+// WithThreadNames names threads in the trace by their thread ID, the names are emitted as metadata when the Tracer is
+// created
+func WithThreadNames(names map[int64]string) TracerOption {
+	return func(t *Tracer) {
+		t.threadNames = names
+	}
+}
+
+// emitMetadata emits the metadata events labelling the process and its threads, bypassing the Tracer's filters as
+// they describe the trace rather than anything that happened within it
+func (t *Tracer) emitMetadata() {
+	pid := getPid()
+	emit := func(e events.Event) {
+		e.Core().Timestamp = t.getTimestamp()
+		t.emit(e)
+	}
+
+	if t.processName != "" {
+		emit(events.NewProcessName(pid, t.processName))
+	}
+	if t.processSortIndex != nil {
+		emit(events.NewProcessSortIndex(pid, *t.processSortIndex))
+	}
+
+	tids := make([]int64, 0, len(t.threadNames))
+	for tid := range t.threadNames {
+		tids = append(tids, tid)
+	}
+	sort.Slice(tids, func(i, j int) bool { return tids[i] < tids[j] })
+	for _, tid := range tids {
+		emit(events.NewThreadName(pid, tid, t.threadNames[tid]))
+	}
+}
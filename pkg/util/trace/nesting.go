@@ -0,0 +1,191 @@
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+var (
+	// ErrMismatchedEnd means a duration was ended while durations begun within it were still open, or on a goroutine
+	// other than the one it began on, or after it had already ended
+	ErrMismatchedEnd = errors.New("duration ended out of order")
+	// ErrUnbalancedDurations means a Tracer was closed while durations begun with it had not been ended
+	ErrUnbalancedDurations = errors.New("durations were never ended")
+)
+
+const (
+	// DurationArg is the arg holding how long a duration took, added to its end by WithNestedDurations
+	DurationArg = "duration"
+	// SelfTimeArg is the arg holding how long a duration took outside of the durations nested within it, added to its
+	// end by WithNestedDurations
+	SelfTimeArg = "self_time"
+)
+
+// WithNestedDurations has the Tracer keep track of the durations open on each goroutine, so that when each duration
+// ends it is given args holding how long it took (DurationArg) and how much of that was not spent in the durations
+// nested within it (SelfTimeArg), in the units of the Tracer's timestamps. Durations ended out of order, or on a
+// goroutine other than the one they began on, are reported to the error handler as ErrMismatchedEnd, and durations that
+// are never ended are reported as ErrUnbalancedDurations when the Tracer is closed. Finding which goroutine is running
+// is not free, so this is best kept for checking instrumentation rather than left on.
+func WithNestedDurations() TracerOption {
+	return func(t *Tracer) {
+		t.nesting = &durationNesting{
+			stacks: map[int64][]nestedDuration{},
+		}
+	}
+}
+
+// durationNesting holds the stack of durations open on each goroutine, by goroutine ID
+type durationNesting struct {
+	lock   sync.Mutex
+	stacks map[int64][]nestedDuration
+}
+
+type nestedDuration struct {
+	id    uint64
+	name  string
+	start int64
+	// nested is the time spent in the durations that have ended within this one
+	nested int64
+}
+
+// beginNestedDuration pushes the duration onto the stack of the current goroutine
+func (t *Tracer) beginNestedDuration(d Duration, timestamp int64) {
+	goroutine := goroutineID()
+	nesting := t.nesting
+	nesting.lock.Lock()
+	defer nesting.lock.Unlock()
+
+	nesting.stacks[goroutine] = append(nesting.stacks[goroutine], nestedDuration{
+		id:    d.id,
+		name:  d.name,
+		start: timestamp,
+	})
+}
+
+// endNestedDuration removes the duration from the stack of the current goroutine, returning an option giving its end
+// the timing args, or nil if the duration is not open on this goroutine. A duration ended on another goroutine is
+// removed from the stack of the goroutine that began it, so that it is not reported again when the Tracer is closed.
+func (t *Tracer) endNestedDuration(d Duration, timestamp int64) EventOption {
+	goroutine := goroutineID()
+	nesting := t.nesting
+	nesting.lock.Lock()
+	stack := nesting.stacks[goroutine]
+	index := indexOfNested(stack, d.id)
+	if index < 0 {
+		for other, otherStack := range nesting.stacks {
+			if i := indexOfNested(otherStack, d.id); i >= 0 {
+				nesting.removeNested(other, i, 0)
+				break
+			}
+		}
+		nesting.lock.Unlock()
+		t.handleError(fmt.Sprintf("duration %q is not open on this goroutine", d.name), ErrMismatchedEnd)
+		return nil
+	}
+
+	ended := stack[index]
+	innermost := stack[len(stack)-1].name
+	elapsed := timestamp - ended.start
+	stack = nesting.removeNested(goroutine, index, elapsed)
+	nesting.lock.Unlock()
+
+	if index != len(stack) {
+		t.handleError(fmt.Sprintf("duration %q ended while %q was still open", d.name, innermost), ErrMismatchedEnd)
+	}
+	return withTimingArgs(elapsed, elapsed-ended.nested)
+}
+
+// indexOfNested finds the duration with the given ID in the stack, searching from the innermost outwards, returning -1
+// if it is not there
+func indexOfNested(stack []nestedDuration, id uint64) int {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeNested removes the duration at the index of the goroutine's stack, adding the time it took to the duration it
+// was nested within, and returns what is left of the stack. The lock must be held.
+func (n *durationNesting) removeNested(goroutine int64, index int, elapsed int64) []nestedDuration {
+	stack := n.stacks[goroutine]
+	stack = append(stack[:index], stack[index+1:]...)
+	if index > 0 {
+		stack[index-1].nested += elapsed
+	}
+	if len(stack) > 0 {
+		n.stacks[goroutine] = stack
+	} else {
+		delete(n.stacks, goroutine)
+	}
+	return stack
+}
+
+// withTimingArgs adds the timing args of a nested duration to the args of its end
+func withTimingArgs(elapsed, self int64) EventOption {
+	return func(e events.Event) error {
+		getter, ok := e.(events.ArgGetter)
+		if !ok {
+			return nil
+		}
+		setter, ok := e.(events.ArgSetter)
+		if !ok {
+			return nil
+		}
+		// the args may have been given by the caller, so are copied rather than modified
+		args := make(map[string]interface{}, len(getter.GetArgs())+2)
+		for k, v := range getter.GetArgs() {
+			args[k] = v
+		}
+		args[DurationArg] = elapsed
+		args[SelfTimeArg] = self
+		setter.SetArgs(args)
+		return nil
+	}
+}
+
+// reportUnbalancedDurations reports any durations that are still open to the error handler
+func (t *Tracer) reportUnbalancedDurations() {
+	nesting := t.nesting
+	if nesting == nil {
+		return
+	}
+
+	nesting.lock.Lock()
+	var names []string
+	for goroutine, stack := range nesting.stacks {
+		for _, d := range stack {
+			names = append(names, strconv.Quote(d.name))
+		}
+		delete(nesting.stacks, goroutine)
+	}
+	nesting.lock.Unlock()
+
+	if len(names) > 0 {
+		sort.Strings(names)
+		t.handleError(fmt.Sprintf("durations %s are still open", strings.Join(names, ", ")), ErrUnbalancedDurations)
+	}
+}
+
+// goroutineID finds the ID of the running goroutine, which Go does not otherwise reveal, from the header of its stack
+// trace: "goroutine 123 [running]:"
+func goroutineID() int64 {
+	var buf [64]byte
+	header := buf[:runtime.Stack(buf[:], false)]
+	header = bytes.TrimPrefix(header, []byte("goroutine "))
+	if space := bytes.IndexByte(header, ' '); space >= 0 {
+		header = header[:space]
+	}
+	id, _ := strconv.ParseInt(string(header), 10, 64)
+	return id
+}
@@ -0,0 +1,135 @@
+package trace_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/util/trace"
+	"github.com/omaskery/teffy/pkg/util/trace/tracetest"
+)
+
+var _ = Describe("Nested durations", func() {
+	var mockTime mockTimestamp
+	var eventWriter *tracetest.Recorder
+	var errs []error
+	var options []trace.TracerOption
+	var tracer *trace.Tracer
+
+	BeforeEach(func() {
+		options = nil
+	})
+
+	JustBeforeEach(func() {
+		mockTime = mockTimestamp{}
+		eventWriter = tracetest.NewRecorder()
+		errs = nil
+		tracer = trace.NewTracer(eventWriter, append([]trace.TracerOption{
+			trace.WithTimestampFn(mockTime.getTimestamp),
+			trace.WithNestedDurations(),
+			trace.WithErrorHandler(func(err error) {
+				errs = append(errs, err)
+			}),
+		}, options...)...)
+	})
+
+	argsOf := func(name string) map[string]interface{} {
+		for _, e := range eventWriter.Events() {
+			if e.Core().Name != name || e.Phase() == events.PhaseBeginDuration {
+				continue
+			}
+			if getter, ok := e.(events.ArgGetter); ok {
+				return getter.GetArgs()
+			}
+		}
+		return nil
+	}
+
+	It("gives each duration its time and self time as args", func() {
+		outer := tracer.BeginDuration("outer")
+		mockTime.time = 10
+		inner := tracer.BeginDuration("inner")
+		mockTime.time = 40
+		inner.End(trace.WithArgs(map[string]interface{}{"a": 1}))
+		mockTime.time = 50
+		outer.End()
+
+		Expect(argsOf("inner")).To(Equal(map[string]interface{}{"a": 1, trace.DurationArg: int64(30), trace.SelfTimeArg: int64(30)}))
+		Expect(argsOf("outer")).To(Equal(map[string]interface{}{trace.DurationArg: int64(50), trace.SelfTimeArg: int64(20)}))
+		Expect(tracer.Close()).To(Succeed())
+		Expect(errs).To(BeEmpty())
+	})
+
+	It("reports durations ended out of order", func() {
+		outer := tracer.BeginDuration("outer")
+		inner := tracer.BeginDuration("inner")
+		outer.End()
+		inner.End()
+
+		Expect(errs).To(HaveLen(1))
+		Expect(errors.Is(errs[0], trace.ErrMismatchedEnd)).To(BeTrue())
+		Expect(errs[0].Error()).To(ContainSubstring(`"outer" ended while "inner" was still open`))
+	})
+
+	It("reports durations ended twice", func() {
+		d := tracer.BeginDuration("work")
+		d.End()
+		d.End()
+		Expect(errs).To(HaveLen(1))
+		Expect(errors.Is(errs[0], trace.ErrMismatchedEnd)).To(BeTrue())
+	})
+
+	It("reports durations ended on another goroutine", func() {
+		d := tracer.BeginDuration("work")
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			d.End()
+		}()
+		<-done
+		Expect(errs).To(HaveLen(1))
+		Expect(errors.Is(errs[0], trace.ErrMismatchedEnd)).To(BeTrue())
+
+		Expect(tracer.Close()).To(Succeed())
+		Expect(errs).To(HaveLen(1))
+	})
+
+	It("keeps the durations of each goroutine apart", func() {
+		outer := tracer.BeginDuration("outer")
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			tracer.BeginDuration("elsewhere").End()
+		}()
+		<-done
+		outer.End()
+		Expect(errs).To(BeEmpty())
+	})
+
+	It("reports durations never ended when the tracer is closed", func() {
+		tracer.BeginDuration("forgotten")
+		Expect(tracer.Close()).To(Succeed())
+		Expect(errs).To(HaveLen(1))
+		Expect(errors.Is(errs[0], trace.ErrUnbalancedDurations)).To(BeTrue())
+		Expect(errs[0].Error()).To(ContainSubstring(`"forgotten"`))
+	})
+
+	When("emitting complete events", func() {
+		BeforeEach(func() {
+			options = []trace.TracerOption{trace.WithCompleteEvents()}
+		})
+
+		It("gives the complete events the timing args", func() {
+			outer := tracer.BeginDuration("outer")
+			mockTime.time = 5
+			tracer.BeginDuration("inner").End()
+			outer.End()
+
+			Expect(eventWriter.Events()).To(HaveLen(2))
+			Expect(argsOf("outer")).To(Equal(map[string]interface{}{trace.DurationArg: int64(5), trace.SelfTimeArg: int64(5)}))
+			Expect(argsOf("inner")).To(Equal(map[string]interface{}{trace.DurationArg: int64(0), trace.SelfTimeArg: int64(0)}))
+		})
+	})
+})
@@ -0,0 +1,24 @@
+package trace_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/util/trace"
+)
+
+var _ = Describe("Noop tracer", func() {
+	It("accepts everything without doing any work", func() {
+		tracer := trace.Noop()
+		allocs := testing.AllocsPerRun(100, func() {
+			tracer.BeginDuration("work", trace.WithStackTrace(0)).End()
+			tracer.Instant("tick")
+		})
+		Expect(allocs).To(BeZero())
+		Expect(tracer.WriteEvent(&events.Counter{}, trace.WithStackTrace(0))).To(Succeed())
+		Expect(tracer.Close()).To(Succeed())
+	})
+})
@@ -0,0 +1,91 @@
+package trace
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// PanicEventName is the name of the events emitted by RecoverAndTrace
+const PanicEventName = "panic"
+
+// RecoverAndTrace is deferred to emit an Instant event if the function deferring it panics, before the panic continues
+// on its way, so that crashes are visible at the end of traces. The event is scoped to the process, holds the panic
+// value and its type in its args, and has the full stack of the panicking goroutine as its stack trace, which is
+// moved into the trace's stack frames when the trace is made chrome compatible.
+//
+//	defer trace.RecoverAndTrace(t)
+func RecoverAndTrace(t *Tracer, options ...EventOption) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	t.flushOpenDurations()
+
+	pid := getPid()
+	event := &events.Instant{
+		EventWithArgs: events.EventWithArgs{
+			EventCore: events.EventCore{
+				Name:      PanicEventName,
+				Timestamp: t.getTimestamp(),
+				ProcessID: &pid,
+			},
+			Args: map[string]interface{}{
+				"value": fmt.Sprint(r),
+				"type":  fmt.Sprintf("%T", r),
+			},
+		},
+		EventStackTrace: events.EventStackTrace{StackTrace: panicStackTrace()},
+		Scope:           events.InstantScopeProcess,
+	}
+	t.writeEvent(event, options...)
+
+	panic(r)
+}
+
+// panicStackTrace captures the whole stack of a panicking goroutine from within a deferred function, leaving out the
+// frames of the deferred function and the runtime's handling of the panic, with the outermost caller first
+func panicStackTrace() *events.StackTrace {
+	pc := make([]uintptr, 64)
+	for {
+		n := runtime.Callers(1, pc)
+		if n < len(pc) {
+			pc = pc[:n]
+			break
+		}
+		pc = make([]uintptr, len(pc)*2)
+	}
+
+	var frames []runtime.Frame
+	callers := runtime.CallersFrames(pc)
+	for {
+		frame, more := callers.Next()
+		if isPanicFrame(frame.Function) {
+			// everything up to here was the deferred function and the runtime starting to panic
+			frames = frames[:0]
+		} else {
+			frames = append(frames, frame)
+		}
+		if !more {
+			break
+		}
+	}
+
+	s := &events.StackTrace{}
+	for i := len(frames) - 1; i >= 0; i-- {
+		s.Trace = append(s.Trace, &events.StackFrame{
+			Category: frames[i].File,
+			Name:     fmt.Sprintf("%s:%v", frames[i].Function, frames[i].Line),
+		})
+	}
+	return s
+}
+
+// isPanicFrame reports whether the function is one of the runtime's functions for raising a panic
+func isPanicFrame(function string) bool {
+	return function == "runtime.gopanic" || function == "runtime.sigpanic" ||
+		strings.HasPrefix(function, "runtime.panic") || strings.HasPrefix(function, "runtime.goPanic")
+}
@@ -0,0 +1,81 @@
+package trace_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/util/trace"
+	"github.com/omaskery/teffy/pkg/util/trace/tracetest"
+)
+
+func panicsWithNilMap(m map[string]int) {
+	m["boom"] = 1
+}
+
+var _ = Describe("RecoverAndTrace", func() {
+	var eventWriter *tracetest.Recorder
+	var tracer *trace.Tracer
+
+	BeforeEach(func() {
+		eventWriter = tracetest.NewRecorder()
+		tracer = trace.NewTracer(eventWriter)
+	})
+
+	panicEvent := func() *events.Instant {
+		Expect(eventWriter.LastEvent()).To(BeAssignableToTypeOf(&events.Instant{}))
+		return eventWriter.LastEvent().(*events.Instant)
+	}
+
+	frameNames := func(e *events.Instant) []string {
+		var names []string
+		for _, frame := range e.StackTrace.Trace {
+			names = append(names, frame.Name)
+		}
+		return names
+	}
+
+	It("emits an instant event with the panic value and stack before panicking again", func() {
+		panicking := func() {
+			defer trace.RecoverAndTrace(tracer, trace.WithCategories("crash"))
+			panic("oh no")
+		}
+		Expect(panicking).To(PanicWith("oh no"))
+
+		e := panicEvent()
+		Expect(e.Name).To(Equal(trace.PanicEventName))
+		Expect(e.Categories).To(Equal([]string{"crash"}))
+		Expect(e.Scope).To(Equal(events.InstantScopeProcess))
+		Expect(e.Args).To(Equal(map[string]interface{}{"value": "oh no", "type": "string"}))
+
+		Expect(e.StackTrace.Trace).ToNot(BeEmpty())
+		innermost := e.StackTrace.Trace[len(e.StackTrace.Trace)-1]
+		Expect(innermost.Category).To(HaveSuffix("panic_test.go"))
+		for _, name := range frameNames(e) {
+			Expect(name).ToNot(ContainSubstring("RecoverAndTrace"))
+			Expect(name).ToNot(HavePrefix("runtime.gopanic"))
+		}
+	})
+
+	It("leaves out the runtime's frames for panics it raises", func() {
+		panicking := func() {
+			defer trace.RecoverAndTrace(tracer)
+			panicsWithNilMap(nil)
+		}
+		Expect(panicking).To(Panic())
+
+		names := frameNames(panicEvent())
+		innermost := names[len(names)-1]
+		Expect(strings.Contains(innermost, "panicsWithNilMap") || strings.HasPrefix(innermost, "runtime.mapassign")).To(BeTrue())
+	})
+
+	It("does nothing when there is no panic", func() {
+		calm := func() {
+			defer trace.RecoverAndTrace(tracer)
+		}
+		Expect(calm).ToNot(Panic())
+		Expect(eventWriter.Events()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,63 @@
+package trace
+
+import (
+	"errors"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+var (
+	// ErrAlreadyRecording means a recording was started while one was already in progress
+	ErrAlreadyRecording = errors.New("tracer is already recording")
+	// ErrNotRecording means a recording was stopped or retrieved when none was in progress
+	ErrNotRecording = errors.New("tracer is not recording")
+)
+
+// StartRecording begins retaining a copy of all events emitted by the Tracer in memory, in addition to writing them
+// to the Tracer's EventWriter, until StopRecording is called
+func (t *Tracer) StartRecording() error {
+	t.streamLock.Lock()
+	defer t.streamLock.Unlock()
+
+	if t.recording != nil {
+		return ErrAlreadyRecording
+	}
+	t.recording = &tio.TefData{}
+	return nil
+}
+
+// StopRecording ends the current recording, returning the events emitted since it was started
+func (t *Tracer) StopRecording() (*tio.TefData, error) {
+	t.streamLock.Lock()
+	defer t.streamLock.Unlock()
+
+	if t.recording == nil {
+		return nil, ErrNotRecording
+	}
+	recording := t.recording
+	t.recording = nil
+	return recording, nil
+}
+
+// Recording returns a copy of the events emitted since the current recording was started, without stopping it
+func (t *Tracer) Recording() (*tio.TefData, error) {
+	t.streamLock.Lock()
+	defer t.streamLock.Unlock()
+
+	if t.recording == nil {
+		return nil, ErrNotRecording
+	}
+	snapshot := &tio.TefData{}
+	for _, e := range t.recording.Events() {
+		snapshot.Write(e)
+	}
+	return snapshot, nil
+}
+
+// IsRecording reports whether a recording is currently in progress
+func (t *Tracer) IsRecording() bool {
+	t.streamLock.Lock()
+	defer t.streamLock.Unlock()
+
+	return t.recording != nil
+}
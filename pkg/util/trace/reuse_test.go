@@ -0,0 +1,61 @@
+package trace_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/util/trace"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (*bufferCloser) Close() error {
+	return nil
+}
+
+var _ = Describe("Reusing events", func() {
+	It("emits plain events without allocating when the writer has finished with them", func() {
+		tracer := trace.NewTracer(tio.NewStreamingWriter(discardCloser{}))
+		allocs := testing.AllocsPerRun(100, func() {
+			tracer.BeginDuration("work").End()
+			tracer.Instant("tick")
+		})
+		Expect(allocs).To(BeZero())
+	})
+
+	It("writes every event correctly while reusing them", func() {
+		var buffer bufferCloser
+		tracer := trace.NewTracer(tio.NewStreamingWriter(&buffer))
+		tracer.BeginDuration("a").End()
+		tracer.Instant("b")
+		tracer.BeginDuration("c").End()
+		Expect(tracer.Close()).To(Succeed())
+
+		data, err := tio.ParseJsonArray(&buffer.Buffer)
+		Expect(err).ToNot(HaveOccurred())
+		names := []string{}
+		for _, e := range data.Events() {
+			names = append(names, string(e.Phase())+e.Core().Name)
+		}
+		Expect(names).To(Equal([]string{"Ba", "Ea", "Ib", "Bc", "Ec"}))
+	})
+
+	It("does not reuse events kept by a recording", func() {
+		tracer := trace.NewTracer(tio.NewStreamingWriter(discardCloser{}))
+		Expect(tracer.StartRecording()).To(Succeed())
+		tracer.Instant("a")
+		tracer.Instant("b")
+		recording, err := tracer.StopRecording()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(recording.Events()).To(HaveLen(2))
+		Expect(recording.Events()[0].Core().Name).To(Equal("a"))
+		Expect(recording.Events()[1].Core().Name).To(Equal("b"))
+	})
+})
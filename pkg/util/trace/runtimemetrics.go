@@ -0,0 +1,183 @@
+package trace
+
+import (
+	"math"
+	"runtime/metrics"
+	"time"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// RuntimeMetricsCategory is the category of the Counter events emitted by WithRuntimeMetrics, allowing them to be
+// toggled like any other category
+const RuntimeMetricsCategory = "runtime"
+
+const (
+	metricHeapObjects = "/memory/classes/heap/objects:bytes"
+	metricHeapUnused  = "/memory/classes/heap/unused:bytes"
+	metricHeapFree    = "/memory/classes/heap/free:bytes"
+	metricGoroutines  = "/sched/goroutines:goroutines"
+	metricGcCycles    = "/gc/cycles/total:gc-cycles"
+	metricGcPauses    = "/sched/pauses/total/gc:seconds"
+	// metricGcPausesLegacy is the name of the GC pause histogram before Go 1.22
+	metricGcPausesLegacy = "/gc/pauses:seconds"
+)
+
+// WithRuntimeMetrics starts a background collector that reads the Go runtime's metrics at the given interval,
+// emitting them as Counter events: "heap" with the bytes of the heap holding objects, unused and free, "goroutines"
+// with the number of goroutines, "gc" with the number of completed GC cycles and "gc pause" with the milliseconds the
+// program was paused for GC since the previous reading. The collector runs until the Tracer is closed.
+func WithRuntimeMetrics(interval time.Duration) TracerOption {
+	return func(t *Tracer) {
+		t.metricsInterval = interval
+	}
+}
+
+// WithRuntimeMetricSamples adds metrics from the runtime/metrics package, such as "/gc/heap/goal:bytes", to those
+// read by WithRuntimeMetrics, each emitted as a Counter named after the metric with a single "value" series. Only
+// metrics with a single value are supported, histograms and metrics unknown to the runtime are skipped.
+func WithRuntimeMetricSamples(names ...string) TracerOption {
+	return func(t *Tracer) {
+		t.metricNames = append(t.metricNames, names...)
+	}
+}
+
+type metricsCollector struct {
+	t        *Tracer
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	samples  []metrics.Sample
+	// extra are the indices of the samples added by WithRuntimeMetricSamples
+	extra []int
+	// lastPauses holds the GC pause histogram's counts from the previous reading
+	lastPauses []uint64
+}
+
+func startMetricsCollector(t *Tracer, interval time.Duration, names []string) *metricsCollector {
+	supported := map[string]metrics.ValueKind{}
+	for _, description := range metrics.All() {
+		supported[description.Name] = description.Kind
+	}
+
+	pauses := metricGcPauses
+	if _, ok := supported[pauses]; !ok {
+		pauses = metricGcPausesLegacy
+	}
+
+	c := &metricsCollector{
+		t:        t,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, name := range []string{metricHeapObjects, metricHeapUnused, metricHeapFree, metricGoroutines, metricGcCycles, pauses} {
+		c.samples = append(c.samples, metrics.Sample{Name: name})
+	}
+	for _, name := range names {
+		if kind := supported[name]; kind == metrics.KindUint64 || kind == metrics.KindFloat64 {
+			c.extra = append(c.extra, len(c.samples))
+			c.samples = append(c.samples, metrics.Sample{Name: name})
+		}
+	}
+
+	// the first reading only establishes the GC pauses that happened before the collector started
+	metrics.Read(c.samples)
+	c.pausedSince(c.samples[5].Value)
+
+	go c.run()
+	return c
+}
+
+func (c *metricsCollector) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.collect()
+		}
+	}
+}
+
+// close stops the collector, waiting for any in-progress reading to be emitted
+func (c *metricsCollector) close() {
+	close(c.stop)
+	<-c.done
+}
+
+func (c *metricsCollector) collect() {
+	metrics.Read(c.samples)
+	timestamp := c.t.getTimestamp()
+
+	c.emit(timestamp, "heap", map[string]float64{
+		"objects": value(c.samples[0].Value),
+		"unused":  value(c.samples[1].Value),
+		"free":    value(c.samples[2].Value),
+	})
+	c.emit(timestamp, "goroutines", map[string]float64{"count": value(c.samples[3].Value)})
+	c.emit(timestamp, "gc", map[string]float64{"cycles": value(c.samples[4].Value)})
+	c.emit(timestamp, "gc pause", map[string]float64{"ms": c.pausedSince(c.samples[5].Value) * 1000})
+	for _, i := range c.extra {
+		c.emit(timestamp, c.samples[i].Name, map[string]float64{"value": value(c.samples[i].Value)})
+	}
+}
+
+func (c *metricsCollector) emit(timestamp int64, name string, values map[string]float64) {
+	pid := getPid()
+	c.t.writeEvent(&events.Counter{
+		EventCore: events.EventCore{
+			Name:       name,
+			Categories: []string{RuntimeMetricsCategory},
+			Timestamp:  timestamp,
+			ProcessID:  &pid,
+		},
+		Values: values,
+	})
+}
+
+// pausedSince estimates the seconds spent in the GC pauses recorded in the histogram since the previous reading,
+// taking each pause to last as long as the middle of its bucket
+func (c *metricsCollector) pausedSince(v metrics.Value) float64 {
+	if v.Kind() != metrics.KindFloat64Histogram {
+		return 0
+	}
+	h := v.Float64Histogram()
+
+	var seconds float64
+	for i, count := range h.Counts {
+		var last uint64
+		if i < len(c.lastPauses) {
+			last = c.lastPauses[i]
+		}
+		if count <= last {
+			continue
+		}
+		low, high := h.Buckets[i], h.Buckets[i+1]
+		mid := (low + high) / 2
+		if math.IsInf(low, -1) {
+			mid = high
+		} else if math.IsInf(high, 1) {
+			mid = low
+		}
+		seconds += float64(count-last) * mid
+	}
+	c.lastPauses = append(c.lastPauses[:0], h.Counts...)
+	return seconds
+}
+
+// value converts a metric with a single value to a float, metrics of other kinds being zero
+func value(v metrics.Value) float64 {
+	switch v.Kind() {
+	case metrics.KindUint64:
+		return float64(v.Uint64())
+	case metrics.KindFloat64:
+		return v.Float64()
+	}
+	return 0
+}
@@ -0,0 +1,165 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+// WithSampling starts a background sampler that captures the stacks of all goroutines at the given interval,
+// emitting a Sample event for each goroutine with the goroutine's ID used as the thread ID. The sampler runs
+// until the Tracer is closed.
+func WithSampling(interval time.Duration) TracerOption {
+	return func(t *Tracer) {
+		t.samplingInterval = interval
+	}
+}
+
+type sampler struct {
+	t        *Tracer
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	buf      []byte
+}
+
+func startSampler(t *Tracer, interval time.Duration) *sampler {
+	s := &sampler{
+		t:        t,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		buf:      make([]byte, 64*1024),
+	}
+	go s.run()
+	return s
+}
+
+func (s *sampler) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.sample()
+		}
+	}
+}
+
+// close stops the sampler, waiting for any in-progress sample to be emitted
+func (s *sampler) close() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *sampler) sample() {
+	for {
+		n := runtime.Stack(s.buf, true)
+		if n < len(s.buf) {
+			break
+		}
+		s.buf = make([]byte, len(s.buf)*2)
+	}
+	timestamp := s.t.getTimestamp()
+
+	pid := getPid()
+	for i, g := range parseGoroutineStacks(s.buf) {
+		// the first goroutine is always the one capturing the stacks, i.e. the sampler itself
+		if i == 0 {
+			continue
+		}
+
+		tid := g.id
+		s.t.writeEvent(&events.Sample{
+			EventCore: events.EventCore{
+				Name:      g.state,
+				Timestamp: timestamp,
+				ProcessID: &pid,
+				ThreadID:  &tid,
+			},
+			EventStackTrace: events.EventStackTrace{
+				StackTrace: g.stack,
+			},
+		})
+	}
+}
+
+type goroutineStack struct {
+	id    int64
+	state string
+	stack *events.StackTrace
+}
+
+var goroutineHeader = regexp.MustCompile(`^goroutine (\d+) \[([^\]]*)\]:$`)
+
+// parseGoroutineStacks parses the textual stack dump produced by runtime.Stack, which lists the most recently
+// called frame first as a function line followed by an indented file:line line
+func parseGoroutineStacks(dump []byte) []goroutineStack {
+	var result []goroutineStack
+	var current *goroutineStack
+	var function string
+
+	scanner := bufio.NewScanner(bytes.NewReader(dump))
+	scanner.Buffer(nil, len(dump)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := goroutineHeader.FindStringSubmatch(line); m != nil {
+			id, _ := strconv.ParseInt(m[1], 10, 64)
+			result = append(result, goroutineStack{
+				id:    id,
+				state: strings.SplitN(m[2], ",", 2)[0],
+				stack: &events.StackTrace{},
+			})
+			current = &result[len(result)-1]
+			function = ""
+			continue
+		}
+		if current == nil || line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "\t") {
+			if function == "" {
+				continue
+			}
+			location := strings.TrimSpace(line)
+			if i := strings.LastIndex(location, " +0x"); i >= 0 {
+				location = location[:i]
+			}
+			file, lineNumber := location, ""
+			if i := strings.LastIndex(location, ":"); i >= 0 {
+				file, lineNumber = location[:i], location[i+1:]
+			}
+			// frames are listed most recent first, but stack traces are stored least recent first
+			current.stack.Trace = append([]*events.StackFrame{{
+				Category: file,
+				Name:     function + ":" + lineNumber,
+			}}, current.stack.Trace...)
+			function = ""
+			continue
+		}
+
+		if strings.HasPrefix(line, "created by ") || strings.HasPrefix(line, "...") {
+			function = ""
+			continue
+		}
+		function = line
+		if i := strings.LastIndex(function, "("); i > 0 {
+			function = function[:i]
+		}
+	}
+
+	return result
+}
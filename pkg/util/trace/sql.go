@@ -0,0 +1,383 @@
+package trace
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// SQLCategory is the category of the events emitted for database queries by WrapDriver and WrapConnector
+const SQLCategory = "sql"
+
+// SQLSanitizer rewrites SQL before it is recorded in events, such as to remove sensitive values
+type SQLSanitizer = func(query string) string
+
+// SQLOption configures how database queries are traced
+type SQLOption = func(s *sqlTracer)
+
+// WithSQLSanitizer replaces SanitizeSQL as the function used to rewrite SQL before it is recorded in events, the
+// identity function records SQL as it is
+func WithSQLSanitizer(sanitizer SQLSanitizer) SQLOption {
+	return func(s *sqlTracer) {
+		s.sanitize = sanitizer
+	}
+}
+
+type sqlTracer struct {
+	t        *Tracer
+	sanitize SQLSanitizer
+}
+
+func newSQLTracer(t *Tracer, options []SQLOption) *sqlTracer {
+	s := &sqlTracer{t: t, sanitize: SanitizeSQL}
+	for _, opt := range options {
+		opt(s)
+	}
+	return s
+}
+
+// span records a Complete event for a database operation that began at the given time, with the sanitized query and
+// any error as its args. Operations the driver skipped are not recorded, as database/sql retries them another way.
+func (s *sqlTracer) span(name string, start int64, query string, err error) {
+	if errors.Is(err, driver.ErrSkip) {
+		return
+	}
+	args := map[string]interface{}{}
+	if query != "" {
+		args["query"] = s.sanitize(query)
+	}
+	if err != nil {
+		args["error"] = err.Error()
+	}
+	s.t.complete(name, start, WithCategories(SQLCategory), WithArgs(args))
+}
+
+// WrapDriver wraps a database/sql driver such that the queries, statements and transactions of its connections are
+// recorded as Complete events by the Tracer, with their SQL, sanitized by SanitizeSQL, in their args. Queries are
+// recorded until their rows are returned, not until the rows have been read. Register the wrapped driver to use it:
+//
+//	sql.Register("traced-postgres", trace.WrapDriver(t, &pq.Driver{}))
+//	db, err := sql.Open("traced-postgres", dsn)
+func WrapDriver(t *Tracer, d driver.Driver, options ...SQLOption) driver.Driver {
+	return &sqlDriver{d: d, s: newSQLTracer(t, options)}
+}
+
+// WrapConnector wraps a database/sql connector as WrapDriver wraps drivers, for use with sql.OpenDB
+func WrapConnector(t *Tracer, c driver.Connector, options ...SQLOption) driver.Connector {
+	s := newSQLTracer(t, options)
+	return &sqlConnector{c: c, d: &sqlDriver{d: c.Driver(), s: s}, s: s}
+}
+
+// SanitizeSQL replaces the string and numeric literals of SQL with placeholders and collapses runs of whitespace, so
+// that the SQL recorded in events does not contain the values being queried for
+func SanitizeSQL(query string) string {
+	var b strings.Builder
+	runes := []rune(query)
+	space := false
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			space = b.Len() > 0
+			continue
+		case r == '\'':
+			// quotes within strings are escaped by doubling them
+			for i++; i < len(runes); i++ {
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			r = '?'
+		case unicode.IsDigit(r) && (i == 0 || !isIdentifierRune(runes[i-1])):
+			for i+1 < len(runes) && (isIdentifierRune(runes[i+1]) || runes[i+1] == '.') {
+				i++
+			}
+			r = '?'
+		}
+		if space {
+			b.WriteRune(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+type sqlDriver struct {
+	d driver.Driver
+	s *sqlTracer
+}
+
+func (d *sqlDriver) Open(name string) (driver.Conn, error) {
+	start := d.s.t.getTimestamp()
+	c, err := d.d.Open(name)
+	d.s.span("sql.connect", start, "", err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{c: c, s: d.s}, nil
+}
+
+func (d *sqlDriver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.d.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlConnector{c: c, d: d, s: d.s}, nil
+	}
+	return &sqlConnector{c: dsnConnector{name: name, d: d.d}, d: d, s: d.s}, nil
+}
+
+// dsnConnector connects using drivers that do not provide their own connectors
+type dsnConnector struct {
+	name string
+	d    driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.d.Open(c.name)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.d
+}
+
+type sqlConnector struct {
+	c driver.Connector
+	d *sqlDriver
+	s *sqlTracer
+}
+
+func (c *sqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	start := c.s.t.getTimestamp()
+	conn, err := c.c.Connect(ctx)
+	c.s.span("sql.connect", start, "", err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{c: conn, s: c.s}, nil
+}
+
+func (c *sqlConnector) Driver() driver.Driver {
+	return c.d
+}
+
+// sqlConn wraps connections, implementing all of the optional interfaces of database/sql and falling back to the
+// behaviour database/sql has for connections that do not implement them
+type sqlConn struct {
+	c driver.Conn
+	s *sqlTracer
+}
+
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *sqlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	start := c.s.t.getTimestamp()
+	var stmt driver.Stmt
+	var err error
+	if pc, ok := c.c.(driver.ConnPrepareContext); ok {
+		stmt, err = pc.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.c.Prepare(query)
+	}
+	c.s.span("sql.prepare", start, query, err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStmt{stmt: stmt, conn: c.c, query: query, s: c.s}, nil
+}
+
+func (c *sqlConn) Close() error {
+	return c.c.Close()
+}
+
+func (c *sqlConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *sqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := c.s.t.getTimestamp()
+	var tx driver.Tx
+	var err error
+	if bc, ok := c.c.(driver.ConnBeginTx); ok {
+		tx, err = bc.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.c.Begin()
+	}
+	if err != nil {
+		c.s.span("sql.tx", start, "", err)
+		return nil, err
+	}
+	return &sqlTx{tx: tx, start: start, s: c.s}, nil
+}
+
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.c.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := c.s.t.getTimestamp()
+	result, err := ec.ExecContext(ctx, query, args)
+	c.s.span("sql.exec", start, query, err)
+	return result, err
+}
+
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.c.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := c.s.t.getTimestamp()
+	rows, err := qc.QueryContext(ctx, query, args)
+	c.s.span("sql.query", start, query, err)
+	return rows, err
+}
+
+func (c *sqlConn) Ping(ctx context.Context) error {
+	if p, ok := c.c.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.c.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) IsValid() bool {
+	if v, ok := c.c.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *sqlConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.c.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type sqlStmt struct {
+	stmt  driver.Stmt
+	conn  driver.Conn
+	query string
+	s     *sqlTracer
+}
+
+func (s *sqlStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *sqlStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s *sqlStmt) Exec(args []driver.Value) (driver.Result, error) {
+	start := s.s.t.getTimestamp()
+	result, err := s.stmt.Exec(args)
+	s.s.span("sql.exec", start, s.query, err)
+	return result, err
+}
+
+func (s *sqlStmt) Query(args []driver.Value) (driver.Rows, error) {
+	start := s.s.t.getTimestamp()
+	rows, err := s.stmt.Query(args)
+	s.s.span("sql.query", start, s.query, err)
+	return rows, err
+}
+
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := s.stmt.(driver.StmtExecContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Exec(values)
+	}
+	start := s.s.t.getTimestamp()
+	result, err := ec.ExecContext(ctx, args)
+	s.s.span("sql.exec", start, s.query, err)
+	return result, err
+}
+
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := s.stmt.(driver.StmtQueryContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Query(values)
+	}
+	start := s.s.t.getTimestamp()
+	rows, err := qc.QueryContext(ctx, args)
+	s.s.span("sql.query", start, s.query, err)
+	return rows, err
+}
+
+func (s *sqlStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	if checker, ok := s.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// namedValuesToValues converts args for statements that predate named args, as database/sql itself does
+func namedValuesToValues(named []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(named))
+	for i, nv := range named {
+		if nv.Name != "" {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+		values[i] = nv.Value
+	}
+	return values, nil
+}
+
+// sqlTx records a transaction from when it began until it is committed or rolled back
+type sqlTx struct {
+	tx    driver.Tx
+	start int64
+	s     *sqlTracer
+}
+
+func (t *sqlTx) Commit() error {
+	err := t.tx.Commit()
+	t.end("commit", err)
+	return err
+}
+
+func (t *sqlTx) Rollback() error {
+	err := t.tx.Rollback()
+	t.end("rollback", err)
+	return err
+}
+
+func (t *sqlTx) end(outcome string, err error) {
+	args := map[string]interface{}{"outcome": outcome}
+	if err != nil {
+		args["error"] = err.Error()
+	}
+	t.s.t.complete("sql.tx", t.start, WithCategories(SQLCategory), WithArgs(args))
+}
@@ -0,0 +1,158 @@
+package trace_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/util/trace"
+	"github.com/omaskery/teffy/pkg/util/trace/tracetest"
+)
+
+var errBadQuery = errors.New("bad query")
+
+// fakeDriver is a minimal database driver whose connections support direct execution and querying, while their
+// statements and transactions only support the original driver interfaces
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{query: query}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if query == "bad" {
+		return nil, errBadQuery
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return &fakeRows{}, nil
+}
+
+type fakeStmt struct {
+	query string
+}
+
+func (s *fakeStmt) Close() error                               { return nil }
+func (s *fakeStmt) NumInput() int                              { return -1 }
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return &fakeRows{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	done bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"n"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(42)
+	return nil
+}
+
+var _ = Describe("SQL instrumentation", func() {
+	var writer *tracetest.Recorder
+	var db *sql.DB
+
+	BeforeEach(func() {
+		writer = tracetest.NewRecorder()
+		now := int64(0)
+		tracer := trace.NewTracer(writer, trace.WithTimestampFn(func() int64 {
+			now += 10
+			return now
+		}))
+		connector, err := trace.WrapDriver(tracer, fakeDriver{}).(driver.DriverContext).OpenConnector("dsn")
+		Expect(err).To(Succeed())
+		db = sql.OpenDB(connector)
+		db.SetMaxOpenConns(1)
+	})
+
+	AfterEach(func() {
+		Expect(db.Close()).To(Succeed())
+	})
+
+	completes := func() []*events.Complete {
+		var result []*events.Complete
+		for _, e := range writer.Events() {
+			c, ok := e.(*events.Complete)
+			Expect(ok).To(BeTrue())
+			Expect(c.Categories).To(Equal([]string{trace.SQLCategory}))
+			result = append(result, c)
+		}
+		return result
+	}
+
+	It("records connections and queries with their sanitized SQL", func() {
+		var n int
+		Expect(db.QueryRow("SELECT n FROM t WHERE name = 'bob' AND age > 30").Scan(&n)).To(Succeed())
+		Expect(n).To(Equal(42))
+
+		c := completes()
+		Expect(c).To(HaveLen(2))
+		Expect(c[0].Name).To(Equal("sql.connect"))
+		Expect(c[1].Name).To(Equal("sql.query"))
+		Expect(c[1].Args).To(Equal(map[string]interface{}{"query": "SELECT n FROM t WHERE name = ? AND age > ?"}))
+		Expect(c[1].Duration).To(Equal(int64(10)))
+	})
+
+	It("records the errors of failed operations", func() {
+		_, err := db.Exec("bad")
+		Expect(err).To(MatchError(errBadQuery))
+
+		c := completes()
+		Expect(c[len(c)-1].Name).To(Equal("sql.exec"))
+		Expect(c[len(c)-1].Args).To(HaveKeyWithValue("error", errBadQuery.Error()))
+	})
+
+	It("records prepared statements and transactions", func() {
+		tx, err := db.Begin()
+		Expect(err).To(Succeed())
+		stmt, err := tx.Prepare("UPDATE t SET n = n + 1 WHERE id = ?")
+		Expect(err).To(Succeed())
+		_, err = stmt.Exec(7)
+		Expect(err).To(Succeed())
+		Expect(stmt.Close()).To(Succeed())
+		Expect(tx.Commit()).To(Succeed())
+
+		var names []string
+		for _, c := range completes() {
+			names = append(names, c.Name)
+		}
+		Expect(names).To(Equal([]string{"sql.connect", "sql.prepare", "sql.exec", "sql.tx"}))
+
+		c := completes()
+		Expect(c[2].Args).To(Equal(map[string]interface{}{"query": "UPDATE t SET n = n + ? WHERE id = ?"}))
+		Expect(c[3].Args).To(Equal(map[string]interface{}{"outcome": "commit"}))
+		Expect(c[3].Timestamp).To(BeNumerically("<", c[1].Timestamp))
+	})
+
+	DescribeTable("sanitizes SQL",
+		func(query, expected string) {
+			Expect(trace.SanitizeSQL(query)).To(Equal(expected))
+		},
+		Entry("strings", "SELECT * FROM t WHERE a = 'it''s' AND b='x'", "SELECT * FROM t WHERE a = ? AND b=?"),
+		Entry("numbers", "SELECT * FROM t2 WHERE a = 1.5 AND b IN (1, 2)", "SELECT * FROM t2 WHERE a = ? AND b IN (?, ?)"),
+		Entry("placeholders", "SELECT * FROM t WHERE a = $1", "SELECT * FROM t WHERE a = $1"),
+		Entry("whitespace", "  SELECT *\n\tFROM t  ", "SELECT * FROM t"),
+	)
+})
@@ -0,0 +1,169 @@
+package trace
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/omaskery/teffy/internal/stackframes"
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+)
+
+// DefaultStackDepth is the most frames captured by WithEndStackTrace
+const DefaultStackDepth = 10
+
+// resolvedFrame is a frame of a stack as it is recorded in events, along with the function it is in
+type resolvedFrame struct {
+	function string
+	file     string
+	name     string
+}
+
+// frameCache holds the frames already resolved for each program counter, as []resolvedFrame, so that repeatedly
+// capturing stacks from the same code only pays for symbolising each program counter once. A program counter may
+// resolve to several frames where functions have been inlined.
+var frameCache sync.Map
+
+// tracerPackage is the prefix of the names of the functions of this package, whose frames are left out of the stacks
+// attached to events so that they start from the code calling the Tracer
+var tracerPackage = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name()
+	slash := strings.LastIndex(name, "/")
+	return name[:slash+strings.Index(name[slash:], ".")+1]
+}()
+
+// buildStackTrace captures the stack of the code calling the Tracer, leaving out skip frames beyond it and keeping at
+// most depth frames (all of them if depth is zero or less), with the outermost caller first
+func buildStackTrace(skip, depth int) *events.StackTrace {
+	pc := make([]uintptr, 32)
+	for {
+		n := runtime.Callers(2, pc)
+		if n < len(pc) {
+			pc = pc[:n]
+			break
+		}
+		pc = make([]uintptr, len(pc)*2)
+	}
+
+	var frames []resolvedFrame
+	inTracer := true
+	for _, p := range pc {
+		for _, frame := range resolveFrames(p) {
+			if inTracer && strings.HasPrefix(frame.function, tracerPackage) {
+				continue
+			}
+			inTracer = false
+			if skip > 0 {
+				skip--
+				continue
+			}
+			frames = append(frames, frame)
+		}
+		if depth > 0 && len(frames) >= depth {
+			frames = frames[:depth]
+			break
+		}
+	}
+
+	s := &events.StackTrace{}
+	for i := len(frames) - 1; i >= 0; i-- {
+		s.Trace = append(s.Trace, &events.StackFrame{
+			Category: frames[i].file,
+			Name:     frames[i].name,
+		})
+	}
+	return s
+}
+
+// resolveFrames symbolises the program counter, using the frames cached for it if it has been seen before
+func resolveFrames(pc uintptr) []resolvedFrame {
+	if cached, ok := frameCache.Load(pc); ok {
+		return cached.([]resolvedFrame)
+	}
+
+	var resolved []resolvedFrame
+	frames := runtime.CallersFrames([]uintptr{pc})
+	for {
+		frame, more := frames.Next()
+		resolved = append(resolved, resolvedFrame{
+			function: frame.Function,
+			file:     frame.File,
+			name:     fmt.Sprintf("%s:%v", frame.Function, frame.Line),
+		})
+		if !more {
+			break
+		}
+	}
+	frameCache.Store(pc, resolved)
+	return resolved
+}
+
+// WithInternedStackFrames has the Tracer write each distinct frame of the stack traces attached to events only once,
+// the events referring to their frames by ID rather than holding their stack traces inline, which makes traces with
+// many stack traces much smaller. Writers that can hold stack frames, such as tio.RingWriter, are given the frames
+// directly, other writers are sent them as metadata events, which tio.TefData's CollectStackFrames moves into the
+// stack frames of the trace once it has been read back.
+func WithInternedStackFrames() TracerOption {
+	return func(t *Tracer) {
+		t.frames = stackframes.New(nil)
+	}
+}
+
+// internStackTraces replaces the inline stack traces of the event with references to interned frames, writing each
+// frame the first time it is seen. It must be called with the stream lock held.
+func (t *Tracer) internStackTraces(e events.Event) error {
+	switch event := e.(type) {
+	case *events.BeginDuration:
+		return t.internInline(&event.EventStackTrace)
+	case *events.EndDuration:
+		return t.internInline(&event.EventStackTrace)
+	case *events.Complete:
+		if err := t.internInline(&event.EventStackTrace); err != nil {
+			return err
+		}
+		if event.EndStackTrace != nil {
+			id, err := t.intern(event.EndStackTrace)
+			if err != nil {
+				return err
+			}
+			event.EndStackFrameID = id
+			event.EndStackTrace = nil
+		}
+	case *events.Instant:
+		return t.internInline(&event.EventStackTrace)
+	case *events.Sample:
+		return t.internInline(&event.EventStackTrace)
+	}
+	return nil
+}
+
+func (t *Tracer) internInline(st *events.EventStackTrace) error {
+	if st.StackTrace == nil {
+		return nil
+	}
+	id, err := t.intern(st.StackTrace)
+	if err != nil {
+		return err
+	}
+	st.StackFrameID = id
+	st.StackTrace = nil
+	return nil
+}
+
+// intern returns the ID of the most recently called frame of the stack trace, writing any frames not seen before
+func (t *Tracer) intern(trace *events.StackTrace) (string, error) {
+	return t.frames.Intern(trace, t.writeStackFrame)
+}
+
+func (t *Tracer) writeStackFrame(id string, frame *events.StackFrame) error {
+	if t.recording != nil {
+		t.recording.SetStackFrame(id, frame)
+	}
+	if w, ok := t.stream.(tio.StackFrameWriter); ok {
+		return w.WriteStackFrame(id, frame)
+	}
+	return t.stream.Write(events.NewStackFrameMetadata(id, frame))
+}
@@ -0,0 +1,70 @@
+package trace_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	tio "github.com/omaskery/teffy/pkg/io"
+	"github.com/omaskery/teffy/pkg/util/trace"
+	"github.com/omaskery/teffy/pkg/util/trace/tracetest"
+)
+
+var _ = Describe("Interned stack frames", func() {
+	instants := func(evs []events.Event) []*events.Instant {
+		var result []*events.Instant
+		for _, e := range evs {
+			if instant, ok := e.(*events.Instant); ok {
+				result = append(result, instant)
+			}
+		}
+		return result
+	}
+
+	It("sends each frame once as metadata to writers that cannot hold stack frames", func() {
+		eventWriter := tracetest.NewRecorder()
+		tracer := trace.NewTracer(eventWriter, trace.WithInternedStackFrames())
+		for i := 0; i < 2; i++ {
+			instantFromHelper(tracer, trace.WithStackTrace(0))
+		}
+
+		data := &tio.TefData{}
+		data.SetEvents(eventWriter.Events())
+		frameEvents := len(eventWriter.Events()) - 2
+		Expect(frameEvents).To(BeNumerically(">", 0))
+
+		emitted := instants(eventWriter.Events())
+		Expect(emitted).To(HaveLen(2))
+		Expect(emitted[0].StackTrace).To(BeNil())
+		Expect(emitted[0].StackFrameID).ToNot(BeEmpty())
+		Expect(emitted[1].StackFrameID).To(Equal(emitted[0].StackFrameID))
+
+		data.CollectStackFrames()
+		Expect(data.Events()).To(HaveLen(2))
+		Expect(data.StackFrames()).To(HaveLen(frameEvents))
+		innermost := data.StackFrames()[emitted[0].StackFrameID]
+		Expect(innermost.Name).To(ContainSubstring("instantFromHelper"))
+		Expect(data.StackFrames()).To(HaveKey(innermost.Parent))
+	})
+
+	It("gives the frames directly to writers that can hold them", func() {
+		ring := tio.NewRingWriter(10)
+		tracer := trace.NewTracer(ring, trace.WithInternedStackFrames())
+		instantFromHelper(tracer, trace.WithStackTrace(0))
+
+		Expect(ring.Events()).To(HaveLen(1))
+		id := instants(ring.Events())[0].StackFrameID
+		Expect(ring.StackFrames()).To(HaveKey(id))
+	})
+
+	It("keeps the frames in recordings", func() {
+		tracer := trace.NewTracer(tracetest.NewRecorder(), trace.WithInternedStackFrames())
+		Expect(tracer.StartRecording()).To(Succeed())
+		instantFromHelper(tracer, trace.WithStackTrace(0))
+		recording, err := tracer.StopRecording()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(recording.Events()).To(HaveLen(1))
+		Expect(recording.StackFrames()).To(HaveKey(instants(recording.Events())[0].StackFrameID))
+	})
+})
@@ -2,12 +2,15 @@
 package trace
 
 import (
+	"errors"
 	"fmt"
+	"github.com/omaskery/teffy/internal/stackframes"
 	"github.com/omaskery/teffy/pkg/events"
 	tio "github.com/omaskery/teffy/pkg/io"
 	"io"
 	"os"
-	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -29,7 +32,8 @@ func WithLogger(logger logr.Logger) TracerOption {
 	}
 }
 
-// WithErrorHandler provides a callback for the tracing library to report errors to
+// WithErrorHandler provides a callback for the tracing library to report errors to, otherwise the first error is
+// returned by Close
 func WithErrorHandler(handler ErrorHandler) TracerOption {
 	return func(t *Tracer) {
 		t.errHandler = handler
@@ -46,9 +50,47 @@ func WithTimestampFn(f TimestampFn) TracerOption {
 // Tracer is an opinionated utility for generating events in Trace Event Format
 type Tracer struct {
 	stream      tio.EventWriter
+	streamLock  sync.Mutex
 	logger      logr.Logger
 	errHandler  ErrorHandler
+	errLock     sync.Mutex
+	firstErr    error
 	timestampFn TimestampFn
+	filters     []EventFilter
+	categories  *CategoryRegistry
+
+	samplingInterval time.Duration
+	sampler          *sampler
+
+	metricsInterval time.Duration
+	metricNames     []string
+	metrics         *metricsCollector
+
+	gcEvents bool
+	gc       *gcWatcher
+
+	processName      string
+	processSortIndex *int64
+	threadNames      map[int64]string
+
+	lastFlowId int64
+
+	traceContext *TraceContext
+
+	recording *tio.TefData
+
+	frames *stackframes.Interner
+
+	openDurations  *openDurations
+	nesting        *durationNesting
+	lastDurationID uint64
+	durationArgs   durationArgs
+
+	// noop is set for Tracers made by Noop, which skip doing anything at all
+	noop bool
+
+	// reuseEvents is set when the stream has finished with each event once it is written, so events can be pooled
+	reuseEvents bool
 }
 
 // NewTracer creates a new Tracer that writes its events to the provided EventWriter
@@ -56,10 +98,24 @@ func NewTracer(stream tio.EventWriter, options ...TracerOption) *Tracer {
 	t := &Tracer{
 		stream:      stream,
 		timestampFn: MicrosecondTimestampFn,
+		categories:  DefaultCategories,
 	}
 	for _, opt := range options {
 		opt(t)
 	}
+	if transient, ok := stream.(tio.TransientWriter); ok {
+		t.reuseEvents = transient.Transient()
+	}
+	t.emitMetadata()
+	if t.samplingInterval > 0 {
+		t.sampler = startSampler(t, t.samplingInterval)
+	}
+	if t.metricsInterval > 0 {
+		t.metrics = startMetricsCollector(t, t.metricsInterval, t.metricNames)
+	}
+	if t.gcEvents {
+		t.gc = startGCWatcher(t)
+	}
 	return t
 }
 
@@ -77,135 +133,219 @@ func TraceToFile(path string, options ...TracerOption) (*Tracer, error) {
 	return TracerToWriter(f, options...), nil
 }
 
-// Close closes the underlying EventWriter that events are written to
+// Close stops any background sampling, metrics collection and GC events and closes the underlying EventWriter that
+// events are written to. If the Tracer has no error handler, the first error it encountered is returned.
 func (t *Tracer) Close() error {
+	if t.sampler != nil {
+		t.sampler.close()
+		t.sampler = nil
+	}
+	if t.metrics != nil {
+		t.metrics.close()
+		t.metrics = nil
+	}
+	if t.gc != nil {
+		t.gc.close()
+		t.gc = nil
+	}
+	t.flushOpenDurations()
+	t.reportUnbalancedDurations()
+
+	t.streamLock.Lock()
+	defer t.streamLock.Unlock()
 	if err := t.stream.Close(); err != nil {
 		return fmt.Errorf("error closing stream writer: %w", err)
 	}
-	return nil
+
+	t.errLock.Lock()
+	defer t.errLock.Unlock()
+	return t.firstErr
 }
 
-// EventOption allows for customising the data in individual events before they are emitted
-type EventOption = func(e events.Event)
+// EventOption allows for customising the data in individual events before they are emitted, returning an error if it
+// cannot be applied to the event, such as ErrUnsupportedOption
+type EventOption = func(e events.Event) error
+
+// ErrUnsupportedOption means that an EventOption was applied to a type of event that it does not support, such as
+// WithEndStackTrace applied to an Instant
+var ErrUnsupportedOption = errors.New("option is not supported by this type of event")
 
 // WithCategories allows adding category strings to an event, this is supported by all events
 func WithCategories(categories ...string) EventOption {
-	return func(e events.Event) {
+	return func(e events.Event) error {
 		e.Core().Categories = categories
+		return nil
+	}
+}
+
+// WithColor has the trace viewer draw the event in one of its reserved colors, such as "thread_state_runnable" or
+// "bad", rather than a color chosen from the event's name, this is supported by all events
+func WithColor(color string) EventOption {
+	return func(e events.Event) error {
+		e.Core().Color = color
+		return nil
 	}
 }
 
 // WithArgs allows for adding arbitrary argument values to an event, note that this is not supported by all events
 func WithArgs(args map[string]interface{}) EventOption {
-	return func(e events.Event) {
+	return func(e events.Event) error {
 		switch event := e.(type) {
 		case events.ArgSetter:
 			event.SetArgs(args)
+			return nil
 		default:
-			panic(fmt.Sprintf("cannot set arguments on this event type: %v", e))
+			return fmt.Errorf("cannot set arguments on %T: %w", e, ErrUnsupportedOption)
 		}
 	}
 }
 
-// WithStackTrace will attach a stack trace to the event, note that this is not supported by all events
-func WithStackTrace() EventOption {
-	return func(e events.Event) {
+// WithTypedArgs allows for giving the args of an event as any Go value that encodes as a JSON object, such as a struct
+// or an events.ArgMarshaler, which is only encoded if the event is written. Like WithArgs this is not supported by all
+// events. Args given by WithArgs, or added by the Tracer, are added to the fields of the value.
+func WithTypedArgs(args interface{}) EventOption {
+	return func(e events.Event) error {
 		switch event := e.(type) {
-		case events.StackTraceSetter:
-			event.SetStackTrace(buildStackTrace())
+		case events.TypedArgSetter:
+			event.SetTypedArgs(args)
+			return nil
 		default:
-			panic(fmt.Sprintf("cannot set stack traces on this event type: %v", e))
+			return fmt.Errorf("cannot set arguments on %T: %w", e, ErrUnsupportedOption)
 		}
 	}
 }
 
-// WithEndStackTrace will attach a stack trace to the event in the "end" stack trace field, only supported by Complete events
-func WithEndStackTrace() EventOption {
-	return func(e events.Event) {
+// WithStackTrace will attach a stack trace to the event, note that this is not supported by all events. The stack
+// starts from the code calling the Tracer and holds at most depth frames, a depth of zero or less capturing the whole
+// stack.
+func WithStackTrace(depth int) EventOption {
+	return func(e events.Event) error {
 		switch event := e.(type) {
-		case events.EndStackTraceSetter:
-			event.SetEndStackTrace(buildStackTrace())
+		case events.StackTraceSetter:
+			event.SetStackTrace(buildStackTrace(0, depth))
+			return nil
 		default:
-			panic(fmt.Sprintf("cannot set end stack traces on this event type: %v", e))
+			return fmt.Errorf("cannot set stack traces on %T: %w", e, ErrUnsupportedOption)
 		}
 	}
 }
 
-func buildStackTrace() *events.StackTrace {
-	s := &events.StackTrace{
-		Trace: nil,
+// WithStackTraceSkip is like WithStackTrace, capturing the whole stack, but leaves out the given number of frames
+// beyond the code calling the Tracer, so that helpers wrapping the Tracer can attach the stack of their own caller
+func WithStackTraceSkip(skip int) EventOption {
+	return func(e events.Event) error {
+		switch event := e.(type) {
+		case events.StackTraceSetter:
+			event.SetStackTrace(buildStackTrace(skip, 0))
+			return nil
+		default:
+			return fmt.Errorf("cannot set stack traces on %T: %w", e, ErrUnsupportedOption)
+		}
 	}
+}
 
-	// TODO: this probably shouldn't skip a hard coded number of stack levels ¯\_(ツ)_/¯
-	stackLevelsToSkip := 5
-
-	pc := make([]uintptr, 10)
-	n := runtime.Callers(stackLevelsToSkip, pc)
-	if n == 0 {
-		return s
+// WithEndStackTrace will attach a stack trace to the event in the "end" stack trace field, only supported by Complete
+// events. Like WithStackTrace the stack starts from the code calling the Tracer, holding at most DefaultStackDepth
+// frames.
+func WithEndStackTrace() EventOption {
+	return func(e events.Event) error {
+		switch event := e.(type) {
+		case events.EndStackTraceSetter:
+			event.SetEndStackTrace(buildStackTrace(0, DefaultStackDepth))
+			return nil
+		default:
+			return fmt.Errorf("cannot set end stack traces on %T: %w", e, ErrUnsupportedOption)
+		}
 	}
-	pc = pc[:n]
-
-	frames := runtime.CallersFrames(pc)
-	for {
-		frame, more := frames.Next()
-
-		s.Trace = append(s.Trace, &events.StackFrame{
-			Category: frame.File,
-			Name:     fmt.Sprintf("%s:%v", frame.Function, frame.Line),
-		})
+}
 
-		if !more {
-			break
+// WithScope sets how widely an instant event is relevant, within its thread, its process or the whole trace, in place
+// of the thread scope given to instants by Instant, only supported by Instant events. A scope that is not one of
+// events.InstantScopeThread, events.InstantScopeProcess or events.InstantScopeGlobal is an error wrapping
+// events.ErrInvalidScope.
+func WithScope(scope events.InstantScope) EventOption {
+	return func(e events.Event) error {
+		instant, ok := e.(*events.Instant)
+		if !ok {
+			return fmt.Errorf("cannot set the scope of %T: %w", e, ErrUnsupportedOption)
 		}
+		if scope == "" || !scope.Valid() {
+			return fmt.Errorf("scope '%s': %w", scope, events.ErrInvalidScope)
+		}
+		instant.Scope = scope
+		return nil
 	}
-
-	return s
 }
 
 // Duration is a handle to a Duration generated by BeginDuration, allowing you to signal the end of a Duration
 type Duration struct {
-	name string
-	pid  int64
-	t    *Tracer
+	name    string
+	t       *Tracer
+	dropped bool
+	// id identifies the duration among those the Tracer keeps track of, such as to hold the args added to it until it
+	// ends
+	id uint64
 }
 
 // BeginDuration generates an event signalling the start of some work on a thread
 func (t *Tracer) BeginDuration(name string, options ...EventOption) Duration {
 	duration := Duration{
 		name: name,
-		pid:  getPid(),
 		t:    t,
 	}
-
-	event := &events.BeginDuration{
-		EventWithArgs: events.EventWithArgs{
-			EventCore: events.EventCore{
-				Name:      name,
-				Timestamp: t.getTimestamp(),
-				ProcessID: &duration.pid,
-			},
-		},
+	if t.noop {
+		duration.dropped = true
+		return duration
+	}
+	duration.id = atomic.AddUint64(&t.lastDurationID, 1)
+
+	timestamp := t.getTimestamp()
+	event := events.AcquireBeginDuration()
+	event.Name = name
+	event.Timestamp = timestamp
+	event.ProcessID = &processID
+	if t.openDurations != nil {
+		duration.dropped = !t.beginOpenDuration(duration.id, event, options...)
+	} else {
+		emitted, reusable := t.writeReusableEvent(event, options...)
+		if reusable {
+			events.Release(event)
+		}
+		duration.dropped = !emitted
+	}
+	if t.nesting != nil && !duration.dropped {
+		t.beginNestedDuration(duration, timestamp)
 	}
-
-	t.writeEvent(event, options...)
 
 	return duration
 }
 
 // End generates an event signalling the end of some work on a thread
 func (d Duration) End(options ...EventOption) {
-	event := &events.EndDuration{
-		EventWithArgs: events.EventWithArgs{
-			EventCore: events.EventCore{
-				Name:      d.name,
-				Timestamp: d.t.getTimestamp(),
-				ProcessID: &d.pid,
-			},
-		},
+	if d.dropped {
+		return
+	}
+	timestamp := d.t.getTimestamp()
+	if args := d.t.durationArgs.take(d.id); args != nil {
+		options = append(options[:len(options):len(options)], withAddedArgs(args))
+	}
+	if d.t.nesting != nil {
+		if timing := d.t.endNestedDuration(d, timestamp); timing != nil {
+			options = append(options[:len(options):len(options)], timing)
+		}
+	}
+	if d.t.openDurations != nil && d.t.endOpenDuration(d.id, timestamp, options...) {
+		return
 	}
 
-	d.t.writeEvent(event, options...)
+	event := events.AcquireEndDuration()
+	event.Name = d.name
+	event.Timestamp = timestamp
+	event.ProcessID = &processID
+	d.t.applyOptions(event, options...)
+	if d.t.emit(event) {
+		events.Release(event)
+	}
 }
 
 // Instant generates an event with no duration signalling that something happened within the scope of the current thread
@@ -215,35 +355,143 @@ func (t *Tracer) Instant(name string, options ...EventOption) {
 
 // ScopedInstant generates an event with no duration signalling that something happened within the specified scope
 func (t *Tracer) ScopedInstant(name string, scope events.InstantScope, options ...EventOption) {
-	pid := getPid()
+	if t.noop {
+		return
+	}
+
+	event := events.AcquireInstant()
+	event.Name = name
+	event.Timestamp = t.getTimestamp()
+	event.ProcessID = &processID
+	event.Scope = scope
+	if _, reusable := t.writeReusableEvent(event, options...); reusable {
+		events.Release(event)
+	}
+}
+
+// complete generates an event for work that began at the given timestamp and has just ended, for instrumentation
+// that only learns what to record about work once it is done
+func (t *Tracer) complete(name string, start int64, options ...EventOption) {
+	if t.noop {
+		return
+	}
 
-	event := &events.Instant{
-		EventCore: events.EventCore{
-			Name:      name,
-			Timestamp: t.getTimestamp(),
-			ProcessID: &pid,
-		},
-		Scope: scope,
+	event := events.AcquireComplete()
+	event.Name = name
+	event.Timestamp = start
+	event.ProcessID = &processID
+	event.Duration = t.getTimestamp() - start
+
+	if _, reusable := t.writeReusableEvent(event, options...); reusable {
+		events.Release(event)
+	}
+}
+
+// writeEvent applies the options to the event and emits it if it passes the Tracer's filters, reporting whether it did
+func (t *Tracer) writeEvent(e events.Event, options ...EventOption) bool {
+	emitted, _ := t.writeReusableEvent(e, options...)
+	return emitted
+}
+
+// writeReusableEvent is writeEvent, also reporting whether the event may be reused, nothing having kept hold of it
+func (t *Tracer) writeReusableEvent(e events.Event, options ...EventOption) (emitted, reusable bool) {
+	if !t.prepareEvent(e, options...) {
+		return false, true
+	}
+	return true, t.emit(e)
+}
+
+// prepareEvent applies the options and trace context to the event, reporting whether it passes the Tracer's filters
+func (t *Tracer) prepareEvent(e events.Event, options ...EventOption) bool {
+	if t.noop {
+		return false
+	}
+	t.applyOptions(e, options...)
+	if t.traceContext != nil {
+		if err := setTraceContext(e, *t.traceContext, false); err != nil {
+			t.handleError("failed to apply trace context", err)
+		}
 	}
 
-	t.writeEvent(event, options...)
+	return t.shouldEmit(e)
 }
 
-func (t *Tracer) writeEvent(e events.Event, options ...EventOption) {
+// applyOptions applies the options to the event, reporting any that cannot be applied to the error handler
+func (t *Tracer) applyOptions(e events.Event, options ...EventOption) {
 	for _, opt := range options {
-		opt(e)
+		if err := opt(e); err != nil {
+			t.handleError("failed to apply event option", err)
+		}
 	}
+}
+
+// WriteEvent writes an event built by the caller, after applying the options to it, returning any error applying the
+// options or writing the event rather than reporting it to the error handler. Events discarded by the Tracer's filters
+// are not written, which is not an error.
+func (t *Tracer) WriteEvent(e events.Event, options ...EventOption) error {
+	if t.noop {
+		return nil
+	}
+	for _, opt := range options {
+		if err := opt(e); err != nil {
+			return fmt.Errorf("failed to apply event option: %w", err)
+		}
+	}
+	if t.traceContext != nil {
+		if err := setTraceContext(e, *t.traceContext, false); err != nil {
+			return fmt.Errorf("failed to apply trace context: %w", err)
+		}
+	}
+
+	if !t.shouldEmit(e) {
+		return nil
+	}
+	_, err := t.write(e)
+	return err
+}
 
-	err := t.stream.Write(e)
+// MustWriteEvent is like WriteEvent, but panics if the event cannot be written
+func (t *Tracer) MustWriteEvent(e events.Event, options ...EventOption) {
+	if err := t.WriteEvent(e, options...); err != nil {
+		panic(err)
+	}
+}
+
+// emit writes the event, reporting any error to the error handler, and reports whether the event may be reused
+func (t *Tracer) emit(e events.Event) bool {
+	reusable, err := t.write(e)
 	if err != nil {
-		t.handleError("failed to write begin duration event", err)
+		t.handleError("failed to emit event", err)
 	}
+	return reusable
+}
+
+// write writes the event to the stream, and any recording, reporting whether the event may be reused afterwards
+func (t *Tracer) write(e events.Event) (reusable bool, err error) {
+	t.streamLock.Lock()
+	defer t.streamLock.Unlock()
+
+	reusable = t.reuseEvents && t.recording == nil
+	if t.frames != nil {
+		if err := t.internStackTraces(e); err != nil {
+			return reusable, fmt.Errorf("failed to write stack frame: %w", err)
+		}
+	}
+	if t.recording != nil {
+		t.recording.Write(e)
+	}
+	if err := t.stream.Write(e); err != nil {
+		return reusable, fmt.Errorf("failed to write event: %w", err)
+	}
+	return reusable, nil
 }
 
 func (t *Tracer) getTimestamp() int64 {
 	return (t.timestampFn)()
 }
 
+// handleError reports the error to the error handler, or if there is none keeps it to be returned by Close if it is
+// the first, so that errors are never lost without trace
 func (t *Tracer) handleError(context string, err error) {
 	if t.logger != nil {
 		t.logger.Error(err, context)
@@ -251,7 +499,13 @@ func (t *Tracer) handleError(context string, err error) {
 	err = fmt.Errorf("%s: %w", context, err)
 	if t.errHandler != nil {
 		(t.errHandler)(err)
+		return
 	}
+	t.errLock.Lock()
+	if t.firstErr == nil {
+		t.firstErr = err
+	}
+	t.errLock.Unlock()
 }
 
 // MicrosecondTimestampFn is the default function used to generate timestamps by a Tracer
@@ -260,6 +514,10 @@ func MicrosecondTimestampFn() int64 {
 	return time.Now().UTC().UnixNano() / nanoToUs
 }
 
+// processID is looked up once, as it never changes and getting it is a system call. The events taken from pools by
+// the Tracer all point to it, rather than each allocating their own process ID, so it must never be modified.
+var processID = int64(os.Getpid())
+
 func getPid() int64 {
-	return int64(os.Getpid())
+	return processID
 }
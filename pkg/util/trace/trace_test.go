@@ -1,33 +1,21 @@
 package trace_test
 
 import (
+	"context"
 	"github.com/omaskery/teffy/pkg/events"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"os"
+	"runtime"
+	"time"
 
 	"github.com/omaskery/teffy/pkg/util/trace"
+	"github.com/omaskery/teffy/pkg/util/trace/tracetest"
 )
 
-type mockEventWriter struct {
-	events []events.Event
-}
-
-func (m *mockEventWriter) Write(e events.Event) error {
-	m.events = append(m.events, e)
-	return nil
-}
-
-func (m *mockEventWriter) Close() error {
-	return nil
-}
-
-func (m *mockEventWriter) lastEvent() events.Event {
-	l := len(m.events)
-	if l < 1 {
-		return nil
-	}
-	return m.events[l-1]
+// instantFromHelper emits an instant from a function of its own, so that it can be found in stack traces
+func instantFromHelper(tracer *trace.Tracer, options ...trace.EventOption) {
+	tracer.Instant("such-instant", options...)
 }
 
 type mockTimestamp struct {
@@ -42,23 +30,23 @@ var _ = Describe("Tracer", func() {
 	var mockTime mockTimestamp
 	var tracer *trace.Tracer
 	var options []trace.TracerOption
-	var eventWriter mockEventWriter
+	var eventWriter *tracetest.Recorder
 	pid := int64(os.Getpid())
 
 	JustBeforeEach(func() {
 		mockTime = mockTimestamp{}
-		eventWriter = mockEventWriter{}
+		eventWriter = tracetest.NewRecorder()
 		baseOptions := []trace.TracerOption{
 			trace.WithTimestampFn(mockTime.getTimestamp),
 		}
 		allOptions := append(baseOptions, options...)
-		tracer = trace.NewTracer(&eventWriter, allOptions...)
+		tracer = trace.NewTracer(eventWriter, allOptions...)
 	})
 
 	When("no events are written", func() {
 		It("is an empty list of events", func() {
 			Expect(tracer.Close()).To(Succeed())
-			Expect(eventWriter.events).To(BeEmpty())
+			Expect(eventWriter.Events()).To(BeEmpty())
 		})
 	})
 
@@ -71,8 +59,8 @@ var _ = Describe("Tracer", func() {
 			})
 
 			It("emits a single BeginDuration event", func() {
-				Expect(eventWriter.events).To(HaveLen(1))
-				Expect(eventWriter.lastEvent()).To(Equal(&events.BeginDuration{
+				Expect(eventWriter.Events()).To(HaveLen(1))
+				Expect(eventWriter.LastEvent()).To(Equal(&events.BeginDuration{
 					EventWithArgs: events.EventWithArgs{
 						EventCore: events.EventCore{
 							Name:      "such-duration",
@@ -92,8 +80,8 @@ var _ = Describe("Tracer", func() {
 				})
 
 				It("emits an EndDuration event", func() {
-					Expect(eventWriter.events).To(HaveLen(2))
-					Expect(eventWriter.lastEvent()).To(Equal(&events.EndDuration{
+					Expect(eventWriter.Events()).To(HaveLen(2))
+					Expect(eventWriter.LastEvent()).To(Equal(&events.EndDuration{
 						EventWithArgs: events.EventWithArgs{
 							EventCore: events.EventCore{
 								Name:      "such-duration",
@@ -110,18 +98,20 @@ var _ = Describe("Tracer", func() {
 			JustBeforeEach(func() {
 				d = tracer.BeginDuration("such-duration",
 					trace.WithCategories("one", "two"),
+					trace.WithColor("thread_state_runnable"),
 					trace.WithArgs(map[string]interface{}{"a": 5}))
 			})
 
 			It("includes the options in the output", func() {
-				Expect(eventWriter.events).To(HaveLen(1))
-				Expect(eventWriter.lastEvent()).To(Equal(&events.BeginDuration{
+				Expect(eventWriter.Events()).To(HaveLen(1))
+				Expect(eventWriter.LastEvent()).To(Equal(&events.BeginDuration{
 					EventWithArgs: events.EventWithArgs{
 						EventCore: events.EventCore{
 							Name:       "such-duration",
 							Timestamp:  0,
 							ProcessID:  &pid,
 							Categories: []string{"one", "two"},
+							Color:      "thread_state_runnable",
 						},
 						Args: map[string]interface{}{
 							"a": 5,
@@ -139,29 +129,327 @@ var _ = Describe("Tracer", func() {
 			})
 
 			It("emits a sensible event", func() {
-				Expect(eventWriter.events).To(HaveLen(1))
-				Expect(eventWriter.lastEvent()).To(Equal(&events.Instant{
-					EventCore: events.EventCore{
+				Expect(eventWriter.Events()).To(HaveLen(1))
+				Expect(eventWriter.LastEvent()).To(Equal(&events.Instant{
+					EventWithArgs: events.EventWithArgs{EventCore: events.EventCore{
 						Name:      "such-instant",
 						Timestamp: 0,
 						ProcessID: &pid,
-					},
+					}},
 					Scope: events.InstantScopeThread,
 				}))
 			})
 		})
 
+		Context("with a scope", func() {
+			JustBeforeEach(func() {
+				tracer.Instant("such-instant", trace.WithScope(events.InstantScopeProcess))
+			})
+
+			It("emits an event of that scope", func() {
+				Expect(eventWriter.LastEvent().(*events.Instant).Scope).To(Equal(events.InstantScopeProcess))
+			})
+		})
+
 		Context("with stack traces", func() {
+			var option trace.EventOption
+
+			BeforeEach(func() {
+				option = trace.WithStackTrace(0)
+			})
+
 			JustBeforeEach(func() {
-				tracer.Instant("such-instant", trace.WithStackTrace())
+				instantFromHelper(tracer, option)
 			})
 
-			It("emits a sensible event", func() {
-				Expect(eventWriter.events).To(HaveLen(1))
-				e, ok := eventWriter.lastEvent().(*events.Instant)
+			stack := func() []*events.StackFrame {
+				Expect(eventWriter.Events()).To(HaveLen(1))
+				e, ok := eventWriter.LastEvent().(*events.Instant)
 				Expect(ok).To(BeTrue())
-				Expect(e.StackTrace.Trace).ToNot(BeEmpty())
+				return e.StackTrace.Trace
+			}
+
+			It("emits a sensible event", func() {
+				Expect(stack()).ToNot(BeEmpty())
 			})
+
+			It("starts from the code calling the tracer, with the outermost caller first", func() {
+				frames := stack()
+				Expect(frames[len(frames)-1].Name).To(ContainSubstring("instantFromHelper"))
+				Expect(frames[len(frames)-2].Name).ToNot(ContainSubstring("instantFromHelper"))
+				for _, frame := range frames {
+					Expect(frame.Name).ToNot(HavePrefix("github.com/omaskery/teffy/pkg/util/trace."))
+				}
+			})
+
+			When("the depth is limited", func() {
+				BeforeEach(func() {
+					option = trace.WithStackTrace(2)
+				})
+
+				It("keeps the innermost frames", func() {
+					frames := stack()
+					Expect(frames).To(HaveLen(2))
+					Expect(frames[1].Name).To(ContainSubstring("instantFromHelper"))
+				})
+			})
+
+			When("frames are skipped", func() {
+				BeforeEach(func() {
+					option = trace.WithStackTraceSkip(1)
+				})
+
+				It("starts from the caller of the skipped frames", func() {
+					frames := stack()
+					Expect(frames).ToNot(BeEmpty())
+					for _, frame := range frames {
+						Expect(frame.Name).ToNot(ContainSubstring("instantFromHelper"))
+					}
+				})
+			})
+		})
+	})
+
+	When("a flow is followed across goroutines", func() {
+		JustBeforeEach(func() {
+			ctx := tracer.StartFlow(context.Background(), "such-flow")
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				tracer.FollowFrom(ctx, "such-flow")
+			}()
+			<-done
+			tracer.FollowFrom(context.Background(), "not-a-flow")
+		})
+
+		It("emits a start and end with matching ids", func() {
+			Expect(eventWriter.Events()).To(HaveLen(2))
+			start, ok := eventWriter.Events()[0].(*events.FlowStart)
+			Expect(ok).To(BeTrue())
+			end, ok := eventWriter.Events()[1].(*events.FlowFinish)
+			Expect(ok).To(BeTrue())
+			Expect(start.Id).ToNot(BeEmpty())
+			Expect(end.Id).To(Equal(start.Id))
+			Expect(end.BindingPoint).To(Equal(events.BindingPointEnclosing))
+		})
+	})
+
+	When("a clock sync is issued", func() {
+		JustBeforeEach(func() {
+			tracer.IssueClockSync("such-sync", func(syncId string) {
+				Expect(syncId).To(Equal("such-sync"))
+				mockTime.time = 10
+			})
+		})
+
+		It("records when it was issued", func() {
+			Expect(eventWriter.Events()).To(HaveLen(1))
+			e, ok := eventWriter.LastEvent().(*events.ClockSync)
+			Expect(ok).To(BeTrue())
+			Expect(e.SyncId).To(Equal("such-sync"))
+			Expect(e.Timestamp).To(BeEquivalentTo(10))
+			Expect(e.IssueTs).ToNot(BeNil())
+			Expect(*e.IssueTs).To(BeEquivalentTo(0))
+		})
+	})
+
+	When("sampling is enabled", func() {
+		BeforeEach(func() {
+			options = []trace.TracerOption{trace.WithSampling(time.Millisecond)}
+		})
+
+		AfterEach(func() {
+			options = nil
+		})
+
+		It("emits sample events for other goroutines until closed", func() {
+			blocked := make(chan struct{})
+			go func() {
+				<-blocked
+			}()
+			time.Sleep(20 * time.Millisecond)
+			Expect(tracer.Close()).To(Succeed())
+			close(blocked)
+
+			Expect(eventWriter.Events()).ToNot(BeEmpty())
+			count := len(eventWriter.Events())
+			for _, e := range eventWriter.Events() {
+				sample, ok := e.(*events.Sample)
+				Expect(ok).To(BeTrue())
+				Expect(sample.ProcessID).To(Equal(&pid))
+				Expect(sample.ThreadID).ToNot(BeNil())
+				Expect(sample.StackTrace.Trace).ToNot(BeEmpty())
+			}
+
+			time.Sleep(5 * time.Millisecond)
+			Expect(eventWriter.Events()).To(HaveLen(count))
+		})
+	})
+
+	When("runtime metrics are enabled", func() {
+		BeforeEach(func() {
+			options = []trace.TracerOption{
+				trace.WithRuntimeMetrics(time.Millisecond),
+				trace.WithRuntimeMetricSamples("/gc/heap/goal:bytes", "/gc/pauses:seconds", "/not/a/metric:bytes"),
+			}
+		})
+
+		AfterEach(func() {
+			options = nil
+		})
+
+		It("emits counter events until closed", func() {
+			runtime.GC()
+			time.Sleep(20 * time.Millisecond)
+			Expect(tracer.Close()).To(Succeed())
+
+			latest := map[string]*events.Counter{}
+			for _, e := range eventWriter.Events() {
+				counter, ok := e.(*events.Counter)
+				Expect(ok).To(BeTrue())
+				Expect(counter.Categories).To(Equal([]string{trace.RuntimeMetricsCategory}))
+				Expect(counter.ProcessID).To(Equal(&pid))
+				latest[counter.Name] = counter
+			}
+			Expect(latest).To(HaveLen(5))
+			Expect(latest["heap"].Values["objects"]).To(BeNumerically(">", 0))
+			Expect(latest["goroutines"].Values["count"]).To(BeNumerically(">=", 1))
+			Expect(latest["gc"].Values["cycles"]).To(BeNumerically(">=", 1))
+			Expect(latest["gc pause"].Values).To(HaveKey("ms"))
+			Expect(latest["/gc/heap/goal:bytes"].Values["value"]).To(BeNumerically(">", 0))
+
+			count := len(eventWriter.Events())
+			time.Sleep(5 * time.Millisecond)
+			Expect(eventWriter.Events()).To(HaveLen(count))
+		})
+	})
+
+	When("GC events are enabled", func() {
+		BeforeEach(func() {
+			options = []trace.TracerOption{trace.WithGCEvents()}
+		})
+
+		AfterEach(func() {
+			options = nil
+		})
+
+		It("emits an async span for the pause of each GC cycle until closed", func() {
+			Expect(tracer.StartRecording()).To(Succeed())
+			gcPauses := func() int {
+				runtime.GC()
+				data, err := tracer.Recording()
+				Expect(err).To(Succeed())
+				return len(data.Events())
+			}
+			Eventually(gcPauses).Should(BeNumerically(">=", 4))
+
+			data, err := tracer.StopRecording()
+			Expect(err).To(Succeed())
+			begin, ok := data.Events()[0].(*events.AsyncBegin)
+			Expect(ok).To(BeTrue())
+			end, ok := data.Events()[1].(*events.AsyncEnd)
+			Expect(ok).To(BeTrue())
+			Expect(begin.Name).To(Equal("GC pause"))
+			Expect(begin.Categories).To(Equal([]string{trace.GCCategory}))
+			Expect(begin.ProcessID).To(Equal(&pid))
+			Expect(end.Id).To(Equal(begin.Id))
+			Expect(end.Timestamp).To(BeNumerically(">=", begin.Timestamp))
+			Expect(data.Events()[2].(*events.AsyncBegin).Id).ToNot(Equal(begin.Id))
+
+			Expect(tracer.Close()).To(Succeed())
+			count := len(eventWriter.Events())
+			runtime.GC()
+			time.Sleep(5 * time.Millisecond)
+			Expect(eventWriter.Events()).To(HaveLen(count))
+		})
+	})
+
+	When("process and thread metadata is provided", func() {
+		BeforeEach(func() {
+			options = []trace.TracerOption{
+				trace.WithProcessName("server"),
+				trace.WithProcessSortIndex(-1),
+				trace.WithThreadNames(map[int64]string{2: "worker", 1: "main"}),
+			}
+		})
+
+		AfterEach(func() {
+			options = nil
+		})
+
+		It("emits metadata events when the tracer is created", func() {
+			one, two := int64(1), int64(2)
+			metadataCore := func(kind events.MetadataKind, tid *int64) events.EventCore {
+				return events.EventCore{Name: string(kind), ProcessID: &pid, ThreadID: tid}
+			}
+			Expect(eventWriter.Events()).To(Equal([]events.Event{
+				&events.MetadataProcessName{
+					EventCore:   metadataCore(events.MetadataKindProcessName, nil),
+					ProcessName: "server",
+				},
+				&events.MetadataProcessSortIndex{
+					EventCore: metadataCore(events.MetadataKindProcessSortIndex, nil),
+					SortIndex: -1,
+				},
+				&events.MetadataThreadName{
+					EventCore:  metadataCore(events.MetadataKindThreadName, &one),
+					ThreadName: "main",
+				},
+				&events.MetadataThreadName{
+					EventCore:  metadataCore(events.MetadataKindThreadName, &two),
+					ThreadName: "worker",
+				},
+			}))
+		})
+	})
+
+	When("events are filtered", func() {
+		BeforeEach(func() {
+			options = []trace.TracerOption{trace.WithEventFilter(func(e events.Event) bool {
+				return e.Core().Name != "boring"
+			})}
+		})
+
+		AfterEach(func() {
+			options = nil
+		})
+
+		It("only emits events passing the filter", func() {
+			tracer.Instant("boring")
+			tracer.Instant("interesting")
+			Expect(eventWriter.Events()).To(HaveLen(1))
+			Expect(eventWriter.LastEvent().Core().Name).To(Equal("interesting"))
+		})
+
+		It("discards the end of durations whose beginning was discarded", func() {
+			tracer.BeginDuration("boring").End()
+			tracer.BeginDuration("interesting").End()
+			Expect(eventWriter.Events()).To(HaveLen(2))
+			Expect(eventWriter.Events()[0]).To(BeAssignableToTypeOf(&events.BeginDuration{}))
+			Expect(eventWriter.Events()[1]).To(BeAssignableToTypeOf(&events.EndDuration{}))
+			Expect(eventWriter.LastEvent().Core().Name).To(Equal("interesting"))
+		})
+	})
+
+	When("categories are sampled", func() {
+		BeforeEach(func() {
+			options = []trace.TracerOption{
+				trace.WithSampleRate("net.*", 0),
+				trace.WithSampleRate("disk", 1),
+			}
+		})
+
+		AfterEach(func() {
+			options = nil
+		})
+
+		It("applies the rate of matching categories", func() {
+			tracer.Instant("read", trace.WithCategories("net.read"))
+			tracer.Instant("write", trace.WithCategories("disk"))
+			tracer.Instant("other", trace.WithCategories("cpu"))
+			Expect(eventWriter.Events()).To(HaveLen(2))
+			Expect(eventWriter.Events()[0].Core().Name).To(Equal("write"))
+			Expect(eventWriter.Events()[1].Core().Name).To(Equal("other"))
 		})
 	})
 })
@@ -0,0 +1,144 @@
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/omaskery/teffy/pkg/events"
+)
+
+const (
+	// ArgTraceID is the arg that WithTraceContext stores the W3C trace ID of an event under
+	ArgTraceID = "trace_id"
+	// ArgSpanID is the arg that WithTraceContext stores the W3C span ID of an event under
+	ArgSpanID = "span_id"
+)
+
+// ErrInvalidTraceparent means that a traceparent header is not in the form given by the W3C Trace Context
+// specification
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// TraceContext identifies the distributed trace, and the span within it, that work is being done for
+type TraceContext struct {
+	// TraceID is the 32 hex digit ID of the distributed trace
+	TraceID string
+	// SpanID is the 16 hex digit ID of the span within the distributed trace
+	SpanID string
+}
+
+// traceContextKey is the context key that ContextWithTraceContext stores a TraceContext under
+type traceContextKey struct{}
+
+// ParseTraceparent parses the value of a W3C traceparent header, such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", into the trace context it carries
+func ParseTraceparent(header string) (TraceContext, error) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return TraceContext{}, fmt.Errorf("'%s': %w", header, ErrInvalidTraceparent)
+	}
+	tc := TraceContext{TraceID: parts[1], SpanID: parts[2]}
+	if !isHex(tc.TraceID, 32) || !isHex(tc.SpanID, 16) || !isHex(parts[3], 2) ||
+		strings.Trim(tc.TraceID, "0") == "" || strings.Trim(tc.SpanID, "0") == "" {
+		return TraceContext{}, fmt.Errorf("'%s': %w", header, ErrInvalidTraceparent)
+	}
+	return tc, nil
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// ContextWithTraceContext returns a context carrying the trace context, for WithTraceContextFrom to attach to events
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext returns the trace context carried by the context, if any
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// WithTraceContext attaches the IDs of the trace context to the event as its trace_id and span_id args, alongside any
+// other args. Events without args are not supported.
+func WithTraceContext(tc TraceContext) EventOption {
+	return func(e events.Event) error {
+		return setTraceContext(e, tc, true)
+	}
+}
+
+// WithTraceContextFrom attaches the trace context carried by the context to the event as WithTraceContext does, if
+// the context carries one
+func WithTraceContextFrom(ctx context.Context) EventOption {
+	return func(e events.Event) error {
+		if tc, ok := TraceContextFromContext(ctx); ok {
+			return setTraceContext(e, tc, true)
+		}
+		return nil
+	}
+}
+
+// WithProcessTraceContext attaches the trace context to every event emitted by the Tracer that does not already have
+// one, for processes that do all their work as part of one distributed trace, such as a batch job started with a
+// TRACEPARENT environment variable
+func WithProcessTraceContext(tc TraceContext) TracerOption {
+	return func(t *Tracer) {
+		t.traceContext = &tc
+	}
+}
+
+// setTraceContext stores the IDs of the trace context in the event's args, replacing any it already has only if
+// asked to. Only events with args can hold a trace context, explicitly setting one on other events panics as WithArgs
+// does.
+func setTraceContext(e events.Event, tc TraceContext, replace bool) error {
+	setter, ok := e.(events.ArgSetter)
+	if !ok {
+		if replace {
+			return fmt.Errorf("cannot set a trace context on %T: %w", e, ErrUnsupportedOption)
+		}
+		return nil
+	}
+
+	args := eventArgs(e)
+	if _, ok := args[ArgTraceID]; ok && !replace {
+		return nil
+	}
+
+	merged := make(map[string]interface{}, len(args)+2)
+	for key, value := range args {
+		merged[key] = value
+	}
+	merged[ArgTraceID] = tc.TraceID
+	merged[ArgSpanID] = tc.SpanID
+
+	setter.SetArgs(merged)
+	return nil
+}
+
+// eventArgs returns the args of the event, if it has any
+func eventArgs(e events.Event) map[string]interface{} {
+	if getter, ok := e.(events.ArgGetter); ok {
+		return getter.GetArgs()
+	}
+	return nil
+}
+
+// GroupByTraceID groups the events by the W3C trace ID in their trace_id arg, keeping the order of the events within
+// each group, such that the events of each distributed trace can be looked at together. Events without a trace ID
+// are left out.
+func GroupByTraceID(evs []events.Event) map[string][]events.Event {
+	groups := map[string][]events.Event{}
+	for _, e := range evs {
+		if id, ok := eventArgs(e)[ArgTraceID].(string); ok && id != "" {
+			groups[id] = append(groups[id], e)
+		}
+	}
+	return groups
+}
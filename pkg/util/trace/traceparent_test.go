@@ -0,0 +1,94 @@
+package trace_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/util/trace"
+	"github.com/omaskery/teffy/pkg/util/trace/tracetest"
+)
+
+var _ = Describe("Trace context", func() {
+	tc := trace.TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}
+	other := trace.TraceContext{TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "b7ad6b7169203331"}
+
+	var writer *tracetest.Recorder
+	var tracer *trace.Tracer
+
+	BeforeEach(func() {
+		writer = tracetest.NewRecorder()
+		tracer = trace.NewTracer(writer, trace.WithTimestampFn(func() int64 { return 0 }))
+	})
+
+	It("parses traceparent headers", func() {
+		parsed, err := trace.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		Expect(err).To(Succeed())
+		Expect(parsed).To(Equal(tc))
+
+		parsed, err = trace.ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future")
+		Expect(err).To(Succeed())
+		Expect(parsed).To(Equal(tc))
+	})
+
+	DescribeTable("rejects invalid traceparent headers",
+		func(header string) {
+			_, err := trace.ParseTraceparent(header)
+			Expect(err).To(MatchError(trace.ErrInvalidTraceparent))
+		},
+		Entry("empty", ""),
+		Entry("too few parts", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"),
+		Entry("extra parts for version 00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x"),
+		Entry("forbidden version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+		Entry("short trace id", "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01"),
+		Entry("upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"),
+		Entry("zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"),
+		Entry("zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"),
+	)
+
+	It("attaches trace contexts as args alongside other args", func() {
+		tracer.BeginDuration("work", trace.WithArgs(map[string]interface{}{"n": 1}), trace.WithTraceContext(tc))
+
+		e := writer.LastEvent().(*events.BeginDuration)
+		Expect(e.Args).To(Equal(map[string]interface{}{"n": 1, trace.ArgTraceID: tc.TraceID, trace.ArgSpanID: tc.SpanID}))
+	})
+
+	It("attaches trace contexts to instants", func() {
+		ctx := trace.ContextWithTraceContext(context.Background(), tc)
+		tracer.Instant("mark", trace.WithTraceContextFrom(ctx))
+
+		e := writer.LastEvent().(*events.Instant)
+		Expect(e.Args).To(HaveKeyWithValue(trace.ArgTraceID, tc.TraceID))
+	})
+
+	It("ignores contexts without a trace context", func() {
+		tracer.BeginDuration("work", trace.WithTraceContextFrom(context.Background()))
+		Expect(writer.LastEvent().(*events.BeginDuration).Args).To(BeNil())
+	})
+
+	It("attaches the process trace context to events without one", func() {
+		tracer = trace.NewTracer(writer, trace.WithTimestampFn(func() int64 { return 0 }), trace.WithProcessTraceContext(tc))
+		tracer.BeginDuration("default")
+		tracer.BeginDuration("explicit", trace.WithTraceContext(other))
+		tracer.Instant("instant")
+
+		Expect(writer.Events()[0].(*events.BeginDuration).Args).To(HaveKeyWithValue(trace.ArgTraceID, tc.TraceID))
+		Expect(writer.Events()[1].(*events.BeginDuration).Args).To(HaveKeyWithValue(trace.ArgTraceID, other.TraceID))
+		Expect(writer.Events()[2].(*events.Instant).Args).To(HaveKeyWithValue(trace.ArgTraceID, tc.TraceID))
+	})
+
+	It("groups events by trace ID", func() {
+		tracer.BeginDuration("a", trace.WithTraceContext(tc))
+		tracer.Instant("b", trace.WithTraceContext(other))
+		tracer.BeginDuration("c")
+		tracer.BeginDuration("d", trace.WithTraceContext(tc))
+
+		groups := trace.GroupByTraceID(writer.Events())
+		Expect(groups).To(HaveLen(2))
+		Expect(groups[tc.TraceID]).To(Equal([]events.Event{writer.Events()[0], writer.Events()[3]}))
+		Expect(groups[other.TraceID]).To(Equal([]events.Event{writer.Events()[1]}))
+	})
+})
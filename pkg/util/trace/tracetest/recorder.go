@@ -0,0 +1,95 @@
+// tracetest provides utilities for testing code instrumented with a trace.Tracer
+package tracetest
+
+import (
+	"sync"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/util/trace"
+)
+
+// Recorder is an EventWriter that keeps every event written to it, so that tests can make assertions about the
+// events emitted by the code they exercise. Recorder is safe for concurrent use.
+type Recorder struct {
+	lock   sync.Mutex
+	events []events.Event
+	closed bool
+}
+
+// NewRecorder creates an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// NewTracer creates a Tracer writing to a new Recorder, returning both
+func NewTracer(options ...trace.TracerOption) (*trace.Tracer, *Recorder) {
+	r := NewRecorder()
+	return trace.NewTracer(r, options...), r
+}
+
+// Write keeps the event
+func (r *Recorder) Write(e events.Event) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.events = append(r.events, e)
+	return nil
+}
+
+// Close marks the Recorder as closed, the events remaining available
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.closed = true
+	return nil
+}
+
+// Events returns a copy of the events written so far, in the order they were written
+func (r *Recorder) Events() []events.Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]events.Event(nil), r.events...)
+}
+
+// LastEvent returns the most recently written event, or nil if none have been written
+func (r *Recorder) LastEvent() events.Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.events) < 1 {
+		return nil
+	}
+	return r.events[len(r.events)-1]
+}
+
+// Named returns the events written so far with the given name
+func (r *Recorder) Named(name string) []events.Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var named []events.Event
+	for _, e := range r.events {
+		if e.Core().Name == name {
+			named = append(named, e)
+		}
+	}
+	return named
+}
+
+// Closed reports whether the Recorder has been closed
+func (r *Recorder) Closed() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.closed
+}
+
+// Reset forgets the events written so far
+func (r *Recorder) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.events = nil
+}
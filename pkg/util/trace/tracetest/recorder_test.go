@@ -0,0 +1,33 @@
+package tracetest_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/util/trace/tracetest"
+)
+
+var _ = Describe("Recorder", func() {
+	It("records the events emitted by a tracer", func() {
+		tracer, recorder := tracetest.NewTracer()
+		tracer.BeginDuration("work").End()
+		tracer.Instant("tick")
+
+		Expect(recorder.Events()).To(HaveLen(3))
+		Expect(recorder.Named("work")).To(HaveLen(2))
+		Expect(recorder.LastEvent()).To(BeAssignableToTypeOf(&events.Instant{}))
+		Expect(recorder.Closed()).To(BeFalse())
+
+		Expect(tracer.Close()).To(Succeed())
+		Expect(recorder.Closed()).To(BeTrue())
+	})
+
+	It("forgets the events when reset", func() {
+		recorder := tracetest.NewRecorder()
+		Expect(recorder.LastEvent()).To(BeNil())
+		Expect(recorder.Write(&events.Instant{})).To(Succeed())
+		recorder.Reset()
+		Expect(recorder.Events()).To(BeEmpty())
+	})
+})
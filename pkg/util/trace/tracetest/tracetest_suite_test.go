@@ -0,0 +1,13 @@
+package tracetest_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestTracetest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tracetest Suite")
+}
@@ -0,0 +1,129 @@
+package trace
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+// HTTPCategory is the category of the events emitted for HTTP requests by Transport
+const HTTPCategory = "http"
+
+// Transport is an http.RoundTripper that records each request it makes as a Complete event, from the request being
+// sent until its response headers arrive, along with nested Complete events for the phases of the request: "dns"
+// for resolving the host, "connect" for dialing it, "tls" for the TLS handshake and "ttfb" for waiting between the
+// request being written and the first byte of the response. Phases that a request skips, such as when it reuses a
+// connection, are not recorded.
+//
+//	client := &http.Client{Transport: &trace.Transport{Tracer: t}}
+type Transport struct {
+	// Tracer records the events of requests
+	Tracer *Tracer
+	// Base makes the requests, http.DefaultTransport is used if it is nil
+	Base http.RoundTripper
+}
+
+// RoundTrip makes the request using the Base RoundTripper, recording its events
+func (tr *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := tr.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	p := &requestPhases{t: tr.Tracer}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), p.clientTrace()))
+
+	start := tr.Tracer.getTimestamp()
+	resp, err := base.RoundTrip(req)
+
+	u := *req.URL
+	u.User = nil
+	args := map[string]interface{}{
+		"method": req.Method,
+		"url":    u.String(),
+	}
+	if err != nil {
+		args["error"] = err.Error()
+	} else {
+		args["status"] = resp.StatusCode
+	}
+	tr.Tracer.complete("HTTP "+req.Method, start, WithCategories(HTTPCategory), WithArgs(args))
+
+	return resp, err
+}
+
+// requestPhases tracks when the phases of a request started, the hooks of a ClientTrace may be called concurrently
+type requestPhases struct {
+	t     *Tracer
+	lock  sync.Mutex
+	dns   int64
+	dials map[string]int64
+	tls   int64
+	wrote int64
+}
+
+func (p *requestPhases) begin(ts *int64) {
+	now := p.t.getTimestamp()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	*ts = now
+}
+
+func (p *requestPhases) end(name string, ts *int64, err error, args map[string]interface{}) {
+	p.lock.Lock()
+	start := *ts
+	*ts = 0
+	p.lock.Unlock()
+	if start == 0 {
+		return
+	}
+
+	if err != nil {
+		args["error"] = err.Error()
+	}
+	options := []EventOption{WithCategories(HTTPCategory)}
+	if len(args) > 0 {
+		options = append(options, WithArgs(args))
+	}
+	p.t.complete(name, start, options...)
+}
+
+func (p *requestPhases) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			p.begin(&p.dns)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			p.end("dns", &p.dns, info.Err, map[string]interface{}{})
+		},
+		ConnectStart: func(network, addr string) {
+			now := p.t.getTimestamp()
+			p.lock.Lock()
+			defer p.lock.Unlock()
+			if p.dials == nil {
+				p.dials = map[string]int64{}
+			}
+			p.dials[network+" "+addr] = now
+		},
+		ConnectDone: func(network, addr string, err error) {
+			p.lock.Lock()
+			start := p.dials[network+" "+addr]
+			delete(p.dials, network+" "+addr)
+			p.lock.Unlock()
+			p.end("connect", &start, err, map[string]interface{}{"addr": addr})
+		},
+		TLSHandshakeStart: func() {
+			p.begin(&p.tls)
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			p.end("tls", &p.tls, err, map[string]interface{}{})
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			p.begin(&p.wrote)
+		},
+		GotFirstResponseByte: func() {
+			p.end("ttfb", &p.wrote, nil, map[string]interface{}{})
+		},
+	}
+}
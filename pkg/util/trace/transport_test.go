@@ -0,0 +1,100 @@
+package trace_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/teffy/pkg/events"
+	"github.com/omaskery/teffy/pkg/util/trace"
+	"github.com/omaskery/teffy/pkg/util/trace/tracetest"
+)
+
+var _ = Describe("Transport", func() {
+	var tracer *trace.Tracer
+	var server *httptest.Server
+	var client *http.Client
+
+	BeforeEach(func() {
+		var now int64
+		tracer = trace.NewTracer(tracetest.NewRecorder(), trace.WithTimestampFn(func() int64 {
+			return atomic.AddInt64(&now, 1)
+		}))
+		Expect(tracer.StartRecording()).To(Succeed())
+
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}))
+		client = &http.Client{Transport: &trace.Transport{Tracer: tracer, Base: server.Client().Transport}}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(tracer.Close()).To(Succeed())
+	})
+
+	request := func(path string) []*events.Complete {
+		resp, err := client.Get(server.URL + path)
+		Expect(err).To(Succeed())
+		_, _ = ioutil.ReadAll(resp.Body)
+		Expect(resp.Body.Close()).To(Succeed())
+
+		data, err := tracer.StopRecording()
+		Expect(err).To(Succeed())
+		Expect(tracer.StartRecording()).To(Succeed())
+
+		var result []*events.Complete
+		for _, e := range data.Events() {
+			c, ok := e.(*events.Complete)
+			Expect(ok).To(BeTrue())
+			Expect(c.Categories).To(Equal([]string{trace.HTTPCategory}))
+			result = append(result, c)
+		}
+		return result
+	}
+
+	names := func(completes []*events.Complete) []string {
+		var result []string
+		for _, c := range completes {
+			result = append(result, c.Name)
+		}
+		return result
+	}
+
+	It("records requests with the phases nested within them", func() {
+		first := request("/teapot?q=1")
+		Expect(names(first)).To(Equal([]string{"connect", "tls", "ttfb", "HTTP GET"}))
+
+		req := first[len(first)-1]
+		Expect(req.Args).To(Equal(map[string]interface{}{
+			"method": "GET",
+			"url":    server.URL + "/teapot?q=1",
+			"status": http.StatusTeapot,
+		}))
+		for _, phase := range first[:len(first)-1] {
+			Expect(phase.Timestamp).To(BeNumerically(">=", req.Timestamp))
+			Expect(phase.Timestamp + phase.Duration).To(BeNumerically("<=", req.Timestamp+req.Duration))
+		}
+		Expect(first[0].Args).To(HaveKeyWithValue("addr", strings.TrimPrefix(server.URL, "https://")))
+
+		Expect(names(request("/again"))).To(Equal([]string{"ttfb", "HTTP GET"}))
+	})
+
+	It("records the errors of failed requests", func() {
+		server.Close()
+		_, err := client.Get(server.URL)
+		Expect(err).To(HaveOccurred())
+
+		data, err := tracer.StopRecording()
+		Expect(err).To(Succeed())
+		last := data.Events()[len(data.Events())-1].(*events.Complete)
+		Expect(last.Name).To(Equal("HTTP GET"))
+		Expect(last.Args).To(HaveKey("error"))
+		Expect(last.Args).ToNot(HaveKey("status"))
+	})
+})